@@ -0,0 +1,198 @@
+// Package access allows or denies clients by static rules of users, source addresses and hours like hosts.allow.
+//
+// Each line of a rules file is a rule of the action, users, sources and optionally hours:
+//
+//	# ACTION USERS SOURCES [HOURS]
+//	deny  root         *
+//	allow deploy       10.0.0.0/8,192.168.0.0/16
+//	allow john,alice   *                         Mon-Fri 09:00-18:00
+//	deny  *            *
+//
+// USERS are comma-separated patterns with "*" and "?", SOURCES are comma-separated IP addresses or CIDRs, "*" matches any,
+// and HOURS are in the form of notify.ParseHours. The first matching rule decides, and clients matching no rules are allowed.
+package access
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/John-Ao/go-sshd/notify"
+)
+
+// Rule allows or denies clients of users from sources in hours.
+type Rule struct {
+	Allow bool
+	// Users are patterns of user names, or nil for any users
+	Users []string
+	// Sources are networks of client addresses, or nil for any sources
+	Sources []netip.Prefix
+	// Hours are when the rule applies, or nil for any time
+	Hours *notify.Hours
+	// Line is the line number in the file
+	Line int
+}
+
+// Rules are rules checked in order.
+type Rules []Rule
+
+// Load reads the rules file of path.
+func Load(path string) (Rules, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := Parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Parse parses rules in the form of the package document. Empty lines and lines starting with "#" are ignored.
+func Parse(r io.Reader) (Rules, error) {
+	var rules Rules
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		rule, err := parseRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		rule.Line = line
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func parseRule(fields []string) (Rule, error) {
+	var rule Rule
+	if len(fields) < 3 {
+		return rule, errors.New("expected ACTION USERS SOURCES [HOURS]")
+	}
+	switch fields[0] {
+	case "allow":
+		rule.Allow = true
+	case "deny":
+	default:
+		return rule, fmt.Errorf("unknown action: %s", fields[0])
+	}
+	if fields[1] != "*" {
+		for _, pattern := range strings.Split(fields[1], ",") {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return rule, fmt.Errorf("invalid user pattern: %q", pattern)
+			}
+			rule.Users = append(rule.Users, pattern)
+		}
+	}
+	if fields[2] != "*" {
+		for _, source := range strings.Split(fields[2], ",") {
+			prefix, err := netip.ParsePrefix(source)
+			if err != nil {
+				addr, err := netip.ParseAddr(source)
+				if err != nil {
+					return rule, fmt.Errorf("invalid source: %q", source)
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			rule.Sources = append(rule.Sources, prefix.Masked())
+		}
+	}
+	if len(fields) > 3 {
+		hours, err := notify.ParseHours(strings.Join(fields[3:], " "))
+		if err != nil {
+			return rule, err
+		}
+		rule.Hours = hours
+	}
+	return rule, nil
+}
+
+// matchUser reports whether the rule applies to userName
+func (r *Rule) matchUser(userName string) bool {
+	if r.Users == nil {
+		return true
+	}
+	for _, pattern := range r.Users {
+		if ok, _ := path.Match(pattern, userName); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSource reports whether the rule applies to addr at t
+func (r *Rule) matchSource(addr netip.Addr, t time.Time) bool {
+	if r.Hours != nil && !r.Hours.Contains(t) {
+		return false
+	}
+	if r.Sources == nil {
+		return true
+	}
+	for _, prefix := range r.Sources {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrIP returns the IP address of addr, which is invalid for addresses other than TCP and UDP
+func addrIP(addr net.Addr) netip.Addr {
+	var ip netip.Addr
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, _ = netip.AddrFromSlice(addr.IP)
+	case *net.UDPAddr:
+		ip, _ = netip.AddrFromSlice(addr.IP)
+	}
+	return ip.Unmap()
+}
+
+// Check returns an error if userName from addr is denied at t.
+func (rules Rules) Check(userName string, addr net.Addr, t time.Time) error {
+	ip := addrIP(addr)
+	for _, rule := range rules {
+		if !rule.matchSource(ip, t) || !rule.matchUser(userName) {
+			continue
+		}
+		if rule.Allow {
+			return nil
+		}
+		return fmt.Errorf("denied by rule at line %d", rule.Line)
+	}
+	return nil
+}
+
+// CheckAddr returns an error if all users from addr are denied at t, e.g. before the handshake.
+func (rules Rules) CheckAddr(addr net.Addr, t time.Time) error {
+	ip := addrIP(addr)
+	for _, rule := range rules {
+		if !rule.matchSource(ip, t) {
+			continue
+		}
+		if rule.Allow {
+			return nil
+		}
+		// Other users are decided by later rules
+		if rule.Users != nil {
+			continue
+		}
+		return fmt.Errorf("denied by rule at line %d", rule.Line)
+	}
+	return nil
+}
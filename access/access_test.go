@@ -0,0 +1,64 @@
+package access
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	rules, err := Parse(strings.NewReader(`
+# ACTION USERS SOURCES [HOURS]
+deny  root         *
+allow deploy       10.0.0.0/8,192.168.1.10
+allow john,a?ice   *                         Mon-Fri 09:00-18:00
+deny  *            203.0.113.0/24
+deny  deploy,john  *
+`))
+	require.NoError(t, err)
+	require.Len(t, rules, 5)
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000} }
+	monday := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	sunday := time.Date(2024, 1, 7, 10, 0, 0, 0, time.Local)
+
+	assert.EqualError(t, rules.Check("root", addr("10.0.0.1"), monday), "denied by rule at line 3")
+	assert.NoError(t, rules.Check("deploy", addr("10.1.2.3"), monday))
+	assert.NoError(t, rules.Check("deploy", addr("::ffff:192.168.1.10"), monday))
+	assert.EqualError(t, rules.Check("deploy", addr("192.168.1.11"), monday), "denied by rule at line 7")
+	assert.NoError(t, rules.Check("alice", addr("203.0.113.1"), monday))
+	assert.EqualError(t, rules.Check("alice", addr("203.0.113.1"), sunday), "denied by rule at line 6")
+	assert.EqualError(t, rules.Check("john", addr("198.51.100.1"), sunday), "denied by rule at line 7")
+	// Users matching no rules are allowed
+	assert.NoError(t, rules.Check("bob", addr("198.51.100.1"), sunday))
+
+	// Connections are denied only if all users are denied
+	assert.NoError(t, rules.CheckAddr(addr("203.0.113.1"), monday))
+	assert.EqualError(t, rules.CheckAddr(addr("203.0.113.1"), sunday), "denied by rule at line 6")
+	assert.NoError(t, rules.CheckAddr(addr("198.51.100.1"), sunday))
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, invalid := range []string{
+		"allow *",
+		"permit * *",
+		"allow * 10.0.0.300",
+		"allow [ *",
+		"allow * * Mon-Fri",
+	} {
+		_, err := Parse(strings.NewReader(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.rules")
+	require.NoError(t, os.WriteFile(path, []byte("allow * *\nbad\n"), 0600))
+	_, err := Load(path)
+	assert.EqualError(t, err, path+": line 2: expected ACTION USERS SOURCES [HOURS]")
+}
@@ -0,0 +1,136 @@
+// Package accounting persists the traffic of users across restarts and upgrades, e.g. to meter usage of tunnel hosting.
+package accounting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// Usage is the JSON form of server.Traffic.
+type Usage struct {
+	BytesReceived        uint64 `json:"bytes_received"`
+	BytesSent            uint64 `json:"bytes_sent"`
+	ForwardBytesReceived uint64 `json:"forward_bytes_received"`
+	ForwardBytesSent     uint64 `json:"forward_bytes_sent"`
+}
+
+// NewUsage returns the JSON form of traffic.
+func NewUsage(traffic server.Traffic) Usage {
+	return Usage(traffic)
+}
+
+// Traffic returns u as server.Traffic.
+func (u Usage) Traffic() server.Traffic {
+	return server.Traffic(u)
+}
+
+// file is the content of the file of Store
+type file struct {
+	Updated time.Time        `json:"updated"`
+	Users   map[string]Usage `json:"users"`
+}
+
+// Store accumulates the traffic of users in a JSON file.
+// Processes saving to the same file, e.g. the old one draining connections after an upgrade and the new one, add their traffic to each other's.
+type Store struct {
+	path string
+	mu   sync.Mutex
+	// saved is the traffic of this process already added to the file
+	saved map[string]server.Traffic
+}
+
+// Open returns the store of the file of path, which is created by Save if it doesn't exist.
+func Open(path string) (*Store, error) {
+	if _, err := Load(path); err != nil {
+		return nil, err
+	}
+	return &Store{path: path, saved: map[string]server.Traffic{}}, nil
+}
+
+// Load returns the traffic by user saved in the file of path. It returns no traffic if the file doesn't exist.
+func Load(path string) (map[string]server.Traffic, error) {
+	traffic := map[string]server.Traffic{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return traffic, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for user, usage := range f.Users {
+		traffic[user] = usage.Traffic()
+	}
+	return traffic, nil
+}
+
+// Save adds traffic, the totals of this process by user, not saved yet to the file.
+func (s *Store) Save(traffic map[string]server.Traffic) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := os.OpenFile(s.path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := lockFile(lock); err != nil {
+		return err
+	}
+	defer unlockFile(lock)
+	saved, err := Load(s.path)
+	if err != nil {
+		return err
+	}
+	f := file{Updated: time.Now().UTC(), Users: map[string]Usage{}}
+	for user, total := range server.AddTraffic(saved, s.unsaved(traffic)) {
+		f.Users[user] = NewUsage(total)
+	}
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The file is replaced at once not to be broken by crashes
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for user, t := range traffic {
+		s.saved[user] = t
+	}
+	return nil
+}
+
+// Totals returns the traffic by user saved in the file plus traffic, the totals of this process, not saved yet.
+func (s *Store) Totals(traffic map[string]server.Traffic) (map[string]server.Traffic, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	saved, err := Load(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return server.AddTraffic(saved, s.unsaved(traffic)), nil
+}
+
+// unsaved returns traffic minus the saved one
+func (s *Store) unsaved(traffic map[string]server.Traffic) map[string]server.Traffic {
+	unsaved := map[string]server.Traffic{}
+	for user, t := range traffic {
+		if d := t.Sub(s.saved[user]); d != (server.Traffic{}) {
+			unsaved[user] = d
+		}
+	}
+	return unsaved
+}
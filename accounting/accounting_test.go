@@ -0,0 +1,50 @@
+package accounting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	old, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, old.Save(map[string]server.Traffic{"john": {BytesReceived: 10, BytesSent: 20}}))
+	require.NoError(t, old.Save(map[string]server.Traffic{"john": {BytesReceived: 15, BytesSent: 20}}))
+
+	// Another process adds its traffic to the old one's
+	current, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, current.Save(map[string]server.Traffic{"john": {BytesReceived: 1}, "jane": {ForwardBytesSent: 3, BytesSent: 3}}))
+	require.NoError(t, old.Save(map[string]server.Traffic{"john": {BytesReceived: 17, BytesSent: 20}}))
+	traffic, err := Load(path)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]server.Traffic{
+		"john": {BytesReceived: 18, BytesSent: 20},
+		"jane": {BytesSent: 3, ForwardBytesSent: 3},
+	}, traffic)
+
+	totals, err := current.Totals(map[string]server.Traffic{"john": {BytesReceived: 5}, "jane": {ForwardBytesSent: 3, BytesSent: 3}})
+	require.NoError(t, err)
+	assert.Equal(t, server.Traffic{BytesReceived: 22, BytesSent: 20}, totals["john"])
+	assert.Equal(t, server.Traffic{BytesSent: 3, ForwardBytesSent: 3}, totals["jane"])
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"forward_bytes_sent": 3`)
+}
+
+func TestOpenInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0600))
+	_, err := Open(path)
+	assert.ErrorContains(t, err, "failed to parse")
+	traffic, err := Load(filepath.Join(t.TempDir(), "none.json"))
+	require.NoError(t, err)
+	assert.Empty(t, traffic)
+}
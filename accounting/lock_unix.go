@@ -0,0 +1,17 @@
+//go:build !windows
+
+package accounting
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile locks f exclusively against other processes such as the old process draining connections after an upgrade
+func lockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+}
+
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
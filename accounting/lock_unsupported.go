@@ -0,0 +1,14 @@
+//go:build windows
+
+package accounting
+
+import "os"
+
+// lockFile does nothing because processes are not upgraded on Windows
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
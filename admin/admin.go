@@ -0,0 +1,378 @@
+// Package admin serves an HTTP API and a gRPC service authenticated by a bearer token to inspect and manage servers,
+// e.g. for web dashboards and orchestration systems. Connections are of the control package.
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/John-Ao/go-sshd/control"
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// API is the admin API. Endpoints of nil fields respond 501 Not Implemented.
+//
+//	GET    /v1/connections        lists connections with their sessions and forwards
+//	DELETE /v1/connections/ID     closes the connection, the session or the forwarding of ID
+//	GET    /v1/events             streams events as server-sent events, filtered by type=TYPE if any
+//	GET    /v1/monitor/ID         streams the live output of the session of ID, notifying the client if notify=true
+//	GET    /v1/stats              shows the statistics
+//	GET    /v1/traffic            lists the traffic by user
+//	GET    /v1/bans               lists banned IP addresses and networks
+//	POST   /v1/bans               bans {"address": "192.0.2.1" or "192.0.2.0/24", "duration": "1h" (default: permanent)}
+//	DELETE /v1/bans/ADDRESS       lifts the ban of ADDRESS
+//	POST   /v1/reload             reloads the settings
+//	POST   /v1/wall               writes {"message": "...", "users": ["john"] (default: all)} to the terminals of sessions
+//	GET    /debug/pprof/          serves profiles of net/http/pprof if Pprof is not empty
+type API struct {
+	// Token is the bearer token required in the Authorization header
+	Token []byte
+	// Servers returns the servers to list and close connections
+	Servers func() []*server.Server
+	// Stats returns the statistics
+	Stats func() server.Stats
+	// Traffic returns the traffic by user
+	Traffic func() (map[string]server.Traffic, error)
+	// Bans are managed by the API. Connections from banned addresses are closed when banned.
+	Bans *Bans
+	// Reload reloads the settings
+	Reload func() error
+	// Events are streamed by GET /v1/events and the Events RPC of gRPC
+	Events *Events
+	// Pprof is PprofLoopback or PprofAny to serve profiles. They are not served if empty.
+	Pprof string
+}
+
+// Stats is the JSON form of server.Stats.
+type Stats struct {
+	ActiveConnections    int64             `json:"active_connections"`
+	ActiveSessions       int64             `json:"active_sessions"`
+	ActiveForwards       int64             `json:"active_forwards"`
+	ActiveGoroutines     int64             `json:"active_goroutines"`
+	ActiveProcesses      int64             `json:"active_processes"`
+	Connections          uint64            `json:"connections"`
+	Sessions             uint64            `json:"sessions"`
+	AuthFailures         uint64            `json:"auth_failures"`
+	AuthAttempts         []AuthAttempts    `json:"auth_attempts"`
+	ShedConnections      uint64            `json:"shed_connections"`
+	ShedChannels         uint64            `json:"shed_channels"`
+	RateLimitedChannels  uint64            `json:"rate_limited_channels"`
+	StalledRelays        uint64            `json:"stalled_relays"`
+	BytesReceived        uint64            `json:"bytes_received"`
+	BytesSent            uint64            `json:"bytes_sent"`
+	ForwardBytesReceived uint64            `json:"forward_bytes_received"`
+	ForwardBytesSent     uint64            `json:"forward_bytes_sent"`
+	SftpOperations       map[string]uint64 `json:"sftp_operations"`
+	Handshakes           uint64            `json:"handshakes"`
+	HandshakeSecondsSum  float64           `json:"handshake_seconds_sum"`
+}
+
+// AuthAttempts is the number of authentication attempts by method and result.
+type AuthAttempts struct {
+	Method  string `json:"method"`
+	Success bool   `json:"success"`
+	Count   uint64 `json:"count"`
+}
+
+// NewStats returns the JSON form of stats.
+func NewStats(stats server.Stats) Stats {
+	s := Stats{
+		ActiveConnections:    stats.ActiveConnections,
+		ActiveSessions:       stats.ActiveSessions,
+		ActiveForwards:       stats.ActiveForwards,
+		ActiveGoroutines:     stats.ActiveGoroutines,
+		ActiveProcesses:      stats.ActiveProcesses,
+		Connections:          stats.Connections,
+		Sessions:             stats.Sessions,
+		AuthFailures:         stats.AuthFailures,
+		AuthAttempts:         []AuthAttempts{},
+		ShedConnections:      stats.ShedConnections,
+		ShedChannels:         stats.ShedChannels,
+		RateLimitedChannels:  stats.RateLimitedChannels,
+		StalledRelays:        stats.StalledRelays,
+		BytesReceived:        stats.BytesReceived,
+		BytesSent:            stats.BytesSent,
+		ForwardBytesReceived: stats.ForwardBytesReceived,
+		ForwardBytesSent:     stats.ForwardBytesSent,
+		SftpOperations:       map[string]uint64{},
+		Handshakes:           stats.HandshakeDurations.Count,
+		HandshakeSecondsSum:  stats.HandshakeDurations.Sum.Seconds(),
+	}
+	for result, count := range stats.AuthAttempts {
+		s.AuthAttempts = append(s.AuthAttempts, AuthAttempts{Method: result.Method, Success: result.Success, Count: count})
+	}
+	sort.Slice(s.AuthAttempts, func(i, j int) bool {
+		a, b := s.AuthAttempts[i], s.AuthAttempts[j]
+		return a.Method < b.Method || a.Method == b.Method && !a.Success && b.Success
+	})
+	for operation, count := range stats.SftpOperations {
+		s.SftpOperations[operation] = count
+	}
+	return s
+}
+
+// UserTraffic is the JSON form of server.Traffic of a user.
+type UserTraffic struct {
+	User                 string `json:"user"`
+	BytesReceived        uint64 `json:"bytes_received"`
+	BytesSent            uint64 `json:"bytes_sent"`
+	ForwardBytesReceived uint64 `json:"forward_bytes_received"`
+	ForwardBytesSent     uint64 `json:"forward_bytes_sent"`
+}
+
+// NewUserTraffic returns the JSON form of traffic by user in order of users.
+func NewUserTraffic(traffic map[string]server.Traffic) []UserTraffic {
+	users := []UserTraffic{}
+	for user, t := range traffic {
+		users = append(users, UserTraffic{
+			User:                 user,
+			BytesReceived:        t.BytesReceived,
+			BytesSent:            t.BytesSent,
+			ForwardBytesReceived: t.ForwardBytesReceived,
+			ForwardBytesSent:     t.ForwardBytesSent,
+		})
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].User < users[j].User
+	})
+	return users
+}
+
+// banRequest is the body of POST /v1/bans
+type banRequest struct {
+	Address string `json:"address"`
+	// Duration is in the form of time.ParseDuration
+	Duration string `json:"duration"`
+}
+
+// wallRequest is the body of POST /v1/wall
+type wallRequest struct {
+	Message string   `json:"message"`
+	Users   []string `json:"users"`
+}
+
+// Handler returns the HTTP handler of the API.
+func (a *API) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/connections", a.connections)
+	mux.HandleFunc("/v1/connections/", a.connection)
+	mux.HandleFunc("/v1/events", a.streamEvents)
+	mux.HandleFunc("/v1/monitor/", a.monitor)
+	mux.HandleFunc("/v1/stats", a.stats)
+	mux.HandleFunc("/v1/traffic", a.traffic)
+	mux.HandleFunc("/v1/bans", a.bans)
+	mux.HandleFunc("/v1/bans/", a.ban)
+	mux.HandleFunc("/v1/reload", a.reload)
+	mux.HandleFunc("/v1/wall", a.wall)
+	if a.Pprof != "" {
+		mux.Handle("/debug/pprof/", a.pprofHandler())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.authenticated(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (a *API) authenticated(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && len(a.Token) != 0 && subtle.ConstantTimeCompare([]byte(token), a.Token) == 1
+}
+
+func (a *API) connections(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) || !implemented(w, a.Servers != nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, control.ListConnections(a.Servers()))
+}
+
+func (a *API) connection(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) || !implemented(w, a.Servers != nil) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/connections/")
+	if !a.close(id) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", id))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// close closes the connection, the session or the forwarding of id. It returns false if not found.
+func (a *API) close(id string) bool {
+	for _, s := range a.Servers() {
+		if id != "" && s.Close(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *API) monitor(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) || !implemented(w, a.Servers != nil) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/monitor/")
+	output, stop, ok := control.Monitor(a.Servers(), id, r.URL.Query().Get("notify") == "true")
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", id))
+		return
+	}
+	defer stop()
+	control.StreamOutput(w, r, output)
+}
+
+func (a *API) stats(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) || !implemented(w, a.Stats != nil) {
+		return
+	}
+	writeJSON(w, http.StatusOK, NewStats(a.Stats()))
+}
+
+func (a *API) traffic(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) || !implemented(w, a.Traffic != nil) {
+		return
+	}
+	traffic, err := a.Traffic()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, NewUserTraffic(traffic))
+}
+
+func (a *API) bans(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet, http.MethodPost) || !implemented(w, a.Bans != nil) {
+		return
+	}
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, a.Bans.List())
+		return
+	}
+	var req banRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		duration, err = time.ParseDuration(req.Duration)
+		if err != nil || duration <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid duration: %s", req.Duration))
+			return
+		}
+	}
+	ban, err := a.addBan(req.Address, duration)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+// addBan bans address for duration, or permanently if duration is 0, and closes its connections
+func (a *API) addBan(address string, duration time.Duration) (Ban, error) {
+	prefix, err := ParseAddress(address)
+	if err != nil {
+		return Ban{}, err
+	}
+	ban := Ban{Address: formatPrefix(prefix)}
+	var until time.Time
+	if duration != 0 {
+		until = time.Now().Add(duration)
+		ban.Until = &until
+	}
+	a.Bans.Add(prefix, until)
+	if a.Servers != nil {
+		for _, s := range a.Servers() {
+			for _, conn := range s.Connections() {
+				if a.Bans.Banned(conn.RemoteAddr) {
+					s.Close(conn.ID)
+				}
+			}
+		}
+	}
+	return ban, nil
+}
+
+func (a *API) ban(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodDelete) || !implemented(w, a.Bans != nil) {
+		return
+	}
+	address := strings.TrimPrefix(r.URL.Path, "/v1/bans/")
+	prefix, err := ParseAddress(address)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !a.Bans.Remove(prefix) {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("not found: %s", address))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) reload(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !implemented(w, a.Reload != nil) {
+		return
+	}
+	if err := a.Reload(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *API) wall(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) || !implemented(w, a.Servers != nil) {
+		return
+	}
+	var req wallRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid body: %v", err))
+		return
+	}
+	if req.Message == "" {
+		writeError(w, http.StatusBadRequest, "empty message")
+		return
+	}
+	writeJSON(w, http.StatusOK, control.WallResponse{Sessions: control.Wall(a.Servers(), req.Message, req.Users)})
+}
+
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	return false
+}
+
+func implemented(w http.ResponseWriter, ok bool) bool {
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "not implemented")
+	}
+	return ok
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes {"error": message}
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
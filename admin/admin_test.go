@@ -0,0 +1,178 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/control"
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/sshdtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// do requests the API with the token and decodes the JSON response into v if not nil
+func do(t *testing.T, handler http.Handler, method, target, body string, v any) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if v != nil {
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), v), recorder.Body.String())
+	}
+	return recorder
+}
+
+func TestAPI(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}}
+	s.Config.AuthLogCallback = s.AuthLog
+	sshServer := sshdtest.NewServer(t, s)
+	// Banned by the IP address
+	sshServer.Listener.RemoteAddr = &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 50022}
+	sshClient := sshServer.Client(t, &ssh.ClientConfig{User: "john"})
+	reloads := 0
+	api := &API{
+		Token:   []byte("secret"),
+		Servers: func() []*server.Server { return []*server.Server{s} },
+		Stats:   s.Stats,
+		Traffic: func() (map[string]server.Traffic, error) {
+			return map[string]server.Traffic{"john": {BytesReceived: 2, BytesSent: 3}, "alice": {BytesSent: 1}}, nil
+		},
+		Bans: &Bans{},
+		Reload: func() error {
+			reloads++
+			if reloads > 1 {
+				return errors.New("invalid config")
+			}
+			return nil
+		},
+	}
+	handler := api.Handler()
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic c2VjcmV0"} {
+		req := httptest.NewRequest("GET", "/v1/stats", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusUnauthorized, recorder.Code, auth)
+	}
+
+	var connections []control.Connection
+	assert.Equal(t, http.StatusOK, do(t, handler, "GET", "/v1/connections", "", &connections).Code)
+	require.Len(t, connections, 1)
+	assert.Equal(t, "john", connections[0].User)
+
+	var stats Stats
+	assert.Equal(t, http.StatusOK, do(t, handler, "GET", "/v1/stats", "", &stats).Code)
+	assert.Equal(t, int64(1), stats.ActiveConnections)
+	assert.Equal(t, []AuthAttempts{{Method: "none", Success: true, Count: 1}}, stats.AuthAttempts)
+
+	var traffic []UserTraffic
+	assert.Equal(t, http.StatusOK, do(t, handler, "GET", "/v1/traffic", "", &traffic).Code)
+	assert.Equal(t, []UserTraffic{{User: "alice", BytesSent: 1}, {User: "john", BytesReceived: 2, BytesSent: 3}}, traffic)
+
+	var wall control.WallResponse
+	assert.Equal(t, http.StatusOK, do(t, handler, "POST", "/v1/wall", `{"message":"maintenance","users":["john"]}`, &wall).Code)
+	assert.Equal(t, control.WallResponse{Sessions: []string{}}, wall)
+	assert.Equal(t, http.StatusBadRequest, do(t, handler, "POST", "/v1/wall", `{"message":""}`, nil).Code)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, do(t, handler, "POST", "/v1/stats", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(t, handler, "DELETE", "/v1/connections/unknown", "", nil).Code)
+	assert.Equal(t, http.StatusNoContent, do(t, handler, "POST", "/v1/reload", "", nil).Code)
+	var errBody map[string]string
+	assert.Equal(t, http.StatusInternalServerError, do(t, handler, "POST", "/v1/reload", "", &errBody).Code)
+	assert.Equal(t, map[string]string{"error": "invalid config"}, errBody)
+
+	// Banning closes connections from the address
+	var ban Ban
+	assert.Equal(t, http.StatusCreated, do(t, handler, "POST", "/v1/bans", `{"address":"127.0.0.0/8","duration":"1h"}`, &ban).Code)
+	assert.Equal(t, "127.0.0.0/8", ban.Address)
+	require.NotNil(t, ban.Until)
+	assert.Error(t, sshClient.Wait())
+	assert.True(t, api.Bans.Banned(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}))
+	var permanent Ban
+	assert.Equal(t, http.StatusCreated, do(t, handler, "POST", "/v1/bans", `{"address":"2001:db8::1"}`, &permanent).Code)
+	assert.Nil(t, permanent.Until)
+	assert.Equal(t, http.StatusBadRequest, do(t, handler, "POST", "/v1/bans", `{"address":"example.com"}`, nil).Code)
+	assert.Equal(t, http.StatusBadRequest, do(t, handler, "POST", "/v1/bans", `{"address":"192.0.2.1","duration":"-1h"}`, nil).Code)
+	var bans []Ban
+	do(t, handler, "GET", "/v1/bans", "", &bans)
+	require.Len(t, bans, 2)
+	assert.Equal(t, "127.0.0.0/8", bans[0].Address)
+	assert.Equal(t, "2001:db8::1", bans[1].Address)
+
+	assert.Equal(t, http.StatusNoContent, do(t, handler, "DELETE", "/v1/bans/127.0.0.0/8", "", nil).Code)
+	assert.Equal(t, http.StatusNotFound, do(t, handler, "DELETE", "/v1/bans/127.0.0.0/8", "", nil).Code)
+	assert.False(t, api.Bans.Banned(&net.TCPAddr{IP: net.ParseIP("127.0.0.1")}))
+}
+
+func TestCloseConnection(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}}
+	sshClient := sshdtest.NewClient(t, s, "john")
+	handler := (&API{Token: []byte("secret"), Servers: func() []*server.Server { return []*server.Server{s} }}).Handler()
+	id := s.Connections()[0].ID
+	assert.Equal(t, http.StatusNoContent, do(t, handler, "DELETE", "/v1/connections/"+id, "", nil).Code)
+	assert.Error(t, sshClient.Wait())
+	// Not configured
+	recorder := do(t, handler, "GET", "/v1/bans", "", nil)
+	assert.Equal(t, http.StatusNotImplemented, recorder.Code)
+	b, _ := io.ReadAll(recorder.Body)
+	assert.JSONEq(t, `{"error":"not implemented"}`, string(b))
+}
+
+func TestPprof(t *testing.T) {
+	request := func(api *API, remoteAddr string) int {
+		req := httptest.NewRequest("GET", "/debug/pprof/goroutine?debug=1", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Bearer secret")
+		recorder := httptest.NewRecorder()
+		api.Handler().ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	assert.Equal(t, http.StatusNotFound, request(&API{Token: []byte("secret")}, "127.0.0.1:1234"))
+	loopback := &API{Token: []byte("secret"), Pprof: PprofLoopback}
+	assert.Equal(t, http.StatusOK, request(loopback, "127.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, request(loopback, "[::1]:1234"))
+	assert.Equal(t, http.StatusForbidden, request(loopback, "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusOK, request(&API{Token: []byte("secret"), Pprof: PprofAny}, "192.0.2.1:1234"))
+}
+
+func TestStreamEvents(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}}
+	events := &Events{}
+	s.OnEvent = func(event server.Event) { events.Publish("main", event) }
+	httpServer := httptest.NewServer((&API{Token: []byte("secret"), Events: events}).Handler())
+	defer httpServer.Close()
+	req, err := http.NewRequest("GET", httpServer.URL+"/v1/events?type=connection-opened", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	// Subscribed when headers are received
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+	sshdtest.NewClient(t, s, "john")
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	require.NoError(t, err)
+	data, ok := strings.CutPrefix(line, "data: ")
+	require.True(t, ok, line)
+	var event EventJSON
+	require.NoError(t, json.Unmarshal([]byte(data), &event))
+	assert.Equal(t, server.EventConnectionOpened, event.Type)
+	assert.Equal(t, "main", event.Server)
+	assert.Equal(t, "john", event.User)
+	assert.Nil(t, event.ExitCode)
+}
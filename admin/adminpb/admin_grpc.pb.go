@@ -0,0 +1,558 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: admin.proto
+
+package adminpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Admin_ListConnections_FullMethodName = "/gosshd.admin.v1.Admin/ListConnections"
+	Admin_Close_FullMethodName           = "/gosshd.admin.v1.Admin/Close"
+	Admin_GetStats_FullMethodName        = "/gosshd.admin.v1.Admin/GetStats"
+	Admin_ListTraffic_FullMethodName     = "/gosshd.admin.v1.Admin/ListTraffic"
+	Admin_ListBans_FullMethodName        = "/gosshd.admin.v1.Admin/ListBans"
+	Admin_AddBan_FullMethodName          = "/gosshd.admin.v1.Admin/AddBan"
+	Admin_RemoveBan_FullMethodName       = "/gosshd.admin.v1.Admin/RemoveBan"
+	Admin_Reload_FullMethodName          = "/gosshd.admin.v1.Admin/Reload"
+	Admin_Events_FullMethodName          = "/gosshd.admin.v1.Admin/Events"
+	Admin_Monitor_FullMethodName         = "/gosshd.admin.v1.Admin/Monitor"
+	Admin_Wall_FullMethodName            = "/gosshd.admin.v1.Admin/Wall"
+)
+
+// AdminClient is the client API for Admin service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AdminClient interface {
+	// ListConnections lists connections with their sessions and forwards.
+	ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error)
+	// Close closes the connection, the session or the forwarding of the ID. It fails with NOT_FOUND for unknown IDs.
+	Close(ctx context.Context, in *CloseRequest, opts ...grpc.CallOption) (*CloseResponse, error)
+	// GetStats returns the statistics of all servers.
+	GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error)
+	// ListTraffic lists the traffic by user, including the saved one with --traffic-file.
+	ListTraffic(ctx context.Context, in *ListTrafficRequest, opts ...grpc.CallOption) (*ListTrafficResponse, error)
+	// ListBans lists banned IP addresses and networks.
+	ListBans(ctx context.Context, in *ListBansRequest, opts ...grpc.CallOption) (*ListBansResponse, error)
+	// AddBan bans an IP address or network and closes its connections.
+	AddBan(ctx context.Context, in *AddBanRequest, opts ...grpc.CallOption) (*Ban, error)
+	// RemoveBan lifts a ban. It fails with NOT_FOUND if the address is not banned.
+	RemoveBan(ctx context.Context, in *RemoveBanRequest, opts ...grpc.CallOption) (*RemoveBanResponse, error)
+	// Reload reloads the settings.
+	Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadResponse, error)
+	// Events streams events of all servers from now on. Events are dropped for slow receivers.
+	Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Admin_EventsClient, error)
+	// Monitor streams the live output of a session until it ends. Output is dropped for slow receivers.
+	// It fails with NOT_FOUND for unknown IDs.
+	Monitor(ctx context.Context, in *MonitorRequest, opts ...grpc.CallOption) (Admin_MonitorClient, error)
+	// Wall writes a message to the terminals of sessions, e.g. to warn of maintenance. It fails with INVALID_ARGUMENT for empty messages.
+	Wall(ctx context.Context, in *WallRequest, opts ...grpc.CallOption) (*WallResponse, error)
+}
+
+type adminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAdminClient(cc grpc.ClientConnInterface) AdminClient {
+	return &adminClient{cc}
+}
+
+func (c *adminClient) ListConnections(ctx context.Context, in *ListConnectionsRequest, opts ...grpc.CallOption) (*ListConnectionsResponse, error) {
+	out := new(ListConnectionsResponse)
+	err := c.cc.Invoke(ctx, Admin_ListConnections_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Close(ctx context.Context, in *CloseRequest, opts ...grpc.CallOption) (*CloseResponse, error) {
+	out := new(CloseResponse)
+	err := c.cc.Invoke(ctx, Admin_Close_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) GetStats(ctx context.Context, in *GetStatsRequest, opts ...grpc.CallOption) (*Stats, error) {
+	out := new(Stats)
+	err := c.cc.Invoke(ctx, Admin_GetStats_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListTraffic(ctx context.Context, in *ListTrafficRequest, opts ...grpc.CallOption) (*ListTrafficResponse, error) {
+	out := new(ListTrafficResponse)
+	err := c.cc.Invoke(ctx, Admin_ListTraffic_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) ListBans(ctx context.Context, in *ListBansRequest, opts ...grpc.CallOption) (*ListBansResponse, error) {
+	out := new(ListBansResponse)
+	err := c.cc.Invoke(ctx, Admin_ListBans_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) AddBan(ctx context.Context, in *AddBanRequest, opts ...grpc.CallOption) (*Ban, error) {
+	out := new(Ban)
+	err := c.cc.Invoke(ctx, Admin_AddBan_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) RemoveBan(ctx context.Context, in *RemoveBanRequest, opts ...grpc.CallOption) (*RemoveBanResponse, error) {
+	out := new(RemoveBanResponse)
+	err := c.cc.Invoke(ctx, Admin_RemoveBan_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Reload(ctx context.Context, in *ReloadRequest, opts ...grpc.CallOption) (*ReloadResponse, error) {
+	out := new(ReloadResponse)
+	err := c.cc.Invoke(ctx, Admin_Reload_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *adminClient) Events(ctx context.Context, in *EventsRequest, opts ...grpc.CallOption) (Admin_EventsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[0], Admin_Events_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminEventsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_EventsClient interface {
+	Recv() (*Event, error)
+	grpc.ClientStream
+}
+
+type adminEventsClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminEventsClient) Recv() (*Event, error) {
+	m := new(Event)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) Monitor(ctx context.Context, in *MonitorRequest, opts ...grpc.CallOption) (Admin_MonitorClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Admin_ServiceDesc.Streams[1], Admin_Monitor_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &adminMonitorClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Admin_MonitorClient interface {
+	Recv() (*MonitorOutput, error)
+	grpc.ClientStream
+}
+
+type adminMonitorClient struct {
+	grpc.ClientStream
+}
+
+func (x *adminMonitorClient) Recv() (*MonitorOutput, error) {
+	m := new(MonitorOutput)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *adminClient) Wall(ctx context.Context, in *WallRequest, opts ...grpc.CallOption) (*WallResponse, error) {
+	out := new(WallResponse)
+	err := c.cc.Invoke(ctx, Admin_Wall_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AdminServer is the server API for Admin service.
+// All implementations must embed UnimplementedAdminServer
+// for forward compatibility
+type AdminServer interface {
+	// ListConnections lists connections with their sessions and forwards.
+	ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error)
+	// Close closes the connection, the session or the forwarding of the ID. It fails with NOT_FOUND for unknown IDs.
+	Close(context.Context, *CloseRequest) (*CloseResponse, error)
+	// GetStats returns the statistics of all servers.
+	GetStats(context.Context, *GetStatsRequest) (*Stats, error)
+	// ListTraffic lists the traffic by user, including the saved one with --traffic-file.
+	ListTraffic(context.Context, *ListTrafficRequest) (*ListTrafficResponse, error)
+	// ListBans lists banned IP addresses and networks.
+	ListBans(context.Context, *ListBansRequest) (*ListBansResponse, error)
+	// AddBan bans an IP address or network and closes its connections.
+	AddBan(context.Context, *AddBanRequest) (*Ban, error)
+	// RemoveBan lifts a ban. It fails with NOT_FOUND if the address is not banned.
+	RemoveBan(context.Context, *RemoveBanRequest) (*RemoveBanResponse, error)
+	// Reload reloads the settings.
+	Reload(context.Context, *ReloadRequest) (*ReloadResponse, error)
+	// Events streams events of all servers from now on. Events are dropped for slow receivers.
+	Events(*EventsRequest, Admin_EventsServer) error
+	// Monitor streams the live output of a session until it ends. Output is dropped for slow receivers.
+	// It fails with NOT_FOUND for unknown IDs.
+	Monitor(*MonitorRequest, Admin_MonitorServer) error
+	// Wall writes a message to the terminals of sessions, e.g. to warn of maintenance. It fails with INVALID_ARGUMENT for empty messages.
+	Wall(context.Context, *WallRequest) (*WallResponse, error)
+	mustEmbedUnimplementedAdminServer()
+}
+
+// UnimplementedAdminServer must be embedded to have forward compatible implementations.
+type UnimplementedAdminServer struct {
+}
+
+func (UnimplementedAdminServer) ListConnections(context.Context, *ListConnectionsRequest) (*ListConnectionsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListConnections not implemented")
+}
+func (UnimplementedAdminServer) Close(context.Context, *CloseRequest) (*CloseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Close not implemented")
+}
+func (UnimplementedAdminServer) GetStats(context.Context, *GetStatsRequest) (*Stats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
+func (UnimplementedAdminServer) ListTraffic(context.Context, *ListTrafficRequest) (*ListTrafficResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListTraffic not implemented")
+}
+func (UnimplementedAdminServer) ListBans(context.Context, *ListBansRequest) (*ListBansResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListBans not implemented")
+}
+func (UnimplementedAdminServer) AddBan(context.Context, *AddBanRequest) (*Ban, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddBan not implemented")
+}
+func (UnimplementedAdminServer) RemoveBan(context.Context, *RemoveBanRequest) (*RemoveBanResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemoveBan not implemented")
+}
+func (UnimplementedAdminServer) Reload(context.Context, *ReloadRequest) (*ReloadResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reload not implemented")
+}
+func (UnimplementedAdminServer) Events(*EventsRequest, Admin_EventsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Events not implemented")
+}
+func (UnimplementedAdminServer) Monitor(*MonitorRequest, Admin_MonitorServer) error {
+	return status.Errorf(codes.Unimplemented, "method Monitor not implemented")
+}
+func (UnimplementedAdminServer) Wall(context.Context, *WallRequest) (*WallResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Wall not implemented")
+}
+func (UnimplementedAdminServer) mustEmbedUnimplementedAdminServer() {}
+
+// UnsafeAdminServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AdminServer will
+// result in compilation errors.
+type UnsafeAdminServer interface {
+	mustEmbedUnimplementedAdminServer()
+}
+
+func RegisterAdminServer(s grpc.ServiceRegistrar, srv AdminServer) {
+	s.RegisterService(&Admin_ServiceDesc, srv)
+}
+
+func _Admin_ListConnections_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListConnectionsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListConnections(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListConnections_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListConnections(ctx, req.(*ListConnectionsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Close_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CloseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Close(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Close_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Close(ctx, req.(*CloseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_GetStats_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).GetStats(ctx, req.(*GetStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListTraffic_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListTrafficRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListTraffic(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListTraffic_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListTraffic(ctx, req.(*ListTrafficRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_ListBans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListBansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).ListBans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_ListBans_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).ListBans(ctx, req.(*ListBansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_AddBan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddBanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).AddBan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_AddBan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).AddBan(ctx, req.(*AddBanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_RemoveBan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveBanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).RemoveBan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_RemoveBan_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).RemoveBan(ctx, req.(*RemoveBanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Reload_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReloadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Reload(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Reload_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Reload(ctx, req.(*ReloadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Admin_Events_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EventsRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).Events(m, &adminEventsServer{stream})
+}
+
+type Admin_EventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+type adminEventsServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminEventsServer) Send(m *Event) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_Monitor_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(MonitorRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(AdminServer).Monitor(m, &adminMonitorServer{stream})
+}
+
+type Admin_MonitorServer interface {
+	Send(*MonitorOutput) error
+	grpc.ServerStream
+}
+
+type adminMonitorServer struct {
+	grpc.ServerStream
+}
+
+func (x *adminMonitorServer) Send(m *MonitorOutput) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Admin_Wall_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(WallRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AdminServer).Wall(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Admin_Wall_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AdminServer).Wall(ctx, req.(*WallRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Admin_ServiceDesc is the grpc.ServiceDesc for Admin service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Admin_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "gosshd.admin.v1.Admin",
+	HandlerType: (*AdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListConnections",
+			Handler:    _Admin_ListConnections_Handler,
+		},
+		{
+			MethodName: "Close",
+			Handler:    _Admin_Close_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _Admin_GetStats_Handler,
+		},
+		{
+			MethodName: "ListTraffic",
+			Handler:    _Admin_ListTraffic_Handler,
+		},
+		{
+			MethodName: "ListBans",
+			Handler:    _Admin_ListBans_Handler,
+		},
+		{
+			MethodName: "AddBan",
+			Handler:    _Admin_AddBan_Handler,
+		},
+		{
+			MethodName: "RemoveBan",
+			Handler:    _Admin_RemoveBan_Handler,
+		},
+		{
+			MethodName: "Reload",
+			Handler:    _Admin_Reload_Handler,
+		},
+		{
+			MethodName: "Wall",
+			Handler:    _Admin_Wall_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Events",
+			Handler:       _Admin_Events_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Monitor",
+			Handler:       _Admin_Monitor_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "admin.proto",
+}
@@ -0,0 +1,129 @@
+package admin
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Ban is a banned IP address or network.
+type Ban struct {
+	// Address is an IP address or a network in CIDR notation
+	Address string `json:"address"`
+	// Until is the expiry of the ban. The ban is permanent if nil.
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// Bans is a set of banned IP addresses and networks. The zero value is empty and a nil Bans bans nothing.
+type Bans struct {
+	mu sync.Mutex
+	// bans are expiries by network. Zero expiries are permanent.
+	bans map[netip.Prefix]time.Time
+}
+
+// ParseAddress parses an IP address or a network in CIDR notation.
+func ParseAddress(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address or network: %s", s)
+	}
+	return prefix.Masked(), nil
+}
+
+// Add bans prefix until the time, or permanently if until is zero.
+func (b *Bans) Add(prefix netip.Prefix, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bans == nil {
+		b.bans = map[netip.Prefix]time.Time{}
+	}
+	b.bans[prefix] = until
+}
+
+// Remove lifts the ban of prefix. It returns false if prefix is not banned.
+func (b *Bans) Remove(prefix netip.Prefix) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeExpired()
+	_, ok := b.bans[prefix]
+	delete(b.bans, prefix)
+	return ok
+}
+
+// List returns the bans in effect in order of address.
+func (b *Bans) List() []Ban {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.removeExpired()
+	bans := []Ban{}
+	for prefix, until := range b.bans {
+		ban := Ban{Address: formatPrefix(prefix)}
+		if !until.IsZero() {
+			until := until
+			ban.Until = &until
+		}
+		bans = append(bans, ban)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Address < bans[j].Address
+	})
+	return bans
+}
+
+// Banned reports whether the IP address of addr is banned. Addresses other than TCP and UDP are never banned.
+func (b *Bans) Banned(addr net.Addr) bool {
+	if b == nil {
+		return false
+	}
+	ip, ok := addrIP(addr)
+	if !ok {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for prefix, until := range b.bans {
+		if prefix.Contains(ip) && (until.IsZero() || now.Before(until)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Bans) removeExpired() {
+	now := time.Now()
+	for prefix, until := range b.bans {
+		if !until.IsZero() && !now.Before(until) {
+			delete(b.bans, prefix)
+		}
+	}
+}
+
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	parsed, ok := netip.AddrFromSlice(ip)
+	return parsed.Unmap(), ok
+}
+
+// formatPrefix formats single addresses without the prefix length
+func formatPrefix(prefix netip.Prefix) string {
+	if prefix.IsSingleIP() {
+		return prefix.Addr().String()
+	}
+	return prefix.String()
+}
@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBans(t *testing.T) {
+	var bans Bans
+	ipv4, err := ParseAddress("192.0.2.1")
+	require.NoError(t, err)
+	network, err := ParseAddress("198.51.100.7/24")
+	require.NoError(t, err)
+	assert.Equal(t, "198.51.100.0/24", network.String())
+	expired, err := ParseAddress("203.0.113.1")
+	require.NoError(t, err)
+	_, err = ParseAddress("192.0.2.1:22")
+	assert.Error(t, err)
+
+	bans.Add(ipv4, time.Time{})
+	bans.Add(network, time.Now().Add(time.Hour))
+	bans.Add(expired, time.Now().Add(-time.Second))
+	assert.True(t, bans.Banned(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}))
+	// IPv4-mapped IPv6 addresses
+	assert.True(t, bans.Banned(&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}))
+	assert.True(t, bans.Banned(&net.TCPAddr{IP: net.ParseIP("198.51.100.200")}))
+	assert.False(t, bans.Banned(&net.TCPAddr{IP: net.ParseIP("192.0.2.2")}))
+	assert.False(t, bans.Banned(&net.TCPAddr{IP: net.ParseIP("203.0.113.1")}))
+	assert.False(t, bans.Banned(&net.UnixAddr{Name: "/tmp/sshd.sock", Net: "unix"}))
+	assert.False(t, (*Bans)(nil).Banned(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}))
+
+	list := bans.List()
+	require.Len(t, list, 2)
+	assert.Equal(t, "192.0.2.1", list[0].Address)
+	assert.Nil(t, list[0].Until)
+	assert.Equal(t, "198.51.100.0/24", list[1].Address)
+	assert.NotNil(t, list[1].Until)
+
+	assert.True(t, bans.Remove(ipv4))
+	assert.False(t, bans.Remove(ipv4))
+	assert.False(t, bans.Remove(expired))
+}
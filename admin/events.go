@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"golang.org/x/exp/slices"
+)
+
+// sseKeepaliveInterval is the interval of comments sent on idle server-sent event streams
+const sseKeepaliveInterval = 30 * time.Second
+
+// Event is an event of the server named Server.
+type Event struct {
+	Server string
+	server.Event
+}
+
+// Events broadcasts events of servers to subscribers. The zero value has no subscribers.
+type Events struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+// Publish sends the event of the server named serverName to subscribers.
+// Events are dropped instead of blocking when the buffer of a subscriber is full.
+func (e *Events) Publish(serverName string, event server.Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for ch := range e.subscribers {
+		select {
+		case ch <- Event{Server: serverName, Event: event}:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function to unsubscribe.
+func (e *Events) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subscribers == nil {
+		e.subscribers = map[chan Event]struct{}{}
+	}
+	e.subscribers[ch] = struct{}{}
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			delete(e.subscribers, ch)
+			close(ch)
+		})
+	}
+}
+
+// EventJSON is the JSON form of Event. Fields not related to the type are omitted.
+type EventJSON struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Server     string    `json:"server,omitempty"`
+	ConnID     string    `json:"conn_id,omitempty"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_address,omitempty"`
+	// Country and ASN are of the remote address by GeoIP
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	// Method and Error are of "auth"
+	Method string `json:"method,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Command is of sessions. ExitCode is of "session-ended".
+	Command  []string `json:"command,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	// ForwardType, Host and Port are of forwards
+	ForwardType string `json:"forward_type,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	// Path is the Unix domain socket path of forwards or the path of "sftp"
+	Path string `json:"path,omitempty"`
+	// Operation and TargetPath are of "sftp"
+	Operation  string `json:"operation,omitempty"`
+	TargetPath string `json:"target_path,omitempty"`
+	// BytesReceived and BytesSent are of "transfer"
+	BytesReceived *uint64 `json:"bytes_received,omitempty"`
+	BytesSent     *uint64 `json:"bytes_sent,omitempty"`
+}
+
+// NewEventJSON returns the JSON form of event.
+func NewEventJSON(event Event) EventJSON {
+	e := EventJSON{
+		Type:        event.Type,
+		Time:        event.Time,
+		Server:      event.Server,
+		ConnID:      event.ConnID,
+		User:        event.User,
+		RemoteAddr:  event.RemoteAddr,
+		Country:     event.Country,
+		ASN:         event.ASN,
+		Method:      event.Method,
+		Error:       event.Err,
+		Command:     event.Command,
+		ForwardType: event.ForwardType,
+		Host:        event.Host,
+		Port:        event.Port,
+		Path:        event.Path,
+		Operation:   event.Operation,
+		TargetPath:  event.TargetPath,
+	}
+	switch event.Type {
+	case server.EventSessionEnded:
+		e.ExitCode = &event.ExitCode
+	case server.EventTransfer:
+		e.BytesReceived = &event.BytesReceived
+		e.BytesSent = &event.BytesSent
+	}
+	return e
+}
+
+// streamEvents streams events as server-sent events, each of which is "data: " followed by EventJSON,
+// until the request is canceled. Events are filtered by the "type" query parameters if any.
+func (a *API) streamEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) || !implemented(w, a.Events != nil) {
+		return
+	}
+	types := r.URL.Query()["type"]
+	events, unsubscribe := a.Events.Subscribe(eventsBuffer)
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	flush()
+	// Comments keep proxies and clients from closing the idle stream
+	keepalive := time.NewTicker(sseKeepaliveInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepalive.C:
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flush()
+		case event := <-events:
+			if len(types) != 0 && !slices.Contains(types, event.Type) {
+				continue
+			}
+			data, err := json.Marshal(NewEventJSON(event))
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+				return
+			}
+			flush()
+		}
+	}
+}
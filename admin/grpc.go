@@ -0,0 +1,288 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/admin/adminpb"
+	"github.com/John-Ao/go-sshd/control"
+
+	"golang.org/x/exp/slices"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// eventsBuffer is the number of events buffered for each stream of the Events RPC
+const eventsBuffer = 256
+
+// NewGRPCServer returns a gRPC server of the adminpb.Admin service requiring the bearer token in the "authorization" metadata.
+// RPCs of nil fields fail with UNIMPLEMENTED.
+func (a *API) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.ChainUnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			if err := a.authenticateGRPC(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.ChainStreamInterceptor(func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := a.authenticateGRPC(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	s := grpc.NewServer(opts...)
+	adminpb.RegisterAdminServer(s, &grpcServer{api: a})
+	return s
+}
+
+func (a *API) authenticateGRPC(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		token, ok := strings.CutPrefix(value, "Bearer ")
+		if ok && len(a.Token) != 0 && subtle.ConstantTimeCompare([]byte(token), a.Token) == 1 {
+			return nil
+		}
+	}
+	return status.Error(codes.Unauthenticated, "unauthorized")
+}
+
+// grpcServer implements adminpb.AdminServer with API
+type grpcServer struct {
+	adminpb.UnimplementedAdminServer
+	api *API
+}
+
+var errUnimplemented = status.Error(codes.Unimplemented, "not implemented")
+
+func (s *grpcServer) ListConnections(ctx context.Context, req *adminpb.ListConnectionsRequest) (*adminpb.ListConnectionsResponse, error) {
+	if s.api.Servers == nil {
+		return nil, errUnimplemented
+	}
+	res := &adminpb.ListConnectionsResponse{}
+	for _, conn := range control.ListConnections(s.api.Servers()) {
+		pbConn := &adminpb.Connection{
+			Id:            conn.ID,
+			User:          conn.User,
+			RemoteAddress: conn.RemoteAddr,
+			Channels:      conn.Channels,
+			StartTime:     timestamppb.New(conn.StartTime),
+		}
+		for _, sess := range conn.Sessions {
+			pbConn.Sessions = append(pbConn.Sessions, &adminpb.Session{Id: sess.ID, Type: sess.Type, Command: sess.Command, Pid: int32(sess.PID), Pty: sess.Pty, StartTime: timestamppb.New(sess.StartTime)})
+		}
+		for _, fwd := range conn.Forwards {
+			pbConn.Forwards = append(pbConn.Forwards, &adminpb.Forward{Id: fwd.ID, Type: fwd.Type, Host: fwd.Host, Port: int32(fwd.Port), Path: fwd.Path, StartTime: timestamppb.New(fwd.StartTime)})
+		}
+		res.Connections = append(res.Connections, pbConn)
+	}
+	return res, nil
+}
+
+func (s *grpcServer) Close(ctx context.Context, req *adminpb.CloseRequest) (*adminpb.CloseResponse, error) {
+	if s.api.Servers == nil {
+		return nil, errUnimplemented
+	}
+	if !s.api.close(req.Id) {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Id)
+	}
+	return &adminpb.CloseResponse{}, nil
+}
+
+func (s *grpcServer) GetStats(ctx context.Context, req *adminpb.GetStatsRequest) (*adminpb.Stats, error) {
+	if s.api.Stats == nil {
+		return nil, errUnimplemented
+	}
+	stats := s.api.Stats()
+	res := &adminpb.Stats{
+		ActiveConnections:    stats.ActiveConnections,
+		ActiveSessions:       stats.ActiveSessions,
+		ActiveForwards:       stats.ActiveForwards,
+		Connections:          stats.Connections,
+		Sessions:             stats.Sessions,
+		AuthFailures:         stats.AuthFailures,
+		BytesReceived:        stats.BytesReceived,
+		BytesSent:            stats.BytesSent,
+		ForwardBytesReceived: stats.ForwardBytesReceived,
+		ForwardBytesSent:     stats.ForwardBytesSent,
+		SftpOperations:       stats.SftpOperations,
+		Handshakes:           stats.HandshakeDurations.Count,
+		HandshakeDurationSum: durationpb.New(stats.HandshakeDurations.Sum),
+	}
+	// In the same order as the HTTP API
+	for _, attempts := range NewStats(stats).AuthAttempts {
+		res.AuthAttempts = append(res.AuthAttempts, &adminpb.AuthAttempts{Method: attempts.Method, Success: attempts.Success, Count: attempts.Count})
+	}
+	return res, nil
+}
+
+func (s *grpcServer) ListTraffic(ctx context.Context, req *adminpb.ListTrafficRequest) (*adminpb.ListTrafficResponse, error) {
+	if s.api.Traffic == nil {
+		return nil, errUnimplemented
+	}
+	traffic, err := s.api.Traffic()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	res := &adminpb.ListTrafficResponse{}
+	for _, t := range NewUserTraffic(traffic) {
+		res.Users = append(res.Users, &adminpb.UserTraffic{
+			User:                 t.User,
+			BytesReceived:        t.BytesReceived,
+			BytesSent:            t.BytesSent,
+			ForwardBytesReceived: t.ForwardBytesReceived,
+			ForwardBytesSent:     t.ForwardBytesSent,
+		})
+	}
+	return res, nil
+}
+
+func (s *grpcServer) ListBans(ctx context.Context, req *adminpb.ListBansRequest) (*adminpb.ListBansResponse, error) {
+	if s.api.Bans == nil {
+		return nil, errUnimplemented
+	}
+	res := &adminpb.ListBansResponse{}
+	for _, ban := range s.api.Bans.List() {
+		res.Bans = append(res.Bans, newPBBan(ban))
+	}
+	return res, nil
+}
+
+func (s *grpcServer) AddBan(ctx context.Context, req *adminpb.AddBanRequest) (*adminpb.Ban, error) {
+	if s.api.Bans == nil {
+		return nil, errUnimplemented
+	}
+	duration := req.Duration.AsDuration()
+	if req.Duration != nil && (req.Duration.CheckValid() != nil || duration <= 0) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid duration: %s", duration)
+	}
+	ban, err := s.api.addBan(req.Address, duration)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return newPBBan(ban), nil
+}
+
+func newPBBan(ban Ban) *adminpb.Ban {
+	pbBan := &adminpb.Ban{Address: ban.Address}
+	if ban.Until != nil {
+		pbBan.Until = timestamppb.New(*ban.Until)
+	}
+	return pbBan
+}
+
+func (s *grpcServer) RemoveBan(ctx context.Context, req *adminpb.RemoveBanRequest) (*adminpb.RemoveBanResponse, error) {
+	if s.api.Bans == nil {
+		return nil, errUnimplemented
+	}
+	prefix, err := ParseAddress(req.Address)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !s.api.Bans.Remove(prefix) {
+		return nil, status.Errorf(codes.NotFound, "not found: %s", req.Address)
+	}
+	return &adminpb.RemoveBanResponse{}, nil
+}
+
+func (s *grpcServer) Reload(ctx context.Context, req *adminpb.ReloadRequest) (*adminpb.ReloadResponse, error) {
+	if s.api.Reload == nil {
+		return nil, errUnimplemented
+	}
+	if err := s.api.Reload(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &adminpb.ReloadResponse{}, nil
+}
+
+func (s *grpcServer) Events(req *adminpb.EventsRequest, stream adminpb.Admin_EventsServer) error {
+	if s.api.Events == nil {
+		return errUnimplemented
+	}
+	events, unsubscribe := s.api.Events.Subscribe(eventsBuffer)
+	defer unsubscribe()
+	// Headers are sent to notify the client of the subscription
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case event := <-events:
+			if len(req.Types) != 0 && !slices.Contains(req.Types, event.Type) {
+				continue
+			}
+			if err := stream.Send(newPBEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) Monitor(req *adminpb.MonitorRequest, stream adminpb.Admin_MonitorServer) error {
+	if s.api.Servers == nil {
+		return errUnimplemented
+	}
+	output, stop, ok := control.Monitor(s.api.Servers(), req.Id, req.Notify)
+	if !ok {
+		return status.Errorf(codes.NotFound, "not found: %s", req.Id)
+	}
+	defer stop()
+	// Headers are sent to notify the client of the start
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		case data, ok := <-output:
+			if !ok {
+				return nil
+			}
+			if err := stream.Send(&adminpb.MonitorOutput{Data: data}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *grpcServer) Wall(ctx context.Context, req *adminpb.WallRequest) (*adminpb.WallResponse, error) {
+	if s.api.Servers == nil {
+		return nil, errUnimplemented
+	}
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	return &adminpb.WallResponse{SessionIds: control.Wall(s.api.Servers(), req.Message, req.Users)}, nil
+}
+
+func newPBEvent(event Event) *adminpb.Event {
+	return &adminpb.Event{
+		Type:          event.Type,
+		Time:          timestamppb.New(event.Time),
+		Server:        event.Server,
+		ConnId:        event.ConnID,
+		User:          event.User,
+		RemoteAddress: event.RemoteAddr,
+		Method:        event.Method,
+		Error:         event.Err,
+		Command:       event.Command,
+		ExitCode:      int32(event.ExitCode),
+		ForwardType:   event.ForwardType,
+		Host:          event.Host,
+		Port:          int32(event.Port),
+		Path:          event.Path,
+		Operation:     event.Operation,
+		TargetPath:    event.TargetPath,
+		BytesReceived: event.BytesReceived,
+		BytesSent:     event.BytesSent,
+	}
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/admin/adminpb"
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/sshdtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func newGRPCClient(t *testing.T, api *API) adminpb.AdminClient {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := api.NewGRPCServer()
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+	conn, err := grpc.NewClient(ln.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return adminpb.NewAdminClient(conn)
+}
+
+func TestGRPC(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}}
+	events := &Events{}
+	s.OnEvent = func(event server.Event) { events.Publish("main", event) }
+	api := &API{
+		Token:   []byte("secret"),
+		Servers: func() []*server.Server { return []*server.Server{s} },
+		Stats:   s.Stats,
+		Bans:    &Bans{},
+		Events:  events,
+	}
+	client := newGRPCClient(t, api)
+	_, err := client.GetStats(context.Background(), &adminpb.GetStatsRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	_, err = client.Reload(ctx, &adminpb.ReloadRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := client.Events(streamCtx, &adminpb.EventsRequest{Types: []string{server.EventConnectionOpened}})
+	require.NoError(t, err)
+	// Subscribed when headers are received
+	_, err = stream.Header()
+	require.NoError(t, err)
+	sshClient := sshdtest.NewClient(t, s, "john")
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, server.EventConnectionOpened, event.Type)
+	assert.Equal(t, "main", event.Server)
+	assert.Equal(t, "john", event.User)
+
+	connections, err := client.ListConnections(ctx, &adminpb.ListConnectionsRequest{})
+	require.NoError(t, err)
+	require.Len(t, connections.Connections, 1)
+	assert.Equal(t, event.ConnId, connections.Connections[0].Id)
+	stats, err := client.GetStats(ctx, &adminpb.GetStatsRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats.ActiveConnections)
+	_, err = client.ListTraffic(ctx, &adminpb.ListTrafficRequest{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	_, err = client.AddBan(ctx, &adminpb.AddBanRequest{Address: "192.0.2.0/24", Duration: durationpb.New(-time.Hour)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	ban, err := client.AddBan(ctx, &adminpb.AddBanRequest{Address: "192.0.2.0/24", Duration: durationpb.New(time.Hour)})
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.0/24", ban.Address)
+	assert.NotNil(t, ban.Until)
+	bans, err := client.ListBans(ctx, &adminpb.ListBansRequest{})
+	require.NoError(t, err)
+	assert.Len(t, bans.Bans, 1)
+	_, err = client.RemoveBan(ctx, &adminpb.RemoveBanRequest{Address: "192.0.2.0/24"})
+	require.NoError(t, err)
+	_, err = client.RemoveBan(ctx, &adminpb.RemoveBanRequest{Address: "192.0.2.0/24"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Connections without sessions with a pty get no message
+	wall, err := client.Wall(ctx, &adminpb.WallRequest{Message: "maintenance"})
+	require.NoError(t, err)
+	assert.Empty(t, wall.SessionIds)
+	_, err = client.Wall(ctx, &adminpb.WallRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.Close(ctx, &adminpb.CloseRequest{Id: "unknown"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = client.Close(ctx, &adminpb.CloseRequest{Id: event.ConnId})
+	require.NoError(t, err)
+	assert.Error(t, sshClient.Wait())
+}
+
+func TestGRPCMonitor(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}, AllowExecute: true}
+	started := make(chan struct{})
+	s.Handler = func(sess server.Session) {
+		close(started)
+		io.Copy(sess, sess)
+	}
+	sshClient := sshdtest.NewClient(t, s, "john")
+	session, err := sshClient.NewSession()
+	require.NoError(t, err)
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	<-started
+	id := s.Connections()[0].Sessions[0].ID
+
+	api := &API{Token: []byte("secret"), Servers: func() []*server.Server { return []*server.Server{s} }}
+	assert.Equal(t, http.StatusNotFound, do(t, api.Handler(), "GET", "/v1/monitor/unknown", "", nil).Code)
+	client := newGRPCClient(t, api)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer secret")
+	stream, err := client.Monitor(ctx, &adminpb.MonitorRequest{Id: "unknown"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	stream, err = client.Monitor(ctx, &adminpb.MonitorRequest{Id: id})
+	require.NoError(t, err)
+	// Monitored when headers are received
+	_, err = stream.Header()
+	require.NoError(t, err)
+	_, err = stdin.Write([]byte("ping"))
+	require.NoError(t, err)
+	output, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(output.Data))
+	// The stream ends with the session
+	stdin.Close()
+	_, err = stream.Recv()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,37 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"net/netip"
+)
+
+// Values of API.Pprof
+const (
+	// PprofLoopback serves profiles only to clients on loopback addresses
+	PprofLoopback = "loopback"
+	// PprofAny serves profiles to any authenticated client
+	PprofAny = "any"
+)
+
+// pprofHandler serves net/http/pprof under /debug/pprof/
+func (a *API) pprofHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.Pprof != PprofAny && !fromLoopback(r) {
+			writeError(w, http.StatusForbidden, "profiles are only served to loopback addresses")
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func fromLoopback(r *http.Request) bool {
+	addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+	return err == nil && addrPort.Addr().Unmap().IsLoopback()
+}
@@ -0,0 +1,257 @@
+// Package audit writes an append-only log of server events in JSON lines, separate from operational logs.
+// Records can be chained with HMAC-SHA256 for tamper evidence: each HMAC covers the record and the previous HMAC,
+// so modifying, inserting, removing or reordering records breaks the chain. Truncating the end of the log is not detected.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// Record is a line of the audit log. Fields are only added to keep the schema stable.
+// Fields not related to the type are omitted.
+type Record struct {
+	// Seq is the sequence number of the record in the file starting from 1
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+	// Type is a type of server.Event (e.g. "auth", "session-started", "sftp", "forward-started")
+	Type string `json:"type"`
+	// Server is the name of the server in the config file
+	Server     string `json:"server,omitempty"`
+	ConnID     string `json:"conn_id,omitempty"`
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remote_address,omitempty"`
+	// Country and ASN are of the remote address by GeoIP
+	Country string `json:"country,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	// Method, Success and Error are of "auth"
+	Method  string `json:"method,omitempty"`
+	Success *bool  `json:"success,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Command is of sessions. ExitCode is of "session-ended".
+	Command  []string `json:"command,omitempty"`
+	ExitCode *int     `json:"exit_code,omitempty"`
+	// ForwardType, Host and Port are of forwards
+	ForwardType string `json:"forward_type,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	// Path is the Unix domain socket path of forwards or the path of "sftp"
+	Path string `json:"path,omitempty"`
+	// Operation and TargetPath are of "sftp"
+	Operation  string `json:"operation,omitempty"`
+	TargetPath string `json:"target_path,omitempty"`
+	// BytesReceived and BytesSent are of "transfer"
+	BytesReceived *uint64 `json:"bytes_received,omitempty"`
+	BytesSent     *uint64 `json:"bytes_sent,omitempty"`
+	// HMAC is the hex HMAC-SHA256 of the previous HMAC and the line without HMAC. It is empty without a key.
+	HMAC string `json:"hmac,omitempty"`
+}
+
+// NewRecord returns the record of event of the server named serverName.
+func NewRecord(serverName string, event server.Event) Record {
+	record := Record{
+		Time:        event.Time,
+		Type:        event.Type,
+		Server:      serverName,
+		ConnID:      event.ConnID,
+		User:        event.User,
+		RemoteAddr:  event.RemoteAddr,
+		Country:     event.Country,
+		ASN:         event.ASN,
+		Method:      event.Method,
+		Error:       event.Err,
+		Command:     event.Command,
+		ForwardType: event.ForwardType,
+		Host:        event.Host,
+		Port:        event.Port,
+		Path:        event.Path,
+		Operation:   event.Operation,
+		TargetPath:  event.TargetPath,
+	}
+	switch event.Type {
+	case server.EventAuth:
+		success := event.Err == ""
+		record.Success = &success
+	case server.EventSessionEnded:
+		record.ExitCode = &event.ExitCode
+	case server.EventTransfer:
+		record.BytesReceived = &event.BytesReceived
+		record.BytesSent = &event.BytesSent
+	}
+	return record
+}
+
+// Logger appends records to a file.
+// Processes appending to the same file, e.g. during an upgrade, continue the sequence numbers and the HMAC chain of each other.
+type Logger struct {
+	mu   sync.Mutex
+	f    *os.File
+	path string
+	key  []byte
+	// seq and prevMAC are of the last record when the size of the file was size
+	seq     uint64
+	prevMAC string
+	size    int64
+}
+
+// Open opens the audit log of path to append records, chained with HMAC if key is not empty.
+// Appending to an existing file continues its sequence numbers and HMAC chain.
+func Open(path string, key []byte) (*Logger, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	l := &Logger{f: f, path: path, key: key, size: -1}
+	if err := l.loadLast(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// loadLast loads the last record if the file was appended by others
+func (l *Logger) loadLast() error {
+	info, err := l.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == l.size {
+		return nil
+	}
+	line, err := lastLine(l.f, info.Size())
+	if err != nil {
+		return err
+	}
+	var last Record
+	if len(line) != 0 {
+		if err := json.Unmarshal(line, &last); err != nil {
+			return fmt.Errorf("failed to parse the last record of %s: %w", l.path, err)
+		}
+		if len(l.key) != 0 && last.HMAC == "" {
+			return fmt.Errorf("%s is not chained with HMAC", l.path)
+		}
+	}
+	l.seq = last.Seq
+	l.prevMAC = last.HMAC
+	l.size = info.Size()
+	return nil
+}
+
+// lastLine returns the last line of f of size without the newline
+func lastLine(f *os.File, size int64) ([]byte, error) {
+	var line []byte
+	chunk := make([]byte, 4096)
+	for offset := size; offset > 0; {
+		n := int64(len(chunk))
+		if offset < n {
+			n = offset
+		}
+		offset -= n
+		if _, err := f.ReadAt(chunk[:n], offset); err != nil {
+			return nil, err
+		}
+		line = append(append([]byte(nil), chunk[:n]...), line...)
+		trimmed := bytes.TrimRight(line, "\n")
+		if i := bytes.LastIndexByte(trimmed, '\n'); i >= 0 {
+			return trimmed[i+1:], nil
+		}
+	}
+	return bytes.TrimRight(line, "\n"), nil
+}
+
+// Log appends the record of event of the server named serverName.
+func (l *Logger) Log(serverName string, event server.Event) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := lockFile(l.f); err != nil {
+		return err
+	}
+	defer unlockFile(l.f)
+	if err := l.loadLast(); err != nil {
+		return err
+	}
+	record := NewRecord(serverName, event)
+	record.Seq = l.seq + 1
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if len(l.key) != 0 {
+		mac := computeMAC(l.key, l.prevMAC, line)
+		line = appendMAC(line, mac)
+		l.prevMAC = mac
+	}
+	if _, err := l.f.Write(append(line, '\n')); err != nil {
+		// The size is unknown after a partial write
+		l.size = -1
+		return err
+	}
+	l.seq = record.Seq
+	l.size += int64(len(line)) + 1
+	return nil
+}
+
+// Close closes the file.
+func (l *Logger) Close() error {
+	return l.f.Close()
+}
+
+func computeMAC(key []byte, prevMAC string, line []byte) string {
+	h := hmac.New(sha256.New, key)
+	io.WriteString(h, prevMAC)
+	h.Write([]byte{'\n'})
+	h.Write(line)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// appendMAC adds the "hmac" field at the end of the JSON object of line
+func appendMAC(line []byte, mac string) []byte {
+	return append(line[:len(line)-1], `,"hmac":"`+mac+`"}`...)
+}
+
+// Verify verifies the HMAC chain and the sequence numbers of the audit log read from r and returns the number of records.
+func Verify(r io.Reader, key []byte) (int, error) {
+	if len(key) == 0 {
+		return 0, errors.New("key required")
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16*1024*1024)
+	prevMAC := ""
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Bytes()
+		var record Record
+		if err := json.Unmarshal(line, &record); err != nil {
+			return n - 1, fmt.Errorf("line %d: %w", n, err)
+		}
+		if record.Seq != uint64(n) {
+			return n - 1, fmt.Errorf("line %d: unexpected seq %d", n, record.Seq)
+		}
+		suffix := `,"hmac":"` + record.HMAC + `"}`
+		if record.HMAC == "" || !bytes.HasSuffix(line, []byte(suffix)) {
+			return n - 1, fmt.Errorf("line %d: no hmac at the end", n)
+		}
+		unsigned := append(line[:len(line)-len(suffix):len(line)-len(suffix)], '}')
+		if !hmac.Equal([]byte(computeMAC(key, prevMAC, unsigned)), []byte(record.HMAC)) {
+			return n - 1, fmt.Errorf("line %d: hmac mismatch", n)
+		}
+		prevMAC = record.HMAC
+	}
+	if err := scanner.Err(); err != nil {
+		return n, err
+	}
+	return n, nil
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("secret")
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	l, err := Open(path, key)
+	require.NoError(t, err)
+	require.NoError(t, l.Log("a", server.Event{Type: server.EventAuth, Time: now, User: "john", RemoteAddr: "192.0.2.1:50000", Method: "password"}))
+	require.NoError(t, l.Log("a", server.Event{Type: server.EventSessionEnded, Time: now, ConnID: "c1", Command: []string{"ls", "-l"}}))
+	require.NoError(t, l.Close())
+	// The chain continues after reopening
+	l, err = Open(path, key)
+	require.NoError(t, err)
+	require.NoError(t, l.Log("", server.Event{Type: server.EventSftp, Time: now, Operation: "rename", Path: "a", TargetPath: "b"}))
+	require.NoError(t, l.Close())
+
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	require.Len(t, lines, 3)
+	var record Record
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, uint64(1), record.Seq)
+	assert.Equal(t, "auth", record.Type)
+	assert.Equal(t, "a", record.Server)
+	assert.True(t, *record.Success)
+	assert.Regexp(t, `^\{"seq":1,"time":"2024-01-02T03:04:05Z","type":"auth","server":"a","user":"john","remote_address":"192.0.2.1:50000","method":"password","success":true,"hmac":"[0-9a-f]{64}"\}$`, lines[0])
+	assert.Contains(t, lines[1], `"command":["ls","-l"],"exit_code":0,`)
+	assert.Contains(t, lines[2], `"seq":3,`)
+
+	n, err := Verify(bytes.NewReader(b), key)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	_, err = Verify(bytes.NewReader(b), []byte("wrong"))
+	assert.EqualError(t, err, "line 1: hmac mismatch")
+	tampered := strings.Replace(string(b), `"ls","-l"`, `"ls","-a"`, 1)
+	_, err = Verify(strings.NewReader(tampered), key)
+	assert.EqualError(t, err, "line 2: hmac mismatch")
+	removed := lines[0] + "\n" + lines[2] + "\n"
+	_, err = Verify(strings.NewReader(removed), key)
+	assert.EqualError(t, err, "line 2: unexpected seq 3")
+}
+
+func TestOpenUnchained(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, l.Log("", server.Event{Type: server.EventConnectionOpened}))
+	require.NoError(t, l.Close())
+	_, err = Open(path, []byte("secret"))
+	assert.EqualError(t, err, path+" is not chained with HMAC")
+}
+
+func TestLoggersSharingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	key := []byte("secret")
+	// The old and the new process during an upgrade
+	l1, err := Open(path, key)
+	require.NoError(t, err)
+	defer l1.Close()
+	l2, err := Open(path, key)
+	require.NoError(t, err)
+	defer l2.Close()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, l1.Log("", server.Event{Type: server.EventConnectionOpened}))
+		require.NoError(t, l2.Log("", server.Event{Type: server.EventConnectionClosed}))
+	}
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	n, err := Verify(f, key)
+	require.NoError(t, err)
+	assert.Equal(t, 6, n)
+}
@@ -0,0 +1,86 @@
+// Package auditd sends records of authentications, logins and sessions to the Linux audit subsystem,
+// so they are searched by ausearch and reported by aureport alongside the ones of OpenSSH.
+package auditd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// Record types of the audit subsystem
+const (
+	TypeUserAuth  uint16 = 1100
+	TypeUserStart uint16 = 1105
+	TypeUserEnd   uint16 = 1106
+	TypeUserLogin uint16 = 1112
+)
+
+// Record is a user space audit record in the format of audit_log_acct_message of libaudit.
+type Record struct {
+	Type uint16
+	// Op is the operation, e.g. "login"
+	Op   string
+	Acct string
+	// Addr is the IP address of the client
+	Addr     string
+	Terminal string
+	Success  bool
+}
+
+// NewRecord returns the record of event. It returns false if event is not recorded.
+func NewRecord(event server.Event) (Record, bool) {
+	addr, _, err := net.SplitHostPort(event.RemoteAddr)
+	if err != nil {
+		addr = event.RemoteAddr
+	}
+	record := Record{Acct: event.User, Addr: addr, Terminal: "ssh", Success: true}
+	switch event.Type {
+	case server.EventAuth:
+		record.Type = TypeUserAuth
+		record.Op = "authentication"
+		record.Success = event.Err == ""
+	case server.EventConnectionOpened:
+		record.Type = TypeUserLogin
+		record.Op = "login"
+	case server.EventSessionStarted:
+		record.Type = TypeUserStart
+		record.Op = "session_open"
+	case server.EventSessionEnded:
+		record.Type = TypeUserEnd
+		record.Op = "session_close"
+	default:
+		return Record{}, false
+	}
+	return record, true
+}
+
+// Message returns the message of r sent by the executable of exe.
+func (r Record) Message(exe string) string {
+	res := "failed"
+	if r.Success {
+		res = "success"
+	}
+	return fmt.Sprintf("op=%s acct=%s exe=%s hostname=%s addr=%s terminal=%s res=%s",
+		r.Op, encodeValue(r.Acct), encodeValue(exe), orUnknown(r.Addr), orUnknown(r.Addr), orUnknown(r.Terminal), res)
+}
+
+// encodeValue quotes s, or encodes it in hex if it contains spaces, quotes or control characters as libaudit does
+func encodeValue(s string) string {
+	for _, c := range s {
+		if c <= ' ' || c == '"' || c >= 0x7f {
+			return strings.ToUpper(hex.EncodeToString([]byte(s)))
+		}
+	}
+	return `"` + s + `"`
+}
+
+func orUnknown(s string) string {
+	if s == "" {
+		return "?"
+	}
+	return s
+}
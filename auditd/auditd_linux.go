@@ -0,0 +1,120 @@
+package auditd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/cpu"
+	"golang.org/x/sys/unix"
+)
+
+// nativeEndian is the byte order of netlink headers
+var nativeEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	if cpu.IsBigEndian {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// Logger sends records to the audit subsystem over netlink. It requires CAP_AUDIT_WRITE.
+type Logger struct {
+	mu  sync.Mutex
+	fd  int
+	seq uint32
+	exe string
+}
+
+// Open connects to the audit subsystem.
+func Open() (*Logger, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_AUDIT)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// Acknowledgements are not waited for long not to block connections
+	tv := unix.NsecToTimeval(int64(time.Second))
+	if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setsockopt", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+	return &Logger{fd: fd, exe: exe}, nil
+}
+
+// Log sends r and waits for the acknowledgement.
+func (l *Logger) Log(r Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	msg := netlinkMessage(r.Type, l.seq, r.Message(l.exe))
+	if err := unix.Sendto(l.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(l.fd, buf, 0)
+		if err != nil {
+			return os.NewSyscallError("recvfrom", err)
+		}
+		done, err := parseAck(buf[:n], l.seq)
+		if done {
+			return err
+		}
+	}
+}
+
+// Close closes the connection.
+func (l *Logger) Close() error {
+	return unix.Close(l.fd)
+}
+
+// netlinkMessage returns the netlink message of text terminated by NUL as libaudit sends
+func netlinkMessage(recordType uint16, seq uint32, text string) []byte {
+	length := unix.NLMSG_HDRLEN + len(text) + 1
+	msg := make([]byte, (length+unix.NLMSG_ALIGNTO-1)&^(unix.NLMSG_ALIGNTO-1))
+	nativeEndian.PutUint32(msg[0:4], uint32(length))
+	nativeEndian.PutUint16(msg[4:6], recordType)
+	nativeEndian.PutUint16(msg[6:8], unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:12], seq)
+	copy(msg[unix.NLMSG_HDRLEN:], text)
+	return msg
+}
+
+// parseAck parses the messages in b and returns true with the error of the acknowledgement of seq if found
+func parseAck(b []byte, seq uint32) (bool, error) {
+	for len(b) >= unix.NLMSG_HDRLEN {
+		length := int(nativeEndian.Uint32(b[0:4]))
+		if length < unix.NLMSG_HDRLEN || length > len(b) {
+			return true, errors.New("malformed netlink message")
+		}
+		msgType := nativeEndian.Uint16(b[4:6])
+		msgSeq := nativeEndian.Uint32(b[8:12])
+		if msgType == unix.NLMSG_ERROR && msgSeq == seq {
+			if length < unix.NLMSG_HDRLEN+4 {
+				return true, errors.New("malformed netlink error")
+			}
+			// The error is negative errno, or 0 for an acknowledgement
+			if errno := int32(nativeEndian.Uint32(b[unix.NLMSG_HDRLEN:])); errno != 0 {
+				return true, fmt.Errorf("audit: %w", unix.Errno(-errno))
+			}
+			return true, nil
+		}
+		aligned := (length + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if aligned > len(b) {
+			break
+		}
+		b = b[aligned:]
+	}
+	return false, nil
+}
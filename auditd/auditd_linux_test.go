@@ -0,0 +1,42 @@
+package auditd
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestNetlinkMessage(t *testing.T) {
+	msg := netlinkMessage(TypeUserLogin, 7, "op=login")
+	assert.Len(t, msg, 28)
+	assert.Equal(t, uint32(25), nativeEndian.Uint32(msg[0:4]))
+	assert.Equal(t, TypeUserLogin, nativeEndian.Uint16(msg[4:6]))
+	assert.Equal(t, uint16(unix.NLM_F_REQUEST|unix.NLM_F_ACK), nativeEndian.Uint16(msg[6:8]))
+	assert.Equal(t, uint32(7), nativeEndian.Uint32(msg[8:12]))
+	assert.Equal(t, "op=login\x00", string(msg[16:25]))
+}
+
+func TestParseAck(t *testing.T) {
+	ack := func(seq uint32, errno int32) []byte {
+		b := make([]byte, unix.NLMSG_HDRLEN+4)
+		nativeEndian.PutUint32(b[0:4], uint32(len(b)))
+		nativeEndian.PutUint16(b[4:6], unix.NLMSG_ERROR)
+		nativeEndian.PutUint32(b[8:12], seq)
+		nativeEndian.PutUint32(b[unix.NLMSG_HDRLEN:], uint32(errno))
+		return b
+	}
+	done, err := parseAck(ack(1, 0), 2)
+	assert.False(t, done)
+	assert.NoError(t, err)
+	done, err = parseAck(append(ack(1, 0), ack(2, 0)...), 2)
+	assert.True(t, done)
+	assert.NoError(t, err)
+	done, err = parseAck(ack(3, -int32(unix.EPERM)), 3)
+	assert.True(t, done)
+	assert.True(t, errors.Is(err, unix.EPERM))
+	done, err = parseAck([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}, 1)
+	assert.True(t, done)
+	assert.Error(t, err)
+}
@@ -0,0 +1,25 @@
+package auditd
+
+import (
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRecord(t *testing.T) {
+	record, ok := NewRecord(server.Event{Type: server.EventAuth, User: "john", RemoteAddr: "192.0.2.1:50000", Err: "password rejected"})
+	assert.True(t, ok)
+	assert.Equal(t, Record{Type: TypeUserAuth, Op: "authentication", Acct: "john", Addr: "192.0.2.1", Terminal: "ssh"}, record)
+	assert.Equal(t, `op=authentication acct="john" exe="/usr/bin/go-sshd" hostname=192.0.2.1 addr=192.0.2.1 terminal=ssh res=failed`, record.Message("/usr/bin/go-sshd"))
+
+	record, ok = NewRecord(server.Event{Type: server.EventSessionStarted, User: "jo hn", RemoteAddr: "[2001:db8::1]:50000"})
+	assert.True(t, ok)
+	assert.Equal(t, TypeUserStart, record.Type)
+	assert.Equal(t, `op=session_open acct=6A6F20686E exe="/usr/bin/go-sshd" hostname=2001:db8::1 addr=2001:db8::1 terminal=ssh res=success`, record.Message("/usr/bin/go-sshd"))
+
+	_, ok = NewRecord(server.Event{Type: server.EventSftp})
+	assert.False(t, ok)
+	assert.Equal(t, `op=login acct="" exe="sshd" hostname=? addr=? terminal=? res=success`, Record{Op: "login", Success: true}.Message("sshd"))
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/John-Ao/go-sshd/admin"
+	"github.com/John-Ao/go-sshd/tarpit"
+)
+
+// readAdminToken reads --admin-token-file required by --admin-listen and --admin-grpc-listen. It returns nil if neither is specified.
+func readAdminToken(flag *flagType) ([]byte, error) {
+	if flag.adminListen == "" && flag.adminGRPCListen == "" {
+		if flag.adminTokenFile != "" {
+			return nil, errors.New("--admin-token-file requires --admin-listen or --admin-grpc-listen")
+		}
+		return nil, nil
+	}
+	if flag.adminTokenFile == "" {
+		return nil, errors.New("--admin-listen and --admin-grpc-listen require --admin-token-file")
+	}
+	return readSecretFile(flag.adminTokenFile)
+}
+
+// checkAdminPprof checks --admin-pprof
+func checkAdminPprof(flag *flagType) error {
+	switch flag.adminPprof {
+	case "":
+		return nil
+	case admin.PprofLoopback, admin.PprofAny:
+		if flag.adminListen == "" {
+			return errors.New("--admin-pprof requires --admin-listen")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid --admin-pprof: %s (expected %s or %s)", flag.adminPprof, admin.PprofLoopback, admin.PprofAny)
+}
+
+// newTarpit returns the tarpit of --tarpit for addresses banned by the admin API. It returns nil if not specified.
+func newTarpit(flag *flagType) (*tarpit.Tarpit, error) {
+	if !flag.tarpit {
+		return nil, nil
+	}
+	if flag.adminListen == "" && flag.adminGRPCListen == "" {
+		return nil, errors.New("--tarpit requires --admin-listen or --admin-grpc-listen to ban addresses")
+	}
+	if flag.tarpitMaxConns < 0 || flag.tarpitDuration < 0 || flag.tarpitInterval <= 0 {
+		return nil, errors.New("--tarpit-max-connections and --tarpit-duration must not be negative and --tarpit-interval must be positive")
+	}
+	return &tarpit.Tarpit{MaxConnections: flag.tarpitMaxConns, Duration: flag.tarpitDuration, Interval: flag.tarpitInterval}, nil
+}
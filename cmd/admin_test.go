@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/admin/adminpb"
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAdminListen(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "admin.token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	adminAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(getAvailableTcpPort()))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--admin-listen", adminAddress, "--admin-pprof", "--admin-token-file", tokenFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+
+	request := func(method, path, body string) int {
+		req, err := http.NewRequest(method, "http://"+adminAddress+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res.StatusCode
+	}
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", adminAddress)
+		if err == nil {
+			conn.Close()
+		}
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, http.StatusOK, request("GET", "/v1/stats", ""))
+	assert.Equal(t, http.StatusNoContent, request("POST", "/v1/reload", ""))
+	assert.Equal(t, http.StatusOK, request("GET", "/debug/pprof/cmdline", ""))
+
+	// Events of new connections are streamed
+	req, err := http.NewRequest("GET", "http://"+adminAddress+"/v1/events?type=connection-opened", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	eventClient, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	eventClient.Close()
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	res.Body.Close()
+	require.NoError(t, err)
+	assert.Contains(t, line, `"type":"connection-opened"`)
+
+	// Banning closes the connection and rejects new ones
+	assert.Equal(t, http.StatusCreated, request("POST", "/v1/bans", `{"address":"127.0.0.1"}`))
+	assert.Error(t, client.Wait())
+	_, err = dialPassword(port, "john", "mypass")
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusNoContent, request("DELETE", "/v1/bans/127.0.0.1", ""))
+	client, err = dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	client.Close()
+}
+
+func TestAdminListenWithoutToken(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--admin-listen", "127.0.0.1:0"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--admin-listen and --admin-grpc-listen require --admin-token-file")
+}
+
+func TestAdminPprofWithoutListen(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--admin-pprof=any"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--admin-pprof requires --admin-listen")
+}
+
+func TestAdminGRPCListen(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "admin.token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	grpcAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(getAvailableTcpPort()))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--admin-grpc-listen", grpcAddress, "--admin-token-file", tokenFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	conn, err := grpc.NewClient(grpcAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+	client := adminpb.NewAdminClient(conn)
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	stream, err := client.Events(ctx, &adminpb.EventsRequest{Types: []string{server.EventAuth}}, grpc.WaitForReady(true))
+	require.NoError(t, err)
+	_, err = stream.Header()
+	require.NoError(t, err)
+	sshClient, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer sshClient.Close()
+	event, err := stream.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "john", event.User)
+	assert.Equal(t, "password", event.Method)
+	assert.Empty(t, event.Error)
+}
+
+func TestTarpit(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "admin.token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	adminAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(getAvailableTcpPort()))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--admin-listen", adminAddress, "--admin-token-file", tokenFile, "--tarpit", "--tarpit-interval", "10ms"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	require.Eventually(t, func() bool {
+		req, err := http.NewRequest("POST", "http://"+adminAddress+"/v1/bans", strings.NewReader(`{"address":"127.0.0.1"}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer secret")
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return res.StatusCode == http.StatusCreated
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Banned clients get lines without the version
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	require.NoError(t, err)
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		assert.NotContains(t, line, "SSH-")
+	}
+}
+
+func TestTarpitWithoutAdmin(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--tarpit"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--tarpit requires --admin-listen or --admin-grpc-listen to ban addresses")
+}
@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/John-Ao/go-sshd/audit"
+
+	"github.com/spf13/cobra"
+)
+
+func auditCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Inspect audit logs",
+		Args:  cobra.NoArgs,
+	}
+	var keyFile string
+	verifyCmd := &cobra.Command{
+		Use:     "verify FILE",
+		Short:   "Verify the HMAC chain of an audit log",
+		Long:    "Verify the HMAC chain and the sequence numbers of an audit log written with --audit-hmac-key-file. Removing records at the end is not detected.",
+		Example: `go-sshd audit verify /var/log/go-sshd-audit.log --hmac-key-file /etc/go-sshd/audit.key`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if keyFile == "" {
+				return errors.New("--hmac-key-file is required")
+			}
+			key, err := readSecretFile(keyFile)
+			if err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			n, err := audit.Verify(f, key)
+			if err != nil {
+				return fmt.Errorf("%s: %w", args[0], err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "%d records OK\n", n)
+			return nil
+		},
+	}
+	verifyCmd.Flags().StringVarP(&keyFile, "hmac-key-file", "", "", "file of the key given to --audit-hmac-key-file")
+	cmd.AddCommand(verifyCmd)
+	return cmd
+}
+
+// openAuditLog opens --audit-log. It returns nil if not specified.
+func openAuditLog(flag *flagType) (*audit.Logger, error) {
+	if flag.auditLog == "" {
+		if flag.auditHMACKeyFile != "" {
+			return nil, errors.New("--audit-hmac-key-file requires --audit-log")
+		}
+		return nil, nil
+	}
+	var key []byte
+	if flag.auditHMACKeyFile != "" {
+		var err error
+		key, err = readSecretFile(flag.auditHMACKeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return audit.Open(flag.auditLog, key)
+}
+
+// readSecretFile reads the key or token in the file without trailing newlines
+func readSecretFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := bytes.TrimRight(b, "\r\n")
+	if len(key) == 0 {
+		return nil, fmt.Errorf("empty key in %s", path)
+	}
+	return key, nil
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	auditLog := filepath.Join(dir, "audit.log")
+	keyFile := filepath.Join(dir, "audit.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--audit-log", auditLog, "--audit-hmac-key-file", keyFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	assertExec(t, client)
+	client.Close()
+
+	var content string
+	require.Eventually(t, func() bool {
+		b, _ := os.ReadFile(auditLog)
+		content = string(b)
+		return bytes.Contains(b, []byte(`"type":"connection-closed"`))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, content, `"type":"auth","user":"john","remote_address":"127.0.0.1:`)
+	assert.Contains(t, content, `"method":"password","success":true,`)
+	assert.Contains(t, content, `"type":"session-started",`)
+	assert.Contains(t, content, `"command":["whoami"]`)
+
+	verifyCmd := RootCmd()
+	verifyCmd.SetArgs([]string{"audit", "verify", auditLog, "--hmac-key-file", keyFile})
+	var stdoutBuf bytes.Buffer
+	verifyCmd.SetOut(&stdoutBuf)
+	require.NoError(t, verifyCmd.Execute())
+	assert.Regexp(t, `^\d+ records OK\n$`, stdoutBuf.String())
+}
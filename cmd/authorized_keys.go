@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/forgekeys"
+	"github.com/John-Ao/go-sshd/server/auth"
+	"golang.org/x/exp/slog"
+)
+
+// newAuthorizedKeysURL returns the authenticator of --authorized-keys-url
+func newAuthorizedKeysURL(logger *slog.Logger, flag *flagType) (*auth.AuthorizedKeysURL, error) {
+	// The template is checked with a user name
+	u, err := url.Parse(strings.ReplaceAll(flag.authorizedKeysURL, "%u", "user"))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("--authorized-keys-url: invalid URL: %s", flag.authorizedKeysURL)
+	}
+	if u.Scheme == "http" {
+		logger.Warn("authorized keys fetched without TLS", "url", flag.authorizedKeysURL)
+	}
+	if flag.authorizedKeysURLCache <= 0 {
+		return nil, fmt.Errorf("--authorized-keys-url-cache must be positive")
+	}
+	return &auth.AuthorizedKeysURL{
+		URL:           flag.authorizedKeysURL,
+		CacheDuration: flag.authorizedKeysURLCache,
+		FailOpen:      flag.authorizedKeysURLFailOpen,
+		Logger:        logger,
+	}, nil
+}
+
+// newForgeKeys returns the provider of --forge-keys
+func newForgeKeys(logger *slog.Logger, flag *flagType) (*forgekeys.Provider, error) {
+	if flag.forgeKeys != forgekeys.GitHub && flag.forgeKeys != forgekeys.GitLab {
+		return nil, fmt.Errorf(`--forge-keys must be "github" or "gitlab": %s`, flag.forgeKeys)
+	}
+	// Anyone with an account could log in otherwise
+	if len(flag.forgeAccounts) == 0 && flag.forgeOrg == "" {
+		return nil, errors.New("--forge-keys requires --forge-account or --forge-org")
+	}
+	if flag.forgeURL != "" {
+		if u, err := url.Parse(flag.forgeURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return nil, fmt.Errorf("--forge-url: invalid URL: %s", flag.forgeURL)
+		}
+	}
+	if flag.forgeCache <= 0 {
+		return nil, errors.New("--forge-cache must be positive")
+	}
+	provider := &forgekeys.Provider{
+		Forge:         flag.forgeKeys,
+		URL:           flag.forgeURL,
+		Org:           flag.forgeOrg,
+		Client:        &http.Client{Timeout: auth.DefaultAuthorizedKeysTimeout},
+		CacheDuration: flag.forgeCache,
+		FailOpen:      flag.forgeFailOpen,
+		Logger:        logger,
+	}
+	for _, spec := range flag.forgeAccounts {
+		user, account, found := strings.Cut(spec, ":")
+		if !found {
+			account = user
+		}
+		if user == "" || account == "" {
+			return nil, fmt.Errorf("--forge-account: invalid account: %s", spec)
+		}
+		if provider.Accounts == nil {
+			provider.Accounts = map[string]string{}
+		}
+		provider.Accounts[user] = account
+	}
+	if flag.forgeTokenFile != "" {
+		token, err := readSecretFile(flag.forgeTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		provider.Token = string(token)
+	}
+	return provider, nil
+}
@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthorizedKeysURL(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/keys/john" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+	}))
+	defer httpServer.Close()
+
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "", "--authorized-keys-url", httpServer.URL + "/keys/%u"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	dial := func(user string) (*ssh.Client, error) {
+		return ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+	client, err := dial("john")
+	require.NoError(t, err)
+	defer client.Close()
+	assertExec(t, client)
+	_, err = dial("alex")
+	assert.Error(t, err)
+}
+
+func TestAuthorizedKeysURLInvalid(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--authorized-keys-url", "ftp://keys.example.com/%u"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--authorized-keys-url: invalid URL: ftp://keys.example.com/%u")
+}
+
+func TestForgeKeys(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/octocat.keys", "/hubot.keys":
+			w.Write(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+		case "/api/v3/orgs/acme/members/octocat":
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer httpServer.Close()
+
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "", "--forge-keys", "github", "--forge-url", httpServer.URL,
+		"--forge-account", "john:octocat", "--forge-account", "hubot", "--forge-org", "acme"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	dial := func(user string) (*ssh.Client, error) {
+		return ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+	}
+	client, err := dial("john")
+	require.NoError(t, err)
+	defer client.Close()
+	assertExec(t, client)
+	// Not a member of the organization
+	_, err = dial("hubot")
+	assert.Error(t, err)
+	// Not mapped
+	_, err = dial("octocat")
+	assert.Error(t, err)
+}
+
+func TestForgeKeysInvalid(t *testing.T) {
+	for _, c := range []struct {
+		args []string
+		err  string
+	}{
+		{args: []string{"--forge-keys", "bitbucket", "--forge-org", "acme"}, err: `--forge-keys must be "github" or "gitlab": bitbucket`},
+		{args: []string{"--forge-keys", "github"}, err: "--forge-keys requires --forge-account or --forge-org"},
+		{args: []string{"--forge-keys", "gitlab", "--forge-account", "john:"}, err: "--forge-account: invalid account: john:"},
+	} {
+		rootCmd := RootCmd()
+		rootCmd.SetArgs(append([]string{"--port", strconv.Itoa(getAvailableTcpPort())}, c.args...))
+		rootCmd.SetOut(&bytes.Buffer{})
+		rootCmd.SetErr(&bytes.Buffer{})
+		assert.EqualError(t, rootCmd.Execute(), c.err)
+	}
+}
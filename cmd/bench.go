@@ -0,0 +1,340 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+type benchOptions struct {
+	selftestOptions
+	scenarios   []string
+	concurrency int
+	duration    time.Duration
+	command     string
+	tunnelSize  string
+}
+
+// benchScenario is an operation repeated by concurrent workers
+type benchScenario struct {
+	name string
+	// newWorker prepares a worker and returns its operation and the function to release it.
+	// The operation returns the number of bytes it transferred.
+	newWorker func(b *bencher) (op func() (int64, error), release func(), err error)
+	// connects is true if each operation has its own connection, so the worker continues after errors
+	connects bool
+}
+
+var benchScenarios = []benchScenario{
+	{name: "handshake", newWorker: benchHandshake, connects: true},
+	{name: "session", newWorker: benchSession},
+	{name: "exec", newWorker: benchExec},
+	{name: "tunnel", newWorker: benchTunnel},
+}
+
+// bencher connects to the target of the benchmark
+type bencher struct {
+	address string
+	config  *ssh.ClientConfig
+	command string
+	// tunnelSize is the number of bytes sent and received back by an operation of "tunnel"
+	tunnelSize int
+}
+
+// benchResult is the result of a scenario
+type benchResult struct {
+	latencies []time.Duration
+	bytes     int64
+	errors    int
+	err       error
+	elapsed   time.Duration
+}
+
+func benchCmd(flag *flagType) *cobra.Command {
+	var opts benchOptions
+	cmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Load-test a server with concurrent handshakes, sessions, execs and tunnels",
+		Long: `Load-test a server with concurrent handshakes, sessions, execs and tunnels, reporting the throughput and the latency percentiles of each scenario.
+Each scenario runs for --duration by --concurrency workers, each with its own connection except for "handshake":
+  handshake  connect, authenticate and disconnect
+  session    open and close a session channel
+  exec       execute --command
+  tunnel     send --tunnel-size bytes through a local forward to a remote forward echoing them back
+Without --target, an ephemeral server with all permissions and the --shell listens on 127.0.0.1 in the same process.`,
+		Example: `go-sshd bench
+go-sshd bench --scenario exec,tunnel --concurrency 64 --duration 30s
+go-sshd bench --target ssh.example.com:2222 --login john -i ~/.ssh/id_ed25519`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.ephemeralShell = flag.sshShell
+			opts.stdin = cmd.InOrStdin()
+			return runBench(cmd.OutOrStdout(), &opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.target, "target", "", "", "address of a running server to load-test (e.g. 127.0.0.1:2222) instead of an ephemeral one")
+	cmd.Flags().StringVarP(&opts.login, "login", "l", "", "user to log in to --target")
+	cmd.Flags().StringArrayVarP(&opts.identities, "identity", "i", nil, "private key file to authenticate to --target")
+	cmd.Flags().BoolVarP(&opts.passwordStdin, "password-stdin", "", false, "read the password for --target from the first line of stdin")
+	cmd.Flags().StringVarP(&opts.hostKeyFingerprint, "host-key-fingerprint", "", "", "expected SHA256 fingerprint of the host key of --target (default: any)")
+	cmd.Flags().DurationVarP(&opts.timeout, "timeout", "", 30*time.Second, "time limit of connecting")
+	cmd.Flags().StringSliceVarP(&opts.scenarios, "scenario", "", []string{"handshake", "session", "exec", "tunnel"}, "scenarios to run in order")
+	cmd.Flags().IntVarP(&opts.concurrency, "concurrency", "c", 8, "number of concurrent workers")
+	cmd.Flags().DurationVarP(&opts.duration, "duration", "d", 5*time.Second, "time to run each scenario")
+	cmd.Flags().StringVarP(&opts.command, "command", "", "true", "command of the exec scenario")
+	cmd.Flags().StringVarP(&opts.tunnelSize, "tunnel-size", "", "64K", `bytes sent by an operation of the tunnel scenario with "K" or "M"`)
+	return cmd
+}
+
+func runBench(out io.Writer, opts *benchOptions) error {
+	var scenarios []benchScenario
+	for _, name := range opts.scenarios {
+		scenario, ok := findBenchScenario(name)
+		if !ok {
+			return fmt.Errorf("unknown scenario: %s", name)
+		}
+		scenarios = append(scenarios, scenario)
+	}
+	if opts.concurrency < 1 {
+		return fmt.Errorf("--concurrency must be positive: %d", opts.concurrency)
+	}
+	tunnelSize, err := parseByteSize(opts.tunnelSize)
+	if err != nil || tunnelSize == 0 || tunnelSize > 1<<30 {
+		return fmt.Errorf("--tunnel-size must be from 1 to 1G: %s", opts.tunnelSize)
+	}
+	token, err := selftestToken()
+	if err != nil {
+		return err
+	}
+	address, config, stop, err := targetConfig(&opts.selftestOptions, token)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	b := &bencher{address: address, config: config, command: opts.command, tunnelSize: int(tunnelSize)}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SCENARIO\tOPS\tERRORS\tOPS/S\tP50\tP90\tP99\tMAX\tMB/S")
+	failures := 0
+	var errs []string
+	for _, scenario := range scenarios {
+		result := runBenchScenario(b, scenario, opts.concurrency, opts.duration)
+		fmt.Fprintln(w, result.row(scenario.name))
+		if len(result.latencies) == 0 {
+			failures++
+		}
+		if result.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", scenario.name, result.err))
+		}
+	}
+	w.Flush()
+	for _, e := range errs {
+		fmt.Fprintln(out, e)
+	}
+	if failures != 0 {
+		return fmt.Errorf("%d of %d scenarios failed", failures, len(scenarios))
+	}
+	return nil
+}
+
+func findBenchScenario(name string) (benchScenario, bool) {
+	for _, scenario := range benchScenarios {
+		if scenario.name == name {
+			return scenario, true
+		}
+	}
+	return benchScenario{}, false
+}
+
+// runBenchScenario repeats the operation of scenario by concurrency workers for duration after all of them are prepared
+func runBenchScenario(b *bencher, scenario benchScenario, concurrency int, duration time.Duration) *benchResult {
+	result := &benchResult{}
+	var mu sync.Mutex
+	record := func(latency time.Duration, n int64, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			result.errors++
+			if result.err == nil {
+				result.err = err
+			}
+			return
+		}
+		result.latencies = append(result.latencies, latency)
+		result.bytes += n
+	}
+	var prepared, done sync.WaitGroup
+	start := make(chan struct{})
+	var deadline time.Time
+	prepared.Add(concurrency)
+	done.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer done.Done()
+			op, release, err := scenario.newWorker(b)
+			prepared.Done()
+			if err != nil {
+				record(0, 0, err)
+				return
+			}
+			defer release()
+			<-start
+			for time.Now().Before(deadline) {
+				opStart := time.Now()
+				n, err := op()
+				record(time.Since(opStart), n, err)
+				if err != nil && !scenario.connects {
+					// The connection of the worker may be broken
+					return
+				}
+			}
+		}()
+	}
+	prepared.Wait()
+	startTime := time.Now()
+	deadline = startTime.Add(duration)
+	close(start)
+	done.Wait()
+	result.elapsed = time.Since(startTime)
+	return result
+}
+
+// row formats the result as a row of the table of runBench
+func (r *benchResult) row(name string) string {
+	n := len(r.latencies)
+	if n == 0 {
+		return fmt.Sprintf("%s\t0\t%d\t-\t-\t-\t-\t-\t-", name, r.errors)
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	percentile := func(p float64) string {
+		i := int(math.Ceil(p*float64(n))) - 1
+		return benchDuration(r.latencies[max(i, 0)])
+	}
+	seconds := r.elapsed.Seconds()
+	throughput := "-"
+	if r.bytes != 0 {
+		throughput = fmt.Sprintf("%.1f", float64(r.bytes)/seconds/1e6)
+	}
+	return fmt.Sprintf("%s\t%d\t%d\t%.1f\t%s\t%s\t%s\t%s\t%s", name, n, r.errors, float64(n)/seconds,
+		percentile(0.5), percentile(0.9), percentile(0.99), benchDuration(r.latencies[n-1]), throughput)
+}
+
+// benchDuration formats d with 3 significant digits at most
+func benchDuration(d time.Duration) string {
+	switch {
+	case d >= time.Second:
+		return d.Round(10 * time.Millisecond).String()
+	case d >= time.Millisecond:
+		return d.Round(10 * time.Microsecond).String()
+	}
+	return d.Round(time.Microsecond).String()
+}
+
+func (b *bencher) dial() (*ssh.Client, error) {
+	return ssh.Dial("tcp", b.address, b.config)
+}
+
+// benchHandshake connects, authenticates and disconnects
+func benchHandshake(b *bencher) (func() (int64, error), func(), error) {
+	return func() (int64, error) {
+		client, err := b.dial()
+		if err != nil {
+			return 0, err
+		}
+		return 0, client.Close()
+	}, func() {}, nil
+}
+
+// benchSession opens and closes a session channel
+func benchSession(b *bencher) (func() (int64, error), func(), error) {
+	client, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	return func() (int64, error) {
+		session, err := client.NewSession()
+		if err != nil {
+			return 0, err
+		}
+		session.Close()
+		return 0, nil
+	}, func() { client.Close() }, nil
+}
+
+// benchExec executes the command
+func benchExec(b *bencher) (func() (int64, error), func(), error) {
+	client, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	return func() (int64, error) {
+		session, err := client.NewSession()
+		if err != nil {
+			return 0, err
+		}
+		defer session.Close()
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		if err := session.Run(b.command); err != nil {
+			return 0, err
+		}
+		return int64(stdout.Len()), nil
+	}, func() { client.Close() }, nil
+}
+
+// benchTunnel sends data through a local forward to a remote forward on the server echoing it back, like selftestForward
+func benchTunnel(b *bencher) (func() (int64, error), func(), error) {
+	client, err := b.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("remote forward: %w", err)
+	}
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("local forward: %w", err)
+	}
+	data := make([]byte, b.tunnelSize)
+	received := make([]byte, b.tunnelSize)
+	return func() (int64, error) {
+			// Written concurrently not to fill the windows of both directions
+			written := make(chan error, 1)
+			go func() {
+				_, err := conn.Write(data)
+				written <- err
+			}()
+			_, err := io.ReadFull(conn, received)
+			if err != nil {
+				// Unblocks the writer
+				conn.Close()
+			}
+			if writeErr := <-written; err == nil {
+				err = writeErr
+			}
+			return 2 * int64(len(data)), err
+		}, func() {
+			conn.Close()
+			ln.Close()
+			client.Close()
+		}, nil
+}
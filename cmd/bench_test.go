@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBench(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"bench", "--duration", "200ms", "--concurrency", "2"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	lines := strings.Split(strings.TrimSuffix(stdoutBuf.String(), "\n"), "\n")
+	require.Len(t, lines, 5)
+	assert.Regexp(t, `^SCENARIO +OPS +ERRORS +OPS/S +P50 +P90 +P99 +MAX +MB/S$`, lines[0])
+	for i, name := range []string{"handshake", "session", "exec", "tunnel"} {
+		assert.Regexp(t, `^`+name+` +[1-9]\d* +0 `, lines[i+1])
+	}
+	assert.Regexp(t, ` -$`, lines[1])
+	assert.Regexp(t, ` \d+\.\d$`, lines[4])
+}
+
+func TestBenchFailure(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"bench", "--duration", "100ms", "--concurrency", "2", "--scenario", "exec", "--command", "false"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "1 of 1 scenarios failed")
+	assert.Regexp(t, `(?m)^exec +0 +2 `, stdoutBuf.String())
+	assert.Contains(t, stdoutBuf.String(), "exec: Process exited with status 1")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"bench", "--scenario", "ssh"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "unknown scenario: ssh")
+}
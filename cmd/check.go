@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/vsock"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+func checkCmd(flag *flagType, allPermissionFlags []permissionFlagType) *cobra.Command {
+	return &cobra.Command{
+		Use:   "check",
+		Short: "Check the settings without starting servers",
+		Long:  "Check the settings, host keys, authorized keys, the user store and whether listen addresses are available without starting servers",
+		Example: `go-sshd check --config go-sshd.yaml
+go-sshd -t --sshd-config /etc/ssh/sshd_config`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCheck(cmd.OutOrStdout(), flag, allPermissionFlags)
+		},
+	}
+}
+
+func runCheck(w io.Writer, flag *flagType, allPermissionFlags []permissionFlagType) error {
+	if err := checkConfigs(slog.Default(), flag, allPermissionFlags); err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "configuration OK")
+	return nil
+}
+
+// checkConfigs returns all errors found in the settings of the servers
+func checkConfigs(logger *slog.Logger, flag *flagType, allPermissionFlags []permissionFlagType) error {
+	configs, err := loadConfigs(logger, flag, allPermissionFlags)
+	if err != nil {
+		return err
+	}
+	// Logs of creating servers are not needed
+	discardLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	var errs []error
+	keys := map[string]bool{}
+	for _, config := range configs {
+		var configErrs []error
+		if _, err := newServer(discardLogger, config.flag, config.allPermissionFlags); err != nil {
+			configErrs = append(configErrs, err)
+		}
+		for _, pattern := range config.flag.authorizedKeysFiles {
+			if err := checkAuthorizedKeysFile(pattern); err != nil {
+				configErrs = append(configErrs, err)
+			}
+		}
+		key := listenKey(config.flag)
+		if keys[key] {
+			configErrs = append(configErrs, fmt.Errorf("duplicate listen address: %s", key))
+		} else if err := checkListen(config.flag); err != nil {
+			configErrs = append(configErrs, fmt.Errorf("can not listen: %w", err))
+		}
+		keys[key] = true
+		for _, err := range configErrs {
+			if config.name != "" {
+				err = fmt.Errorf("server %s: %w", config.name, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// checkAuthorizedKeysFile parses the authorized_keys file of pattern.
+// Patterns depending on users are skipped because the files may not exist for all users.
+func checkAuthorizedKeysFile(pattern string) error {
+	if strings.Contains(pattern, "%") || !filepath.IsAbs(pattern) {
+		return nil
+	}
+	b, err := os.ReadFile(pattern)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+			return fmt.Errorf("%s:%d: %w", pattern, lineNumber, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// checkListen listens on the address of flag and closes it immediately
+func checkListen(flag *flagType) error {
+	var ln net.Listener
+	var err error
+	if flag.vsock != "" {
+		addr, err := vsock.ParseAddr(flag.vsock)
+		if err != nil {
+			return err
+		}
+		ln, err = vsock.Listen(addr)
+		if err != nil {
+			return err
+		}
+	} else if flag.sshUnixSocket == "" {
+		ln, err = net.Listen("tcp", net.JoinHostPort(flag.sshHost, strconv.Itoa(int(flag.sshPort))))
+	} else {
+		ln, err = net.Listen("unix", flag.sshUnixSocket)
+	}
+	if err != nil {
+		return err
+	}
+	return ln.Close()
+}
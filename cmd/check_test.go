@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	require.NoError(t, os.WriteFile(authorizedKeys, []byte("# comment\nssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMNX0P2nu7ETCvam4WVS+thwv+SbZXAdH0iLSoVFQn9D root@vm\n"), 0600))
+
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"check", "-p", "0", "--authorized-keys-file", authorizedKeys, "--authorized-keys-file", "%h/.ssh/authorized_keys"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "configuration OK\n", stdoutBuf.String())
+
+	// -t is the same as check
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"-t", "-p", "0", "-u", "john:mypass"})
+	stdoutBuf.Reset()
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Equal(t, "configuration OK\n", stdoutBuf.String())
+}
+
+func TestCheckErrors(t *testing.T) {
+	dir := t.TempDir()
+	hostKey := filepath.Join(dir, "host_key")
+	require.NoError(t, os.WriteFile(hostKey, []byte("invalid"), 0600))
+	authorizedKeys := filepath.Join(dir, "authorized_keys")
+	require.NoError(t, os.WriteFile(authorizedKeys, []byte("ssh-ed25519 invalid\n"), 0600))
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	port := strconv.Itoa(ln.Addr().(*net.TCPAddr).Port)
+
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"-t", "--host", "127.0.0.1", "-p", port, "--host-key", hostKey, "--authorized-keys-file", authorizedKeys})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	err = rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to parse host key "+hostKey)
+	assert.Contains(t, err.Error(), authorizedKeys+":1:")
+	assert.Contains(t, err.Error(), "can not listen")
+
+	config := filepath.Join(dir, "go-sshd.yaml")
+	require.NoError(t, os.WriteFile(config, []byte(`servers:
+  - name: a
+    port: 0
+    user: ["john:mypass"]
+  - name: b
+    port: 0
+    user: ["john:mypass"]
+`), 0600))
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"check", "--config", config})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	err = rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "server b: duplicate listen address")
+}
@@ -0,0 +1,179 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile is a YAML file of named server profiles.
+// Keys of a profile are the long flag names, e.g.
+//
+//	servers:
+//	  - name: tenant-a
+//	    port: 2222
+//	    user: ["john:mypass"]
+//	    allow-direct-tcpip: true
+//	include: ["conf.d"]
+type configFile struct {
+	Servers []map[string]any `yaml:"servers"`
+	// Include is files and directories of more servers relative to the file, which can have glob patterns.
+	// A directory includes its .yaml and .yml files in the order of their names.
+	Include []string `yaml:"include"`
+}
+
+// maxConfigIncludeDepth is the maximum depth of nested include
+const maxConfigIncludeDepth = 16
+
+// processFlagNames are flags for the process rather than servers
+var processFlagNames = []string{
+	"config", "version", "check", "daemon", "pid-file", "run-as", "pledge", "copy-buffer-size", "control-socket", "metrics-listen", "admin-listen", "admin-grpc-listen", "admin-token-file", "admin-pprof", "tarpit", "tarpit-max-connections", "tarpit-duration", "tarpit-interval", "knock-sequence", "knock-timeout", "knock-spa-port", "knock-spa-key-file", "knock-allow-duration", "max-handshakes", "max-channel-workers", "worker-queue-size", "worker-queue-timeout", "audit-log", "audit-hmac-key-file", "auditd", "traffic-file", "traffic-save-interval",
+	"webhook-url", "webhook-secret-file", "webhook-events", "webhook-auth-failures", "webhook-auth-failures-window", "webhook-large-upload",
+	"login-notify-slack", "login-notify-matrix", "login-notify-matrix-token-file", "login-notify-smtp", "login-notify-smtp-user", "login-notify-smtp-password-file",
+	"login-notify-email-from", "login-notify-email-to", "login-notify-new-address", "login-notify-known-addresses", "login-notify-users", "login-notify-outside-hours", "login-notify-template-file",
+	"log-file", "log-max-size", "log-rotate-interval", "log-max-backups", "log-max-age",
+	"log-format", "log-level", "verbose", "quiet",
+	"syslog", "syslog-facility", "syslog-tag",
+}
+
+// profile is a named server profile
+type profile struct {
+	name string
+	args []string
+}
+
+func loadConfigFile(path string) ([]profile, error) {
+	servers, err := readConfigServers(path, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(servers) == 0 {
+		return nil, fmt.Errorf("no servers in %s", path)
+	}
+	var profiles []profile
+	names := map[string]bool{}
+	for i, server := range servers {
+		name, _ := server["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("name of servers[%d] is required", i)
+		}
+		if names[name] {
+			return nil, fmt.Errorf("duplicate server: %s", name)
+		}
+		names[name] = true
+		profiles = append(profiles, profile{name: name, args: profileArgs(server)})
+	}
+	return profiles, nil
+}
+
+// readConfigServers returns the servers of the config file at path followed by the ones of its includes in order.
+// Glob patterns matching nothing are ignored, e.g. an empty conf.d, but other missing files are errors.
+func readConfigServers(path string, depth int) ([]map[string]any, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config configFile
+	if err := yaml.Unmarshal(b, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	servers := config.Servers
+	if len(config.Include) != 0 && depth >= maxConfigIncludeDepth {
+		return nil, fmt.Errorf("include of %s too deeply nested", path)
+	}
+	for _, pattern := range config.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		paths := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			if paths, err = filepath.Glob(pattern); err != nil {
+				return nil, fmt.Errorf("invalid include of %s: %s", path, pattern)
+			}
+		}
+		for _, p := range paths {
+			files, err := includedConfigFiles(p)
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				included, err := readConfigServers(file, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				servers = append(servers, included...)
+			}
+		}
+	}
+	return servers, nil
+}
+
+// includedConfigFiles returns path, or the .yaml and .yml files in path in the order of their names if it is a directory
+func includedConfigFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return []string{path}, err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if ext := filepath.Ext(entry.Name()); !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// profileArgs converts a profile to command line arguments
+func profileArgs(server map[string]any) []string {
+	var keys []string
+	for key := range server {
+		if key != "name" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	var args []string
+	for _, key := range keys {
+		values, ok := server[key].([]any)
+		if !ok {
+			values = []any{server[key]}
+		}
+		for _, value := range values {
+			if value == nil {
+				value = ""
+			}
+			args = append(args, fmt.Sprintf("--%s=%v", key, value))
+		}
+	}
+	return args
+}
+
+// loadInstanceConfigs loads the settings of all servers in the config file
+func loadInstanceConfigs(path string) ([]instanceConfig, error) {
+	profiles, err := loadConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []instanceConfig
+	for _, p := range profiles {
+		rootCmd, flag, allPermissionFlags := newRootCmd()
+		if err := rootCmd.ParseFlags(p.args); err != nil {
+			return nil, fmt.Errorf("server %s: %w", p.name, err)
+		}
+		for _, name := range processFlagNames {
+			if rootCmd.Flags().Changed(name) {
+				return nil, fmt.Errorf("server %s: %s can not be used in a server", p.name, name)
+			}
+		}
+		configs = append(configs, instanceConfig{name: p.name, flag: flag, allPermissionFlags: allPermissionFlags, flagSet: rootCmd.Flags()})
+	}
+	return configs, nil
+}
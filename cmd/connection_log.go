@@ -0,0 +1,23 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// unsafeFileNameChars are replaced in user names of connection log files
+var unsafeFileNameChars = regexp.MustCompile(`[^A-Za-z0-9._@-]`)
+
+// connectionLogFile returns server.Server.ConnectionLog creating a file per connection in dir,
+// e.g. "20240102T150405Z_john_5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b.log"
+func connectionLogFile(dir string) func(conn *server.ConnMetadata, startTime time.Time) (io.WriteCloser, error) {
+	return func(conn *server.ConnMetadata, startTime time.Time) (io.WriteCloser, error) {
+		name := startTime.UTC().Format("20060102T150405Z") + "_" + unsafeFileNameChars.ReplaceAllString(conn.User(), "_") + "_" + conn.ID + ".log"
+		return os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+}
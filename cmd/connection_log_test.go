@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionLogDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "connections")
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--connection-log-dir", dir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	assertExec(t, client)
+	client.Close()
+
+	var content []byte
+	require.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.log"))
+		if len(files) != 1 {
+			return false
+		}
+		assert.Regexp(t, `^\d{8}T\d{6}Z_john_[0-9a-f-]{36}\.log$`, filepath.Base(files[0]))
+		content, _ = os.ReadFile(files[0])
+		return bytes.Contains(content, []byte(`msg="SSH connection closed"`))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Contains(t, string(content), `msg="new SSH connection"`)
+	info, err := os.Stat(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+}
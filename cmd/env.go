@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// envPrefix is the prefix of environment variables setting flags, e.g. GO_SSHD_ALLOW_EXECUTE for --allow-execute
+const envPrefix = "GO_SSHD_"
+
+// flagEnvName returns the environment variable of the flag
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// applyEnvFlags sets flags of the root command not set on the command line from environment variables.
+// Values of array flags such as --user are separated by newlines, as commas can be in them,
+// and values of slice flags such as --webhook-events are comma-separated like on the command line.
+func applyEnvFlags(cmd *cobra.Command) error {
+	var err error
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || f.Name == "help" {
+			return
+		}
+		// Flags of subcommands are not configurable
+		if cmd != cmd.Root() && cmd.Root().PersistentFlags().Lookup(f.Name) == nil {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok {
+			return
+		}
+		if sliceValue, isSlice := f.Value.(pflag.SliceValue); isSlice && f.Value.Type() == "stringArray" {
+			err = sliceValue.Replace(strings.Split(strings.TrimSuffix(value, "\n"), "\n"))
+		} else {
+			err = f.Value.Set(value)
+		}
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %w", flagEnvName(f.Name), err)
+		}
+	})
+	return err
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvFlags(t *testing.T) {
+	t.Setenv("GO_SSHD_PORT", "2300")
+	t.Setenv("GO_SSHD_USER", "john:mypass\nalex:")
+	t.Setenv("GO_SSHD_ALLOW_SFTP", "true")
+	t.Setenv("GO_SSHD_HOST", "127.0.0.1")
+	rootCmd := RootCmd()
+	// Flags precede environment variables
+	rootCmd.SetArgs([]string{"print-config", "--host", "::1"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	output := stdoutBuf.String()
+	assert.Contains(t, output, "    port: 2300 # env GO_SSHD_PORT\n")
+	assert.Contains(t, output, "    user: ['john:xxxxx', 'alex:'] # env GO_SSHD_USER\n")
+	assert.Contains(t, output, "    allow-sftp: true # env GO_SSHD_ALLOW_SFTP\n")
+	assert.Contains(t, output, "    allow-execute: false # default\n")
+	assert.Contains(t, output, "    host: ::1 # command line\n")
+
+	// Commas are in values of array flags
+	t.Setenv("GO_SSHD_USER", "john:x,y:")
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"print-config"})
+	stdoutBuf.Reset()
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	output = stdoutBuf.String()
+	assert.Contains(t, output, "    user: ['john:xxxxx'] # env GO_SSHD_USER\n")
+
+	t.Setenv("GO_SSHD_PORT", "ssh")
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"print-config"})
+	rootCmd.SetErr(&bytes.Buffer{})
+	err := rootCmd.Execute()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid GO_SSHD_PORT")
+}
@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+func fingerprintCmd(flag *flagType, allPermissionFlags []permissionFlagType) *cobra.Command {
+	return &cobra.Command{
+		Use:   "fingerprint [KEY_FILE...]",
+		Short: "Show fingerprints of host keys",
+		Long:  "Show SHA256 and MD5 fingerprints and the randomart of the given private or public key files, or of the host keys configured by flags",
+		Example: `go-sshd fingerprint host_ed25519.pub
+go-sshd fingerprint --config go-sshd.yaml`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys, err := fingerprintKeys(flag, allPermissionFlags, args)
+			if err != nil {
+				return err
+			}
+			for i, key := range keys {
+				if i != 0 {
+					fmt.Fprintln(cmd.OutOrStdout())
+				}
+				printFingerprint(cmd.OutOrStdout(), key.name, key.publicKey)
+			}
+			return nil
+		},
+	}
+}
+
+type namedPublicKey struct {
+	name      string
+	publicKey ssh.PublicKey
+}
+
+// fingerprintKeys returns the keys of files, or the host keys configured by flag if files are empty
+func fingerprintKeys(flag *flagType, allPermissionFlags []permissionFlagType, files []string) ([]namedPublicKey, error) {
+	if len(files) == 0 {
+		configs, err := loadConfigs(slog.Default(), flag, allPermissionFlags)
+		if err != nil {
+			return nil, err
+		}
+		seen := map[string]bool{}
+		for _, config := range configs {
+			hostKeys := config.flag.hostKeys
+			if len(hostKeys) == 0 {
+				hostKeys = []string{""}
+			}
+			for _, hostKey := range hostKeys {
+				if !seen[hostKey] {
+					seen[hostKey] = true
+					files = append(files, hostKey)
+				}
+			}
+		}
+	}
+	var keys []namedPublicKey
+	for _, file := range files {
+		if file == "" {
+			signer, err := ssh.ParsePrivateKey([]byte(defaultHostKeyPem))
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, namedPublicKey{name: "built-in host key", publicKey: signer.PublicKey()})
+			continue
+		}
+		publicKey, err := readPublicKey(file)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, namedPublicKey{name: file, publicKey: publicKey})
+	}
+	return keys, nil
+}
+
+// readPublicKey reads the public key of a private key file or a public key file
+func readPublicKey(file string) (ssh.PublicKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if signer, err := ssh.ParsePrivateKey(b); err == nil {
+		return signer.PublicKey(), nil
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a private key nor a public key", file)
+	}
+	return publicKey, nil
+}
+
+func printFingerprint(w io.Writer, name string, publicKey ssh.PublicKey) {
+	keyType, bits := keyTypeAndBits(publicKey)
+	fmt.Fprintf(w, "%d %s %s (%s)\n", bits, ssh.FingerprintSHA256(publicKey), name, keyType)
+	fmt.Fprintf(w, "%d MD5:%s %s (%s)\n", bits, ssh.FingerprintLegacyMD5(publicKey), name, keyType)
+	digest := sha256.Sum256(publicKey.Marshal())
+	fmt.Fprint(w, randomart(digest[:], fmt.Sprintf("[%s %d]", keyType, bits), "[SHA256]"))
+}
+
+// keyTypeAndBits returns the key type and size shown by ssh-keygen
+func keyTypeAndBits(publicKey ssh.PublicKey) (string, int) {
+	cryptoPublicKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return strings.ToUpper(publicKey.Type()), 0
+	}
+	switch key := cryptoPublicKey.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		return "ED25519", 256
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	}
+	return strings.ToUpper(publicKey.Type()), 0
+}
+
+// randomart returns the randomart of digest by the drunken bishop algorithm of OpenSSH
+func randomart(digest []byte, title, footer string) string {
+	const (
+		width  = 17
+		height = 9
+	)
+	const symbols = " .o+=*BOX@%&#/^SE"
+	const end = len(symbols) - 1
+	var field [width][height]int
+	x, y := width/2, height/2
+	for _, input := range digest {
+		// Each byte has four 2-bit moves
+		for i := 0; i < 4; i++ {
+			if input&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if input&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = clamp(x, 0, width-1)
+			y = clamp(y, 0, height-1)
+			if field[x][y] < end-2 {
+				field[x][y]++
+			}
+			input >>= 2
+		}
+	}
+	field[width/2][height/2] = end - 1
+	field[x][y] = end
+
+	var b strings.Builder
+	border := func(label string) {
+		if len(label) > width {
+			label = label[:width]
+		}
+		left := (width - len(label)) / 2
+		b.WriteString("+" + strings.Repeat("-", left) + label + strings.Repeat("-", width-left-len(label)) + "+\n")
+	}
+	border(title)
+	for y := 0; y < height; y++ {
+		b.WriteByte('|')
+		for x := 0; x < width; x++ {
+			b.WriteByte(symbols[field[x][y]])
+		}
+		b.WriteString("|\n")
+	}
+	border(footer)
+	return b.String()
+}
+
+func clamp(n, lower, upper int) int {
+	if n < lower {
+		return lower
+	}
+	if n > upper {
+		return upper
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFingerprint(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "host_ed25519.pub")
+	require.NoError(t, os.WriteFile(file, []byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIMNX0P2nu7ETCvam4WVS+thwv+SbZXAdH0iLSoVFQn9D root@vm\n"), 0644))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"fingerprint", file})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	// Same as ssh-keygen -lv
+	assert.Equal(t, `256 SHA256:mM81urjeuJ2bIaYc+9NKDWTMC+IAAWIjZdTPDjb7KWs `+file+` (ED25519)
+256 MD5:6e:80:f9:34:b6:77:fe:dd:5a:48:af:f7:32:1b:87:71 `+file+` (ED25519)
++--[ED25519 256]--+
+|B*+.             |
+|=.. .o           |
+|. . .o=          |
+| o .++o+         |
+|  .. == S o      |
+|    . .= o .     |
+|    ..+o*        |
+|   .E*+*.=       |
+|   .==O=B.       |
++----[SHA256]-----+
+`, stdoutBuf.String())
+}
+
+func TestFingerprintConfigured(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "host_key")
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"keygen", "-f", file})
+	rootCmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, rootCmd.Execute())
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"fingerprint", "--host-key", file})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, strings.Split(stdoutBuf.String(), "\n")[0], file+" (ED25519)")
+
+	// The built-in key without --host-key
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"fingerprint"})
+	stdoutBuf.Reset()
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, strings.Split(stdoutBuf.String(), "\n")[0], "built-in host key (RSA)")
+}
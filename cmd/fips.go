@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// fipsBuild is true in binaries built with the fips tag, which are always in FIPS mode
+var fipsBuild bool
+
+// checkFIPS returns an error if FIPS mode is requested by --fips or the build, but the Go Cryptographic Module is not in FIPS mode.
+// It returns whether FIPS mode is requested.
+func checkFIPS(flag *flagType) (bool, error) {
+	if !flag.fips && !fipsBuild {
+		return false, nil
+	}
+	if !server.FIPSEnabled() {
+		return false, errors.New("FIPS mode requires the Go Cryptographic Module in FIPS 140-3 mode (Go 1.24 or later with GODEBUG=fips140=on or built with GOFIPS140)")
+	}
+	return true, nil
+}
@@ -0,0 +1,7 @@
+//go:build fips
+
+package cmd
+
+func init() {
+	fipsBuild = true
+}
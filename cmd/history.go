@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/John-Ao/go-sshd/server"
+	"golang.org/x/exp/slog"
+)
+
+// commandHistoryFile returns server.Server.CommandHistory appending entries to a file per user in dir, e.g. "john.history".
+// Each line is the time, the session ID, the client address, the type and the command, which is quoted if it has control characters.
+func commandHistoryFile(logger *slog.Logger, dir string) func(conn *server.ConnMetadata, entry server.HistoryEntry) {
+	var mu sync.Mutex
+	return func(conn *server.ConnMetadata, entry server.HistoryEntry) {
+		command := entry.Command
+		if strings.ContainsFunc(command, unicode.IsControl) {
+			command = strconv.Quote(command)
+		}
+		line := fmt.Sprintf("%s %s %s %s %s\n", entry.Time.UTC().Format(time.RFC3339), entry.SessionID, conn.RemoteAddr(), entry.Type, command)
+		path := filepath.Join(dir, unsafeFileNameChars.ReplaceAllString(conn.User(), "_")+".history")
+		mu.Lock()
+		defer mu.Unlock()
+		// Opened for each entry so that the files can be rotated
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			logger.Error("failed to open command history", "path", path, "err", err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.WriteString(line); err != nil {
+			logger.Error("failed to write command history", "path", path, "err", err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandHistoryDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "history")
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--command-history-dir", dir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+	assertExec(t, client)
+
+	var content []byte
+	require.Eventually(t, func() bool {
+		content, _ = os.ReadFile(filepath.Join(dir, "john.history"))
+		return len(content) != 0
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Regexp(t, `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z [0-9a-f-]{36}/\d+ 127\.0\.0\.1:\d+ exec whoami\n$`, string(content))
+}
+
+func TestCommandHistoryShellInputWithoutDir(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--command-history-shell-input"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--command-history-shell-input requires --command-history-dir")
+}
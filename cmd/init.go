@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/userstore"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/yaml.v3"
+)
+
+// Files written by the init command
+const (
+	initHostKeyFile   = "host_ed25519"
+	initUserStoreFile = "users.yaml"
+	initConfigFile    = "go-sshd.yaml"
+)
+
+func initCmd() *cobra.Command {
+	var dir string
+	var force bool
+	initCmd := cobra.Command{
+		Use:   "init",
+		Short: "Set up a host key, a first user and a config file interactively",
+		Long: `Set up a host key, a first user with an authorized key and a config file interactively.
+Empty answers take the defaults in brackets.`,
+		Example: `go-sshd init --dir /etc/go-sshd`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInit(cmd.InOrStdin(), cmd.OutOrStdout(), dir, force)
+		},
+	}
+	initCmd.Flags().StringVarP(&dir, "dir", "", ".", "directory to write the files")
+	initCmd.Flags().BoolVarP(&force, "force", "", false, "overwrite existing files")
+	return &initCmd
+}
+
+func runInit(stdin io.Reader, w io.Writer, dir string, force bool) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(stdin)
+	ask := func(question, defaultValue string) (string, error) {
+		fmt.Fprintf(w, "%s [%s]: ", question, defaultValue)
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			return line, nil
+		}
+		return defaultValue, nil
+	}
+
+	portString, err := ask("Port", "2222")
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portString, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid port: %s", portString)
+	}
+	defaultUser := os.Getenv("USER")
+	if defaultUser == "" {
+		defaultUser = "john"
+	}
+	userName, err := ask("User name", defaultUser)
+	if err != nil {
+		return err
+	}
+	defaultKey := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		for _, name := range []string{"id_ed25519.pub", "id_ecdsa.pub", "id_rsa.pub"} {
+			if path := filepath.Join(home, ".ssh", name); fileExists(path) {
+				defaultKey = path
+				break
+			}
+		}
+	}
+	keyAnswer, err := ask("Public key of the user (file or authorized_keys line)", defaultKey)
+	if err != nil {
+		return err
+	}
+	authorizedKey, err := readAuthorizedKey(keyAnswer)
+	if err != nil {
+		return err
+	}
+
+	hostKey, err := generateKey("ed25519", 0)
+	if err != nil {
+		return err
+	}
+	block, err := ssh.MarshalPrivateKey(hostKey, "")
+	if err != nil {
+		return err
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return err
+	}
+	hostKeyPath := filepath.Join(dir, initHostKeyFile)
+	userStorePath := filepath.Join(dir, initUserStoreFile)
+	configPath := filepath.Join(dir, initConfigFile)
+	users, err := yaml.Marshal(map[string][]userstore.User{
+		"users": {{Name: userName, AuthorizedKeys: []string{authorizedKey}}},
+	})
+	if err != nil {
+		return err
+	}
+	config, err := yaml.Marshal(map[string][]map[string]any{
+		"servers": {{"name": "default", "port": port, "host-key": []string{hostKeyPath}, "user-store": userStorePath}},
+	})
+	if err != nil {
+		return err
+	}
+	files := []struct {
+		path string
+		data []byte
+		perm os.FileMode
+	}{
+		{path: hostKeyPath, data: pem.EncodeToMemory(block), perm: 0600},
+		{path: hostKeyPath + ".pub", data: ssh.MarshalAuthorizedKey(hostSigner.PublicKey()), perm: 0644},
+		{path: userStorePath, data: users, perm: 0600},
+		{path: configPath, data: config, perm: 0644},
+	}
+	// Not to leave some of the files
+	for _, file := range files {
+		if _, err := os.Stat(file.path); err == nil && !force {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", file.path)
+		}
+	}
+	for _, file := range files {
+		if err := writeNewFile(file.path, file.data, file.perm, force); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "wrote %s\n", file.path)
+	}
+	fmt.Fprintf(w, `
+Host key fingerprint: %s
+
+Start the server:
+  go-sshd --config %s
+
+Connect:
+  ssh -p %d %s@localhost
+`, ssh.FingerprintSHA256(hostSigner.PublicKey()), configPath, port, userName)
+	return nil
+}
+
+// readAuthorizedKey returns the authorized_keys line of answer, which is a public key file or the line itself
+func readAuthorizedKey(answer string) (string, error) {
+	if answer == "" {
+		return "", fmt.Errorf("public key required")
+	}
+	line := answer
+	if fileExists(answer) {
+		b, err := os.ReadFile(answer)
+		if err != nil {
+			return "", err
+		}
+		line, _, _ = strings.Cut(strings.TrimSpace(string(b)), "\n")
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+		return "", fmt.Errorf("invalid public key: %w", err)
+	}
+	return line, nil
+}
+
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/userstore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+func TestInit(t *testing.T) {
+	dir := t.TempDir()
+	publicKey, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPublicKey, err := ssh.NewPublicKey(publicKey)
+	require.NoError(t, err)
+	keyPath := filepath.Join(dir, "id_ed25519.pub")
+	require.NoError(t, os.WriteFile(keyPath, ssh.MarshalAuthorizedKey(sshPublicKey), 0644))
+
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"init", "--dir", dir})
+	rootCmd.SetIn(strings.NewReader("2200\nalex\n" + keyPath + "\n"))
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	assert.Contains(t, stdoutBuf.String(), "go-sshd --config "+filepath.Join(dir, "go-sshd.yaml")+"\n")
+	assert.Contains(t, stdoutBuf.String(), "ssh -p 2200 alex@localhost\n")
+
+	configs, err := loadInstanceConfigs(filepath.Join(dir, "go-sshd.yaml"))
+	require.NoError(t, err)
+	require.Len(t, configs, 1)
+	assert.Equal(t, uint16(2200), configs[0].flag.sshPort)
+	_, err = newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), configs[0].flag, configs[0].allPermissionFlags)
+	assert.NoError(t, err)
+	store, err := userstore.LoadFile(filepath.Join(dir, "users.yaml"))
+	require.NoError(t, err)
+	user, err := store.Lookup("alex")
+	require.NoError(t, err)
+	assert.Equal(t, []string{strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPublicKey)))}, user.AuthorizedKeys)
+
+	// Existing files are not overwritten
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"init", "--dir", dir})
+	rootCmd.SetIn(strings.NewReader("\n\n" + keyPath + "\n"))
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.ErrorContains(t, rootCmd.Execute(), "already exists")
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+func keygenCmd() *cobra.Command {
+	var keyType string
+	var bits int
+	var file string
+	var comment string
+	var force bool
+	keygenCmd := cobra.Command{
+		Use:   "keygen",
+		Short: "Generate a host key",
+		Example: `# Generate an ed25519 host key and its public key to host_ed25519.pub
+go-sshd keygen -f host_ed25519
+
+# Generate a 4096-bit RSA host key
+go-sshd keygen --type rsa -b 4096 -f host_rsa`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := generateKey(keyType, bits)
+			if err != nil {
+				return err
+			}
+			block, err := ssh.MarshalPrivateKey(key, comment)
+			if err != nil {
+				return err
+			}
+			signer, err := ssh.NewSignerFromKey(key)
+			if err != nil {
+				return err
+			}
+			publicKey := ssh.MarshalAuthorizedKey(signer.PublicKey())
+			if comment != "" {
+				publicKey = append(publicKey[:len(publicKey)-1], []byte(" "+comment+"\n")...)
+			}
+			if file == "" {
+				cmd.OutOrStdout().Write(pem.EncodeToMemory(block))
+				cmd.OutOrStdout().Write(publicKey)
+				return nil
+			}
+			if err := writeNewFile(file, pem.EncodeToMemory(block), 0600, force); err != nil {
+				return err
+			}
+			if err := writeNewFile(file+".pub", publicKey, 0644, force); err != nil {
+				return err
+			}
+			cmd.OutOrStdout().Write(publicKey)
+			return nil
+		},
+	}
+	keygenCmd.Flags().StringVarP(&keyType, "type", "", "ed25519", `key type: "ed25519", "ecdsa" or "rsa"`)
+	keygenCmd.Flags().IntVarP(&bits, "bits", "b", 0, "key size: 256, 384 or 521 for ecdsa (default 256), 2048 or more for rsa (default 3072)")
+	keygenCmd.Flags().StringVarP(&file, "file", "f", "", "private key file to write with the public key to FILE.pub (default: stdout)")
+	keygenCmd.Flags().StringVarP(&comment, "comment", "C", "", "comment of the key")
+	keygenCmd.Flags().BoolVarP(&force, "force", "", false, "overwrite existing files")
+	return &keygenCmd
+}
+
+// generateKey generates a private key of keyType. bits is the default of keyType if 0.
+func generateKey(keyType string, bits int) (crypto.Signer, error) {
+	switch keyType {
+	case "ed25519":
+		if bits != 0 {
+			return nil, fmt.Errorf("bits can not be specified for ed25519")
+		}
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	case "ecdsa":
+		var curve elliptic.Curve
+		switch bits {
+		case 0, 256:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("invalid ecdsa key size: %d", bits)
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case "rsa":
+		if bits == 0 {
+			bits = 3072
+		}
+		if bits < 2048 {
+			return nil, fmt.Errorf("rsa key size must be 2048 or more: %d", bits)
+		}
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+	return nil, fmt.Errorf("unknown key type: %s", keyType)
+}
+
+// writeNewFile writes the file. It fails if the file exists unless force.
+func writeNewFile(path string, data []byte, perm os.FileMode, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, perm)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists (use --force to overwrite)", path)
+		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeygen(t *testing.T) {
+	for _, c := range []struct {
+		args    []string
+		keyType string
+	}{
+		{args: nil, keyType: ssh.KeyAlgoED25519},
+		{args: []string{"--type", "ecdsa", "-b", "384"}, keyType: ssh.KeyAlgoECDSA384},
+		{args: []string{"--type", "rsa", "-b", "2048"}, keyType: ssh.KeyAlgoRSA},
+	} {
+		file := filepath.Join(t.TempDir(), "host_key")
+		rootCmd := RootCmd()
+		rootCmd.SetArgs(append([]string{"keygen", "-f", file, "-C", "test"}, c.args...))
+		var stdoutBuf bytes.Buffer
+		rootCmd.SetOut(&stdoutBuf)
+		require.NoError(t, rootCmd.Execute())
+
+		keyBytes, err := os.ReadFile(file)
+		require.NoError(t, err)
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		require.NoError(t, err)
+		assert.Equal(t, c.keyType, signer.PublicKey().Type())
+		publicKeyBytes, err := os.ReadFile(file + ".pub")
+		require.NoError(t, err)
+		assert.Equal(t, stdoutBuf.String(), string(publicKeyBytes))
+		publicKey, comment, _, _, err := ssh.ParseAuthorizedKey(publicKeyBytes)
+		require.NoError(t, err)
+		assert.Equal(t, signer.PublicKey().Marshal(), publicKey.Marshal())
+		assert.Equal(t, "test", comment)
+		info, err := os.Stat(file)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+}
+
+func TestKeygenExists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "host_key")
+	require.NoError(t, os.WriteFile(file, []byte("existing"), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"keygen", "-f", file})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), file+" already exists (use --force to overwrite)")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"keygen", "-f", file, "--force"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	require.NoError(t, rootCmd.Execute())
+	keyBytes, err := os.ReadFile(file)
+	require.NoError(t, err)
+	_, err = ssh.ParsePrivateKey(keyBytes)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"time"
+
+	"github.com/John-Ao/go-sshd/knock"
+	"github.com/spf13/cobra"
+	"golang.org/x/exp/slog"
+)
+
+// newKnockGate returns the gate of --knock-sequence and --knock-spa-port. It returns nil if neither is specified.
+func newKnockGate(logger *slog.Logger, flag *flagType) (*knock.Gate, error) {
+	if (flag.knockSPAPort == 0) != (flag.knockSPAKeyFile == "") {
+		return nil, errors.New("--knock-spa-port and --knock-spa-key-file require each other")
+	}
+	if len(flag.knockSequence) == 0 && flag.knockSPAPort == 0 {
+		return nil, nil
+	}
+	for _, port := range flag.knockSequence {
+		if port < 1 || port > 65535 {
+			return nil, fmt.Errorf("invalid port of --knock-sequence: %d", port)
+		}
+	}
+	if flag.knockTimeout <= 0 || flag.knockAllowDuration <= 0 {
+		return nil, errors.New("--knock-timeout and --knock-allow-duration must be positive")
+	}
+	gate := &knock.Gate{
+		Sequence:      flag.knockSequence,
+		Timeout:       flag.knockTimeout,
+		AllowDuration: flag.knockAllowDuration,
+		OnAllow: func(addr netip.Addr, method string) {
+			logger.Info("allowed address by knock", "remote_address", addr.String(), "method", method)
+		},
+	}
+	if flag.knockSPAKeyFile != "" {
+		key, err := readSecretFile(flag.knockSPAKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		gate.Key = key
+	}
+	return gate, nil
+}
+
+// serveKnock listens on the ports of the knock gate by upgrader, so that they are passed by upgrades. stop closes them.
+func (sup *supervisor) serveKnock() (stop func(), err error) {
+	var closers []io.Closer
+	stop = func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	listened := map[int]bool{}
+	for _, port := range sup.knock.Sequence {
+		if listened[port] {
+			continue
+		}
+		listened[port] = true
+		ln, err := sup.upgrader.Listen("tcp", net.JoinHostPort(sup.knockHost, strconv.Itoa(port)))
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		closers = append(closers, ln)
+		go sup.knock.ServeKnocks(ln, port)
+	}
+	if sup.knockSPAPort != 0 {
+		pc, err := sup.upgrader.ListenPacket("udp", net.JoinHostPort(sup.knockHost, strconv.Itoa(int(sup.knockSPAPort))))
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		closers = append(closers, pc)
+		go sup.knock.ServeSPA(pc)
+	}
+	sup.logger.Info("gating connections by knocks", "sequence_ports", len(sup.knock.Sequence), "spa_port", sup.knockSPAPort)
+	return stop, nil
+}
+
+type knockOptions struct {
+	sequence   []int
+	spaPort    uint16
+	spaKeyFile string
+	timeout    time.Duration
+}
+
+func knockCmd() *cobra.Command {
+	var opts knockOptions
+	cmd := &cobra.Command{
+		Use:   "knock HOST",
+		Short: "Knock on a server gated by --knock-sequence or --knock-spa-port to be allowed to connect",
+		Long: `Knock on a server gated by --knock-sequence or --knock-spa-port to be allowed to connect for its --knock-allow-duration.
+With --sequence, it connects to the ports in order, each of which is reset by the server.
+With --spa-port, it sends a single packet signed by the key of --spa-key-file.`,
+		Example: `go-sshd knock ssh.example.com --sequence 7000,8000,9000 && ssh -p 2222 john@ssh.example.com
+go-sshd knock ssh.example.com --spa-port 62201 --spa-key-file spa.key`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runKnock(args[0], &opts)
+		},
+	}
+	cmd.Flags().IntSliceVarP(&opts.sequence, "sequence", "", nil, "TCP ports to connect to in order")
+	cmd.Flags().Uint16VarP(&opts.spaPort, "spa-port", "", 0, "UDP port to send a single packet authorization to")
+	cmd.Flags().StringVarP(&opts.spaKeyFile, "spa-key-file", "", "", "file of the key to sign the packet of --spa-port")
+	cmd.Flags().DurationVarP(&opts.timeout, "timeout", "", 5*time.Second, "time limit of each knock")
+	return cmd
+}
+
+func runKnock(host string, opts *knockOptions) error {
+	if (opts.spaPort == 0) != (opts.spaKeyFile == "") {
+		return errors.New("--spa-port and --spa-key-file require each other")
+	}
+	if len(opts.sequence) == 0 && opts.spaPort == 0 {
+		return errors.New("--sequence or --spa-port is required")
+	}
+	for _, port := range opts.sequence {
+		if err := knockPort(net.JoinHostPort(host, strconv.Itoa(port)), opts.timeout); err != nil {
+			return err
+		}
+	}
+	if opts.spaPort == 0 {
+		return nil
+	}
+	key, err := readSecretFile(opts.spaKeyFile)
+	if err != nil {
+		return err
+	}
+	packet, err := knock.NewSPAPacket(key, time.Now())
+	if err != nil {
+		return err
+	}
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(host, strconv.Itoa(int(opts.spaPort))), opts.timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	return err
+}
+
+// knockPort connects to address and waits for the reset, so that the server records the knock before the next one
+func knockPort(address string, timeout time.Duration) error {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Timeout() {
+			return fmt.Errorf("failed to knock on %s: %w", address, err)
+		}
+		// Refused or reset by the server
+		return nil
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	_, err = io.Copy(io.Discard, conn)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return fmt.Errorf("failed to knock on %s: not reset by the server", address)
+	}
+	return nil
+}
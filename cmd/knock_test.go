@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnock(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "spa.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	knockPorts := []string{strconv.Itoa(getAvailableTcpPort()), strconv.Itoa(getAvailableTcpPort())}
+	spaPort := strconv.Itoa(getAvailableTcpPort())
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--knock-sequence", knockPorts[0] + "," + knockPorts[1],
+		"--knock-spa-port", spaPort, "--knock-spa-key-file", keyFile, "--knock-allow-duration", "1s"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+
+	// Reset without knocks
+	_, err := dialPassword(port, "john", "mypass")
+	assert.Error(t, err)
+	knock := func(args ...string) bool {
+		knockCmd := RootCmd()
+		knockCmd.SetArgs(append([]string{"knock", "127.0.0.1"}, args...))
+		knockCmd.SetOut(&bytes.Buffer{})
+		knockCmd.SetErr(&bytes.Buffer{})
+		if knockCmd.Execute() != nil {
+			return false
+		}
+		client, err := dialPassword(port, "john", "mypass")
+		if err != nil {
+			return false
+		}
+		client.Close()
+		return true
+	}
+	assert.Eventually(t, func() bool { return knock("--sequence", knockPorts[0]+","+knockPorts[1]) }, 5*time.Second, 50*time.Millisecond)
+	// Expired after --knock-allow-duration
+	assert.Eventually(t, func() bool {
+		_, err := dialPassword(port, "john", "mypass")
+		return err != nil
+	}, 5*time.Second, 50*time.Millisecond)
+	assert.Eventually(t, func() bool { return knock("--spa-port", spaPort, "--spa-key-file", keyFile) }, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestInvalidKnock(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--knock-spa-port", "62201"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--knock-spa-port and --knock-spa-key-file require each other")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--knock-sequence", "7000,70000"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "invalid port of --knock-sequence: 70000")
+}
@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/John-Ao/go-sshd/logfile"
+
+	"golang.org/x/exp/slog"
+)
+
+// Log formats
+const (
+	// logFormatText is the format of the standard log package, e.g. `2024/01/02 15:04:05 INFO new SSH connection user=john`
+	logFormatText = "text"
+	// logFormatJSON is a JSON object per line, e.g. `{"time":"2024-01-02T15:04:05.000000000+09:00","level":"INFO","msg":"new SSH connection","user":"john"}`
+	logFormatJSON = "json"
+)
+
+// logLevel returns the level of --log-level changed by -v and -q
+func logLevel(flag *flagType) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(flag.logLevel)); err != nil {
+		return 0, fmt.Errorf("invalid log level: %s (debug, info, warn or error)", flag.logLevel)
+	}
+	// A -v or -q changes the level by one of debug, info, warn and error
+	return level + slog.Level(4*(flag.quiet-flag.verbose)), nil
+}
+
+// setupLog sets the default logger by flag and returns the function to restore the previous one
+func setupLog(flag *flagType) (func(), error) {
+	if flag.logFormat != logFormatText && flag.logFormat != logFormatJSON {
+		return nil, fmt.Errorf("invalid log format: %s (text or json)", flag.logFormat)
+	}
+	if flag.syslog != "" && flag.logFile != "" {
+		return nil, fmt.Errorf("--syslog and --log-file can not be used together")
+	}
+	level, err := logLevel(flag)
+	if err != nil {
+		return nil, err
+	}
+	// -vv shows the source of logs
+	opts := &slog.HandlerOptions{Level: level, AddSource: flag.verbose >= 2}
+	prevLogger := slog.Default()
+	prevWriter := log.Writer()
+	prevFlags := log.Flags()
+	var closers []io.Closer
+	restore := func() {
+		// SetDefault redirects the log package to the handler, which is not undone by SetDefault of the default handler
+		slog.SetDefault(prevLogger)
+		log.SetOutput(prevWriter)
+		log.SetFlags(prevFlags)
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+	w := prevWriter
+	if flag.logFile != "" {
+		file := &logfile.Writer{
+			Path:       flag.logFile,
+			MaxSize:    flag.logMaxSize * 1024 * 1024,
+			Interval:   flag.logRotateInterval,
+			MaxBackups: flag.logMaxBackups,
+			MaxAge:     flag.logMaxAge,
+		}
+		if err := file.Open(); err != nil {
+			return nil, err
+		}
+		closers = append(closers, file)
+		w = file
+		log.SetOutput(w)
+	}
+	var handler slog.Handler
+	switch {
+	case flag.syslog != "":
+		sw, err := dialSyslog(flag.syslog, flag.syslogFacility, flag.syslogTag)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		closers = append(closers, sw)
+		handler = newSyslogHandler(sw, flag.logFormat, opts)
+	case flag.logFormat == logFormatJSON:
+		handler = slog.NewJSONHandler(w, opts)
+	case level != slog.LevelInfo || opts.AddSource:
+		// The default handler can not change the level
+		handler = newTextHandler(w, opts)
+	}
+	if handler != nil {
+		slog.SetDefault(slog.New(handler))
+	}
+	return restore, nil
+}
+
+// textHandler writes entries in the format of the default handler, logFormatText
+type textHandler struct {
+	// Handler writes the attributes to w
+	slog.Handler
+	w *textWriter
+}
+
+// textWriter writes the attributes formatted by textHandler after the header of the entry
+type textWriter struct {
+	mu     sync.Mutex
+	header []byte
+	w      io.Writer
+}
+
+func (tw *textWriter) Write(p []byte) (int, error) {
+	line := tw.header
+	if len(p) > 1 {
+		line = append(line, ' ')
+	}
+	line = append(line, p...)
+	if _, err := tw.w.Write(line); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func newTextHandler(w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	tw := &textWriter{w: w}
+	textOpts := *opts
+	textOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		// They are in the header
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+			return slog.Attr{}
+		}
+		return a
+	}
+	return &textHandler{Handler: slog.NewTextHandler(tw, &textOpts), w: tw}
+}
+
+func (h *textHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	header := r.Time.Format("2006/01/02 15:04:05") + " " + r.Level.String() + " " + r.Message
+	h.w.header = []byte(header)
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &textHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	return &textHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
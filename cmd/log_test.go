@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+func TestLogLevel(t *testing.T) {
+	for _, tt := range []struct {
+		args  []string
+		level slog.Level
+	}{
+		{nil, slog.LevelInfo},
+		{[]string{"-v"}, slog.LevelDebug},
+		{[]string{"-vv"}, slog.LevelDebug - 4},
+		{[]string{"-q"}, slog.LevelWarn},
+		{[]string{"-qq"}, slog.LevelError},
+		{[]string{"--log-level", "warn", "-v"}, slog.LevelInfo},
+		{[]string{"--log-level", "DEBUG"}, slog.LevelDebug},
+	} {
+		rootCmd, flag, _ := newRootCmd()
+		require.NoError(t, rootCmd.ParseFlags(tt.args))
+		level, err := logLevel(flag)
+		require.NoError(t, err)
+		assert.Equal(t, tt.level, level, tt.args)
+	}
+
+	rootCmd, flag, _ := newRootCmd()
+	require.NoError(t, rootCmd.ParseFlags([]string{"--log-level", "verbose"}))
+	_, err := logLevel(flag)
+	assert.EqualError(t, err, "invalid log level: verbose (debug, info, warn or error)")
+}
+
+func TestTextHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(newTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})).With("user", "john")
+	logger.Debug("channel opened", "channel_type", "session")
+	logger.Info("connection closed")
+	assert.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d DEBUG channel opened user=john channel_type=session
+\d{4}/\d\d/\d\d \d\d:\d\d:\d\d INFO connection closed user=john
+$`, buf.String())
+
+	buf.Reset()
+	slog.New(newTextHandler(&buf, &slog.HandlerOptions{})).Info("no attributes")
+	assert.Regexp(t, `^\d{4}/\d\d/\d\d \d\d:\d\d:\d\d INFO no attributes
+$`, buf.String())
+}
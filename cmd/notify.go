@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/John-Ao/go-sshd/notify"
+
+	"golang.org/x/exp/slog"
+)
+
+// newLoginNotifier returns the notifier of --login-notify-* flags. It returns nil if no destination is specified.
+func newLoginNotifier(logger *slog.Logger, flag *flagType) (*notify.Notifier, error) {
+	var senders []notify.Sender
+	for _, u := range flag.loginNotifySlack {
+		parsed, err := url.Parse(u)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+			return nil, fmt.Errorf("invalid --login-notify-slack %s: must be an http or https URL", u)
+		}
+		senders = append(senders, &notify.Slack{URL: u})
+	}
+	if flag.loginNotifyMatrix != "" {
+		parsed, err := url.Parse(flag.loginNotifyMatrix)
+		room := strings.Trim(parsed.Path, "/")
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || !strings.HasPrefix(room, "!") {
+			return nil, fmt.Errorf("invalid --login-notify-matrix %s: must be the URL of a homeserver followed by a room ID, e.g. https://matrix.example.com/!abc:example.com", flag.loginNotifyMatrix)
+		}
+		if flag.loginNotifyMatrixTokenFile == "" {
+			return nil, errors.New("--login-notify-matrix requires --login-notify-matrix-token-file")
+		}
+		token, err := readSecretFile(flag.loginNotifyMatrixTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		senders = append(senders, &notify.Matrix{Homeserver: parsed.Scheme + "://" + parsed.Host, Room: room, Token: string(token)})
+	}
+	if flag.loginNotifySMTP != "" {
+		if _, _, err := net.SplitHostPort(flag.loginNotifySMTP); err != nil {
+			return nil, fmt.Errorf("invalid --login-notify-smtp: %w", err)
+		}
+		if flag.loginNotifyEmailFrom == "" || len(flag.loginNotifyEmailTo) == 0 {
+			return nil, errors.New("--login-notify-smtp requires --login-notify-email-from and --login-notify-email-to")
+		}
+		sender := &notify.SMTP{Addr: flag.loginNotifySMTP, Username: flag.loginNotifySMTPUser, From: flag.loginNotifyEmailFrom, To: flag.loginNotifyEmailTo}
+		if flag.loginNotifySMTPPasswordFile != "" {
+			password, err := readSecretFile(flag.loginNotifySMTPPasswordFile)
+			if err != nil {
+				return nil, err
+			}
+			sender.Password = string(password)
+		}
+		senders = append(senders, sender)
+	}
+	criteria := flag.loginNotifyNewAddress || len(flag.loginNotifyUsers) != 0 || flag.loginNotifyOutsideHours != ""
+	if len(senders) == 0 {
+		if criteria {
+			return nil, errors.New("--login-notify-new-address, --login-notify-users and --login-notify-outside-hours require --login-notify-slack, --login-notify-matrix or --login-notify-smtp")
+		}
+		return nil, nil
+	}
+	if !criteria {
+		return nil, errors.New("login notifications require --login-notify-new-address, --login-notify-users or --login-notify-outside-hours")
+	}
+	n := &notify.Notifier{
+		Senders:            senders,
+		NewAddress:         flag.loginNotifyNewAddress,
+		KnownAddressesFile: flag.loginNotifyKnownAddresses,
+		Users:              flag.loginNotifyUsers,
+		Logger:             logger,
+	}
+	if flag.loginNotifyOutsideHours != "" {
+		hours, err := notify.ParseHours(flag.loginNotifyOutsideHours)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --login-notify-outside-hours: %w", err)
+		}
+		n.Hours = hours
+	}
+	if flag.loginNotifyTemplateFile != "" {
+		tmpl, err := readNotifyTemplate(flag.loginNotifyTemplateFile)
+		if err != nil {
+			return nil, err
+		}
+		n.Template = tmpl
+	}
+	if err := n.LoadKnownAddresses(); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func readNotifyTemplate(path string) (*template.Template, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := notify.NewTemplate(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("invalid --login-notify-template-file: %w", err)
+	}
+	return tmpl, nil
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginNotify(t *testing.T) {
+	texts := make(chan string, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		texts <- body["text"]
+	}))
+	defer receiver.Close()
+	templateFile := filepath.Join(t.TempDir(), "login.tmpl")
+	require.NoError(t, os.WriteFile(templateFile, []byte(`{{.User}} from {{.Address}}: {{join .Reasons ", "}}`), 0600))
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--user", "root:rootpass",
+		"--login-notify-slack", receiver.URL, "--login-notify-users", "root", "--login-notify-template-file", templateFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	for _, user := range []string{"john", "root"} {
+		client, err := dialPassword(port, user, map[string]string{"john": "mypass", "root": "rootpass"}[user])
+		require.NoError(t, err)
+		client.Close()
+	}
+	select {
+	case text := <-texts:
+		assert.Equal(t, "root from 127.0.0.1: watched user", text)
+	case <-time.After(5 * time.Second):
+		t.Fatal("login notification not received")
+	}
+	assert.Empty(t, texts)
+}
+
+func TestLoginNotifyWithoutDestination(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--login-notify-new-address"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--login-notify-new-address, --login-notify-users and --login-notify-outside-hours require --login-notify-slack, --login-notify-matrix or --login-notify-smtp")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/userstore"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/term"
+)
+
+func passwdCmd() *cobra.Command {
+	var algorithm string
+	var cost int
+	passwdCmd := cobra.Command{
+		Use:   "passwd",
+		Short: "Hash a password for password_hash of the user store",
+		Long:  "Hash a password for password_hash of --user-store. The password is prompted on a terminal or read from the first line of stdin.",
+		Example: `# Prompt a password and print its bcrypt hash
+go-sshd passwd
+
+# Hash the password from stdin with argon2id
+echo mypass | go-sshd passwd --algorithm argon2id`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			password, err := readPassword(cmd.InOrStdin(), cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			var hash string
+			switch algorithm {
+			case userstore.HashBcrypt:
+				hash, err = userstore.HashBcryptPassword(password, cost)
+			case userstore.HashArgon2id:
+				hash, err = userstore.HashArgon2idPassword(password, userstore.DefaultArgon2idParams)
+			default:
+				return fmt.Errorf("unknown algorithm: %s", algorithm)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), hash)
+			return nil
+		},
+	}
+	passwdCmd.Flags().StringVarP(&algorithm, "algorithm", "a", userstore.HashBcrypt, `hash algorithm: "bcrypt" or "argon2id"`)
+	passwdCmd.Flags().IntVarP(&cost, "cost", "", bcrypt.DefaultCost, "bcrypt cost")
+	return &passwdCmd
+}
+
+// readPassword prompts the password twice on a terminal or reads the first line of stdin
+func readPassword(stdin io.Reader, prompt io.Writer) ([]byte, error) {
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(prompt, "Password: ")
+		password, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(prompt)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprint(prompt, "Retype password: ")
+		retyped, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(prompt)
+		if err != nil {
+			return nil, err
+		}
+		if string(password) != string(retyped) {
+			return nil, fmt.Errorf("passwords do not match")
+		}
+		if len(password) == 0 {
+			return nil, fmt.Errorf("empty password")
+		}
+		return password, nil
+	}
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty password")
+	}
+	return []byte(line), nil
+}
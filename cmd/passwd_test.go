@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/userstore"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPasswd(t *testing.T) {
+	for _, args := range [][]string{
+		{"--cost", "4"},
+		{"--algorithm", "argon2id"},
+	} {
+		rootCmd := RootCmd()
+		rootCmd.SetArgs(append([]string{"passwd"}, args...))
+		rootCmd.SetIn(strings.NewReader("mypass\n"))
+		var stdoutBuf bytes.Buffer
+		rootCmd.SetOut(&stdoutBuf)
+		require.NoError(t, rootCmd.Execute())
+		hash := strings.TrimSuffix(stdoutBuf.String(), "\n")
+		assert.NoError(t, userstore.ComparePassword(hash, []byte("mypass")))
+		assert.Error(t, userstore.ComparePassword(hash, []byte("wrong")))
+	}
+
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"passwd"})
+	rootCmd.SetIn(strings.NewReader(""))
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "empty password")
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v3"
+)
+
+// envFlagNames are environment variables giving the defaults of flags besides GO_SSHD_* ones
+var envFlagNames = map[string]string{
+	"port":  "PORT",
+	"user":  "USER_PASS",
+	"shell": "SHELL",
+}
+
+// Sources of settings shown by print-config
+const (
+	sourceDefault     = "default"
+	sourceCommandLine = "command line"
+	sourceConfigFile  = "config file"
+	sourceSshdConfig  = "sshd_config"
+	sourcePermissions = "resolved from other permissions"
+)
+
+func printConfigCmd(flag *flagType, allPermissionFlags []permissionFlagType) *cobra.Command {
+	return &cobra.Command{
+		Use:   "print-config",
+		Short: "Print the effective settings of servers",
+		Long: `Print the effective settings of servers merged from the config file, sshd_config, environment variables and flags.
+Each setting has a comment of where it comes from. Passwords are redacted.`,
+		Example: `go-sshd print-config --config go-sshd.yaml
+go-sshd print-config -p 22 --allow-execute`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return printConfig(cmd.OutOrStdout(), cmd.Flags(), flag, allPermissionFlags)
+		},
+	}
+}
+
+// printConfig prints the settings of servers in the config file format. cmdFlags are the flags of the command line.
+func printConfig(w io.Writer, cmdFlags *pflag.FlagSet, flag *flagType, allPermissionFlags []permissionFlagType) error {
+	// Logs of loading settings and creating servers are not needed
+	discardLogger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	configs, err := loadConfigs(discardLogger, flag, allPermissionFlags)
+	if err != nil {
+		return err
+	}
+	servers := &yaml.Node{Kind: yaml.SequenceNode}
+	for _, config := range configs {
+		parsedFlags, origin := cmdFlags, sourceCommandLine
+		if config.flagSet != nil {
+			parsedFlags, origin = config.flagSet, sourceConfigFile
+		}
+		// Values are taken before newServer resolves permissions in config.flag
+		parsed := flagStrings(parsedFlags)
+		loaded := flagStrings(serverFlagSet(config.flag))
+		if _, err := newServer(discardLogger, config.flag, config.allPermissionFlags); err != nil {
+			if config.name != "" {
+				return fmt.Errorf("server %s: %w", config.name, err)
+			}
+			return err
+		}
+		server := &yaml.Node{Kind: yaml.MappingNode}
+		if config.name != "" {
+			server.Content = append(server.Content, scalarNode("name"), scalarNode(config.name))
+		}
+		serverFlagSet(config.flag).VisitAll(func(f *pflag.Flag) {
+			if isProcessFlag(f.Name) {
+				return
+			}
+			source := sourceDefault
+			switch {
+			case f.Value.String() != loaded[f.Name]:
+				source = sourcePermissions
+			case loaded[f.Name] != parsed[f.Name]:
+				source = sourceSshdConfig
+			case parsedFlags.Changed(f.Name):
+				source = origin
+			case origin == sourceCommandLine && os.Getenv(flagEnvName(f.Name)) != "":
+				source = "env " + flagEnvName(f.Name)
+			case os.Getenv(envFlagNames[f.Name]) != "":
+				source = "env " + envFlagNames[f.Name]
+			}
+			value := flagValueNode(f)
+			value.LineComment = source
+			server.Content = append(server.Content, scalarNode(f.Name), value)
+		})
+		servers.Content = append(servers.Content, server)
+	}
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&yaml.Node{Kind: yaml.MappingNode, Content: []*yaml.Node{scalarNode("servers"), servers}}); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// serverFlagSet returns the flags bound to a copy of flag
+func serverFlagSet(flag *flagType) *pflag.FlagSet {
+	rootCmd, f, _ := newRootCmd()
+	*f = *flag
+	return rootCmd.PersistentFlags()
+}
+
+// flagStrings returns the values of flags by names
+func flagStrings(flags *pflag.FlagSet) map[string]string {
+	values := map[string]string{}
+	flags.VisitAll(func(f *pflag.Flag) {
+		values[f.Name] = f.Value.String()
+	})
+	return values
+}
+
+func isProcessFlag(name string) bool {
+	for _, processName := range processFlagNames {
+		if name == processName {
+			return true
+		}
+	}
+	return false
+}
+
+func scalarNode(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+}
+
+// flagValueNode returns the YAML value of f with passwords redacted
+func flagValueNode(f *pflag.Flag) *yaml.Node {
+	if sliceValue, ok := f.Value.(pflag.SliceValue); ok {
+		node := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, value := range sliceValue.GetSlice() {
+			node.Content = append(node.Content, scalarNode(redactFlagValue(f.Name, value)))
+		}
+		return node
+	}
+	switch f.Value.Type() {
+	case "bool":
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: f.Value.String()}
+	case "int", "int64", "uint16", "count":
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: f.Value.String()}
+	}
+	return scalarNode(redactFlagValue(f.Name, f.Value.String()))
+}
+
+// redactFlagValue hides passwords of --user and URLs
+func redactFlagValue(name, value string) string {
+	if name == "user" {
+		if user, password, ok := strings.Cut(value, ":"); ok && password != "" {
+			return user + ":xxxxx"
+		}
+		return value
+	}
+	if u, err := url.Parse(value); err == nil && u.User != nil {
+		return u.Redacted()
+	}
+	return value
+}
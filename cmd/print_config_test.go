@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPrintConfig(t *testing.T) {
+	dir := t.TempDir()
+	sshdConfigPath := filepath.Join(dir, "sshd_config")
+	require.NoError(t, os.WriteFile(sshdConfigPath, []byte("Port 2200\nAllowTcpForwarding local\n"), 0600))
+	configPath := filepath.Join(dir, "config.yaml")
+	require.NoError(t, os.WriteFile(configPath, []byte(`servers:
+  - name: tenant-a
+    port: 2222
+    user: ["john:mypass"]
+    allow-execute: true
+  - name: tenant-b
+    sshd-config: `+sshdConfigPath+`
+`), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"print-config", "--config", configPath})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+
+	var config struct {
+		Servers []map[string]any `yaml:"servers"`
+	}
+	require.NoError(t, yaml.Unmarshal(stdoutBuf.Bytes(), &config))
+	require.Len(t, config.Servers, 2)
+	assert.Equal(t, "tenant-a", config.Servers[0]["name"])
+	assert.Equal(t, 2222, config.Servers[0]["port"])
+	assert.Equal(t, []any{"john:xxxxx"}, config.Servers[0]["user"])
+	assert.Equal(t, true, config.Servers[0]["allow-pty"])
+	assert.Equal(t, false, config.Servers[0]["allow-sftp"])
+	assert.Equal(t, 2200, config.Servers[1]["port"])
+	assert.Equal(t, true, config.Servers[1]["allow-direct-tcpip"])
+	assert.Equal(t, false, config.Servers[1]["allow-tcpip-forward"])
+
+	output := stdoutBuf.String()
+	assert.Contains(t, output, "    port: 2222 # config file\n")
+	assert.Contains(t, output, "    allow-pty: true # resolved from other permissions\n")
+	assert.Contains(t, output, "    allow-sftp: false # default\n")
+	assert.Contains(t, output, "    port: 2200 # sshd_config\n")
+	assert.NotContains(t, output, "mypass")
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/server/recording"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// sessionRecordingFile returns server.Server.SessionRecording creating a file per session in dir,
+// e.g. "20240102T150405Z_john_5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b_1.rec"
+func sessionRecordingFile(dir string) func(conn *server.ConnMetadata, sessionID string, startTime time.Time) (io.WriteCloser, error) {
+	return func(conn *server.ConnMetadata, sessionID string, startTime time.Time) (io.WriteCloser, error) {
+		name := startTime.UTC().Format("20060102T150405Z") + "_" + unsafeFileNameChars.ReplaceAllString(conn.User(), "_") + "_" + strings.ReplaceAll(sessionID, "/", "_") + ".rec"
+		return os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	}
+}
+
+// openRecording reads the header of the recording of path
+func openRecording(path string) (*recording.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := recording.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return r, f, nil
+}
+
+func playCmd() *cobra.Command {
+	var opts recording.PlayOptions
+	cmd := &cobra.Command{
+		Use:   "play RECORDING",
+		Short: "Replay a session recording in the terminal",
+		Long: `Replay a session recording of --session-recording-dir in the terminal.
+Keys on a terminal: space pauses or resumes, + and - double or halve the speed, right and left arrows seek 5s forward or backward, q quits.`,
+		Example: `go-sshd play /var/log/go-sshd/recordings/20240102T150405Z_john_5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b_1.rec --speed 2 --idle-limit 2s`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, f, err := openRecording(args[0])
+			if err != nil {
+				return err
+			}
+			events, err := r.ReadAll()
+			f.Close()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithCancel(cmd.Context())
+			defer cancel()
+			out := cmd.OutOrStdout()
+			if stdin, ok := cmd.InOrStdin().(*os.File); ok && term.IsTerminal(int(stdin.Fd())) {
+				state, err := term.MakeRaw(int(stdin.Fd()))
+				if err != nil {
+					return err
+				}
+				defer term.Restore(int(stdin.Fd()), state)
+				controls := make(chan recording.Control)
+				go readPlayControls(stdin, controls, cancel)
+				opts.Controls = controls
+				// Output without a pty doesn't have carriage returns which the terminal in raw mode needs
+				if r.Header.Width == 0 {
+					out = crlfWriter{out}
+				}
+			}
+			err = recording.Play(ctx, out, events, opts)
+			if err == context.Canceled {
+				return nil
+			}
+			return err
+		},
+	}
+	cmd.Flags().Float64VarP(&opts.Speed, "speed", "s", 1, "playback speed")
+	cmd.Flags().DurationVarP(&opts.Seek, "seek", "", 0, "position to start from (e.g. 1m30s)")
+	cmd.Flags().DurationVarP(&opts.IdleLimit, "idle-limit", "", 0, "limit of pauses between outputs (default: unlimited)")
+	return cmd
+}
+
+// readPlayControls sends controls of keys read from stdin in raw mode. It calls quit on q or Ctrl-C.
+func readPlayControls(stdin io.Reader, controls chan<- recording.Control, quit func()) {
+	keys := map[string]recording.Control{
+		" ":      recording.ControlPause,
+		"+":      recording.ControlFaster,
+		"=":      recording.ControlFaster,
+		"-":      recording.ControlSlower,
+		"\x1b[C": recording.ControlForward,
+		"\x1b[D": recording.ControlBackward,
+	}
+	b := make([]byte, 16)
+	for {
+		n, err := stdin.Read(b)
+		if err != nil {
+			return
+		}
+		key := string(b[:n])
+		if key == "q" || key == "\x03" {
+			quit()
+			return
+		}
+		if control, ok := keys[key]; ok {
+			controls <- control
+		}
+	}
+}
+
+// crlfWriter writes "\n" as "\r\n"
+type crlfWriter struct {
+	io.Writer
+}
+
+func (w crlfWriter) Write(p []byte) (int, error) {
+	if _, err := w.Writer.Write(bytes.ReplaceAll(p, []byte("\n"), []byte("\r\n"))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func exportCmd() *cobra.Command {
+	var output string
+	cmd := &cobra.Command{
+		Use:     "export RECORDING",
+		Short:   "Convert a session recording to the asciicast v2 format of asciinema",
+		Example: `go-sshd export 20240102T150405Z_john_5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b_1.rec -o session.cast && asciinema play session.cast`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			r, f, err := openRecording(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if output == "" {
+				return recording.Export(cmd.OutOrStdout(), r)
+			}
+			out, err := os.Create(output)
+			if err != nil {
+				return err
+			}
+			if err := recording.Export(out, r); err != nil {
+				out.Close()
+				return err
+			}
+			return out.Close()
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write instead of stdout")
+	return cmd
+}
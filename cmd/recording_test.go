@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRecordingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "recordings")
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--session-recording-dir", dir})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+	assertExec(t, client)
+	whoami, err := exec.Command("whoami").Output()
+	require.NoError(t, err)
+
+	var path string
+	require.Eventually(t, func() bool {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.rec"))
+		if len(files) != 1 {
+			return false
+		}
+		path = files[0]
+		content, _ := os.ReadFile(path)
+		return bytes.Count(content, []byte("\n")) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Regexp(t, `^\d{8}T\d{6}Z_john_[0-9a-f-]{36}_1\.rec$`, filepath.Base(path))
+
+	run := func(args ...string) string {
+		cmd := RootCmd()
+		cmd.SetArgs(args)
+		var stdout bytes.Buffer
+		cmd.SetOut(&stdout)
+		cmd.SetIn(&bytes.Buffer{})
+		require.NoError(t, cmd.Execute())
+		return stdout.String()
+	}
+	assert.Equal(t, string(whoami), run("play", path, "--speed", "100"))
+	lines := strings.Split(run("export", path), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"command":"whoami"`)
+	assert.Contains(t, lines[1], strconv.Quote(string(whoami)))
+}
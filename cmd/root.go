@@ -1,28 +1,198 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"net"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/John-Ao/go-sshd/access"
+	"github.com/John-Ao/go-sshd/admin"
+	"github.com/John-Ao/go-sshd/auditd"
+	"github.com/John-Ao/go-sshd/daemon"
+	"github.com/John-Ao/go-sshd/executor"
+	"github.com/John-Ao/go-sshd/geoip"
+	"github.com/John-Ao/go-sshd/httpconnect"
+	"github.com/John-Ao/go-sshd/knock"
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/opa"
+	"github.com/John-Ao/go-sshd/reuseport"
+	"github.com/John-Ao/go-sshd/seccomp"
 	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/server/auth"
+	"github.com/John-Ao/go-sshd/server/forward"
+	"github.com/John-Ao/go-sshd/sshdconfig"
+	"github.com/John-Ao/go-sshd/tarpit"
+	"github.com/John-Ao/go-sshd/upgrade"
+	"github.com/John-Ao/go-sshd/userstore"
 	"github.com/John-Ao/go-sshd/version"
+	"github.com/John-Ao/go-sshd/vsock"
 
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/exp/slog"
 )
 
 type flagType struct {
 	//dnsServer    string
-	showsVersion  bool
-	sshHost       string
-	sshPort       uint16
-	sshUnixSocket string
-	sshShell      string
-	sshUsers      []string
+	showsVersion bool
+	check        bool
+	configFile   string
+	sshdConfig   string
+	// sshd is the parsed sshdConfig
+	sshd                *sshdconfig.Config
+	sshHost             string
+	sshPort             uint16
+	sshUnixSocket       string
+	vsock               string
+	httpConnect         bool
+	reusePort           int
+	reusePortPerCPU     bool
+	drainTimeout        time.Duration
+	daemon              bool
+	pidFile             string
+	runAs               string
+	pledge              bool
+	copyBufferSize      string
+	controlSocket       string
+	metricsListen       string
+	adminListen         string
+	adminGRPCListen     string
+	adminTokenFile      string
+	adminPprof          string
+	auditLog            string
+	auditd              bool
+	trafficFile         string
+	trafficSaveInterval time.Duration
+	tarpit              bool
+	tarpitMaxConns      int
+	tarpitDuration      time.Duration
+	tarpitInterval      time.Duration
+	knockSequence       []int
+	knockTimeout        time.Duration
+	knockAllowDuration  time.Duration
+	knockSPAPort        uint16
+	knockSPAKeyFile     string
+	maxHandshakes       int
+	maxChannelWorkers   int
+	workerQueueSize     int
+	workerQueueTimeout  time.Duration
+	auditHMACKeyFile    string
+	webhookURLs         []string
+	webhookSecretFile   string
+	webhookEvents       []string
+	webhookAuthFailures int
+	// webhookAuthFailuresWindow is the window of webhookAuthFailures
+	webhookAuthFailuresWindow time.Duration
+	// webhookLargeUpload is in MiB
+	webhookLargeUpload int64
+	// loginNotify* are of login notifications
+	loginNotifySlack            []string
+	loginNotifyMatrix           string
+	loginNotifyMatrixTokenFile  string
+	loginNotifySMTP             string
+	loginNotifySMTPUser         string
+	loginNotifySMTPPasswordFile string
+	loginNotifyEmailFrom        string
+	loginNotifyEmailTo          []string
+	loginNotifyNewAddress       bool
+	loginNotifyKnownAddresses   string
+	loginNotifyUsers            []string
+	loginNotifyOutsideHours     string
+	loginNotifyTemplateFile     string
+	connectionLogDir            string
+	commandHistoryDir           string
+	historyShellInput           bool
+	sessionRecordingDir         string
+	logFile                     string
+	logFormat                   string
+	logLevel                    string
+	verbose                     int
+	quiet                       int
+	syslog                      string
+	syslogFacility              string
+	syslogTag                   string
+	logMaxSize                  int64
+	logRotateInterval           time.Duration
+	logMaxBackups               int
+	logMaxAge                   time.Duration
+	sshShell                    string
+	loginShells                 bool
+	windowsExecShell            string
+	sshUsers                    []string
+	hostKeys                    []string
+	authorizedKeysFiles         []string
+	authorizedKeysURL           string
+	authorizedKeysURLCache      time.Duration
+	authorizedKeysURLFailOpen   bool
+	forgeKeys                   string
+	forgeURL                    string
+	forgeAccounts               []string
+	forgeOrg                    string
+	forgeTokenFile              string
+	forgeCache                  time.Duration
+	forgeFailOpen               bool
+	userStore                   string
+	opaURL                      string
+	opaPolicies                 []string
+	opaQuery                    string
+	obscureKeystrokeTiming      time.Duration
+
+	disconnectMalformed bool
+	genericOpenFailures bool
+	channelRate         string
+	forwardChannelRate  string
+	maxChannels         int
+	maxTransfer         string
+	stallTimeout        time.Duration
+	allowClientVersions []string
+	denyClientVersions  []string
+	minClientVersions   []string
+	geoipDBs            []string
+	geoipRules          geoip.Rules
+	serverVersion       string
+	algorithms          server.Algorithms
+	rekeyLimit          string
+	fips                bool
+	chrootDirectory     string
+	resourceLimits      string
+	cgroupParent        string
+	cgroupLimits        string
+	seccompProfile      string
+	namespaces          string
+	sandboxUser         string
+	userAccess          auth.UserAccess
+	accessRules         string
+	minRSAKeyBits       int
+	allowDSAKeys        bool
+	denyECDSAKeys       bool
+
+	upstreams          []string
+	upstreamIdentity   string
+	upstreamKnownHosts string
+	upstreamInsecure   bool
+
+	dockerImage      string
+	dockerUserImages []string
+	dockerShell      string
+	dockerMounts     []string
+	dockerCPUs       string
+	dockerMemory     string
+	dockerPidsLimit  int
+	dockerNetwork    string
+
+	kubernetesContext   string
+	kubernetesNamespace string
+	kubernetesPod       string
+	kubernetesContainer string
+	kubernetesImage     string
+	kubernetesShell     string
 
 	allowTcpipForward       bool
 	allowDirectTcpip        bool
@@ -30,16 +200,41 @@ type flagType struct {
 	allowSftp               bool
 	allowStreamlocalForward bool
 	allowDirectStreamlocal  bool
+	allowPty                bool
+	allowScp                bool
+	allowAgentForward       bool
+	allowX11Forward         bool
+	allowAttach             bool
+	allowAttachWrite        bool
+	attachTargets           []string
+	denyPty                 bool
+	denyAll                 bool
 }
 
+// usedCredentialsSuffix is appended to --user-store for the file keeping the one-time credentials used
+const usedCredentialsSuffix = ".used.json"
+
+// permissionPty is the permission name of --allow-pty, which the server applies as DenyPty
+const permissionPty = "pty"
+
 type permissionFlagType = struct {
 	name    string
 	flagPtr *bool
 }
 
-type sshUser struct {
-	name     string
-	password string
+func permissionFlags(flag *flagType) []permissionFlagType {
+	return []permissionFlagType{
+		{name: server.PermissionTcpipForward, flagPtr: &flag.allowTcpipForward},
+		{name: server.PermissionDirectTcpip, flagPtr: &flag.allowDirectTcpip},
+		{name: server.PermissionExecute, flagPtr: &flag.allowExecute},
+		{name: server.PermissionSftp, flagPtr: &flag.allowSftp},
+		{name: server.PermissionStreamlocalForward, flagPtr: &flag.allowStreamlocalForward},
+		{name: server.PermissionDirectStreamlocal, flagPtr: &flag.allowDirectStreamlocal},
+		{name: permissionPty, flagPtr: &flag.allowPty},
+		{name: server.PermissionScp, flagPtr: &flag.allowScp},
+		{name: server.PermissionAgentForward, flagPtr: &flag.allowAgentForward},
+		{name: server.PermissionX11Forward, flagPtr: &flag.allowX11Forward},
+	}
 }
 
 func init() {
@@ -47,15 +242,14 @@ func init() {
 }
 
 func RootCmd() *cobra.Command {
+	rootCmd, _, _ := newRootCmd()
+	return rootCmd
+}
+
+// newRootCmd returns the root command and its flags
+func newRootCmd() (*cobra.Command, *flagType, []permissionFlagType) {
 	var flag flagType
-	allPermissionFlags := []permissionFlagType{
-		{name: "tcpip-forward", flagPtr: &flag.allowTcpipForward},
-		{name: "direct-tcpip", flagPtr: &flag.allowDirectTcpip},
-		{name: "execute", flagPtr: &flag.allowExecute},
-		{name: "sftp", flagPtr: &flag.allowSftp},
-		{name: "streamlocal-forward", flagPtr: &flag.allowStreamlocalForward},
-		{name: "direct-streamlocal", flagPtr: &flag.allowDirectStreamlocal},
-	}
+	allPermissionFlags := permissionFlags(&flag)
 	rootCmd := cobra.Command{
 		Use:          os.Args[0],
 		Short:        "go-sshd",
@@ -69,7 +263,11 @@ func RootCmd() *cobra.Command {
 
 Permissions:
 All permissions are allowed by default.
-For example, specifying --allow-direct-tcpip and --allow-execute allows only them.`,
+For example, specifying --allow-direct-tcpip and --allow-execute allows only them (and pty and scp by --allow-execute).
+With --deny-all, only the specified permissions are allowed.`,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return applyEnvFlags(cmd)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return rootRunEWithExtra(cmd, args, &flag, allPermissionFlags)
 		},
@@ -78,14 +276,167 @@ For example, specifying --allow-direct-tcpip and --allow-execute allows only the
 	if err != nil {
 		port = 2222
 	}
-	rootCmd.PersistentFlags().BoolVarP(&flag.showsVersion, "version", "v", false, "show version")
+	rootCmd.PersistentFlags().BoolVarP(&flag.showsVersion, "version", "", false, "show version")
+	rootCmd.Flags().BoolVarP(&flag.check, "check", "t", false, "check the settings without starting servers (same as the check command)")
+	rootCmd.PersistentFlags().StringVarP(&flag.configFile, "config", "", "", "YAML file of named server profiles to run concurrently")
+	rootCmd.PersistentFlags().StringVarP(&flag.sshdConfig, "sshd-config", "", "", "OpenSSH sshd_config file of supported directives, overriding flags")
 	rootCmd.PersistentFlags().StringVarP(&flag.sshHost, "host", "", "", "SSH server host to listen (e.g. 127.0.0.1)")
 	rootCmd.PersistentFlags().Uint16VarP(&flag.sshPort, "port", "p", uint16(port), "port to listen")
 	// NOTE: long name 'unix-socket' is from curl (ref: https://curl.se/docs/manpage.html)
 	rootCmd.PersistentFlags().StringVarP(&flag.sshUnixSocket, "unix-socket", "", "", "Unix domain socket to listen")
+	rootCmd.PersistentFlags().StringVarP(&flag.vsock, "vsock", "", "", `vsock address to listen (e.g. "2222" for any CID, "3:2222")`)
+	rootCmd.PersistentFlags().BoolVarP(&flag.httpConnect, "http-connect", "", false, "accept SSH tunneled through HTTP CONNECT requests instead of plain SSH")
+	rootCmd.PersistentFlags().IntVarP(&flag.reusePort, "reuseport", "", 0, "listen on the TCP address by this number of sockets with SO_REUSEPORT, each accepted by its own goroutine (Linux)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.reusePortPerCPU, "reuseport-per-cpu", "", false, "make each socket of --reuseport prefer connections received on its CPU, with a socket per CPU by default (Linux)")
+	rootCmd.PersistentFlags().DurationVarP(&flag.drainTimeout, "drain-timeout", "", 0, "time to wait for connections to close after an upgrade by SIGUSR2 or a stop by SIGTERM (default: no limit)")
+	rootCmd.Flags().BoolVarP(&flag.daemon, "daemon", "", false, "run in the background after listening")
+	rootCmd.Flags().StringVarP(&flag.pidFile, "pid-file", "", "", "file to write the process ID")
+	rootCmd.Flags().StringVarP(&flag.runAs, "run-as", "", "", `user or "user:group" to switch to after listening and reading host keys as root (e.g. "go-sshd")`)
+	rootCmd.Flags().BoolVarP(&flag.pledge, "pledge", "", false, "pledge and unveil only the paths needed after listening on OpenBSD")
+	rootCmd.Flags().StringVarP(&flag.copyBufferSize, "copy-buffer-size", "", "32K", `size of buffers to relay forwarded connections with "K" or "M", larger for bulk transfers over fast links (e.g. "256K")`)
+	rootCmd.Flags().StringVarP(&flag.auditLog, "audit-log", "", "", "file to append the audit log of authentications, sessions, SFTP operations and forwards in JSON lines")
+	rootCmd.Flags().BoolVarP(&flag.auditd, "auditd", "", false, "send records of authentications, logins and sessions to the Linux audit subsystem (requires CAP_AUDIT_WRITE)")
+	rootCmd.Flags().StringVarP(&flag.trafficFile, "traffic-file", "", "", "JSON file to accumulate the traffic of sessions, SFTP and forwards by user across restarts")
+	rootCmd.Flags().DurationVarP(&flag.trafficSaveInterval, "traffic-save-interval", "", time.Minute, "interval to save the traffic to --traffic-file")
+	rootCmd.Flags().StringVarP(&flag.auditHMACKeyFile, "audit-hmac-key-file", "", "", "file of the key to chain records of --audit-log with HMAC-SHA256")
+	rootCmd.Flags().StringArrayVarP(&flag.webhookURLs, "webhook-url", "", nil, "URL to post JSON notifications of events such as logins and failed authentication bursts")
+	rootCmd.Flags().StringVarP(&flag.webhookSecretFile, "webhook-secret-file", "", "", "file of the secret to sign --webhook-url requests with HMAC-SHA256 in the X-Go-Sshd-Signature header")
+	rootCmd.Flags().StringSliceVarP(&flag.webhookEvents, "webhook-events", "", nil, "events to post to --webhook-url (login, auth-failure-burst, remote-forward or large-upload) (default: all)")
+	rootCmd.Flags().IntVarP(&flag.webhookAuthFailures, "webhook-auth-failures", "", 5, "failed authentications from a host within --webhook-auth-failures-window for an auth-failure-burst")
+	rootCmd.Flags().DurationVarP(&flag.webhookAuthFailuresWindow, "webhook-auth-failures-window", "", time.Minute, "time window of --webhook-auth-failures")
+	rootCmd.Flags().Int64VarP(&flag.webhookLargeUpload, "webhook-large-upload", "", 100, "megabytes received by an SFTP session for a large-upload")
+	rootCmd.Flags().StringArrayVarP(&flag.loginNotifySlack, "login-notify-slack", "", nil, "incoming webhook URL of Slack or a compatible service to notify logins")
+	rootCmd.Flags().StringVarP(&flag.loginNotifyMatrix, "login-notify-matrix", "", "", "homeserver URL followed by a room ID of Matrix to notify logins (e.g. https://matrix.example.com/!abc:example.com)")
+	rootCmd.Flags().StringVarP(&flag.loginNotifyMatrixTokenFile, "login-notify-matrix-token-file", "", "", "file of the access token for --login-notify-matrix")
+	rootCmd.Flags().StringVarP(&flag.loginNotifySMTP, "login-notify-smtp", "", "", `SMTP server "host:port" to notify logins by email`)
+	rootCmd.Flags().StringVarP(&flag.loginNotifySMTPUser, "login-notify-smtp-user", "", "", "user to authenticate to --login-notify-smtp")
+	rootCmd.Flags().StringVarP(&flag.loginNotifySMTPPasswordFile, "login-notify-smtp-password-file", "", "", "file of the password of --login-notify-smtp-user")
+	rootCmd.Flags().StringVarP(&flag.loginNotifyEmailFrom, "login-notify-email-from", "", "", "sender address of login notifications by email")
+	rootCmd.Flags().StringSliceVarP(&flag.loginNotifyEmailTo, "login-notify-email-to", "", nil, "recipient addresses of login notifications by email")
+	rootCmd.Flags().BoolVarP(&flag.loginNotifyNewAddress, "login-notify-new-address", "", false, "notify logins from IP addresses not seen for the user before")
+	rootCmd.Flags().StringVarP(&flag.loginNotifyKnownAddresses, "login-notify-known-addresses", "", "", "JSON file to keep the addresses seen for --login-notify-new-address across restarts")
+	rootCmd.Flags().StringSliceVarP(&flag.loginNotifyUsers, "login-notify-users", "", nil, "notify logins of the users (e.g. root)")
+	rootCmd.Flags().StringVarP(&flag.loginNotifyOutsideHours, "login-notify-outside-hours", "", "", `notify logins outside the hours in local time or a time zone (e.g. "Mon-Fri 09:00-18:00", "22:00-06:00 UTC")`)
+	rootCmd.Flags().StringVarP(&flag.loginNotifyTemplateFile, "login-notify-template-file", "", "", "file of the text/template of login notifications, whose first line is the subject of emails")
+	rootCmd.Flags().StringVarP(&flag.metricsListen, "metrics-listen", "", "", `address to serve Prometheus metrics at /metrics (e.g. "127.0.0.1:9100")`)
+	rootCmd.Flags().StringVarP(&flag.adminListen, "admin-listen", "", "", `address to serve the admin HTTP API authenticated by --admin-token-file (e.g. "127.0.0.1:9101")`)
+	rootCmd.Flags().StringVarP(&flag.adminGRPCListen, "admin-grpc-listen", "", "", `address to serve the admin gRPC API with streaming events authenticated by --admin-token-file (e.g. "127.0.0.1:9102")`)
+	rootCmd.Flags().StringVarP(&flag.adminPprof, "admin-pprof", "", "", `serve profiles of net/http/pprof under /debug/pprof/ of --admin-listen to "loopback" or "any" clients`)
+	rootCmd.Flags().Lookup("admin-pprof").NoOptDefVal = admin.PprofLoopback
+	rootCmd.Flags().BoolVarP(&flag.tarpit, "tarpit", "", false, "hold connections from addresses banned by the admin API open, trickling an endless banner instead of closing them")
+	rootCmd.Flags().IntVarP(&flag.tarpitMaxConns, "tarpit-max-connections", "", 1024, "connections held by --tarpit at once, over which they are closed (0 for no limit)")
+	rootCmd.Flags().DurationVarP(&flag.tarpitDuration, "tarpit-duration", "", time.Hour, "time to hold each connection by --tarpit (0 for until the client closes it)")
+	rootCmd.Flags().DurationVarP(&flag.tarpitInterval, "tarpit-interval", "", tarpit.DefaultInterval, "interval of the lines of the banner of --tarpit")
+	rootCmd.Flags().IntSliceVarP(&flag.knockSequence, "knock-sequence", "", nil, "TCP ports to connect to in order to be allowed to connect for --knock-allow-duration, resetting connections from others (e.g. 7000,8000,9000)")
+	rootCmd.Flags().DurationVarP(&flag.knockTimeout, "knock-timeout", "", knock.DefaultTimeout, "time to complete --knock-sequence")
+	rootCmd.Flags().Uint16VarP(&flag.knockSPAPort, "knock-spa-port", "", 0, "UDP port to receive single packet authorizations signed by --knock-spa-key-file to be allowed to connect for --knock-allow-duration")
+	rootCmd.Flags().StringVarP(&flag.knockSPAKeyFile, "knock-spa-key-file", "", "", "file of the HMAC-SHA256 key of --knock-spa-port")
+	rootCmd.Flags().DurationVarP(&flag.knockAllowDuration, "knock-allow-duration", "", knock.DefaultAllowDuration, "time to allow new connections from an address after knocking")
+	rootCmd.Flags().IntVarP(&flag.maxHandshakes, "max-handshakes", "", 0, "handshakes at once by all servers, over which connections wait in a queue of --worker-queue-size (0 for no limit)")
+	rootCmd.Flags().IntVarP(&flag.maxChannelWorkers, "max-channel-workers", "", 0, "channels served at once by all connections, over which channels wait in a queue of --worker-queue-size (0 for no limit)")
+	rootCmd.Flags().IntVarP(&flag.workerQueueSize, "worker-queue-size", "", 64, "connections and channels waiting for --max-handshakes and --max-channel-workers each, over which they are shed")
+	rootCmd.Flags().DurationVarP(&flag.workerQueueTimeout, "worker-queue-timeout", "", 10*time.Second, "time for connections and channels to wait in the queue before shed (0 for no limit)")
+	rootCmd.Flags().StringVarP(&flag.adminTokenFile, "admin-token-file", "", "", "file of the bearer token required by --admin-listen and --admin-grpc-listen")
+	rootCmd.PersistentFlags().StringVarP(&flag.controlSocket, "control-socket", "", "", "Unix domain socket for the sessions command to list and close connections")
+	rootCmd.Flags().StringVarP(&flag.connectionLogDir, "connection-log-dir", "", "", "directory to write the logs of each connection to a file named by its start time, user and ID")
+	rootCmd.Flags().StringVarP(&flag.sessionRecordingDir, "session-recording-dir", "", "", "directory to record the output of each shell and exec session to a file named by its start time, user and ID for the play command")
+	rootCmd.Flags().StringVarP(&flag.commandHistoryDir, "command-history-dir", "", "", "directory to append the commands of exec sessions of each user with timestamps to a file named by the user")
+	rootCmd.Flags().BoolVarP(&flag.historyShellInput, "command-history-shell-input", "", false, "also append the lines typed into shells with a pty to --command-history-dir, including passwords typed without echo")
+	rootCmd.Flags().StringVarP(&flag.logFile, "log-file", "", "", "file to write logs instead of stderr")
+	rootCmd.Flags().StringVarP(&flag.logFormat, "log-format", "", logFormatText, "log format (text or json)")
+	rootCmd.Flags().StringVarP(&flag.logLevel, "log-level", "", "info", "log level (debug, info, warn or error)")
+	rootCmd.Flags().CountVarP(&flag.verbose, "verbose", "v", "lower the log level by one (-v for debug, -vv for debug with sources)")
+	rootCmd.Flags().CountVarP(&flag.quiet, "quiet", "q", "raise the log level by one (-q for warn, -qq for error)")
+	rootCmd.Flags().StringVarP(&flag.syslog, "syslog", "", "", `syslog to write logs instead of stderr ("local", "udp://host:port", "tcp://host:port" or "unix:///path")`)
+	rootCmd.Flags().StringVarP(&flag.syslogFacility, "syslog-facility", "", "daemon", `syslog facility (e.g. "auth", "local0")`)
+	rootCmd.Flags().StringVarP(&flag.syslogTag, "syslog-tag", "", "go-sshd", "syslog tag")
+	rootCmd.Flags().Int64VarP(&flag.logMaxSize, "log-max-size", "", 0, "size in MiB to rotate --log-file at (default: no limit)")
+	rootCmd.Flags().DurationVarP(&flag.logRotateInterval, "log-rotate-interval", "", 0, `interval to rotate --log-file at in UTC (e.g. "24h" for midnight)`)
+	rootCmd.Flags().IntVarP(&flag.logMaxBackups, "log-max-backups", "", 0, "number of rotated log files to keep (default: all)")
+	rootCmd.Flags().DurationVarP(&flag.logMaxAge, "log-max-age", "", 0, `time to keep rotated log files (e.g. "720h")`)
 	rootCmd.PersistentFlags().StringVarP(&flag.sshShell, "shell", "", os.Getenv("SHELL"), "Shell")
+	rootCmd.PersistentFlags().BoolVarP(&flag.loginShells, "login-shell", "", false, "use the login shells of users in /etc/passwd unless the user store specifies one, falling back to --shell")
+	rootCmd.PersistentFlags().StringVarP(&flag.windowsExecShell, "windows-exec-shell", "", server.WindowsExecCmd, `shell running the command lines of exec requests as is on Windows, "cmd" or "powershell"`)
 	//rootCmd.PersistentFlags().StringVar(&flag.dnsServer, "dns-server", "", "DNS server (e.g. 1.1.1.1:53)")
 	rootCmd.PersistentFlags().StringArrayVarP(&flag.sshUsers, "user", "u", []string{os.Getenv("USER_PASS")}, `SSH user name (e.g. "john:mypass")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.hostKeys, "host-key", "", nil, "private host key file (default: built-in key)")
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.authorizedKeysFiles, "authorized-keys-file", "", nil, `authorized_keys file of users (e.g. "%h/.ssh/authorized_keys", "/etc/ssh/keys/%u")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.authorizedKeysURL, "authorized-keys-url", "", "", `URL of authorized keys of users fetched over HTTPS with %u of the user name (e.g. "https://keys.example.com/%u")`)
+	rootCmd.PersistentFlags().DurationVarP(&flag.authorizedKeysURLCache, "authorized-keys-url-cache", "", auth.DefaultAuthorizedKeysCacheDuration, "time keys fetched from --authorized-keys-url are used before revalidated by their ETags")
+	rootCmd.PersistentFlags().BoolVarP(&flag.authorizedKeysURLFailOpen, "authorized-keys-url-fail-open", "", false, "use the last keys fetched for users when --authorized-keys-url fails instead of rejecting them")
+	rootCmd.PersistentFlags().StringVarP(&flag.forgeKeys, "forge-keys", "", "", `authenticate users by the public keys of their accounts on "github" or "gitlab"`)
+	rootCmd.PersistentFlags().StringVarP(&flag.forgeURL, "forge-url", "", "", `URL of GitHub Enterprise Server or self-managed GitLab for --forge-keys (e.g. "https://gitlab.example.com")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.forgeAccounts, "forge-account", "", nil, `user allowed to log in by --forge-keys with the keys of an account as "user:account", or "user" of the same name`)
+	rootCmd.PersistentFlags().StringVarP(&flag.forgeOrg, "forge-org", "", "", "GitHub organization or GitLab group accounts of --forge-keys must be members of")
+	rootCmd.PersistentFlags().StringVarP(&flag.forgeTokenFile, "forge-token-file", "", "", "file of the API token to see private members of --forge-org")
+	rootCmd.PersistentFlags().DurationVarP(&flag.forgeCache, "forge-cache", "", auth.DefaultAuthorizedKeysCacheDuration, "time keys and memberships of --forge-keys are used before fetched again")
+	rootCmd.PersistentFlags().BoolVarP(&flag.forgeFailOpen, "forge-fail-open", "", false, "use the last keys and memberships of accounts when the forge of --forge-keys fails instead of rejecting them")
+	rootCmd.PersistentFlags().StringVarP(&flag.userStore, "user-store", "", "", "JSON or YAML file of virtual users with per-user settings")
+	rootCmd.PersistentFlags().BoolVarP(&flag.disconnectMalformed, "disconnect-malformed", "", false, "disconnect clients sending malformed requests instead of rejecting the requests")
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.allowClientVersions, "allow-client-version", "", nil, `pattern of client identification strings to allow, denying others (e.g. "SSH-2.0-OpenSSH_*")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.denyClientVersions, "deny-client-version", "", nil, `pattern of client identification strings to deny (e.g. "*libssh*")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.minClientVersions, "min-client-version", "", nil, `minimum version of client software (e.g. "OpenSSH_8.0")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.geoipDBs, "geoip-db", "", nil, "MaxMind DB file of countries or autonomous systems to look up clients for logs, events and --geoip-* rules (e.g. GeoLite2-Country.mmdb)")
+	rootCmd.PersistentFlags().StringSliceVarP(&flag.geoipRules.AllowCountries, "geoip-allow-countries", "", nil, `ISO country codes of clients to allow, denying others not in --geoip-allow-asns (e.g. "JP,US")`)
+	rootCmd.PersistentFlags().StringSliceVarP(&flag.geoipRules.DenyCountries, "geoip-deny-countries", "", nil, "ISO country codes of clients to deny")
+	rootCmd.PersistentFlags().UintSliceVarP(&flag.geoipRules.AllowASNs, "geoip-allow-asns", "", nil, "autonomous system numbers of clients to allow, denying others not in --geoip-allow-countries")
+	rootCmd.PersistentFlags().UintSliceVarP(&flag.geoipRules.DenyASNs, "geoip-deny-asns", "", nil, "autonomous system numbers of clients to deny")
+	rootCmd.PersistentFlags().StringVarP(&flag.serverVersion, "server-version", "", "", `identification string sent to clients, "SSH-2.0-" prepended if missing (e.g. "OpenSSH_9.6") (default: "SSH-2.0-Go")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.algorithms.Ciphers, "ciphers", "", "", `ciphers like Ciphers of sshd_config, "+", "-" or "^" to append, remove or prepend to the defaults (e.g. "-aes128-ctr,aes192-ctr")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.algorithms.MACs, "macs", "", "", `MAC algorithms like MACs of sshd_config (e.g. "-hmac-sha1*")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.algorithms.KeyExchanges, "kex-algorithms", "", "", `key exchange algorithms like KexAlgorithms of sshd_config (e.g. "-diffie-hellman-group14-sha1")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.algorithms.PostQuantum, "post-quantum-kex", "", "", `"prefer", "require" or "disable" hybrid post-quantum key exchanges such as mlkem768x25519-sha256 (default: as --kex-algorithms)`)
+	rootCmd.PersistentFlags().BoolVarP(&flag.genericOpenFailures, "generic-open-failures", "", false, `send only the reason such as "connect failed" to clients when channels are rejected, not destinations and errors`)
+	rootCmd.PersistentFlags().StringVarP(&flag.channelRate, "channel-rate", "", "", `channels per second each connection can open as "RATE" or "RATE:BURST", rejecting ones over it (e.g. "10:50") (default: no limit)`)
+	rootCmd.PersistentFlags().StringVarP(&flag.forwardChannelRate, "forward-channel-rate", "", "", `direct-tcpip and direct-streamlocal channels per second each connection can open in addition to --channel-rate, e.g. against port scans through the server (e.g. "5:20") (default: no limit)`)
+	rootCmd.PersistentFlags().IntVarP(&flag.maxChannels, "max-channels", "", 0, "channels each connection can open at once such as sessions and forwards, rejecting ones over it (0 for no limit)")
+	rootCmd.PersistentFlags().StringVarP(&flag.maxTransfer, "max-transfer", "", "", `bytes each connection can receive and send through sessions, SFTP and forwards with "K", "M" or "G", closing it over them (e.g. "1G") (default: no limit)`)
+	rootCmd.PersistentFlags().DurationVarP(&flag.stallTimeout, "stall-timeout", "", 0, `time a write of a forwarding relay can block before closing the relay, disconnecting clients not reading (e.g. "2m") (default: no limit)`)
+	rootCmd.PersistentFlags().BoolVarP(&flag.fips, "fips", "", false, "restrict algorithms and host keys to FIPS 140-3 approved ones, failing unless the Go Cryptographic Module is in FIPS mode")
+	rootCmd.PersistentFlags().DurationVarP(&flag.obscureKeystrokeTiming, "obscure-keystroke-timing", "", 0, `interval to write the output of pty sessions in while typing, with chaff, to hide the timing of keystrokes like ObscureKeystrokeTiming of OpenSSH (e.g. "20ms") (default: disabled)`)
+	rootCmd.PersistentFlags().StringVarP(&flag.rekeyLimit, "rekey-limit", "", "", `data after which keys are renegotiated with "K", "M" or "G" like RekeyLimit of sshd_config (e.g. "1G") (default: depending on the cipher)`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.userAccess.AllowUsers, "allow-user", "", nil, `pattern of users to allow before authentication, denying others (e.g. "john", "deploy@10.0.0.0/8")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.userAccess.DenyUsers, "deny-user", "", nil, `pattern of users to deny before authentication (e.g. "root", "*@192.168.1.*")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.userAccess.AllowGroups, "allow-group", "", nil, `pattern of OS groups of users to allow, denying others (e.g. "ssh-users")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.userAccess.DenyGroups, "deny-group", "", nil, `pattern of OS groups of users to deny (e.g. "guests")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.accessRules, "access-rules", "", "", "file of rules to allow or deny users by source addresses and hours like hosts.allow, read again on reload")
+	rootCmd.PersistentFlags().IntVarP(&flag.minRSAKeyBits, "min-rsa-key-bits", "", 3072, "minimum size of RSA public keys of clients (0: no limit)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowDSAKeys, "allow-dsa-keys", "", false, "allow DSA public keys of clients")
+	rootCmd.PersistentFlags().BoolVarP(&flag.denyECDSAKeys, "deny-ecdsa-keys", "", false, "deny ECDSA public keys of clients on NIST curves")
+	rootCmd.PersistentFlags().StringVarP(&flag.chrootDirectory, "chroot-directory", "", "", `directory to chroot shell and exec sessions into, owned by root and not writable by others, with %u and %h (e.g. "/srv/jail/%u")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.resourceLimits, "resource-limits", "", "", `limits of processes of sessions on Linux, "cpu" in seconds, "as" in bytes with "K", "M" or "G", "nofile" and "nproc" (e.g. "cpu=3600,as=2G,nofile=1024,nproc=256")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.cgroupParent, "cgroup-parent", "", "", `cgroup v2 directory to create a cgroup per session in on Linux (e.g. "/sys/fs/cgroup/go-sshd")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.cgroupLimits, "cgroup-limits", "", "", `cgroup controls of sessions in --cgroup-parent, "cpu.weight", "memory.max" in bytes with "K", "M" or "G" and "pids.max" (e.g. "cpu.weight=50,memory.max=1G,pids.max=256")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.namespaces, "namespaces", "", "", `new Linux namespaces of shell and exec sessions, "mount", "pid", "ipc", "uts", and "net=none" or "net=" a network namespace to join (e.g. "mount,pid,net=none")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.sandboxUser, "sandbox-user", "", "", `OS user to run shell, exec and SFTP of all users as (e.g. "sshd-sandbox")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.seccompProfile, "seccomp-profile", "", "", "seccomp profile in the format of Docker to run shell and exec sessions under on Linux")
+	rootCmd.PersistentFlags().StringVarP(&flag.opaURL, "opa-url", "", "", `Open Policy Agent decision URL to authorize exec, SFTP and forwarding (e.g. "http://127.0.0.1:8181/v1/data/sshd/allow")`)
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.opaPolicies, "opa-policy", "", nil, "Rego policy file evaluated in process to authorize exec, SFTP and forwarding instead of --opa-url")
+	rootCmd.PersistentFlags().StringVarP(&flag.opaQuery, "opa-query", "", opa.DefaultQuery, "decision of --opa-policy")
+
+	// Gateway flags
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.upstreams, "upstream", "", nil, `backend SSH server to proxy connections to (e.g. "10.0.0.2:22" for all users, "john=10.0.0.3:22" for "john")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.upstreamIdentity, "upstream-identity", "", "", "private key file to authenticate with backend SSH servers")
+	rootCmd.PersistentFlags().StringVarP(&flag.upstreamKnownHosts, "upstream-known-hosts", "", "", "known_hosts file to verify backend SSH servers")
+	rootCmd.PersistentFlags().BoolVarP(&flag.upstreamInsecure, "upstream-insecure-ignore-host-key", "", false, "connect to backend SSH servers without verifying their host keys instead of requiring --upstream-known-hosts (insecure)")
+
+	// Docker flags
+	rootCmd.PersistentFlags().StringVarP(&flag.dockerImage, "docker-image", "", "", "run shell/exec in a new Docker container of the image per session (e.g. alpine)")
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.dockerUserImages, "docker-user-image", "", nil, `Docker image for the user (e.g. "john=ubuntu:24.04")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.dockerShell, "docker-shell", "", "/bin/sh", "shell in Docker containers")
+	rootCmd.PersistentFlags().StringArrayVarP(&flag.dockerMounts, "docker-mount", "", nil, `volume to mount to Docker containers (e.g. "/srv/data:/data:ro")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.dockerCPUs, "docker-cpus", "", "", `CPU limit of Docker containers (e.g. "0.5")`)
+	rootCmd.PersistentFlags().StringVarP(&flag.dockerMemory, "docker-memory", "", "", `memory limit of Docker containers (e.g. "256m")`)
+	rootCmd.PersistentFlags().IntVarP(&flag.dockerPidsLimit, "docker-pids-limit", "", 0, "process limit of Docker containers")
+	rootCmd.PersistentFlags().StringVarP(&flag.dockerNetwork, "docker-network", "", "", `network of Docker containers (e.g. "none")`)
+
+	// Kubernetes flags
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesPod, "kubernetes-pod", "", "", "run shell/exec in the existing Kubernetes pod")
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesImage, "kubernetes-image", "", "", "run shell/exec in a new Kubernetes pod of the image per session")
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesContext, "kubernetes-context", "", "", "kubeconfig context")
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesNamespace, "kubernetes-namespace", "", "", "Kubernetes namespace of pods")
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesContainer, "kubernetes-container", "", "", "container in --kubernetes-pod")
+	rootCmd.PersistentFlags().StringVarP(&flag.kubernetesShell, "kubernetes-shell", "", "/bin/sh", "shell in Kubernetes pods")
 
 	// Permission flags
 	rootCmd.PersistentFlags().BoolVarP(&flag.allowTcpipForward, "allow-tcpip-forward", "", false, "client can use remote forwarding (ssh -R)")
@@ -94,8 +445,31 @@ For example, specifying --allow-direct-tcpip and --allow-execute allows only the
 	rootCmd.PersistentFlags().BoolVarP(&flag.allowSftp, "allow-sftp", "", false, "client can use SFTP and SSHFS")
 	rootCmd.PersistentFlags().BoolVarP(&flag.allowStreamlocalForward, "allow-streamlocal-forward", "", false, "client can use Unix domain socket remote forwarding (ssh -R)")
 	rootCmd.PersistentFlags().BoolVarP(&flag.allowDirectStreamlocal, "allow-direct-streamlocal", "", false, "client can use Unix domain socket local forwarding (ssh -L)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowPty, "allow-pty", "", false, "client can request pseudo terminals")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowScp, "allow-scp", "", false, "client can execute scp without --allow-execute")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowAgentForward, "allow-agent-forward", "", false, "client can use agent forwarding (ssh -A)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowX11Forward, "allow-x11-forward", "", false, "client can use X11 forwarding (ssh -X)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowAttach, "allow-attach", "", false, "client can attach read-only to pty sessions of its user and --attach-targets by go-sshd-attach (not allowed by default)")
+	rootCmd.PersistentFlags().BoolVarP(&flag.allowAttachWrite, "allow-attach-write", "", false, "client can attach read-write to pty sessions of its user and --attach-targets by go-sshd-attach -w (not allowed by default)")
+	rootCmd.PersistentFlags().StringSliceVarP(&flag.attachTargets, "attach-targets", "", nil, `users whose pty sessions users of --allow-attach can attach to, or "*" for all (default: only their own)`)
+	rootCmd.PersistentFlags().BoolVarP(&flag.denyPty, "deny-pty", "", false, "client can not request pseudo terminals")
+	rootCmd.PersistentFlags().BoolVarP(&flag.denyAll, "deny-all", "", false, "allow only the specified permissions even if none is specified")
 
-	return &rootCmd
+	rootCmd.AddCommand(versionCmd())
+	rootCmd.AddCommand(initCmd())
+	rootCmd.AddCommand(keygenCmd())
+	rootCmd.AddCommand(passwdCmd())
+	rootCmd.AddCommand(fingerprintCmd(&flag, allPermissionFlags))
+	rootCmd.AddCommand(checkCmd(&flag, allPermissionFlags))
+	rootCmd.AddCommand(printConfigCmd(&flag, allPermissionFlags))
+	rootCmd.AddCommand(sessionsCmd(&flag))
+	rootCmd.AddCommand(selftestCmd(&flag))
+	rootCmd.AddCommand(benchCmd(&flag))
+	rootCmd.AddCommand(auditCmd())
+	rootCmd.AddCommand(playCmd())
+	rootCmd.AddCommand(exportCmd())
+	rootCmd.AddCommand(knockCmd())
+	return &rootCmd, &flag, allPermissionFlags
 }
 
 func rootRunEWithExtra(cmd *cobra.Command, args []string, flag *flagType, allPermissionFlags []permissionFlagType) error {
@@ -103,10 +477,152 @@ func rootRunEWithExtra(cmd *cobra.Command, args []string, flag *flagType, allPer
 		fmt.Fprintln(cmd.OutOrStdout(), version.Version)
 		return nil
 	}
+	if flag.check {
+		return runCheck(cmd.OutOrStdout(), flag, allPermissionFlags)
+	}
+	upgrader, err := upgrade.New()
+	if err != nil {
+		return err
+	}
+	// Upgraded processes are already in the background
+	if flag.daemon && !daemon.Daemonized() && !upgrader.Upgraded() {
+		return daemon.Detach()
+	}
+	restoreLog, err := setupLog(flag)
+	if err != nil {
+		return err
+	}
+	defer restoreLog()
 	logger := slog.Default()
+	info := version.Get()
+	logger.Info("starting go-sshd", "version", info.Version, "commit", info.Commit, "build_date", info.BuildDate, "go_version", info.GoVersion)
+	auditLogger, err := openAuditLog(flag)
+	if err != nil {
+		return err
+	}
+	if auditLogger != nil {
+		defer auditLogger.Close()
+	}
+	var auditdLogger *auditd.Logger
+	if flag.auditd {
+		auditdLogger, err = auditd.Open()
+		if err != nil {
+			return fmt.Errorf("--auditd: %w", err)
+		}
+		defer auditdLogger.Close()
+	}
+	notifier, err := newWebhookNotifier(logger, flag)
+	if err != nil {
+		return err
+	}
+	if notifier != nil {
+		defer notifier.Close()
+	}
+	loginNotifier, err := newLoginNotifier(logger, flag)
+	if err != nil {
+		return err
+	}
+	if loginNotifier != nil {
+		defer loginNotifier.Close()
+	}
+	trafficStore, err := openTrafficFile(flag)
+	if err != nil {
+		return err
+	}
+	adminToken, err := readAdminToken(flag)
+	if err != nil {
+		return err
+	}
+	if err := checkAdminPprof(flag); err != nil {
+		return err
+	}
+	banTarpit, err := newTarpit(flag)
+	if err != nil {
+		return err
+	}
+	knockGate, err := newKnockGate(logger, flag)
+	if err != nil {
+		return err
+	}
+	copyBufferSize, err := parseByteSize(flag.copyBufferSize)
+	if err != nil || copyBufferSize < 1<<10 || copyBufferSize > 64<<20 {
+		return fmt.Errorf("--copy-buffer-size must be from 1K to 64M: %s", flag.copyBufferSize)
+	}
+	forward.SetCopyBufferSize(int(copyBufferSize))
+	handshakePool, channelPool, err := newPools(flag)
+	if err != nil {
+		return err
+	}
+	var runAs *daemon.Credential
+	if flag.runAs != "" {
+		if flag.namespaces != "" {
+			return fmt.Errorf("--namespaces requires root, which --run-as drops")
+		}
+		runAs, err = daemon.LookupCredential(flag.runAs)
+		if err != nil {
+			return fmt.Errorf("--run-as: %w", err)
+		}
+	}
+	var sandbox func(configs []instanceConfig) *daemon.Sandbox
+	if flag.pledge {
+		if runtime.GOOS != "openbsd" {
+			return fmt.Errorf("--pledge is supported only on OpenBSD")
+		}
+		if flag.chrootDirectory != "" {
+			return fmt.Errorf("--chroot-directory is not allowed by --pledge")
+		}
+		sandbox = func(configs []instanceConfig) *daemon.Sandbox {
+			return newSandbox(flag, configs)
+		}
+	}
+	sup := &supervisor{
+		audit:               auditLogger,
+		webhook:             notifier,
+		loginNotifier:       loginNotifier,
+		traffic:             trafficStore,
+		trafficSaveInterval: flag.trafficSaveInterval,
+		adminListen:         flag.adminListen,
+		adminGRPCListen:     flag.adminGRPCListen,
+		adminToken:          adminToken,
+		adminPprof:          flag.adminPprof,
+		tarpit:              banTarpit,
+		knock:               knockGate,
+		knockHost:           flag.sshHost,
+		knockSPAPort:        flag.knockSPAPort,
+		handshakePool:       handshakePool,
+		channelPool:         channelPool,
+		logger:              logger,
+		upgrader:            upgrader,
+		drainTimeout:        flag.drainTimeout,
+		pidFile:             flag.pidFile,
+		runAs:               runAs,
+		sandbox:             sandbox,
+		controlSocket:       flag.controlSocket,
+		metricsListen:       flag.metricsListen,
+		load: func() ([]instanceConfig, error) {
+			return loadConfigs(logger, flag, allPermissionFlags)
+		},
+	}
+	return sup.run(cmd.Context())
+}
 
+// loadConfigs returns the settings of servers by flag, --config and --sshd-config
+func loadConfigs(logger *slog.Logger, flag *flagType, allPermissionFlags []permissionFlagType) ([]instanceConfig, error) {
+	configs := []instanceConfig{{flag: flag, allPermissionFlags: allPermissionFlags}}
+	if flag.configFile != "" {
+		var err error
+		configs, err = loadInstanceConfigs(flag.configFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return applySshdConfigs(logger, configs)
+}
+
+// newServer creates a server from flag
+func newServer(logger *slog.Logger, flag *flagType, allPermissionFlags []permissionFlagType) (*server.Server, error) {
 	// Allow all permissions if all permission is not set
-	{
+	if !flag.denyAll {
 		allPermissionFalse := true
 		for _, permissionFlag := range allPermissionFlags {
 			allPermissionFalse = allPermissionFalse && !*permissionFlag.flagPtr
@@ -116,6 +632,18 @@ func rootRunEWithExtra(cmd *cobra.Command, args []string, flag *flagType, allPer
 				*permissionFlag.flagPtr = true
 			}
 		}
+		// --allow-execute has allowed pty and scp before they became permissions
+		if flag.allowExecute {
+			flag.allowPty = true
+			flag.allowScp = true
+		}
+	}
+	if flag.denyPty {
+		flag.allowPty = false
+	}
+
+	if _, err := reusePortListeners(flag); err != nil {
+		return nil, err
 	}
 
 	sshServer := &server.Server{
@@ -126,85 +654,582 @@ func rootRunEWithExtra(cmd *cobra.Command, args []string, flag *flagType, allPer
 		AllowSftp:               flag.allowSftp,
 		AllowStreamlocalForward: flag.allowStreamlocalForward,
 		AllowDirectStreamlocal:  flag.allowDirectStreamlocal,
+		AllowScp:                flag.allowScp,
+		AllowAgentForward:       flag.allowAgentForward,
+		AllowX11Forward:         flag.allowX11Forward,
+		AllowAttach:             flag.allowAttach,
+		AllowAttachWrite:        flag.allowAttachWrite,
+		AttachTargets:           flag.attachTargets,
+		DenyPty:                 !flag.allowPty,
+		GenericOpenFailures:     flag.genericOpenFailures,
+		MaxChannels:             flag.maxChannels,
+		StallTimeout:            flag.stallTimeout,
+		ChrootDirectory:         flag.chrootDirectory,
+		CgroupParent:            flag.cgroupParent,
+		SeccompProfile:          flag.seccompProfile,
+		Privileged:              daemon.RunPrivileged,
+		ObscureKeystrokeTiming:  flag.obscureKeystrokeTiming,
+		ClientVersions: server.ClientVersionPolicy{
+			Allow:       flag.allowClientVersions,
+			Deny:        flag.denyClientVersions,
+			MinVersions: flag.minClientVersions,
+		},
+	}
+	resourceLimits, err := server.ParseResourceLimits(flag.resourceLimits)
+	if err != nil {
+		return nil, fmt.Errorf("--resource-limits: %w", err)
+	}
+	if !resourceLimits.IsZero() && flag.chrootDirectory != "" {
+		return nil, fmt.Errorf("--resource-limits can not be used with --chroot-directory")
+	}
+	sshServer.ResourceLimits = resourceLimits
+	if flag.windowsExecShell != server.WindowsExecCmd && flag.windowsExecShell != server.WindowsExecPowerShell {
+		return nil, fmt.Errorf(`--windows-exec-shell must be "cmd" or "powershell": %s`, flag.windowsExecShell)
+	}
+	if flag.loginShells && runtime.GOOS == "windows" {
+		return nil, fmt.Errorf("--login-shell is not supported on Windows")
+	}
+	if flag.maxChannels < 0 {
+		return nil, fmt.Errorf("--max-channels must not be negative: %d", flag.maxChannels)
+	}
+	if flag.maxTransfer != "" {
+		if sshServer.MaxTransfer, err = parseByteSize(flag.maxTransfer); err != nil {
+			return nil, fmt.Errorf("--max-transfer: %w", err)
+		}
+	}
+	if flag.stallTimeout < 0 {
+		return nil, fmt.Errorf("--stall-timeout must not be negative: %s", flag.stallTimeout)
+	}
+	if sshServer.ChannelRate, err = server.ParseRateLimit(flag.channelRate); err != nil {
+		return nil, fmt.Errorf("--channel-rate: %w", err)
+	}
+	if sshServer.ForwardChannelRate, err = server.ParseRateLimit(flag.forwardChannelRate); err != nil {
+		return nil, fmt.Errorf("--forward-channel-rate: %w", err)
+	}
+	if len(flag.geoipDBs) != 0 {
+		sshServer.GeoIP, err = geoip.Open(flag.geoipDBs...)
+		if err != nil {
+			return nil, fmt.Errorf("--geoip-db: %w", err)
+		}
+		sshServer.GeoIPRules = flag.geoipRules
+	} else if !flag.geoipRules.IsZero() {
+		return nil, fmt.Errorf("--geoip-allow-* and --geoip-deny-* require --geoip-db")
+	}
+	cgroupLimits, err := server.ParseCgroupLimits(flag.cgroupLimits)
+	if err != nil {
+		return nil, fmt.Errorf("--cgroup-limits: %w", err)
+	}
+	if !cgroupLimits.IsZero() && flag.cgroupParent == "" {
+		return nil, fmt.Errorf("--cgroup-limits requires --cgroup-parent")
+	}
+	sshServer.CgroupLimits = cgroupLimits
+	if err := sshServer.ClientVersions.Validate(); err != nil {
+		return nil, fmt.Errorf("--min-client-version: %w", err)
+	}
+	if flag.disconnectMalformed {
+		sshServer.MalformedRequests = server.MalformedRequestDisconnect
+	}
+	if flag.opaURL != "" && len(flag.opaPolicies) != 0 {
+		return nil, fmt.Errorf("--opa-url can not be used with --opa-policy")
+	}
+	if flag.opaURL != "" {
+		sshServer.Authorizer = &opa.Authorizer{URL: flag.opaURL}
+	}
+	if len(flag.opaPolicies) != 0 {
+		policy, err := opa.LoadPolicy(flag.opaQuery, flag.opaPolicies)
+		if err != nil {
+			return nil, fmt.Errorf("--opa-policy: %w", err)
+		}
+		sshServer.Authorizer = policy
+	}
+	if len(flag.upstreams) != 0 {
+		upstream, err := upstreamFunc(logger, flag)
+		if err != nil {
+			return nil, err
+		}
+		sshServer.Upstream = upstream
+	}
+	usesDocker := flag.dockerImage != "" || len(flag.dockerUserImages) != 0
+	usesKubernetes := flag.kubernetesPod != "" || flag.kubernetesImage != ""
+	if usesDocker && usesKubernetes {
+		return nil, fmt.Errorf("Docker and Kubernetes can not be used together")
+	}
+	if flag.chrootDirectory != "" && (usesDocker || usesKubernetes) {
+		return nil, fmt.Errorf("--chroot-directory can not be used with Docker or Kubernetes")
+	}
+	if flag.cgroupParent != "" && (usesDocker || usesKubernetes) {
+		return nil, fmt.Errorf("--cgroup-parent can not be used with Docker or Kubernetes")
+	}
+	sshServer.Namespaces, err = namespaces.Parse(flag.namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("--namespaces: %w", err)
+	}
+	if !sshServer.Namespaces.IsZero() && (flag.chrootDirectory != "" || usesDocker || usesKubernetes) {
+		return nil, fmt.Errorf("--namespaces can not be used with --chroot-directory, Docker or Kubernetes")
+	}
+	if flag.sandboxUser != "" {
+		if !sshServer.Namespaces.IsZero() || sshServer.Authorizer != nil || usesDocker || usesKubernetes {
+			return nil, fmt.Errorf("--sandbox-user can not be used with --namespaces, --opa-url, --opa-policy, Docker or Kubernetes")
+		}
+		sshServer.SandboxUser, err = server.LookupSandboxUser(flag.sandboxUser)
+		if err != nil {
+			return nil, fmt.Errorf("--sandbox-user: %w", err)
+		}
+	}
+	if flag.seccompProfile != "" {
+		if flag.chrootDirectory != "" || usesDocker || usesKubernetes {
+			return nil, fmt.Errorf("--seccomp-profile can not be used with --chroot-directory, Docker or Kubernetes")
+		}
+		if _, err := seccomp.LoadProfile(flag.seccompProfile); err != nil {
+			return nil, fmt.Errorf("--seccomp-profile: %w", err)
+		}
+	}
+	if usesDocker {
+		sshServer.Executor = dockerExecutor(logger, flag)
+	}
+	if usesKubernetes {
+		sshServer.Executor = &executor.Kubernetes{
+			Default: executor.KubernetesConfig{
+				Context:   flag.kubernetesContext,
+				Namespace: flag.kubernetesNamespace,
+				Pod:       flag.kubernetesPod,
+				Container: flag.kubernetesContainer,
+				Image:     flag.kubernetesImage,
+				Shell:     flag.kubernetesShell,
+			},
+			Logger: logger,
+		}
 	}
-	var sshUsers []sshUser
-	for _, u := range flag.sshUsers {
-		splits := strings.SplitN(u, ":", 2)
-		if len(splits) != 2 {
-			return fmt.Errorf("invalid user format: %s", u)
+	sshUsers, err := auth.ParseStaticUsers(flag.sshUsers)
+	if err != nil {
+		return nil, err
+	}
+	passwordChain := auth.PasswordChain{sshUsers}
+	var publicKeyChain auth.PublicKeyChain
+	if flag.userStore != "" {
+		store, err := userstore.LoadFile(flag.userStore)
+		if err != nil {
+			return nil, err
+		}
+		userStoreAuthenticator := &userstore.Authenticator{Store: store, UsedCredentialsFile: flag.userStore + usedCredentialsSuffix}
+		if err := userStoreAuthenticator.LoadUsedCredentials(); err != nil {
+			return nil, err
+		}
+		sshServer.CheckLogin = userStoreAuthenticator.CheckLogin
+		passwordChain = append(passwordChain, userStoreAuthenticator)
+		publicKeyChain = append(publicKeyChain, userStoreAuthenticator)
+	}
+	if len(flag.authorizedKeysFiles) != 0 {
+		publicKeyChain = append(publicKeyChain, auth.AuthorizedKeysFiles(flag.authorizedKeysFiles))
+	}
+	if flag.authorizedKeysURL != "" {
+		authorizedKeysURL, err := newAuthorizedKeysURL(logger, flag)
+		if err != nil {
+			return nil, err
 		}
-		sshUsers = append(sshUsers, sshUser{name: splits[0], password: splits[1]})
+		publicKeyChain = append(publicKeyChain, authorizedKeysURL)
 	}
-	if len(sshUsers) == 0 {
-		return fmt.Errorf(`No user specified
+	if flag.forgeKeys != "" {
+		forgeKeys, err := newForgeKeys(logger, flag)
+		if err != nil {
+			return nil, err
+		}
+		publicKeyChain = append(publicKeyChain, forgeKeys)
+	}
+	if len(sshUsers) == 0 && len(publicKeyChain) == 0 {
+		return nil, fmt.Errorf(`No user specified
 e.g. --user "john:mypass"
 e.g. --user "john:"`)
 	}
 	// (base: https://gist.github.com/jpillora/b480fde82bff51a06238)
 	sshConfig := &ssh.ServerConfig{
-		//Define a function to run when a client attempts a password login
-		PasswordCallback: func(metadata ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
-			for _, user := range sshUsers {
-				// No auth required
-				if user.name == metadata.User() && user.password == string(pass) {
-					return nil, nil
-				}
+		PasswordCallback:     passwordChain.PasswordCallback,
+		NoClientAuth:         true,
+		NoClientAuthCallback: sshUsers.NoClientAuthCallback,
+	}
+	fips, err := checkFIPS(flag)
+	if err != nil {
+		return nil, err
+	}
+	minRSAKeyBits := flag.minRSAKeyBits
+	if fips && minRSAKeyBits < server.FIPSMinRSABits {
+		minRSAKeyBits = server.FIPSMinRSABits
+	}
+	if len(publicKeyChain) != 0 {
+		keyStrength := &auth.KeyStrength{
+			Authenticator: publicKeyChain,
+			MinRSABits:    minRSAKeyBits,
+			AllowDSA:      flag.allowDSAKeys,
+			DenyECDSA:     flag.denyECDSAKeys,
+			Logger:        logger,
+		}
+		sshConfig.PublicKeyCallback = keyStrength.PublicKeyCallback
+	}
+	if flag.sshd != nil && sshdPerConnection(flag.sshd) {
+		auth.WithExtensions(sshConfig, func(conn ssh.ConnMetadata) map[string]string {
+			return sshdMatchExtensions(flag, flag.sshd.ConnSettings(conn.User(), conn.RemoteAddr()))
+		})
+	}
+	userAccess := flag.userAccess
+	userAccess.Logger = logger
+	userAccess.Apply(sshConfig)
+	if flag.accessRules != "" {
+		rules, err := access.Load(flag.accessRules)
+		if err != nil {
+			return nil, fmt.Errorf("--access-rules: %w", err)
+		}
+		sshServer.CheckConn = func(remoteAddr net.Addr) error {
+			return rules.CheckAddr(remoteAddr, time.Now())
+		}
+		auth.WithCheck(sshConfig, func(conn ssh.ConnMetadata) error {
+			err := rules.Check(conn.User(), conn.RemoteAddr(), time.Now())
+			if err != nil {
+				logger.Info("denied user", "user", conn.User(), "remote_address", conn.RemoteAddr().String(), "reason", err)
+				return fmt.Errorf("%q denied: %w", conn.User(), err)
 			}
-			return nil, fmt.Errorf("password rejected for %q", metadata.User())
-		},
-		NoClientAuth: true,
-		NoClientAuthCallback: func(metadata ssh.ConnMetadata) (*ssh.Permissions, error) {
-			for _, user := range sshUsers {
-				// No auth required
-				if user.name == metadata.User() && user.password == "" {
-					return nil, nil
-				}
+			return nil
+		})
+	}
+	sshConfig.AuthLogCallback = sshServer.AuthLog
+	if flag.serverVersion != "" {
+		sshConfig.ServerVersion, err = serverVersion(flag.serverVersion)
+		if err != nil {
+			return nil, fmt.Errorf("--server-version: %w", err)
+		}
+	}
+	algorithms := flag.algorithms
+	algorithms.FIPS = fips
+	if err := algorithms.Apply(&sshConfig.Config); err != nil {
+		return nil, err
+	}
+	if fips {
+		sshConfig.PublicKeyAuthAlgorithms = server.FIPSPublicKeyAlgorithms
+	}
+	addHostKey := func(name string, signer ssh.Signer) error {
+		if fips {
+			var err error
+			if signer, err = server.FIPSSigner(signer); err != nil {
+				return fmt.Errorf("host key %s: %w", name, err)
 			}
-			return nil, fmt.Errorf("%s auth required", metadata.User())
-		},
+		}
+		sshConfig.AddHostKey(signer)
+		return nil
+	}
+	if sshConfig.RekeyThreshold, err = parseRekeyLimit(flag.rekeyLimit); err != nil {
+		return nil, fmt.Errorf("--rekey-limit: %w", err)
+	}
+	if len(flag.hostKeys) == 0 {
+		pri, err := ssh.ParsePrivateKey([]byte(defaultHostKeyPem))
+		if err != nil {
+			return nil, err
+		}
+		if err := addHostKey("built-in", pri); err != nil {
+			return nil, err
+		}
+	}
+	for _, hostKey := range flag.hostKeys {
+		keyBytes, err := os.ReadFile(hostKey)
+		if err != nil {
+			return nil, err
+		}
+		pri, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host key %s: %w", hostKey, err)
+		}
+		if err := addHostKey(hostKey, pri); err != nil {
+			return nil, err
+		}
+	}
+
+	showPermissions(logger, allPermissionFlags)
+
+	if flag.connectionLogDir != "" {
+		if err := os.MkdirAll(flag.connectionLogDir, 0700); err != nil {
+			return nil, err
+		}
+		sshServer.ConnectionLog = connectionLogFile(flag.connectionLogDir)
+	}
+	if flag.sessionRecordingDir != "" {
+		if err := os.MkdirAll(flag.sessionRecordingDir, 0700); err != nil {
+			return nil, err
+		}
+		sshServer.SessionRecording = sessionRecordingFile(flag.sessionRecordingDir)
+	}
+	if flag.commandHistoryDir != "" {
+		if err := os.MkdirAll(flag.commandHistoryDir, 0700); err != nil {
+			return nil, err
+		}
+		sshServer.CommandHistory = commandHistoryFile(logger, flag.commandHistoryDir)
+		sshServer.HistoryShellInput = flag.historyShellInput
+	} else if flag.historyShellInput {
+		return nil, fmt.Errorf("--command-history-shell-input requires --command-history-dir")
+	}
+
+	sshServer.Config = sshConfig
+	sshServer.Shell = flag.sshShell
+	sshServer.LoginShells = flag.loginShells
+	sshServer.WindowsExecShell = flag.windowsExecShell
+	return sshServer, nil
+}
+
+// serverVersion returns the identification string of version with "SSH-2.0-" prepended if missing.
+// It must be printable ASCII within 255 bytes with CR LF (RFC 4253 section 4.2).
+func serverVersion(version string) (string, error) {
+	if !strings.HasPrefix(version, "SSH-") {
+		version = "SSH-2.0-" + version
+	}
+	if !strings.HasPrefix(version, "SSH-2.0-") {
+		return "", fmt.Errorf("%s is not for SSH 2.0", version)
+	}
+	software, _, _ := strings.Cut(strings.TrimPrefix(version, "SSH-2.0-"), " ")
+	if software == "" || strings.Contains(software, "-") {
+		return "", fmt.Errorf("%s has no software version or one with \"-\"", version)
+	}
+	for _, c := range []byte(version) {
+		if c < ' ' || c > '~' {
+			return "", fmt.Errorf("%q has non-printable characters", version)
+		}
+	}
+	if len(version)+len("\r\n") > 255 {
+		return "", fmt.Errorf("%s is longer than 253 bytes", version)
 	}
-	// TODO: specify priv_key by flags
-	pri, err := ssh.ParsePrivateKey([]byte(defaultHostKeyPem))
+	return version, nil
+}
+
+// parseRekeyLimit returns the bytes of limit like "512K", "1G" or "default", 0 for the default
+func parseRekeyLimit(limit string) (uint64, error) {
+	if limit == "" || limit == "default" {
+		return 0, nil
+	}
+	n, err := parseByteSize(limit)
 	if err != nil {
-		return err
+		return 0, fmt.Errorf("invalid limit: %s", limit)
+	}
+	// Like OpenSSH. golang.org/x/crypto/ssh raises ones less than 256 bytes to 256 bytes.
+	if n < 16 {
+		return 0, fmt.Errorf("%s is less than 16 bytes", limit)
+	}
+	return n, nil
+}
+
+// newPools returns the handshake pool and the channel pool shared by all servers, which are nil without limits
+func newPools(flag *flagType) (handshakePool, channelPool *server.Pool, err error) {
+	if flag.maxHandshakes < 0 || flag.maxChannelWorkers < 0 || flag.workerQueueSize < 0 || flag.workerQueueTimeout < 0 {
+		return nil, nil, errors.New("--max-handshakes, --max-channel-workers, --worker-queue-size and --worker-queue-timeout must not be negative")
+	}
+	newPool := func(size int) *server.Pool {
+		if size == 0 {
+			return nil
+		}
+		return &server.Pool{Size: size, QueueSize: flag.workerQueueSize, QueueTimeout: flag.workerQueueTimeout}
+	}
+	return newPool(flag.maxHandshakes), newPool(flag.maxChannelWorkers), nil
+}
+
+// parseByteSize parses a number of bytes with an optional suffix of "K", "M" or "G"
+func parseByteSize(s string) (uint64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+	number, unit := s, uint64(1)
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		unit = 1 << 10
+	case 'M', 'm':
+		unit = 1 << 20
+	case 'G', 'g':
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		number = s[:len(s)-1]
 	}
-	sshConfig.AddHostKey(pri)
+	n, err := strconv.ParseUint(number, 10, 64)
+	if err != nil || n > math.MaxUint64/unit {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * unit, nil
+}
+
+// listenKey identifies the listener of flag
+func listenKey(flag *flagType) string {
+	key := "tcp:" + net.JoinHostPort(flag.sshHost, strconv.Itoa(int(flag.sshPort)))
+	if flag.vsock != "" {
+		key = "vsock:" + flag.vsock
+	} else if flag.sshUnixSocket != "" {
+		key = "unix:" + flag.sshUnixSocket
+	}
+	if flag.httpConnect {
+		key += "+http-connect"
+	}
+	return key
+}
 
-	var ln net.Listener
-	if flag.sshUnixSocket == "" {
+// reusePortListeners returns the number of sockets with SO_REUSEPORT by --reuseport and --reuseport-per-cpu, or 0 for one socket without it
+func reusePortListeners(flag *flagType) (int, error) {
+	n := flag.reusePort
+	if flag.reusePortPerCPU && n == 0 {
+		n = runtime.NumCPU()
+	}
+	switch {
+	case n < 0:
+		return 0, fmt.Errorf("--reuseport must not be negative: %d", n)
+	case n != 0 && (flag.vsock != "" || flag.sshUnixSocket != ""):
+		return 0, fmt.Errorf("--reuseport and --reuseport-per-cpu require a TCP address")
+	}
+	return n, nil
+}
+
+// listen starts listening by flag. It returns multiple listeners on the same address with --reuseport.
+func listen(logger *slog.Logger, upgrader *upgrade.Upgrader, flag *flagType) ([]net.Listener, error) {
+	var lns []net.Listener
+	if flag.vsock != "" {
+		addr, err := vsock.ParseAddr(flag.vsock)
+		if err != nil {
+			return nil, err
+		}
+		ln, err := vsock.Listen(addr)
+		if err != nil {
+			return nil, err
+		}
+		lns = append(lns, ln)
+		logger.Info(fmt.Sprintf("listening on %s...", ln.Addr()))
+	} else if flag.sshUnixSocket == "" {
 		address := net.JoinHostPort(flag.sshHost, strconv.Itoa(int(flag.sshPort)))
-		ln, err = net.Listen("tcp", address)
+		reusePort, err := reusePortListeners(flag)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		if reusePort == 0 {
+			ln, err := upgrader.Listen("tcp", address)
+			if err != nil {
+				return nil, err
+			}
+			lns = append(lns, ln)
+			logger.Info(fmt.Sprintf("listening on %s...", address))
+		} else {
+			lns, err = listenReusePort(upgrader, address, reusePort, flag.reusePortPerCPU)
+			if err != nil {
+				return nil, err
+			}
+			logger.Info(fmt.Sprintf("listening on %s by %d sockets with SO_REUSEPORT...", address, reusePort))
 		}
-		logger.Info(fmt.Sprintf("listening on %s...", address))
 	} else {
-		ln, err = net.Listen("unix", flag.sshUnixSocket)
+		ln, err := upgrader.Listen("unix", flag.sshUnixSocket)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		lns = append(lns, ln)
 		logger.Info(fmt.Sprintf("listening on %s...", flag.sshUnixSocket))
 	}
-	defer ln.Close()
-
-	showPermissions(logger, allPermissionFlags)
+	if flag.httpConnect {
+		for i, ln := range lns {
+			lns[i] = httpconnect.NewListener(ln)
+		}
+		logger.Info("accepting HTTP CONNECT requests")
+	}
+	return lns, nil
+}
 
-	for {
-		conn, err := ln.Accept()
+// listenReusePort listens on address by n sockets with SO_REUSEPORT, the i-th preferring connections received on CPU i if perCPU
+func listenReusePort(upgrader *upgrade.Upgrader, address string, n int, perCPU bool) ([]net.Listener, error) {
+	var lns []net.Listener
+	for i := 0; i < n; i++ {
+		cpu := reuseport.CPUAny
+		if perCPU {
+			cpu = i % runtime.NumCPU()
+		}
+		config, err := reuseport.ListenConfig(cpu)
 		if err != nil {
-			logger.Error("failed to accept TCP connection", "err", err)
-			continue
+			return nil, err
 		}
-		sshConn, chans, reqs, err := ssh.NewServerConn(conn, sshConfig)
+		ln, err := upgrader.ListenConfig(config, "tcp", address, strconv.Itoa(i))
 		if err != nil {
-			logger.Info("failed to handshake", "err", err)
-			conn.Close()
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+// upstreamFunc returns a function choosing a backend by user name for the gateway mode
+func upstreamFunc(logger *slog.Logger, flag *flagType) (func(conn ssh.ConnMetadata) (*server.Upstream, error), error) {
+	var defaultAddress string
+	userToAddress := map[string]string{}
+	for _, u := range flag.upstreams {
+		user, address, found := strings.Cut(u, "=")
+		if !found {
+			defaultAddress = u
 			continue
 		}
-		logger.Info("new SSH connection", "remote_address", sshConn.RemoteAddr(), "client_version", string(sshConn.ClientVersion()))
-		go sshServer.HandleGlobalRequests(sshConn, reqs)
-		go sshServer.HandleChannels(flag.sshShell, chans)
+		userToAddress[user] = address
+	}
+	var authMethods []ssh.AuthMethod
+	if flag.upstreamIdentity != "" {
+		keyBytes, err := os.ReadFile(flag.upstreamIdentity)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse upstream identity: %w", err)
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case flag.upstreamKnownHosts != "":
+		var err error
+		hostKeyCallback, err = knownhosts.New(flag.upstreamKnownHosts)
+		if err != nil {
+			return nil, err
+		}
+	case flag.upstreamInsecure:
+		logger.Warn("host keys of upstreams are not verified (--upstream-insecure-ignore-host-key)")
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		// Proxied sessions could be intercepted otherwise
+		return nil, errors.New("--upstream requires --upstream-known-hosts or --upstream-insecure-ignore-host-key")
+	}
+	return func(conn ssh.ConnMetadata) (*server.Upstream, error) {
+		address, ok := userToAddress[conn.User()]
+		if !ok {
+			address = defaultAddress
+		}
+		if address == "" {
+			return nil, fmt.Errorf("no upstream for %q", conn.User())
+		}
+		return &server.Upstream{
+			Address: address,
+			ClientConfig: &ssh.ClientConfig{
+				User:            conn.User(),
+				Auth:            authMethods,
+				HostKeyCallback: hostKeyCallback,
+			},
+		}, nil
+	}, nil
+}
+
+func dockerExecutor(logger *slog.Logger, flag *flagType) *executor.Docker {
+	config := executor.DockerConfig{
+		Image:     flag.dockerImage,
+		Shell:     flag.dockerShell,
+		Mounts:    flag.dockerMounts,
+		CPUs:      flag.dockerCPUs,
+		Memory:    flag.dockerMemory,
+		PidsLimit: flag.dockerPidsLimit,
+		Network:   flag.dockerNetwork,
+	}
+	userConfigs := map[string]executor.DockerConfig{}
+	for _, userImage := range flag.dockerUserImages {
+		user, image, _ := strings.Cut(userImage, "=")
+		userConfig := config
+		userConfig.Image = image
+		userConfigs[user] = userConfig
 	}
+	return &executor.Docker{Default: config, Users: userConfigs, Logger: logger}
 }
 
 func showPermissions(logger *slog.Logger, allPermissionFlags []permissionFlagType) {
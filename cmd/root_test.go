@@ -1,15 +1,27 @@
 package cmd
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/John-Ao/go-sshd/server"
 	"github.com/John-Ao/go-sshd/version"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -22,6 +34,16 @@ func TestVersion(t *testing.T) {
 	assert.Equal(t, version.Version+"\n", stdoutBuf.String())
 }
 
+func TestVersionCmd(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"version"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	assert.NoError(t, rootCmd.Execute())
+	info := version.Get()
+	assert.Equal(t, "go-sshd "+version.Version+"\ncommit: "+info.Commit+"\nbuild date: unknown\ngo version: "+runtime.Version()+"\nplatform: "+runtime.GOOS+"/"+runtime.GOARCH+"\n", stdoutBuf.String())
+}
+
 func TestZeroUsers(t *testing.T) {
 	rootCmd := RootCmd()
 	rootCmd.SetArgs([]string{})
@@ -34,6 +56,16 @@ e.g. --user "john:"
 `, stderrBuf.String())
 }
 
+func TestEmptyUser(t *testing.T) {
+	// e.g. --user "$USER_PASS" with USER_PASS unset
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--user", ""})
+	rootCmd.SetErr(&bytes.Buffer{})
+	err := rootCmd.Execute()
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "No user specified"))
+}
+
 func TestAllPermissionsAllowed(t *testing.T) {
 	rootCmd := RootCmd()
 	port := getAvailableTcpPort()
@@ -174,6 +206,37 @@ func TestAllowExecute(t *testing.T) {
 	assertNoUnixLocalPortForwarding(t, client)
 }
 
+func TestDenyAll(t *testing.T) {
+	rootCmd := RootCmd()
+	port := getAvailableTcpPort()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--deny-all", "--allow-execute"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	sshClientConfig := &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	client, err := ssh.Dial("tcp", address, sshClientConfig)
+	assert.NoError(t, err)
+	defer client.Close()
+	assertNoRemotePortForwarding(t, client)
+	assertNoLocalPortForwarding(t, client)
+	assertExec(t, client)
+	// Pty is not implied by --allow-execute with --deny-all
+	assertNoPtyTerminal(t, client)
+	assertNoSftp(t, client)
+	assertNoUnixRemotePortForwarding(t, client)
+	assertNoUnixLocalPortForwarding(t, client)
+}
+
 func TestAllowTcpipForward(t *testing.T) {
 	rootCmd := RootCmd()
 	port := getAvailableTcpPort()
@@ -327,3 +390,561 @@ func TestAllowSftp(t *testing.T) {
 	assertNoUnixRemotePortForwarding(t, client)
 	assertSftp(t, client)
 }
+
+func TestUserStore(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	userStorePath := filepath.Join(t.TempDir(), "users.yaml")
+	assert.NoError(t, os.WriteFile(userStorePath, []byte(`users:
+  - name: alex
+    password_hash: `+string(hash)+`
+    permissions: [sftp]
+`), 0600))
+	rootCmd := RootCmd()
+	port := getAvailableTcpPort()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user-store", userStorePath})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	sshClientConfig := &ssh.ClientConfig{
+		User:            "alex",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	}
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	client, err := ssh.Dial("tcp", address, sshClientConfig)
+	assert.NoError(t, err)
+	defer client.Close()
+	// Only the permissions of the user are allowed though the server allows all
+	assertNoLocalPortForwarding(t, client)
+	assertNoExec(t, client)
+	assertSftp(t, client)
+}
+
+func TestConfigFile(t *testing.T) {
+	port1 := getAvailableTcpPort()
+	port2 := getAvailableTcpPort()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`servers:
+  - name: tenant-a
+    port: `+strconv.Itoa(port1)+`
+    user: ["john:mypass"]
+    allow-execute: true
+  - name: tenant-b
+    port: `+strconv.Itoa(port2)+`
+    user: ["alex:pass2"]
+    allow-sftp: true
+`), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--config", configPath})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port1)
+	waitTCPServer(port2)
+
+	client1, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port1)), &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.NoError(t, err)
+	defer client1.Close()
+	assertExec(t, client1)
+	assertNoSftp(t, client1)
+
+	// Users of other servers can not log in
+	_, err = ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port2)), &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.Error(t, err)
+	client2, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port2)), &ssh.ClientConfig{
+		User:            "alex",
+		Auth:            []ssh.AuthMethod{ssh.Password("pass2")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.NoError(t, err)
+	defer client2.Close()
+	assertNoExec(t, client2)
+	assertSftp(t, client2)
+}
+
+func TestConfigFileDuplicateServer(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`servers:
+  - name: a
+    user: ["john:"]
+  - name: a
+    user: ["john:"]
+`), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--config", configPath})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "duplicate server: a")
+}
+
+func TestConfigFileInclude(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "conf.d"), 0700))
+	for name, content := range map[string]string{
+		"config.yaml": "servers:\n  - name: main\n    user: [\"john:\"]\ninclude: [conf.d, \"extra-*.yaml\"]\n",
+		// Included in the order of the names
+		"conf.d/20-team-b.yaml": "servers:\n  - name: team-b\n    user: [\"alex:\"]\n",
+		"conf.d/10-team-a.yml":  "servers:\n  - name: team-a\n    user: [\"alex:\"]\ninclude: [../nested.yaml]\n",
+		"conf.d/README":         "not a config",
+		"nested.yaml":           "servers:\n  - name: nested\n    user: [\"alex:\"]\n",
+	} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	profiles, err := loadConfigFile(filepath.Join(dir, "config.yaml"))
+	assert.NoError(t, err)
+	var names []string
+	for _, p := range profiles {
+		names = append(names, p.name)
+	}
+	assert.Equal(t, []string{"main", "team-a", "nested", "team-b"}, names)
+
+	// Servers of fragments must have unique names
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "extra-1.yaml"), []byte("servers:\n  - name: team-a\n"), 0600))
+	_, err = loadConfigFile(filepath.Join(dir, "config.yaml"))
+	assert.EqualError(t, err, "duplicate server: team-a")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "loop.yaml"), []byte("include: [loop.yaml]\n"), 0600))
+	_, err = loadConfigFile(filepath.Join(dir, "loop.yaml"))
+	assert.ErrorContains(t, err, "too deeply nested")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "missing.yaml"), []byte("include: [missing.d/a.yaml]\n"), 0600))
+	_, err = loadConfigFile(filepath.Join(dir, "missing.yaml"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestConfigFileProcessFlag(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`servers:
+  - name: a
+    user: ["john:"]
+    log-file: a.log
+`), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--config", configPath})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "server a: log-file can not be used in a server")
+}
+
+func TestLogFile(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "go-sshd.log")
+	rootCmd := RootCmd()
+	port := getAvailableTcpPort()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--log-file", logFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rootCmd.ExecuteContext(ctx)
+		close(done)
+	}()
+	waitTCPServer(port)
+	cancel()
+	// The log output is restored on exit
+	<-done
+	b, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), fmt.Sprintf("listening on :%d...", port))
+}
+
+func TestLogFormatJSON(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "go-sshd.log")
+	rootCmd := RootCmd()
+	port := getAvailableTcpPort()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--log-file", logFile, "--log-format", "json"})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		rootCmd.ExecuteContext(ctx)
+		close(done)
+	}()
+	waitTCPServer(port)
+	client, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.NoError(t, err)
+	client.Close()
+	cancel()
+	<-done
+	b, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	var connected map[string]any
+	for _, line := range bytes.Split(bytes.TrimSpace(b), []byte("\n")) {
+		var entry map[string]any
+		assert.NoError(t, json.Unmarshal(line, &entry), string(line))
+		assert.Contains(t, entry, "time")
+		assert.Contains(t, entry, "level")
+		if entry["msg"] == "new SSH connection" {
+			connected = entry
+		}
+	}
+	assert.Equal(t, "john", connected["user"])
+	assert.Contains(t, connected, "conn_id")
+	assert.Contains(t, connected, "remote_address")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"--log-format", "xml"})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "invalid log format: xml (text or json)")
+}
+
+func TestHTTPConnect(t *testing.T) {
+	rootCmd := RootCmd()
+	port := getAvailableTcpPort()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--http-connect"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	address := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	conn, err := net.Dial("tcp", address)
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("CONNECT " + address + " HTTP/1.1\r\nHost: " + address + "\r\n\r\n"))
+	assert.NoError(t, err)
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	sshConn, chans, reqs, err := ssh.NewClientConn(&bufferedConn{Conn: conn, r: br}, address, &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.NoError(t, err)
+	client := ssh.NewClient(sshConn, chans, reqs)
+	defer client.Close()
+	assertExec(t, client)
+}
+
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+func TestSshdConfig(t *testing.T) {
+	dir := t.TempDir()
+	signers := map[string]ssh.Signer{}
+	for _, user := range []string{"john", "alex", "sam"} {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		assert.NoError(t, err)
+		signer, err := ssh.NewSignerFromKey(priv)
+		assert.NoError(t, err)
+		signers[user] = signer
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, user), ssh.MarshalAuthorizedKey(signer.PublicKey()), 0600))
+	}
+	port := getAvailableTcpPort()
+	sshdConfigPath := filepath.Join(dir, "sshd_config")
+	assert.NoError(t, os.WriteFile(sshdConfigPath, []byte(`Port `+strconv.Itoa(port)+`
+ListenAddress 127.0.0.1
+AuthorizedKeysFile `+filepath.Join(dir, "%u")+`
+Subsystem sftp internal-sftp
+AllowTcpForwarding no
+UsePAM yes
+
+Match User alex
+	AllowTcpForwarding local
+	PermitTTY no
+Match User sam
+	ForceCommand echo forced
+`), 0600))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--sshd-config", sshdConfigPath})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	dial := func(user string) *ssh.Client {
+		client, err := ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers[user])},
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		assert.NoError(t, err)
+		return client
+	}
+
+	john := dial("john")
+	defer john.Close()
+	assertExec(t, john)
+	assertSftp(t, john)
+	assertNoLocalPortForwarding(t, john)
+	assertNoRemotePortForwarding(t, john)
+
+	alex := dial("alex")
+	defer alex.Close()
+	assertExec(t, alex)
+	assertNoPtyTerminal(t, alex)
+	assertLocalPortForwarding(t, alex)
+	assertNoRemotePortForwarding(t, alex)
+
+	sam := dial("sam")
+	defer sam.Close()
+	session, err := sam.NewSession()
+	if !assert.NoError(t, err) {
+		return
+	}
+	output, err := session.Output("whoami")
+	assert.NoError(t, err)
+	assert.Equal(t, "forced\n", string(output))
+	session, err = sam.NewSession()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer session.Close()
+	assert.Error(t, session.RequestSubsystem("sftp"))
+}
+
+func TestMinClientVersionInvalid(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--min-client-version", "OpenSSH"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--min-client-version: OpenSSH is not in the form of <software>_<version>")
+}
+
+func TestServerVersion(t *testing.T) {
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--server-version", "OpenSSH_9.6"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	assert.Equal(t, "SSH-2.0-OpenSSH_9.6", string(client.ServerVersion()))
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--server-version", "SSH-1.5-Go"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--server-version: SSH-1.5-Go is not for SSH 2.0")
+}
+
+func TestAlgorithmsInvalid(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--macs", "hmac-md5"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), `MACs: unsupported algorithm: "hmac-md5"`)
+}
+
+func TestFIPSWithoutModule(t *testing.T) {
+	if server.FIPSEnabled() {
+		t.Skip("FIPS mode is enabled")
+	}
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--fips"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "FIPS mode requires the Go Cryptographic Module in FIPS 140-3 mode (Go 1.24 or later with GODEBUG=fips140=on or built with GOFIPS140)")
+}
+
+func TestParseRekeyLimit(t *testing.T) {
+	for limit, expected := range map[string]uint64{"": 0, "default": 0, "4096": 4096, "512K": 512 << 10, "1G": 1 << 30, "2m": 2 << 20} {
+		n, err := parseRekeyLimit(limit)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, n, limit)
+	}
+	for _, limit := range []string{"1T", "G", "-1", "8", "99999999999999999999G"} {
+		_, err := parseRekeyLimit(limit)
+		assert.Error(t, err, limit)
+	}
+}
+
+func TestInvalidCopyBufferSize(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--copy-buffer-size", "128M"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--copy-buffer-size must be from 1K to 64M: 128M")
+}
+
+func TestInvalidWorkerPool(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--max-channel-workers", "-1"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--max-handshakes, --max-channel-workers, --worker-queue-size and --worker-queue-timeout must not be negative")
+}
+
+func TestInvalidChannelLimits(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--forward-channel-rate", "5:0"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--forward-channel-rate: invalid burst: 0")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--max-channels", "-1"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--max-channels must not be negative: -1")
+
+	rootCmd = RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--max-transfer", "1T"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--max-transfer: invalid size: 1T")
+}
+
+func TestInvalidWindowsExecShell(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--windows-exec-shell", "bash"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), `--windows-exec-shell must be "cmd" or "powershell": bash`)
+}
+
+func TestReusePort(t *testing.T) {
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--reuseport", "3", "--reuseport-per-cpu"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	for i := 0; i < 6; i++ {
+		client, err := dialPassword(port, "john", "mypass")
+		if !assert.NoError(t, err) {
+			return
+		}
+		assertExec(t, client)
+		client.Close()
+	}
+}
+
+func TestReusePortWithUnixSocket(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--unix-socket", filepath.Join(t.TempDir(), "sshd.sock"), "--reuseport", "2"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--reuseport and --reuseport-per-cpu require a TCP address")
+}
+
+func TestDenyUser(t *testing.T) {
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--user", "alex:mypass", "--deny-user", "john@127.0.0.1"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	_, err := dialPassword(port, "john", "mypass")
+	assert.Error(t, err)
+	client, err := dialPassword(port, "alex", "mypass")
+	if !assert.NoError(t, err) {
+		return
+	}
+	client.Close()
+}
+
+func TestAccessRules(t *testing.T) {
+	rulesFile := filepath.Join(t.TempDir(), "access.rules")
+	assert.NoError(t, os.WriteFile(rulesFile, []byte("allow alex 127.0.0.1\ndeny * 127.0.0.0/8\n"), 0600))
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--user", "alex:mypass", "--access-rules", rulesFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	_, err := dialPassword(port, "john", "mypass")
+	assert.Error(t, err)
+	client, err := dialPassword(port, "alex", "mypass")
+	if !assert.NoError(t, err) {
+		return
+	}
+	client.Close()
+}
+
+func TestOpaPolicy(t *testing.T) {
+	policyFile := filepath.Join(t.TempDir(), "policy.rego")
+	assert.NoError(t, os.WriteFile(policyFile, []byte("package sshd\n\nallow if input.command[0] == \"whoami\"\n"), 0600))
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--opa-policy", policyFile})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		var stderrBuf bytes.Buffer
+		rootCmd.SetErr(&stderrBuf)
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer client.Close()
+	assertExec(t, client)
+	session, err := client.NewSession()
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer session.Close()
+	assert.Error(t, session.Run("id"))
+}
+
+func TestOpaPolicyWithURL(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--opa-url", "http://127.0.0.1:8181/v1/data/sshd/allow", "--opa-policy", "policy.rego"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--opa-url can not be used with --opa-policy")
+}
+
+func TestUpstreamHostKeys(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--user", "john:mypass", "--upstream", "127.0.0.1:22"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--upstream requires --upstream-known-hosts or --upstream-insecure-ignore-host-key")
+}
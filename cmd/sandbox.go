@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/daemon"
+	"github.com/John-Ao/go-sshd/userstore"
+)
+
+// pledgePromises are the promises of --pledge: serving, SFTP, ptys and starting processes as users
+const pledgePromises = "stdio rpath wpath cpath fattr chown flock unix inet dns getpw sendfd recvfd tty proc exec id"
+
+// newSandbox returns the sandbox of --pledge for the process flag and the servers of configs.
+// Files are visible to be read again on reloads, directories written with new files to be created.
+func newSandbox(flag *flagType, configs []instanceConfig) *daemon.Sandbox {
+	s := &daemon.Sandbox{Promises: pledgePromises}
+	// Users, groups, hosts and TLS certificates, terminals and the null device
+	s.Unveil("/etc", "r")
+	s.Unveil("/usr/share/zoneinfo", "r")
+	s.Unveil("/dev", "rw")
+	// Shells, and sockets of agent forwarding and home directories for SFTP
+	for _, dir := range []string{"/bin", "/usr/bin", "/usr/local/bin"} {
+		s.Unveil(dir, "rx")
+	}
+	for _, dir := range []string{os.TempDir(), "/home", "/root"} {
+		s.Unveil(dir, "rwc")
+	}
+	// The executable is started again by upgrades
+	if executable, err := os.Executable(); err == nil {
+		s.Unveil(executable, "rx")
+	}
+	// Files included from the directory of the config are read again on reloads
+	if flag.configFile != "" {
+		s.Unveil(filepath.Dir(flag.configFile), "r")
+	}
+	s.Unveil(flag.loginNotifyTemplateFile, "r")
+	for _, path := range []string{flag.pidFile, flag.controlSocket, flag.auditLog} {
+		s.Unveil(path, "rwc")
+	}
+	for _, path := range []string{flag.logFile, flag.trafficFile, flag.loginNotifyKnownAddresses} {
+		if path != "" {
+			s.Unveil(filepath.Dir(path), "rwc")
+		}
+	}
+	for _, config := range configs {
+		f := config.flag
+		if f.sshdConfig != "" {
+			s.Unveil(filepath.Dir(f.sshdConfig), "r")
+		}
+		paths := append([]string{f.userStore, f.upstreamIdentity, f.upstreamKnownHosts, f.accessRules, f.forgeTokenFile}, f.hostKeys...)
+		for _, path := range append(paths, f.geoipDBs...) {
+			s.Unveil(path, "r")
+		}
+		for _, path := range f.authorizedKeysFiles {
+			s.Unveil(patternDir(path), "r")
+		}
+		s.Unveil(f.sshShell, "rx")
+		s.Unveil(f.connectionLogDir, "rwc")
+		s.Unveil(f.sessionRecordingDir, "rwc")
+		s.Unveil(f.commandHistoryDir, "rwc")
+		if f.userStore != "" {
+			if store, err := userstore.LoadFile(f.userStore); err == nil {
+				for _, user := range store.Users() {
+					s.Unveil(user.HomeDir, "rwc")
+					s.Unveil(user.Shell, "rx")
+					if len(user.Credentials) != 0 {
+						// The file of used one-time credentials is replaced by renaming
+						s.Unveil(filepath.Dir(f.userStore), "rwc")
+					}
+				}
+			}
+		}
+	}
+	return s
+}
+
+// patternDir returns path without the components from the first one with "%" such as "%h" and "%u".
+// It is empty for paths starting with "%".
+func patternDir(path string) string {
+	i := strings.IndexByte(path, '%')
+	if i < 0 {
+		return path
+	}
+	if i == 0 {
+		return ""
+	}
+	return filepath.Dir(path[:i] + "x")
+}
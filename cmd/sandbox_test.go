@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSandbox(t *testing.T) {
+	dir := t.TempDir()
+	userStore := filepath.Join(dir, "users.yaml")
+	assert.NoError(t, os.WriteFile(userStore, []byte("users:\n  - name: john\n    home_dir: /srv/john\n    shell: /usr/local/bin/fish\n"), 0600))
+	flag := &flagType{pidFile: "/var/run/go-sshd.pid", logFile: "/var/log/go-sshd/sshd.log"}
+	server := &flagType{
+		hostKeys:            []string{"/etc/go-sshd/host_key"},
+		authorizedKeysFiles: []string{"%h/.ssh/authorized_keys", "/var/keys/%u"},
+		userStore:           userStore,
+		sshShell:            "/bin/ksh",
+	}
+	s := newSandbox(flag, []instanceConfig{{flag: server}})
+	assert.Equal(t, pledgePromises, s.Promises)
+	for path, permissions := range map[string]string{
+		"/etc":                  "r",
+		"/dev":                  "rw",
+		"/home":                 "rwc",
+		"/var/run/go-sshd.pid":  "rwc",
+		"/var/log/go-sshd":      "rwc",
+		"/etc/go-sshd/host_key": "r",
+		"/var/keys":             "r",
+		userStore:               "r",
+		"/bin":                  "rx",
+		"/srv/john":             "rwc",
+		"/usr/local/bin/fish":   "rx",
+	} {
+		assert.Equal(t, permissions, s.Paths[path], path)
+	}
+	assert.NotContains(t, s.Paths, "")
+	assert.NotContains(t, s.Paths, "/var/keys/%u")
+}
+
+func TestPledge(t *testing.T) {
+	if runtime.GOOS == "openbsd" {
+		t.Skip("pledge is supported")
+	}
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--pledge"})
+	assert.EqualError(t, rootCmd.Execute(), "--pledge is supported only on OpenBSD")
+}
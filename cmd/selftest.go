@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// selftestUser is the user of the ephemeral instance of the selftest command
+const selftestUser = "selftest"
+
+type selftestOptions struct {
+	target             string
+	login              string
+	identities         []string
+	passwordStdin      bool
+	hostKeyFingerprint string
+	timeout            time.Duration
+	// ephemeralShell is the shell of the ephemeral server
+	ephemeralShell string
+	stdin          io.Reader
+}
+
+// selftestCheck is a check run over a connection. run returns an error on failure.
+type selftestCheck struct {
+	name string
+	run  func(client *ssh.Client, token string) error
+}
+
+var selftestChecks = []selftestCheck{
+	{name: "shell", run: selftestShell},
+	{name: "exec", run: selftestExec},
+	{name: "sftp", run: selftestSftp},
+	{name: "forward", run: selftestForward},
+}
+
+func selftestCmd(flag *flagType) *cobra.Command {
+	var opts selftestOptions
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Connect as a client and exercise shell, exec, sftp and a loopback forward",
+		Long: `Connect as a client and exercise shell, exec, sftp and a loopback forward, reporting pass or fail of each check.
+Without --target, an ephemeral server with all permissions and the --shell listens on 127.0.0.1 for the test.
+The loopback forward listens on 127.0.0.1 of the server by remote forwarding and connects to it by local forwarding.`,
+		Example: `go-sshd selftest
+go-sshd selftest --target ssh.example.com:2222 --login john -i ~/.ssh/id_ed25519
+echo mypass | go-sshd selftest --target 127.0.0.1:2222 --login john --password-stdin`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.ephemeralShell = flag.sshShell
+			opts.stdin = cmd.InOrStdin()
+			return runSelftest(cmd.OutOrStdout(), &opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.target, "target", "", "", "address of a running server to test (e.g. 127.0.0.1:2222) instead of an ephemeral one")
+	cmd.Flags().StringVarP(&opts.login, "login", "l", "", "user to log in to --target")
+	cmd.Flags().StringArrayVarP(&opts.identities, "identity", "i", nil, "private key file to authenticate to --target")
+	cmd.Flags().BoolVarP(&opts.passwordStdin, "password-stdin", "", false, "read the password for --target from the first line of stdin")
+	cmd.Flags().StringVarP(&opts.hostKeyFingerprint, "host-key-fingerprint", "", "", "expected SHA256 fingerprint of the host key of --target (default: any)")
+	cmd.Flags().DurationVarP(&opts.timeout, "timeout", "", 30*time.Second, "time limit of all checks")
+	return cmd
+}
+
+func runSelftest(out io.Writer, opts *selftestOptions) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	defer w.Flush()
+	token, err := selftestToken()
+	if err != nil {
+		return err
+	}
+	address, config, stop, err := targetConfig(opts, token)
+	if err != nil {
+		return err
+	}
+	defer stop()
+	var fingerprint string
+	checkHostKey := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint = ssh.FingerprintSHA256(key)
+		return checkHostKey(hostname, remote, key)
+	}
+
+	client, err := ssh.Dial("tcp", address, config)
+	if err != nil {
+		fmt.Fprintf(w, "FAIL\tconnect\t%s: %v\n", address, err)
+		for _, check := range selftestChecks {
+			fmt.Fprintf(w, "SKIP\t%s\n", check.name)
+		}
+		return fmt.Errorf("%d of %d checks failed", len(selftestChecks)+1, len(selftestChecks)+1)
+	}
+	defer client.Close()
+	// Hanging checks fail by closing the connection
+	timer := time.AfterFunc(opts.timeout, func() { client.Close() })
+	defer timer.Stop()
+	fmt.Fprintf(w, "PASS\tconnect\t%s (%s)\n", address, fingerprint)
+	failures := 0
+	for _, check := range selftestChecks {
+		start := time.Now()
+		if err := check.run(client, token); err != nil {
+			fmt.Fprintf(w, "FAIL\t%s\t%v\n", check.name, err)
+			failures++
+			continue
+		}
+		fmt.Fprintf(w, "PASS\t%s\t%s\n", check.name, time.Since(start).Round(time.Millisecond))
+	}
+	if failures != 0 {
+		return fmt.Errorf("%d of %d checks failed", failures, len(selftestChecks)+1)
+	}
+	return nil
+}
+
+// targetConfig returns the address and the client config of --target, or of an ephemeral server with token as the password.
+// Call stop after use.
+func targetConfig(opts *selftestOptions, token string) (address string, config *ssh.ClientConfig, stop func(), err error) {
+	address = opts.target
+	config = &ssh.ClientConfig{
+		User:    opts.login,
+		Timeout: opts.timeout,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if fingerprint := ssh.FingerprintSHA256(key); opts.hostKeyFingerprint != "" && fingerprint != opts.hostKeyFingerprint {
+				return fmt.Errorf("host key mismatch: %s", fingerprint)
+			}
+			return nil
+		},
+	}
+	stop = func() {}
+	if address == "" {
+		address, stop, err = startEphemeralServer(opts, token)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		config.User = selftestUser
+		config.Auth = []ssh.AuthMethod{ssh.Password(token)}
+		return address, config, stop, nil
+	}
+	if opts.login == "" {
+		return "", nil, nil, errors.New("--login is required with --target")
+	}
+	config.Auth, err = selftestAuthMethods(opts)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return address, config, stop, nil
+}
+
+func selftestToken() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "go-sshd-selftest-" + hex.EncodeToString(b), nil
+}
+
+// startEphemeralServer serves with all permissions for selftestUser authenticated by password on a port of 127.0.0.1
+func startEphemeralServer(opts *selftestOptions, password string) (string, func(), error) {
+	_, flag, allPermissionFlags := newRootCmd()
+	flag.sshUsers = []string{selftestUser + ":" + password}
+	flag.sshShell = opts.ephemeralShell
+	s, err := newServer(slog.New(slog.NewTextHandler(io.Discard, nil)), flag, allPermissionFlags)
+	if err != nil {
+		return "", nil, err
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	go s.Serve(ln)
+	return ln.Addr().String(), func() { ln.Close() }, nil
+}
+
+func selftestAuthMethods(opts *selftestOptions) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	var signers []ssh.Signer
+	for _, identity := range opts.identities {
+		b, err := os.ReadFile(identity)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", identity, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) != 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	if opts.passwordStdin {
+		line, err := bufio.NewReader(opts.stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		methods = append(methods, ssh.Password(strings.TrimRight(line, "\r\n")))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("--identity or --password-stdin is required with --target")
+	}
+	return methods, nil
+}
+
+// selftestShell runs a shell echoing token
+func selftestShell(client *ssh.Client, token string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	var stdout bytes.Buffer
+	session.Stdin = strings.NewReader("echo " + token + "\nexit\n")
+	session.Stdout = &stdout
+	if err := session.Shell(); err != nil {
+		return err
+	}
+	if err := session.Wait(); err != nil {
+		return err
+	}
+	if !strings.Contains(stdout.String(), token) {
+		return fmt.Errorf("unexpected output: %q", stdout.String())
+	}
+	return nil
+}
+
+// selftestExec executes a command echoing token
+func selftestExec(client *ssh.Client, token string) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+	output, err := session.Output("echo " + token)
+	if err != nil {
+		return err
+	}
+	if strings.TrimRight(string(output), "\r\n") != token {
+		return fmt.Errorf("unexpected output: %q", output)
+	}
+	return nil
+}
+
+// selftestSftp writes, reads and removes a file in the working directory
+func selftestSftp(client *ssh.Client, token string) error {
+	sftpClient, err := sftp.NewClient(client)
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Close()
+	wd, err := sftpClient.Getwd()
+	if err != nil {
+		return err
+	}
+	name := path.Join(wd, "."+token)
+	f, err := sftpClient.Create(name)
+	if err != nil {
+		return err
+	}
+	defer sftpClient.Remove(name)
+	_, err = f.Write([]byte(token))
+	f.Close()
+	if err != nil {
+		return err
+	}
+	f, err = sftpClient.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	if string(b) != token {
+		return fmt.Errorf("unexpected content: %q", b)
+	}
+	return sftpClient.Remove(name)
+}
+
+// selftestForward sends token through a remote forward on the server by a local forward and receives it back
+func selftestForward(client *ssh.Client, token string) error {
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("remote forward: %w", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		return fmt.Errorf("local forward: %w", err)
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, token); err != nil {
+		return err
+	}
+	b := make([]byte, len(token))
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return err
+	}
+	if string(b) != token {
+		return fmt.Errorf("unexpected data: %q", b)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSelftest(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"selftest"})
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	require.NoError(t, rootCmd.Execute())
+	lines := strings.Split(strings.TrimSuffix(stdoutBuf.String(), "\n"), "\n")
+	require.Len(t, lines, 5)
+	for i, name := range []string{"connect", "shell", "exec", "sftp", "forward"} {
+		assert.Regexp(t, `^PASS +`+name+` `, lines[i])
+	}
+}
+
+func TestSelftestTarget(t *testing.T) {
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--allow-execute", "--allow-sftp"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	target := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	runSelftestCmd := func(password string) (string, error) {
+		rootCmd := RootCmd()
+		rootCmd.SetArgs([]string{"selftest", "--target", target, "--login", "john", "--password-stdin"})
+		rootCmd.SetIn(strings.NewReader(password + "\n"))
+		var stdoutBuf bytes.Buffer
+		rootCmd.SetOut(&stdoutBuf)
+		rootCmd.SetErr(&bytes.Buffer{})
+		err := rootCmd.Execute()
+		return stdoutBuf.String(), err
+	}
+	// Forwarding is not allowed
+	output, err := runSelftestCmd("mypass")
+	assert.EqualError(t, err, "1 of 5 checks failed")
+	assert.Regexp(t, `(?m)^PASS +sftp `, output)
+	assert.Regexp(t, `(?m)^FAIL +forward +remote forward: `, output)
+
+	output, err = runSelftestCmd("wrong")
+	assert.EqualError(t, err, "5 of 5 checks failed")
+	assert.Regexp(t, `(?m)^FAIL +connect `, output)
+	assert.Regexp(t, `(?m)^SKIP +shell$`, output)
+}
@@ -0,0 +1,662 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/John-Ao/go-sshd/accounting"
+	"github.com/John-Ao/go-sshd/admin"
+	"github.com/John-Ao/go-sshd/audit"
+	"github.com/John-Ao/go-sshd/auditd"
+	"github.com/John-Ao/go-sshd/control"
+	"github.com/John-Ao/go-sshd/daemon"
+	"github.com/John-Ao/go-sshd/knock"
+	"github.com/John-Ao/go-sshd/metrics"
+	"github.com/John-Ao/go-sshd/notify"
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/tarpit"
+	"github.com/John-Ao/go-sshd/upgrade"
+	"github.com/John-Ao/go-sshd/webhook"
+
+	"github.com/spf13/pflag"
+	"golang.org/x/exp/slog"
+)
+
+// instanceConfig is the settings of a server. name is empty without the config file.
+type instanceConfig struct {
+	name               string
+	flag               *flagType
+	allPermissionFlags []permissionFlagType
+	// flagSet is the flags parsed from the config file. It is nil for the command line.
+	flagSet *pflag.FlagSet
+}
+
+// instance is listeners on the same address serving connections with the latest server.
+// There are multiple listeners with --reuseport.
+type instance struct {
+	key    string
+	lns    []net.Listener
+	server atomic.Pointer[server.Server]
+	// closed is true when the listener is closed by reload or shutdown
+	closed atomic.Bool
+}
+
+func (inst *instance) close() {
+	inst.closed.Store(true)
+	for _, ln := range inst.lns {
+		ln.Close()
+	}
+}
+
+// supervisor runs the servers loaded by load.
+// On reloadSignals, it loads the settings again and applies them to new connections.
+// On upgradeSignals, it starts a new process inheriting the listeners and returns after draining connections.
+// On stopSignals, it returns after draining connections. Another stop signal closes the remaining connections.
+// On dumpSignals, it logs the statistics of the servers and a snapshot of connections, sessions, forwards and goroutines.
+type supervisor struct {
+	logger       *slog.Logger
+	upgrader     *upgrade.Upgrader
+	drainTimeout time.Duration
+	// pidFile is written after listening if not empty
+	pidFile string
+	// runAs is the user and groups to switch to after listening and serving the control socket if not nil
+	runAs *daemon.Credential
+	// sandbox returns the sandbox to restrict the process to after dropping privileges if not nil
+	sandbox func(configs []instanceConfig) *daemon.Sandbox
+	// controlSocket is the path of the control socket served after listening if not empty
+	controlSocket string
+	// metricsListen is the address to serve metrics if not empty
+	metricsListen string
+	// adminListen and adminGRPCListen are the addresses to serve the admin API authenticated by adminToken if not empty
+	adminListen     string
+	adminGRPCListen string
+	adminToken      []byte
+	// adminPprof serves profiles on adminListen to "loopback" or "any" clients if not empty
+	adminPprof string
+	// bans are managed by the admin API
+	bans admin.Bans
+	// tarpit holds connections from banned addresses instead of closing them if not nil
+	tarpit *tarpit.Tarpit
+	// knock resets connections from addresses not knocking on the ports of knockHost if not nil
+	knock        *knock.Gate
+	knockHost    string
+	knockSPAPort uint16
+	// handshakePool and channelPool bound the handshakes and the channels of all servers if not nil
+	handshakePool *server.Pool
+	channelPool   *server.Pool
+	// events are streamed by the admin HTTP and gRPC APIs
+	events admin.Events
+	// audit records events of all servers if not nil
+	audit *audit.Logger
+	// auditd sends records of events of all servers to the Linux audit subsystem if not nil
+	auditd *auditd.Logger
+	// webhook notifies events of all servers if not nil
+	webhook *webhook.Notifier
+	// loginNotifier notifies logins of all servers if not nil
+	loginNotifier *notify.Notifier
+	// traffic accumulates the traffic by user every trafficSaveInterval if not nil
+	traffic             *accounting.Store
+	trafficSaveInterval time.Duration
+	load                func() ([]instanceConfig, error)
+
+	mu sync.Mutex
+	// instances by listenKey
+	instances map[string]*instance
+	// servers are all servers created including ones replaced by reload, which may still serve connections
+	servers []*server.Server
+	// retiredStats is the sum of the statistics of servers removed from servers
+	retiredStats server.Stats
+	errCh        chan error
+	// configs are the settings loaded last by reload
+	configs []instanceConfig
+	// started is closed by run when connections can be accepted, after dropping privileges
+	started chan struct{}
+	// upgrading is true after starting the new process by an upgrade
+	upgrading atomic.Bool
+}
+
+func (sup *supervisor) run(ctx context.Context) error {
+	sup.instances = map[string]*instance{}
+	sup.errCh = make(chan error, 1)
+	sup.started = make(chan struct{})
+	var startOnce sync.Once
+	start := func() { startOnce.Do(func() { close(sup.started) }) }
+	// Listeners closed on errors are accepted to end serve
+	defer start()
+	// Signals are handled before listening not to terminate the process
+	sigCh := make(chan os.Signal, 1)
+	var signals []os.Signal
+	for _, s := range [][]os.Signal{reloadSignals, upgradeSignals, stopSignals, dumpSignals} {
+		signals = append(signals, s...)
+	}
+	signal.Notify(sigCh, signals...)
+	defer signal.Stop(sigCh)
+	defer sup.closeAll()
+	if sup.tarpit != nil {
+		defer sup.tarpit.Close()
+	}
+	if err := sup.reload(); err != nil {
+		return err
+	}
+	// The traffic is saved after draining connections
+	if sup.traffic != nil {
+		defer sup.saveTrafficPeriodically()()
+	}
+	// The listener of metrics is passed by upgrades
+	stopMetrics := func() {}
+	if sup.metricsListen != "" {
+		var err error
+		stopMetrics, err = sup.serveMetrics()
+		if err != nil {
+			return err
+		}
+		defer stopMetrics()
+	}
+	// The listeners of the admin API are passed by upgrades, but bans are not
+	stopAdmin, err := sup.serveAdmin()
+	if err != nil {
+		return err
+	}
+	defer stopAdmin()
+	// The listeners of knocks are passed by upgrades, but allowed addresses are not
+	stopKnock := func() {}
+	if sup.knock != nil {
+		stopKnock, err = sup.serveKnock()
+		if err != nil {
+			return err
+		}
+		defer stopKnock()
+	}
+	if err := sup.upgrader.Ready(); err != nil {
+		return err
+	}
+	if sup.pidFile != "" {
+		if err := daemon.WritePIDFile(sup.pidFile); err != nil {
+			return err
+		}
+		defer daemon.RemovePIDFile(sup.pidFile)
+	}
+	if sup.controlSocket != "" {
+		stopControl, err := sup.serveControl()
+		if err != nil {
+			return err
+		}
+		defer stopControl()
+	}
+	if sup.runAs != nil {
+		keep := keptCapabilities(sup.configs)
+		if err := daemon.DropPrivileges(sup.runAs, keep); err != nil {
+			return err
+		}
+		// The new process starts as root to keep the capabilities too
+		sup.upgrader.Start = daemon.StartAsRoot
+		sup.logger.Info("dropped privileges", "uid", sup.runAs.UID, "gid", sup.runAs.GID, "capabilities", len(keep))
+	}
+	if sup.sandbox != nil {
+		sandbox := sup.sandbox(sup.configs)
+		if err := daemon.Restrict(sandbox); err != nil {
+			return err
+		}
+		sup.logger.Info("pledged", "promises", sandbox.Promises, "unveiled_paths", len(sandbox.Paths))
+	}
+	start()
+	if err := daemon.Ready(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case err := <-sup.errCh:
+			return err
+		case <-ctx.Done():
+			return nil
+		case sig := <-sigCh:
+			switch {
+			case isSignal(sig, reloadSignals):
+				sup.logger.Info("reloading...")
+				if err := sup.reload(); err != nil {
+					sup.logger.Error("failed to reload", "err", err)
+					continue
+				}
+				sup.logger.Info("reloaded")
+			case isSignal(sig, upgradeSignals):
+				sup.logger.Info("upgrading...")
+				if err := sup.upgrader.Upgrade(); err != nil {
+					sup.logger.Error("failed to upgrade", "err", err)
+					continue
+				}
+				sup.upgrading.Store(true)
+				sup.closeAll()
+				stopMetrics()
+				stopAdmin()
+				stopKnock()
+				sup.logger.Info("upgraded, draining connections...")
+				sup.drainUntilStop(sigCh)
+				return nil
+			case isSignal(sig, stopSignals):
+				sup.closeAll()
+				sup.logger.Info("stopping, draining connections...")
+				sup.drainUntilStop(sigCh)
+				return nil
+			case isSignal(sig, dumpSignals):
+				sup.logStats()
+				sup.dumpState()
+			}
+		}
+	}
+}
+
+// reload loads the settings and applies them to new connections.
+// Listeners not in the settings anymore are closed, but their connections are not.
+// Nothing is changed on errors.
+func (sup *supervisor) reload() error {
+	configs, err := sup.load()
+	if err != nil {
+		return err
+	}
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	servers := map[string]*server.Server{}
+	loggers := map[string]*slog.Logger{}
+	flags := map[string]*flagType{}
+	var keys []string
+	for _, config := range configs {
+		key := listenKey(config.flag)
+		if _, ok := servers[key]; ok {
+			return fmt.Errorf("duplicate listen address: %s", key)
+		}
+		logger := sup.logger
+		if config.name != "" {
+			logger = logger.With("server", config.name)
+		}
+		s, err := newServer(logger, config.flag, config.allPermissionFlags)
+		if err != nil {
+			if config.name != "" {
+				return fmt.Errorf("server %s: %w", config.name, err)
+			}
+			return err
+		}
+		s.HandshakePool = sup.handshakePool
+		s.ChannelPool = sup.channelPool
+		if sup.audit != nil || sup.auditd != nil || sup.webhook != nil || sup.loginNotifier != nil || sup.adminListen != "" || sup.adminGRPCListen != "" {
+			s.OnEvent = sup.onEvent(logger, config.name)
+		}
+		servers[key] = s
+		loggers[key] = logger
+		flags[key] = config.flag
+		keys = append(keys, key)
+	}
+	newInstances := map[string]*instance{}
+	for _, key := range keys {
+		if _, ok := sup.instances[key]; ok {
+			continue
+		}
+		lns, err := listen(loggers[key], sup.upgrader, flags[key])
+		if err != nil {
+			for _, inst := range newInstances {
+				inst.close()
+			}
+			return err
+		}
+		newInstances[key] = &instance{key: key, lns: lns}
+	}
+
+	for key, inst := range sup.instances {
+		if _, ok := servers[key]; !ok {
+			sup.logger.Info(fmt.Sprintf("closing %s...", key))
+			inst.close()
+			delete(sup.instances, key)
+		}
+	}
+	// Servers replaced without connections are not needed for draining
+	var activeServers []*server.Server
+	for _, s := range sup.servers {
+		stats := s.Stats()
+		if stats.ActiveConnections != 0 {
+			activeServers = append(activeServers, s)
+			continue
+		}
+		sup.retiredStats = sup.retiredStats.Add(stats)
+	}
+	sup.servers = activeServers
+	for _, key := range keys {
+		inst, ok := sup.instances[key]
+		if !ok {
+			inst = newInstances[key]
+			sup.instances[key] = inst
+		}
+		inst.server.Store(servers[key])
+		sup.servers = append(sup.servers, servers[key])
+	}
+	sup.configs = configs
+	for _, inst := range newInstances {
+		for _, ln := range inst.lns {
+			go sup.serve(inst, ln)
+		}
+	}
+	return nil
+}
+
+// serveControl serves the control socket for all servers. stop closes it.
+// The socket file is left after an upgrade for the new process listening on it.
+func (sup *supervisor) serveControl() (stop func(), err error) {
+	// The new process takes over the socket of the old one
+	ln, err := control.Listen(sup.controlSocket, sup.upgrader.Upgraded())
+	if err != nil {
+		return nil, err
+	}
+	httpServer := &http.Server{
+		Handler:           control.Handler(sup.allServers),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go httpServer.Serve(ln)
+	return func() {
+		httpServer.Close()
+		if !sup.upgrading.Load() {
+			os.Remove(sup.controlSocket)
+		}
+	}, nil
+}
+
+// onEvent returns a handler writing events of the server named serverName to the audit logs, webhooks, login notifications and the admin APIs
+func (sup *supervisor) onEvent(logger *slog.Logger, serverName string) func(server.Event) {
+	return func(event server.Event) {
+		if sup.audit != nil {
+			if err := sup.audit.Log(serverName, event); err != nil {
+				logger.Error("failed to write audit log", "event_type", event.Type, "err", err)
+			}
+		}
+		if sup.auditd != nil {
+			if record, ok := auditd.NewRecord(event); ok {
+				if err := sup.auditd.Log(record); err != nil {
+					logger.Error("failed to send audit record", "event_type", event.Type, "err", err)
+				}
+			}
+		}
+		if sup.webhook != nil {
+			sup.webhook.Notify(serverName, event)
+		}
+		if sup.loginNotifier != nil {
+			sup.loginNotifier.Notify(serverName, event)
+		}
+		if sup.adminListen != "" || sup.adminGRPCListen != "" {
+			sup.events.Publish(serverName, event)
+		}
+	}
+}
+
+// serveMetrics serves Prometheus metrics of all servers. stop closes it.
+func (sup *supervisor) serveMetrics() (stop func(), err error) {
+	ln, err := sup.upgrader.Listen("tcp", sup.metricsListen)
+	if err != nil {
+		return nil, err
+	}
+	sup.logger.Info(fmt.Sprintf("serving metrics on %s...", sup.metricsListen))
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(sup.totalStats))
+	httpServer := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go httpServer.Serve(ln)
+	return func() { httpServer.Close() }, nil
+}
+
+// serveAdmin serves the admin API for all servers on adminListen and adminGRPCListen if not empty. stop closes them.
+func (sup *supervisor) serveAdmin() (stop func(), err error) {
+	api := &admin.API{
+		Token:   sup.adminToken,
+		Servers: sup.allServers,
+		Stats:   sup.totalStats,
+		Traffic: sup.userTraffic,
+		Bans:    &sup.bans,
+		Reload: func() error {
+			sup.logger.Info("reloading by admin API...")
+			if err := sup.reload(); err != nil {
+				sup.logger.Error("failed to reload", "err", err)
+				return err
+			}
+			sup.logger.Info("reloaded")
+			return nil
+		},
+		Events: &sup.events,
+		Pprof:  sup.adminPprof,
+	}
+	var stops []func()
+	stop = func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+	if sup.adminListen != "" {
+		ln, err := sup.upgrader.Listen("tcp", sup.adminListen)
+		if err != nil {
+			return nil, err
+		}
+		sup.logger.Info(fmt.Sprintf("serving admin API on %s...", sup.adminListen))
+		httpServer := &http.Server{Handler: api.Handler(), ReadHeaderTimeout: 10 * time.Second}
+		go httpServer.Serve(ln)
+		stops = append(stops, func() { httpServer.Close() })
+	}
+	if sup.adminGRPCListen != "" {
+		ln, err := sup.upgrader.Listen("tcp", sup.adminGRPCListen)
+		if err != nil {
+			stop()
+			return nil, err
+		}
+		sup.logger.Info(fmt.Sprintf("serving admin gRPC API on %s...", sup.adminGRPCListen))
+		grpcServer := api.NewGRPCServer()
+		go grpcServer.Serve(ln)
+		stops = append(stops, grpcServer.Stop)
+	}
+	return stop, nil
+}
+
+// allServers returns all servers including ones replaced by reloads with connections
+func (sup *supervisor) allServers() []*server.Server {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	return append([]*server.Server(nil), sup.servers...)
+}
+
+// totalStats returns the sum of the statistics of all servers including removed ones
+func (sup *supervisor) totalStats() server.Stats {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	total := sup.retiredStats
+	for _, s := range sup.servers {
+		total = total.Add(s.Stats())
+	}
+	return total
+}
+
+// serve accepts connections on ln of inst and serves each one with the latest server of inst
+func (sup *supervisor) serve(inst *instance, ln net.Listener) {
+	if sup.started != nil {
+		<-sup.started
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				if !inst.closed.Load() {
+					select {
+					case sup.errCh <- err:
+					default:
+					}
+				}
+				return
+			}
+			sup.logger.Error("failed to accept connection", "err", err)
+			continue
+		}
+		if sup.bans.Banned(conn.RemoteAddr()) {
+			if sup.tarpit != nil && sup.tarpit.Hold(conn) {
+				sup.logger.Info("tarpitting banned address", "remote_address", conn.RemoteAddr().String(), "tarpitted_connections", sup.tarpit.Active())
+				continue
+			}
+			sup.logger.Info("rejected banned address", "remote_address", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		if sup.knock != nil && !sup.knock.Allowed(conn.RemoteAddr()) {
+			sup.logger.Debug("reset connection without knock", "remote_address", conn.RemoteAddr().String())
+			knock.Reject(conn)
+			continue
+		}
+		inst.server.Load().GoServeConn(conn)
+	}
+}
+
+func (sup *supervisor) closeAll() {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	for key, inst := range sup.instances {
+		inst.close()
+		delete(sup.instances, key)
+	}
+}
+
+// drainUntilStop drains connections until a stop signal from sigCh
+func (sup *supervisor) drainUntilStop(sigCh <-chan os.Signal) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				if isSignal(sig, stopSignals) {
+					cancel()
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	sup.drain(ctx)
+}
+
+// drain waits for connections of all servers to close up to drainTimeout, or indefinitely if drainTimeout is 0.
+// Waiting is aborted when ctx is done.
+func (sup *supervisor) drain(ctx context.Context) {
+	if sup.drainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sup.drainTimeout)
+		defer cancel()
+	}
+	sup.mu.Lock()
+	servers := sup.servers
+	sup.mu.Unlock()
+	for _, s := range servers {
+		if err := s.Drain(ctx); err != nil {
+			sup.logger.Info("closing remaining connections", "active_connections", s.Stats().ActiveConnections)
+			return
+		}
+	}
+}
+
+// logStats logs the statistics of the servers by listener
+func (sup *supervisor) logStats() {
+	sup.mu.Lock()
+	defer sup.mu.Unlock()
+	var keys []string
+	for key := range sup.instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		stats := sup.instances[key].server.Load().Stats()
+		sup.logger.Info("stats",
+			"listener", key,
+			"active_connections", stats.ActiveConnections,
+			"active_sessions", stats.ActiveSessions,
+			"active_forwards", stats.ActiveForwards,
+			"active_goroutines", stats.ActiveGoroutines,
+			"active_processes", stats.ActiveProcesses,
+			"bytes_received", stats.BytesReceived,
+			"bytes_sent", stats.BytesSent,
+			"auth_failures", stats.AuthFailures,
+			"shed_connections", stats.ShedConnections,
+			"shed_channels", stats.ShedChannels,
+			"rate_limited_channels", stats.RateLimitedChannels,
+			"stalled_relays", stats.StalledRelays,
+		)
+	}
+}
+
+// dumpState logs active connections with their sessions and forwards of all servers, and the number of goroutines
+func (sup *supervisor) dumpState() {
+	servers := sup.allServers()
+	now := time.Now()
+	var connections int
+	for _, s := range servers {
+		for _, conn := range s.Connections() {
+			connections++
+			remoteAddr := ""
+			if conn.RemoteAddr != nil {
+				remoteAddr = conn.RemoteAddr.String()
+			}
+			sup.logger.Info("dump: connection", "conn_id", conn.ID, "user", conn.User, "remote_address", remoteAddr, "channels", conn.Channels, "goroutines", conn.Goroutines, "processes", conn.Processes, "age", age(now, conn.StartTime))
+			for _, sess := range conn.Sessions {
+				sup.logger.Info("dump: session", "session_id", sess.ID, "type", sess.Type, "command", strings.Join(sess.Command, " "), "pid", sess.PID, "age", age(now, sess.StartTime))
+			}
+			for _, fwd := range conn.Forwards {
+				target := fwd.Path
+				if target == "" {
+					target = net.JoinHostPort(fwd.Host, strconv.Itoa(fwd.Port))
+				}
+				sup.logger.Info("dump: forward", "forward_id", fwd.ID, "type", fwd.Type, "target", target, "age", age(now, fwd.StartTime))
+			}
+		}
+	}
+	// Servers replaced by reloads still serving connections are included
+	sup.logger.Info("dump: runtime", "servers", len(servers), "connections", connections, "goroutines", runtime.NumGoroutine())
+}
+
+// keptCapabilities returns the capabilities to keep after dropping privileges for the sandbox users and chroot of configs
+func keptCapabilities(configs []instanceConfig) []daemon.Capability {
+	var switchUser, chroot bool
+	for _, config := range configs {
+		flag := config.flag
+		if flag.sandboxUser != "" {
+			switchUser = true
+		}
+		if flag.chrootDirectory != "" {
+			chroot = true
+		}
+		if flag.sshd != nil {
+			for _, match := range flag.sshd.Matches {
+				if dir := match.Settings.ChrootDirectory; dir != "" && dir != "none" {
+					chroot = true
+				}
+			}
+		}
+	}
+	var keep []daemon.Capability
+	// Chrooted sessions run as the sandbox user or the OS user
+	if switchUser || chroot {
+		keep = append(keep, daemon.CapSetGID, daemon.CapSetUID)
+	}
+	if chroot {
+		keep = append(keep, daemon.CapSysChroot)
+	}
+	return keep
+}
+
+func isSignal(sig os.Signal, signals []os.Signal) bool {
+	for _, s := range signals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/daemon"
+	"github.com/John-Ao/go-sshd/sshdconfig"
+	"github.com/John-Ao/go-sshd/upgrade"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+func dialPassword(port int, user, password string) (*ssh.Client, error) {
+	return ssh.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+}
+
+func TestReload(t *testing.T) {
+	port1 := getAvailableTcpPort()
+	port2 := getAvailableTcpPort()
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(config string) {
+		require.NoError(t, os.WriteFile(configPath, []byte(config), 0600))
+	}
+	writeConfig(`servers:
+  - name: a
+    port: ` + strconv.Itoa(port1) + `
+    user: ["john:pass1"]
+`)
+	upgrader, err := upgrade.New()
+	require.NoError(t, err)
+	sup := &supervisor{
+		logger:   slog.Default(),
+		upgrader: upgrader,
+		load: func() ([]instanceConfig, error) {
+			return loadInstanceConfigs(configPath)
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go sup.run(ctx)
+	waitTCPServer(port1)
+	client, err := dialPassword(port1, "john", "pass1")
+	require.NoError(t, err)
+	defer client.Close()
+
+	// The password is changed and a server is added
+	writeConfig(`servers:
+  - name: a
+    port: ` + strconv.Itoa(port1) + `
+    user: ["john:pass2"]
+  - name: b
+    port: ` + strconv.Itoa(port2) + `
+    user: ["alex:"]
+`)
+	require.NoError(t, sup.reload())
+	_, err = dialPassword(port1, "john", "pass1")
+	assert.Error(t, err)
+	client2, err := dialPassword(port1, "john", "pass2")
+	require.NoError(t, err)
+	client2.Close()
+	client3, err := dialPassword(port2, "alex", "")
+	require.NoError(t, err)
+	client3.Close()
+	// The existing connection is not affected
+	assertExec(t, client)
+
+	// Invalid settings are not applied
+	writeConfig(`servers:
+  - name: b
+    port: ` + strconv.Itoa(port2) + `
+    user: ["invalid"]
+`)
+	assert.Error(t, sup.reload())
+	client2, err = dialPassword(port1, "john", "pass2")
+	require.NoError(t, err)
+	client2.Close()
+
+	// The server is removed
+	writeConfig(`servers:
+  - name: b
+    port: ` + strconv.Itoa(port2) + `
+    user: ["alex:"]
+`)
+	require.NoError(t, sup.reload())
+	_, err = net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port1)))
+	assert.Error(t, err)
+	assertExec(t, client)
+}
+
+func TestStop(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals can not be sent on Windows")
+	}
+	port := getAvailableTcpPort()
+	pidFile := filepath.Join(t.TempDir(), "go-sshd.pid")
+	upgrader, err := upgrade.New()
+	require.NoError(t, err)
+	rootCmd, flag, allPermissionFlags := newRootCmd()
+	require.NoError(t, rootCmd.ParseFlags([]string{"-p", strconv.Itoa(port), "-u", "john:mypass"}))
+	sup := &supervisor{
+		logger:   slog.Default(),
+		upgrader: upgrader,
+		pidFile:  pidFile,
+		load: func() ([]instanceConfig, error) {
+			return loadConfigs(slog.Default(), flag, allPermissionFlags)
+		},
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- sup.run(context.Background())
+	}()
+	waitTCPServer(port)
+	assert.FileExists(t, pidFile)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+
+	process, err := os.FindProcess(os.Getpid())
+	require.NoError(t, err)
+	require.NoError(t, process.Signal(dumpSignals[0]))
+	require.NoError(t, process.Signal(stopSignals[0]))
+	// New connections are not accepted while draining
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			return true
+		}
+		conn.Close()
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+	assertExec(t, client)
+	select {
+	case err := <-errCh:
+		t.Fatalf("stopped before connections are closed: %v", err)
+	default:
+	}
+	client.Close()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("not stopped")
+	}
+	assert.NoFileExists(t, pidFile)
+}
+
+func TestMetricsListen(t *testing.T) {
+	port := getAvailableTcpPort()
+	metricsAddress := net.JoinHostPort("127.0.0.1", strconv.Itoa(getAvailableTcpPort()))
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--metrics-listen", metricsAddress})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = dialPassword(port, "john", "wrong")
+	require.Error(t, err)
+
+	var res *http.Response
+	require.Eventually(t, func() bool {
+		res, err = http.Get("http://" + metricsAddress + "/metrics")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "go_sshd_connections_total 1\n")
+	assert.Contains(t, string(body), "go_sshd_active_connections 1\n")
+	assert.Contains(t, string(body), `go_sshd_auth_attempts_total{method="password",result="failure"} 1`+"\n")
+	assert.Contains(t, string(body), `go_sshd_auth_attempts_total{method="password",result="success"} 1`+"\n")
+	assert.Contains(t, string(body), "go_sshd_handshake_duration_seconds_count 1\n")
+}
+
+func TestDumpState(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("sleep is not available on Windows")
+	}
+	port := getAvailableTcpPort()
+	upgrader, err := upgrade.New()
+	require.NoError(t, err)
+	rootCmd, flag, allPermissionFlags := newRootCmd()
+	require.NoError(t, rootCmd.ParseFlags([]string{"-p", strconv.Itoa(port), "-u", "john:mypass"}))
+	sup := &supervisor{
+		logger:    slog.Default(),
+		upgrader:  upgrader,
+		instances: map[string]*instance{},
+		errCh:     make(chan error, 1),
+		load: func() ([]instanceConfig, error) {
+			return loadConfigs(slog.Default(), flag, allPermissionFlags)
+		},
+	}
+	require.NoError(t, sup.reload())
+	defer sup.closeAll()
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.Start("sleep 60"))
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	var buf bytes.Buffer
+	sup.logger = slog.New(slog.NewTextHandler(&buf, nil))
+	require.Eventually(t, func() bool {
+		buf.Reset()
+		sup.dumpState()
+		return regexp.MustCompile(`pid=[1-9]`).MatchString(buf.String())
+	}, 5*time.Second, 10*time.Millisecond)
+	dump := buf.String()
+	assert.Contains(t, dump, `msg="dump: connection"`)
+	assert.Contains(t, dump, "user=john")
+	assert.Contains(t, dump, `msg="dump: session"`)
+	assert.Contains(t, dump, `type=exec command="sleep 60"`)
+	assert.Contains(t, dump, `msg="dump: forward"`)
+	assert.Contains(t, dump, "type=tcpip-forward target="+ln.Addr().String())
+	assert.Regexp(t, `msg="dump: runtime" servers=1 connections=1 goroutines=\d+`, dump)
+}
+
+func TestKeptCapabilities(t *testing.T) {
+	assert.Empty(t, keptCapabilities([]instanceConfig{{flag: &flagType{}}}))
+	assert.Equal(t, []daemon.Capability{daemon.CapSetGID, daemon.CapSetUID},
+		keptCapabilities([]instanceConfig{{flag: &flagType{}}, {flag: &flagType{sandboxUser: "nobody"}}}))
+	sshd := &sshdconfig.Config{Matches: []sshdconfig.Match{{Settings: sshdconfig.Settings{ChrootDirectory: "/srv/jail/%u"}}}}
+	assert.Equal(t, []daemon.Capability{daemon.CapSetGID, daemon.CapSetUID, daemon.CapSysChroot},
+		keptCapabilities([]instanceConfig{{flag: &flagType{sshd: sshd}}}))
+}
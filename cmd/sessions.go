@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/John-Ao/go-sshd/control"
+
+	"github.com/spf13/cobra"
+)
+
+func sessionsCmd(flag *flagType) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "Inspect and close live connections of a running server",
+		Long:  "Inspect and close live connections, sessions and forwards of a server started with --control-socket",
+		Args:  cobra.NoArgs,
+	}
+	client := func() (*control.Client, error) {
+		if flag.controlSocket == "" {
+			return nil, errors.New("--control-socket is required")
+		}
+		return control.NewClient(flag.controlSocket), nil
+	}
+	var jsonOutput bool
+	listCmd := &cobra.Command{
+		Use:     "list",
+		Short:   "List connections with their sessions and forwards",
+		Example: `go-sshd sessions list --control-socket /run/go-sshd.sock`,
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := client()
+			if err != nil {
+				return err
+			}
+			connections, err := c.Connections()
+			if err != nil {
+				return err
+			}
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(connections)
+			}
+			printConnections(cmd.OutOrStdout(), connections, time.Now())
+			return nil
+		},
+	}
+	listCmd.Flags().BoolVarP(&jsonOutput, "json", "", false, "output in JSON")
+	killCmd := &cobra.Command{
+		Use:   "kill ID",
+		Short: "Close a connection, a session or a forward",
+		Long:  "Close a connection, a session or a forward by the ID shown by the list command. Closing a connection closes all of its sessions and forwards.",
+		Example: `go-sshd sessions kill 5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b --control-socket /run/go-sshd.sock
+go-sshd sessions kill 5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b/2 --control-socket /run/go-sshd.sock`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := client()
+			if err != nil {
+				return err
+			}
+			return c.Close(args[0])
+		},
+	}
+	var notify bool
+	monitorCmd := &cobra.Command{
+		Use:   "monitor ID",
+		Short: "Show the live output of a session",
+		Long: `Show the live output of a session, i.e. stdout and stderr or the terminal, by the ID shown by the list command until it ends.
+The view is read-only. Output is dropped if the terminal can't keep up. --notify tells the user that the session is monitored.`,
+		Example: `go-sshd sessions monitor 5f3e1c2a-8b7d-4e6f-9a0b-1c2d3e4f5a6b/1 --notify --control-socket /run/go-sshd.sock`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := client()
+			if err != nil {
+				return err
+			}
+			return c.Monitor(cmd.Context(), args[0], notify, cmd.OutOrStdout())
+		},
+	}
+	monitorCmd.Flags().BoolVarP(&notify, "notify", "", false, "notify the user of the session")
+	var users []string
+	wallCmd := &cobra.Command{
+		Use:   "wall MESSAGE",
+		Short: "Write a message to the terminals of sessions",
+		Long: `Write a message to the terminals of shell and exec sessions with a pty like wall(1), e.g. to warn users before draining or shutting down.
+Sessions without a pty such as scp and SFTP don't get it. The IDs of the sessions written to are printed.`,
+		Example: `go-sshd sessions wall "go-sshd restarts at 18:00 UTC, please save your work" --control-socket /run/go-sshd.sock
+go-sshd sessions wall "your session ends in 5 minutes" --user john --control-socket /run/go-sshd.sock`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			c, err := client()
+			if err != nil {
+				return err
+			}
+			ids, err := c.Wall(args[0], users)
+			if err != nil {
+				return err
+			}
+			for _, id := range ids {
+				fmt.Fprintln(cmd.OutOrStdout(), id)
+			}
+			return nil
+		},
+	}
+	wallCmd.Flags().StringSliceVarP(&users, "user", "", nil, "write only to the sessions of the users")
+	cmd.AddCommand(listCmd, killCmd, monitorCmd, wallCmd)
+	return cmd
+}
+
+// printConnections prints connections and their sessions and forwards indented below them
+func printConnections(w io.Writer, connections []control.Connection, now time.Time) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTYPE\tUSER/TARGET\tREMOTE/COMMAND\tAGE")
+	for _, conn := range connections {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", conn.ID, "connection", conn.User, conn.RemoteAddr, age(now, conn.StartTime))
+		for _, sess := range conn.Sessions {
+			sessionType := sess.Type
+			if sessionType == "" {
+				sessionType = "session"
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t\t%s\t%s\n", sess.ID, sessionType, strings.Join(sess.Command, " "), age(now, sess.StartTime))
+		}
+		for _, fwd := range conn.Forwards {
+			target := fwd.Path
+			if target == "" {
+				target = fmt.Sprintf("%s:%d", fwd.Host, fwd.Port)
+			}
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t\t%s\n", fwd.ID, fwd.Type, target, age(now, fwd.StartTime))
+		}
+	}
+	tw.Flush()
+}
+
+func age(now, t time.Time) string {
+	return now.Sub(t).Truncate(time.Second).String()
+}
@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/control"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runSessionsCmd(args ...string) (string, error) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs(append([]string{"sessions"}, args...))
+	var stdoutBuf bytes.Buffer
+	rootCmd.SetOut(&stdoutBuf)
+	rootCmd.SetErr(&bytes.Buffer{})
+	err := rootCmd.Execute()
+	return stdoutBuf.String(), err
+}
+
+func TestSessions(t *testing.T) {
+	port := getAvailableTcpPort()
+	socketPath := filepath.Join(t.TempDir(), "control.sock")
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--control-socket", socketPath})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+
+	var output string
+	require.Eventually(t, func() bool {
+		output, err = runSessionsCmd("list", "--json", "--control-socket", socketPath)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	var connections []control.Connection
+	require.NoError(t, json.Unmarshal([]byte(output), &connections))
+	require.Len(t, connections, 1)
+	assert.Equal(t, "john", connections[0].User)
+
+	output, err = runSessionsCmd("list", "--control-socket", socketPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(output, "\n"), "\n")
+	require.Len(t, lines, 2)
+	assert.Regexp(t, `^ID +TYPE +USER/TARGET +REMOTE/COMMAND +AGE$`, lines[0])
+	assert.Regexp(t, `^`+connections[0].ID+` +connection +john +127\.0\.0\.1:\d+ +\d+s$`, lines[1])
+
+	_, err = runSessionsCmd("monitor", "unknown", "--control-socket", socketPath)
+	assert.EqualError(t, err, "not found: unknown")
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	require.Eventually(t, func() bool {
+		output, err = runSessionsCmd("list", "--json", "--control-socket", socketPath)
+		require.NoError(t, err)
+		require.NoError(t, json.Unmarshal([]byte(output), &connections))
+		return len(connections[0].Sessions) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	pr, pw := io.Pipe()
+	monitorCmd := RootCmd()
+	monitorCmd.SetArgs([]string{"sessions", "monitor", connections[0].Sessions[0].ID, "--control-socket", socketPath})
+	monitorCmd.SetOut(pw)
+	monitorCmd.SetErr(&bytes.Buffer{})
+	go func() {
+		pw.CloseWithError(monitorCmd.Execute())
+	}()
+	// The output of the echo after the monitor started is shown
+	go func() {
+		for i := 0; i < 100; i++ {
+			fmt.Fprintf(stdin, "echo monitored-$((%d+1))\n", i)
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	line, err := bufio.NewReader(pr).ReadString('\n')
+	require.NoError(t, err)
+	assert.Regexp(t, `monitored-\d+`, line)
+	pr.Close()
+
+	// The session without a pty doesn't get the message
+	output, err = runSessionsCmd("wall", "maintenance", "--control-socket", socketPath)
+	require.NoError(t, err)
+	assert.Empty(t, output)
+	_, err = runSessionsCmd("wall", "", "--control-socket", socketPath)
+	assert.EqualError(t, err, "empty message")
+
+	_, err = runSessionsCmd("kill", "unknown", "--control-socket", socketPath)
+	assert.EqualError(t, err, "not found: unknown")
+	_, err = runSessionsCmd("kill", connections[0].ID, "--control-socket", socketPath)
+	require.NoError(t, err)
+	assert.Error(t, client.Wait())
+
+	_, err = runSessionsCmd("list")
+	assert.EqualError(t, err, "--control-socket is required")
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// reloadSignals make the process reload the settings
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// upgradeSignals make the process upgrade to the binary on the disk
+var upgradeSignals = []os.Signal{syscall.SIGUSR2}
+
+// stopSignals make the process stop accepting and exit after draining connections
+var stopSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+
+// dumpSignals make the process log the statistics and a snapshot of connections for debugging
+var dumpSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package cmd
+
+import "os"
+
+// reloadSignals make the process reload the settings
+var reloadSignals []os.Signal
+
+// upgradeSignals make the process upgrade to the binary on the disk
+var upgradeSignals []os.Signal
+
+// stopSignals make the process stop accepting and exit after draining connections
+var stopSignals = []os.Signal{os.Interrupt}
+
+// dumpSignals make the process log the statistics and a snapshot of connections for debugging
+var dumpSignals []os.Signal
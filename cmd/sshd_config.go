@@ -0,0 +1,189 @@
+package cmd
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/sshdconfig"
+
+	"golang.org/x/exp/slog"
+)
+
+// defaultAuthorizedKeysFiles is the default of AuthorizedKeysFile of sshd_config
+var defaultAuthorizedKeysFiles = []string{".ssh/authorized_keys", ".ssh/authorized_keys2"}
+
+// applySshdConfigs applies --sshd-config of configs. A config is expanded into one per listen address.
+func applySshdConfigs(logger *slog.Logger, configs []instanceConfig) ([]instanceConfig, error) {
+	var applied []instanceConfig
+	for _, config := range configs {
+		if config.flag.sshdConfig == "" {
+			applied = append(applied, config)
+			continue
+		}
+		c, err := sshdconfig.ParseFile(config.flag.sshdConfig)
+		if err != nil {
+			return nil, err
+		}
+		for _, unsupported := range c.Unsupported {
+			logger.Warn("unsupported directive ignored", "file", config.flag.sshdConfig, "directive", unsupported)
+		}
+		if c.RekeyLimitTime != "" && c.RekeyLimitTime != "none" && c.RekeyLimitTime != "0" {
+			logger.Warn("time of RekeyLimit ignored", "file", config.flag.sshdConfig, "time", c.RekeyLimitTime)
+		}
+		if c.Compression != "" && c.Compression != "no" {
+			// golang.org/x/crypto/ssh negotiates only "none", to which clients requesting compression fall back
+			logger.Warn("Compression ignored as compression is not supported", "file", config.flag.sshdConfig, "compression", c.Compression)
+		}
+		for _, address := range sshdListenAddresses(c) {
+			flag := *config.flag
+			flag.sshd = c
+			flag.sshHost = address.host
+			flag.sshPort = address.port
+			if len(c.HostKeys) != 0 {
+				flag.hostKeys = c.HostKeys
+			}
+			flag.authorizedKeysFiles = c.AuthorizedKeysFiles
+			if c.Ciphers != "" {
+				flag.algorithms.Ciphers = c.Ciphers
+			}
+			if c.MACs != "" {
+				flag.algorithms.MACs = c.MACs
+			}
+			if c.KexAlgorithms != "" {
+				flag.algorithms.KeyExchanges = c.KexAlgorithms
+			}
+			if c.AllowUsers != nil {
+				flag.userAccess.AllowUsers = c.AllowUsers
+			}
+			if c.DenyUsers != nil {
+				flag.userAccess.DenyUsers = c.DenyUsers
+			}
+			if c.AllowGroups != nil {
+				flag.userAccess.AllowGroups = c.AllowGroups
+			}
+			if c.DenyGroups != nil {
+				flag.userAccess.DenyGroups = c.DenyGroups
+			}
+			if c.Global.ChrootDirectory != "" && c.Global.ChrootDirectory != "none" {
+				flag.chrootDirectory = c.Global.ChrootDirectory
+			}
+			if c.RekeyLimit != "" {
+				flag.rekeyLimit = c.RekeyLimit
+			}
+			if c.RequiredRSASize != 0 {
+				flag.minRSAKeyBits = c.RequiredRSASize
+			}
+			if flag.authorizedKeysFiles == nil {
+				flag.authorizedKeysFiles = defaultAuthorizedKeysFiles
+			}
+			settings := c.Global.WithDefaults()
+			flag.allowTcpipForward = allowsRemote(settings.AllowTcpForwarding)
+			flag.allowDirectTcpip = allowsLocal(settings.AllowTcpForwarding)
+			flag.allowStreamlocalForward = allowsRemote(settings.AllowStreamLocalForwarding)
+			flag.allowDirectStreamlocal = allowsLocal(settings.AllowStreamLocalForwarding)
+			flag.allowAgentForward = settings.AllowAgentForwarding == "yes"
+			flag.allowX11Forward = settings.X11Forwarding == "yes"
+			flag.allowExecute = true
+			flag.allowScp = true
+			// The built-in SFTP server is used for any command
+			flag.allowSftp = c.Subsystems["sftp"] != ""
+			flag.allowPty = settings.PermitTTY != "no"
+			flag.denyPty = settings.PermitTTY == "no"
+			applied = append(applied, instanceConfig{name: config.name, flag: &flag, allPermissionFlags: permissionFlags(&flag), flagSet: config.flagSet})
+		}
+	}
+	return applied, nil
+}
+
+type sshdListenAddress struct {
+	host string
+	port uint16
+}
+
+// sshdListenAddresses returns the addresses by ListenAddress and Port
+func sshdListenAddresses(c *sshdconfig.Config) []sshdListenAddress {
+	ports := c.Ports
+	if len(ports) == 0 {
+		ports = []uint16{22}
+	}
+	hosts := c.ListenAddresses
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	var addresses []sshdListenAddress
+	for _, host := range hosts {
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			if port, err := strconv.ParseUint(p, 10, 16); err == nil {
+				addresses = append(addresses, sshdListenAddress{host: h, port: uint16(port)})
+				continue
+			}
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		for _, port := range ports {
+			addresses = append(addresses, sshdListenAddress{host: host, port: port})
+		}
+	}
+	return addresses
+}
+
+func allowsLocal(forwarding string) bool {
+	return forwarding == "yes" || forwarding == "all" || forwarding == "local"
+}
+
+func allowsRemote(forwarding string) bool {
+	return forwarding == "yes" || forwarding == "all" || forwarding == "remote"
+}
+
+// sshdPerConnection reports whether c has settings applied per connection by sshdMatchExtensions
+func sshdPerConnection(c *sshdconfig.Config) bool {
+	return len(c.Matches) != 0 || c.Global.ForceCommand != "" || c.Global.MaxSessions != ""
+}
+
+// sshdMatchExtensions returns the extensions for the settings of Match blocks
+func sshdMatchExtensions(flag *flagType, settings sshdconfig.Settings) map[string]string {
+	var permissions []string
+	if allowsRemote(settings.AllowTcpForwarding) {
+		permissions = append(permissions, server.PermissionTcpipForward)
+	}
+	if allowsLocal(settings.AllowTcpForwarding) {
+		permissions = append(permissions, server.PermissionDirectTcpip)
+	}
+	if allowsRemote(settings.AllowStreamLocalForwarding) {
+		permissions = append(permissions, server.PermissionStreamlocalForward)
+	}
+	if allowsLocal(settings.AllowStreamLocalForwarding) {
+		permissions = append(permissions, server.PermissionDirectStreamlocal)
+	}
+	if settings.AllowAgentForwarding == "yes" {
+		permissions = append(permissions, server.PermissionAgentForward)
+	}
+	if settings.X11Forwarding == "yes" {
+		permissions = append(permissions, server.PermissionX11Forward)
+	}
+	if flag.allowExecute {
+		permissions = append(permissions, server.PermissionExecute)
+	}
+	if flag.allowScp {
+		permissions = append(permissions, server.PermissionScp)
+	}
+	if flag.allowSftp {
+		permissions = append(permissions, server.PermissionSftp)
+	}
+	extensions := map[string]string{
+		server.ExtensionPermissions: strings.Join(permissions, ","),
+		server.ExtensionDenyPty:     strconv.FormatBool(settings.PermitTTY == "no"),
+	}
+	if settings.ForceCommand != "" {
+		extensions[server.ExtensionForceCommand] = settings.ForceCommand
+	}
+	if settings.ChrootDirectory != "" {
+		extensions[server.ExtensionChrootDirectory] = settings.ChrootDirectory
+	}
+	if settings.MaxSessions != "" {
+		// Counted per connection like sshd
+		extensions[server.ExtensionMaxConnSessions] = settings.MaxSessions
+	}
+	return extensions
+}
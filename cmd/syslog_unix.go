@@ -0,0 +1,130 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/slog"
+)
+
+var syslogFacilities = map[string]syslog.Priority{
+	"kern":     syslog.LOG_KERN,
+	"user":     syslog.LOG_USER,
+	"mail":     syslog.LOG_MAIL,
+	"daemon":   syslog.LOG_DAEMON,
+	"auth":     syslog.LOG_AUTH,
+	"syslog":   syslog.LOG_SYSLOG,
+	"lpr":      syslog.LOG_LPR,
+	"news":     syslog.LOG_NEWS,
+	"uucp":     syslog.LOG_UUCP,
+	"cron":     syslog.LOG_CRON,
+	"authpriv": syslog.LOG_AUTHPRIV,
+	"ftp":      syslog.LOG_FTP,
+	"local0":   syslog.LOG_LOCAL0,
+	"local1":   syslog.LOG_LOCAL1,
+	"local2":   syslog.LOG_LOCAL2,
+	"local3":   syslog.LOG_LOCAL3,
+	"local4":   syslog.LOG_LOCAL4,
+	"local5":   syslog.LOG_LOCAL5,
+	"local6":   syslog.LOG_LOCAL6,
+	"local7":   syslog.LOG_LOCAL7,
+}
+
+// dialSyslog connects to address, which is "local" for the local syslog daemon,
+// "udp://host[:port]", "tcp://host[:port]" or "unix:///path"
+func dialSyslog(address, facility, tag string) (*syslog.Writer, error) {
+	priority, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility: %s", facility)
+	}
+	if address == "local" {
+		return syslog.New(priority, tag)
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address: %w", err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "514")
+		}
+		return syslog.Dial(u.Scheme, host, priority, tag)
+	case "unix":
+		// Local syslog daemons usually listen on datagram sockets
+		w, err := syslog.Dial("unixgram", u.Path, priority, tag)
+		if err != nil {
+			w, err = syslog.Dial("unix", u.Path, priority, tag)
+		}
+		return w, err
+	}
+	return nil, fmt.Errorf(`invalid syslog address: %s ("local", "udp://host:port", "tcp://host:port" or "unix:///path")`, address)
+}
+
+// syslogWriter sends each log entry with the severity of its level
+type syslogWriter struct {
+	mu    sync.Mutex
+	level slog.Level
+	w     *syslog.Writer
+}
+
+func (sw *syslogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	var err error
+	switch {
+	case sw.level >= slog.LevelError:
+		err = sw.w.Err(msg)
+	case sw.level >= slog.LevelWarn:
+		err = sw.w.Warning(msg)
+	case sw.level >= slog.LevelInfo:
+		err = sw.w.Info(msg)
+	default:
+		err = sw.w.Debug(msg)
+	}
+	return len(p), err
+}
+
+// syslogHandler formats entries with the handler of the log format and sends them to syslog
+type syslogHandler struct {
+	slog.Handler
+	w *syslogWriter
+}
+
+// newSyslogHandler returns a handler of format writing to w. Times are omitted because syslog adds them.
+func newSyslogHandler(w *syslog.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	sw := &syslogWriter{w: w}
+	syslogOpts := *opts
+	syslogOpts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && a.Key == slog.TimeKey {
+			return slog.Attr{}
+		}
+		return a
+	}
+	if format == logFormatJSON {
+		return &syslogHandler{Handler: slog.NewJSONHandler(sw, &syslogOpts), w: sw}
+	}
+	return &syslogHandler{Handler: slog.NewTextHandler(sw, &syslogOpts), w: sw}
+}
+
+func (h *syslogHandler) Handle(ctx context.Context, r slog.Record) error {
+	h.w.mu.Lock()
+	defer h.w.mu.Unlock()
+	h.w.level = r.Level
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h *syslogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithAttrs(attrs), w: h.w}
+}
+
+func (h *syslogHandler) WithGroup(name string) slog.Handler {
+	return &syslogHandler{Handler: h.Handler.WithGroup(name), w: h.w}
+}
@@ -0,0 +1,47 @@
+//go:build !windows
+
+package cmd
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/exp/slog"
+)
+
+func TestSyslog(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	w, err := dialSyslog("udp://"+conn.LocalAddr().String(), "local0", "go-sshd")
+	require.NoError(t, err)
+	defer w.Close()
+	logger := slog.New(newSyslogHandler(w, logFormatText, &slog.HandlerOptions{})).With("user", "john")
+
+	receive := func() string {
+		buf := make([]byte, 1024)
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+	logger.Info("new SSH connection")
+	msg := receive()
+	// local0 (16) * 8 + info (6)
+	assert.Regexp(t, `^<134>`, msg)
+	assert.Contains(t, msg, "go-sshd[")
+	assert.Contains(t, msg, `level=INFO msg="new SSH connection" user=john`)
+	assert.NotContains(t, msg, "time=")
+
+	logger.Error("failed to accept connection")
+	// local0 (16) * 8 + err (3)
+	assert.Regexp(t, `^<131>`, receive())
+
+	_, err = dialSyslog("udp://"+conn.LocalAddr().String(), "invalid", "go-sshd")
+	assert.EqualError(t, err, "invalid syslog facility: invalid")
+	_, err = dialSyslog("http://127.0.0.1", "daemon", "go-sshd")
+	assert.Error(t, err)
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"golang.org/x/exp/slog"
+)
+
+func dialSyslog(address, facility, tag string) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("syslog is not supported on Windows")
+}
+
+func newSyslogHandler(w io.WriteCloser, format string, opts *slog.HandlerOptions) slog.Handler {
+	return nil
+}
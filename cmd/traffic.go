@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"errors"
+	"time"
+
+	"github.com/John-Ao/go-sshd/accounting"
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// openTrafficFile opens --traffic-file. It returns nil if not specified.
+func openTrafficFile(flag *flagType) (*accounting.Store, error) {
+	if flag.trafficFile == "" {
+		return nil, nil
+	}
+	if flag.trafficSaveInterval <= 0 {
+		return nil, errors.New("--traffic-save-interval must be positive")
+	}
+	return accounting.Open(flag.trafficFile)
+}
+
+// userTraffic returns the traffic by user of all servers, including the saved one with --traffic-file
+func (sup *supervisor) userTraffic() (map[string]server.Traffic, error) {
+	traffic := sup.totalStats().UserTraffic
+	if sup.traffic == nil {
+		return traffic, nil
+	}
+	return sup.traffic.Totals(traffic)
+}
+
+// saveTraffic saves the traffic by user to --traffic-file
+func (sup *supervisor) saveTraffic() {
+	if err := sup.traffic.Save(sup.totalStats().UserTraffic); err != nil {
+		sup.logger.Error("failed to save traffic", "err", err)
+	}
+}
+
+// saveTrafficPeriodically saves the traffic every trafficSaveInterval and when stop is called
+func (sup *supervisor) saveTrafficPeriodically() (stop func()) {
+	ticker := time.NewTicker(sup.trafficSaveInterval)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-ticker.C:
+				sup.saveTraffic()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-stopped
+		sup.saveTraffic()
+	}
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/accounting"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrafficFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traffic.json")
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--traffic-file", path, "--traffic-save-interval", "10ms"})
+	ctx, cancel := context.WithCancel(context.Background())
+	// The traffic is saved on exit before the file is removed
+	exited := make(chan struct{})
+	defer func() {
+		cancel()
+		<-exited
+	}()
+	go func() {
+		defer close(exited)
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	defer client.Close()
+	assertExec(t, client)
+
+	require.Eventually(t, func() bool {
+		traffic, err := accounting.Load(path)
+		return err == nil && traffic["john"].BytesSent != 0
+	}, 5*time.Second, 10*time.Millisecond)
+	traffic, err := accounting.Load(path)
+	require.NoError(t, err)
+	assert.Zero(t, traffic["john"].ForwardBytesSent)
+}
+
+func TestTrafficSaveIntervalInvalid(t *testing.T) {
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(getAvailableTcpPort()), "--traffic-file", filepath.Join(t.TempDir(), "traffic.json"), "--traffic-save-interval", "0s"})
+	rootCmd.SetOut(&bytes.Buffer{})
+	rootCmd.SetErr(&bytes.Buffer{})
+	assert.EqualError(t, rootCmd.Execute(), "--traffic-save-interval must be positive")
+}
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/John-Ao/go-sshd/version"
+
+	"github.com/spf13/cobra"
+)
+
+func versionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Show the version and build metadata",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			info := version.Get()
+			fmt.Fprintf(cmd.OutOrStdout(), "go-sshd %s\ncommit: %s\nbuild date: %s\ngo version: %s\nplatform: %s\n",
+				info.Version, info.Commit, info.BuildDate, info.GoVersion, info.Platform)
+		},
+	}
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/webhook"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// newWebhookNotifier returns the notifier of --webhook-url. It returns nil if not specified.
+func newWebhookNotifier(logger *slog.Logger, flag *flagType) (*webhook.Notifier, error) {
+	if len(flag.webhookURLs) == 0 {
+		if flag.webhookSecretFile != "" {
+			return nil, errors.New("--webhook-secret-file requires --webhook-url")
+		}
+		return nil, nil
+	}
+	for _, u := range flag.webhookURLs {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --webhook-url: %w", err)
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			return nil, fmt.Errorf("invalid --webhook-url %s: scheme must be http or https", u)
+		}
+	}
+	for _, event := range flag.webhookEvents {
+		if !slices.Contains(webhook.AllEvents, event) {
+			return nil, fmt.Errorf("invalid --webhook-events %s: must be one of %s", event, strings.Join(webhook.AllEvents, ", "))
+		}
+	}
+	if flag.webhookAuthFailures <= 0 {
+		return nil, errors.New("--webhook-auth-failures must be positive")
+	}
+	if flag.webhookAuthFailuresWindow <= 0 {
+		return nil, errors.New("--webhook-auth-failures-window must be positive")
+	}
+	if flag.webhookLargeUpload <= 0 {
+		return nil, errors.New("--webhook-large-upload must be positive")
+	}
+	var secret []byte
+	if flag.webhookSecretFile != "" {
+		var err error
+		secret, err = readSecretFile(flag.webhookSecretFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &webhook.Notifier{
+		URLs:              flag.webhookURLs,
+		Secret:            secret,
+		Events:            flag.webhookEvents,
+		AuthFailureBurst:  flag.webhookAuthFailures,
+		AuthFailureWindow: flag.webhookAuthFailuresWindow,
+		LargeUploadBytes:  uint64(flag.webhookLargeUpload) * 1024 * 1024,
+		Logger:            logger,
+	}, nil
+}
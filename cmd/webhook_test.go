@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/webhook"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhook(t *testing.T) {
+	payloads := make(chan webhook.Payload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, webhook.Sign([]byte("secret"), body), r.Header.Get(webhook.HeaderSignature))
+		var payload webhook.Payload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		payloads <- payload
+	}))
+	defer receiver.Close()
+	secretFile := filepath.Join(t.TempDir(), "webhook.secret")
+	require.NoError(t, os.WriteFile(secretFile, []byte("secret\n"), 0600))
+	port := getAvailableTcpPort()
+	rootCmd := RootCmd()
+	rootCmd.SetArgs([]string{"--port", strconv.Itoa(port), "--user", "john:mypass", "--webhook-url", receiver.URL, "--webhook-secret-file", secretFile, "--webhook-auth-failures", "2"})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		rootCmd.SetErr(&bytes.Buffer{})
+		rootCmd.ExecuteContext(ctx)
+	}()
+	waitTCPServer(port)
+	for i := 0; i < 2; i++ {
+		_, err := dialPassword(port, "john", "wrong")
+		require.Error(t, err)
+	}
+	client, err := dialPassword(port, "john", "mypass")
+	require.NoError(t, err)
+	client.Close()
+
+	var events []string
+	for len(events) < 2 {
+		select {
+		case payload := <-payloads:
+			events = append(events, payload.Event)
+			assert.Equal(t, "john", payload.User)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhooks not received: %v", events)
+		}
+	}
+	assert.Equal(t, []string{webhook.EventAuthFailureBurst, webhook.EventLogin}, events)
+}
+
+func TestWebhookInvalid(t *testing.T) {
+	for _, args := range [][]string{
+		{"--webhook-url", "ftp://example.com"},
+		{"--webhook-url", "https://example.com", "--webhook-events", "logout"},
+		{"--webhook-secret-file", "webhook.secret"},
+	} {
+		rootCmd := RootCmd()
+		rootCmd.SetArgs(append([]string{"--port", strconv.Itoa(getAvailableTcpPort())}, args...))
+		rootCmd.SetOut(io.Discard)
+		rootCmd.SetErr(io.Discard)
+		assert.Error(t, rootCmd.Execute(), args)
+	}
+}
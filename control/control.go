@@ -0,0 +1,334 @@
+// Package control serves a local Unix domain socket for operators to inspect and close connections, and is its client.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// Connection is an active SSH connection with its sessions and forwards.
+type Connection struct {
+	ID         string    `json:"id"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_address"`
+	Channels   int64     `json:"channels"`
+	Goroutines int64     `json:"goroutines"`
+	Processes  int64     `json:"processes"`
+	StartTime  time.Time `json:"start_time"`
+	Sessions   []Session `json:"sessions"`
+	Forwards   []Forward `json:"forwards"`
+}
+
+// Session is an active session of a connection.
+type Session struct {
+	ID string `json:"id"`
+	// Type is "shell", "exec" or "subsystem". It is empty until requested.
+	Type    string   `json:"type"`
+	Command []string `json:"command"`
+	// PID is the process ID of "shell" or "exec". It is 0 if unknown.
+	PID int `json:"pid,omitempty"`
+	// Pty is whether "shell" or "exec" has a pseudo terminal
+	Pty       bool      `json:"pty,omitempty"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// Forward is an active forwarding of a connection.
+type Forward struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Host      string    `json:"host,omitempty"`
+	Port      int       `json:"port,omitempty"`
+	Path      string    `json:"path,omitempty"`
+	StartTime time.Time `json:"start_time"`
+}
+
+// Handler returns the HTTP handler of the control socket for the servers returned by servers.
+//
+//	GET  /v1/connections     lists connections
+//	POST /v1/close?id=ID     closes the connection, the session or the forwarding of ID
+//	GET  /v1/monitor?id=ID   streams the live output of the session of ID, notifying the client if notify=true
+//	POST /v1/wall?message=M  writes M to the terminals of sessions, only of user=USER if any, and responds {"sessions": [IDs]}
+func Handler(servers func() []*server.Server) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(ListConnections(servers()))
+	})
+	mux.HandleFunc("/v1/close", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		for _, s := range servers() {
+			if id != "" && s.Close(id) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.Error(w, fmt.Sprintf("not found: %s", id), http.StatusNotFound)
+	})
+	mux.HandleFunc("/v1/monitor", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id := r.URL.Query().Get("id")
+		output, stop, ok := Monitor(servers(), id, r.URL.Query().Get("notify") == "true")
+		if !ok {
+			http.Error(w, fmt.Sprintf("not found: %s", id), http.StatusNotFound)
+			return
+		}
+		defer stop()
+		StreamOutput(w, r, output)
+	})
+	mux.HandleFunc("/v1/wall", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		message := r.URL.Query().Get("message")
+		if message == "" {
+			http.Error(w, "empty message", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(WallResponse{Sessions: Wall(servers(), message, r.URL.Query()["user"])})
+	})
+	return mux
+}
+
+// WallResponse is the response of writing a message to the terminals of sessions.
+type WallResponse struct {
+	// Sessions are the IDs of the sessions written to
+	Sessions []string `json:"sessions"`
+}
+
+// Wall writes message to the terminals of the sessions of servers, only of users if not empty, and returns the IDs of the sessions.
+func Wall(servers []*server.Server, message string, users []string) []string {
+	ids := []string{}
+	for _, s := range servers {
+		ids = append(ids, s.Wall(message, users)...)
+	}
+	return ids
+}
+
+// Monitor returns the live output of the session of id in servers. It returns false if not found.
+func Monitor(servers []*server.Server, id string, notify bool) (output <-chan []byte, stop func(), ok bool) {
+	for _, s := range servers {
+		if id == "" {
+			break
+		}
+		if output, stop, ok := s.Monitor(id, notify); ok {
+			return output, stop, true
+		}
+	}
+	return nil, nil, false
+}
+
+// StreamOutput writes output to w as it is received until it is closed or the request is canceled.
+func StreamOutput(w http.ResponseWriter, r *http.Request, output <-chan []byte) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	for {
+		select {
+		case chunk, ok := <-output:
+			if !ok {
+				return
+			}
+			if _, err := w.Write(chunk); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// ListConnections returns the active connections of servers.
+func ListConnections(servers []*server.Server) []Connection {
+	connections := []Connection{}
+	for _, s := range servers {
+		for _, info := range s.Connections() {
+			connections = append(connections, newConnection(info))
+		}
+	}
+	return connections
+}
+
+func newConnection(info server.ConnectionInfo) Connection {
+	conn := Connection{
+		ID:         info.ID,
+		User:       info.User,
+		Channels:   info.Channels,
+		Goroutines: info.Goroutines,
+		Processes:  info.Processes,
+		StartTime:  info.StartTime,
+		Sessions:   []Session{},
+		Forwards:   []Forward{},
+	}
+	if info.RemoteAddr != nil {
+		conn.RemoteAddr = info.RemoteAddr.String()
+	}
+	for _, sess := range info.Sessions {
+		conn.Sessions = append(conn.Sessions, Session{ID: sess.ID, Type: sess.Type, Command: sess.Command, PID: sess.PID, Pty: sess.Pty, StartTime: sess.StartTime})
+	}
+	for _, fwd := range info.Forwards {
+		conn.Forwards = append(conn.Forwards, Forward{ID: fwd.ID, Type: fwd.Type, Host: fwd.Host, Port: fwd.Port, Path: fwd.Path, StartTime: fwd.StartTime})
+	}
+	return conn
+}
+
+// Listen listens on the Unix domain socket path accessible only by the user.
+// An existing socket is replaced if takeOver or no process listens on it.
+// The socket file is not removed by closing the listener; remove it on exit.
+func Listen(path string, takeOver bool) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if !takeOver {
+			if conn, err := net.Dial("unix", path); err == nil {
+				conn.Close()
+				return nil, fmt.Errorf("control socket %s is in use", path)
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	// An upgraded process may listen on the same path
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(path, 0600); err != nil {
+		ln.Close()
+		os.Remove(path)
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Client is a client of the control socket.
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient returns a client of the control socket of path.
+func NewClient(path string) *Client {
+	return &Client{httpClient: &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, "unix", path)
+			},
+		},
+		Timeout: 10 * time.Second,
+	}}
+}
+
+// Connections returns the active connections.
+func (c *Client) Connections() ([]Connection, error) {
+	res, err := c.httpClient.Get("http://control/v1/connections")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return nil, err
+	}
+	var connections []Connection
+	if err := json.NewDecoder(res.Body).Decode(&connections); err != nil {
+		return nil, err
+	}
+	return connections, nil
+}
+
+// Close closes the connection, the session or the forwarding of id.
+func (c *Client) Close(id string) error {
+	res, err := c.httpClient.Post("http://control/v1/close?id="+url.QueryEscape(id), "", nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	return responseError(res)
+}
+
+// Monitor writes the live output of the session of id to w until the session ends or ctx is done.
+// The client is notified if notify.
+func (c *Client) Monitor(ctx context.Context, id string, notify bool, w io.Writer) error {
+	query := url.Values{"id": {id}}
+	if notify {
+		query.Set("notify", "true")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://control/v1/monitor?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	// The output is streamed beyond the timeout of other requests
+	httpClient := *c.httpClient
+	httpClient.Timeout = 0
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, res.Body)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// Wall writes message to the terminals of sessions, only of users if not empty, and returns the IDs of the sessions.
+func (c *Client) Wall(message string, users []string) ([]string, error) {
+	query := url.Values{"message": {message}, "user": users}
+	res, err := c.httpClient.Post("http://control/v1/wall?"+query.Encode(), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := responseError(res); err != nil {
+		return nil, err
+	}
+	var wall WallResponse
+	if err := json.NewDecoder(res.Body).Decode(&wall); err != nil {
+		return nil, err
+	}
+	return wall.Sessions, nil
+}
+
+func responseError(res *http.Response) error {
+	if res.StatusCode/100 == 2 {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	if len(b) == 0 {
+		return errors.New(res.Status)
+	}
+	return errors.New(strings.TrimRight(string(b), "\r\n"))
+}
@@ -0,0 +1,186 @@
+package control
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/sshdtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+func TestControl(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}, AllowTcpipForward: true}
+	sshClient := sshdtest.NewClient(t, s, "john")
+	remoteLn, err := sshClient.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer remoteLn.Close()
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := Listen(path, false)
+	require.NoError(t, err)
+	defer ln.Close()
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	go http.Serve(ln, Handler(func() []*server.Server { return []*server.Server{s} }))
+	// The socket in use is not replaced
+	_, err = Listen(path, false)
+	assert.Error(t, err)
+
+	client := NewClient(path)
+	connections, err := client.Connections()
+	require.NoError(t, err)
+	require.Len(t, connections, 1)
+	conn := connections[0]
+	assert.Equal(t, "john", conn.User)
+	assert.Empty(t, conn.Sessions)
+	require.Len(t, conn.Forwards, 1)
+	assert.Equal(t, "tcpip-forward", conn.Forwards[0].Type)
+	assert.Equal(t, remoteLn.Addr().(*net.TCPAddr).Port, conn.Forwards[0].Port)
+
+	assert.EqualError(t, client.Close("unknown"), "not found: unknown")
+	require.NoError(t, client.Close(conn.ID))
+	assert.Error(t, sshClient.Wait())
+}
+
+func TestListenStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := Listen(path, false)
+	require.NoError(t, err)
+	ln.Close()
+	// The socket file is left by closing
+	assert.FileExists(t, path)
+	ln, err = Listen(path, false)
+	require.NoError(t, err)
+	ln.Close()
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestMonitor(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}, AllowExecute: true}
+	started := make(chan struct{})
+	s.Handler = func(sess server.Session) {
+		close(started)
+		io.Copy(sess, sess)
+	}
+	sshClient := sshdtest.NewClient(t, s, "john")
+	session, err := sshClient.NewSession()
+	require.NoError(t, err)
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	<-started
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := Listen(path, false)
+	require.NoError(t, err)
+	defer ln.Close()
+	go http.Serve(ln, Handler(func() []*server.Server { return []*server.Server{s} }))
+	client := NewClient(path)
+	connections, err := client.Connections()
+	require.NoError(t, err)
+	require.Len(t, connections, 1)
+	require.Len(t, connections[0].Sessions, 1)
+	assert.EqualError(t, client.Monitor(context.Background(), "unknown", false, io.Discard), "not found: unknown")
+
+	var output lockedBuffer
+	done := make(chan error)
+	go func() {
+		done <- client.Monitor(context.Background(), connections[0].Sessions[0].ID, false, &output)
+	}()
+	// The monitor is subscribed when the output of the previous write is received
+	for !strings.Contains(output.String(), "ping") {
+		_, err := stdin.Write([]byte("ping"))
+		require.NoError(t, err)
+		select {
+		case err := <-done:
+			t.Fatal(err)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	// The monitor ends with the session
+	stdin.Close()
+	require.NoError(t, <-done)
+}
+
+func TestWall(t *testing.T) {
+	s := &server.Server{Logger: slog.Default(), Config: &ssh.ServerConfig{NoClientAuth: true}, AllowExecute: true}
+	started := make(chan struct{}, 2)
+	s.Handler = func(sess server.Session) {
+		started <- struct{}{}
+		io.Copy(io.Discard, sess)
+	}
+	sshClient := sshdtest.NewClient(t, s, "john")
+	ptySession, err := sshClient.NewSession()
+	require.NoError(t, err)
+	defer ptySession.Close()
+	var stderr lockedBuffer
+	ptySession.Stderr = &stderr
+	// Sessions end on EOF of stdin
+	_, err = ptySession.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, ptySession.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	require.NoError(t, ptySession.Shell())
+	execSession, err := sshClient.NewSession()
+	require.NoError(t, err)
+	defer execSession.Close()
+	_, err = execSession.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, execSession.Start("true"))
+	<-started
+	<-started
+
+	path := filepath.Join(t.TempDir(), "control.sock")
+	ln, err := Listen(path, false)
+	require.NoError(t, err)
+	defer ln.Close()
+	go http.Serve(ln, Handler(func() []*server.Server { return []*server.Server{s} }))
+	client := NewClient(path)
+	connections, err := client.Connections()
+	require.NoError(t, err)
+	require.Len(t, connections, 1)
+
+	// Only sessions with a pty get the message
+	ids, err := client.Wall("maintenance at 18:00\nsave your work", nil)
+	require.NoError(t, err)
+	require.Len(t, ids, 1)
+	assert.True(t, strings.HasPrefix(ids[0], connections[0].ID+"/"))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stderr.String(), "\r\nmaintenance at 18:00\r\nsave your work\r\n")
+	}, 3*time.Second, 10*time.Millisecond)
+	ids, err = client.Wall("maintenance", []string{"alice"})
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+	_, err = client.Wall("", nil)
+	assert.EqualError(t, err, "empty message")
+}
@@ -0,0 +1,95 @@
+package daemon
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// privileged runs the functions of RunPrivileged on the thread keeping the capabilities if not nil
+var privileged chan func()
+
+// dropKeepingCapabilities switches the user and groups of the process to c and keeps the capabilities of keep
+// only on a locked thread. Capabilities are per thread, so the other threads and the processes they start have none.
+func dropKeepingCapabilities(c *Credential, keep []Capability) error {
+	var mask uint32
+	for _, capability := range keep {
+		mask |= 1 << capability
+	}
+	errCh := make(chan error)
+	ch := make(chan func())
+	go func() {
+		// The thread is never unlocked, so it exits with the goroutine instead of running others with the capabilities
+		runtime.LockOSThread()
+		if err := dropOnThread(c, mask); err != nil {
+			errCh <- err
+			return
+		}
+		errCh <- nil
+		for f := range ch {
+			f()
+		}
+	}()
+	if err := <-errCh; err != nil {
+		return err
+	}
+	// Make sure the other threads can not regain privileges. Unlike syscall.Setuid, the raw system call applies only to
+	// the locked thread, which exits with the goroutine.
+	regained := make(chan bool, 1)
+	go func() {
+		runtime.LockOSThread()
+		_, _, errno := unix.RawSyscall(unix.SYS_SETUID, 0, 0, 0)
+		regained <- errno == 0
+	}()
+	if c.UID != 0 && <-regained {
+		return fmt.Errorf("privileges were not dropped")
+	}
+	privileged = ch
+	return nil
+}
+
+// dropOnThread drops privileges of the process keeping the capabilities of mask on the calling thread
+func dropOnThread(c *Credential, mask uint32) error {
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to keep capabilities: %w", err)
+	}
+	// Setgroups, Setgid and Setuid apply to all threads, and PR_SET_KEEPCAPS keeps the permitted capabilities of this one
+	if err := setCredential(c); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_KEEPCAPS, 0, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to reset keeping capabilities: %w", err)
+	}
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{{Effective: mask, Permitted: mask}}
+	if err := unix.Capset(&header, &data[0]); err != nil {
+		return fmt.Errorf("failed to set capabilities: %w", err)
+	}
+	return nil
+}
+
+// RunPrivileged runs f on the thread keeping the capabilities of DropPrivileges, e.g. to start a process as another user.
+// f runs directly if no capabilities are kept. Processes started by f get no capabilities unless they run as root.
+func RunPrivileged(f func() error) error {
+	if privileged == nil {
+		return f()
+	}
+	errCh := make(chan error, 1)
+	privileged <- func() { errCh <- f() }
+	return <-errCh
+}
+
+// StartAsRoot starts cmd as root by the capabilities kept by DropPrivileges, e.g. for an upgrade to keep them too.
+func StartAsRoot(cmd *exec.Cmd) error {
+	if privileged == nil {
+		return cmd.Start()
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: 0, Gid: 0, Groups: []uint32{0}}
+	return RunPrivileged(cmd.Start)
+}
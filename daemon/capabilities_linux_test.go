@@ -0,0 +1,67 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// envTestKeepAs is the user the process of TestDropPrivilegesKeepingCapabilities switches to
+const envTestKeepAs = "DAEMON_TEST_KEEP_AS"
+
+// init runs the process started by TestDropPrivilegesKeepingCapabilities before TestMain
+func init() {
+	if spec := os.Getenv(envTestKeepAs); spec != "" {
+		os.Exit(runKeepCapabilities(spec))
+	}
+}
+
+// runKeepCapabilities drops privileges keeping the capabilities to start id as root
+func runKeepCapabilities(spec string) int {
+	c, err := LookupCredential(spec)
+	if err != nil {
+		return 1
+	}
+	if err := DropPrivileges(c, []Capability{CapSetGID, CapSetUID}); err != nil {
+		return 2
+	}
+	if os.Geteuid() != c.UID || os.Getegid() != c.GID {
+		return 3
+	}
+	// Only the privileged thread can start processes as other users
+	if err := StartAsRoot(exec.Command("id", "-u")); err != nil {
+		return 4
+	}
+	cmd := exec.Command("id", "-u")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 0, Gid: 0}}
+	var output []byte
+	if err := RunPrivileged(func() (err error) {
+		output, err = cmd.Output()
+		return err
+	}); err != nil || strings.TrimSpace(string(output)) != "0" {
+		return 5
+	}
+	cmd = exec.Command("id", "-u")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 0, Gid: 0}}
+	if cmd.Run() == nil {
+		return 6
+	}
+	return 0
+}
+
+func TestDropPrivilegesKeepingCapabilities(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("no nobody user")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), envTestKeepAs+"=nobody")
+	assert.NoError(t, cmd.Run())
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package daemon
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// dropKeepingCapabilities is supported only on Linux.
+func dropKeepingCapabilities(c *Credential, keep []Capability) error {
+	return fmt.Errorf("keeping capabilities is supported only on Linux")
+}
+
+// RunPrivileged runs f, because capabilities are kept only on Linux.
+func RunPrivileged(f func() error) error {
+	return f()
+}
+
+// StartAsRoot starts cmd, because capabilities are kept only on Linux.
+func StartAsRoot(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
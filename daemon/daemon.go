@@ -0,0 +1,92 @@
+// Package daemon runs the process in the background like a traditional daemon, drops its privileges and restricts it on OpenBSD.
+// Go programs can not fork, so Detach starts the executable again and waits for it to become ready.
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// envDaemon is set for the process started by Detach, which receives the pipe to notify readiness as fd 3.
+// It is not GO_SSHD_DAEMON, which sets --daemon.
+const envDaemon = "GO_SSHD_DAEMON_CHILD"
+
+var (
+	loadOnce sync.Once
+	mu       sync.Mutex
+	ready    *os.File
+)
+
+func load() {
+	loadOnce.Do(func() {
+		if os.Getenv(envDaemon) == "" {
+			return
+		}
+		// Not to be inherited by shells and upgraded processes
+		os.Unsetenv(envDaemon)
+		ready = os.NewFile(3, "ready")
+	})
+}
+
+// Daemonized reports whether this process was started by Detach and has not called Ready yet.
+func Daemonized() bool {
+	load()
+	mu.Lock()
+	defer mu.Unlock()
+	return ready != nil
+}
+
+// Detach starts the executable with the same arguments in a new session and waits for it to call Ready.
+// The caller should exit after Detach succeeded.
+// The standard output and error of the new process are the ones of this process until Ready, so that errors on startup are shown.
+func Detach() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envDaemon+"=1")
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	cmd.SysProcAttr, err = sysProcAttr()
+	if err != nil {
+		readyWriter.Close()
+		return err
+	}
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	// The pipe is closed without data when the process exits
+	if _, err := readyReader.Read(make([]byte, 1)); err != nil {
+		return fmt.Errorf("daemon exited before becoming ready")
+	}
+	return cmd.Process.Release()
+}
+
+// Ready notifies the process which called Detach and redirects the standard input and output to the null device.
+// It does nothing if this process was not started by Detach.
+func Ready() error {
+	load()
+	mu.Lock()
+	defer mu.Unlock()
+	if ready == nil {
+		return nil
+	}
+	if err := redirectStdio(); err != nil {
+		return err
+	}
+	_, err := ready.Write([]byte{1})
+	ready.Close()
+	ready = nil
+	return err
+}
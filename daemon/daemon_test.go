@@ -0,0 +1,120 @@
+package daemon
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envTestPIDFile is the PID file written by the daemon of the test
+const envTestPIDFile = "DAEMON_TEST_PID_FILE"
+
+// envTestRunAs is the user the process of TestDropPrivileges switches to
+const envTestRunAs = "DAEMON_TEST_RUN_AS"
+
+func TestMain(m *testing.M) {
+	if path := os.Getenv(envTestPIDFile); path != "" {
+		os.Exit(runDaemon(path))
+	}
+	if spec := os.Getenv(envTestRunAs); spec != "" {
+		os.Exit(runDropPrivileges(spec))
+	}
+	os.Exit(m.Run())
+}
+
+// runDaemon is the process started by Detach
+func runDaemon(path string) int {
+	if !Daemonized() {
+		return 1
+	}
+	if err := WritePIDFile(path); err != nil {
+		return 1
+	}
+	if err := Ready(); err != nil || Daemonized() {
+		return 1
+	}
+	return 0
+}
+
+func TestDetach(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("daemon is not supported on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "go-sshd.pid")
+	t.Setenv(envTestPIDFile, path)
+	require.NoError(t, Detach())
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	assert.NotEqual(t, os.Getpid(), pid)
+	assert.False(t, Daemonized())
+}
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-sshd.pid")
+	require.NoError(t, WritePIDFile(path))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(b))
+	require.NoError(t, RemovePIDFile(path))
+	assert.NoFileExists(t, path)
+
+	// The file of another process is kept
+	require.NoError(t, os.WriteFile(path, []byte("1\n"), 0644))
+	require.NoError(t, RemovePIDFile(path))
+	assert.FileExists(t, path)
+}
+
+// runDropPrivileges is the process started by TestDropPrivileges
+func runDropPrivileges(spec string) int {
+	c, err := LookupCredential(spec)
+	if err != nil {
+		return 1
+	}
+	if err := DropPrivileges(c, nil); err != nil {
+		return 2
+	}
+	if os.Geteuid() != c.UID || os.Getegid() != c.GID {
+		return 3
+	}
+	// Again after an upgrade
+	if err := DropPrivileges(c, nil); err != nil {
+		return 4
+	}
+	return 0
+}
+
+func TestLookupCredential(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("user IDs are not numbers on Windows")
+	}
+	c, err := LookupCredential("root")
+	require.NoError(t, err)
+	assert.Equal(t, 0, c.UID)
+	assert.Equal(t, 0, c.GID)
+	_, err = LookupCredential("root:no-such-group")
+	assert.Error(t, err)
+	_, err = LookupCredential("no-such-user")
+	assert.Error(t, err)
+}
+
+func TestDropPrivileges(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("dropping privileges requires root")
+	}
+	if _, err := user.Lookup("nobody"); err != nil {
+		t.Skip("no nobody user")
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(os.Environ(), envTestRunAs+"=nobody")
+	assert.NoError(t, cmd.Run())
+}
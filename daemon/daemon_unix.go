@@ -0,0 +1,29 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+func sysProcAttr() (*syscall.SysProcAttr, error) {
+	// Detach from the controlling terminal
+	return &syscall.SysProcAttr{Setsid: true}, nil
+}
+
+func redirectStdio() error {
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+	for _, fd := range []int{0, 1, 2} {
+		if err := unix.Dup2(int(null.Fd()), fd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package daemon
+
+import (
+	"fmt"
+	"syscall"
+)
+
+func sysProcAttr() (*syscall.SysProcAttr, error) {
+	return nil, fmt.Errorf("daemon is not supported on Windows; use a service manager")
+}
+
+func redirectStdio() error {
+	return nil
+}
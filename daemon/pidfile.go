@@ -0,0 +1,24 @@
+package daemon
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+)
+
+// WritePIDFile writes the process ID to path, replacing the file of the previous process.
+func WritePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// RemovePIDFile removes path if it has the process ID, so that the file written by the upgraded process is kept.
+func RemovePIDFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if string(bytes.TrimSpace(b)) != strconv.Itoa(os.Getpid()) {
+		return nil
+	}
+	return os.Remove(path)
+}
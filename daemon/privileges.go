@@ -0,0 +1,63 @@
+package daemon
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Credential is the user and groups to run as.
+type Credential struct {
+	UID    int
+	GID    int
+	Groups []int
+}
+
+// LookupCredential returns the credential of "user" or "user:group".
+// The group is the primary group of the user if omitted, and the supplementary groups are the ones of the user.
+func LookupCredential(spec string) (*Credential, error) {
+	userName, groupName, hasGroup := strings.Cut(spec, ":")
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported user ID: %s", u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported group ID: %s", u.Gid)
+	}
+	if hasGroup {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return nil, err
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return nil, fmt.Errorf("unsupported group ID: %s", g.Gid)
+		}
+	}
+	c := &Credential{UID: uid, GID: gid, Groups: []int{gid}}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range groupIDs {
+		if n, err := strconv.Atoi(id); err == nil && n != gid {
+			c.Groups = append(c.Groups, n)
+		}
+	}
+	return c, nil
+}
+
+// Capability is a Linux capability which DropPrivileges can keep.
+type Capability int
+
+// The Linux capabilities to start sessions as other users and chroot them after dropping privileges
+const (
+	CapSetGID    Capability = 6
+	CapSetUID    Capability = 7
+	CapSysChroot Capability = 18
+)
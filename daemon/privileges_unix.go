@@ -0,0 +1,42 @@
+//go:build !windows
+
+package daemon
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// DropPrivileges switches the user and groups of the process to c. It does nothing if the process already runs as c,
+// e.g. after an upgrade. The capabilities of keep are kept only for RunPrivileged, which is supported only on Linux.
+func DropPrivileges(c *Credential, keep []Capability) error {
+	if os.Geteuid() == c.UID && os.Getegid() == c.GID {
+		return nil
+	}
+	if len(keep) != 0 {
+		return dropKeepingCapabilities(c, keep)
+	}
+	if err := setCredential(c); err != nil {
+		return err
+	}
+	// Make sure the privileges can not be regained
+	if c.UID != 0 && syscall.Setuid(0) == nil {
+		return fmt.Errorf("privileges were not dropped")
+	}
+	return nil
+}
+
+// setCredential switches the user and groups of all threads to c
+func setCredential(c *Credential) error {
+	if err := syscall.Setgroups(c.Groups); err != nil {
+		return fmt.Errorf("failed to set groups: %w", err)
+	}
+	if err := syscall.Setgid(c.GID); err != nil {
+		return fmt.Errorf("failed to set group ID: %w", err)
+	}
+	if err := syscall.Setuid(c.UID); err != nil {
+		return fmt.Errorf("failed to set user ID: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package daemon
+
+import "fmt"
+
+// DropPrivileges is not supported on Windows.
+func DropPrivileges(c *Credential, keep []Capability) error {
+	return fmt.Errorf("dropping privileges is not supported on Windows")
+}
@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Sandbox is the promises of pledge(2) and the paths of unveil(2) of this process on OpenBSD.
+type Sandbox struct {
+	// Promises are space-separated promises. Executed programs are not restricted by them.
+	Promises string
+	// Paths are the permissions of unveil(2) such as "r", "rwc" and "rx" by path. Other paths are hidden.
+	Paths map[string]string
+}
+
+// Unveil adds permissions to path. Relative paths are made absolute.
+func (s *Sandbox) Unveil(path, permissions string) {
+	if path == "" {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if s.Paths == nil {
+		s.Paths = map[string]string{}
+	}
+	current := s.Paths[path]
+	for _, p := range permissions {
+		if !strings.ContainsRune(current, p) {
+			current += string(p)
+		}
+	}
+	s.Paths[path] = current
+}
@@ -0,0 +1,26 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Restrict unveils the paths of s, blocks further unveil calls and pledges the promises of s.
+// Missing paths are skipped. It can be called only once.
+func Restrict(s *Sandbox) error {
+	for path, permissions := range s.Paths {
+		if err := unix.Unveil(path, permissions); err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("unveil %s: %w", path, err)
+		}
+	}
+	if err := unix.UnveilBlock(); err != nil {
+		return os.NewSyscallError("unveil", err)
+	}
+	if err := unix.PledgePromises(s.Promises); err != nil {
+		return os.NewSyscallError("pledge", err)
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !openbsd
+
+package daemon
+
+import "fmt"
+
+// Restrict is supported only on OpenBSD.
+func Restrict(s *Sandbox) error {
+	return fmt.Errorf("pledge and unveil are supported only on OpenBSD")
+}
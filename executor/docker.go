@@ -0,0 +1,120 @@
+// Package executor provides server.Executor backends isolating sessions from the host.
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+// DockerConfig is a configuration of containers.
+type DockerConfig struct {
+	Image string
+	// Shell is the shell in the container for "shell" requests. "/bin/sh" is used if empty.
+	Shell string
+	// Mounts are volume specs of "docker run --volume" (e.g. "/srv/data:/data:ro").
+	Mounts []string
+	// CPUs is "docker run --cpus" (e.g. "0.5"). No limit if empty.
+	CPUs string
+	// Memory is "docker run --memory" (e.g. "256m"). No limit if empty.
+	Memory string
+	// PidsLimit is "docker run --pids-limit". No limit if 0.
+	PidsLimit int
+	// Network is "docker run --network" (e.g. "none"). The default network is used if empty.
+	Network string
+	// ExtraArgs are passed to "docker run" before the image.
+	ExtraArgs []string
+}
+
+// Docker runs each process in a new container with the docker CLI.
+type Docker struct {
+	// Command is the docker CLI. "docker" is used if empty.
+	Command string
+	// Default is the configuration for users not in Users.
+	Default DockerConfig
+	// Users is configurations per user.
+	Users map[string]DockerConfig
+	// Logger logs failures of cleaning up containers if not nil.
+	Logger *slog.Logger
+}
+
+var _ server.Executor = (*Docker)(nil)
+
+func (d *Docker) Start(spec *server.ProcessSpec) (server.Process, error) {
+	config, ok := d.Users[spec.User]
+	if !ok {
+		config = d.Default
+	}
+	if config.Image == "" {
+		return nil, fmt.Errorf("no docker image for %q", spec.User)
+	}
+	containerName := "go-sshd-" + uuid.New().String()
+	command := d.runCommand(containerName, &config, spec)
+	process, err := (&server.LocalExecutor{}).Start(&server.ProcessSpec{
+		User:    spec.User,
+		Command: command,
+		Pty:     spec.Pty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &containerProcess{Process: process, logger: d.Logger, remove: func() error {
+		return d.removeContainer(containerName)
+	}}, nil
+}
+
+func (d *Docker) command() string {
+	if d.Command == "" {
+		return "docker"
+	}
+	return d.Command
+}
+
+// runCommand returns the command line of "docker run"
+func (d *Docker) runCommand(containerName string, config *DockerConfig, spec *server.ProcessSpec) []string {
+	command := []string{d.command(), "run", "--rm", "--interactive", "--name", containerName, "--label", "go-sshd.user=" + spec.User}
+	if spec.Pty != nil {
+		command = append(command, "--tty", "--env", "TERM="+spec.Pty.Term)
+	}
+	for _, env := range spec.Env {
+		command = append(command, "--env", env)
+	}
+	for _, mount := range config.Mounts {
+		command = append(command, "--volume", mount)
+	}
+	if config.CPUs != "" {
+		command = append(command, "--cpus", config.CPUs)
+	}
+	if config.Memory != "" {
+		command = append(command, "--memory", config.Memory)
+	}
+	if config.PidsLimit != 0 {
+		command = append(command, "--pids-limit", fmt.Sprint(config.PidsLimit))
+	}
+	if config.Network != "" {
+		command = append(command, "--network", config.Network)
+	}
+	command = append(command, config.ExtraArgs...)
+	command = append(command, config.Image)
+	if spec.RawCommand == "" {
+		shell := config.Shell
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		return append(command, shell)
+	}
+	return append(command, spec.Command...)
+}
+
+func (d *Docker) removeContainer(containerName string) error {
+	// The container may have already been removed by --rm
+	output, err := exec.Command(d.command(), "rm", "--force", containerName).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to remove container %s: %w: %s", containerName, err, output)
+	}
+	return nil
+}
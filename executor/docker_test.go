@@ -0,0 +1,48 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDockerRunCommand(t *testing.T) {
+	d := &Docker{}
+	config := &DockerConfig{
+		Image:     "alpine:3.20",
+		Mounts:    []string{"/srv/data:/data:ro"},
+		CPUs:      "0.5",
+		Memory:    "256m",
+		PidsLimit: 64,
+		Network:   "none",
+	}
+	command := d.runCommand("go-sshd-test", config, &server.ProcessSpec{
+		User:    "john",
+		Command: []string{"/bin/bash"},
+		Env:     []string{"LANG=C"},
+		Pty:     &server.Pty{Term: "xterm"},
+	})
+	assert.Equal(t, []string{
+		"docker", "run", "--rm", "--interactive", "--name", "go-sshd-test", "--label", "go-sshd.user=john",
+		"--tty", "--env", "TERM=xterm",
+		"--env", "LANG=C",
+		"--volume", "/srv/data:/data:ro",
+		"--cpus", "0.5",
+		"--memory", "256m",
+		"--pids-limit", "64",
+		"--network", "none",
+		"alpine:3.20", "/bin/sh",
+	}, command)
+
+	command = d.runCommand("go-sshd-test", &DockerConfig{Image: "alpine:3.20"}, &server.ProcessSpec{
+		User:       "john",
+		Command:    []string{"ls", "-l", "my dir"},
+		RawCommand: `ls -l "my dir"`,
+	})
+	assert.Equal(t, []string{
+		"docker", "run", "--rm", "--interactive", "--name", "go-sshd-test", "--label", "go-sshd.user=john",
+		"alpine:3.20", "ls", "-l", "my dir",
+	}, command)
+}
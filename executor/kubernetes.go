@@ -0,0 +1,163 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+// KubernetesConfig is a configuration of pods.
+type KubernetesConfig struct {
+	// Context is the kubeconfig context. The current context is used if empty.
+	Context string
+	// Namespace of pods. The namespace of the context is used if empty.
+	Namespace string
+	// Pod is an existing pod to exec into. A new pod of Image is created per process if empty.
+	Pod string
+	// Container in Pod. The default container is used if empty.
+	Container string
+	// Image of pods created per process.
+	Image string
+	// Shell is the shell in the pod for "shell" requests. "/bin/sh" is used if empty.
+	Shell string
+}
+
+// Kubernetes attaches each process to a Kubernetes pod with the kubectl CLI.
+type Kubernetes struct {
+	// Command is the kubectl CLI. "kubectl" is used if empty.
+	Command string
+	// Default is the configuration for users not in Users.
+	Default KubernetesConfig
+	// Users is configurations per user.
+	Users map[string]KubernetesConfig
+	// Logger logs failures of cleaning up pods if not nil.
+	Logger *slog.Logger
+}
+
+var _ server.Executor = (*Kubernetes)(nil)
+
+func (k *Kubernetes) Start(spec *server.ProcessSpec) (server.Process, error) {
+	config, ok := k.Users[spec.User]
+	if !ok {
+		config = k.Default
+	}
+	if config.Pod == "" && config.Image == "" {
+		return nil, fmt.Errorf("neither pod nor image for %q", spec.User)
+	}
+	var command []string
+	podName := ""
+	if config.Pod != "" {
+		command = k.execCommand(&config, spec)
+	} else {
+		podName = "go-sshd-" + uuid.New().String()
+		command = k.runCommand(podName, &config, spec)
+	}
+	process, err := (&server.LocalExecutor{}).Start(&server.ProcessSpec{
+		User:    spec.User,
+		Command: command,
+		Pty:     spec.Pty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if podName == "" {
+		return process, nil
+	}
+	return &containerProcess{Process: process, logger: k.Logger, remove: func() error {
+		return k.deletePod(podName, &config)
+	}}, nil
+}
+
+func (k *Kubernetes) command() string {
+	if k.Command == "" {
+		return "kubectl"
+	}
+	return k.Command
+}
+
+// kubectlCommand returns the command line of kubectl with the global options
+func (k *Kubernetes) kubectlCommand(config *KubernetesConfig, args ...string) []string {
+	command := []string{k.command()}
+	if config.Context != "" {
+		command = append(command, "--context", config.Context)
+	}
+	if config.Namespace != "" {
+		command = append(command, "--namespace", config.Namespace)
+	}
+	return append(command, args...)
+}
+
+// execCommand returns the command line of "kubectl exec" into the existing pod
+func (k *Kubernetes) execCommand(config *KubernetesConfig, spec *server.ProcessSpec) []string {
+	command := k.kubectlCommand(config, "exec", "--stdin")
+	if spec.Pty != nil {
+		command = append(command, "--tty")
+	}
+	command = append(command, config.Pod)
+	if config.Container != "" {
+		command = append(command, "--container", config.Container)
+	}
+	command = append(command, "--")
+	// "kubectl exec" has no option to set environment variables
+	var env []string
+	if spec.Pty != nil {
+		env = append(env, "TERM="+spec.Pty.Term)
+	}
+	env = append(env, spec.Env...)
+	if len(env) != 0 {
+		command = append(append(command, "env"), env...)
+	}
+	return append(command, podCommand(config, spec)...)
+}
+
+// runCommand returns the command line of "kubectl run" creating a pod
+func (k *Kubernetes) runCommand(podName string, config *KubernetesConfig, spec *server.ProcessSpec) []string {
+	command := k.kubectlCommand(config, "run", podName, "--rm", "--stdin", "--restart=Never", "--quiet",
+		"--image="+config.Image, "--labels=go-sshd.user="+labelValue(spec.User))
+	if spec.Pty != nil {
+		command = append(command, "--tty", "--env=TERM="+spec.Pty.Term)
+	}
+	for _, env := range spec.Env {
+		command = append(command, "--env="+env)
+	}
+	command = append(command, "--")
+	return append(command, podCommand(config, spec)...)
+}
+
+func (k *Kubernetes) deletePod(podName string, config *KubernetesConfig) error {
+	// The pod may have already been deleted by --rm
+	command := k.kubectlCommand(config, "delete", "pod", podName, "--ignore-not-found", "--wait=false")
+	output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to delete pod %s: %w: %s", podName, err, output)
+	}
+	return nil
+}
+
+func podCommand(config *KubernetesConfig, spec *server.ProcessSpec) []string {
+	if spec.RawCommand == "" {
+		shell := config.Shell
+		if shell == "" {
+			shell = "/bin/sh"
+		}
+		return []string{shell}
+	}
+	return spec.Command
+}
+
+var invalidLabelValueCharRegexp = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// labelValue converts s into a valid label value
+// (https://kubernetes.io/docs/concepts/overview/working-with-objects/labels/#syntax-and-character-set)
+func labelValue(s string) string {
+	s = invalidLabelValueCharRegexp.ReplaceAllString(s, "_")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return s
+}
@@ -0,0 +1,46 @@
+package executor
+
+import (
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesExecCommand(t *testing.T) {
+	k := &Kubernetes{}
+	config := &KubernetesConfig{Context: "prod", Namespace: "tools", Pod: "toolbox", Container: "main"}
+	command := k.execCommand(config, &server.ProcessSpec{
+		User:    "john",
+		Command: []string{"/bin/bash"},
+		Env:     []string{"LANG=C"},
+		Pty:     &server.Pty{Term: "xterm"},
+	})
+	assert.Equal(t, []string{
+		"kubectl", "--context", "prod", "--namespace", "tools", "exec", "--stdin", "--tty", "toolbox", "--container", "main",
+		"--", "env", "TERM=xterm", "LANG=C", "/bin/sh",
+	}, command)
+
+	command = k.execCommand(&KubernetesConfig{Pod: "toolbox"}, &server.ProcessSpec{
+		User:       "john",
+		Command:    []string{"ls", "-l"},
+		RawCommand: "ls -l",
+	})
+	assert.Equal(t, []string{"kubectl", "exec", "--stdin", "toolbox", "--", "ls", "-l"}, command)
+}
+
+func TestKubernetesRunCommand(t *testing.T) {
+	k := &Kubernetes{Command: "/usr/local/bin/kubectl"}
+	config := &KubernetesConfig{Namespace: "sandbox", Image: "alpine:3.20", Shell: "/bin/ash"}
+	command := k.runCommand("go-sshd-test", config, &server.ProcessSpec{
+		User:    "john@example.com",
+		Command: []string{"/bin/bash"},
+		Pty:     &server.Pty{Term: "xterm"},
+	})
+	assert.Equal(t, []string{
+		"/usr/local/bin/kubectl", "--namespace", "sandbox", "run", "go-sshd-test", "--rm", "--stdin", "--restart=Never", "--quiet",
+		"--image=alpine:3.20", "--labels=go-sshd.user=john_example.com", "--tty", "--env=TERM=xterm",
+		"--", "/bin/ash",
+	}, command)
+}
@@ -0,0 +1,27 @@
+package executor
+
+import (
+	"sync"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"golang.org/x/exp/slog"
+)
+
+// containerProcess is a process of a CLI attached to a container, removing the container on Close.
+type containerProcess struct {
+	server.Process
+	logger     *slog.Logger
+	remove     func() error
+	removeOnce sync.Once
+}
+
+func (p *containerProcess) Close() error {
+	err := p.Process.Close()
+	p.removeOnce.Do(func() {
+		if removeErr := p.remove(); removeErr != nil && p.logger != nil {
+			p.logger.Info("failed to clean up", "err", removeErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,248 @@
+// Package forgekeys authenticates SSH users by the public keys published for their GitHub or GitLab accounts,
+// e.g. https://github.com/<account>.keys, optionally only members of an organization or a group, e.g. for team jump hosts.
+package forgekeys
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server/auth"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// Forges
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+)
+
+// accountPattern matches account names of GitHub and GitLab, not to request other paths
+var accountPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Provider authenticates users by the keys of their accounts on Forge. Its fields must not be changed after the first authentication.
+type Provider struct {
+	// Forge is GitHub or GitLab
+	Forge string
+	// URL is the web URL of the forge (default: "https://github.com" or "https://gitlab.com"), e.g. of GitHub Enterprise Server or self-managed GitLab
+	URL string
+	// APIURL is the REST API URL of the forge (default: "https://api.github.com" for github.com, URL with "/api/v3" for GitHub Enterprise Server, URL with "/api/v4" for GitLab)
+	APIURL string
+	// Accounts maps SSH user names to accounts. Only the users in it can log in if not nil, and others log in with the accounts of their user names.
+	Accounts map[string]string
+	// Org is the GitHub organization or the GitLab group (path) accounts must be members of if not empty
+	Org string
+	// Token is the API token to check memberships with, required to see private members
+	Token string
+	// Client is http.DefaultClient if nil
+	Client *http.Client
+	// CacheDuration is the time keys and memberships are used before fetched again (default: auth.DefaultAuthorizedKeysCacheDuration)
+	CacheDuration time.Duration
+	// FailOpen uses the last keys and memberships of accounts when the forge fails. Accounts never fetched are rejected anyway.
+	FailOpen bool
+	// Logger logs failures if not nil
+	Logger *slog.Logger
+
+	once    sync.Once
+	keys    *auth.AuthorizedKeysURL
+	mu      sync.Mutex
+	members map[string]membership
+}
+
+// membership is whether an account was a member of Org at checked
+type membership struct {
+	member  bool
+	checked time.Time
+}
+
+func (p *Provider) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	account, ok := p.account(conn.User())
+	if !ok {
+		return nil, fmt.Errorf("no %s account for %q", p.Forge, conn.User())
+	}
+	p.once.Do(p.init)
+	// Keys are checked first not to query memberships of accounts of others
+	if _, err := p.keys.PublicKeyCallback(accountConn{ConnMetadata: conn, account: account}, key); err != nil {
+		return nil, err
+	}
+	if p.Org != "" {
+		member, err := p.isMember(account)
+		if err != nil {
+			return nil, err
+		}
+		if !member {
+			return nil, fmt.Errorf("%s account %q of %q is not a member of %s", p.Forge, account, conn.User(), p.Org)
+		}
+	}
+	return nil, nil
+}
+
+// account returns the account of userName
+func (p *Provider) account(userName string) (string, bool) {
+	account := userName
+	if p.Accounts != nil {
+		var ok bool
+		if account, ok = p.Accounts[userName]; !ok {
+			return "", false
+		}
+	}
+	return account, accountPattern.MatchString(account)
+}
+
+func (p *Provider) init() {
+	p.keys = &auth.AuthorizedKeysURL{
+		URL:           strings.ReplaceAll(p.webURL(), "%", "%%") + "/%u.keys",
+		Client:        p.Client,
+		CacheDuration: p.CacheDuration,
+		FailOpen:      p.FailOpen,
+		Logger:        p.Logger,
+	}
+}
+
+// isMember reports whether account is a member of Org, cached for CacheDuration
+func (p *Provider) isMember(account string) (bool, error) {
+	now := time.Now()
+	p.mu.Lock()
+	cached, ok := p.members[account]
+	p.mu.Unlock()
+	cacheDuration := p.CacheDuration
+	if cacheDuration <= 0 {
+		cacheDuration = auth.DefaultAuthorizedKeysCacheDuration
+	}
+	if ok && now.Sub(cached.checked) < cacheDuration {
+		return cached.member, nil
+	}
+	member, err := p.checkMember(account)
+	if err != nil {
+		if p.Logger != nil {
+			p.Logger.Warn("failed to check membership", "forge", p.Forge, "account", account, "org", p.Org, "err", err, "fail_open", p.FailOpen)
+		}
+		if p.FailOpen && ok {
+			return cached.member, nil
+		}
+		return false, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.members == nil {
+		p.members = map[string]membership{}
+	}
+	// Only accounts with the keys of clients get here, so the map doesn't grow by scans
+	p.members[account] = membership{member: member, checked: now}
+	return member, nil
+}
+
+// checkMember asks the forge whether account is a member of Org
+func (p *Provider) checkMember(account string) (bool, error) {
+	if p.Forge == GitLab {
+		return p.checkGitLabMember(account)
+	}
+	// 204 for members, 404 for others. Without a token of a member, it redirects to the public members.
+	resp, err := p.get(fmt.Sprintf("%s/orgs/%s/members/%s", p.apiURL(), url.PathEscape(p.Org), url.PathEscape(account)))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status: %s", resp.Status)
+}
+
+func (p *Provider) checkGitLabMember(account string) (bool, error) {
+	resp, err := p.get(fmt.Sprintf("%s/users?username=%s", p.apiURL(), url.QueryEscape(account)))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	var users []struct {
+		ID int64 `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return false, fmt.Errorf("failed to decode users: %w", err)
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+	// Members include the ones inherited from ancestor groups
+	resp, err = p.get(fmt.Sprintf("%s/groups/%s/members/all/%d", p.apiURL(), url.PathEscape(p.Org), users[0].ID))
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("unexpected status: %s", resp.Status)
+}
+
+// get requests u of the API with Token
+func (p *Provider) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Token != "" {
+		if p.Forge == GitLab {
+			req.Header.Set("PRIVATE-TOKEN", p.Token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+	}
+	if p.Forge != GitLab {
+		req.Header.Set("Accept", "application/vnd.github+json")
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (p *Provider) webURL() string {
+	switch {
+	case p.URL != "":
+		return strings.TrimSuffix(p.URL, "/")
+	case p.Forge == GitLab:
+		return "https://gitlab.com"
+	}
+	return "https://github.com"
+}
+
+func (p *Provider) apiURL() string {
+	switch {
+	case p.APIURL != "":
+		return strings.TrimSuffix(p.APIURL, "/")
+	case p.Forge == GitLab:
+		return p.webURL() + "/api/v4"
+	case p.URL == "":
+		return "https://api.github.com"
+	}
+	return p.webURL() + "/api/v3"
+}
+
+// accountConn is the connection with the user name replaced by the account for AuthorizedKeysURL
+type accountConn struct {
+	ssh.ConnMetadata
+	account string
+}
+
+func (c accountConn) User() string {
+	return c.account
+}
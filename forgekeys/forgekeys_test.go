@@ -0,0 +1,105 @@
+package forgekeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type connMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c connMetadata) User() string {
+	return c.user
+}
+
+func (c connMetadata) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+}
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestGitHub(t *testing.T) {
+	signers := map[string]ssh.Signer{"octocat": newSigner(t), "outsider": newSigner(t)}
+	var authorization string
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/octocat.keys", "/outsider.keys":
+			w.Write(ssh.MarshalAuthorizedKey(signers[r.URL.Path[1:len(r.URL.Path)-len(".keys")]].PublicKey()))
+		case "/api/v3/orgs/acme/members/octocat":
+			authorization = r.Header.Get("Authorization")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer httpServer.Close()
+
+	p := &Provider{Forge: GitHub, URL: httpServer.URL, Accounts: map[string]string{"john": "octocat", "alex": "outsider", "bob": "../octocat"}}
+	_, err := p.PublicKeyCallback(connMetadata{user: "john"}, signers["octocat"].PublicKey())
+	assert.NoError(t, err)
+	_, err = p.PublicKeyCallback(connMetadata{user: "alex"}, signers["octocat"].PublicKey())
+	assert.Error(t, err)
+	// Users not in Accounts
+	_, err = p.PublicKeyCallback(connMetadata{user: "octocat"}, signers["octocat"].PublicKey())
+	assert.EqualError(t, err, `no github account for "octocat"`)
+	_, err = p.PublicKeyCallback(connMetadata{user: "bob"}, signers["octocat"].PublicKey())
+	assert.Error(t, err)
+
+	p = &Provider{Forge: GitHub, URL: httpServer.URL, Org: "acme", Token: "secret"}
+	_, err = p.PublicKeyCallback(connMetadata{user: "octocat"}, signers["octocat"].PublicKey())
+	assert.NoError(t, err)
+	assert.Equal(t, "Bearer secret", authorization)
+	_, err = p.PublicKeyCallback(connMetadata{user: "outsider"}, signers["outsider"].PublicKey())
+	assert.EqualError(t, err, `github account "outsider" of "outsider" is not a member of acme`)
+}
+
+func TestGitLab(t *testing.T) {
+	signer := newSigner(t)
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.EscapedPath() {
+		case "/alice.keys", "/mallory.keys":
+			w.Write(ssh.MarshalAuthorizedKey(signer.PublicKey()))
+		case "/api/v4/users":
+			assert.Equal(t, "token", r.Header.Get("PRIVATE-TOKEN"))
+			id := map[string]string{"alice": "1", "mallory": "2"}[r.URL.Query().Get("username")]
+			w.Write([]byte(`[{"id": ` + id + `}]`))
+		case "/api/v4/groups/acme%2Fops/members/all/1":
+			w.Write([]byte(`{"id": 1}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer httpServer.Close()
+
+	p := &Provider{Forge: GitLab, URL: httpServer.URL, Org: "acme/ops", Token: "token", CacheDuration: time.Nanosecond, FailOpen: true}
+	_, err := p.PublicKeyCallback(connMetadata{user: "alice"}, signer.PublicKey())
+	assert.NoError(t, err)
+	_, err = p.PublicKeyCallback(connMetadata{user: "mallory"}, signer.PublicKey())
+	assert.Error(t, err)
+
+	// The last keys and membership are used when the forge fails
+	httpServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	_, err = p.PublicKeyCallback(connMetadata{user: "alice"}, signer.PublicKey())
+	assert.NoError(t, err)
+	_, err = p.PublicKeyCallback(connMetadata{user: "bob"}, signer.PublicKey())
+	assert.Error(t, err)
+}
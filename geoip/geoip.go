@@ -0,0 +1,132 @@
+// Package geoip looks up the countries and autonomous systems of IP addresses in MaxMind DB files such as GeoLite2,
+// and allows or denies them by Rules.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+	"golang.org/x/exp/slices"
+)
+
+// Location is the country and autonomous system of an IP address. Fields not in the databases are zero.
+type Location struct {
+	// Country is the ISO 3166-1 alpha-2 code (e.g. "JP")
+	Country string
+	// ASN is the autonomous system number
+	ASN uint
+	// Organization is the organization of the autonomous system
+	Organization string
+}
+
+// IsZero reports whether l is not found in the databases, e.g. for private addresses.
+func (l Location) IsZero() bool {
+	return l == Location{}
+}
+
+// record is the fields of Location in the databases of countries, cities and autonomous systems
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// DB is MaxMind DB files looked up together, e.g. GeoLite2-Country and GeoLite2-ASN.
+type DB struct {
+	readers []*maxminddb.Reader
+}
+
+// Open opens the MaxMind DB files of paths.
+func Open(paths ...string) (*DB, error) {
+	db := &DB{}
+	for _, path := range paths {
+		reader, err := maxminddb.Open(path)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		db.readers = append(db.readers, reader)
+	}
+	return db, nil
+}
+
+// Lookup returns the location of ip merged from the databases. The first database with a field wins.
+func (db *DB) Lookup(ip net.IP) (Location, error) {
+	var location Location
+	for _, reader := range db.readers {
+		var r record
+		if err := reader.Lookup(ip, &r); err != nil {
+			return Location{}, err
+		}
+		if location.Country == "" {
+			location.Country = r.Country.ISOCode
+		}
+		if location.ASN == 0 {
+			location.ASN = r.ASN
+			location.Organization = r.Organization
+		}
+	}
+	return location, nil
+}
+
+// LookupAddr returns the location of the IP address of a TCP or UDP address. It returns the zero location for other addresses.
+func (db *DB) LookupAddr(addr net.Addr) (Location, error) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return db.Lookup(addr.IP)
+	case *net.UDPAddr:
+		return db.Lookup(addr.IP)
+	}
+	return Location{}, nil
+}
+
+// Close closes the files.
+func (db *DB) Close() error {
+	for _, reader := range db.readers {
+		reader.Close()
+	}
+	return nil
+}
+
+// Rules allow or deny locations. The zero value allows all.
+type Rules struct {
+	// AllowCountries and AllowASNs allow only locations in either of them if not empty
+	AllowCountries []string
+	AllowASNs      []uint
+	// DenyCountries and DenyASNs deny locations in them
+	DenyCountries []string
+	DenyASNs      []uint
+}
+
+// IsZero reports whether r allows all.
+func (r Rules) IsZero() bool {
+	return len(r.AllowCountries) == 0 && len(r.AllowASNs) == 0 && len(r.DenyCountries) == 0 && len(r.DenyASNs) == 0
+}
+
+// Allowed reports whether location is allowed. Locations not found in the databases are allowed, not to lock out private networks.
+func (r Rules) Allowed(location Location) bool {
+	if location.IsZero() {
+		return true
+	}
+	if containsCountry(r.DenyCountries, location.Country) || slices.Contains(r.DenyASNs, location.ASN) {
+		return false
+	}
+	if len(r.AllowCountries) == 0 && len(r.AllowASNs) == 0 {
+		return true
+	}
+	return containsCountry(r.AllowCountries, location.Country) || slices.Contains(r.AllowASNs, location.ASN)
+}
+
+// containsCountry reports whether countries contains country case-insensitively
+func containsCountry(countries []string, country string) bool {
+	if country == "" {
+		return false
+	}
+	return slices.ContainsFunc(countries, func(c string) bool {
+		return strings.EqualFold(c, country)
+	})
+}
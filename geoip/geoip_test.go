@@ -0,0 +1,81 @@
+package geoip
+
+import (
+	"bytes"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes the database of networks to a file in dir
+func writeFile(t *testing.T, dir, name string, networks map[netip.Prefix]Location) string {
+	var b bytes.Buffer
+	require.NoError(t, Write(&b, networks))
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, b.Bytes(), 0600))
+	return path
+}
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(
+		writeFile(t, dir, "country.mmdb", map[netip.Prefix]Location{
+			netip.MustParsePrefix("192.0.2.0/24"):  {Country: "JP"},
+			netip.MustParsePrefix("2001:db8::/32"): {Country: "DE"},
+		}),
+		writeFile(t, dir, "asn.mmdb", map[netip.Prefix]Location{
+			netip.MustParsePrefix("192.0.2.128/25"): {ASN: 64500, Organization: "Example"},
+		}),
+	)
+	require.NoError(t, err)
+	defer db.Close()
+
+	for ip, expected := range map[string]Location{
+		"192.0.2.1":    {Country: "JP"},
+		"192.0.2.200":  {Country: "JP", ASN: 64500, Organization: "Example"},
+		"2001:db8::1":  {Country: "DE"},
+		"198.51.100.1": {},
+		"::1":          {},
+	} {
+		location, err := db.Lookup(net.ParseIP(ip))
+		require.NoError(t, err, ip)
+		assert.Equal(t, expected, location, ip)
+	}
+	location, err := db.LookupAddr(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22})
+	require.NoError(t, err)
+	assert.Equal(t, "JP", location.Country)
+
+	_, err = Open(filepath.Join(dir, "no-such-file.mmdb"))
+	assert.Error(t, err)
+}
+
+func TestWriteOverlapping(t *testing.T) {
+	assert.Error(t, Write(&bytes.Buffer{}, map[netip.Prefix]Location{
+		netip.MustParsePrefix("192.0.2.0/24"): {Country: "JP"},
+		netip.MustParsePrefix("192.0.2.0/25"): {Country: "DE"},
+	}))
+}
+
+func TestRules(t *testing.T) {
+	jp := Location{Country: "JP", ASN: 64500}
+	us := Location{Country: "US", ASN: 64501}
+	assert.True(t, Rules{}.Allowed(jp))
+	assert.True(t, Rules{}.IsZero())
+
+	rules := Rules{AllowCountries: []string{"jp"}, AllowASNs: []uint{64501}}
+	assert.True(t, rules.Allowed(jp))
+	assert.True(t, rules.Allowed(us))
+	assert.False(t, rules.Allowed(Location{Country: "DE"}))
+	// Locations not found are allowed
+	assert.True(t, rules.Allowed(Location{}))
+
+	rules = Rules{DenyCountries: []string{"US"}, DenyASNs: []uint{64500}}
+	assert.False(t, rules.Allowed(jp))
+	assert.False(t, rules.Allowed(us))
+	assert.True(t, rules.Allowed(Location{Country: "DE"}))
+}
@@ -0,0 +1,179 @@
+package geoip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"time"
+)
+
+// Write writes the locations of networks in an IPv6 MaxMind DB with IPv4 networks in ::/96,
+// e.g. for tests or a database of private networks opened with others.
+func Write(w io.Writer, networks map[netip.Prefix]Location) error {
+	type node struct {
+		children [2]*node
+		// data is the offset in the data section of a leaf, or -1
+		data  int
+		index int
+	}
+	root := &node{data: -1}
+	var data []byte
+	prefixes := make([]netip.Prefix, 0, len(networks))
+	for prefix := range networks {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return prefixes[i].String() < prefixes[j].String() })
+	for _, prefix := range prefixes {
+		bits := prefix.Bits()
+		addr := prefix.Addr()
+		if addr.Is4() {
+			var ip [16]byte
+			ip4 := addr.As4()
+			copy(ip[12:], ip4[:])
+			addr = netip.AddrFrom16(ip)
+			bits += 96
+		}
+		if bits <= 0 {
+			return fmt.Errorf("network too large: %s", prefix)
+		}
+		ip := addr.As16()
+		n := root
+		for i := 0; i < bits; i++ {
+			if n.data >= 0 {
+				return fmt.Errorf("overlapping network: %s", prefix)
+			}
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if n.children[bit] == nil {
+				n.children[bit] = &node{data: -1}
+			}
+			n = n.children[bit]
+		}
+		if n.data >= 0 || n.children != [2]*node{} {
+			return fmt.Errorf("overlapping network: %s", prefix)
+		}
+		n.data = len(data)
+		data = append(data, encodeLocation(networks[prefix])...)
+	}
+
+	// Internal nodes are numbered in breadth-first order
+	var nodes []*node
+	for queue := []*node{root}; len(queue) > 0; queue = queue[1:] {
+		n := queue[0]
+		n.index = len(nodes)
+		nodes = append(nodes, n)
+		for _, child := range n.children {
+			if child != nil && child.data < 0 {
+				queue = append(queue, child)
+			}
+		}
+	}
+	nodeCount := len(nodes)
+	if nodeCount+16+len(data) >= 1<<24 {
+		return fmt.Errorf("too many networks")
+	}
+	record := func(child *node) int {
+		switch {
+		case child == nil:
+			return nodeCount
+		case child.data >= 0:
+			return nodeCount + 16 + child.data
+		}
+		return child.index
+	}
+	var tree []byte
+	for _, n := range nodes {
+		for _, child := range n.children {
+			r := record(child)
+			tree = append(tree, byte(r>>16), byte(r>>8), byte(r))
+		}
+	}
+	metadata := encodeMap([]string{
+		"binary_format_major_version", "binary_format_minor_version", "build_epoch", "database_type",
+		"description", "ip_version", "languages", "node_count", "record_size",
+	}, [][]byte{
+		encodeUint(2), encodeUint(0), encodeUint(uint64(time.Now().Unix())), encodeString("go-sshd"),
+		encodeMap(nil, nil), encodeUint(6), encodeArray(nil), encodeUint(uint64(nodeCount)), encodeUint(24),
+	})
+	for _, b := range [][]byte{tree, make([]byte, 16), data, []byte("\xAB\xCD\xEFMaxMind.com"), metadata} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeLocation encodes l in the fields of record
+func encodeLocation(l Location) []byte {
+	var keys []string
+	var values [][]byte
+	if l.ASN != 0 {
+		keys = append(keys, "autonomous_system_number")
+		values = append(values, encodeUint(uint64(l.ASN)))
+	}
+	if l.Organization != "" {
+		keys = append(keys, "autonomous_system_organization")
+		values = append(values, encodeString(l.Organization))
+	}
+	if l.Country != "" {
+		keys = append(keys, "country")
+		values = append(values, encodeMap([]string{"iso_code"}, [][]byte{encodeString(l.Country)}))
+	}
+	return encodeMap(keys, values)
+}
+
+// Types of the data section of MaxMind DB
+const (
+	typeString = 2
+	typeUint64 = 9
+	typeMap    = 7
+	typeArray  = 11
+)
+
+// encodeControl encodes the control byte of a field of type and size
+func encodeControl(typ, size int) []byte {
+	var b []byte
+	switch {
+	case size < 29:
+		b = []byte{byte(size)}
+	case size < 29+256:
+		b = []byte{29, byte(size - 29)}
+	default:
+		b = []byte{30, byte((size - 285) >> 8), byte(size - 285)}
+	}
+	if typ > 7 {
+		return append(b[:1], append([]byte{byte(typ - 7)}, b[1:]...)...)
+	}
+	b[0] |= byte(typ << 5)
+	return b
+}
+
+func encodeString(s string) []byte {
+	return append(encodeControl(typeString, len(s)), s...)
+}
+
+func encodeUint(v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 0 && b[0] == 0 {
+		b = b[1:]
+	}
+	return append(encodeControl(typeUint64, len(b)), b...)
+}
+
+func encodeMap(keys []string, values [][]byte) []byte {
+	b := encodeControl(typeMap, len(keys))
+	for i, key := range keys {
+		b = append(b, encodeString(key)...)
+		b = append(b, values[i]...)
+	}
+	return b
+}
+
+func encodeArray(values [][]byte) []byte {
+	b := encodeControl(typeArray, len(values))
+	for _, value := range values {
+		b = append(b, value...)
+	}
+	return b
+}
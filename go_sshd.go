@@ -4,9 +4,18 @@ import (
 	"os"
 
 	"github.com/John-Ao/go-sshd/cmd"
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/rlimit"
+	"github.com/John-Ao/go-sshd/seccomp"
+	"github.com/John-Ao/go-sshd/server/sftpd"
 )
 
 func main() {
+	// The helper of namespaces may start the one of seccomp, which may start the one of resource limits
+	namespaces.RunHelper()
+	seccomp.RunHelper()
+	rlimit.RunHelper()
+	sftpd.RunHelper()
 	if err := cmd.RootCmd().Execute(); err != nil {
 		os.Exit(-1)
 	}
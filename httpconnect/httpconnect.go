@@ -0,0 +1,99 @@
+// Package httpconnect accepts connections tunneled through HTTP CONNECT requests,
+// for clients which can reach the server only via HTTP proxies.
+package httpconnect
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// NewListener returns a listener accepting the tunnels of HTTP CONNECT requests on ln.
+// Other requests are rejected with 405 Method Not Allowed.
+// HTTP/2 extended CONNECT is not supported.
+func NewListener(ln net.Listener) net.Listener {
+	l := &listener{
+		ln:    ln,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	l.server = &http.Server{
+		Handler:           http.HandlerFunc(l.handle),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	go func() {
+		l.server.Serve(ln)
+		l.Close()
+	}()
+	return l
+}
+
+type listener struct {
+	ln        net.Listener
+	server    *http.Server
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *listener) handle(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	// The deadline of reading the request is left on the hijacked connection
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- &bufferedConn{Conn: conn, r: rw.Reader}:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.done)
+		err = l.server.Close()
+	})
+	return err
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// bufferedConn reads data buffered while reading the request first
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
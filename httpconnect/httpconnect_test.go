@@ -0,0 +1,69 @@
+package httpconnect
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listen(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	l := NewListener(ln)
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestConnect(t *testing.T) {
+	l := listen(t)
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	// The data following the request is not lost
+	_, err = conn.Write([]byte("CONNECT localhost:22 HTTP/1.1\r\nHost: localhost:22\r\n\r\nSSH-2.0-test\r\n"))
+	require.NoError(t, err)
+
+	serverConn, err := l.Accept()
+	require.NoError(t, err)
+	defer serverConn.Close()
+	br := bufio.NewReader(conn)
+	res, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	buf := make([]byte, len("SSH-2.0-test\r\n"))
+	_, err = io.ReadFull(serverConn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "SSH-2.0-test\r\n", string(buf))
+	_, err = serverConn.Write([]byte("pong"))
+	require.NoError(t, err)
+	buf = make([]byte, 4)
+	_, err = io.ReadFull(br, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "pong", string(buf))
+}
+
+func TestNotConnect(t *testing.T) {
+	l := listen(t)
+	res, err := http.Get("http://" + l.Addr().String())
+	require.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestClose(t *testing.T) {
+	l := listen(t)
+	errCh := make(chan error)
+	go func() {
+		_, err := l.Accept()
+		errCh <- err
+	}()
+	l.Close()
+	assert.True(t, errors.Is(<-errCh, net.ErrClosed))
+}
@@ -0,0 +1,268 @@
+// Package knock gates connections to the SSH port until clients knock on a sequence of TCP ports or send a signed single packet authorization (SPA),
+// after which their IP addresses are allowed for a while.
+// A userspace server can't drop SYNs, so connections from addresses not allowed are reset at once, which looks like a closed port to connect but not to SYN scans.
+package knock
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTimeout is the time to complete the sequence if Gate.Timeout is 0
+	DefaultTimeout = 10 * time.Second
+	// DefaultAllowDuration is the time addresses are allowed if Gate.AllowDuration is 0
+	DefaultAllowDuration = 30 * time.Second
+	// MaxClockSkew is the difference between the clocks of clients and the server accepted for SPA packets
+	MaxClockSkew = 30 * time.Second
+
+	// MethodSequence and MethodSPA are the methods passed to Gate.OnAllow
+	MethodSequence = "sequence"
+	MethodSPA      = "spa"
+
+	// spaMagic starts SPA packets, which are followed by the Unix time in seconds, a random nonce and HMAC-SHA256 of them
+	spaMagic  = "go-sshd-spa1"
+	nonceSize = 16
+	// SPAPacketSize is the size of SPA packets
+	SPAPacketSize = len(spaMagic) + 8 + nonceSize + sha256.Size
+
+	// pruneInterval is the interval to forget expired progress, allowed addresses and nonces
+	pruneInterval = time.Minute
+)
+
+// Gate allows addresses knocking on Sequence or sending SPA packets signed by Key.
+type Gate struct {
+	// Sequence is the TCP ports to connect to in order
+	Sequence []int
+	// Timeout is the time to complete Sequence from its first port (default: DefaultTimeout)
+	Timeout time.Duration
+	// Key is the HMAC-SHA256 key of SPA packets
+	Key []byte
+	// AllowDuration is the time addresses are allowed after knocking (default: DefaultAllowDuration)
+	AllowDuration time.Duration
+	// OnAllow is called with the address and the method when an address is allowed if not nil, and must not call the Gate
+	OnAllow func(addr netip.Addr, method string)
+
+	mu sync.Mutex
+	// progress is the index of the next port in Sequence and the time of the first knock by address
+	progress map[netip.Addr]*progress
+	// allowed is the expiry of allowed addresses
+	allowed map[netip.Addr]time.Time
+	// nonces are the ones of SPA packets accepted within MaxClockSkew, not to be replayed
+	nonces map[string]time.Time
+	pruned time.Time
+	// now is time.Now, replaced by tests
+	now func() time.Time
+}
+
+type progress struct {
+	next    int
+	started time.Time
+}
+
+// Allowed reports whether addr is allowed to connect. Addresses other than IP ones, e.g. of Unix domain sockets, are always allowed.
+func (g *Gate) Allowed(addr net.Addr) bool {
+	ip, ok := addrIP(addr)
+	if !ok {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	expiry, ok := g.allowed[ip]
+	return ok && g.clock().Before(expiry)
+}
+
+// Knock records a connection from addr to port. A port out of order starts the sequence over.
+func (g *Gate) Knock(addr net.Addr, port int) {
+	ip, ok := addrIP(addr)
+	if !ok || len(g.Sequence) == 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock()
+	g.prune(now)
+	p := g.progress[ip]
+	if p == nil || now.Sub(p.started) > g.timeout() {
+		p = &progress{started: now}
+	}
+	switch {
+	case port == g.Sequence[p.next]:
+		p.next++
+	case port == g.Sequence[0]:
+		p = &progress{next: 1, started: now}
+	default:
+		delete(g.progress, ip)
+		return
+	}
+	if p.next == len(g.Sequence) {
+		delete(g.progress, ip)
+		g.allow(ip, now, MethodSequence)
+		return
+	}
+	if g.progress == nil {
+		g.progress = map[netip.Addr]*progress{}
+	}
+	g.progress[ip] = p
+}
+
+// ServeKnocks records connections accepted on ln as knocks on port and resets them, until ln is closed.
+func (g *Gate) ServeKnocks(ln net.Listener, port int) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		g.Knock(conn.RemoteAddr(), port)
+		Reject(conn)
+	}
+}
+
+// ServeSPA allows the senders of valid SPA packets received on pc until pc is closed. It never replies.
+func (g *Gate) ServeSPA(pc net.PacketConn) {
+	buf := make([]byte, SPAPacketSize+1)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		g.ReceiveSPA(addr, buf[:n])
+	}
+}
+
+// ReceiveSPA allows addr if packet is a valid SPA packet, which is not too old or replayed. It reports whether addr is allowed.
+func (g *Gate) ReceiveSPA(addr net.Addr, packet []byte) bool {
+	ip, ok := addrIP(addr)
+	if !ok || len(g.Key) == 0 || len(packet) != SPAPacketSize || !bytes.HasPrefix(packet, []byte(spaMagic)) {
+		return false
+	}
+	signed := packet[:len(packet)-sha256.Size]
+	if !hmac.Equal(packet[len(signed):], sign(g.Key, signed)) {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.clock()
+	g.prune(now)
+	sent := time.Unix(int64(binary.BigEndian.Uint64(signed[len(spaMagic):])), 0)
+	if sent.Before(now.Add(-MaxClockSkew)) || sent.After(now.Add(MaxClockSkew)) {
+		return false
+	}
+	nonce := string(signed[len(spaMagic)+8:])
+	if _, ok := g.nonces[nonce]; ok {
+		return false
+	}
+	if g.nonces == nil {
+		g.nonces = map[string]time.Time{}
+	}
+	// Forgotten after the packet is too old anyway
+	g.nonces[nonce] = sent.Add(MaxClockSkew)
+	g.allow(ip, now, MethodSPA)
+	return true
+}
+
+// NewSPAPacket returns a new SPA packet signed by key at now.
+func NewSPAPacket(key []byte, now time.Time) ([]byte, error) {
+	packet := []byte(spaMagic)
+	packet = binary.BigEndian.AppendUint64(packet, uint64(now.Unix()))
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	packet = append(packet, nonce...)
+	return append(packet, sign(key, packet)...), nil
+}
+
+// Reject resets conn, which looks like a closed port to the client.
+func Reject(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		tcpConn.SetLinger(0)
+	}
+	conn.Close()
+}
+
+func (g *Gate) allow(ip netip.Addr, now time.Time, method string) {
+	if g.allowed == nil {
+		g.allowed = map[netip.Addr]time.Time{}
+	}
+	duration := g.AllowDuration
+	if duration <= 0 {
+		duration = DefaultAllowDuration
+	}
+	g.allowed[ip] = now.Add(duration)
+	if g.OnAllow != nil {
+		g.OnAllow(ip, method)
+	}
+}
+
+// prune forgets expired entries every pruneInterval not to grow by scans
+func (g *Gate) prune(now time.Time) {
+	if now.Sub(g.pruned) < pruneInterval {
+		return
+	}
+	g.pruned = now
+	for ip, p := range g.progress {
+		if now.Sub(p.started) > g.timeout() {
+			delete(g.progress, ip)
+		}
+	}
+	for ip, expiry := range g.allowed {
+		if !now.Before(expiry) {
+			delete(g.allowed, ip)
+		}
+	}
+	for nonce, expiry := range g.nonces {
+		if now.After(expiry) {
+			delete(g.nonces, nonce)
+		}
+	}
+}
+
+func (g *Gate) timeout() time.Duration {
+	if g.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return g.Timeout
+}
+
+func (g *Gate) clock() time.Time {
+	if g.now != nil {
+		return g.now()
+	}
+	return time.Now()
+}
+
+func sign(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// addrIP returns the IP address of addr without the zone and IPv4-mapping
+func addrIP(addr net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	default:
+		return netip.Addr{}, false
+	}
+	a, ok := netip.AddrFromSlice(ip)
+	return a.Unmap(), ok
+}
@@ -0,0 +1,113 @@
+package knock
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKnock(t *testing.T) {
+	now := time.Now()
+	var allowed []netip.Addr
+	g := &Gate{Sequence: []int{7000, 8000, 7000, 9000}, OnAllow: func(addr netip.Addr, method string) {
+		assert.Equal(t, MethodSequence, method)
+		allowed = append(allowed, addr)
+	}, now: func() time.Time { return now }}
+	client := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 50000}
+	other := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 50000}
+
+	assert.False(t, g.Allowed(client))
+	for _, port := range []int{7000, 8000, 7000} {
+		g.Knock(client, port)
+	}
+	// Knocks of others don't interfere
+	g.Knock(other, 7000)
+	assert.False(t, g.Allowed(client))
+	g.Knock(client, 9000)
+	assert.True(t, g.Allowed(client))
+	assert.True(t, g.Allowed(&net.TCPAddr{IP: net.ParseIP("::ffff:192.0.2.1")}), "IPv4-mapped")
+	assert.False(t, g.Allowed(other))
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.1")}, allowed)
+	now = now.Add(DefaultAllowDuration)
+	assert.False(t, g.Allowed(client))
+
+	// A wrong port starts over
+	for _, port := range []int{7000, 8000, 8000, 7000, 9000} {
+		g.Knock(other, port)
+	}
+	assert.False(t, g.Allowed(other))
+	// The first port starts over too
+	for _, port := range []int{7000, 7000, 8000, 7000, 9000} {
+		g.Knock(other, port)
+	}
+	assert.True(t, g.Allowed(other))
+
+	// The sequence times out
+	for _, port := range []int{7000, 8000, 7000} {
+		g.Knock(client, port)
+	}
+	now = now.Add(DefaultTimeout + time.Second)
+	g.Knock(client, 9000)
+	assert.False(t, g.Allowed(client))
+
+	assert.True(t, g.Allowed(&net.UnixAddr{Name: "/run/sshd.sock", Net: "unix"}))
+}
+
+func TestSPA(t *testing.T) {
+	now := time.Now()
+	key := []byte("secret")
+	g := &Gate{Key: key, now: func() time.Time { return now }}
+	client := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 50000}
+
+	packet, err := NewSPAPacket(key, now)
+	require.NoError(t, err)
+	assert.Len(t, packet, SPAPacketSize)
+	forged, err := NewSPAPacket([]byte("wrong"), now)
+	require.NoError(t, err)
+	assert.False(t, g.ReceiveSPA(client, forged))
+	old, err := NewSPAPacket(key, now.Add(-MaxClockSkew-time.Second))
+	require.NoError(t, err)
+	assert.False(t, g.ReceiveSPA(client, old))
+	assert.False(t, g.ReceiveSPA(client, packet[1:]))
+	assert.False(t, g.Allowed(&net.TCPAddr{IP: client.IP}))
+
+	assert.True(t, g.ReceiveSPA(client, packet))
+	assert.True(t, g.Allowed(&net.TCPAddr{IP: client.IP}))
+	assert.False(t, g.ReceiveSPA(client, packet), "replayed")
+}
+
+func TestServe(t *testing.T) {
+	key := []byte("secret")
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+	g := &Gate{Sequence: []int{1}}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go g.ServeKnocks(ln, 1)
+	// Reset by the gate, during or after connecting
+	if conn, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		assert.Error(t, err)
+		conn.Close()
+	}
+	assert.Eventually(t, func() bool { return g.Allowed(loopback) }, time.Second, 10*time.Millisecond)
+
+	g = &Gate{Key: key}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+	go g.ServeSPA(pc)
+	packet, err := NewSPAPacket(key, time.Now())
+	require.NoError(t, err)
+	conn, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write(packet)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return g.Allowed(loopback) }, time.Second, 10*time.Millisecond)
+}
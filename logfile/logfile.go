@@ -0,0 +1,201 @@
+// Package logfile provides a log file rotated by size and time with retention limits.
+package logfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the suffix of rotated files, e.g. "go-sshd.log.20240102-150405"
+const backupTimeFormat = "20060102-150405"
+
+// Writer writes to a log file and rotates it. Rotated files have the time of rotation as the suffix.
+type Writer struct {
+	Path string
+	// MaxSize is the size in bytes to rotate the file at. No rotation by size if 0.
+	MaxSize int64
+	// Interval rotates the file at multiples of Interval in UTC, e.g. at midnight for 24h. No rotation by time if 0.
+	Interval time.Duration
+	// MaxBackups is the number of rotated files to keep. All are kept if 0.
+	MaxBackups int
+	// MaxAge is the time to keep rotated files since their last write. All are kept if 0.
+	MaxAge time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	now      func() time.Time
+}
+
+// Open opens the file to append logs. Write opens it if not opened.
+func (w *Writer) Open() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.open()
+}
+
+func (w *Writer) open() error {
+	if w.file != nil {
+		return nil
+	}
+	f, err := os.OpenFile(w.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = w.currentTime()
+	return nil
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.open(); err != nil {
+		return 0, err
+	}
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			// Logs are written to the current file rather than lost
+			fmt.Fprintf(os.Stderr, "failed to rotate %s: %v\n", w.Path, err)
+		}
+	}
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// Rotate renames the current file and opens a new one.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.rotate()
+}
+
+func (w *Writer) currentTime() time.Time {
+	if w.now != nil {
+		return w.now()
+	}
+	return time.Now()
+}
+
+func (w *Writer) shouldRotate(n int64) bool {
+	if w.MaxSize > 0 && w.size > 0 && w.size+n > w.MaxSize {
+		return true
+	}
+	if w.Interval > 0 && !w.currentTime().Truncate(w.Interval).Equal(w.openedAt.Truncate(w.Interval)) {
+		return true
+	}
+	return false
+}
+
+func (w *Writer) rotate() error {
+	suffix := w.currentTime().UTC().Format(backupTimeFormat)
+	name := w.Path + "." + suffix
+	// Files may be rotated more than once a second by size
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%s.%s.%d", w.Path, suffix, i)
+	}
+	if err := os.Rename(w.Path, name); err != nil {
+		return err
+	}
+	w.file.Close()
+	w.file = nil
+	if err := w.open(); err != nil {
+		return err
+	}
+	return w.removeOldBackups()
+}
+
+// backup is a rotated file
+type backup struct {
+	path string
+	time time.Time
+	// seq is the number of rotations in the same second
+	seq int
+}
+
+// backups returns rotated files from the newest
+func (w *Writer) backups() ([]backup, error) {
+	matches, err := filepath.Glob(w.Path + ".*")
+	if err != nil {
+		return nil, err
+	}
+	var backups []backup
+	for _, match := range matches {
+		suffix := strings.TrimPrefix(match, w.Path+".")
+		timeString, seqString, hasSeq := strings.Cut(suffix, ".")
+		t, err := time.Parse(backupTimeFormat, timeString)
+		if err != nil {
+			continue
+		}
+		seq := 0
+		if hasSeq {
+			if seq, err = strconv.Atoi(seqString); err != nil {
+				continue
+			}
+		}
+		backups = append(backups, backup{path: match, time: t, seq: seq})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		if !backups[i].time.Equal(backups[j].time) {
+			return backups[i].time.After(backups[j].time)
+		}
+		return backups[i].seq > backups[j].seq
+	})
+	return backups, nil
+}
+
+func (w *Writer) removeOldBackups() error {
+	if w.MaxBackups == 0 && w.MaxAge == 0 {
+		return nil
+	}
+	backups, err := w.backups()
+	if err != nil {
+		return err
+	}
+	for i, b := range backups {
+		remove := w.MaxBackups > 0 && i >= w.MaxBackups
+		if !remove && w.MaxAge > 0 {
+			if info, err := os.Stat(b.path); err == nil && w.currentTime().Sub(info.ModTime()) > w.MaxAge {
+				remove = true
+			}
+		}
+		if remove {
+			if err := os.Remove(b.path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readFile(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(b)
+}
+
+func TestRotateBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-sshd.log")
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	w := &Writer{Path: path, MaxSize: 10, MaxBackups: 2, now: func() time.Time { return now }}
+	defer w.Close()
+	for _, line := range []string{"line1\n", "line2\n", "line3\n", "line4\n"} {
+		_, err := w.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "line4\n", readFile(t, path))
+	// The oldest one is removed
+	assert.NoFileExists(t, path+".20240102-150405")
+	assert.Equal(t, "line2\n", readFile(t, path+".20240102-150405.1"))
+	assert.Equal(t, "line3\n", readFile(t, path+".20240102-150405.2"))
+}
+
+func TestRotateByInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go-sshd.log")
+	now := time.Date(2024, 1, 2, 23, 59, 0, 0, time.UTC)
+	w := &Writer{Path: path, Interval: 24 * time.Hour, MaxAge: time.Hour, now: func() time.Time { return now }}
+	defer w.Close()
+	require.NoError(t, w.Open())
+	_, err := w.Write([]byte("day1\n"))
+	require.NoError(t, err)
+	now = now.Add(2 * time.Minute)
+	_, err = w.Write([]byte("day2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, "day2\n", readFile(t, path))
+	assert.Equal(t, "day1\n", readFile(t, path+".20240103-000100"))
+
+	// Rotated files older than MaxAge are removed
+	old := path + ".20240101-000000"
+	require.NoError(t, os.WriteFile(old, []byte("old\n"), 0644))
+	require.NoError(t, os.Chtimes(old, now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+	require.NoError(t, w.Rotate())
+	assert.NoFileExists(t, old)
+	assert.FileExists(t, path+".20240103-000100.1")
+}
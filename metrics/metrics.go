@@ -0,0 +1,144 @@
+// Package metrics exports Stats of servers in the Prometheus text format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Handler returns the HTTP handler of the metrics of the stats returned by stats, e.g. the sum of all servers.
+func Handler(stats func() server.Stats) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		Write(w, stats())
+	})
+}
+
+// Write writes stats in the Prometheus text format.
+func Write(w io.Writer, stats server.Stats) error {
+	bw := bufio.NewWriter(w)
+	m := &writer{w: bw}
+	m.metric("go_sshd_connections_total", "counter", "Total number of SSH connections.")
+	m.sample("", nil, float64(stats.Connections))
+	m.metric("go_sshd_active_connections", "gauge", "Number of active SSH connections.")
+	m.sample("", nil, float64(stats.ActiveConnections))
+
+	m.metric("go_sshd_auth_attempts_total", "counter", "Total number of authentication attempts by method and result.")
+	var results []server.AuthResult
+	for result := range stats.AuthAttempts {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Method != results[j].Method {
+			return results[i].Method < results[j].Method
+		}
+		return !results[i].Success && results[j].Success
+	})
+	for _, result := range results {
+		outcome := "failure"
+		if result.Success {
+			outcome = "success"
+		}
+		m.sample("", []string{"method", result.Method, "result", outcome}, float64(stats.AuthAttempts[result]))
+	}
+
+	m.metric("go_sshd_sessions_total", "counter", "Total number of sessions.")
+	m.sample("", nil, float64(stats.Sessions))
+	m.metric("go_sshd_active_sessions", "gauge", "Number of active sessions.")
+	m.sample("", nil, float64(stats.ActiveSessions))
+	m.metric("go_sshd_active_forwards", "gauge", "Number of active remote forwarding listeners and local forwarding channels.")
+	m.sample("", nil, float64(stats.ActiveForwards))
+	m.metric("go_sshd_active_goroutines", "gauge", "Number of goroutines serving SSH connections.")
+	m.sample("", nil, float64(stats.ActiveGoroutines))
+	m.metric("go_sshd_active_processes", "gauge", "Number of child processes of SSH connections.")
+	m.sample("", nil, float64(stats.ActiveProcesses))
+	m.metric("go_sshd_shed_total", "counter", "Total number of connections and channels shed by the worker pools.")
+	m.sample("", []string{"kind", "connection"}, float64(stats.ShedConnections))
+	m.sample("", []string{"kind", "channel"}, float64(stats.ShedChannels))
+	m.metric("go_sshd_rate_limited_channels_total", "counter", "Total number of channels rejected by the channel rate limits.")
+	m.sample("", nil, float64(stats.RateLimitedChannels))
+	m.metric("go_sshd_stalled_relays_total", "counter", "Total number of relays closed by the stall timeout.")
+	m.sample("", nil, float64(stats.StalledRelays))
+
+	m.metric("go_sshd_channel_bytes_total", "counter", "Total number of bytes through channels by direction from the server.")
+	m.sample("", []string{"direction", "received"}, float64(stats.BytesReceived))
+	m.sample("", []string{"direction", "sent"}, float64(stats.BytesSent))
+	m.metric("go_sshd_forwarded_bytes_total", "counter", "Total number of bytes through forwarding channels by direction from the server.")
+	m.sample("", []string{"direction", "received"}, float64(stats.ForwardBytesReceived))
+	m.sample("", []string{"direction", "sent"}, float64(stats.ForwardBytesSent))
+
+	m.metric("go_sshd_sftp_operations_total", "counter", "Total number of SFTP requests by operation.")
+	var operations []string
+	for operation := range stats.SftpOperations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	for _, operation := range operations {
+		m.sample("", []string{"operation", operation}, float64(stats.SftpOperations[operation]))
+	}
+
+	h := stats.HandshakeDurations
+	m.metric("go_sshd_handshake_duration_seconds", "histogram", "Durations of successful SSH handshakes including authentication.")
+	for i, bucket := range h.Buckets {
+		m.sample("_bucket", []string{"le", formatFloat(bucket.Seconds())}, float64(h.Counts[i]))
+	}
+	m.sample("_bucket", []string{"le", "+Inf"}, float64(h.Count))
+	m.sample("_sum", nil, h.Sum.Seconds())
+	m.sample("_count", nil, float64(h.Count))
+	if m.err != nil {
+		return m.err
+	}
+	return bw.Flush()
+}
+
+// writer writes samples of the last metric
+type writer struct {
+	w    io.Writer
+	name string
+	err  error
+}
+
+func (m *writer) metric(name, metricType, help string) {
+	m.name = name
+	m.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// sample writes a sample of the metric with suffix (e.g. "_bucket") and labels of name and value pairs
+func (m *writer) sample(suffix string, labels []string, value float64) {
+	var b strings.Builder
+	b.WriteString(m.name + suffix)
+	if len(labels) != 0 {
+		b.WriteString("{")
+		for i := 0; i < len(labels); i += 2 {
+			if i != 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(labels[i] + `="` + labelValueEscaper.Replace(labels[i+1]) + `"`)
+		}
+		b.WriteString("}")
+	}
+	m.printf("%s %s\n", b.String(), formatFloat(value))
+}
+
+func (m *writer) printf(format string, args ...any) {
+	if m.err != nil {
+		return
+	}
+	_, m.err = fmt.Fprintf(m.w, format, args...)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	stats := server.Stats{
+		ActiveConnections: 1,
+		Connections:       3,
+		AuthAttempts: map[server.AuthResult]uint64{
+			{Method: "publickey", Success: true}:  2,
+			{Method: "password", Success: false}:  1,
+			{Method: "publickey", Success: false}: 4,
+		},
+		ForwardBytesSent: 1024,
+		ShedChannels:     5,
+		SftpOperations:   map[string]uint64{"write": 2, "open": 1},
+		HandshakeDurations: server.Histogram{
+			Buckets: []time.Duration{10 * time.Millisecond, time.Second},
+			Counts:  []uint64{1, 2},
+			Count:   3,
+			Sum:     2500 * time.Millisecond,
+		},
+	}
+	handler := Handler(func() server.Stats { return stats })
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+	body := recorder.Body.String()
+	for _, line := range []string{
+		"# TYPE go_sshd_connections_total counter\ngo_sshd_connections_total 3\n",
+		"go_sshd_active_connections 1\n",
+		`go_sshd_auth_attempts_total{method="password",result="failure"} 1
+go_sshd_auth_attempts_total{method="publickey",result="failure"} 4
+go_sshd_auth_attempts_total{method="publickey",result="success"} 2
+`,
+		`go_sshd_forwarded_bytes_total{direction="sent"} 1024` + "\n",
+		`go_sshd_shed_total{kind="connection"} 0
+go_sshd_shed_total{kind="channel"} 5
+`,
+		`go_sshd_sftp_operations_total{operation="open"} 1
+go_sshd_sftp_operations_total{operation="write"} 2
+`,
+		`# TYPE go_sshd_handshake_duration_seconds histogram
+go_sshd_handshake_duration_seconds_bucket{le="0.01"} 1
+go_sshd_handshake_duration_seconds_bucket{le="1"} 2
+go_sshd_handshake_duration_seconds_bucket{le="+Inf"} 3
+go_sshd_handshake_duration_seconds_sum 2.5
+go_sshd_handshake_duration_seconds_count 3
+`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
@@ -0,0 +1,128 @@
+// Package namespaces runs commands in new Linux namespaces for isolation lighter than containers.
+// Some namespaces need setting up in them before the command runs, so Command makes the executable start again as a helper,
+// which sets them up and executes the command in place of itself.
+package namespaces
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// envNamespaces is the namespaces for the helper started by Command
+const envNamespaces = "GO_SSHD_NAMESPACES"
+
+// Network namespaces other than the one of this process
+const (
+	// NetworkNone is a new network namespace with only the loopback interface
+	NetworkNone = "none"
+)
+
+// Namespaces are the namespaces to run a command in. The zero value is the ones of this process.
+type Namespaces struct {
+	// Mount is a new mount namespace in which mounts are not propagated to this process
+	Mount bool
+	// PID is a new PID namespace with /proc of it in a new mount namespace. The command is PID 1.
+	PID bool
+	// IPC is a new IPC namespace
+	IPC bool
+	// UTS is a new UTS namespace, so the host name can be changed
+	UTS bool
+	// Network is NetworkNone, or the name of a network namespace in /var/run/netns or its path to join if not empty
+	Network string
+}
+
+// Parse parses comma-separated namespaces like "mount,pid,ipc,uts,net=none" or "pid,net=vpn".
+func Parse(s string) (Namespaces, error) {
+	var n Namespaces
+	if s == "" {
+		return n, nil
+	}
+	for _, name := range strings.Split(s, ",") {
+		switch name {
+		case "mount":
+			n.Mount = true
+		case "pid":
+			n.PID = true
+		case "ipc":
+			n.IPC = true
+		case "uts":
+			n.UTS = true
+		default:
+			network, ok := strings.CutPrefix(name, "net=")
+			if !ok || network == "" {
+				return Namespaces{}, fmt.Errorf("unknown namespace: %s", name)
+			}
+			n.Network = network
+		}
+	}
+	return n, nil
+}
+
+// String returns n in the form of Parse.
+func (n Namespaces) String() string {
+	var names []string
+	for _, namespace := range []struct {
+		name string
+		new  bool
+	}{{"mount", n.Mount}, {"pid", n.PID}, {"ipc", n.IPC}, {"uts", n.UTS}} {
+		if namespace.new {
+			names = append(names, namespace.name)
+		}
+	}
+	if n.Network != "" {
+		names = append(names, "net="+n.Network)
+	}
+	return strings.Join(names, ",")
+}
+
+// IsZero reports whether n is the namespaces of this process.
+func (n Namespaces) IsZero() bool {
+	return n == Namespaces{}
+}
+
+// Command makes cmd run in the namespaces of n by starting the executable as a helper, which requires root.
+// The helper must call RunHelper first in main. Path, Args and SysProcAttr of cmd are changed.
+func Command(cmd *exec.Cmd, n Namespaces) error {
+	if n.IsZero() {
+		return nil
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	if err := setCloneflags(cmd, n); err != nil {
+		return err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envNamespaces+"="+n.String())
+	cmd.Args = append([]string{executable, cmd.Path}, cmd.Args...)
+	cmd.Path = executable
+	return nil
+}
+
+// RunHelper sets up the namespaces and executes the command if this process was started by Command, and does nothing otherwise.
+// It exits with 126 like shells when the command can not be executed.
+func RunHelper() {
+	s, ok := os.LookupEnv(envNamespaces)
+	if !ok {
+		return
+	}
+	os.Unsetenv(envNamespaces)
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "namespaces: no command")
+		os.Exit(126)
+	}
+	n, err := Parse(s)
+	if err == nil {
+		err = execIn(n, os.Args[1], os.Args[2:])
+	}
+	fmt.Fprintf(os.Stderr, "namespaces: %s: %v\n", os.Args[1], err)
+	os.Exit(126)
+}
@@ -0,0 +1,102 @@
+package namespaces
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// networkPath returns the path of the network namespace to join
+func networkPath(network string) string {
+	if filepath.IsAbs(network) {
+		return network
+	}
+	return filepath.Join("/var/run/netns", network)
+}
+
+func setCloneflags(cmd *exec.Cmd, n Namespaces) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if n.Mount || n.PID {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWNS
+	}
+	if n.PID {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWPID
+	}
+	if n.IPC {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWIPC
+	}
+	if n.UTS {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWUTS
+	}
+	switch n.Network {
+	case "":
+	case NetworkNone:
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWNET
+	default:
+		if _, err := os.Stat(networkPath(n.Network)); err != nil {
+			return fmt.Errorf("network namespace: %w", err)
+		}
+	}
+	return nil
+}
+
+// execIn sets up the namespaces of n this process was started in and executes the program at path with args
+func execIn(n Namespaces, path string, args []string) error {
+	// Namespaces joined by setns(2) are of the thread
+	runtime.LockOSThread()
+	if n.Mount || n.PID {
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			return os.NewSyscallError("mount", err)
+		}
+	}
+	if n.PID {
+		if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NOEXEC|unix.MS_NODEV, ""); err != nil {
+			return fmt.Errorf("failed to mount /proc: %w", err)
+		}
+	}
+	switch n.Network {
+	case "":
+	case NetworkNone:
+		if err := setLoopbackUp(); err != nil {
+			return fmt.Errorf("failed to set up loopback: %w", err)
+		}
+	default:
+		fd, err := unix.Open(networkPath(n.Network), unix.O_RDONLY|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return fmt.Errorf("network namespace: %w", err)
+		}
+		if err := unix.Setns(fd, unix.CLONE_NEWNET); err != nil {
+			return os.NewSyscallError("setns", err)
+		}
+		unix.Close(fd)
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// setLoopbackUp sets the loopback interface up, which is down in new network namespaces
+func setLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return os.NewSyscallError("socket", err)
+	}
+	defer unix.Close(fd)
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq); err != nil {
+		return os.NewSyscallError("ioctl", err)
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package namespaces
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setCloneflags(cmd *exec.Cmd, n Namespaces) error {
+	return fmt.Errorf("namespaces are not supported on this platform")
+}
+
+func execIn(n Namespaces, path string, args []string) error {
+	return fmt.Errorf("namespaces are not supported on this platform")
+}
@@ -0,0 +1,58 @@
+package namespaces
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestParse(t *testing.T) {
+	n, err := Parse("mount,pid,ipc,uts,net=none")
+	require.NoError(t, err)
+	assert.Equal(t, Namespaces{Mount: true, PID: true, IPC: true, UTS: true, Network: NetworkNone}, n)
+	assert.Equal(t, "mount,pid,ipc,uts,net=none", n.String())
+	n, err = Parse("net=/var/run/netns/vpn")
+	require.NoError(t, err)
+	assert.Equal(t, Namespaces{Network: "/var/run/netns/vpn"}, n)
+	for _, invalid := range []string{"user", "net", "net=", "pid,"} {
+		_, err := Parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if runtime.GOOS != "linux" || os.Geteuid() != 0 {
+		t.Skip("namespaces need root on Linux")
+	}
+	if err := exec.Command("unshare", "--pid", "--mount", "--net", "--fork", "true").Run(); err != nil {
+		t.Skip("namespaces can not be created:", err)
+	}
+
+	cmd := exec.Command("sh", "-c", "echo $$; cat /proc/1/comm; tail -n +3 /proc/net/dev | cut -d: -f1")
+	require.NoError(t, Command(cmd, Namespaces{PID: true, Network: NetworkNone}))
+	output, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Equal(t, []string{"1", "sh", "lo"}, strings.Fields(string(output)))
+
+	network := fmt.Sprintf("/proc/%d/ns/net", os.Getpid())
+	hostNetwork, err := os.Readlink(network)
+	require.NoError(t, err)
+	cmd = exec.Command("readlink", "/proc/self/ns/net")
+	require.NoError(t, Command(cmd, Namespaces{Network: network}))
+	output, err = cmd.CombinedOutput()
+	require.NoError(t, err, string(output))
+	assert.Equal(t, hostNetwork+"\n", string(output))
+
+	assert.Error(t, Command(exec.Command("true"), Namespaces{Network: "no-such-namespace"}))
+}
@@ -0,0 +1,133 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Hours are time ranges on days of the week in local time or a time zone, e.g. business hours.
+type Hours struct {
+	// Days are the days of the week indexed by time.Weekday
+	Days [7]bool
+	// Start and End are the times of day. The range is overnight if End is before Start.
+	Start time.Duration
+	End   time.Duration
+	// Location is the time zone of the hours, or nil for the time zone of times given
+	Location *time.Location
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseHours parses "[DAYS ]HH:MM-HH:MM[ ZONE]" such as "Mon-Fri 09:00-18:00" and "Sat,Sun 10:00-12:00 Asia/Tokyo".
+// DAYS are comma-separated days or ranges of days, which are all days if omitted.
+// ZONE is a name of the IANA Time Zone database, which is the time zone of times given if omitted.
+func ParseHours(s string) (*Hours, error) {
+	h := &Hours{}
+	fields := strings.Fields(s)
+	if len(fields) > 1 && !strings.Contains(fields[len(fields)-1], ":") {
+		location, err := time.LoadLocation(fields[len(fields)-1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+		h.Location = location
+		fields = fields[:len(fields)-1]
+	}
+	var days, times string
+	switch len(fields) {
+	case 1:
+		times = fields[0]
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return nil, fmt.Errorf("invalid hours: %s", s)
+	}
+	if days == "" {
+		h.Days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, r := range strings.Split(days, ",") {
+		if r == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(r, "-")
+		if !isRange {
+			last = first
+		}
+		start, ok1 := weekdays[strings.ToLower(first)]
+		end, ok2 := weekdays[strings.ToLower(last)]
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("invalid days: %s", r)
+		}
+		for d := start; ; d = (d + 1) % 7 {
+			h.Days[d] = true
+			if d == end {
+				break
+			}
+		}
+	}
+	start, end, found := strings.Cut(strings.TrimSpace(times), "-")
+	if !found {
+		return nil, fmt.Errorf("invalid hours: %s", s)
+	}
+	var err error
+	if h.Start, err = parseTimeOfDay(start); err != nil {
+		return nil, err
+	}
+	if h.End, err = parseTimeOfDay(end); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * time.Hour, nil
+		}
+		return 0, fmt.Errorf("invalid time: %s", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether t is in the hours. Overnight ranges belong to the day they start.
+func (h *Hours) Contains(t time.Time) bool {
+	if h.Location != nil {
+		t = t.In(h.Location)
+	}
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if h.Start <= h.End {
+		return h.Days[t.Weekday()] && h.Start <= offset && offset < h.End
+	}
+	if offset >= h.Start {
+		return h.Days[t.Weekday()]
+	}
+	return offset < h.End && h.Days[(t.Weekday()+6)%7]
+}
+
+// EndAfter returns when the hours containing t end, following ranges continuing on the next days.
+// It returns the zero time if t is not in the hours or they never end, e.g. "00:00-24:00".
+func (h *Hours) EndAfter(t time.Time) time.Time {
+	if !h.Contains(t) {
+		return time.Time{}
+	}
+	if h.Location != nil {
+		t = t.In(h.Location)
+	}
+	// A range ends at End of the day it starts or the next day
+	day := t
+	if h.Start > h.End && time.Duration(t.Hour())*time.Hour+time.Duration(t.Minute())*time.Minute >= h.Start {
+		day = t.AddDate(0, 0, 1)
+	}
+	for i := 0; i < 8; i++ {
+		end := time.Date(day.Year(), day.Month(), day.Day(), int(h.End/time.Hour), int(h.End%time.Hour/time.Minute), 0, 0, day.Location())
+		if !h.Contains(end) {
+			return end
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	return time.Time{}
+}
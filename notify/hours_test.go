@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHours(t *testing.T) {
+	h, err := ParseHours("Mon-Fri 09:00-18:00")
+	require.NoError(t, err)
+	assert.Equal(t, [7]bool{false, true, true, true, true, true, false}, h.Days)
+	assert.Equal(t, 9*time.Hour, h.Start)
+	assert.Equal(t, 18*time.Hour, h.End)
+	// 2024-01-01 is Monday
+	assert.True(t, h.Contains(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+	assert.False(t, h.Contains(time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)))
+	assert.False(t, h.Contains(time.Date(2024, 1, 6, 12, 0, 0, 0, time.UTC)))
+
+	h, err = ParseHours("Fri-Sun,Tue 22:00-06:00")
+	require.NoError(t, err)
+	assert.Equal(t, [7]bool{true, false, true, false, false, true, true}, h.Days)
+	assert.True(t, h.Contains(time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC)))
+	// Monday morning belongs to Sunday night
+	assert.True(t, h.Contains(time.Date(2024, 1, 8, 5, 59, 0, 0, time.UTC)))
+	assert.False(t, h.Contains(time.Date(2024, 1, 8, 23, 0, 0, 0, time.UTC)))
+
+	h, err = ParseHours("00:00-24:00")
+	require.NoError(t, err)
+	assert.True(t, h.Contains(time.Date(2024, 1, 6, 23, 59, 59, 0, time.UTC)))
+
+	h, err = ParseHours("Mon-Fri 09:00-18:00 Asia/Tokyo")
+	require.NoError(t, err)
+	// 09:00 in Tokyo is 00:00 in UTC
+	assert.True(t, h.Contains(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.False(t, h.Contains(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+
+	for _, s := range []string{"", "Mon-Fri", "Mon-Fry 09:00-18:00", "9-18", "09:00-25:00", "09:00-18:00 Mars/Olympus", "Mon Fri 09:00-18:00"} {
+		_, err := ParseHours(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestHoursEndAfter(t *testing.T) {
+	h, err := ParseHours("Mon-Fri 09:00-18:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC), h.EndAfter(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	assert.True(t, h.EndAfter(time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC)).IsZero())
+
+	h, err = ParseHours("Fri-Sun 22:00-06:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 6, 6, 0, 0, 0, time.UTC), h.EndAfter(time.Date(2024, 1, 5, 23, 0, 0, 0, time.UTC)))
+	assert.Equal(t, time.Date(2024, 1, 6, 6, 0, 0, 0, time.UTC), h.EndAfter(time.Date(2024, 1, 6, 5, 0, 0, 0, time.UTC)))
+
+	// Ranges continuing on the next days end together
+	h, err = ParseHours("Mon-Fri 00:00-24:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC), h.EndAfter(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)))
+	h, err = ParseHours("00:00-24:00")
+	require.NoError(t, err)
+	assert.True(t, h.EndAfter(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)).IsZero())
+
+	h, err = ParseHours("Mon-Fri 09:00-18:00 Asia/Tokyo")
+	require.NoError(t, err)
+	assert.True(t, h.EndAfter(time.Date(2024, 1, 1, 3, 0, 0, 0, time.UTC)).Equal(time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)))
+}
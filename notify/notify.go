@@ -0,0 +1,224 @@
+// Package notify sends notifications of logins matching criteria, such as logins from new addresses or outside business hours,
+// by email, Slack and Matrix with templated messages.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/exp/slog"
+)
+
+// Reasons of notifications
+const (
+	ReasonNewAddress   = "new address"
+	ReasonUser         = "watched user"
+	ReasonOutsideHours = "outside hours"
+)
+
+// DefaultTemplate is the default template of messages. The first line is the subject of emails.
+const DefaultTemplate = `{{.User}} logged in to {{.Hostname}} from {{.Address}}
+User: {{.User}}
+Address: {{.Address}}
+Server: {{.Hostname}}{{if .Server}} ({{.Server}}){{end}}
+Time: {{.Time.Format "2006-01-02 15:04:05 MST"}}
+Reasons: {{join .Reasons ", "}}`
+
+// Login is the data of templates.
+type Login struct {
+	Time time.Time
+	// Server is the name of the server in the config file
+	Server string
+	// Hostname is the host name of the machine
+	Hostname string
+	ConnID   string
+	User     string
+	// Address is the IP address of the client
+	Address string
+	// Reasons are why the login is notified, e.g. ReasonNewAddress
+	Reasons []string
+}
+
+// NewTemplate parses text as a template of Login with the function join of strings.Join.
+func NewTemplate(text string) (*template.Template, error) {
+	return template.New("notify").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+}
+
+// Notifier sends notifications of logins matching any of the criteria.
+type Notifier struct {
+	Senders []Sender
+	// NewAddress notifies logins from addresses not seen for the user before
+	NewAddress bool
+	// KnownAddressesFile keeps the addresses seen for each user across restarts for NewAddress if not empty
+	KnownAddressesFile string
+	// Users notifies logins of the users, e.g. root-equivalent ones
+	Users []string
+	// Hours notifies logins outside the hours if not nil
+	Hours *Hours
+	// Template is of messages (default: DefaultTemplate)
+	Template *template.Template
+	// Timeout is the time limit of sending a notification (default: 30s)
+	Timeout time.Duration
+	Logger  *slog.Logger
+
+	startOnce sync.Once
+	queue     chan Login
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	// known are the addresses seen by user
+	known    map[string][]string
+	hostname string
+}
+
+// LoadKnownAddresses loads KnownAddressesFile. It does nothing if the file doesn't exist.
+func (n *Notifier) LoadKnownAddresses() error {
+	n.start()
+	if n.KnownAddressesFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(n.KnownAddressesFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	known := map[string][]string{}
+	if err := json.Unmarshal(b, &known); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", n.KnownAddressesFile, err)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.known = known
+	return nil
+}
+
+func (n *Notifier) start() {
+	n.startOnce.Do(func() {
+		n.queue = make(chan Login, 256)
+		n.done = make(chan struct{})
+		n.known = map[string][]string{}
+		n.hostname, _ = os.Hostname()
+		go n.run()
+	})
+}
+
+// Notify sends the notification of event of the server named serverName if it is a login matching the criteria. It doesn't block.
+func (n *Notifier) Notify(serverName string, event server.Event) {
+	n.start()
+	if event.Type != server.EventConnectionOpened || event.User == "" {
+		return
+	}
+	address, _, err := net.SplitHostPort(event.RemoteAddr)
+	if err != nil {
+		address = event.RemoteAddr
+	}
+	login := Login{Time: event.Time, Server: serverName, Hostname: n.hostname, ConnID: event.ConnID, User: event.User, Address: address}
+	if n.NewAddress && n.seen(event.User, address) {
+		login.Reasons = append(login.Reasons, ReasonNewAddress)
+	}
+	if slices.Contains(n.Users, event.User) {
+		login.Reasons = append(login.Reasons, ReasonUser)
+	}
+	if n.Hours != nil && !n.Hours.Contains(event.Time.Local()) {
+		login.Reasons = append(login.Reasons, ReasonOutsideHours)
+	}
+	if len(login.Reasons) == 0 {
+		return
+	}
+	select {
+	case n.queue <- login:
+	default:
+		n.logger().Warn("login notification dropped because the queue is full", "user", login.User)
+	}
+}
+
+// seen records address for user and returns true if it is new
+func (n *Notifier) seen(user, address string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if slices.Contains(n.known[user], address) {
+		return false
+	}
+	n.known[user] = append(n.known[user], address)
+	if n.KnownAddressesFile != "" {
+		if err := n.saveKnownAddresses(); err != nil {
+			n.logger().Error("failed to save known addresses", "err", err)
+		}
+	}
+	return true
+}
+
+// saveKnownAddresses replaces KnownAddressesFile at once not to be broken by crashes
+func (n *Notifier) saveKnownAddresses() error {
+	b, err := json.MarshalIndent(n.known, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := n.KnownAddressesFile + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, n.KnownAddressesFile)
+}
+
+// Close stops accepting notifications and waits for queued ones to be sent.
+func (n *Notifier) Close() {
+	n.start()
+	n.closeOnce.Do(func() {
+		close(n.queue)
+		<-n.done
+	})
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for login := range n.queue {
+		n.send(login)
+	}
+}
+
+func (n *Notifier) send(login Login) {
+	tmpl := n.Template
+	if tmpl == nil {
+		tmpl = template.Must(NewTemplate(DefaultTemplate))
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, login); err != nil {
+		n.logger().Error("failed to execute the template of login notifications", "err", err)
+		return
+	}
+	message := buf.String()
+	subject, _, _ := strings.Cut(message, "\n")
+	timeout := n.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	for _, sender := range n.Senders {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		if err := sender.Send(ctx, subject, message); err != nil {
+			n.logger().Error("failed to send login notification", "user", login.User, "err", err)
+		}
+		cancel()
+	}
+}
+
+func (n *Notifier) logger() *slog.Logger {
+	if n.Logger == nil {
+		return slog.Default()
+	}
+	return n.Logger
+}
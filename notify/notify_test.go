@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingSender struct {
+	mu       sync.Mutex
+	subjects []string
+	messages []string
+}
+
+func (s *recordingSender) Send(ctx context.Context, subject, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subjects = append(s.subjects, subject)
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+func login(user, address string, t time.Time) server.Event {
+	return server.Event{Type: server.EventConnectionOpened, Time: t, User: user, RemoteAddr: net.JoinHostPort(address, "50000")}
+}
+
+func TestNotifier(t *testing.T) {
+	knownFile := filepath.Join(t.TempDir(), "known.json")
+	require.NoError(t, os.WriteFile(knownFile, []byte(`{"john": ["192.0.2.1"]}`), 0600))
+	hours, err := ParseHours("Mon-Fri 09:00-18:00")
+	require.NoError(t, err)
+	tmpl, err := NewTemplate(`{{.User}}@{{.Address}} on {{.Server}}: {{join .Reasons ", "}}`)
+	require.NoError(t, err)
+	sender := &recordingSender{}
+	n := &Notifier{Senders: []Sender{sender}, NewAddress: true, KnownAddressesFile: knownFile, Users: []string{"root"}, Hours: hours, Template: tmpl}
+	require.NoError(t, n.LoadKnownAddresses())
+	// 2024-01-01 is Monday
+	work := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	n.Notify("main", login("john", "192.0.2.1", work))
+	n.Notify("main", login("john", "192.0.2.2", work))
+	n.Notify("main", login("john", "192.0.2.2", work))
+	n.Notify("main", login("root", "192.0.2.1", work.Add(10*time.Hour)))
+	n.Notify("main", server.Event{Type: server.EventAuth, Time: work.Add(10 * time.Hour), User: "john"})
+	n.Close()
+
+	assert.Equal(t, []string{
+		"john@192.0.2.2 on main: new address",
+		"root@192.0.2.1 on main: new address, watched user, outside hours",
+	}, sender.messages)
+	var known map[string][]string
+	b, err := os.ReadFile(knownFile)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &known))
+	assert.Equal(t, map[string][]string{"john": {"192.0.2.1", "192.0.2.2"}, "root": {"192.0.2.1"}}, known)
+}
+
+func TestDefaultTemplate(t *testing.T) {
+	sender := &recordingSender{}
+	n := &Notifier{Senders: []Sender{sender}, Users: []string{"root"}}
+	n.Notify("", login("root", "2001:db8::1", time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)))
+	n.Close()
+	hostname, _ := os.Hostname()
+	require.Len(t, sender.subjects, 1)
+	assert.Equal(t, "root logged in to "+hostname+" from 2001:db8::1", sender.subjects[0])
+	assert.Contains(t, sender.messages[0], "\nTime: 2024-01-01 12:00:00 UTC\nReasons: watched user")
+}
+
+func TestSlackAndMatrix(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, fmt.Sprintf("%s %s %s %s", r.Method, r.URL.EscapedPath(), r.Header.Get("Authorization"), body))
+		if strings.Contains(r.URL.Path, "fail") {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+	ctx := context.Background()
+	require.NoError(t, (&Slack{URL: ts.URL + "/hook"}).Send(ctx, "subject", "hello"))
+	assert.Error(t, (&Slack{URL: ts.URL + "/fail"}).Send(ctx, "subject", "hello"))
+	require.NoError(t, (&Matrix{Homeserver: ts.URL + "/", Room: "!room:example.com", Token: "secret"}).Send(ctx, "subject", "hello"))
+	require.Len(t, requests, 3)
+	assert.Equal(t, `POST /hook  {"text":"hello"}`, requests[0])
+	assert.Regexp(t, `^PUT /_matrix/client/v3/rooms/%21room:example.com/send/m.room.message/[0-9a-f-]{36} Bearer secret \{"body":"hello","msgtype":"m.text"\}$`, requests[2])
+}
+
+func TestSMTP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var data strings.Builder
+		inData := false
+		fmt.Fprint(conn, "220 localhost ESMTP\r\n")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch {
+			case inData && line == ".\r\n":
+				inData = false
+				received <- data.String()
+				fmt.Fprint(conn, "250 OK\r\n")
+			case inData:
+				data.WriteString(line)
+			case strings.HasPrefix(line, "EHLO"):
+				fmt.Fprint(conn, "250-localhost\r\n250 8BITMIME\r\n")
+			case strings.HasPrefix(line, "DATA"):
+				inData = true
+				fmt.Fprint(conn, "354 Go ahead\r\n")
+			case strings.HasPrefix(line, "QUIT"):
+				fmt.Fprint(conn, "221 Bye\r\n")
+				return
+			default:
+				fmt.Fprint(conn, "250 OK\r\n")
+			}
+		}
+	}()
+	s := &SMTP{Addr: ln.Addr().String(), From: "go-sshd@example.com", To: []string{"admin@example.com", "ops@example.com"}}
+	require.NoError(t, s.Send(context.Background(), "john logged in\r\nBcc: x", "line1\nline2"))
+	data := <-received
+	assert.Contains(t, data, "From: go-sshd@example.com\r\nTo: admin@example.com, ops@example.com\r\nSubject: john logged in  Bcc: x\r\n")
+	assert.Contains(t, data, "\r\n\r\nline1\r\nline2\r\n")
+}
@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Sender sends a notification.
+type Sender interface {
+	Send(ctx context.Context, subject, message string) error
+}
+
+// Slack posts notifications to an incoming webhook of Slack, or of compatible ones accepting {"text": message}
+// such as Mattermost and the Matrix bridge hookshot.
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *Slack) Send(ctx context.Context, subject, message string) error {
+	body, err := json.Marshal(map[string]string{"text": message})
+	if err != nil {
+		return err
+	}
+	return do(ctx, s.Client, http.MethodPost, s.URL, "", body)
+}
+
+// Matrix sends notifications as messages to a room of Matrix by the client-server API.
+type Matrix struct {
+	// Homeserver is the base URL of the homeserver, e.g. "https://matrix.example.com"
+	Homeserver string
+	// Room is the room ID, e.g. "!abc:example.com"
+	Room string
+	// Token is the access token of the user sending messages
+	Token  string
+	Client *http.Client
+}
+
+func (m *Matrix) Send(ctx context.Context, subject, message string) error {
+	body, err := json.Marshal(map[string]string{"msgtype": "m.text", "body": message})
+	if err != nil {
+		return err
+	}
+	u := strings.TrimSuffix(m.Homeserver, "/") + "/_matrix/client/v3/rooms/" + url.PathEscape(m.Room) +
+		"/send/m.room.message/" + uuid.New().String()
+	return do(ctx, m.Client, http.MethodPut, u, m.Token, body)
+}
+
+// do requests url with body in JSON and the bearer token if not empty
+func do(ctx context.Context, client *http.Client, method, url, token string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status: %s", res.Status)
+	}
+	return nil
+}
+
+// SMTP sends notifications by email. STARTTLS is used if the server supports it.
+type SMTP struct {
+	// Addr is "host:port" of the server
+	Addr string
+	// Username and Password authenticate with PLAIN if Username is not empty, which requires TLS except on localhost
+	Username string
+	Password string
+	From     string
+	To       []string
+}
+
+func (s *SMTP) Send(ctx context.Context, subject, message string) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := strings.Cut(s.Addr, ":")
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.NewReplacer("\r", " ", "\n", " ").Replace(subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(message, "\r\n", "\n"), "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	// smtp.SendMail doesn't take a context, so the result is abandoned when ctx is done
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- smtp.SendMail(s.Addr, auth, s.From, s.To, msg.Bytes())
+	}()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
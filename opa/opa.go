@@ -0,0 +1,74 @@
+// Package opa provides server.Authorizer implementations by Open Policy Agent:
+// Authorizer asks an OPA server, e.g. a sidecar, and Policy evaluates Rego policies in process.
+package opa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+)
+
+// Authorizer queries a decision of the OPA REST API (https://www.openpolicyagent.org/docs/latest/rest-api/#get-a-document-with-input).
+// The action is passed as the input and the decision must be a boolean.
+type Authorizer struct {
+	// URL is the data API URL of the decision (e.g. "http://127.0.0.1:8181/v1/data/sshd/allow")
+	URL string
+	// Client is http.DefaultClient if nil
+	Client *http.Client
+	// Timeout is the time limit of a decision (default: DefaultTimeout)
+	Timeout time.Duration
+}
+
+// DefaultTimeout is the time limit of a decision if Authorizer.Timeout is 0
+const DefaultTimeout = 10 * time.Second
+
+var _ server.Authorizer = (*Authorizer)(nil)
+
+func (a *Authorizer) Authorize(action *server.Action) (bool, error) {
+	body, err := json.Marshal(struct {
+		Input *server.Action `json:"input"`
+	}{Input: action})
+	if err != nil {
+		return false, err
+	}
+	client := a.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	timeout := a.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	// The action waits for the decision, which must not hang forever
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("unexpected status from OPA: %s", resp.Status)
+	}
+	var decision struct {
+		// Result is nil when the decision is undefined
+		Result *bool `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return false, fmt.Errorf("failed to decode OPA response: %w", err)
+	}
+	if decision.Result == nil {
+		return false, nil
+	}
+	return *decision.Result, nil
+}
@@ -0,0 +1,110 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthorizer(t *testing.T) {
+	var input server.Action
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/data/sshd/allow", r.URL.Path)
+		var body struct {
+			Input server.Action `json:"input"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		input = body.Input
+		switch input.User {
+		case "john":
+			w.Write([]byte(`{"result": true}`))
+		case "alex":
+			w.Write([]byte(`{"result": false}`))
+		case "bob":
+			// Undefined decision
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer httpServer.Close()
+	authorizer := &Authorizer{URL: httpServer.URL + "/v1/data/sshd/allow"}
+
+	allowed, err := authorizer.Authorize(&server.Action{Type: server.ActionExec, User: "john", Command: []string{"ls", "-l"}})
+	require.NoError(t, err)
+	assert.True(t, allowed)
+	assert.Equal(t, server.Action{Type: server.ActionExec, User: "john", Command: []string{"ls", "-l"}}, input)
+
+	allowed, err = authorizer.Authorize(&server.Action{Type: server.ActionSftp, User: "alex", Operation: "Get", Path: "/etc/shadow"})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	allowed, err = authorizer.Authorize(&server.Action{Type: server.ActionDirectTcpip, User: "bob", Host: "10.0.0.1", Port: 22})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	_, err = authorizer.Authorize(&server.Action{Type: server.ActionExec, User: "eve"})
+	assert.Error(t, err)
+}
+
+func TestAuthorizerTimeout(t *testing.T) {
+	done := make(chan struct{})
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer httpServer.Close()
+	defer close(done)
+	authorizer := &Authorizer{URL: httpServer.URL, Timeout: 100 * time.Millisecond}
+	_, err := authorizer.Authorize(&server.Action{Type: server.ActionExec, User: "john"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy("", map[string]string{"policy.rego": `package sshd
+
+default allow := false
+
+allow if {
+	input.type == "sftp"
+	startswith(input.path, "/srv/share/")
+}
+
+allow if {
+	input.type == "exec"
+	input.command[0] == "git-upload-pack"
+}
+`})
+	require.NoError(t, err)
+	for _, c := range []struct {
+		action  server.Action
+		allowed bool
+	}{
+		{action: server.Action{Type: server.ActionSftp, User: "john", Operation: "Get", Path: "/srv/share/a.txt"}, allowed: true},
+		{action: server.Action{Type: server.ActionSftp, User: "john", Operation: "Get", Path: "/etc/shadow"}, allowed: false},
+		{action: server.Action{Type: server.ActionExec, User: "john", Command: []string{"git-upload-pack", "repo.git"}}, allowed: true},
+		{action: server.Action{Type: server.ActionExec, User: "john", Command: []string{"sh"}}, allowed: false},
+		{action: server.Action{Type: server.ActionDirectTcpip, User: "john", Host: "10.0.0.1", Port: 22}, allowed: false},
+	} {
+		allowed, err := policy.Authorize(&c.action)
+		require.NoError(t, err)
+		assert.Equal(t, c.allowed, allowed, c.action)
+	}
+
+	// Undefined decisions deny actions and non-boolean ones fail
+	policy, err = NewPolicy("data.sshd.allow", map[string]string{"policy.rego": "package sshd\n\nallow := input.user if input.type == \"exec\"\n"})
+	require.NoError(t, err)
+	allowed, err := policy.Authorize(&server.Action{Type: server.ActionSftp, User: "john"})
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	_, err = policy.Authorize(&server.Action{Type: server.ActionExec, User: "john"})
+	assert.EqualError(t, err, "decision is not a boolean: john")
+
+	_, err = NewPolicy("", map[string]string{"policy.rego": "package sshd\n\nallow {\n\ttrue\n}\n"})
+	assert.Error(t, err)
+}
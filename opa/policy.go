@@ -0,0 +1,86 @@
+package opa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/rego"
+)
+
+// DefaultQuery is the decision of Policy if the query is empty
+const DefaultQuery = "data.sshd.allow"
+
+// Policy evaluates Rego policies in process, without an OPA server.
+// The action is the input like Authorizer and the decision must be a boolean.
+type Policy struct {
+	query rego.PreparedEvalQuery
+	// Timeout is the time limit of a decision (default: DefaultTimeout)
+	Timeout time.Duration
+}
+
+var _ server.Authorizer = (*Policy)(nil)
+
+// NewPolicy compiles modules, Rego sources in the syntax of OPA 1.0 by their file names, to decide query (default: DefaultQuery).
+func NewPolicy(query string, modules map[string]string) (*Policy, error) {
+	if query == "" {
+		query = DefaultQuery
+	}
+	options := []func(*rego.Rego){rego.Query(query), rego.SetRegoVersion(ast.RegoV1)}
+	for name, module := range modules {
+		options = append(options, rego.Module(name, module))
+	}
+	prepared, err := rego.New(options...).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{query: prepared}, nil
+}
+
+// LoadPolicy reads Rego files of paths and compiles them by NewPolicy.
+func LoadPolicy(query string, paths []string) (*Policy, error) {
+	modules := map[string]string{}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		modules[path] = string(b)
+	}
+	return NewPolicy(query, modules)
+}
+
+func (p *Policy) Authorize(action *server.Action) (bool, error) {
+	// Fields are named by their JSON tags as in the input of Authorizer
+	b, err := json.Marshal(action)
+	if err != nil {
+		return false, err
+	}
+	var input any
+	if err := json.Unmarshal(b, &input); err != nil {
+		return false, err
+	}
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return false, err
+	}
+	// No results when the decision is undefined
+	if len(results) == 0 || len(results[0].Expressions) == 0 {
+		return false, nil
+	}
+	allowed, ok := results[0].Expressions[0].Value.(bool)
+	if !ok {
+		return false, fmt.Errorf("decision is not a boolean: %v", results[0].Expressions[0].Value)
+	}
+	return allowed, nil
+}
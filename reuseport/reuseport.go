@@ -0,0 +1,6 @@
+// Package reuseport listens on a TCP address by multiple sockets with SO_REUSEPORT, among which the kernel balances connections.
+// Each socket has its own accept queue, so accepting by a goroutine per socket doesn't contend on one queue.
+package reuseport
+
+// CPUAny is the CPU of ListenConfig not preferring connections received on any CPU
+const CPUAny = -1
@@ -0,0 +1,27 @@
+package reuseport
+
+import (
+	"net"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// ListenConfig returns the config of a listener with SO_REUSEPORT.
+// Unless cpu is CPUAny, the kernel prefers the listener for connections received on cpu by SO_INCOMING_CPU.
+func ListenConfig(cpu int) (*net.ListenConfig, error) {
+	return &net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+			if sockErr == nil && cpu != CPUAny {
+				sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_INCOMING_CPU, cpu)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return os.NewSyscallError("setsockopt", sockErr)
+	}}, nil
+}
@@ -0,0 +1,47 @@
+package reuseport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenConfig(t *testing.T) {
+	config, err := ListenConfig(CPUAny)
+	require.NoError(t, err)
+	ln1, err := config.Listen(context.Background(), "tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln1.Close()
+	config, err = ListenConfig(0)
+	require.NoError(t, err)
+	ln2, err := config.Listen(context.Background(), "tcp", ln1.Addr().String())
+	require.NoError(t, err)
+	defer ln2.Close()
+
+	// Connections are accepted by either listener
+	accepted := make(chan net.Conn, 8)
+	for _, ln := range []net.Listener{ln1, ln2} {
+		go func(ln net.Listener) {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}(ln)
+	}
+	for i := 0; i < 8; i++ {
+		conn, err := net.Dial("tcp", ln1.Addr().String())
+		require.NoError(t, err)
+		conn.Close()
+		(<-accepted).Close()
+	}
+
+	// Sockets without SO_REUSEPORT can't listen on the address
+	_, err = net.Listen("tcp", ln1.Addr().String())
+	assert.Error(t, err)
+}
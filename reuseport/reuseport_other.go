@@ -0,0 +1,13 @@
+//go:build !linux
+
+package reuseport
+
+import (
+	"fmt"
+	"net"
+)
+
+// ListenConfig returns the config of a listener with SO_REUSEPORT.
+func ListenConfig(cpu int) (*net.ListenConfig, error) {
+	return nil, fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
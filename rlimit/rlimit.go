@@ -0,0 +1,95 @@
+// Package rlimit runs commands with resource limits on Linux.
+// Go programs can not run code between fork and exec, so Command makes the executable start again as a helper,
+// which sets the limits and executes the command in place of itself. The command never runs without them.
+package rlimit
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// envLimits is the limits for the helper started by Command
+const envLimits = "GO_SSHD_RESOURCE_LIMITS"
+
+// Limit is a resource such as unix.RLIMIT_NOFILE with the value of both its soft and hard limits.
+type Limit struct {
+	Resource int
+	Value    uint64
+}
+
+// Command makes cmd run with limits by starting the executable as a helper.
+// The helper must call RunHelper first in main. Path and Args of cmd are changed.
+// Settings of cmd such as Dir, Env and SysProcAttr apply to the helper and are inherited by the command,
+// so the executable must be found after SysProcAttr.Chroot.
+func Command(cmd *exec.Cmd, limits []Limit) error {
+	if err := supported(); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envLimits+"="+format(limits))
+	cmd.Args = append([]string{executable, cmd.Path}, cmd.Args...)
+	cmd.Path = executable
+	return nil
+}
+
+// RunHelper sets the limits and executes the command if this process was started by Command, and does nothing otherwise.
+// It exits with 126 like shells when the command can not be executed.
+func RunHelper() {
+	s, ok := os.LookupEnv(envLimits)
+	if !ok {
+		return
+	}
+	os.Unsetenv(envLimits)
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "rlimit: no command")
+		os.Exit(126)
+	}
+	limits, err := parse(s)
+	if err == nil {
+		err = execLimited(limits, os.Args[1], os.Args[2:])
+	}
+	fmt.Fprintf(os.Stderr, "rlimit: %s: %v\n", os.Args[1], err)
+	os.Exit(126)
+}
+
+// format returns limits as comma-separated "resource=value"
+func format(limits []Limit) string {
+	var s []string
+	for _, limit := range limits {
+		s = append(s, strconv.Itoa(limit.Resource)+"="+strconv.FormatUint(limit.Value, 10))
+	}
+	return strings.Join(s, ",")
+}
+
+// parse parses limits in the form of format
+func parse(s string) ([]Limit, error) {
+	var limits []Limit
+	if s == "" {
+		return limits, nil
+	}
+	for _, limit := range strings.Split(s, ",") {
+		resource, value, _ := strings.Cut(limit, "=")
+		r, err := strconv.Atoi(resource)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource limit: %s", limit)
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resource limit: %s", limit)
+		}
+		limits = append(limits, Limit{Resource: r, Value: v})
+	}
+	return limits, nil
+}
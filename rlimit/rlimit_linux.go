@@ -0,0 +1,21 @@
+package rlimit
+
+import (
+	"os"
+	"syscall"
+)
+
+func supported() error {
+	return nil
+}
+
+// execLimited executes the program at path with args after setting limits.
+// syscall.Setrlimit keeps RLIMIT_NOFILE from being restored to the one this process started with.
+func execLimited(limits []Limit, path string, args []string) error {
+	for _, limit := range limits {
+		if err := syscall.Setrlimit(limit.Resource, &syscall.Rlimit{Cur: limit.Value, Max: limit.Value}); err != nil {
+			return os.NewSyscallError("setrlimit", err)
+		}
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
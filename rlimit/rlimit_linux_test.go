@@ -0,0 +1,35 @@
+package rlimit
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestCommand(t *testing.T) {
+	if err := supported(); err != nil {
+		t.Skip(err)
+	}
+	// The first process started by the helper already has the limits
+	cmd := exec.Command("sh", "-c", "echo $0; ulimit -n; ulimit -t", "limited")
+	require.NoError(t, Command(cmd, []Limit{{Resource: unix.RLIMIT_NOFILE, Value: 32}, {Resource: unix.RLIMIT_CPU, Value: 60}}))
+	output, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Equal(t, "limited\n32\n60\n", string(output))
+
+	cmd = exec.Command("/no-such-command")
+	require.NoError(t, Command(cmd, nil))
+	err = cmd.Run()
+	if assert.IsType(t, &exec.ExitError{}, err) {
+		assert.Equal(t, 126, err.(*exec.ExitError).ExitCode())
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package rlimit
+
+import "fmt"
+
+func supported() error {
+	return fmt.Errorf("resource limits are not supported on this platform")
+}
+
+func execLimited(limits []Limit, path string, args []string) error {
+	return supported()
+}
@@ -0,0 +1,126 @@
+// Package seccomp runs commands under seccomp filters on Linux to limit the system calls available to them.
+// Go programs can not run code between fork and exec, so Command makes the executable start again as a helper,
+// which installs the filter and executes the command in place of itself.
+package seccomp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// envProfile is the profile path for the helper started by Command
+const envProfile = "GO_SSHD_SECCOMP_PROFILE"
+
+// Actions of profiles
+const (
+	ActAllow       = "SCMP_ACT_ALLOW"
+	ActErrno       = "SCMP_ACT_ERRNO"
+	ActKill        = "SCMP_ACT_KILL"
+	ActKillThread  = "SCMP_ACT_KILL_THREAD"
+	ActKillProcess = "SCMP_ACT_KILL_PROCESS"
+	ActTrap        = "SCMP_ACT_TRAP"
+	ActLog         = "SCMP_ACT_LOG"
+)
+
+// Profile is a seccomp profile in the format of Docker and containerd.
+// Syscall names unknown on the architecture are ignored, so profiles can be shared between architectures.
+// Conditions on arguments are not supported.
+type Profile struct {
+	DefaultAction   string `json:"defaultAction"`
+	DefaultErrnoRet *uint  `json:"defaultErrnoRet,omitempty"`
+	Syscalls        []Rule `json:"syscalls"`
+}
+
+// Rule is the action for system calls of Names. ErrnoRet is the error number of SCMP_ACT_ERRNO, EPERM by default.
+type Rule struct {
+	Names    []string          `json:"names"`
+	Action   string            `json:"action"`
+	ErrnoRet *uint             `json:"errnoRet,omitempty"`
+	Args     []json.RawMessage `json:"args,omitempty"`
+}
+
+// LoadProfile reads and validates the profile at path.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Profile
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse seccomp profile: %w", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// Validate returns an error if p has unknown actions or conditions on arguments.
+func (p *Profile) Validate() error {
+	if p.DefaultAction == "" {
+		return fmt.Errorf("no defaultAction")
+	}
+	if err := validateAction(p.DefaultAction); err != nil {
+		return err
+	}
+	for _, rule := range p.Syscalls {
+		if err := validateAction(rule.Action); err != nil {
+			return err
+		}
+		if len(rule.Args) != 0 {
+			return fmt.Errorf("conditions on arguments are not supported: %v", rule.Names)
+		}
+	}
+	return nil
+}
+
+func validateAction(action string) error {
+	switch action {
+	case ActAllow, ActErrno, ActKill, ActKillThread, ActKillProcess, ActTrap, ActLog:
+		return nil
+	}
+	return fmt.Errorf("unknown action: %s", action)
+}
+
+// Command makes cmd run under the filter of the profile at path by starting the executable as a helper.
+// The helper must call RunHelper first in main. Path and Args of cmd are changed.
+// Settings of cmd such as Dir, Env and SysProcAttr apply to the helper and are inherited by the command.
+func Command(cmd *exec.Cmd, path string) error {
+	if err := supported(); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if cmd.Err != nil {
+		return cmd.Err
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, envProfile+"="+path)
+	cmd.Args = append([]string{executable, cmd.Path}, cmd.Args...)
+	cmd.Path = executable
+	return nil
+}
+
+// RunHelper installs the filter and executes the command if this process was started by Command, and does nothing otherwise.
+// It exits with 126 like shells when the command can not be executed.
+func RunHelper() {
+	path := os.Getenv(envProfile)
+	if path == "" {
+		return
+	}
+	os.Unsetenv(envProfile)
+	if len(os.Args) < 3 {
+		fmt.Fprintln(os.Stderr, "seccomp: no command")
+		os.Exit(126)
+	}
+	if err := execFiltered(path, os.Args[1], os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "seccomp: %s: %v\n", os.Args[1], err)
+		os.Exit(126)
+	}
+}
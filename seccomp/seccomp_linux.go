@@ -0,0 +1,130 @@
+package seccomp
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Offsets of struct seccomp_data
+const (
+	offsetNr   = 0
+	offsetArch = 4
+)
+
+func supported() error {
+	if auditArch == 0 {
+		return fmt.Errorf("seccomp filters are not supported on this architecture")
+	}
+	return nil
+}
+
+// Filter compiles p into a BPF program for this architecture.
+// System calls of other architectures such as x32 get the default action.
+func (p *Profile) Filter() ([]unix.SockFilter, error) {
+	if err := supported(); err != nil {
+		return nil, err
+	}
+	defaultAction, err := action(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, offsetNr),
+	}
+	if auditArch == unix.AUDIT_ARCH_X86_64 {
+		// x32 system calls have the same architecture
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, 0x40000000, 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, defaultAction),
+		)
+	}
+	seen := make(map[uint32]bool)
+	for _, rule := range p.Syscalls {
+		ruleAction, err := action(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range rule.Names {
+			nr, ok := syscalls[name]
+			if !ok || seen[nr] {
+				continue
+			}
+			seen[nr] = true
+			filter = append(filter,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, nr, 0, 1),
+				stmt(unix.BPF_RET|unix.BPF_K, ruleAction),
+			)
+		}
+	}
+	return append(filter, stmt(unix.BPF_RET|unix.BPF_K, defaultAction)), nil
+}
+
+// action returns the return value of the filter for a of profiles
+func action(a string, errnoRet *uint) (uint32, error) {
+	switch a {
+	case ActAllow:
+		return unix.SECCOMP_RET_ALLOW, nil
+	case ActErrno:
+		errno := uint32(unix.EPERM)
+		if errnoRet != nil {
+			errno = uint32(*errnoRet)
+		}
+		return unix.SECCOMP_RET_ERRNO | errno&unix.SECCOMP_RET_DATA, nil
+	case ActKill, ActKillThread:
+		return unix.SECCOMP_RET_KILL_THREAD, nil
+	case ActKillProcess:
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	case ActTrap:
+		return unix.SECCOMP_RET_TRAP, nil
+	case ActLog:
+		return unix.SECCOMP_RET_LOG, nil
+	}
+	return 0, fmt.Errorf("unknown action: %s", a)
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// install installs filter to all threads of this process, which is inherited by executed programs.
+// Set-user-ID programs no longer gain privileges.
+func install(filter []unix.SockFilter) error {
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return os.NewSyscallError("prctl", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	if _, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return os.NewSyscallError("seccomp", errno)
+	}
+	return nil
+}
+
+// execFiltered executes the program at path with args under the filter of the profile at profilePath
+func execFiltered(profilePath, path string, args []string) error {
+	profile, err := LoadProfile(profilePath)
+	if err != nil {
+		return err
+	}
+	filter, err := profile.Filter()
+	if err != nil {
+		return err
+	}
+	if len(filter) > unix.BPF_MAXINSNS {
+		return fmt.Errorf("seccomp filter too long")
+	}
+	if err := install(filter); err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
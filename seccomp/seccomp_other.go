@@ -0,0 +1,13 @@
+//go:build !linux
+
+package seccomp
+
+import "fmt"
+
+func supported() error {
+	return fmt.Errorf("seccomp filters are not supported on this platform")
+}
+
+func execFiltered(profilePath, path string, args []string) error {
+	return supported()
+}
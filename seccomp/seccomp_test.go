@@ -0,0 +1,68 @@
+package seccomp
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestLoadProfile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO", "errnoRet": 1}]}`), 0600))
+	profile, err := LoadProfile(path)
+	require.NoError(t, err)
+	assert.Equal(t, ActAllow, profile.DefaultAction)
+	assert.Equal(t, []string{"mkdir", "mkdirat"}, profile.Syscalls[0].Names)
+
+	for _, invalid := range []string{
+		`{"syscalls": []}`,
+		`{"defaultAction": "SCMP_ACT_DENY"}`,
+		`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["mkdir"], "action": "SCMP_ACT_NOTIFY"}]}`,
+		`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [{"names": ["clone"], "action": "SCMP_ACT_ERRNO", "args": [{"index": 0, "value": 2114060288, "op": "SCMP_CMP_MASKED_EQ"}]}]}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(invalid), 0600))
+		_, err := LoadProfile(path)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	if err := supported(); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "profile.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"defaultAction": "SCMP_ACT_ALLOW", "syscalls": [
+		{"names": ["mkdir", "mkdirat"], "action": "SCMP_ACT_ERRNO"},
+		{"names": ["no_such_syscall"], "action": "SCMP_ACT_KILL"}
+	]}`), 0600))
+
+	cmd := exec.Command("sh", "-c", "echo $0; mkdir "+filepath.Join(dir, "denied"), "filtered")
+	require.NoError(t, Command(cmd, path))
+	output, err := cmd.CombinedOutput()
+	assert.Error(t, err)
+	assert.Contains(t, string(output), "filtered\n")
+	assert.Contains(t, string(output), "Operation not permitted")
+	assert.NoDirExists(t, filepath.Join(dir, "denied"))
+
+	cmd = exec.Command("mkdir", filepath.Join(dir, "allowed"))
+	assert.NoError(t, cmd.Run())
+	assert.DirExists(t, filepath.Join(dir, "allowed"))
+
+	cmd = exec.Command("true")
+	require.NoError(t, Command(cmd, filepath.Join(dir, "missing.json")))
+	err = cmd.Run()
+	if assert.IsType(t, &exec.ExitError{}, err) {
+		assert.Equal(t, 126, err.(*exec.ExitError).ExitCode())
+	}
+}
@@ -0,0 +1,7 @@
+//go:build linux && !amd64 && !arm64
+
+package seccomp
+
+const auditArch = 0
+
+var syscalls = map[string]uint32{}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server/forward"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SessionInfo describes an active "session" channel.
+type SessionInfo struct {
+	// ID is "<connection ID>/<number>"
+	ID string
+	// Type is "shell", "exec" or "subsystem". It is empty until requested.
+	Type string
+	// Command is the command of "exec" or the subsystem name
+	Command []string
+	// PID is the process ID of "shell" or "exec" if the process reports it
+	PID int
+	// Pty is whether "shell" or "exec" has a pseudo terminal
+	Pty       bool
+	StartTime time.Time
+}
+
+// ForwardInfo describes an active forwarding.
+type ForwardInfo struct {
+	// ID is "<connection ID>/<number>"
+	ID string
+	// Type is the forwarding type (e.g. "direct-tcpip", "tcpip-forward")
+	Type string
+	Host string
+	Port int
+	// Path is the Unix domain socket path
+	Path      string
+	StartTime time.Time
+}
+
+type activeSession struct {
+	mu    sync.Mutex
+	info  SessionInfo
+	close func() error
+	// tap copies the output to monitors
+	tap *outputTap
+	// stderr notifies the client of monitoring without being copied to monitors
+	stderr io.Writer
+	// stdin is the input of the process with a pty, to which users attached read-write write
+	stdin io.Writer
+}
+
+// start records the request starting the session
+func (sess *activeSession) start(reqType string, command []string, pty bool) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.info.Type = reqType
+	sess.info.Command = command
+	sess.info.Pty = pty
+}
+
+// setPID records the process ID of the session
+func (sess *activeSession) setPID(pid int) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.info.PID = pid
+}
+
+// setInput records the input of the process of the session with a pty
+func (sess *activeSession) setInput(stdin io.Writer) {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	sess.stdin = stdin
+}
+
+// input returns the input of the process of the session with a pty, or nil if not known
+func (sess *activeSession) input() io.Writer {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.stdin
+}
+
+func (sess *activeSession) snapshot() SessionInfo {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	return sess.info
+}
+
+type activeForward struct {
+	info       ForwardInfo
+	forwarding *forward.Forwarding
+}
+
+// nextActivityID returns a new ID of a session or a forwarding of the connection
+func (c *connection) nextActivityID() string {
+	return fmt.Sprintf("%s/%d", c.id, c.lastActivityID.Add(1))
+}
+
+// addSession records an active session of channel and returns the channel whose output is copied to monitors.
+// Call the returned function when it ends.
+func (c *connection) addSession(channel ssh.Channel) (*activeSession, ssh.Channel, func()) {
+	sess := &activeSession{
+		info:   SessionInfo{ID: c.nextActivityID(), StartTime: time.Now()},
+		close:  channel.Close,
+		tap:    &outputTap{},
+		stderr: channel.Stderr(),
+	}
+	c.sessions.Store(sess.info.ID, sess)
+	return sess, &tappedChannel{Channel: channel, tap: sess.tap}, func() {
+		c.sessions.Delete(sess.info.ID)
+		sess.tap.close()
+	}
+}
+
+// addForward records an active forwarding. Call the returned function when it ends.
+func (c *connection) addForward(forwarding *forward.Forwarding) func() {
+	target := forwarding.Target
+	fwd := &activeForward{
+		info:       ForwardInfo{ID: c.nextActivityID(), Type: target.Type, Host: target.Host, Port: target.Port, Path: target.Path, StartTime: time.Now()},
+		forwarding: forwarding,
+	}
+	c.forwards.Store(fwd.info.ID, fwd)
+	return func() { c.forwards.Delete(fwd.info.ID) }
+}
+
+// activities returns the active sessions and forwards of the connection in order of start time
+func (c *connection) activities() ([]SessionInfo, []ForwardInfo) {
+	var sessions []SessionInfo
+	c.sessions.Range(func(_ string, sess *activeSession) bool {
+		sessions = append(sessions, sess.snapshot())
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartTime.Before(sessions[j].StartTime)
+	})
+	var forwards []ForwardInfo
+	c.forwards.Range(func(_ string, fwd *activeForward) bool {
+		forwards = append(forwards, fwd.info)
+		return true
+	})
+	sort.Slice(forwards, func(i, j int) bool {
+		return forwards[i].StartTime.Before(forwards[j].StartTime)
+	})
+	return sessions, forwards
+}
+
+// Close closes the connection, the session or the forwarding of id in Connections.
+// It returns false if id is not found.
+func (s *Server) Close(id string) bool {
+	connID, _, isActivity := strings.Cut(id, "/")
+	conn, ok := s.connections.Load(connID)
+	if !ok {
+		return false
+	}
+	if !isActivity {
+		conn.logger.Info("closing connection by request")
+		conn.sshConn.Close()
+		return true
+	}
+	if sess, ok := conn.sessions.Load(id); ok {
+		conn.logger.Info("closing session by request", "session_id", id)
+		sess.close()
+		return true
+	}
+	if fwd, ok := conn.forwards.Load(id); ok {
+		conn.logger.Info("stopping forwarding by request", "forward_id", id)
+		fwd.forwarding.Stop()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slices"
+)
+
+// Supported and default algorithms of golang.org/x/crypto/ssh for servers
+var (
+	SupportedCiphers = []string{
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"arcfour256", "arcfour128", "arcfour",
+		"aes128-cbc",
+		"3des-cbc",
+	}
+	DefaultCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	SupportedMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+		"hmac-sha1", "hmac-sha1-96",
+	}
+	DefaultMACs = SupportedMACs
+	// SupportedKeyExchanges are without diffie-hellman-group-exchange-* which is only for clients
+	SupportedKeyExchanges = append(slices.Clone(PostQuantumKeyExchanges),
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512", "diffie-hellman-group14-sha1",
+		"diffie-hellman-group1-sha1",
+	)
+	DefaultKeyExchanges = append(slices.Clone(PostQuantumKeyExchanges),
+		"curve25519-sha256", "curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group14-sha1",
+	)
+)
+
+// Modes of Algorithms.PostQuantum
+const (
+	// PostQuantumPrefer puts the post-quantum key exchanges first
+	PostQuantumPrefer = "prefer"
+	// PostQuantumRequire allows only the post-quantum key exchanges
+	PostQuantumRequire = "require"
+	// PostQuantumDisable removes the post-quantum key exchanges
+	PostQuantumDisable = "disable"
+)
+
+// Algorithms are comma-separated algorithm lists in the form of Ciphers, MACs and KexAlgorithms of sshd_config.
+// A list starting with "+" appends to the defaults, "-" removes from them and "^" prepends to them.
+// Patterns of "-" can have "*" and "?". Empty lists are the defaults.
+type Algorithms struct {
+	Ciphers      string
+	MACs         string
+	KeyExchanges string
+	// FIPS makes the FIPS algorithms the supported ones and the defaults
+	FIPS bool
+	// PostQuantum is PostQuantumPrefer, PostQuantumRequire, PostQuantumDisable or empty to leave key exchanges as they are
+	PostQuantum string
+}
+
+// Apply sets the algorithms of config. It returns an error with unsupported algorithms.
+func (a Algorithms) Apply(config *ssh.Config) error {
+	defaultCiphers, supportedCiphers := DefaultCiphers, SupportedCiphers
+	defaultMACs, supportedMACs := DefaultMACs, SupportedMACs
+	defaultKeyExchanges, supportedKeyExchanges := DefaultKeyExchanges, SupportedKeyExchanges
+	if a.FIPS {
+		defaultCiphers, supportedCiphers = FIPSCiphers, FIPSCiphers
+		defaultMACs, supportedMACs = FIPSMACs, FIPSMACs
+		defaultKeyExchanges, supportedKeyExchanges = FIPSKeyExchanges, FIPSKeyExchanges
+	}
+	ciphers, err := resolveAlgorithms(a.Ciphers, defaultCiphers, supportedCiphers)
+	if err != nil {
+		return fmt.Errorf("ciphers: %w", err)
+	}
+	macs, err := resolveAlgorithms(a.MACs, defaultMACs, supportedMACs)
+	if err != nil {
+		return fmt.Errorf("MACs: %w", err)
+	}
+	keyExchanges, err := resolveAlgorithms(a.KeyExchanges, defaultKeyExchanges, supportedKeyExchanges)
+	if err != nil {
+		return fmt.Errorf("key exchanges: %w", err)
+	}
+	if a.FIPS {
+		// nil is the defaults of golang.org/x/crypto/ssh
+		if ciphers == nil {
+			ciphers = FIPSCiphers
+		}
+		if macs == nil {
+			macs = FIPSMACs
+		}
+		if keyExchanges == nil {
+			keyExchanges = FIPSKeyExchanges
+		}
+	}
+	if keyExchanges, err = applyPostQuantum(a.PostQuantum, keyExchanges, defaultKeyExchanges, a.FIPS); err != nil {
+		return fmt.Errorf("key exchanges: %w", err)
+	}
+	config.Ciphers, config.MACs, config.KeyExchanges = ciphers, macs, keyExchanges
+	return nil
+}
+
+// applyPostQuantum returns keyExchanges, or defaults if nil, with the post-quantum key exchanges in mode
+func applyPostQuantum(mode string, keyExchanges, defaults []string, fips bool) ([]string, error) {
+	switch mode {
+	case "":
+		return keyExchanges, nil
+	case PostQuantumPrefer, PostQuantumRequire:
+		if fips {
+			return nil, fmt.Errorf("post-quantum key exchanges are not approved by FIPS")
+		}
+		if len(PostQuantumKeyExchanges) == 0 {
+			return nil, fmt.Errorf("post-quantum key exchanges require Go 1.24 or later")
+		}
+	case PostQuantumDisable:
+	default:
+		return nil, fmt.Errorf("unknown post-quantum mode: %q", mode)
+	}
+	if keyExchanges == nil {
+		keyExchanges = defaults
+	}
+	var algorithms []string
+	switch mode {
+	case PostQuantumPrefer:
+		algorithms = appendNew(algorithms, PostQuantumKeyExchanges...)
+		algorithms = appendNew(algorithms, keyExchanges...)
+	case PostQuantumRequire:
+		for _, algorithm := range keyExchanges {
+			if slices.Contains(PostQuantumKeyExchanges, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		if len(algorithms) == 0 {
+			algorithms = PostQuantumKeyExchanges
+		}
+	case PostQuantumDisable:
+		for _, algorithm := range keyExchanges {
+			if !slices.Contains(PostQuantumKeyExchanges, algorithm) {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		if len(algorithms) == 0 {
+			return nil, fmt.Errorf("all algorithms removed")
+		}
+	}
+	return algorithms, nil
+}
+
+// resolveAlgorithms returns the algorithms of list, nil for the defaults of golang.org/x/crypto/ssh
+func resolveAlgorithms(list string, defaults, supported []string) ([]string, error) {
+	if list == "" {
+		return nil, nil
+	}
+	op := list[0]
+	if op == '+' || op == '-' || op == '^' {
+		list = list[1:]
+	}
+	names := strings.Split(list, ",")
+	if op == '-' {
+		var algorithms []string
+		for _, algorithm := range defaults {
+			removed := false
+			for _, pattern := range names {
+				removed = removed || globRegexp(pattern).MatchString(algorithm)
+			}
+			if !removed {
+				algorithms = append(algorithms, algorithm)
+			}
+		}
+		if len(algorithms) == 0 {
+			return nil, fmt.Errorf("all algorithms removed")
+		}
+		return algorithms, nil
+	}
+	for _, name := range names {
+		if !slices.Contains(supported, name) {
+			return nil, fmt.Errorf("unsupported algorithm: %q", name)
+		}
+	}
+	var algorithms []string
+	switch op {
+	case '+':
+		algorithms = append(algorithms, defaults...)
+		algorithms = appendNew(algorithms, names...)
+	case '^':
+		algorithms = appendNew(algorithms, names...)
+		algorithms = appendNew(algorithms, defaults...)
+	default:
+		algorithms = appendNew(algorithms, names...)
+	}
+	return algorithms, nil
+}
+
+// appendNew appends the values not in s
+func appendNew(s []string, values ...string) []string {
+	for _, v := range values {
+		if !slices.Contains(s, v) {
+			s = append(s, v)
+		}
+	}
+	return s
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server/session"
+
+	"github.com/mattn/go-shellwords"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// AttachCommand is the command of "exec" requests attaching to a pty session of another connection to share it like tmux,
+// e.g. "ssh -t host go-sshd-attach ID". "-w" before ID attaches read-write, and no ID lists the sessions to attach to.
+// It requires PermissionAttach, or PermissionAttachWrite for "-w", and is not served to Handler.
+// Sessions of the same user, or of Server.AttachTargets, can be attached to if Authorizer allows ActionAttach.
+const AttachCommand = "go-sshd-attach"
+
+// attachUsage is written for invalid arguments of AttachCommand
+const attachUsage = "usage: " + AttachCommand + " [[-w] SESSION_ID]\n"
+
+// attachArgs returns the arguments of AttachCommand if the payload of "exec" runs it
+func attachArgs(payload []byte) ([]string, bool) {
+	rawCommand, err := session.ParseExec(payload)
+	if err != nil {
+		return nil, false
+	}
+	words, err := shellwords.Parse(rawCommand)
+	if err != nil || len(words) == 0 || words[0] != AttachCommand {
+		return nil, false
+	}
+	return words[1:], true
+}
+
+// handleAttach serves the "exec" request of AttachCommand with args on channel. It returns false if it is rejected.
+func (s *Server) handleAttach(logger *slog.Logger, conn *connection, req *ssh.Request, channel ssh.Channel, active *activeSession, args []string) bool {
+	if !conn.permissions.attach {
+		logger.Info("attach not allowed")
+		req.Reply(false, nil)
+		return false
+	}
+	req.Reply(true, nil)
+	active.start(req.Type, append([]string{AttachCommand}, args...), false)
+	conn.lifecycle.Go(func() {
+		exitCode := s.attach(logger, conn, channel, args)
+		channel.SendRequest("exit-status", false, session.ExitStatus(exitCode))
+		channel.Close()
+	})
+	return true
+}
+
+// attach runs AttachCommand with args and returns its exit code
+func (s *Server) attach(logger *slog.Logger, conn *connection, channel ssh.Channel, args []string) int {
+	command := append([]string{AttachCommand}, args...)
+	user := conn.metadata.User()
+	write := len(args) != 0 && args[0] == "-w"
+	if write {
+		args = args[1:]
+	}
+	switch {
+	case len(args) == 0 && !write:
+		s.writeAttachable(channel, user)
+		return 0
+	case len(args) != 1:
+		io.WriteString(channel.Stderr(), attachUsage)
+		return 2
+	case write && !conn.permissions.attachWrite:
+		logger.Info("read-write attach not allowed")
+		io.WriteString(channel.Stderr(), "read-write attach not allowed\n")
+		return 1
+	}
+	id := args[0]
+	target, sess, ok := s.findSession(id)
+	var input io.Writer
+	if ok {
+		input = sess.input()
+	}
+	// Sessions of other users are not revealed
+	if !ok || !s.canAttach(user, target.metadata.User()) || !sess.snapshot().Pty || write && input == nil {
+		fmt.Fprintf(channel.Stderr(), "no session to attach to: %s\n", id)
+		return 1
+	}
+	if !s.authorize(logger, conn, &Action{Type: ActionAttach, Command: command, TargetUser: target.metadata.User()}) {
+		io.WriteString(channel.Stderr(), "attach not allowed\n")
+		return 1
+	}
+	output, stop, ok := sess.tap.subscribe()
+	if !ok {
+		fmt.Fprintf(channel.Stderr(), "no session to attach to: %s\n", id)
+		return 1
+	}
+	mode := "read-only"
+	if write {
+		mode = "read-write"
+	}
+	logger.Info("attached to session", "session_id", id, "mode", mode)
+	target.logger.Info("session attached by user", "session_id", id, "attached_user", user, "attached_conn_id", conn.id, "mode", mode)
+	fmt.Fprintf(sess.stderr, "\r\n[go-sshd: %s attached to this session (%s)]\r\n", user, mode)
+	// Input of read-only ones is discarded. The end of input detaches.
+	conn.lifecycle.Go(func() {
+		if write {
+			io.Copy(input, channel)
+		} else {
+			io.Copy(io.Discard, channel)
+		}
+		stop()
+	})
+	for chunk := range output {
+		if _, err := channel.Write(chunk); err != nil {
+			stop()
+		}
+	}
+	logger.Info("detached from session", "session_id", id)
+	target.logger.Info("session detached by user", "session_id", id, "attached_user", user, "attached_conn_id", conn.id)
+	fmt.Fprintf(sess.stderr, "\r\n[go-sshd: %s detached from this session]\r\n", user)
+	return 0
+}
+
+// canAttach reports whether user can attach to sessions of targetUser
+func (s *Server) canAttach(user, targetUser string) bool {
+	return user == targetUser || slices.Contains(s.AttachTargets, targetUser) || slices.Contains(s.AttachTargets, "*")
+}
+
+// writeAttachable writes the sessions with a pty which user can attach to
+func (s *Server) writeAttachable(w io.Writer, user string) {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tUSER\tAGE\tCOMMAND")
+	now := time.Now()
+	for _, info := range s.Connections() {
+		if !s.canAttach(user, info.User) {
+			continue
+		}
+		for _, sess := range info.Sessions {
+			if sess.Pty {
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", sess.ID, info.User, now.Sub(sess.StartTime).Truncate(time.Second), strings.Join(sess.Command, " "))
+			}
+		}
+	}
+	tw.Flush()
+}
@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os/user"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// UserAccess allows or denies users before authentication like AllowUsers, DenyUsers, AllowGroups and DenyGroups of sshd_config.
+// User patterns can be "user@host" where host is a pattern or a CIDR of the client address.
+// Patterns can have "*" and "?". Deny lists are checked first, then allow lists of which one must match if not empty.
+type UserAccess struct {
+	AllowUsers  []string
+	DenyUsers   []string
+	AllowGroups []string
+	DenyGroups  []string
+	// Groups returns the group names of user. The groups of the OS user are used if nil.
+	Groups func(user string) ([]string, error)
+	// Logger logs denied users if not nil
+	Logger *slog.Logger
+}
+
+// Check returns an error if user from addr is not allowed.
+func (a *UserAccess) Check(userName string, addr net.Addr) error {
+	for _, pattern := range a.DenyUsers {
+		if matchUser(pattern, userName, addr) {
+			return fmt.Errorf("user denied by pattern %q", pattern)
+		}
+	}
+	if len(a.AllowUsers) != 0 {
+		allowed := false
+		for _, pattern := range a.AllowUsers {
+			allowed = allowed || matchUser(pattern, userName, addr)
+		}
+		if !allowed {
+			return errors.New("user not allowed")
+		}
+	}
+	if len(a.AllowGroups) == 0 && len(a.DenyGroups) == 0 {
+		return nil
+	}
+	groupsFunc := a.Groups
+	if groupsFunc == nil {
+		groupsFunc = osGroups
+	}
+	groups, err := groupsFunc(userName)
+	if err != nil {
+		return fmt.Errorf("failed to get groups: %w", err)
+	}
+	for _, pattern := range a.DenyGroups {
+		for _, group := range groups {
+			if wildcard(pattern, group) {
+				return fmt.Errorf("group %s denied by pattern %q", group, pattern)
+			}
+		}
+	}
+	if len(a.AllowGroups) != 0 {
+		for _, pattern := range a.AllowGroups {
+			for _, group := range groups {
+				if wildcard(pattern, group) {
+					return nil
+				}
+			}
+		}
+		return errors.New("no groups allowed")
+	}
+	return nil
+}
+
+// Apply makes the authentication callbacks of config fail for users not allowed without calling them.
+func (a *UserAccess) Apply(config *ssh.ServerConfig) {
+	WithCheck(config, func(conn ssh.ConnMetadata) error {
+		err := a.Check(conn.User(), conn.RemoteAddr())
+		if err == nil {
+			return nil
+		}
+		if a.Logger != nil {
+			a.Logger.Info("denied user", "user", conn.User(), "remote_address", conn.RemoteAddr().String(), "reason", err)
+		}
+		return fmt.Errorf("%q denied: %w", conn.User(), err)
+	})
+}
+
+// WithCheck makes the authentication callbacks of config fail with the error of check without calling them.
+func WithCheck(config *ssh.ServerConfig, check func(conn ssh.ConnMetadata) error) {
+	if callback := config.PasswordCallback; callback != nil {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if err := check(conn); err != nil {
+				return nil, err
+			}
+			return callback(conn, password)
+		}
+	}
+	if callback := config.PublicKeyCallback; callback != nil {
+		config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if err := check(conn); err != nil {
+				return nil, err
+			}
+			return callback(conn, key)
+		}
+	}
+	if callback := config.KeyboardInteractiveCallback; callback != nil {
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			if err := check(conn); err != nil {
+				return nil, err
+			}
+			return callback(conn, client)
+		}
+	}
+	if callback := config.NoClientAuthCallback; callback != nil {
+		config.NoClientAuthCallback = func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			if err := check(conn); err != nil {
+				return nil, err
+			}
+			return callback(conn)
+		}
+	}
+}
+
+// matchUser matches "user" or "user@host" patterns
+func matchUser(pattern, userName string, addr net.Addr) bool {
+	i := strings.LastIndexByte(pattern, '@')
+	if i < 0 {
+		return wildcard(pattern, userName)
+	}
+	if !wildcard(pattern[:i], userName) {
+		return false
+	}
+	host := pattern[i+1:]
+	var ip net.IP
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		ip = tcpAddr.IP
+	} else if addr != nil {
+		h, _, _ := net.SplitHostPort(addr.String())
+		ip = net.ParseIP(h)
+	}
+	if ip == nil {
+		return false
+	}
+	if _, ipNet, err := net.ParseCIDR(host); err == nil {
+		return ipNet.Contains(ip)
+	}
+	return wildcard(host, ip.String())
+}
+
+// wildcard matches s with pattern of "*" and "?"
+func wildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
+
+// osGroups returns the groups of the OS user
+func osGroups(userName string) ([]string, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups, nil
+}
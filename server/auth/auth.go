@@ -0,0 +1,150 @@
+// Package auth provides composable authentication callbacks for ssh.ServerConfig.
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// PasswordAuthenticator is a source of ssh.ServerConfig.PasswordCallback.
+type PasswordAuthenticator interface {
+	PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+}
+
+// PublicKeyAuthenticator is a source of ssh.ServerConfig.PublicKeyCallback.
+type PublicKeyAuthenticator interface {
+	PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error)
+}
+
+// StaticUser is a user with a fixed password. An empty password means no authentication is required.
+type StaticUser struct {
+	Name     string
+	Password string
+}
+
+// StaticUsers authenticates fixed users.
+type StaticUsers []StaticUser
+
+// ParseStaticUsers parses users in "name:password" form. Empty strings are skipped.
+func ParseStaticUsers(specs []string) (StaticUsers, error) {
+	var users StaticUsers
+	for _, spec := range specs {
+		if spec == "" {
+			continue
+		}
+		name, password, found := strings.Cut(spec, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid user format: %s", spec)
+		}
+		users = append(users, StaticUser{Name: name, Password: password})
+	}
+	return users, nil
+}
+
+func (u StaticUsers) PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	for _, user := range u {
+		if user.Name == conn.User() && subtle.ConstantTimeCompare([]byte(user.Password), password) == 1 {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("password rejected for %q", conn.User())
+}
+
+// NoClientAuthCallback accepts users without passwords. Set NoClientAuth of ssh.ServerConfig to use it.
+func (u StaticUsers) NoClientAuthCallback(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+	for _, user := range u {
+		// No auth required
+		if user.Name == conn.User() && user.Password == "" {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("%s auth required", conn.User())
+}
+
+// PasswordChain tries authenticators in order and accepts the first success.
+type PasswordChain []PasswordAuthenticator
+
+func (c PasswordChain) PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	err := fmt.Errorf("password rejected for %q", conn.User())
+	for _, authenticator := range c {
+		var perms *ssh.Permissions
+		perms, err = authenticator.PasswordCallback(conn, password)
+		if err == nil {
+			return perms, nil
+		}
+	}
+	return nil, err
+}
+
+// PublicKeyChain tries authenticators in order and accepts the first success.
+type PublicKeyChain []PublicKeyAuthenticator
+
+func (c PublicKeyChain) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	err := fmt.Errorf("public key rejected for %q", conn.User())
+	for _, authenticator := range c {
+		var perms *ssh.Permissions
+		perms, err = authenticator.PublicKeyCallback(conn, key)
+		if err == nil {
+			return perms, nil
+		}
+	}
+	return nil, err
+}
+
+// WithExtensions sets extensions returned by f for the connection to the permissions of successful authentications by config.
+// Extensions already set by the callbacks are kept.
+func WithExtensions(config *ssh.ServerConfig, f func(conn ssh.ConnMetadata) map[string]string) {
+	extend := func(conn ssh.ConnMetadata, perms *ssh.Permissions) *ssh.Permissions {
+		if perms == nil {
+			perms = &ssh.Permissions{}
+		}
+		if perms.Extensions == nil {
+			perms.Extensions = map[string]string{}
+		}
+		for key, value := range f(conn) {
+			if _, ok := perms.Extensions[key]; !ok {
+				perms.Extensions[key] = value
+			}
+		}
+		return perms
+	}
+	if callback := config.PasswordCallback; callback != nil {
+		config.PasswordCallback = func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			perms, err := callback(conn, password)
+			if err != nil {
+				return nil, err
+			}
+			return extend(conn, perms), nil
+		}
+	}
+	if callback := config.PublicKeyCallback; callback != nil {
+		config.PublicKeyCallback = func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			perms, err := callback(conn, key)
+			if err != nil {
+				return nil, err
+			}
+			return extend(conn, perms), nil
+		}
+	}
+	if callback := config.KeyboardInteractiveCallback; callback != nil {
+		config.KeyboardInteractiveCallback = func(conn ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			perms, err := callback(conn, client)
+			if err != nil {
+				return nil, err
+			}
+			return extend(conn, perms), nil
+		}
+	}
+	if callback := config.NoClientAuthCallback; callback != nil {
+		config.NoClientAuthCallback = func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			perms, err := callback(conn)
+			if err != nil {
+				return nil, err
+			}
+			return extend(conn, perms), nil
+		}
+	}
+}
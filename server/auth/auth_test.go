@@ -0,0 +1,259 @@
+package auth
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+type connMetadata struct {
+	ssh.ConnMetadata
+	user string
+	addr net.Addr
+}
+
+func (c connMetadata) User() string {
+	return c.user
+}
+
+func (c connMetadata) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestStaticUsers(t *testing.T) {
+	users, err := ParseStaticUsers([]string{"", "john:mypass", "alex:", "bob:pass:word"})
+	require.NoError(t, err)
+	assert.Equal(t, StaticUsers{{Name: "john", Password: "mypass"}, {Name: "alex"}, {Name: "bob", Password: "pass:word"}}, users)
+	_, err = ParseStaticUsers([]string{"john"})
+	assert.EqualError(t, err, "invalid user format: john")
+
+	_, err = users.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	assert.NoError(t, err)
+	_, err = users.PasswordCallback(connMetadata{user: "john"}, []byte("wrong"))
+	assert.Error(t, err)
+	_, err = users.NoClientAuthCallback(connMetadata{user: "alex"})
+	assert.NoError(t, err)
+	_, err = users.NoClientAuthCallback(connMetadata{user: "john"})
+	assert.Error(t, err)
+}
+
+type passwordFunc func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error)
+
+func (f passwordFunc) PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	return f(conn, password)
+}
+
+func TestPasswordChain(t *testing.T) {
+	chain := PasswordChain{
+		StaticUsers{{Name: "john", Password: "mypass"}},
+		passwordFunc(func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() != "alex" {
+				return nil, fmt.Errorf("unknown user")
+			}
+			return &ssh.Permissions{Extensions: map[string]string{"from": "func"}}, nil
+		}),
+	}
+	_, err := chain.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	assert.NoError(t, err)
+	perms, err := chain.PasswordCallback(connMetadata{user: "alex"}, []byte("any"))
+	require.NoError(t, err)
+	assert.Equal(t, "func", perms.Extensions["from"])
+	_, err = chain.PasswordCallback(connMetadata{user: "bob"}, []byte("mypass"))
+	assert.EqualError(t, err, "unknown user")
+}
+
+func TestAuthorizedKeysFiles(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "john"), append([]byte("# comment\n"), ssh.MarshalAuthorizedKey(signer.PublicKey())...), 0600))
+
+	files := AuthorizedKeysFiles{filepath.Join(dir, "%u")}
+	_, err = files.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.NoError(t, err)
+	_, err = files.PublicKeyCallback(connMetadata{user: "alex"}, signer.PublicKey())
+	assert.Error(t, err)
+	_, err = files.PublicKeyCallback(connMetadata{user: "../" + filepath.Base(dir) + "/john"}, signer.PublicKey())
+	assert.Error(t, err)
+}
+
+func TestAuthorizedKeysURL(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	var requests []string
+	failing := false
+	httpServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.EscapedPath()+" "+r.Header.Get("If-None-Match"))
+		switch {
+		case failing:
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path != "/keys/john":
+			w.WriteHeader(http.StatusNotFound)
+		case r.Header.Get("If-None-Match") == `"v1"`:
+			w.WriteHeader(http.StatusNotModified)
+		default:
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(append([]byte("# comment\n"), ssh.MarshalAuthorizedKey(signer.PublicKey())...))
+		}
+	}))
+	defer httpServer.Close()
+	now := time.Now()
+	a := &AuthorizedKeysURL{URL: httpServer.URL + "/keys/%u", CacheDuration: time.Minute, now: func() time.Time { return now }}
+
+	_, err = a.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.NoError(t, err)
+	_, err = a.PublicKeyCallback(connMetadata{user: "alex"}, signer.PublicKey())
+	assert.Error(t, err)
+	_, err = a.PublicKeyCallback(connMetadata{user: "john.doe"}, signer.PublicKey())
+	assert.Error(t, err)
+	// Dot segments are rejected without requests
+	for _, userName := range []string{".", "..", "../john", "a/../john", `..\john`} {
+		_, err = a.PublicKeyCallback(connMetadata{user: userName}, signer.PublicKey())
+		assert.ErrorContains(t, err, "invalid user name for authorized keys URL")
+	}
+	// Cached
+	_, err = a.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/keys/john ", "/keys/alex ", "/keys/john.doe "}, requests)
+
+	// Revalidated by the ETag
+	now = now.Add(time.Minute)
+	_, err = a.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.NoError(t, err)
+	assert.Equal(t, `/keys/john "v1"`, requests[len(requests)-1])
+
+	// Failures reject keys unless failing open
+	now = now.Add(time.Minute)
+	failing = true
+	_, err = a.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.ErrorContains(t, err, "unexpected status: 500")
+	a.FailOpen = true
+	_, err = a.PublicKeyCallback(connMetadata{user: "john"}, signer.PublicKey())
+	assert.NoError(t, err)
+	_, err = a.PublicKeyCallback(connMetadata{user: "bob"}, signer.PublicKey())
+	assert.Error(t, err)
+}
+
+func TestWithExtensions(t *testing.T) {
+	config := &ssh.ServerConfig{
+		PasswordCallback: StaticUsers{{Name: "john", Password: "mypass"}}.PasswordCallback,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{"key": "callback"}}, nil
+		},
+	}
+	WithExtensions(config, func(conn ssh.ConnMetadata) map[string]string {
+		return map[string]string{"key": conn.User(), "other": "value"}
+	})
+	perms, err := config.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": "john", "other": "value"}, perms.Extensions)
+	_, err = config.PasswordCallback(connMetadata{user: "john"}, []byte("wrong"))
+	assert.Error(t, err)
+	// Extensions of the callback are kept
+	perms, err = config.NoClientAuthCallback(connMetadata{user: "john"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"key": "callback", "other": "value"}, perms.Extensions)
+}
+
+type acceptAll struct{}
+
+func (acceptAll) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	return nil, nil
+}
+
+func TestKeyStrength(t *testing.T) {
+	publicKey := func(key any) ssh.PublicKey {
+		publicKey, err := ssh.NewPublicKey(key)
+		require.NoError(t, err)
+		return publicKey
+	}
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+	rsa2048, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var dsaKey dsa.PrivateKey
+	require.NoError(t, dsa.GenerateParameters(&dsaKey.Parameters, rand.Reader, dsa.L1024N160))
+	require.NoError(t, dsa.GenerateKey(&dsaKey, rand.Reader))
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	k := &KeyStrength{Authenticator: acceptAll{}, MinRSABits: 2048}
+	assert.NoError(t, k.Check(publicKey(&rsa2048.PublicKey)))
+	assert.EqualError(t, k.Check(publicKey(&rsa1024.PublicKey)), "RSA key of 1024 bits is shorter than 2048 bits")
+	assert.EqualError(t, k.Check(&ssh.Certificate{Key: publicKey(&rsa1024.PublicKey)}), "RSA key of 1024 bits is shorter than 2048 bits")
+	assert.EqualError(t, k.Check(publicKey(&dsaKey.PublicKey)), "DSA keys are not allowed")
+	assert.NoError(t, k.Check(publicKey(&ecdsaKey.PublicKey)))
+	assert.NoError(t, k.Check(publicKey(ed25519Key)))
+	_, err = k.PublicKeyCallback(connMetadata{user: "john"}, publicKey(&rsa1024.PublicKey))
+	assert.EqualError(t, err, `public key rejected for "john": RSA key of 1024 bits is shorter than 2048 bits`)
+	_, err = k.PublicKeyCallback(connMetadata{user: "john"}, publicKey(ed25519Key))
+	assert.NoError(t, err)
+
+	k = &KeyStrength{Authenticator: acceptAll{}, AllowDSA: true, DenyECDSA: true}
+	assert.NoError(t, k.Check(publicKey(&rsa1024.PublicKey)))
+	assert.NoError(t, k.Check(publicKey(&dsaKey.PublicKey)))
+	assert.EqualError(t, k.Check(publicKey(&ecdsaKey.PublicKey)), "ECDSA keys of NIST curves are not allowed")
+}
+
+func TestUserAccess(t *testing.T) {
+	addr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	}
+	groups := map[string][]string{"john": {"john", "ssh-users"}, "alex": {"alex", "guests"}, "bob": {"bob"}}
+	access := &UserAccess{
+		AllowUsers:  []string{"john", "alex", "bob", "deploy@10.0.0.0/8", "ci-*@192.168.1.?"},
+		DenyUsers:   []string{"root"},
+		AllowGroups: []string{"ssh-*", "deploy", "ci-*"},
+		DenyGroups:  []string{"guests"},
+		Groups: func(user string) ([]string, error) {
+			if groups, ok := groups[user]; ok {
+				return groups, nil
+			}
+			return []string{user}, nil
+		},
+	}
+	assert.NoError(t, access.Check("john", addr("192.168.0.1")))
+	assert.EqualError(t, access.Check("root", addr("192.168.0.1")), `user denied by pattern "root"`)
+	assert.EqualError(t, access.Check("alex", addr("192.168.0.1")), `group guests denied by pattern "guests"`)
+	assert.EqualError(t, access.Check("bob", addr("192.168.0.1")), "no groups allowed")
+	assert.NoError(t, access.Check("deploy", addr("10.1.2.3")))
+	assert.EqualError(t, access.Check("deploy", addr("192.168.0.1")), "user not allowed")
+	assert.EqualError(t, access.Check("ci-runner", addr("192.168.1.10")), "user not allowed")
+	assert.EqualError(t, access.Check("ci", addr("192.168.1.1")), "user not allowed")
+	assert.NoError(t, access.Check("ci-runner", addr("192.168.1.1")))
+	assert.NoError(t, (&UserAccess{}).Check("root", addr("192.168.0.1")))
+
+	called := false
+	config := &ssh.ServerConfig{PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		called = true
+		return nil, nil
+	}}
+	access.Apply(config)
+	_, err := config.PasswordCallback(connMetadata{user: "root", addr: addr("192.168.0.1")}, []byte("mypass"))
+	assert.EqualError(t, err, `"root" denied: user denied by pattern "root"`)
+	assert.False(t, called)
+	_, err = config.PasswordCallback(connMetadata{user: "john", addr: addr("192.168.0.1")}, []byte("mypass"))
+	assert.NoError(t, err)
+	assert.True(t, called)
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthorizedKeysFiles authenticates users by public keys in authorized_keys files of OpenSSH format.
+// Each path can contain %u (the user name), %h (the home directory of the OS user) and %%.
+// Relative paths are relative to the home directory like AuthorizedKeysFile of sshd_config.
+type AuthorizedKeysFiles []string
+
+func (a AuthorizedKeysFiles) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	keyBytes := key.Marshal()
+	home := ""
+	if u, err := user.Lookup(conn.User()); err == nil {
+		home = u.HomeDir
+	}
+	for _, pattern := range a {
+		path, ok := expandAuthorizedKeysPath(pattern, conn.User(), home)
+		if !ok {
+			continue
+		}
+		rest, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for len(rest) > 0 {
+			var authorizedKey ssh.PublicKey
+			authorizedKey, _, _, rest, err = ssh.ParseAuthorizedKey(rest)
+			if err != nil {
+				break
+			}
+			if bytes.Equal(authorizedKey.Marshal(), keyBytes) {
+				return nil, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("public key rejected for %q", conn.User())
+}
+
+// expandAuthorizedKeysPath returns the path of the user. It returns false if the home directory is required but unknown.
+func expandAuthorizedKeysPath(pattern, userName, home string) (string, bool) {
+	// User names can not escape the directory
+	if strings.ContainsAny(userName, `/\`) || userName == ".." {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'u':
+			b.WriteString(userName)
+		case 'h':
+			if home == "" {
+				return "", false
+			}
+			b.WriteString(home)
+		default:
+			b.WriteByte(pattern[i])
+		}
+	}
+	path := b.String()
+	if !filepath.IsAbs(path) {
+		if home == "" {
+			return "", false
+		}
+		path = filepath.Join(home, path)
+	}
+	return path, true
+}
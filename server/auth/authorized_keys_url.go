@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	// DefaultAuthorizedKeysCacheDuration is the time fetched keys are used without revalidation if AuthorizedKeysURL.CacheDuration is 0
+	DefaultAuthorizedKeysCacheDuration = 5 * time.Minute
+	// DefaultAuthorizedKeysTimeout is the time limit of fetching the keys of a user if AuthorizedKeysURL.Client is nil
+	DefaultAuthorizedKeysTimeout = 10 * time.Second
+	// maxAuthorizedKeysSize is the maximum size of a response of authorized keys
+	maxAuthorizedKeysSize = 1 << 20
+	// authorizedKeysPruneInterval is the interval to forget expired entries of users without keys
+	authorizedKeysPruneInterval = time.Minute
+)
+
+// AuthorizedKeysURL authenticates users by public keys in authorized_keys format fetched over HTTP(S), e.g. from a central key service.
+// URL can contain %u (the path-escaped user name) and %%. User names that are dot segments (e.g. "..") are rejected. Keys are cached for CacheDuration and revalidated by their ETags after it.
+// A 404 response means the user has no keys. Other failures reject keys unless FailOpen.
+type AuthorizedKeysURL struct {
+	// URL is the template of the URL of the keys of a user (e.g. "https://keys.example.com/%u")
+	URL string
+	// Client is a client with DefaultAuthorizedKeysTimeout if nil
+	Client *http.Client
+	// CacheDuration is the time fetched keys are used without revalidation (default: DefaultAuthorizedKeysCacheDuration)
+	CacheDuration time.Duration
+	// FailOpen uses the last keys fetched for the user, however old, when the endpoint fails. Users never fetched are rejected anyway.
+	FailOpen bool
+	// Logger logs failures to fetch keys if not nil
+	Logger *slog.Logger
+
+	mu     sync.Mutex
+	cache  map[string]*cachedAuthorizedKeys
+	pruned time.Time
+	// now is time.Now, replaced by tests
+	now func() time.Time
+}
+
+// cachedAuthorizedKeys are the keys of a user fetched at fetched
+type cachedAuthorizedKeys struct {
+	keys    []ssh.PublicKey
+	etag    string
+	fetched time.Time
+}
+
+func (a *AuthorizedKeysURL) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	keys, err := a.Keys(conn.User())
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := key.Marshal()
+	for _, authorizedKey := range keys {
+		if bytes.Equal(authorizedKey.Marshal(), keyBytes) {
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("public key rejected for %q", conn.User())
+}
+
+// Keys returns the keys of userName from the cache, or fetched from the endpoint after CacheDuration.
+func (a *AuthorizedKeysURL) Keys(userName string) ([]ssh.PublicKey, error) {
+	now := a.clock()
+	a.mu.Lock()
+	cached := a.cache[userName]
+	a.mu.Unlock()
+	if cached != nil && now.Sub(cached.fetched) < a.cacheDuration() {
+		return cached.keys, nil
+	}
+	fetched, err := a.fetch(userName, cached)
+	if err != nil {
+		if a.Logger != nil {
+			a.Logger.Warn("failed to fetch authorized keys", "user", userName, "err", err, "fail_open", a.FailOpen)
+		}
+		if a.FailOpen && cached != nil {
+			return cached.keys, nil
+		}
+		return nil, fmt.Errorf("public key rejected for %q: %w", userName, err)
+	}
+	fetched.fetched = now
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.cache == nil {
+		a.cache = map[string]*cachedAuthorizedKeys{}
+	}
+	a.prune(now)
+	a.cache[userName] = fetched
+	return fetched.keys, nil
+}
+
+// fetch requests the keys of userName, revalidating cached by its ETag if not nil
+func (a *AuthorizedKeysURL) fetch(userName string, cached *cachedAuthorizedKeys) (*cachedAuthorizedKeys, error) {
+	u, err := expandAuthorizedKeysURL(a.URL, userName)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && cached.etag != "" {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: DefaultAuthorizedKeysTimeout}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		return &cachedAuthorizedKeys{keys: cached.keys, etag: cached.etag}, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return &cachedAuthorizedKeys{}, nil
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxAuthorizedKeysSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxAuthorizedKeysSize {
+		return nil, errors.New("too large authorized keys")
+	}
+	return &cachedAuthorizedKeys{keys: ParseAuthorizedKeys(body), etag: resp.Header.Get("ETag")}, nil
+}
+
+// ParseAuthorizedKeys returns the keys of lines in authorized_keys format. Invalid lines, such as comments, are skipped.
+func ParseAuthorizedKeys(b []byte) []ssh.PublicKey {
+	var keys []ssh.PublicKey
+	for _, line := range bytes.Split(b, []byte("\n")) {
+		if key, _, _, _, err := ssh.ParseAuthorizedKey(line); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// expandAuthorizedKeysURL returns the URL of pattern for userName
+func expandAuthorizedKeysURL(pattern, userName string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'u':
+			// PathEscape keeps dots, so "." and ".." would be resolved as dot segments of the path
+			if hasDotSegment(userName) {
+				return "", fmt.Errorf("invalid user name for authorized keys URL: %q", userName)
+			}
+			b.WriteString(url.PathEscape(userName))
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown escape in authorized keys URL: %%%c", pattern[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// hasDotSegment reports whether userName is or contains a "." or ".." path segment
+func hasDotSegment(userName string) bool {
+	for _, segment := range strings.FieldsFunc(userName, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// prune forgets expired entries of users without keys every authorizedKeysPruneInterval not to grow by unknown users.
+// Entries with keys are kept for FailOpen.
+func (a *AuthorizedKeysURL) prune(now time.Time) {
+	if now.Sub(a.pruned) < authorizedKeysPruneInterval {
+		return
+	}
+	a.pruned = now
+	for userName, cached := range a.cache {
+		if len(cached.keys) == 0 && now.Sub(cached.fetched) >= a.cacheDuration() {
+			delete(a.cache, userName)
+		}
+	}
+}
+
+func (a *AuthorizedKeysURL) cacheDuration() time.Duration {
+	if a.CacheDuration <= 0 {
+		return DefaultAuthorizedKeysCacheDuration
+	}
+	return a.CacheDuration
+}
+
+func (a *AuthorizedKeysURL) clock() time.Time {
+	if a.now != nil {
+		return a.now()
+	}
+	return time.Now()
+}
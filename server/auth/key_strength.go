@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// KeyStrength rejects weak public keys before Authenticator tries them.
+// The keys of certificates are checked.
+type KeyStrength struct {
+	Authenticator PublicKeyAuthenticator
+	// MinRSABits is the minimum size of RSA keys. 0 allows any size.
+	MinRSABits int
+	// AllowDSA allows DSA keys, which are limited to 1024 bits
+	AllowDSA bool
+	// DenyECDSA rejects ECDSA keys of NIST curves including ones of security keys
+	DenyECDSA bool
+	// Logger logs rejected keys if not nil
+	Logger *slog.Logger
+}
+
+// Check returns an error if key is weak.
+func (k *KeyStrength) Check(key ssh.PublicKey) error {
+	if cert, ok := key.(*ssh.Certificate); ok {
+		key = cert.Key
+	}
+	switch key.Type() {
+	case ssh.KeyAlgoRSA:
+		if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok && rsaKey.N.BitLen() < k.MinRSABits {
+				return fmt.Errorf("RSA key of %d bits is shorter than %d bits", rsaKey.N.BitLen(), k.MinRSABits)
+			}
+		}
+	case ssh.KeyAlgoDSA:
+		if !k.AllowDSA {
+			return fmt.Errorf("DSA keys are not allowed")
+		}
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoSKECDSA256:
+		if k.DenyECDSA {
+			return fmt.Errorf("ECDSA keys of NIST curves are not allowed")
+		}
+	}
+	return nil
+}
+
+func (k *KeyStrength) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	if err := k.Check(key); err != nil {
+		if k.Logger != nil {
+			k.Logger.Info("rejected weak public key", "user", conn.User(), "remote_address", conn.RemoteAddr().String(), "key_type", key.Type(), "fingerprint", ssh.FingerprintSHA256(key), "reason", err)
+		}
+		return nil, fmt.Errorf("public key rejected for %q: %w", conn.User(), err)
+	}
+	return k.Authenticator.PublicKeyCallback(conn, key)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"github.com/John-Ao/go-sshd/server/forward"
+
+	"golang.org/x/exp/slog"
+)
+
+// Action types passed to Authorizer
+const (
+	ActionShell              = "shell"
+	ActionExec               = "exec"
+	ActionSftp               = "sftp"
+	ActionAttach             = "attach"
+	ActionDirectTcpip        = forward.TypeDirectTcpip
+	ActionTcpipForward       = forward.TypeTcpipForward
+	ActionDirectStreamlocal  = forward.TypeDirectStreamlocal
+	ActionStreamlocalForward = forward.TypeStreamlocalForward
+)
+
+// Action is a sensitive action requested by a client.
+type Action struct {
+	Type       string `json:"type"`
+	User       string `json:"user"`
+	RemoteAddr string `json:"remote_address,omitempty"`
+	// Command is the command of "shell", "exec" and "attach"
+	Command []string `json:"command,omitempty"`
+	// RawCommand is the command line of "exec" or the forced command, which the shell runs as is on Windows
+	RawCommand string `json:"raw_command,omitempty"`
+	// Operation is the SFTP request method (e.g. "Get", "Put", "Remove", "List")
+	Operation string `json:"operation,omitempty"`
+	// Path is the SFTP file path or the Unix domain socket path
+	Path string `json:"path,omitempty"`
+	// TargetPath is the new path of SFTP "Rename", "Link" and "Symlink"
+	TargetPath string `json:"target_path,omitempty"`
+	// TargetUser is the user of the session of "attach"
+	TargetUser string `json:"target_user,omitempty"`
+	// Host and Port are the destination of "direct-tcpip" or the bind address of "tcpip-forward"
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+}
+
+// Authorizer decides whether an action allowed by permissions is performed.
+type Authorizer interface {
+	Authorize(action *Action) (bool, error)
+}
+
+// authorize reports whether the action is allowed. It is denied when Authorizer fails.
+func (s *Server) authorize(logger *slog.Logger, conn *connection, action *Action) bool {
+	if s.Authorizer == nil {
+		return true
+	}
+	if conn.sshConn != nil {
+		action.User = conn.sshConn.User()
+		action.RemoteAddr = conn.sshConn.RemoteAddr().String()
+	}
+	allowed, err := s.Authorizer.Authorize(action)
+	if err != nil {
+		logger.Error("failed to authorize", "action", action.Type, "err", err)
+		return false
+	}
+	if !allowed {
+		logger.Info("action denied", "action", action.Type)
+	}
+	return allowed
+}
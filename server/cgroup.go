@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CgroupLimits are cgroup v2 controls of the processes of a session. 0 is no control.
+type CgroupLimits struct {
+	// CPUWeight is cpu.weight from 1 to 10000, 100 by default
+	CPUWeight uint64
+	// MemoryMax is memory.max in bytes
+	MemoryMax uint64
+	// PidsMax is pids.max
+	PidsMax uint64
+}
+
+// ParseCgroupLimits parses comma-separated controls like "cpu.weight=50,memory.max=512M,pids.max=128".
+// "memory.max" can have a suffix of "K", "M" or "G".
+func ParseCgroupLimits(s string) (CgroupLimits, error) {
+	var limits CgroupLimits
+	if s == "" {
+		return limits, nil
+	}
+	for _, limit := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(limit, "=")
+		if !ok || value == "" {
+			return CgroupLimits{}, fmt.Errorf("invalid cgroup limit: %s", limit)
+		}
+		var field *uint64
+		parse := parseCount
+		switch name {
+		case "cpu.weight":
+			field = &limits.CPUWeight
+		case "memory.max":
+			field, parse = &limits.MemoryMax, parseSize
+		case "pids.max":
+			field = &limits.PidsMax
+		default:
+			return CgroupLimits{}, fmt.Errorf("unknown cgroup limit: %s", name)
+		}
+		n, err := parse(value)
+		if err != nil || (field == &limits.CPUWeight && n > 10000) {
+			return CgroupLimits{}, fmt.Errorf("invalid value of %s: %s", name, value)
+		}
+		*field = n
+	}
+	return limits, nil
+}
+
+// String returns limits in the form of ParseCgroupLimits.
+func (l CgroupLimits) String() string {
+	var limits []string
+	for _, limit := range l.files() {
+		limits = append(limits, limit.name+"="+strconv.FormatUint(limit.value, 10))
+	}
+	return strings.Join(limits, ",")
+}
+
+// IsZero reports whether no limits are set.
+func (l CgroupLimits) IsZero() bool {
+	return l == CgroupLimits{}
+}
+
+// or fills limits not set in l with the ones of other
+func (l CgroupLimits) or(other CgroupLimits) CgroupLimits {
+	if l.CPUWeight == 0 {
+		l.CPUWeight = other.CPUWeight
+	}
+	if l.MemoryMax == 0 {
+		l.MemoryMax = other.MemoryMax
+	}
+	if l.PidsMax == 0 {
+		l.PidsMax = other.PidsMax
+	}
+	return l
+}
+
+type cgroupFile struct {
+	// controller is the controller to enable in the parent
+	controller string
+	name       string
+	value      uint64
+}
+
+// files returns the interface files of the limits set
+func (l CgroupLimits) files() []cgroupFile {
+	var files []cgroupFile
+	for _, file := range []cgroupFile{{"cpu", "cpu.weight", l.CPUWeight}, {"memory", "memory.max", l.MemoryMax}, {"pids", "pids.max", l.PidsMax}} {
+		if file.value != 0 {
+			files = append(files, file)
+		}
+	}
+	return files
+}
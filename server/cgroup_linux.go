@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// cgroup is a cgroup v2 of a session
+type cgroup struct {
+	path string
+	dir  *os.File
+}
+
+// newCgroup creates a cgroup with limits under parent, enabling the controllers of limits in parent
+func newCgroup(parent string, limits CgroupLimits) (*cgroup, error) {
+	files := limits.files()
+	var controllers []string
+	for _, file := range files {
+		controllers = append(controllers, "+"+file.controller)
+	}
+	if len(controllers) != 0 {
+		if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0); err != nil {
+			return nil, fmt.Errorf("failed to enable controllers: %w", err)
+		}
+	}
+	path, err := os.MkdirTemp(parent, "session-")
+	if err != nil {
+		return nil, err
+	}
+	c := &cgroup{path: path}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(path, file.name), []byte(strconv.FormatUint(file.value, 10)), 0); err != nil {
+			c.remove()
+			return nil, fmt.Errorf("failed to set %s: %w", file.name, err)
+		}
+	}
+	if c.dir, err = os.Open(path); err != nil {
+		c.remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// attach makes cmd start in the cgroup
+func (c *cgroup) attach(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(c.dir.Fd())
+}
+
+// remove kills the processes left in the cgroup and removes it
+func (c *cgroup) remove() error {
+	if c.dir != nil {
+		c.dir.Close()
+	}
+	if err := os.WriteFile(filepath.Join(c.path, "cgroup.kill"), []byte("1"), 0); err != nil {
+		// cgroup.kill is since Linux 5.14
+		c.kill()
+	}
+	var err error
+	for i := 0; i < 100; i++ {
+		if err = syscall.Rmdir(c.path); err == nil || errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("failed to remove cgroup %s: %w", c.path, err)
+}
+
+// kill kills the processes in the cgroup
+func (c *cgroup) kill() {
+	procs, err := os.ReadFile(filepath.Join(c.path, "cgroup.procs"))
+	if err != nil {
+		return
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(procs))
+	for scanner.Scan() {
+		if pid, err := strconv.Atoi(scanner.Text()); err == nil {
+			syscall.Kill(pid, syscall.SIGKILL)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+type cgroup struct{}
+
+func newCgroup(parent string, limits CgroupLimits) (*cgroup, error) {
+	return nil, fmt.Errorf("cgroups are not supported on this platform")
+}
+
+func (c *cgroup) attach(cmd *exec.Cmd) {}
+
+func (c *cgroup) remove() error { return nil }
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"fmt"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// expandChrootDirectory returns the directory of pattern for userName. %u is the user name, %h the home directory and %% "%".
+func expandChrootDirectory(pattern, userName string) (string, error) {
+	// User names can not escape the directory
+	if strings.ContainsAny(userName, `/\`) || userName == ".." || userName == "." {
+		return "", fmt.Errorf("invalid user name for chroot: %q", userName)
+	}
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		switch pattern[i] {
+		case 'u':
+			b.WriteString(userName)
+		case 'h':
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return "", fmt.Errorf("home directory for chroot: %w", err)
+			}
+			b.WriteString(u.HomeDir)
+		case '%':
+			b.WriteByte('%')
+		default:
+			return "", fmt.Errorf("unknown escape in chroot directory: %%%c", pattern[i])
+		}
+	}
+	dir := b.String()
+	if !filepath.IsAbs(dir) {
+		return "", fmt.Errorf("chroot directory is not absolute: %s", dir)
+	}
+	return filepath.Clean(dir), nil
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+)
+
+// setChroot makes cmd run in dir after checking dir like ChrootDirectory of sshd_config.
+// The working directory of cmd is in dir and "/" if empty.
+func setChroot(cmd *exec.Cmd, dir string) error {
+	if err := checkChrootDirectory(dir); err != nil {
+		return err
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Chroot = dir
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+	}
+	return nil
+}
+
+// checkChrootDirectory returns an error unless dir and its ancestors are directories owned by root and not writable by group or others
+func checkChrootDirectory(dir string) error {
+	for path := dir; ; path = filepath.Dir(path) {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("chroot directory: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("chroot directory %s is not a directory", path)
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 {
+			return fmt.Errorf("chroot directory %s is not owned by root", path)
+		}
+		if info.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("chroot directory %s is writable by group or others", path)
+		}
+		if path == filepath.Dir(path) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setChroot(cmd *exec.Cmd, dir string) error {
+	return fmt.Errorf("chroot unsupported")
+}
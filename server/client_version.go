@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ClientVersionPolicy allows or denies clients by their identification strings (e.g. "SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13")
+// before the key exchange. Patterns are matched against the whole string, where "*" matches any characters and "?" one.
+type ClientVersionPolicy struct {
+	// Allow are patterns one of which must match if not empty
+	Allow []string
+	// Deny are patterns none of which must match
+	Deny []string
+	// MinVersions are the minimum versions of software in "<software>_<version>" form (e.g. "OpenSSH_8.0").
+	// Clients of other software are not affected.
+	MinVersions []string
+}
+
+// compiledClientVersionPolicy is ClientVersionPolicy with the patterns compiled
+type compiledClientVersionPolicy struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+	// denyPatterns are the patterns of deny
+	denyPatterns []string
+	minVersions  map[string][]int
+}
+
+// compile returns the compiled policy or an error of invalid minimum versions. It returns nil if p allows all.
+func (p *ClientVersionPolicy) compile() (*compiledClientVersionPolicy, error) {
+	if len(p.Allow) == 0 && len(p.Deny) == 0 && len(p.MinVersions) == 0 {
+		return nil, nil
+	}
+	c := &compiledClientVersionPolicy{minVersions: map[string][]int{}}
+	for _, pattern := range p.Allow {
+		c.allow = append(c.allow, globRegexp(pattern))
+	}
+	for _, pattern := range p.Deny {
+		c.deny = append(c.deny, globRegexp(pattern))
+		c.denyPatterns = append(c.denyPatterns, pattern)
+	}
+	for _, minVersion := range p.MinVersions {
+		software, version, ok := splitSoftwareVersion(minVersion)
+		if !ok || len(version) == 0 {
+			return nil, fmt.Errorf("%s is not in the form of <software>_<version>", minVersion)
+		}
+		c.minVersions[software] = version
+	}
+	return c, nil
+}
+
+// Validate returns an error if p has invalid minimum versions.
+func (p *ClientVersionPolicy) Validate() error {
+	_, err := p.compile()
+	return err
+}
+
+// check returns an error if version is not allowed
+func (c *compiledClientVersionPolicy) check(version string) error {
+	for i, re := range c.deny {
+		if re.MatchString(version) {
+			return fmt.Errorf("client version denied by pattern %q", c.denyPatterns[i])
+		}
+	}
+	if len(c.allow) != 0 {
+		allowed := false
+		for _, re := range c.allow {
+			allowed = allowed || re.MatchString(version)
+		}
+		if !allowed {
+			return errors.New("client version not allowed")
+		}
+	}
+	// The software version is up to the comments after a space (RFC 4253 section 4.2)
+	softwareVersion, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(version, "SSH-2.0-"), "SSH-1.99-"), " ")
+	if software, v, ok := splitSoftwareVersion(softwareVersion); ok {
+		if minVersion, ok := c.minVersions[software]; ok && compareVersions(v, minVersion) < 0 {
+			return fmt.Errorf("client version older than %s_%s", software, joinVersion(minVersion))
+		}
+	}
+	return nil
+}
+
+// globRegexp returns the regexp of the whole string matching pattern
+func globRegexp(pattern string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(pattern)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+	return regexp.MustCompile("^" + quoted + "$")
+}
+
+// splitSoftwareVersion splits "OpenSSH_9.6p1" into "OpenSSH" and [9 6]. The numbers end at the first non-digit of each part.
+func splitSoftwareVersion(s string) (string, []int, bool) {
+	i := strings.LastIndexByte(s, '_')
+	if i <= 0 {
+		return "", nil, false
+	}
+	var version []int
+	for _, part := range strings.Split(s[i+1:], ".") {
+		end := 0
+		for end < len(part) && '0' <= part[end] && part[end] <= '9' {
+			end++
+		}
+		n, err := strconv.Atoi(part[:end])
+		if err != nil {
+			break
+		}
+		version = append(version, n)
+		if end != len(part) {
+			break
+		}
+	}
+	return s[:i], version, true
+}
+
+// compareVersions compares versions by numbers, treating missing ones as 0
+func compareVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func joinVersion(version []int) string {
+	parts := make([]string, len(version))
+	for i, n := range version {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ".")
+}
+
+// maxVersionLineBytes is the bytes before the identification string beyond which it is not checked.
+// ssh.NewServerConn rejects such long input.
+const maxVersionLineBytes = 4096
+
+// versionConn reads the identification string of the client and fails reading it if check returns an error
+type versionConn struct {
+	net.Conn
+	check func(version string) error
+	// buf is read before the identification string
+	buf     []byte
+	done    bool
+	version string
+}
+
+func (c *versionConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if c.done || n == 0 {
+		return n, err
+	}
+	c.buf = append(c.buf, p[:n]...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			if len(c.buf) > maxVersionLineBytes {
+				c.done = true
+				c.buf = nil
+			}
+			return n, err
+		}
+		line := strings.TrimRight(string(c.buf[:i]), "\r")
+		c.buf = c.buf[i+1:]
+		if strings.HasPrefix(line, "SSH-") {
+			c.done = true
+			c.buf = nil
+			c.version = line
+			if checkErr := c.check(line); checkErr != nil {
+				return 0, checkErr
+			}
+			return n, err
+		}
+	}
+}
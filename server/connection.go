@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/John-Ao/go-sshd/geoip"
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/sync_generics"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// connection is the state of an SSH connection served by Server.
+type connection struct {
+	// sshConn is nil when the connection is unknown (HandleChannels)
+	sshConn *ssh.ServerConn
+	id      string
+	// logger carries the connection ID, the user and the remote address
+	logger         *slog.Logger
+	lastChannelID  atomic.Uint64
+	lastActivityID atomic.Uint64
+	sessions       sync_generics.Map[string, *activeSession]
+	forwards       sync_generics.Map[string, *activeForward]
+	activeChannels atomic.Int64
+	startTime      time.Time
+	permissions    permissions
+	denyPty        bool
+	// shell and homeDir are empty when not specified for the user
+	shell       string
+	homeDir     string
+	maxSessions int
+	// maxConnSessions limits openSessions if not 0
+	maxConnSessions int
+	openSessions    atomic.Int64
+	// forceCommand is the command run instead of the requested ones if not empty
+	forceCommand string
+	// chrootDirectory is ProcessSpec.ChrootDirectory of processes
+	chrootDirectory string
+	// resourceLimits are the limits of processes
+	resourceLimits ResourceLimits
+	// cgroupLimits are the cgroup controls of processes
+	cgroupLimits CgroupLimits
+	// namespaces are the namespaces of processes
+	namespaces namespaces.Namespaces
+	// location is the location of the client by Server.GeoIP
+	location geoip.Location
+	// traffic counts the traffic of the user. It is nil when the connection is unknown.
+	traffic *trafficCounters
+	// transferLimit closes the connection over the bytes of MaxTransfer if not nil
+	transferLimit *transferLimit
+	// metadata is passed to handlers
+	metadata *ConnMetadata
+	// lifecycle tracks the goroutines and the processes serving the connection
+	lifecycle *lifecycle
+	// channelLimiter and forwardLimiter limit the channels and the forwarding channels opened by the client if not nil
+	channelLimiter *rateLimiter
+	forwardLimiter *rateLimiter
+	// rateLimited is the number of channels rejected by them
+	rateLimited atomic.Int64
+}
+
+func (s *Server) newConnection(sshConn *ssh.ServerConn) *connection {
+	id := uuid.New().String()
+	var traffic *trafficCounters
+	var location geoip.Location
+	if sshConn != nil {
+		traffic, _ = s.stats.userTraffic.LoadOrStore(sshConn.User(), new(trafficCounters))
+		location = s.location(sshConn.RemoteAddr())
+	}
+	conn := &connection{
+		sshConn:         sshConn,
+		id:              id,
+		logger:          connLogger(s.Logger, id, sshConn).With(locationAttrs(location)...),
+		startTime:       time.Now(),
+		permissions:     s.connPermissions(sshConn),
+		denyPty:         s.connDenyPty(sshConn),
+		shell:           s.connShell(sshConn),
+		homeDir:         extension(sshConn, ExtensionHomeDir),
+		maxSessions:     extensionInt(sshConn, ExtensionMaxSessions),
+		maxConnSessions: extensionInt(sshConn, ExtensionMaxConnSessions),
+		forceCommand:    s.connForceCommand(sshConn),
+		chrootDirectory: extension(sshConn, ExtensionChrootDirectory),
+		resourceLimits:  s.connResourceLimits(sshConn),
+		cgroupLimits:    s.connCgroupLimits(sshConn),
+		namespaces:      s.connNamespaces(sshConn),
+		location:        location,
+		traffic:         traffic,
+		metadata:        &ConnMetadata{ID: id, SSHConn: sshConn},
+		lifecycle:       newLifecycle(&s.stats),
+		channelLimiter:  newRateLimiter(s.ChannelRate),
+		forwardLimiter:  newRateLimiter(s.ForwardChannelRate),
+	}
+	conn.transferLimit = s.newTransferLimit(conn)
+	return conn
+}
+
+// allowChannel returns false if a channel of channelType is over the rate limits
+func (c *connection) allowChannel(channelType string) bool {
+	now := time.Now()
+	if isForwardChannelType(channelType) && !c.forwardLimiter.allow(now) {
+		return false
+	}
+	return c.channelLimiter.allow(now)
+}
+
+// sshEnv returns the environment variables describing the connection to processes like sshd: SSH_CONNECTION, SSH_CLIENT, USER and LOGNAME.
+// It is empty when the connection is unknown.
+func (c *connection) sshEnv() []string {
+	if c.sshConn == nil {
+		return nil
+	}
+	clientAddress, clientPort := addressPort(c.sshConn.RemoteAddr())
+	serverAddress, serverPort := addressPort(c.sshConn.LocalAddr())
+	user := c.sshConn.User()
+	return []string{
+		fmt.Sprintf("SSH_CONNECTION=%s %d %s %d", clientAddress, clientPort, serverAddress, serverPort),
+		fmt.Sprintf("SSH_CLIENT=%s %d %d", clientAddress, clientPort, serverPort),
+		"USER=" + user,
+		"LOGNAME=" + user,
+	}
+}
+
+// addressPort returns the IP address and the port of a TCP address, or "UNKNOWN" and 65535 like sshd for others such as Unix domain sockets
+func addressPort(addr net.Addr) (string, int) {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String(), tcpAddr.Port
+	}
+	return "UNKNOWN", 65535
+}
+
+// connLogger returns logger with the connection ID, the user and the remote address
+func connLogger(logger *slog.Logger, id string, sshConn *ssh.ServerConn) *slog.Logger {
+	logger = logger.With("conn_id", id)
+	if sshConn != nil {
+		logger = logger.With("user", sshConn.User(), "remote_address", sshConn.RemoteAddr().String())
+	}
+	return logger
+}
+
+// channelLogger returns a logger for a new channel of the connection
+func (c *connection) channelLogger(channelType string) *slog.Logger {
+	// Channel IDs are unique within the connection
+	return c.logger.With("channel_id", c.lastChannelID.Add(1), "channel_type", channelType)
+}
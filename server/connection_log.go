@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"golang.org/x/exp/slog"
+)
+
+// openConnectionLog makes the logger of conn also write to the writer of ConnectionLog. Call the returned function when conn is closed.
+func (s *Server) openConnectionLog(conn *connection) func() {
+	if s.ConnectionLog == nil {
+		return func() {}
+	}
+	w, err := s.ConnectionLog(conn.metadata, conn.startTime)
+	if err != nil {
+		conn.logger.Error("failed to open connection log", "err", err)
+		return func() {}
+	}
+	fileHandler := slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	conn.logger = connLogger(slog.New(&teeHandler{handlers: []slog.Handler{s.Logger.Handler(), fileHandler}}), conn.id, conn.sshConn)
+	return func() { w.Close() }
+}
+
+// teeHandler passes records to all handlers enabled for their levels
+type teeHandler struct {
+	handlers []slog.Handler
+}
+
+func (h *teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, handler := range h.handlers {
+		if handler.Enabled(ctx, r.Level) {
+			errs = append(errs, handler.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (h *teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return &teeHandler{handlers: handlers}
+}
+
+func (h *teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, len(h.handlers))
+	for i, handler := range h.handlers {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return &teeHandler{handlers: handlers}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// maxDebugPayload is the number of bytes of payloads logged at the debug level
+const maxDebugPayload = 256
+
+// debugPayload formats payload for logs, truncated to maxDebugPayload
+func debugPayload(payload []byte) string {
+	if len(payload) > maxDebugPayload {
+		return fmt.Sprintf("%q...(%d bytes)", payload[:maxDebugPayload], len(payload))
+	}
+	return fmt.Sprintf("%q", payload)
+}
+
+func debugEnabled(logger *slog.Logger) bool {
+	return logger.Enabled(context.Background(), slog.LevelDebug)
+}
+
+// debugNewChannel logs the lifecycle and requests of the channel at the debug level.
+type debugNewChannel struct {
+	ssh.NewChannel
+	logger *slog.Logger
+}
+
+func (c *debugNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	channel, reqs, err := c.NewChannel.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+	c.logger.Debug("channel accepted")
+	debugReqs := make(chan *ssh.Request)
+	go func() {
+		defer close(debugReqs)
+		for req := range reqs {
+			c.logger.Debug("channel request", "req_type", req.Type, "want_reply", req.WantReply, "payload", debugPayload(req.Payload))
+			debugReqs <- req
+		}
+		// Requests are closed with the channel
+		c.logger.Debug("channel closed")
+	}()
+	return channel, debugReqs, nil
+}
+
+func (c *debugNewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	c.logger.Debug("channel rejected", "reason", reason.String(), "message", message)
+	return c.NewChannel.Reject(reason, message)
+}
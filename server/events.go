@@ -0,0 +1,105 @@
+package server
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Event types
+const (
+	EventConnectionOpened = "connection-opened"
+	EventConnectionClosed = "connection-closed"
+	// EventAuth is an authentication attempt. Err is empty on success.
+	EventAuth           = "auth"
+	EventSessionStarted = "session-started"
+	EventSessionEnded   = "session-ended"
+	// EventTransfer is an SFTP session which has ended.
+	EventTransfer = "transfer"
+	// EventSftp is an SFTP request on paths.
+	EventSftp           = "sftp"
+	EventForwardStarted = "forward-started"
+	EventForwardEnded   = "forward-ended"
+)
+
+// Event is a structured event of Server. Fields not related to the type are zero.
+type Event struct {
+	Type       string
+	Time       time.Time
+	ConnID     string
+	User       string
+	RemoteAddr string
+	// Country and ASN are of RemoteAddr by Server.GeoIP
+	Country string
+	ASN     uint
+	// Method is the authentication method of EventAuth
+	Method string
+	// Err is the error of EventAuth
+	Err string
+	// Command and ExitCode are of sessions
+	Command  []string
+	ExitCode int
+	// ForwardType is the channel or request type of forwards (e.g. "direct-tcpip", "tcpip-forward")
+	ForwardType string
+	Host        string
+	Port        int
+	// Path is the Unix domain socket path of forwards or the path of EventSftp
+	Path string
+	// Operation and TargetPath are of EventSftp (e.g. "open", "remove", "rename")
+	Operation  string
+	TargetPath string
+	// BytesReceived and BytesSent are of EventTransfer
+	BytesReceived uint64
+	BytesSent     uint64
+}
+
+type subscriber struct {
+	events  chan Event
+	dropped atomic.Uint64
+}
+
+// Subscribe returns a channel of events and a function to unsubscribe.
+// Events are dropped instead of blocking Server when the buffer of the channel is full.
+func (s *Server) Subscribe(buffer int) (<-chan Event, func()) {
+	sub := &subscriber{events: make(chan Event, buffer)}
+	id := s.lastSubscriberID.Add(1)
+	s.subscribers.Store(id, sub)
+	var once atomic.Bool
+	return sub.events, func() {
+		if once.CompareAndSwap(false, true) {
+			s.subscribers.Delete(id)
+			s.subscribersMu.Lock()
+			close(sub.events)
+			s.subscribersMu.Unlock()
+		}
+	}
+}
+
+// publish sends the event of the connection to subscribers
+func (s *Server) publish(conn *connection, event Event) {
+	event.Time = time.Now()
+	if conn != nil {
+		event.ConnID = conn.id
+		if conn.sshConn != nil {
+			event.User = conn.sshConn.User()
+			event.RemoteAddr = conn.sshConn.RemoteAddr().String()
+		}
+		event.Country = conn.location.Country
+		event.ASN = conn.location.ASN
+	}
+	if s.OnEvent != nil {
+		s.OnEvent(event)
+	}
+	// Read lock prevents sending to a channel closed by unsubscribing
+	s.subscribersMu.RLock()
+	defer s.subscribersMu.RUnlock()
+	s.subscribers.Range(func(id uint64, sub *subscriber) bool {
+		select {
+		case sub.events <- event:
+		default:
+			if sub.dropped.Add(1) == 1 {
+				s.Logger.Warn("event dropped because subscriber is slow", "event_type", event.Type)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,419 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/rlimit"
+	"github.com/John-Ao/go-sshd/seccomp"
+	"github.com/John-Ao/go-sshd/server/session"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// Executor starts processes for "shell" and "exec" requests.
+// An Executor can choose a backend per user by ProcessSpec.User.
+type Executor interface {
+	Start(spec *ProcessSpec) (Process, error)
+}
+
+// ProcessSpec describes a process to start.
+type ProcessSpec struct {
+	// User is the user name of the connection. It is empty when the connection is unknown.
+	User string
+	// Command is the program and its arguments. It is the shell for "shell" requests.
+	Command []string
+	// RawCommand is the command line of the "exec" request, or the forced command by Server.ForceCommand. It is empty for "shell" requests without one.
+	RawCommand string
+	// Env is environment variables set by "env" requests in "key=value" form,
+	// followed by the ones of agent and X11 forwarding such as SSH_AUTH_SOCK and DISPLAY,
+	// and SSH_CONNECTION, SSH_CLIENT, USER, LOGNAME and SSH_ORIGINAL_COMMAND of the connection. LocalExecutor adds SSH_TTY with a pty.
+	Env []string
+	// Dir is the working directory. It is empty when not specified for the user.
+	Dir string
+	// Pty is the pseudo terminal to attach the process to. It is nil when not requested.
+	Pty *Pty
+	// Conn is the connection requesting the process.
+	Conn *ConnMetadata
+	// ResourceLimits are the limits of the process. LocalExecutor sets them before the command runs by starting the executable
+	// as the helper of rlimit.Command, so its main must call rlimit.RunHelper. They can not be used with ChrootDirectory.
+	ResourceLimits ResourceLimits
+	// CgroupLimits are the cgroup controls of the process and its descendants.
+	CgroupLimits CgroupLimits
+	// Namespaces are the Linux namespaces to run the process in.
+	Namespaces namespaces.Namespaces
+	// ChrootDirectory overrides ChrootDirectory of LocalExecutor for the user if not empty, and "none" disables it.
+	ChrootDirectory string
+}
+
+// Process is a process started by Executor. It can implement Pid() int to report its process ID in SessionInfo.
+type Process interface {
+	// Stdin returns the input of the process. It is the terminal when attached to a pty.
+	Stdin() io.WriteCloser
+	// Stdout returns the output of the process. It is the terminal when attached to a pty.
+	Stdout() io.Reader
+	// Stderr returns the error output of the process. It is nil when attached to a pty.
+	Stderr() io.Reader
+	// Signal sends sig to the process.
+	Signal(sig ssh.Signal) error
+	// Resize sets the size of the pty.
+	Resize(width, height uint32) error
+	// Wait waits for the process to exit and returns its exit code.
+	// Stdout and Stderr should be read until EOF before calling Wait.
+	Wait() (int, error)
+	// Close releases the process resources such as the pty. It makes the process exit when attached to a pty.
+	Close() error
+}
+
+// processPID returns the process ID of process if it implements Pid() int, or 0
+func processPID(process any) int {
+	if p, ok := process.(interface{ Pid() int }); ok {
+		return p.Pid()
+	}
+	return 0
+}
+
+// LocalExecutor starts processes on this machine.
+type LocalExecutor struct {
+	// PtyFactory starts processes attached to pseudo terminals. Local pseudo terminals are used if nil.
+	// ChrootDirectory, CgroupParent, SeccompProfile and SandboxUser apply only to local processes, not to the ones of PtyFactory.
+	PtyFactory PtyFactory
+	// ChrootDirectory is the directory to chroot processes into if not empty, which requires root.
+	// %u is the user name and %h the home directory. The directory and its ancestors must be owned by root
+	// and not writable by group or others like ChrootDirectory of sshd_config. Dir of ProcessSpec is in it.
+	// Root can escape chroot, so the processes run as SandboxUser, or the OS user of the user name without it, which must not be root.
+	ChrootDirectory string
+	// CgroupParent is a cgroup v2 directory on Linux such as "/sys/fs/cgroup/go-sshd" to create a cgroup per process in if not empty.
+	// The cgroup has CgroupLimits of ProcessSpec and is removed with the processes left in it when the process exits.
+	CgroupParent string
+	// SeccompProfile is the path of a seccomp profile to run processes under on Linux if not empty.
+	// The executable is started as the helper of seccomp.Command, so its main must call seccomp.RunHelper.
+	// It can not be used with ChrootDirectory, in which the executable is not found.
+	SeccompProfile string
+	// SandboxUser is the user to run processes as if not nil, which requires root. They start in its home directory, or "/" without it, unless Dir of ProcessSpec is set.
+	SandboxUser *SandboxUser
+	// Privileged runs f starting a process if not nil, e.g. daemon.RunPrivileged on the thread keeping the capabilities
+	// to start processes as SandboxUser or in ChrootDirectory after the server dropped privileges.
+	Privileged func(f func() error) error
+	// WindowsExecShell runs RawCommand of "exec" requests on Windows with the command line as is, WindowsExecCmd by default or WindowsExecPowerShell.
+	// Command parsed by shellwords is run on other platforms.
+	WindowsExecShell string
+}
+
+func (e *LocalExecutor) Start(spec *ProcessSpec) (Process, error) {
+	if len(spec.Command) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	if spec.Pty != nil && e.PtyFactory != nil {
+		ptyProcess, err := e.PtyFactory.Start(spec)
+		if err != nil {
+			return nil, err
+		}
+		// The process of PtyFactory may run briefly without the limits
+		if err := limitProcess(processPID(ptyProcess), spec.ResourceLimits); err != nil {
+			ptyProcess.Close()
+			return nil, err
+		}
+		return startedPtyProcess(ptyProcess, spec)
+	}
+	cmd, err := execCommand(spec, e.WindowsExecShell)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Dir = spec.Dir
+	cmd.Env = append(os.Environ(), spec.Env...)
+	chrootDirectory := e.ChrootDirectory
+	switch spec.ChrootDirectory {
+	case "":
+	case "none":
+		chrootDirectory = ""
+	default:
+		chrootDirectory = spec.ChrootDirectory
+	}
+	runAs := e.SandboxUser
+	if chrootDirectory != "" {
+		if runAs == nil {
+			if runAs, err = LookupSandboxUser(spec.User); err != nil {
+				return nil, fmt.Errorf("chroot requires the OS user or a sandbox user: %w", err)
+			}
+		}
+		if runAs.UID == 0 {
+			return nil, fmt.Errorf("chroot refused for %s, who can escape it as root", runAs.Name)
+		}
+	}
+	if runAs != nil {
+		if err := setCredential(cmd, runAs); err != nil {
+			return nil, err
+		}
+		if cmd.Dir == "" && chrootDirectory == "" {
+			cmd.Dir = runAs.workingDir()
+		}
+	}
+	if chrootDirectory != "" {
+		dir, err := expandChrootDirectory(chrootDirectory, spec.User)
+		if err != nil {
+			return nil, err
+		}
+		if err := setChroot(cmd, dir); err != nil {
+			return nil, err
+		}
+	}
+	// The helper of resource limits runs last, so that the limits do not apply to the other helpers
+	if !spec.ResourceLimits.IsZero() {
+		if chrootDirectory != "" {
+			return nil, fmt.Errorf("resource limits can not be used with chroot directory")
+		}
+		if err := rlimit.Command(cmd, spec.ResourceLimits.rlimits()); err != nil {
+			return nil, err
+		}
+	}
+	if e.SeccompProfile != "" {
+		if chrootDirectory != "" {
+			return nil, fmt.Errorf("seccomp profile can not be used with chroot directory")
+		}
+		if err := seccomp.Command(cmd, e.SeccompProfile); err != nil {
+			return nil, err
+		}
+	}
+	// The helper of namespaces runs before the one of seccomp, whose filter may deny setting up namespaces
+	if !spec.Namespaces.IsZero() {
+		if chrootDirectory != "" {
+			return nil, fmt.Errorf("namespaces can not be used with chroot directory")
+		}
+		if e.SandboxUser != nil {
+			return nil, fmt.Errorf("namespaces can not be set up by sandbox user")
+		}
+		if err := namespaces.Command(cmd, spec.Namespaces); err != nil {
+			return nil, err
+		}
+	}
+	if e.CgroupParent == "" {
+		return e.start(cmd, spec)
+	}
+	cg, err := newCgroup(e.CgroupParent, spec.CgroupLimits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cgroup: %w", err)
+	}
+	cg.attach(cmd)
+	process, err := e.start(cmd, spec)
+	if err != nil {
+		cg.remove()
+		return nil, err
+	}
+	return &cgroupProcess{Process: process, cgroup: cg}, nil
+}
+
+// start starts cmd of spec
+func (e *LocalExecutor) start(cmd *exec.Cmd, spec *ProcessSpec) (Process, error) {
+	if spec.Pty != nil {
+		var ptyProcess PtyProcess
+		err := privileged(e.Privileged, func() (err error) {
+			ptyProcess, err = startPty(cmd)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		return startedPtyProcess(ptyProcess, spec)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := privileged(e.Privileged, cmd.Start); err != nil {
+		return nil, err
+	}
+	return &localProcess{cmd: cmd, stdin: stdin, stdout: stdout, stderr: stderr}, nil
+}
+
+// privileged runs start by run if not nil
+func privileged(run func(f func() error) error, start func() error) error {
+	if run == nil {
+		return start()
+	}
+	return run(start)
+}
+
+// startedPtyProcess sizes the terminal of ptyProcess started for spec
+func startedPtyProcess(ptyProcess PtyProcess, spec *ProcessSpec) (Process, error) {
+	if err := ptyProcess.Resize(uint32(spec.Pty.Window.Width), uint32(spec.Pty.Window.Height)); err != nil {
+		ptyProcess.Close()
+		return nil, err
+	}
+	return &ptyProcessAdapter{PtyProcess: ptyProcess}, nil
+}
+
+// limitProcess sets limits of the started process of pid, which may run briefly without them.
+func limitProcess(pid int, limits ResourceLimits) error {
+	if limits.IsZero() {
+		return nil
+	}
+	if pid == 0 {
+		return fmt.Errorf("resource limits need the process ID")
+	}
+	if err := setResourceLimits(pid, limits); err != nil {
+		return fmt.Errorf("failed to set resource limits: %w", err)
+	}
+	return nil
+}
+
+// cgroupProcess removes its cgroup after exiting
+type cgroupProcess struct {
+	Process
+	cgroup *cgroup
+}
+
+func (p *cgroupProcess) Pid() int {
+	return processPID(p.Process)
+}
+
+func (p *cgroupProcess) Wait() (int, error) {
+	exitCode, err := p.Process.Wait()
+	if removeErr := p.cgroup.remove(); err == nil {
+		err = removeErr
+	}
+	return exitCode, err
+}
+
+type localProcess struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+	stderr io.Reader
+}
+
+func (p *localProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *localProcess) Stdout() io.Reader     { return p.stdout }
+func (p *localProcess) Stderr() io.Reader     { return p.stderr }
+
+func (p *localProcess) Signal(sig ssh.Signal) error {
+	return signalProcess(p.cmd.Process, sig)
+}
+
+func (p *localProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *localProcess) Resize(width, height uint32) error {
+	return fmt.Errorf("no pty")
+}
+
+func (p *localProcess) Wait() (int, error) {
+	return waitCmd(p.cmd)
+}
+
+func (p *localProcess) Close() error {
+	return nil
+}
+
+// waitCmd waits for cmd and returns its exit code
+func waitCmd(cmd *exec.Cmd) (int, error) {
+	if err := cmd.Wait(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return 0, err
+	}
+	return 0, nil
+}
+
+// ptyProcessAdapter adapts PtyProcess to Process
+type ptyProcessAdapter struct {
+	PtyProcess
+}
+
+func (p *ptyProcessAdapter) Stdin() io.WriteCloser { return p.PtyProcess }
+func (p *ptyProcessAdapter) Stdout() io.Reader     { return p.PtyProcess }
+func (p *ptyProcessAdapter) Stderr() io.Reader     { return nil }
+
+func (p *ptyProcessAdapter) Signal(sig ssh.Signal) error {
+	if signaler, ok := p.PtyProcess.(interface{ Signal(ssh.Signal) error }); ok {
+		return signaler.Signal(sig)
+	}
+	return fmt.Errorf("signal unsupported")
+}
+
+func (p *ptyProcessAdapter) Pid() int {
+	return processPID(p.PtyProcess)
+}
+
+func (s *Server) executor() Executor {
+	if s.Executor != nil {
+		return s.Executor
+	}
+	return &LocalExecutor{PtyFactory: s.PtyFactory, ChrootDirectory: s.ChrootDirectory, CgroupParent: s.CgroupParent, SeccompProfile: s.SeccompProfile, SandboxUser: s.SandboxUser, Privileged: s.Privileged, WindowsExecShell: s.WindowsExecShell}
+}
+
+// runProcess relays process and channel in goroutines of lifecycle, and sends the exit status when the process exits.
+// exited is called with the exit code after the exit status is sent.
+func runProcess(logger *slog.Logger, lifecycle *lifecycle, channel ssh.Channel, process Process, exited func(exitCode int)) {
+	processExited := lifecycle.trackProcess(logger, process)
+	exit := func() {
+		exitCode, err := process.Wait()
+		processExited()
+		if err != nil {
+			logger.Info("failed to wait process", "err", err)
+		}
+		if exitCode < 0 {
+			// e.g. killed by a signal
+			exitCode = 255
+		}
+		channel.SendRequest("exit-status", false, session.ExitStatus(exitCode))
+		channel.Close()
+		logger.Info("process exited", "exit_code", exitCode)
+		exited(exitCode)
+	}
+
+	if process.Stderr() == nil {
+		// Attached to a pty: either end finishes the session
+		var once sync.Once
+		closer := func() {
+			process.Close()
+			exit()
+		}
+		lifecycle.Go(func() {
+			io.Copy(channel, process.Stdout())
+			once.Do(closer)
+		})
+		lifecycle.Go(func() {
+			io.Copy(process.Stdin(), channel)
+			once.Do(closer)
+		})
+		return
+	}
+
+	lifecycle.Go(func() {
+		io.Copy(process.Stdin(), channel)
+		process.Stdin().Close()
+	})
+	lifecycle.Go(func() {
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			io.Copy(channel, process.Stdout())
+			wg.Done()
+		}()
+		go func() {
+			io.Copy(channel.Stderr(), process.Stderr())
+			wg.Done()
+		}()
+		wg.Wait()
+		process.Close()
+		exit()
+		// The copier blocked writing to a pipe still open in other processes
+		process.Stdin().Close()
+	})
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/rsa"
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Algorithms approved by FIPS 140-3, to which Algorithms with FIPS and FIPSSigner restrict connections
+var (
+	FIPSCiphers = []string{
+		"aes128-gcm@openssh.com", "aes256-gcm@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	FIPSMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com",
+		"hmac-sha2-256", "hmac-sha2-512",
+	}
+	FIPSKeyExchanges = []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha256", "diffie-hellman-group16-sha512",
+	}
+	// FIPSPublicKeyAlgorithms are for host keys and ssh.ServerConfig.PublicKeyAuthAlgorithms
+	FIPSPublicKeyAlgorithms = []string{
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521,
+		ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256,
+	}
+)
+
+// FIPSMinRSABits is the minimum size of RSA keys for signatures by FIPS 186-5, of host keys and keys of clients
+const FIPSMinRSABits = 2048
+
+// FIPSSigner returns the host key of signer restricted to FIPSPublicKeyAlgorithms.
+// It returns an error for keys of other types, e.g. Ed25519, and RSA keys shorter than 2048 bits.
+func FIPSSigner(signer ssh.Signer) (ssh.Signer, error) {
+	switch signer.PublicKey().Type() {
+	case ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521:
+		return signer, nil
+	case ssh.KeyAlgoRSA:
+		cryptoPublicKey, ok := signer.PublicKey().(ssh.CryptoPublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unknown RSA key")
+		}
+		if bits := cryptoPublicKey.CryptoPublicKey().(*rsa.PublicKey).N.BitLen(); bits < FIPSMinRSABits {
+			return nil, fmt.Errorf("RSA key of %d bits is shorter than %d bits", bits, FIPSMinRSABits)
+		}
+		algorithmSigner, ok := signer.(ssh.AlgorithmSigner)
+		if !ok {
+			return nil, fmt.Errorf("RSA key without SHA-2 signatures")
+		}
+		return ssh.NewSignerWithAlgorithms(algorithmSigner, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256})
+	}
+	return nil, fmt.Errorf("%s keys are not approved by FIPS", signer.PublicKey().Type())
+}
@@ -0,0 +1,11 @@
+//go:build go1.24
+
+package server
+
+import "crypto/fips140"
+
+// FIPSEnabled reports whether the Go Cryptographic Module runs in FIPS 140-3 mode,
+// e.g. with GODEBUG=fips140=on or built with GOFIPS140.
+func FIPSEnabled() bool {
+	return fips140.Enabled()
+}
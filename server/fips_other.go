@@ -0,0 +1,8 @@
+//go:build !go1.24
+
+package server
+
+// FIPSEnabled reports whether the Go Cryptographic Module runs in FIPS 140-3 mode, which requires Go 1.24 or later.
+func FIPSEnabled() bool {
+	return false
+}
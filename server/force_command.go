@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-shellwords"
+	"golang.org/x/crypto/ssh"
+)
+
+// ForceCommandInternalSftp is the forced command allowing only the SFTP subsystem like "internal-sftp" of sshd_config
+const ForceCommandInternalSftp = "internal-sftp"
+
+// connForceCommand returns the forced command of the connection by ExtensionForceCommand or ForceCommand, or empty without one.
+// "none" of the extension disables ForceCommand.
+func (s *Server) connForceCommand(sshConn *ssh.ServerConn) string {
+	switch command := extension(sshConn, ExtensionForceCommand); command {
+	case "":
+		return s.ForceCommand
+	case "none":
+		return ""
+	default:
+		return command
+	}
+}
+
+// forcedCommand returns the forced command of the connection split into words
+func (c *connection) forcedCommand() ([]string, error) {
+	if c.forcesSftp() {
+		return nil, errors.New("only SFTP allowed by forced command")
+	}
+	words, err := shellwords.Parse(c.forceCommand)
+	if err != nil || len(words) == 0 {
+		return nil, errors.New("invalid forced command")
+	}
+	return words, nil
+}
+
+// forcesSftp reports whether the forced command is ForceCommandInternalSftp, whose options such as "-l INFO" are ignored
+func (c *connection) forcesSftp() bool {
+	words := strings.Fields(c.forceCommand)
+	return len(words) != 0 && words[0] == ForceCommandInternalSftp
+}
+
+// forcedEnv returns SSH_ORIGINAL_COMMAND of the command line of "exec" replaced by the forced command, or nil without them
+func (c *connection) forcedEnv(rawCommand string) []string {
+	if c.forceCommand == "" || rawCommand == "" {
+		return nil
+	}
+	return []string{"SSH_ORIGINAL_COMMAND=" + rawCommand}
+}
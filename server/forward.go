@@ -0,0 +1,70 @@
+package server
+
+import (
+	"io"
+
+	"github.com/John-Ao/go-sshd/server/forward"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// forwardHooks applies Authorizer, events and statistics to forwarding of the connection
+func (s *Server) forwardHooks(logger *slog.Logger, conn *connection) *forward.Hooks {
+	event := func(eventType string, target forward.Target) Event {
+		return Event{Type: eventType, ForwardType: target.Type, Host: target.Host, Port: target.Port, Path: target.Path}
+	}
+	// removeForward removes the forwarding from the active ones of conn. Hooks are created per forwarding.
+	var removeForward func()
+	return &forward.Hooks{
+		Allow: func(target forward.Target) bool {
+			return s.authorize(logger, conn, &Action{Type: target.Type, Host: target.Host, Port: target.Port, Path: target.Path})
+		},
+		Started: func(forwarding *forward.Forwarding) {
+			s.stats.activeForwards.Add(1)
+			removeForward = conn.addForward(forwarding)
+			s.publish(conn, event(EventForwardStarted, forwarding.Target))
+		},
+		Ended: func(forwarding *forward.Forwarding) {
+			s.stats.activeForwards.Add(-1)
+			if removeForward != nil {
+				removeForward()
+			}
+			s.publish(conn, event(EventForwardEnded, forwarding.Target))
+		},
+		WrapChannel: func(channel ssh.Channel) ssh.Channel {
+			return &countingChannel{Channel: channel, stats: &s.stats, user: conn.traffic, limit: conn.transferLimit, forward: true}
+		},
+		Malformed: func(err error) {
+			logger.Warn("malformed request", "err", err)
+			s.malformed(logger, conn)
+		},
+		Go:           conn.lifecycle.Go,
+		StallTimeout: s.StallTimeout,
+		Stalled: func(toClient bool) {
+			s.relayStalled(logger, conn, toClient)
+		},
+	}
+}
+
+// copyRelay relays src to dst of a relay of conn, closing the relay by close if a write blocks for StallTimeout
+func (s *Server) copyRelay(logger *slog.Logger, conn *connection, dst io.Writer, src io.Reader, toClient bool, close func()) {
+	forward.CopyStall(dst, src, s.StallTimeout, func() {
+		close()
+		s.relayStalled(logger, conn, toClient)
+	})
+}
+
+// relayStalled is called after a relay of conn is closed as stalled.
+// The client is disconnected if it stopped reading, since it may not acknowledge closing the channel either.
+func (s *Server) relayStalled(logger *slog.Logger, conn *connection, toClient bool) {
+	s.stats.stalledRelays.Add(1)
+	if !toClient {
+		logger.Warn("closed stalled relay", "stall_timeout", s.StallTimeout, "receiver", "target")
+		return
+	}
+	logger.Warn("disconnecting client stalling relay", "stall_timeout", s.StallTimeout, "receiver", "client")
+	if conn.sshConn != nil {
+		conn.sshConn.Close()
+	}
+}
@@ -0,0 +1,111 @@
+package forward
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCopyBufferSize is the size of buffers of Copy by default, the same as io.Copy
+const DefaultCopyBufferSize = 32 * 1024
+
+// copyBufferSize is the size of buffers of Copy, or 0 for DefaultCopyBufferSize
+var copyBufferSize atomic.Int64
+
+var copyBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, CopyBufferSize())
+		return &b
+	},
+}
+
+// SetCopyBufferSize sets the size of buffers of Copy, or DefaultCopyBufferSize if 0.
+// Larger buffers read and write more at once, e.g. for bulk transfers over fast links.
+func SetCopyBufferSize(size int) {
+	copyBufferSize.Store(int64(size))
+}
+
+// CopyBufferSize returns the size of buffers of Copy.
+func CopyBufferSize() int {
+	if size := copyBufferSize.Load(); size > 0 {
+		return int(size)
+	}
+	return DefaultCopyBufferSize
+}
+
+// Copy is io.Copy with a buffer reused from a pool, for relaying connections and channels without allocating buffers per direction.
+// Every relay has an SSH channel on one side, whose data is decrypted in the process, so there is no socket pair to splice in the kernel.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	buf := copyBuffers.Get().(*[]byte)
+	if len(*buf) != CopyBufferSize() {
+		// Set after the buffer was pooled
+		b := make([]byte, CopyBufferSize())
+		buf = &b
+	}
+	defer copyBuffers.Put(buf)
+	// ReadFrom and WriteTo of net.Conn allocate their own buffers for channels, which they can't splice
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, *buf)
+}
+
+// ErrStalled is returned by CopyStall when a write blocks for the timeout
+var ErrStalled = errors.New("relay stalled")
+
+// stallChunkSize is the size of writes of CopyStall, so that the timeout measures progress rather than the size of the buffer
+const stallChunkSize = 32 * 1024
+
+// CopyStall is Copy which calls stalled if a write to dst blocks for timeout, e.g. because the receiver stops reading but keeps its connection alive.
+// stalled should unblock the write, e.g. by closing dst, after which CopyStall returns ErrStalled. It is Copy if timeout is 0.
+func CopyStall(dst io.Writer, src io.Reader, timeout time.Duration, stalled func()) (int64, error) {
+	if timeout == 0 {
+		return Copy(dst, src)
+	}
+	w := &stallWriter{Writer: dst, timeout: timeout, stalled: stalled}
+	defer w.stop()
+	return Copy(w, src)
+}
+
+// stallWriter calls stalled once if a write blocks for timeout
+type stallWriter struct {
+	io.Writer
+	timeout time.Duration
+	stalled func()
+	timer   *time.Timer
+	fired   atomic.Bool
+}
+
+func (w *stallWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), stallChunkSize)]
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.timeout, w.stall)
+		} else {
+			w.timer.Reset(w.timeout)
+		}
+		n, err := w.Writer.Write(chunk)
+		w.timer.Stop()
+		written += n
+		if w.fired.Load() {
+			return written, ErrStalled
+		}
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (w *stallWriter) stall() {
+	if w.fired.CompareAndSwap(false, true) {
+		w.stalled()
+	}
+}
+
+func (w *stallWriter) stop() {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
@@ -0,0 +1,33 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DialFailure returns the reason and the message of the channel open failure of err dialing address.
+func DialFailure(address string, err error) (ssh.RejectionReason, string) {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ssh.ConnectionFailed, fmt.Sprintf("connection refused by target %s", address)
+	case errors.As(err, &dnsErr):
+		return ssh.ConnectionFailed, fmt.Sprintf("could not resolve %s", dnsErr.Name)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ssh.ConnectionFailed, fmt.Sprintf("connection to %s timed out", address)
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ssh.ConnectionFailed, fmt.Sprintf("%s unreachable", address)
+	case errors.Is(err, syscall.ENOENT):
+		return ssh.ConnectionFailed, fmt.Sprintf("no such socket %s", address)
+	case errors.Is(err, syscall.EACCES), errors.Is(err, syscall.EPERM):
+		return ssh.Prohibited, fmt.Sprintf("permission denied to %s", address)
+	case errors.Is(err, syscall.EMFILE), errors.Is(err, syscall.ENFILE), errors.Is(err, syscall.ENOBUFS):
+		return ssh.ResourceShortage, fmt.Sprintf("out of resources to connect to %s", address)
+	}
+	return ssh.ConnectionFailed, fmt.Sprintf("failed to connect to %s", address)
+}
@@ -0,0 +1,389 @@
+// Package forward serves local and remote port forwarding over TCP and Unix domain sockets.
+package forward
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/sync_generics"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// Forwarding types
+const (
+	TypeDirectTcpip        = "direct-tcpip"
+	TypeDirectStreamlocal  = "direct-streamlocal"
+	TypeTcpipForward       = "tcpip-forward"
+	TypeStreamlocalForward = "streamlocal-forward"
+)
+
+// Target is the destination of local forwarding or the bind address of remote forwarding.
+type Target struct {
+	Type string
+	Host string
+	Port int
+	// Path is the Unix domain socket path
+	Path string
+}
+
+// Forwarding is an active forwarding.
+type Forwarding struct {
+	Target Target
+	stop   func()
+}
+
+// Stop closes the forwarded connection or the listener of remote forwarding.
+func (f *Forwarding) Stop() {
+	f.stop()
+}
+
+// Hooks customize forwarding of a connection. Nil fields are ignored.
+type Hooks struct {
+	// Allow decides whether the forwarding is performed.
+	Allow func(target Target) bool
+	// Started and Ended are called when the forwarding starts and ends.
+	Started func(forwarding *Forwarding)
+	Ended   func(forwarding *Forwarding)
+	// WrapChannel wraps channels opened to the client for remote forwarding.
+	WrapChannel func(channel ssh.Channel) ssh.Channel
+	// Malformed is called when a channel or a request has a malformed payload.
+	Malformed func(err error)
+	// Go runs f in a new goroutine, e.g. to wait for goroutines of the connection when it is closed.
+	Go func(f func())
+	// StallTimeout is how long a write of a relay can block before the relay is closed as stalled, or forever if 0.
+	StallTimeout time.Duration
+	// Stalled is called after a relay is closed as stalled. toClient is true if the client stopped reading,
+	// which may not acknowledge closing the channel and leave the write blocked, e.g. so the client should be disconnected.
+	Stalled func(toClient bool)
+}
+
+func (h *Hooks) allow(target Target) bool {
+	return h.Allow == nil || h.Allow(target)
+}
+
+// started calls Started and returns the function to call Ended
+func (h *Hooks) started(target Target, stop func()) func() {
+	forwarding := &Forwarding{Target: target, stop: stop}
+	if h.Started != nil {
+		h.Started(forwarding)
+	}
+	return func() {
+		if h.Ended != nil {
+			h.Ended(forwarding)
+		}
+	}
+}
+
+func (h *Hooks) malformed(err error) {
+	if h.Malformed != nil {
+		h.Malformed(err)
+	}
+}
+
+func (h *Hooks) goroutine(f func()) {
+	if h.Go == nil {
+		go f()
+		return
+	}
+	h.Go(f)
+}
+
+// copy relays src to dst, closing the relay by close if a write blocks for StallTimeout
+func (h *Hooks) copy(dst io.Writer, src io.Reader, toClient bool, close func()) {
+	CopyStall(dst, src, h.StallTimeout, func() {
+		close()
+		if h.Stalled != nil {
+			h.Stalled(toClient)
+		}
+	})
+}
+
+func (h *Hooks) wrapChannel(channel ssh.Channel) ssh.Channel {
+	if h.WrapChannel == nil {
+		return channel
+	}
+	return h.WrapChannel(channel)
+}
+
+// Forwarder serves forwarding channels and requests. Listeners of remote forwarding are shared by all connections.
+type Forwarder struct {
+	bindAddressToListener sync_generics.Map[string, net.Listener]
+}
+
+// HandleDirectTcpip serves a "direct-tcpip" channel.
+// (base: https://github.com/peertechde/zodiac/blob/110fdd2dfd27359546c1cd75a9fec5de2882bf42/pkg/server/server.go#L228)
+func (f *Forwarder) HandleDirectTcpip(logger *slog.Logger, hooks *Hooks, newChannel ssh.NewChannel) {
+	var msg struct {
+		RemoteAddr string
+		RemotePort uint32
+		SourceAddr string
+		SourcePort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
+		logger.Info("failed to parse direct-tcpip message", "err", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-tcpip message")
+		hooks.malformed(err)
+		return
+	}
+	target := Target{Type: TypeDirectTcpip, Host: msg.RemoteAddr, Port: int(msg.RemotePort)}
+	raddr := net.JoinHostPort(msg.RemoteAddr, strconv.Itoa(int(msg.RemotePort)))
+	if !hooks.allow(target) {
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("destination %s denied", raddr))
+		return
+	}
+	f.relay(logger, hooks, target, newChannel, "tcp", raddr)
+}
+
+// HandleDirectStreamlocal serves a "direct-streamlocal@openssh.com" channel.
+// client side: https://github.com/golang/crypto/blob/b4ddeeda5bc71549846db71ba23e83ecb26f36ed/ssh/streamlocal.go#L52
+func (f *Forwarder) HandleDirectStreamlocal(logger *slog.Logger, hooks *Hooks, newChannel ssh.NewChannel) {
+	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L237
+	var msg struct {
+		SocketPath string
+		Reserved0  string
+		Reserved1  uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
+		logger.Info("failed to parse direct-streamlocal message", "err", err)
+		newChannel.Reject(ssh.ConnectionFailed, "invalid direct-streamlocal message")
+		hooks.malformed(err)
+		return
+	}
+	target := Target{Type: TypeDirectStreamlocal, Path: msg.SocketPath}
+	if !hooks.allow(target) {
+		newChannel.Reject(ssh.Prohibited, fmt.Sprintf("destination %s denied", msg.SocketPath))
+		return
+	}
+	f.relay(logger, hooks, target, newChannel, "unix", msg.SocketPath)
+}
+
+// relay dials address and relays newChannel to it until either is closed. newChannel is rejected if dialing fails.
+func (f *Forwarder) relay(logger *slog.Logger, hooks *Hooks, target Target, newChannel ssh.NewChannel, network, address string) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		logger.Info("failed to dial", "err", err)
+		newChannel.Reject(DialFailure(address, err))
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		logger.Info("failed to accept", "err", err)
+		conn.Close()
+		return
+	}
+	hooks.goroutine(func() { ssh.DiscardRequests(reqs) })
+	var closeOnce sync.Once
+	closeRelay := func() {
+		closeOnce.Do(func() {
+			channel.Close()
+			conn.Close()
+		})
+	}
+	ended := hooks.started(target, closeRelay)
+	defer ended()
+	hooks.goroutine(func() {
+		hooks.copy(channel, conn, true, closeRelay)
+		closeRelay()
+	})
+	hooks.copy(conn, channel, false, closeRelay)
+	closeRelay()
+}
+
+// HandleTcpipForward serves a "tcpip-forward" request until sshConn is closed.
+// https://datatracker.ietf.org/doc/html/rfc4254#section-7.1
+func (f *Forwarder) HandleTcpipForward(logger *slog.Logger, hooks *Hooks, sshConn ssh.Conn, req *ssh.Request) {
+	var msg struct {
+		Addr string
+		Port uint32
+	}
+	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+		req.Reply(false, nil)
+		hooks.malformed(err)
+		return
+	}
+	target := Target{Type: TypeTcpipForward, Host: msg.Addr, Port: int(msg.Port)}
+	if !hooks.allow(target) {
+		req.Reply(false, nil)
+		return
+	}
+	address := net.JoinHostPort(msg.Addr, strconv.Itoa(int(msg.Port)))
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	// The port is allocated by the server when 0
+	port := uint32(ln.Addr().(*net.TCPAddr).Port)
+	address = net.JoinHostPort(msg.Addr, strconv.Itoa(int(port)))
+	f.bindAddressToListener.Store(address, ln)
+	defer f.bindAddressToListener.CompareAndDelete(address, ln)
+	target.Port = int(port)
+	ended := hooks.started(target, func() { ln.Close() })
+	defer ended()
+	var replyPayload []byte
+	if msg.Port == 0 {
+		replyPayload = ssh.Marshal(struct{ Port uint32 }{Port: port})
+	}
+	if err := req.Reply(true, replyPayload); err != nil {
+		// The connection is closed, so nothing would close the listener
+		logger.Info("failed to reply", "err", err)
+		ln.Close()
+		return
+	}
+	hooks.goroutine(func() {
+		sshConn.Wait()
+		ln.Close()
+		logger.Info("connection closed", "address", ln.Addr().String())
+	})
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			logger.Info("failed to accept", "err", err)
+			return
+		}
+		var replyMsg struct {
+			Addr           string
+			Port           uint32
+			OriginatorAddr string
+			OriginatorPort uint32
+		}
+		replyMsg.Addr = msg.Addr
+		replyMsg.Port = port
+		originatorAddr, originatorPortStr, err := net.SplitHostPort(conn.RemoteAddr().String())
+		if err == nil {
+			originatorPort, _ := strconv.Atoi(originatorPortStr)
+			replyMsg.OriginatorAddr = originatorAddr
+			replyMsg.OriginatorPort = uint32(originatorPort)
+		} else {
+			logger.Error("failed to split remote address", "remote_address", conn.RemoteAddr())
+		}
+
+		extraData := ssh.Marshal(&replyMsg)
+		hooks.goroutine(func() { relayForwarded(hooks, sshConn, conn, "forwarded-tcpip", extraData) })
+	}
+}
+
+// CancelTcpipForward serves a "cancel-tcpip-forward" request.
+// https://datatracker.ietf.org/doc/html/rfc4254#section-7.1
+func (f *Forwarder) CancelTcpipForward(logger *slog.Logger, req *ssh.Request) {
+	var msg struct {
+		Addr string
+		Port uint32
+	}
+	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	address := net.JoinHostPort(msg.Addr, strconv.Itoa(int(msg.Port)))
+	f.closeListener(logger, req, address)
+}
+
+// HandleStreamlocalForward serves a "streamlocal-forward@openssh.com" request until sshConn is closed.
+// client side: https://github.com/golang/crypto/blob/b4ddeeda5bc71549846db71ba23e83ecb26f36ed/ssh/streamlocal.go#L34
+func (f *Forwarder) HandleStreamlocalForward(logger *slog.Logger, hooks *Hooks, sshConn ssh.Conn, req *ssh.Request) {
+	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L272
+	var msg struct {
+		SocketPath string
+	}
+	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+		req.Reply(false, nil)
+		hooks.malformed(err)
+		return
+	}
+	target := Target{Type: TypeStreamlocalForward, Path: msg.SocketPath}
+	if !hooks.allow(target) {
+		req.Reply(false, nil)
+		return
+	}
+	ln, err := net.Listen("unix", msg.SocketPath)
+	if err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	f.bindAddressToListener.Store(msg.SocketPath, ln)
+	defer f.bindAddressToListener.CompareAndDelete(msg.SocketPath, ln)
+	ended := hooks.started(target, func() { ln.Close() })
+	defer ended()
+	if err := req.Reply(true, nil); err != nil {
+		// The connection is closed, so nothing would close the listener
+		logger.Info("failed to reply", "err", err)
+		ln.Close()
+		return
+	}
+	hooks.goroutine(func() {
+		sshConn.Wait()
+		ln.Close()
+		logger.Info("connection closed", "address", ln.Addr().String())
+	})
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			logger.Info("failed to accept", "err", err)
+			return
+		}
+		// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L255
+		var replyMsg struct {
+			SocketPath string
+			Reserved   string
+		}
+		replyMsg.SocketPath = msg.SocketPath
+
+		extraData := ssh.Marshal(&replyMsg)
+		hooks.goroutine(func() { relayForwarded(hooks, sshConn, conn, "forwarded-streamlocal@openssh.com", extraData) })
+	}
+}
+
+// CancelStreamlocalForward serves a "cancel-streamlocal-forward@openssh.com" request.
+func (f *Forwarder) CancelStreamlocalForward(logger *slog.Logger, req *ssh.Request) {
+	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L280
+	var msg struct {
+		SocketPath string
+	}
+	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
+		req.Reply(false, nil)
+		return
+	}
+	f.closeListener(logger, req, msg.SocketPath)
+}
+
+func (f *Forwarder) closeListener(logger *slog.Logger, req *ssh.Request, address string) {
+	ln, loaded := f.bindAddressToListener.LoadAndDelete(address)
+	if !loaded {
+		logger.Info("failed to find listener", "address", address)
+		req.Reply(false, nil)
+		return
+	}
+	if err := ln.Close(); err != nil {
+		logger.Info("failed to close", "err", err)
+		req.Reply(false, nil)
+		return
+	}
+	req.Reply(true, nil)
+}
+
+// relayForwarded opens a channel of channelType to the client and relays conn to it until either is closed
+func relayForwarded(hooks *Hooks, sshConn ssh.Conn, conn net.Conn, channelType string, extraData []byte) {
+	rawChannel, reqs, err := sshConn.OpenChannel(channelType, extraData)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	channel := hooks.wrapChannel(rawChannel)
+	hooks.goroutine(func() { ssh.DiscardRequests(reqs) })
+	closeRelay := func() {
+		conn.Close()
+		channel.Close()
+	}
+	hooks.goroutine(func() {
+		hooks.copy(channel, conn, true, closeRelay)
+		closeRelay()
+	})
+	hooks.copy(conn, channel, false, closeRelay)
+	closeRelay()
+}
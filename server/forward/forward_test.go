@@ -0,0 +1,213 @@
+package forward_test
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server/forward"
+	"github.com/John-Ao/go-sshd/sshdtest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// serveForwarding serves only forwarding with a Forwarder and returns a connected client
+func serveForwarding(t *testing.T, hooks *forward.Hooks) *ssh.Client {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+	ln := sshdtest.Listen()
+	t.Cleanup(func() { ln.Close() })
+	var forwarder forward.Forwarder
+	logger := slog.Default()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		sshConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				switch req.Type {
+				case "tcpip-forward":
+					go forwarder.HandleTcpipForward(logger, hooks, sshConn, req)
+				case "cancel-tcpip-forward":
+					go forwarder.CancelTcpipForward(logger, req)
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+		for newChannel := range chans {
+			go forwarder.HandleDirectTcpip(logger, hooks, newChannel)
+		}
+	}()
+	conn, err := ln.Dial()
+	require.NoError(t, err)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "pipe", &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+	require.NoError(t, err)
+	client := ssh.NewClient(sshConn, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDirectTcpip(t *testing.T) {
+	echoLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer echoLn.Close()
+	go func() {
+		conn, err := echoLn.Accept()
+		if err != nil {
+			return
+		}
+		io.Copy(conn, conn)
+		conn.Close()
+	}()
+	var started, ended []forward.Target
+	client := serveForwarding(t, &forward.Hooks{
+		Allow: func(target forward.Target) bool {
+			return target.Host == "127.0.0.1"
+		},
+		Started: func(forwarding *forward.Forwarding) { started = append(started, forwarding.Target) },
+		Ended:   func(forwarding *forward.Forwarding) { ended = append(ended, forwarding.Target) },
+	})
+
+	conn, err := client.Dial("tcp", echoLn.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	var buf [5]byte
+	_, err = io.ReadFull(conn, buf[:])
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:]))
+	conn.Close()
+	require.Len(t, started, 1)
+	assert.Equal(t, forward.TypeDirectTcpip, started[0].Type)
+
+	_, err = client.Dial("tcp", "localhost:22")
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ssh.Prohibited, openErr.Reason)
+	assert.Equal(t, "destination localhost:22 denied", openErr.Message)
+
+	// The channel is rejected when dialing fails
+	closedLn, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedLn.Close()
+	_, err = client.Dial("tcp", closedLn.Addr().String())
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ssh.ConnectionFailed, openErr.Reason)
+	assert.Equal(t, "connection refused by target "+closedLn.Addr().String(), openErr.Message)
+}
+
+func TestDialFailure(t *testing.T) {
+	reason, message := forward.DialFailure("nonexistent.invalid:22", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "nonexistent.invalid", IsNotFound: true}})
+	assert.Equal(t, ssh.ConnectionFailed, reason)
+	assert.Equal(t, "could not resolve nonexistent.invalid", message)
+	reason, message = forward.DialFailure("/run/app.sock", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EACCES)})
+	assert.Equal(t, ssh.Prohibited, reason)
+	assert.Equal(t, "permission denied to /run/app.sock", message)
+	reason, message = forward.DialFailure("192.0.2.1:22", errors.New("unknown"))
+	assert.Equal(t, ssh.ConnectionFailed, reason)
+	assert.Equal(t, "failed to connect to 192.0.2.1:22", message)
+}
+
+func TestTcpipForward(t *testing.T) {
+	client := serveForwarding(t, &forward.Hooks{})
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+	assert.NoError(t, ln.Close())
+}
+
+func TestCopy(t *testing.T) {
+	data := bytes.Repeat([]byte("go-sshd"), 10000)
+	var dst bytes.Buffer
+	n, err := forward.Copy(&dst, bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, dst.Bytes())
+
+	// Buffers are reused, and only the wrappers of dst and src are allocated
+	src := bytes.NewReader(data)
+	allocs := testing.AllocsPerRun(100, func() {
+		src.Reset(data)
+		forward.Copy(io.Discard, src)
+	})
+	assert.LessOrEqual(t, allocs, 2.0)
+}
+
+func BenchmarkCopy(b *testing.B) {
+	data := make([]byte, 1<<20)
+	src := bytes.NewReader(data)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		src.Reset(data)
+		forward.Copy(io.Discard, src)
+	}
+}
+
+func TestCopyStall(t *testing.T) {
+	data := bytes.Repeat([]byte("go-sshd"), 10000)
+	var dst bytes.Buffer
+	n, err := forward.CopyStall(&dst, bytes.NewReader(data), time.Second, func() { t.Error("stalled") })
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, dst.Bytes())
+
+	// Nobody reads the pipe
+	stalledDst, other := net.Pipe()
+	defer other.Close()
+	stalled := make(chan struct{})
+	_, err = forward.CopyStall(stalledDst, bytes.NewReader(data), 50*time.Millisecond, func() {
+		close(stalled)
+		stalledDst.Close()
+	})
+	assert.ErrorIs(t, err, forward.ErrStalled)
+	<-stalled
+}
+
+func TestSetCopyBufferSize(t *testing.T) {
+	defer forward.SetCopyBufferSize(0)
+	assert.Equal(t, forward.DefaultCopyBufferSize, forward.CopyBufferSize())
+	forward.SetCopyBufferSize(1 << 20)
+	assert.Equal(t, 1<<20, forward.CopyBufferSize())
+
+	// Buffers pooled before are replaced
+	data := bytes.Repeat([]byte("go-sshd"), 300000)
+	var dst bytes.Buffer
+	n, err := forward.Copy(&dst, bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(data)), n)
+	assert.Equal(t, data, dst.Bytes())
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// upstreamTimeout is the time limit of connecting to an upstream and of the handshake with it
+const upstreamTimeout = 10 * time.Second
+
+// Upstream is a backend SSH server to which an authenticated connection is proxied.
+type Upstream struct {
+	Address      string
+	ClientConfig *ssh.ClientConfig
+}
+
+// proxyConn relays all channels and global requests between sshConn and the upstream in both directions.
+func (s *Server) proxyConn(conn *connection, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+	sshConn := conn.sshConn
+	logger := conn.logger
+	defer sshConn.Close()
+	upstream, err := s.Upstream(sshConn)
+	if err != nil {
+		logger.Info("failed to choose upstream", "err", err)
+		return
+	}
+	// Dialing is canceled when the connection is closed
+	dialer := net.Dialer{Timeout: upstreamTimeout}
+	rawConn, err := dialer.DialContext(conn.lifecycle.ctx, "tcp", upstream.Address)
+	if err != nil {
+		logger.Info("failed to dial upstream", "address", upstream.Address, "err", err)
+		return
+	}
+	rawConn.SetDeadline(time.Now().Add(upstreamTimeout))
+	upstreamConn, upstreamChans, upstreamReqs, err := ssh.NewClientConn(rawConn, upstream.Address, upstream.ClientConfig)
+	if err != nil {
+		logger.Info("failed to handshake with upstream", "address", upstream.Address, "err", err)
+		rawConn.Close()
+		return
+	}
+	rawConn.SetDeadline(time.Time{})
+	defer upstreamConn.Close()
+	logger.Info("proxying connection", "upstream", upstream.Address)
+
+	conn.lifecycle.Go(func() { s.proxyGlobalRequests(sshConn, upstreamReqs) })
+	conn.lifecycle.Go(func() { s.proxyGlobalRequests(upstreamConn, reqs) })
+	// Channels opened by the upstream (e.g. "forwarded-tcpip")
+	conn.lifecycle.Go(func() { s.proxyChannels(conn, sshConn, upstreamChans) })
+	conn.lifecycle.Go(func() { s.proxyChannels(conn, upstreamConn, chans) })
+
+	var closeOnce sync.Once
+	done := make(chan struct{})
+	conn.lifecycle.Go(func() {
+		sshConn.Wait()
+		closeOnce.Do(func() { close(done) })
+	})
+	conn.lifecycle.Go(func() {
+		upstreamConn.Wait()
+		closeOnce.Do(func() { close(done) })
+	})
+	<-done
+	logger.Info("proxied connection closed", "upstream", upstream.Address)
+}
+
+func (s *Server) proxyGlobalRequests(dst ssh.Conn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok, payload, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, payload)
+		}
+	}
+}
+
+func (s *Server) proxyChannels(conn *connection, dst ssh.Conn, chans <-chan ssh.NewChannel) {
+	for newChannel := range chans {
+		newChannel = &countingNewChannel{NewChannel: newChannel, stats: &s.stats, user: conn.traffic, limit: conn.transferLimit, forward: isForwardChannelType(newChannel.ChannelType())}
+		if s.GenericOpenFailures {
+			newChannel = &genericRejectNewChannel{NewChannel: newChannel}
+		}
+		newChannel := newChannel
+		s.goChannel(conn, newChannel, func() { s.proxyChannel(conn, dst, newChannel) })
+	}
+}
+
+func (s *Server) proxyChannel(conn *connection, dst ssh.Conn, newChannel ssh.NewChannel) {
+	logger := conn.channelLogger(newChannel.ChannelType())
+	dstChannel, dstReqs, err := dst.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		logger.Info("failed to accept", "err", err)
+		dstChannel.Close()
+		return
+	}
+	logger.Info("proxying channel")
+
+	// requestMu prevents one direction from closing a channel while the other is relaying a reply to it
+	var requestMu sync.Mutex
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		// The upstream may not acknowledge closing the channel either, so its connection is closed
+		proxyChannelDirection(&requestMu, dstChannel, channel, reqs, func(dstWriter io.Writer, src io.Reader) {
+			s.copyRelay(logger, conn, dstWriter, src, false, func() { dst.Close() })
+		})
+	}()
+	go func() {
+		defer wg.Done()
+		proxyChannelDirection(&requestMu, channel, dstChannel, dstReqs, func(dst io.Writer, src io.Reader) {
+			s.copyRelay(logger, conn, dst, src, true, func() {
+				channel.Close()
+				dstChannel.Close()
+			})
+		})
+	}()
+	wg.Wait()
+}
+
+// proxyChannelDirection relays data by relay and requests from src to dst, and closes dst after src is closed.
+func proxyChannelDirection(requestMu *sync.Mutex, dst ssh.Channel, src ssh.Channel, srcReqs <-chan *ssh.Request, relay func(dst io.Writer, src io.Reader)) {
+	copied := make(chan struct{})
+	go func() {
+		var stderrWg sync.WaitGroup
+		stderrWg.Add(1)
+		go func() {
+			relay(dst.Stderr(), src.Stderr())
+			stderrWg.Done()
+		}()
+		relay(dst, src)
+		stderrWg.Wait()
+		dst.CloseWrite()
+		close(copied)
+	}()
+	for req := range srcReqs {
+		requestMu.Lock()
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if req.WantReply {
+			req.Reply(ok, nil)
+		}
+		requestMu.Unlock()
+	}
+	// Requests end when src is closed, but its buffered data may remain
+	<-copied
+	requestMu.Lock()
+	dst.Close()
+	requestMu.Unlock()
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"net"
+
+	"github.com/John-Ao/go-sshd/geoip"
+)
+
+// location returns the location of addr by GeoIP. It returns the zero location if GeoIP is nil or the lookup fails.
+func (s *Server) location(addr net.Addr) geoip.Location {
+	if s.GeoIP == nil {
+		return geoip.Location{}
+	}
+	location, err := s.GeoIP.LookupAddr(addr)
+	if err != nil {
+		s.Logger.Debug("failed to look up location", "remote_address", addr.String(), "err", err)
+	}
+	return location
+}
+
+// locationAttrs returns the attributes of location for logs
+func locationAttrs(location geoip.Location) []any {
+	var attrs []any
+	if location.Country != "" {
+		attrs = append(attrs, "country", location.Country)
+	}
+	if location.ASN != 0 {
+		attrs = append(attrs, "asn", location.ASN, "as_organization", location.Organization)
+	}
+	return attrs
+}
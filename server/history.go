@@ -0,0 +1,111 @@
+package server
+
+import (
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// HistoryExec and HistoryShell are the types of HistoryEntry
+	HistoryExec  = "exec"
+	HistoryShell = "shell"
+
+	// maxHistoryLine is the length of a line typed into a shell over which bytes are dropped
+	maxHistoryLine = 4096
+)
+
+// HistoryEntry is a command passed to CommandHistory of Server.
+type HistoryEntry struct {
+	Time time.Time
+	// SessionID is the ID of the session in SessionInfo
+	SessionID string
+	// Type is HistoryExec for the command of "exec" or HistoryShell for a line typed into "shell"
+	Type    string
+	Command string
+}
+
+// recordHistory passes the command of the session to CommandHistory if not nil
+func (s *Server) recordHistory(conn *connection, sessionID, entryType, command string) {
+	if s.CommandHistory == nil {
+		return
+	}
+	s.CommandHistory(conn.metadata, HistoryEntry{Time: time.Now(), SessionID: sessionID, Type: entryType, Command: command})
+}
+
+// historyInput returns channel recording the lines typed into the shell of the session if HistoryShellInput
+func (s *Server) historyInput(conn *connection, sessionID string, channel ssh.Channel) ssh.Channel {
+	if s.CommandHistory == nil || !s.HistoryShellInput {
+		return channel
+	}
+	return &historyChannel{Channel: channel, record: func(line string) {
+		s.recordHistory(conn, sessionID, HistoryShell, line)
+	}}
+}
+
+// historyChannel passes the lines read from the channel to record
+type historyChannel struct {
+	ssh.Channel
+	record func(line string)
+	line   lineEditor
+}
+
+func (c *historyChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	for _, b := range p[:n] {
+		if line, ok := c.line.input(b); ok {
+			c.record(line)
+		}
+	}
+	return n, err
+}
+
+// lineEditor reconstructs lines from keys typed into a terminal in the canonical mode, ignoring escape sequences such as arrows
+type lineEditor struct {
+	buf []byte
+	// escape is 1 after ESC and 2 in a control sequence until its final byte
+	escape int
+}
+
+// input types b and returns the line ended by it if any and not empty
+func (e *lineEditor) input(b byte) (string, bool) {
+	switch e.escape {
+	case 1:
+		e.escape = 0
+		if b == '[' || b == 'O' {
+			e.escape = 2
+		}
+		return "", false
+	case 2:
+		if b >= 0x40 && b <= 0x7e {
+			e.escape = 0
+		}
+		return "", false
+	}
+	switch b {
+	case '\r', '\n':
+		line := strings.TrimSpace(string(e.buf))
+		e.buf = e.buf[:0]
+		return line, line != ""
+	case 0x1b:
+		e.escape = 1
+	case 0x7f, '\b':
+		_, size := utf8.DecodeLastRune(e.buf)
+		e.buf = e.buf[:len(e.buf)-size]
+	case 0x03, 0x15:
+		// ^C and ^U discard the line
+		e.buf = e.buf[:0]
+	case 0x17:
+		// ^W erases the last word
+		e.buf = []byte(strings.TrimRight(string(e.buf), " "))
+		e.buf = e.buf[:strings.LastIndexByte(string(e.buf), ' ')+1]
+	default:
+		// Other control characters such as tabs completing words are not in lines
+		if b >= 0x20 && len(e.buf) < maxHistoryLine {
+			e.buf = append(e.buf, b)
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// lifecycleCloseTimeout is how long HandleConn waits for the goroutines and the processes of a closed connection
+const lifecycleCloseTimeout = 5 * time.Second
+
+// lifecycle tracks the goroutines and the child processes of a connection.
+// Closing it kills the processes and waits for the goroutines, so ones stalled on a side of a relay don't outlive the connection.
+type lifecycle struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	stats  *serverStats
+	wg     sync.WaitGroup
+	// goroutines and processes are the numbers of running ones
+	goroutines atomic.Int64
+	processes  atomic.Int64
+}
+
+func newLifecycle(stats *serverStats) *lifecycle {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &lifecycle{ctx: ctx, cancel: cancel, stats: stats}
+}
+
+// Go runs f in a new goroutine waited for by close.
+func (l *lifecycle) Go(f func()) {
+	l.wg.Add(1)
+	l.goroutines.Add(1)
+	l.stats.activeGoroutines.Add(1)
+	go func() {
+		defer func() {
+			l.stats.activeGoroutines.Add(-1)
+			l.goroutines.Add(-1)
+			l.wg.Done()
+		}()
+		f()
+	}()
+}
+
+// onClose calls f in its own goroutine when the lifecycle is closed unless stop is called first, like context.AfterFunc.
+func (l *lifecycle) onClose(f func()) (stop func() bool) {
+	return context.AfterFunc(l.ctx, f)
+}
+
+// trackProcess counts process and kills it if the lifecycle is closed before it exits.
+// Call the returned function after it has exited.
+func (l *lifecycle) trackProcess(logger *slog.Logger, process Process) func() {
+	l.processes.Add(1)
+	l.stats.activeProcesses.Add(1)
+	stop := l.onClose(func() {
+		logger.Info("killing process of closed connection", "pid", processPID(process))
+		process.Signal(ssh.SIGKILL)
+		process.Close()
+	})
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			stop()
+			l.stats.activeProcesses.Add(-1)
+			l.processes.Add(-1)
+		})
+	}
+}
+
+// close kills the processes and waits for the goroutines up to timeout. It returns false if some are still running.
+func (l *lifecycle) close(timeout time.Duration) bool {
+	l.cancel()
+	done := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// MalformedRequestPolicy is how Server treats requests with malformed payloads.
+type MalformedRequestPolicy int
+
+const (
+	// MalformedRequestIgnore rejects the request and keeps the connection.
+	MalformedRequestIgnore MalformedRequestPolicy = iota
+	// MalformedRequestDisconnect rejects the request and closes the connection.
+	MalformedRequestDisconnect
+)
+
+// malformedRequest rejects req and applies MalformedRequests
+func (s *Server) malformedRequest(logger *slog.Logger, conn *connection, req *ssh.Request, err error) {
+	logger.Warn("malformed request", "req_type", req.Type, "err", err)
+	if req.WantReply {
+		req.Reply(false, nil)
+	}
+	s.malformed(logger, conn)
+}
+
+// malformed closes the connection when MalformedRequests is MalformedRequestDisconnect
+func (s *Server) malformed(logger *slog.Logger, conn *connection) {
+	if s.MalformedRequests == MalformedRequestDisconnect && conn.sshConn != nil {
+		logger.Info("disconnecting because of malformed request")
+		conn.sshConn.Close()
+	}
+}
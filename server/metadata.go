@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ConnMetadata describes the SSH connection of channels and requests passed to handlers.
+type ConnMetadata struct {
+	// ID is unique to the connection and appears as "conn_id" in logs.
+	ID string
+	// SSHConn is nil when the connection is unknown (HandleChannels).
+	SSHConn *ssh.ServerConn
+}
+
+// User returns the user name. It is empty when the connection is unknown.
+func (m *ConnMetadata) User() string {
+	if m.SSHConn == nil {
+		return ""
+	}
+	return m.SSHConn.User()
+}
+
+// RemoteAddr returns the address of the client. It is nil when the connection is unknown.
+func (m *ConnMetadata) RemoteAddr() net.Addr {
+	if m.SSHConn == nil {
+		return nil
+	}
+	return m.SSHConn.RemoteAddr()
+}
+
+// SessionID returns the SSH session identifier (the exchange hash) of the connection.
+func (m *ConnMetadata) SessionID() []byte {
+	if m.SSHConn == nil {
+		return nil
+	}
+	return m.SSHConn.SessionID()
+}
+
+// Permissions returns the permissions returned by the authentication callback. It may be nil.
+func (m *ConnMetadata) Permissions() *ssh.Permissions {
+	if m.SSHConn == nil {
+		return nil
+	}
+	return m.SSHConn.Permissions
+}
+
+// Extension returns the value of key in Permissions().Extensions or "" if not set.
+func (m *ConnMetadata) Extension(key string) string {
+	return extension(m.SSHConn, key)
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/John-Ao/go-sshd/server/recording"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// monitorNotice is written to the client when monitoring is started with notify
+const monitorNotice = "\r\n[go-sshd: this session is being monitored by an administrator]\r\n"
+
+// outputTap copies the output of a session to monitors and the recorder
+type outputTap struct {
+	mu       sync.Mutex
+	monitors map[chan []byte]struct{}
+	closed   bool
+	// recorder records the output if not nil. onRecordError is called with its error when closed.
+	recorder      *recording.Writer
+	onRecordError func(error)
+}
+
+// record starts recording by recorder
+func (t *outputTap) record(recorder *recording.Writer, onError func(error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		if err := recorder.Close(); err != nil {
+			onError(err)
+		}
+		return
+	}
+	t.recorder = recorder
+	t.onRecordError = onError
+}
+
+// resize records the resize of the terminal
+func (t *outputTap) resize(w Window) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recorder != nil {
+		t.recorder.Resize(w.Width, w.Height)
+	}
+}
+
+// write copies p to the recorder and the monitors, dropping it for monitors not keeping up
+func (t *outputTap) write(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.recorder != nil {
+		t.recorder.Output(p)
+	}
+	if len(t.monitors) == 0 {
+		return
+	}
+	chunk := append([]byte(nil), p...)
+	for ch := range t.monitors {
+		select {
+		case ch <- chunk:
+		default:
+		}
+	}
+}
+
+// subscribe returns a channel of the output closed when the session ends or stop is called. ok is false if the session has ended.
+func (t *outputTap) subscribe() (<-chan []byte, func(), bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, false
+	}
+	if t.monitors == nil {
+		t.monitors = map[chan []byte]struct{}{}
+	}
+	ch := make(chan []byte, 256)
+	t.monitors[ch] = struct{}{}
+	return ch, func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if _, ok := t.monitors[ch]; ok {
+			delete(t.monitors, ch)
+			close(ch)
+		}
+	}, true
+}
+
+func (t *outputTap) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for ch := range t.monitors {
+		close(ch)
+	}
+	t.monitors = nil
+	if t.recorder != nil {
+		if err := t.recorder.Close(); err != nil {
+			t.onRecordError(err)
+		}
+		t.recorder = nil
+	}
+}
+
+// tappedChannel copies data written to the channel including extended data to tap
+type tappedChannel struct {
+	ssh.Channel
+	tap *outputTap
+}
+
+func (c *tappedChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	c.tap.write(p[:n])
+	return n, err
+}
+
+func (c *tappedChannel) Stderr() io.ReadWriter {
+	return &tappedReadWriter{ReadWriter: c.Channel.Stderr(), tap: c.tap}
+}
+
+type tappedReadWriter struct {
+	io.ReadWriter
+	tap *outputTap
+}
+
+func (rw *tappedReadWriter) Write(p []byte) (int, error) {
+	n, err := rw.ReadWriter.Write(p)
+	rw.tap.write(p[:n])
+	return n, err
+}
+
+// findSession returns the active session of id and its connection. It returns false if not found.
+func (s *Server) findSession(id string) (*connection, *activeSession, bool) {
+	connID, _, _ := strings.Cut(id, "/")
+	conn, ok := s.connections.Load(connID)
+	if !ok {
+		return nil, nil, false
+	}
+	sess, ok := conn.sessions.Load(id)
+	if !ok {
+		return nil, nil, false
+	}
+	return conn, sess, true
+}
+
+// Monitor returns the live output of the session of id in Connections, i.e. stdout and stderr or the terminal,
+// from now on, which is closed when the session ends or stop is called.
+// Output is dropped for receivers not keeping up. The client is notified on stderr if notify.
+// It returns false if id is not found.
+func (s *Server) Monitor(id string, notify bool) (output <-chan []byte, stop func(), ok bool) {
+	conn, sess, ok := s.findSession(id)
+	if !ok {
+		return nil, nil, false
+	}
+	output, stopTap, ok := sess.tap.subscribe()
+	if !ok {
+		return nil, nil, false
+	}
+	conn.logger.Info("monitoring session by request", "session_id", id, "notify", notify)
+	if notify {
+		io.WriteString(sess.stderr, monitorNotice)
+	}
+	var once sync.Once
+	return output, func() {
+		once.Do(func() {
+			stopTap()
+			conn.logger.Info("stopped monitoring session", "session_id", id)
+		})
+	}, true
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// obscureLinger is how long chaff is sent after the last input
+	obscureLinger = time.Second
+	// obscureMaxPending is the size of pending output written at once, which is not keystrokes
+	obscureMaxPending = 32 * 1024
+	// chaffRequest is the channel request of chaff ignored by clients.
+	// The name of one byte makes it as large as the echo of a keystroke in packets.
+	chaffRequest = "."
+)
+
+// obscuredChannel hides the timing of keystrokes from the output of a pty like ObscureKeystrokeTiming of OpenSSH.
+// While input is arriving, output is written only every interval, and chaff is sent in intervals without output.
+type obscuredChannel struct {
+	ssh.Channel
+	interval time.Duration
+
+	mu        sync.Mutex
+	pending   []byte
+	lastInput time.Time
+	ticking   bool
+	closed    bool
+	err       error
+	// writeMu serializes writes to Channel
+	writeMu sync.Mutex
+}
+
+func newObscuredChannel(channel ssh.Channel, interval time.Duration) *obscuredChannel {
+	return &obscuredChannel{Channel: channel, interval: interval}
+}
+
+func (c *obscuredChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	if n > 0 {
+		c.mu.Lock()
+		c.lastInput = time.Now()
+		if !c.ticking && !c.closed {
+			c.ticking = true
+			go c.tick()
+		}
+		c.mu.Unlock()
+	}
+	return n, err
+}
+
+func (c *obscuredChannel) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return 0, err
+	}
+	if !c.ticking {
+		c.mu.Unlock()
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		return c.Channel.Write(p)
+	}
+	c.pending = append(c.pending, p...)
+	full := len(c.pending) >= obscureMaxPending
+	c.mu.Unlock()
+	if full {
+		c.flush()
+	}
+	return len(p), nil
+}
+
+// tick writes pending output or chaff every interval until no input arrives for obscureLinger
+func (c *obscuredChannel) tick() {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		c.writeMu.Lock()
+		c.mu.Lock()
+		pending := c.pending
+		c.pending = nil
+		idle := len(pending) == 0 && time.Since(c.lastInput) > obscureLinger
+		if idle || c.closed {
+			c.ticking = false
+			c.mu.Unlock()
+			c.writeMu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		var err error
+		if len(pending) > 0 {
+			_, err = c.Channel.Write(pending)
+		} else {
+			_, err = c.Channel.SendRequest(chaffRequest, false, nil)
+		}
+		c.writeMu.Unlock()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.ticking = false
+			c.mu.Unlock()
+			return
+		}
+	}
+}
+
+// flush writes pending output now
+func (c *obscuredChannel) flush() {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+	if _, err := c.Channel.Write(pending); err != nil {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+	}
+}
+
+// SendRequest sends the request after pending output, e.g. "exit-status"
+func (c *obscuredChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	c.flush()
+	return c.Channel.SendRequest(name, wantReply, payload)
+}
+
+func (c *obscuredChannel) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.flush()
+	return c.Channel.Close()
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/John-Ao/go-sshd/namespaces"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Permission names
+const (
+	PermissionTcpipForward       = "tcpip-forward"
+	PermissionDirectTcpip        = "direct-tcpip"
+	PermissionExecute            = "execute"
+	PermissionSftp               = "sftp"
+	PermissionStreamlocalForward = "streamlocal-forward"
+	PermissionDirectStreamlocal  = "direct-streamlocal"
+	// PermissionScp allows "exec" of scp even if execution is not allowed
+	PermissionScp          = "scp"
+	PermissionAgentForward = "agent-forward"
+	PermissionX11Forward   = "x11-forward"
+	// PermissionAttach allows attaching read-only to pty sessions of other connections of the same user or Server.AttachTargets by AttachCommand.
+	// Unlike the others, it is not allowed by default.
+	PermissionAttach = "attach"
+	// PermissionAttachWrite allows attaching read-write in addition to PermissionAttach
+	PermissionAttachWrite = "attach-write"
+)
+
+// Keys of ssh.Permissions.Extensions which authentication callbacks can set to customize a connection per user
+const (
+	// ExtensionPermissions is comma-separated permission names allowed instead of Allow* fields of Server.
+	ExtensionPermissions = "go-sshd-permissions"
+	// ExtensionShell is the shell instead of the one of the server.
+	ExtensionShell = "go-sshd-shell"
+	// ExtensionHomeDir is the working directory of processes.
+	ExtensionHomeDir = "go-sshd-home-dir"
+	// ExtensionMaxSessions is the maximum number of concurrent sessions of the user.
+	ExtensionMaxSessions = "go-sshd-max-sessions"
+	// ExtensionMaxConnSessions is the maximum number of concurrent sessions of each connection of the user, like MaxSessions of sshd_config.
+	ExtensionMaxConnSessions = "go-sshd-max-conn-sessions"
+	// ExtensionMaxTransfer is the maximum number of bytes through the channels of each connection of the user in decimal instead of MaxTransfer of Server.
+	ExtensionMaxTransfer = "go-sshd-max-transfer"
+	// ExtensionResourceLimits is the resource limits of processes in the form of ParseResourceLimits, overriding the ones of ResourceLimits of Server.
+	ExtensionResourceLimits = "go-sshd-resource-limits"
+	// ExtensionCgroupLimits is the cgroup controls of processes in the form of ParseCgroupLimits, overriding the ones of CgroupLimits of Server.
+	ExtensionCgroupLimits = "go-sshd-cgroup-limits"
+	// ExtensionNamespaces is the namespaces of processes in the form of namespaces.Parse instead of Namespaces of Server.
+	ExtensionNamespaces = "go-sshd-namespaces"
+	// ExtensionDenyPty is "true" or "false" to reject "pty-req" instead of DenyPty of Server.
+	ExtensionDenyPty = "go-sshd-deny-pty"
+	// ExtensionForceCommand is the command run for "shell" and "exec" requests instead of ForceCommand of Server, or "none" not to force one.
+	ExtensionForceCommand = "go-sshd-force-command"
+	// ExtensionChrootDirectory is the directory to chroot processes into instead of ChrootDirectory of Server, or "none" not to chroot them.
+	ExtensionChrootDirectory = "go-sshd-chroot-directory"
+	// ExtensionDisconnectAt is the time in RFC 3339 to close the connection at, e.g. the end of the login hours of the user.
+	ExtensionDisconnectAt = "go-sshd-disconnect-at"
+)
+
+type permissions struct {
+	tcpipForward       bool
+	directTcpip        bool
+	execute            bool
+	sftp               bool
+	streamlocalForward bool
+	directStreamlocal  bool
+	scp                bool
+	agentForward       bool
+	x11Forward         bool
+	attach             bool
+	attachWrite        bool
+}
+
+// connPermissions returns permissions of the connection from its extensions or Allow* fields
+func (s *Server) connPermissions(sshConn *ssh.ServerConn) permissions {
+	if sshConn != nil && sshConn.Permissions != nil {
+		if names, ok := sshConn.Permissions.Extensions[ExtensionPermissions]; ok {
+			var p permissions
+			for _, name := range strings.Split(names, ",") {
+				switch strings.TrimSpace(name) {
+				case PermissionTcpipForward:
+					p.tcpipForward = true
+				case PermissionDirectTcpip:
+					p.directTcpip = true
+				case PermissionExecute:
+					p.execute = true
+				case PermissionSftp:
+					p.sftp = true
+				case PermissionStreamlocalForward:
+					p.streamlocalForward = true
+				case PermissionDirectStreamlocal:
+					p.directStreamlocal = true
+				case PermissionScp:
+					p.scp = true
+				case PermissionAgentForward:
+					p.agentForward = true
+				case PermissionX11Forward:
+					p.x11Forward = true
+				case PermissionAttach:
+					p.attach = true
+				case PermissionAttachWrite:
+					p.attach = true
+					p.attachWrite = true
+				}
+			}
+			return p
+		}
+	}
+	return permissions{
+		tcpipForward:       s.AllowTcpipForward,
+		directTcpip:        s.AllowDirectTcpip,
+		execute:            s.AllowExecute,
+		sftp:               s.AllowSftp,
+		streamlocalForward: s.AllowStreamlocalForward,
+		directStreamlocal:  s.AllowDirectStreamlocal,
+		scp:                s.AllowScp,
+		agentForward:       s.AllowAgentForward,
+		x11Forward:         s.AllowX11Forward,
+		attach:             s.AllowAttach || s.AllowAttachWrite,
+		attachWrite:        s.AllowAttachWrite,
+	}
+}
+
+// executes reports whether command can be executed by "exec"
+func (p *permissions) executes(command []string) bool {
+	return p.execute || p.scp && len(command) != 0 && path.Base(command[0]) == "scp"
+}
+
+// extension returns the extension of the connection or "" if not set
+func extension(sshConn *ssh.ServerConn, key string) string {
+	if sshConn == nil || sshConn.Permissions == nil {
+		return ""
+	}
+	return sshConn.Permissions.Extensions[key]
+}
+
+func extensionInt(sshConn *ssh.ServerConn, key string) int {
+	n, _ := strconv.Atoi(extension(sshConn, key))
+	return n
+}
+
+// connResourceLimits returns the resource limits of processes of the connection. Invalid ones of the extension are ignored.
+func (s *Server) connResourceLimits(sshConn *ssh.ServerConn) ResourceLimits {
+	limits, _ := ParseResourceLimits(extension(sshConn, ExtensionResourceLimits))
+	return limits.or(s.ResourceLimits)
+}
+
+// connCgroupLimits returns the cgroup controls of processes of the connection. Invalid ones of the extension are ignored.
+func (s *Server) connCgroupLimits(sshConn *ssh.ServerConn) CgroupLimits {
+	limits, _ := ParseCgroupLimits(extension(sshConn, ExtensionCgroupLimits))
+	return limits.or(s.CgroupLimits)
+}
+
+// connNamespaces returns the namespaces of processes of the connection. An invalid extension is ignored.
+func (s *Server) connNamespaces(sshConn *ssh.ServerConn) namespaces.Namespaces {
+	if n, err := namespaces.Parse(extension(sshConn, ExtensionNamespaces)); err == nil && !n.IsZero() {
+		return n
+	}
+	return s.Namespaces
+}
+
+// connDenyPty returns whether "pty-req" is rejected for the connection
+func (s *Server) connDenyPty(sshConn *ssh.ServerConn) bool {
+	if denyPty, err := strconv.ParseBool(extension(sshConn, ExtensionDenyPty)); err == nil {
+		return denyPty
+	}
+	return s.DenyPty
+}
+
+// connDisconnectAt returns the time to close the connection at, or the zero time. An invalid extension is ignored.
+func connDisconnectAt(sshConn *ssh.ServerConn) time.Time {
+	t, _ := time.Parse(time.RFC3339, extension(sshConn, ExtensionDisconnectAt))
+	return t
+}
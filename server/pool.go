@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool bounds the goroutines doing work such as handshakes and channels, so that floods degrade gracefully.
+// Work over Size waits in a queue of QueueSize for up to QueueTimeout, and is shed over them.
+// It can be shared by servers, e.g. ones replaced by reloads. A nil Pool doesn't bound work.
+type Pool struct {
+	// Size is the number of works at once
+	Size int
+	// QueueSize is the number of works waiting for a slot, over which works are shed at once
+	QueueSize int
+	// QueueTimeout is how long works wait for a slot before shed, or forever if 0
+	QueueTimeout time.Duration
+
+	once   sync.Once
+	slots  chan struct{}
+	queued atomic.Int64
+}
+
+// PoolTicket is a slot of Pool or a place in its queue.
+type PoolTicket struct {
+	pool *Pool
+	held bool
+}
+
+// Enter takes a slot, or a place in the queue if the slots are full. It returns nil if the queue is full too, and the work should be shed.
+// Call Wait in the goroutine of the work before starting it.
+func (p *Pool) Enter() *PoolTicket {
+	if p == nil {
+		return &PoolTicket{}
+	}
+	p.once.Do(func() {
+		p.slots = make(chan struct{}, p.Size)
+	})
+	select {
+	case p.slots <- struct{}{}:
+		return &PoolTicket{pool: p, held: true}
+	default:
+	}
+	if p.queued.Add(1) > int64(p.QueueSize) {
+		p.queued.Add(-1)
+		return nil
+	}
+	return &PoolTicket{pool: p}
+}
+
+// Wait waits for a slot if the ticket is in the queue. It returns false if QueueTimeout passes or ctx is done first,
+// e.g. when the client of the work disconnects, and the work should be shed. Call Leave after the work otherwise.
+func (t *PoolTicket) Wait(ctx context.Context) bool {
+	if t.pool == nil || t.held {
+		return true
+	}
+	defer t.pool.queued.Add(-1)
+	var timeout <-chan time.Time
+	if t.pool.QueueTimeout > 0 {
+		timer := time.NewTimer(t.pool.QueueTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case t.pool.slots <- struct{}{}:
+		t.held = true
+		return true
+	case <-timeout:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Leave frees the slot, e.g. after a handshake while the connection is still served. Calling it again does nothing.
+func (t *PoolTicket) Leave() {
+	if t.pool == nil || !t.held {
+		return
+	}
+	t.held = false
+	<-t.pool.slots
+}
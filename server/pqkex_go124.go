@@ -0,0 +1,6 @@
+//go:build go1.24
+
+package server
+
+// PostQuantumKeyExchanges are the hybrid post-quantum key exchanges of golang.org/x/crypto/ssh, which require Go 1.24 or later.
+var PostQuantumKeyExchanges = []string{"mlkem768x25519-sha256"}
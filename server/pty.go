@@ -0,0 +1,22 @@
+package server
+
+import (
+	"io"
+)
+
+// PtyFactory starts processes attached to pseudo terminals for LocalExecutor, e.g. in containers or on remote agents.
+type PtyFactory interface {
+	// Start starts the process of spec attached to a new pseudo terminal of the size of spec.Pty.
+	Start(spec *ProcessSpec) (PtyProcess, error)
+}
+
+// PtyProcess is a process attached to a pseudo terminal.
+// Reading and writing it read the output of the terminal and write the input to it.
+// It may implement Signal(ssh.Signal) error to support "signal" requests.
+type PtyProcess interface {
+	io.ReadWriteCloser
+	// Resize sets the size of the terminal in characters.
+	Resize(width, height uint32) error
+	// Wait waits for the process to exit and returns its exit code.
+	Wait() (int, error)
+}
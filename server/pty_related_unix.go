@@ -6,64 +6,82 @@
 package server
 
 import (
-	"io"
+	"fmt"
 	"os"
 	"os/exec"
-	"sync"
+	"syscall"
 
 	"github.com/creack/pty"
-	"github.com/pkg/errors"
 	"golang.org/x/crypto/ssh"
 )
 
-func (s *Server) createPty(shell string, connection ssh.Channel) (*os.File, error) {
-	if shell == "" {
-		shell = os.Getenv("SHELL")
+// startPty is pty.Start setting SSH_TTY to the name of the terminal like sshd
+func startPty(cmd *exec.Cmd) (PtyProcess, error) {
+	f, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
 	}
-	if shell == "" {
-		shell = "sh"
+	defer tty.Close()
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
 	}
-	// Fire up bash for this session
-	sh := exec.Command(shell)
-
-	// Prepare teardown function
-	closer := func() {
-		connection.SendRequest("exit-status", false, ssh.Marshal(exitStatusMsg{
-			Status: 0,
-		}))
-		connection.Close()
-		if sh.Process != nil {
-			_, err := sh.Process.Wait()
-			if err != nil {
-				s.Logger.Info("failed to exit shell", err)
-			}
-		}
-		s.Logger.Info("session closed")
+	cmd.Env = append(cmd.Env, "SSH_TTY="+tty.Name())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
 	}
-
-	// Allocate a terminal for this channel
-	s.Logger.Info("creating pty...")
-	shf, err := pty.Start(sh)
-	if err != nil {
-		s.Logger.Info("failed to start pty", "err", err)
-		closer()
-		return nil, errors.Errorf("could not start pty (%s)", err)
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	if err := cmd.Start(); err != nil {
+		f.Close()
+		return nil, err
 	}
+	return &localPtyProcess{File: f, cmd: cmd}, nil
+}
+
+type localPtyProcess struct {
+	*os.File
+	cmd *exec.Cmd
+}
+
+// Resize sets the size of the given pty.
+func (p *localPtyProcess) Resize(w, h uint32) error {
+	return pty.Setsize(p.File, &pty.Winsize{Rows: uint16(h), Cols: uint16(w)})
+}
+
+func (p *localPtyProcess) Signal(sig ssh.Signal) error {
+	return signalProcess(p.cmd.Process, sig)
+}
+
+func (p *localPtyProcess) Pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *localPtyProcess) Wait() (int, error) {
+	return waitCmd(p.cmd)
+}
 
-	// pipe session to bash and visa-versa
-	var once sync.Once
-	go func() {
-		io.Copy(connection, shf)
-		once.Do(closer)
-	}()
-	go func() {
-		io.Copy(shf, connection)
-		once.Do(closer)
-	}()
-	return shf, nil
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6.10
+var sshSignalToSignal = map[ssh.Signal]syscall.Signal{
+	ssh.SIGABRT: syscall.SIGABRT,
+	ssh.SIGALRM: syscall.SIGALRM,
+	ssh.SIGFPE:  syscall.SIGFPE,
+	ssh.SIGHUP:  syscall.SIGHUP,
+	ssh.SIGILL:  syscall.SIGILL,
+	ssh.SIGINT:  syscall.SIGINT,
+	ssh.SIGKILL: syscall.SIGKILL,
+	ssh.SIGPIPE: syscall.SIGPIPE,
+	ssh.SIGQUIT: syscall.SIGQUIT,
+	ssh.SIGSEGV: syscall.SIGSEGV,
+	ssh.SIGTERM: syscall.SIGTERM,
+	ssh.SIGUSR1: syscall.SIGUSR1,
+	ssh.SIGUSR2: syscall.SIGUSR2,
 }
 
-// setWinsize sets the size of the given pty.
-func setWinsize(t *os.File, w, h uint32) error {
-	return pty.Setsize(t, &pty.Winsize{Rows: uint16(h), Cols: uint16(w)})
+func signalProcess(process *os.Process, sig ssh.Signal) error {
+	s, ok := sshSignalToSignal[sig]
+	if !ok {
+		return fmt.Errorf("unknown signal: %s", sig)
+	}
+	return process.Signal(s)
 }
@@ -6,15 +6,19 @@ package server
 import (
 	"fmt"
 	"os"
+	"os/exec"
 
 	"golang.org/x/crypto/ssh"
 )
 
-func (s *Server) createPty(shell string, connection ssh.Channel) (*os.File, error) {
+func startPty(cmd *exec.Cmd) (PtyProcess, error) {
 	return nil, fmt.Errorf("creation of pty unsupported")
 }
 
-// setWinsize sets the size of the given pty.
-func setWinsize(t *os.File, w, h uint32) error {
-	return fmt.Errorf("set-win-size unsupported")
+func signalProcess(process *os.Process, sig ssh.Signal) error {
+	// Windows supports only killing
+	if sig == ssh.SIGKILL {
+		return process.Kill()
+	}
+	return fmt.Errorf("signal unsupported: %s", sig)
 }
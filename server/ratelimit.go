@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit limits events to Rate per second in the long run with bursts of up to Burst at once. It doesn't limit if Rate is 0.
+type RateLimit struct {
+	Rate float64
+	// Burst is at least 1
+	Burst int
+}
+
+// IsZero returns true if it doesn't limit
+func (l RateLimit) IsZero() bool {
+	return l.Rate <= 0
+}
+
+// ParseRateLimit parses "RATE" or "RATE:BURST" of events per second (e.g. "10:50"). The burst is the rate rounded up by default.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if s == "" {
+		return RateLimit{}, nil
+	}
+	rate, burst, hasBurst := strings.Cut(s, ":")
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r <= 0 || math.IsInf(r, 0) {
+		return RateLimit{}, fmt.Errorf("invalid rate: %s", rate)
+	}
+	limit := RateLimit{Rate: r, Burst: int(math.Ceil(r))}
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst < 1 {
+			return RateLimit{}, fmt.Errorf("invalid burst: %s", burst)
+		}
+	}
+	return limit, nil
+}
+
+// rateLimiter is a token bucket of RateLimit. A nil rateLimiter allows all events.
+type rateLimiter struct {
+	limit RateLimit
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(limit RateLimit) *rateLimiter {
+	if limit.IsZero() {
+		return nil
+	}
+	limit.Burst = max(limit.Burst, 1)
+	return &rateLimiter{limit: limit, tokens: float64(limit.Burst)}
+}
+
+// allow takes a token at now if any
+func (l *rateLimiter) allow(now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.last.IsZero() {
+		l.tokens = min(l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate, float64(l.limit.Burst))
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
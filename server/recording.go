@@ -0,0 +1,44 @@
+package server
+
+import (
+	"time"
+
+	"github.com/John-Ao/go-sshd/server/recording"
+)
+
+// startRecording records the output of sess to the writer of SessionRecording if not nil. pty is nil without a pty.
+// Call it after sess started and before its output is written.
+func (s *Server) startRecording(conn *connection, sess *activeSession, pty *Pty) {
+	if s.SessionRecording == nil {
+		return
+	}
+	info := sess.snapshot()
+	startTime := time.Now()
+	w, err := s.SessionRecording(conn.metadata, info.ID, startTime)
+	if err != nil {
+		conn.logger.Error("failed to open session recording", "session_id", info.ID, "err", err)
+		return
+	}
+	header := recording.Header{
+		Time:       startTime,
+		SessionID:  info.ID,
+		User:       conn.metadata.User(),
+		RemoteAddr: conn.metadata.RemoteAddr().String(),
+		Type:       info.Type,
+		Command:    info.Command,
+	}
+	if pty != nil {
+		header.Term = pty.Term
+		header.Width = pty.Window.Width
+		header.Height = pty.Window.Height
+	}
+	recorder, err := recording.NewWriter(w, header)
+	if err != nil {
+		w.Close()
+		conn.logger.Error("failed to write session recording", "session_id", info.ID, "err", err)
+		return
+	}
+	sess.tap.record(recorder, func(err error) {
+		conn.logger.Error("failed to write session recording", "session_id", info.ID, "err", err)
+	})
+}
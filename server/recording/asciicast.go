@@ -0,0 +1,87 @@
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// asciicastHeader is the header of asciicast v2
+// https://docs.asciinema.org/manual/asciicast/v2/
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Export writes the recording of r in asciicast v2. The size of sessions without a pty is 80x24.
+// Invalid UTF-8 in the output is replaced with U+FFFD as asciicast stores text.
+func Export(w io.Writer, r *Reader) error {
+	header := asciicastHeader{
+		Version:   2,
+		Width:     r.Header.Width,
+		Height:    r.Header.Height,
+		Timestamp: r.Header.Time.Unix(),
+		Title:     fmt.Sprintf("%s@%s %s", r.Header.User, r.Header.RemoteAddr, r.Header.SessionID),
+	}
+	if header.Width == 0 || header.Height == 0 {
+		header.Width, header.Height = 80, 24
+	}
+	if len(r.Header.Command) != 0 {
+		header.Command = strings.Join(r.Header.Command, " ")
+	}
+	if r.Header.Term != "" {
+		header.Env = map[string]string{"TERM": r.Header.Term}
+	}
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return err
+	}
+	// pending is an incomplete UTF-8 sequence at the end of the last output
+	var pending []byte
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		seconds := event.Time.Seconds()
+		if event.Output == nil {
+			if err := enc.Encode([]any{seconds, "r", fmt.Sprintf("%dx%d", event.Width, event.Height)}); err != nil {
+				return err
+			}
+			continue
+		}
+		var text string
+		text, pending = decodeUTF8(append(pending, event.Output...))
+		if text == "" {
+			continue
+		}
+		if err := enc.Encode([]any{seconds, "o", text}); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeUTF8 returns p in valid UTF-8 and the incomplete sequence at its end
+func decodeUTF8(p []byte) (string, []byte) {
+	end := len(p)
+	// An incomplete sequence is at most 3 bytes
+	for i := len(p) - 1; i >= 0 && i >= len(p)-3; i-- {
+		if utf8.RuneStart(p[i]) {
+			if !utf8.FullRune(p[i:]) {
+				end = i
+			}
+			break
+		}
+	}
+	return strings.ToValidUTF8(string(p[:end]), string(utf8.RuneError)), append([]byte(nil), p[end:]...)
+}
@@ -0,0 +1,158 @@
+package recording
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Control changes the playback of Play.
+type Control int
+
+const (
+	// ControlPause pauses or resumes
+	ControlPause Control = iota
+	// ControlFaster doubles the speed
+	ControlFaster
+	// ControlSlower halves the speed
+	ControlSlower
+	// ControlForward seeks SeekStep forward
+	ControlForward
+	// ControlBackward seeks SeekStep backward, replaying the output from the start after resetting the terminal
+	ControlBackward
+)
+
+// SeekStep is the step of ControlForward and ControlBackward
+const SeekStep = 5 * time.Second
+
+// resetTerminal resets the terminal (RIS) before replaying from the start
+const resetTerminal = "\x1bc"
+
+// PlayOptions are options of Play.
+type PlayOptions struct {
+	// Speed is the playback speed (default: 1)
+	Speed float64
+	// Seek is the position to start from. The output before it is written at once.
+	Seek time.Duration
+	// IdleLimit caps pauses between events if not 0. Positions are in the time with the pauses capped.
+	IdleLimit time.Duration
+	// Controls change the playback if not nil
+	Controls <-chan Control
+}
+
+// player is the state of Play
+type player struct {
+	w      io.Writer
+	events []Event
+	// times are the times of events with pauses capped by IdleLimit
+	times []time.Duration
+	// next is the index of the next event and pos is the position of the playback
+	next int
+	pos  time.Duration
+}
+
+// Play writes the output of events to w in real time until the end or ctx is done. Resizes are not written.
+func Play(ctx context.Context, w io.Writer, events []Event, opts PlayOptions) error {
+	p := &player{w: w, events: events, times: make([]time.Duration, len(events))}
+	var prev, t time.Duration
+	for i, event := range events {
+		gap := event.Time - prev
+		if opts.IdleLimit > 0 && gap > opts.IdleLimit {
+			gap = opts.IdleLimit
+		}
+		t += gap
+		p.times[i] = t
+		prev = event.Time
+	}
+	speed := opts.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+	if err := p.seek(opts.Seek); err != nil {
+		return err
+	}
+	paused := false
+	for p.next < len(events) {
+		waitStart := time.Now()
+		control, ok, err := wait(ctx, time.Duration(float64(p.times[p.next]-p.pos)/speed), paused, opts.Controls)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if err := p.write(p.next); err != nil {
+				return err
+			}
+			p.pos = p.times[p.next]
+			p.next++
+			continue
+		}
+		if !paused {
+			p.pos += time.Duration(float64(time.Since(waitStart)) * speed)
+			if p.pos > p.times[p.next] {
+				p.pos = p.times[p.next]
+			}
+		}
+		switch control {
+		case ControlPause:
+			paused = !paused
+		case ControlFaster:
+			speed *= 2
+		case ControlSlower:
+			speed /= 2
+		case ControlForward:
+			err = p.seek(p.pos + SeekStep)
+		case ControlBackward:
+			err = p.seek(p.pos - SeekStep)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for the next event due after d unless paused. It returns true with a control received before.
+func wait(ctx context.Context, d time.Duration, paused bool, controls <-chan Control) (Control, bool, error) {
+	var timerC <-chan time.Time
+	if !paused {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timerC = timer.C
+	}
+	select {
+	case <-ctx.Done():
+		return 0, false, ctx.Err()
+	case <-timerC:
+		return 0, false, nil
+	case control := <-controls:
+		return control, true, nil
+	}
+}
+
+// seek writes the output up to pos at once, from the start after resetting the terminal if pos is behind
+func (p *player) seek(pos time.Duration) error {
+	if pos < 0 {
+		pos = 0
+	}
+	if pos < p.pos {
+		if _, err := io.WriteString(p.w, resetTerminal); err != nil {
+			return err
+		}
+		p.next = 0
+	}
+	for ; p.next < len(p.events) && p.times[p.next] <= pos; p.next++ {
+		if err := p.write(p.next); err != nil {
+			return err
+		}
+	}
+	p.pos = pos
+	return nil
+}
+
+func (p *player) write(i int) error {
+	if len(p.events[i].Output) == 0 {
+		return nil
+	}
+	_, err := p.w.Write(p.events[i].Output)
+	return err
+}
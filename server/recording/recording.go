@@ -0,0 +1,143 @@
+// Package recording writes, reads and plays recordings of the output of sessions in JSON lines,
+// and exports them in the asciicast v2 format of asciinema.
+// The first line is a Header and each following line is an Event with the output in base64 to keep the bytes as they are.
+package recording
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Version is the version of the format in Header
+const Version = 1
+
+// Header is the first line of a recording.
+type Header struct {
+	Version    int       `json:"version"`
+	Time       time.Time `json:"time"`
+	SessionID  string    `json:"session_id"`
+	User       string    `json:"user"`
+	RemoteAddr string    `json:"remote_address"`
+	// Type is "shell" or "exec"
+	Type    string   `json:"type"`
+	Command []string `json:"command,omitempty"`
+	// Term, Width and Height are of the pty. They are empty without a pty.
+	Term   string `json:"term,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// Event is the output or the resize of the terminal at Time since the start.
+type Event struct {
+	Time   time.Duration
+	Output []byte
+	// Width and Height are the size after a resize. They are 0 for output.
+	Width  int
+	Height int
+}
+
+// line is the JSON form of Event
+type line struct {
+	// Seconds is Event.Time in seconds
+	Seconds float64 `json:"t"`
+	Output  []byte  `json:"o,omitempty"`
+	Width   int     `json:"w,omitempty"`
+	Height  int     `json:"h,omitempty"`
+}
+
+// Writer writes a recording. It is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.WriteCloser
+	enc   *json.Encoder
+	start time.Time
+	// err is the first error of writes, after which events are discarded
+	err error
+}
+
+// NewWriter writes header to w and returns the Writer of the events after header.Time. Close closes w.
+func NewWriter(w io.WriteCloser, header Header) (*Writer, error) {
+	header.Version = Version
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(header); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, enc: enc, start: header.Time}, nil
+}
+
+// Output records p written to the client.
+func (w *Writer) Output(p []byte) {
+	w.write(line{Output: p})
+}
+
+// Resize records the resize of the terminal.
+func (w *Writer) Resize(width, height int) {
+	w.write(line{Width: width, Height: height})
+}
+
+func (w *Writer) write(l line) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return
+	}
+	l.Seconds = time.Since(w.start).Seconds()
+	w.err = w.enc.Encode(l)
+}
+
+// Close closes the underlying writer. It returns the first error of writes if any.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return errors.Join(w.err, w.w.Close())
+}
+
+// Reader reads a recording.
+type Reader struct {
+	Header Header
+	dec    *json.Decoder
+}
+
+// NewReader reads the header of the recording of r.
+func NewReader(r io.Reader) (*Reader, error) {
+	dec := json.NewDecoder(r)
+	var header Header
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read the header: %w", err)
+	}
+	if header.Version != Version {
+		return nil, fmt.Errorf("unsupported version: %d", header.Version)
+	}
+	return &Reader{Header: header, dec: dec}, nil
+}
+
+// Next returns the next event. It returns io.EOF at the end.
+func (r *Reader) Next() (Event, error) {
+	var l line
+	if err := r.dec.Decode(&l); err != nil {
+		if errors.Is(err, io.EOF) {
+			return Event{}, io.EOF
+		}
+		return Event{}, fmt.Errorf("failed to read an event: %w", err)
+	}
+	return Event{Time: time.Duration(l.Seconds * float64(time.Second)), Output: l.Output, Width: l.Width, Height: l.Height}, nil
+}
+
+// ReadAll returns all events after the header.
+func (r *Reader) ReadAll() ([]Event, error) {
+	var events []Event
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, event)
+	}
+}
@@ -0,0 +1,98 @@
+package recording
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	start := time.Now().Add(-time.Second)
+	w, err := NewWriter(nopCloser{&buf}, Header{Time: start, SessionID: "abc/1", User: "john", Type: "shell", Term: "xterm", Width: 80, Height: 24})
+	require.NoError(t, err)
+	w.Output([]byte("\xffhello"))
+	w.Resize(100, 30)
+	require.NoError(t, w.Close())
+
+	r, err := NewReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, Version, r.Header.Version)
+	assert.Equal(t, "abc/1", r.Header.SessionID)
+	events, err := r.ReadAll()
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, []byte("\xffhello"), events[0].Output)
+	assert.GreaterOrEqual(t, events[0].Time, time.Second)
+	assert.Equal(t, 100, events[1].Width)
+	assert.Equal(t, 30, events[1].Height)
+
+	_, err = NewReader(strings.NewReader(`{"version":2}`))
+	assert.EqualError(t, err, "unsupported version: 2")
+}
+
+func TestExport(t *testing.T) {
+	var buf bytes.Buffer
+	header := `{"version":1,"time":"2024-01-02T15:04:05Z","session_id":"abc/1","user":"john","remote_address":"192.0.2.1:1234","type":"exec","command":["ls","-l"]}` + "\n"
+	// "é" is split between events
+	events := `{"t":0.5,"o":"aMM="}` + "\n" + `{"t":1,"o":"qf8K"}` + "\n" + `{"t":2,"w":100,"h":30}` + "\n"
+	r, err := NewReader(strings.NewReader(header + events))
+	require.NoError(t, err)
+	require.NoError(t, Export(&buf, r))
+	assert.Equal(t, `{"version":2,"width":80,"height":24,"timestamp":1704207845,"command":"ls -l","title":"john@192.0.2.1:1234 abc/1"}
+[0.5,"o","h"]
+[1,"o","é�\n"]
+[2,"r","100x30"]
+`, buf.String())
+}
+
+func TestPlay(t *testing.T) {
+	events := []Event{
+		{Time: 100 * time.Millisecond, Output: []byte("a")},
+		{Time: time.Hour, Output: []byte("b")},
+		{Time: time.Hour + 200*time.Millisecond, Width: 100, Height: 30},
+		{Time: time.Hour + 300*time.Millisecond, Output: []byte("c")},
+	}
+	var buf bytes.Buffer
+	start := time.Now()
+	require.NoError(t, Play(context.Background(), &buf, events, PlayOptions{Speed: 2, IdleLimit: 100 * time.Millisecond}))
+	assert.Equal(t, "abc", buf.String())
+	// 100ms + 100ms + 100ms + 100ms at double speed
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	buf.Reset()
+	require.NoError(t, Play(context.Background(), &buf, events, PlayOptions{Seek: time.Hour}))
+	assert.Equal(t, "abc", buf.String())
+
+	controls := make(chan Control)
+	done := make(chan error)
+	buf.Reset()
+	go func() {
+		done <- Play(context.Background(), &buf, events, PlayOptions{Seek: time.Second, Controls: controls})
+	}()
+	controls <- ControlPause
+	controls <- ControlBackward
+	controls <- ControlForward
+	for i := 0; i < 720; i++ {
+		controls <- ControlForward
+	}
+	require.NoError(t, <-done)
+	assert.Equal(t, "a"+resetTerminal+"abc", buf.String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, Play(ctx, io.Discard, events, PlayOptions{}))
+}
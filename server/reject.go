@@ -0,0 +1,12 @@
+package server
+
+import "golang.org/x/crypto/ssh"
+
+// genericRejectNewChannel rejects the channel with the description of the reason instead of the message for GenericOpenFailures
+type genericRejectNewChannel struct {
+	ssh.NewChannel
+}
+
+func (c *genericRejectNewChannel) Reject(reason ssh.RejectionReason, message string) error {
+	return c.NewChannel.Reject(reason, reason.String())
+}
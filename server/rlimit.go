@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ResourceLimits are limits of processes started by LocalExecutor. Both the soft and hard limits are set. 0 is no limit.
+type ResourceLimits struct {
+	// CPU is RLIMIT_CPU in seconds
+	CPU uint64
+	// AddressSpace is RLIMIT_AS in bytes
+	AddressSpace uint64
+	// Files is RLIMIT_NOFILE
+	Files uint64
+	// Processes is RLIMIT_NPROC, which counts all processes of the OS user
+	Processes uint64
+}
+
+// ParseResourceLimits parses comma-separated limits like "cpu=60,as=1G,nofile=1024,nproc=64".
+// "as" can have a suffix of "K", "M" or "G".
+func ParseResourceLimits(s string) (ResourceLimits, error) {
+	var limits ResourceLimits
+	if s == "" {
+		return limits, nil
+	}
+	for _, limit := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(limit, "=")
+		if !ok || value == "" {
+			return ResourceLimits{}, fmt.Errorf("invalid resource limit: %s", limit)
+		}
+		var field *uint64
+		parse := parseCount
+		switch name {
+		case "cpu":
+			field = &limits.CPU
+		case "as":
+			field, parse = &limits.AddressSpace, parseSize
+		case "nofile":
+			field = &limits.Files
+		case "nproc":
+			field = &limits.Processes
+		default:
+			return ResourceLimits{}, fmt.Errorf("unknown resource limit: %s", name)
+		}
+		n, err := parse(value)
+		if err != nil {
+			return ResourceLimits{}, fmt.Errorf("invalid value of %s: %s", name, value)
+		}
+		*field = n
+	}
+	return limits, nil
+}
+
+// parseCount parses a decimal number
+func parseCount(s string) (uint64, error) {
+	return strconv.ParseUint(s, 10, 64)
+}
+
+// parseSize parses a size in bytes with an optional suffix of "K", "M" or "G"
+func parseSize(s string) (uint64, error) {
+	unit := uint64(1)
+	switch s[len(s)-1:] {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	}
+	if unit != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n > ^uint64(0)/unit {
+		return 0, fmt.Errorf("size too large: %s", s)
+	}
+	return n * unit, nil
+}
+
+// String returns limits in the form of ParseResourceLimits.
+func (l ResourceLimits) String() string {
+	var limits []string
+	for _, limit := range []struct {
+		name  string
+		value uint64
+	}{{"cpu", l.CPU}, {"as", l.AddressSpace}, {"nofile", l.Files}, {"nproc", l.Processes}} {
+		if limit.value != 0 {
+			limits = append(limits, limit.name+"="+strconv.FormatUint(limit.value, 10))
+		}
+	}
+	return strings.Join(limits, ",")
+}
+
+// IsZero reports whether no limits are set.
+func (l ResourceLimits) IsZero() bool {
+	return l == ResourceLimits{}
+}
+
+// or fills limits not set in l with the ones of other
+func (l ResourceLimits) or(other ResourceLimits) ResourceLimits {
+	if l.CPU == 0 {
+		l.CPU = other.CPU
+	}
+	if l.AddressSpace == 0 {
+		l.AddressSpace = other.AddressSpace
+	}
+	if l.Files == 0 {
+		l.Files = other.Files
+	}
+	if l.Processes == 0 {
+		l.Processes = other.Processes
+	}
+	return l
+}
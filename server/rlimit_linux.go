@@ -0,0 +1,27 @@
+package server
+
+import (
+	"github.com/John-Ao/go-sshd/rlimit"
+	"golang.org/x/sys/unix"
+)
+
+// rlimits returns the limits set in l
+func (l ResourceLimits) rlimits() []rlimit.Limit {
+	var limits []rlimit.Limit
+	for _, limit := range []rlimit.Limit{{Resource: unix.RLIMIT_CPU, Value: l.CPU}, {Resource: unix.RLIMIT_AS, Value: l.AddressSpace}, {Resource: unix.RLIMIT_NOFILE, Value: l.Files}, {Resource: unix.RLIMIT_NPROC, Value: l.Processes}} {
+		if limit.Value != 0 {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+// setResourceLimits sets limits of the process of pid
+func setResourceLimits(pid int, limits ResourceLimits) error {
+	for _, limit := range limits.rlimits() {
+		if err := unix.Prlimit(pid, limit.Resource, &unix.Rlimit{Cur: limit.Value, Max: limit.Value}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
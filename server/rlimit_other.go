@@ -0,0 +1,18 @@
+//go:build !linux
+
+package server
+
+import (
+	"fmt"
+
+	"github.com/John-Ao/go-sshd/rlimit"
+)
+
+// rlimits returns no limits, which rlimit.Command does not support on this platform
+func (l ResourceLimits) rlimits() []rlimit.Limit {
+	return nil
+}
+
+func setResourceLimits(pid int, limits ResourceLimits) error {
+	return fmt.Errorf("resource limits are not supported on this platform")
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+)
+
+// SandboxUser is an OS user to run processes and SFTP of all sessions as, whoever is authenticated.
+type SandboxUser struct {
+	Name    string
+	UID     uint32
+	GID     uint32
+	Groups  []uint32
+	HomeDir string
+}
+
+// LookupSandboxUser returns the OS user of name with its primary and supplementary groups.
+func LookupSandboxUser(name string) (*SandboxUser, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported user ID: %s", u.Uid)
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported group ID: %s", u.Gid)
+	}
+	sandboxUser := &SandboxUser{Name: u.Username, UID: uint32(uid), GID: uint32(gid), HomeDir: u.HomeDir}
+	groupIDs, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range groupIDs {
+		if n, err := strconv.ParseUint(id, 10, 32); err == nil {
+			sandboxUser.Groups = append(sandboxUser.Groups, uint32(n))
+		}
+	}
+	return sandboxUser, nil
+}
+
+// workingDir returns the home directory, or "/" if it does not exist like sshd
+func (u *SandboxUser) workingDir() string {
+	if info, err := os.Stat(u.HomeDir); err == nil && info.IsDir() {
+		return u.HomeDir
+	}
+	return "/"
+}
+
+// env returns the environment variables of the user
+func (u *SandboxUser) env() []string {
+	return []string{"HOME=" + u.HomeDir, "USER=" + u.Name, "LOGNAME=" + u.Name}
+}
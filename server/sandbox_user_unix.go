@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCredential makes cmd run as u
+func setCredential(cmd *exec.Cmd, u *SandboxUser) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: u.UID, Gid: u.GID, Groups: u.Groups}
+	cmd.Env = append(cmd.Env, u.env()...)
+	return nil
+}
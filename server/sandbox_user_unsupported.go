@@ -0,0 +1,13 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+func setCredential(cmd *exec.Cmd, u *SandboxUser) error {
+	return fmt.Errorf("sandbox user unsupported")
+}
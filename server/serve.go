@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Serve accepts connections on ln and serves each one with ServeConn until ln is closed.
+func (s *Server) Serve(ln net.Listener) error {
+	if s.Config == nil {
+		return fmt.Errorf("Config is not set")
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			s.Logger.Error("failed to accept connection", "err", err)
+			continue
+		}
+		s.GoServeConn(conn)
+	}
+}
+
+// GoServeConn serves conn by ServeConn in a new goroutine after a slot of HandshakePool is free, or closes it if shed.
+// The slot is freed after the handshake.
+func (s *Server) GoServeConn(conn net.Conn) {
+	ticket := s.HandshakePool.Enter()
+	if ticket == nil {
+		s.shedConn(conn)
+		return
+	}
+	go func() {
+		// Queued connections are waited for by Drain as handshakes
+		s.handshakes.Add(1)
+		// The client is not noticed disconnecting before the handshake reads from conn
+		ok := ticket.Wait(context.Background())
+		s.handshakes.Add(-1)
+		if !ok {
+			s.shedConn(conn)
+			return
+		}
+		sshConn, chans, reqs, ok := s.handshake(conn)
+		ticket.Leave()
+		if ok {
+			s.HandleConn(sshConn, s.Shell, chans, reqs)
+		}
+	}()
+}
+
+func (s *Server) shedConn(conn net.Conn) {
+	s.Logger.Warn("shed connection by the handshake pool", "remote_address", conn.RemoteAddr().String())
+	s.stats.shedConnections.Add(1)
+	conn.Close()
+}
+
+// ServeConn performs the SSH handshake on conn with Config and serves the connection with HandleConn.
+func (s *Server) ServeConn(conn net.Conn) {
+	if sshConn, chans, reqs, ok := s.handshake(conn); ok {
+		s.HandleConn(sshConn, s.Shell, chans, reqs)
+	}
+}
+
+// handshake performs the SSH handshake on conn and checks the login. conn is closed if it returns false.
+func (s *Server) handshake(conn net.Conn) (*ssh.ServerConn, <-chan ssh.NewChannel, <-chan *ssh.Request, bool) {
+	s.handshakes.Add(1)
+	start := time.Now()
+	remoteAddr := conn.RemoteAddr().String()
+	if s.CheckConn != nil {
+		if err := s.CheckConn(conn.RemoteAddr()); err != nil {
+			s.Logger.Info("rejected connection", "remote_address", remoteAddr, "reason", err)
+			s.handshakes.Add(-1)
+			conn.Close()
+			return nil, nil, nil, false
+		}
+	}
+	location := s.location(conn.RemoteAddr())
+	if !s.GeoIPRules.Allowed(location) {
+		s.Logger.Info("rejected location", append([]any{"remote_address", remoteAddr}, locationAttrs(location)...)...)
+		s.handshakes.Add(-1)
+		conn.Close()
+		return nil, nil, nil, false
+	}
+	vconn := &versionConn{Conn: conn, check: func(version string) error {
+		return s.checkClientVersion(remoteAddr, version)
+	}}
+	sshConn, chans, reqs, err := ssh.NewServerConn(vconn, s.Config)
+	if err != nil {
+		s.Logger.Info("failed to handshake", append([]any{"remote_address", remoteAddr, "client_version", vconn.version, "err", err}, locationAttrs(location)...)...)
+		s.handshakes.Add(-1)
+		conn.Close()
+		return nil, nil, nil, false
+	}
+	s.handshakes.Add(-1)
+	s.stats.handshakeDurations.observe(time.Since(start))
+	if s.CheckLogin != nil {
+		if err := s.CheckLogin(sshConn); err != nil {
+			s.Logger.Info("rejected login", append([]any{"user", sshConn.User(), "remote_address", remoteAddr, "reason", err}, locationAttrs(location)...)...)
+			sshConn.Close()
+			return nil, nil, nil, false
+		}
+	}
+	return sshConn, chans, reqs, true
+}
+
+// Drain waits until handshakes by ServeConn and connections served by HandleConn finish, or ctx is done.
+// Close the listeners passed to Serve first to stop accepting.
+func (s *Server) Drain(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for s.handshakes.Load() != 0 || s.stats.activeConnections.Load() != 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// checkClientVersion returns an error if version of the client of remoteAddr is not allowed by ClientVersions
+func (s *Server) checkClientVersion(remoteAddr, version string) error {
+	s.clientVersionOnce.Do(func() {
+		s.clientVersions, s.clientVersionsErr = s.ClientVersions.compile()
+	})
+	if s.clientVersionsErr != nil {
+		return s.clientVersionsErr
+	}
+	if s.clientVersions == nil {
+		return nil
+	}
+	if err := s.clientVersions.check(version); err != nil {
+		s.Logger.Info("rejected client version", "remote_address", remoteAddr, "client_version", version, "reason", err)
+		return err
+	}
+	return nil
+}
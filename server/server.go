@@ -8,286 +8,648 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
-	"encoding/binary"
 	"encoding/pem"
 	"fmt"
 	"io"
 	"net"
-	"os"
-	"os/exec"
-	"strconv"
+	"strings"
 	"sync"
-
+	"sync/atomic"
+	"time"
+
+	"github.com/John-Ao/go-sshd/geoip"
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/server/forward"
+	"github.com/John-Ao/go-sshd/server/session"
+	"github.com/John-Ao/go-sshd/server/sftpd"
 	"github.com/John-Ao/go-sshd/sync_generics"
 
 	"github.com/mattn/go-shellwords"
-	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/exp/slog"
 )
 
 type Server struct {
 	Logger                *slog.Logger
-	bindAddressToListener sync_generics.Map[string, net.Listener]
-
-	// Permissions
+	forwarder             forward.Forwarder
+	channelHandlers       sync_generics.Map[string, ChannelHandler]
+	globalRequestHandlers sync_generics.Map[string, GlobalRequestHandler]
+	userSessions          sync_generics.Map[string, *atomic.Int64]
+	connections           sync_generics.Map[string, *connection]
+	stats                 serverStats
+	subscribers           sync_generics.Map[uint64, *subscriber]
+	subscribersMu         sync.RWMutex
+	lastSubscriberID      atomic.Uint64
+	handshakes            atomic.Int64
+	clientVersionOnce     sync.Once
+	clientVersions        *compiledClientVersionPolicy
+	clientVersionsErr     error
+
+	// Config is used for handshakes by Serve and ServeConn. Authentication callbacks, host keys, algorithms and the version are of the caller.
+	// Set AuthLog to its AuthLogCallback to count authentication failures and publish EventAuth.
+	// Algorithms.Apply sets the algorithms in the form of sshd_config.
+	Config *ssh.ServerConfig
+	// Shell is the shell for "shell" requests of connections served by Serve and ServeConn.
+	// $SHELL is used if empty, or sh, or PowerShell or %ComSpec% on Windows without it.
+	Shell string
+	// LoginShells uses the login shells of the users in /etc/passwd on Unix, unless ExtensionShell specifies one.
+	// Users not found get Shell.
+	LoginShells bool
+	// WindowsExecShell is LocalExecutor.WindowsExecShell of the default LocalExecutor.
+	WindowsExecShell string
+
+	// Permissions. They can be overridden per connection by ExtensionPermissions.
 	AllowTcpipForward       bool
 	AllowDirectTcpip        bool
 	AllowExecute            bool // this should not be split into "allow-exec" and "allow-pty-req" for now because "pty-req" can be used not for shell execution.
 	AllowSftp               bool
 	AllowStreamlocalForward bool
 	AllowDirectStreamlocal  bool
+	// AllowScp allows "exec" of scp without AllowExecute, e.g. for users only copying files.
+	AllowScp bool
+	// AllowAgentForward allows "auth-agent-req@openssh.com" (ssh -A) for processes started by the server.
+	AllowAgentForward bool
+	// AllowX11Forward allows "x11-req" (ssh -X) for processes started by the server.
+	AllowX11Forward bool
+	// AllowAttach allows attaching read-only to pty sessions of other connections of the same user or AttachTargets by AttachCommand, e.g. to supervise them.
+	AllowAttach bool
+	// AllowAttachWrite allows attaching read-write to pty sessions like AllowAttach, e.g. for pair debugging.
+	AllowAttachWrite bool
+	// AttachTargets are the users whose pty sessions users can attach to in addition to their own ones, or "*" for all users.
+	AttachTargets []string
+	// ForceCommand is the command line run for "shell" and "exec" requests instead of the requested ones if not empty, like ForceCommand of sshd_config.
+	// The command line of "exec" is passed in SSH_ORIGINAL_COMMAND. ForceCommandInternalSftp allows only the SFTP subsystem, and other forced commands reject subsystems.
+	// It can be overridden per connection by ExtensionForceCommand.
+	ForceCommand string
+	// DenyPty rejects "pty-req" even if execution is allowed. It can be overridden per connection by ExtensionDenyPty.
+	DenyPty bool
+
+	// ClientVersions allows or denies clients by their identification strings before the key exchange.
+	ClientVersions ClientVersionPolicy
+
+	// GenericOpenFailures sends only the description of the reason code such as "connect failed" to clients
+	// when channels are rejected, not to reveal destinations and their errors. Messages are logged at the debug level.
+	GenericOpenFailures bool
+
+	// ChannelRate limits the channels opened by each connection, which are rejected over it.
+	ChannelRate RateLimit
+	// ForwardChannelRate limits the "direct-tcpip" and "direct-streamlocal@openssh.com" channels opened by each connection
+	// in addition to ChannelRate, e.g. not to be used for port scanning.
+	ForwardChannelRate RateLimit
+	// MaxChannels is the maximum number of channels opened by each connection at once, such as sessions and forwarding channels, if not zero.
+	// Channels over it are rejected.
+	MaxChannels int
+	// MaxTransfer is the maximum number of bytes received and sent through the channels of each connection, such as sessions, SFTP and forwards, if not zero.
+	// Connections over it are closed after notifying their sessions, so the bytes in flight then exceed it a little.
+	MaxTransfer uint64
+	// StallTimeout is how long a write of a relay of forwarding, agent forwarding, X11 forwarding or Upstream can block
+	// before the relay is closed as stalled, e.g. because the receiver stops reading but keeps its connection alive, if not zero.
+	// Clients stalling relays are disconnected.
+	StallTimeout time.Duration
+
+	// MalformedRequests is the policy for requests and channels with malformed payloads. They are rejected by default.
+	MalformedRequests MalformedRequestPolicy
+
+	// Authorizer decides each exec, SFTP request and forwarding allowed by permissions if not nil.
+	Authorizer Authorizer
+
+	// Upstream chooses a backend SSH server for an authenticated connection if not nil.
+	// All channels and requests of the connection are proxied to the backend and permissions above are not applied.
+	Upstream func(conn ssh.ConnMetadata) (*Upstream, error)
+
+	// Executor starts processes for "shell" and "exec" requests. LocalExecutor with PtyFactory is used if nil.
+	Executor Executor
+	// PtyFactory starts processes attached to pseudo terminals for the default LocalExecutor.
+	PtyFactory PtyFactory
+	// ChrootDirectory is LocalExecutor.ChrootDirectory of the default LocalExecutor, whose processes run as SandboxUser or the OS users of user names. SFTP is not affected.
+	// It can be overridden per connection by ExtensionChrootDirectory.
+	ChrootDirectory string
+	// ResourceLimits are the limits of processes of LocalExecutor on Linux like ProcessSpec.ResourceLimits. They can be overridden per connection by ExtensionResourceLimits.
+	ResourceLimits ResourceLimits
+	// CgroupParent is LocalExecutor.CgroupParent of the default LocalExecutor.
+	CgroupParent string
+	// CgroupLimits are the cgroup controls of processes in CgroupParent. They can be overridden per connection by ExtensionCgroupLimits.
+	CgroupLimits CgroupLimits
+	// SeccompProfile is LocalExecutor.SeccompProfile of the default LocalExecutor.
+	SeccompProfile string
+	// SandboxUser is the user to run processes of the default LocalExecutor and SFTP as if not nil, whoever is authenticated.
+	// SFTP is served by the executable started as the helper of sftpd.Command, so its main must call sftpd.RunHelper. Authorizer is not supported for SFTP.
+	SandboxUser *SandboxUser
+	// Privileged is LocalExecutor.Privileged of the default LocalExecutor, which also starts the helper of SFTP by it.
+	Privileged func(f func() error) error
+	// Namespaces are the Linux namespaces to run processes of LocalExecutor in. They can be replaced per connection by ExtensionNamespaces.
+	Namespaces namespaces.Namespaces
+
+	// Handler serves "shell" and "exec" requests of sessions instead of the built-in shell/command execution if not nil.
+	Handler func(Session)
+
+	// ObscureKeystrokeTiming is the interval in which the output of "shell" and "exec" sessions with a pty is written
+	// while input is arriving, with chaff in intervals without output, to hide the timing of keystrokes from traffic analysis
+	// like ObscureKeystrokeTiming of OpenSSH, if not zero.
+	ObscureKeystrokeTiming time.Duration
+
+	// ConnectionLog opens a writer to which all log lines of each connection served by HandleConn are also written
+	// in the text format of slog if not nil, e.g. for per-connection log files. The writer is closed when the connection is closed.
+	ConnectionLog func(conn *ConnMetadata, startTime time.Time) (io.WriteCloser, error)
+
+	// SessionRecording opens a writer to which the output of each "shell" and "exec" session is recorded
+	// in the format of the recording package if not nil. The writer is closed when the session ends.
+	SessionRecording func(conn *ConnMetadata, sessionID string, startTime time.Time) (io.WriteCloser, error)
+
+	// CommandHistory is called with the command of each "exec" session if not nil, e.g. to append it to a history file of the user.
+	CommandHistory func(conn *ConnMetadata, entry HistoryEntry)
+	// HistoryShellInput also passes the lines typed into "shell" sessions with a pty to CommandHistory.
+	// They are reconstructed from the keys without the shell, so lines edited by arrows or completed by tabs are inexact, and passwords typed without echo are included.
+	HistoryShellInput bool
+
+	// CheckConn is called with the remote address of each connection before the handshake if not nil.
+	// Connections are closed if it returns an error.
+	CheckConn func(remoteAddr net.Addr) error
+	// CheckLogin is called with each connection served by ServeConn after the handshake if not nil,
+	// e.g. to consume a one-time credential only when authentication succeeded. Connections are closed if it returns an error.
+	CheckLogin func(sshConn *ssh.ServerConn) error
+
+	// HandshakePool bounds the handshakes by Serve and GoServeConn if not nil. Connections shed by it are closed.
+	HandshakePool *Pool
+	// ChannelPool bounds the channels served at once by all connections if not nil. Channels shed by it are rejected.
+	ChannelPool *Pool
+
+	// GeoIP looks up the locations of clients, which are added to logs and events, if not nil.
+	// Connections from locations not allowed by GeoIPRules are closed before the handshake.
+	GeoIP      *geoip.DB
+	GeoIPRules geoip.Rules
+
+	// OnEvent is called synchronously with each event if not nil, e.g. for an audit log which must not drop events.
+	OnEvent func(Event)
 
 	// TODO: DNS server ?
 }
 
-type exitStatusMsg struct {
-	Status uint32
+// ChannelHandler serves a channel of a type registered with HandleChannelType.
+// The handler is responsible for accepting or rejecting newChannel.
+type ChannelHandler func(conn *ConnMetadata, newChannel ssh.NewChannel)
+
+// GlobalRequestHandler serves a global request of a type registered with HandleGlobalRequestType.
+// The handler is responsible for replying to req when req.WantReply is true.
+type GlobalRequestHandler func(conn *ConnMetadata, req *ssh.Request)
+
+// HandleChannelType registers handler for channels of type name (e.g. "rpc@example.com").
+// Channel types not registered here nor built in are rejected as unknown.
+func (s *Server) HandleChannelType(name string, handler ChannelHandler) {
+	s.channelHandlers.Store(name, handler)
 }
 
+// HandleConn serves global requests and channels of sshConn until the connection is closed.
+func (s *Server) HandleConn(sshConn *ssh.ServerConn, shell string, chans <-chan ssh.NewChannel, reqs <-chan *ssh.Request) {
+	conn := s.newConnection(sshConn)
+	closeLog := s.openConnectionLog(conn)
+	defer closeLog()
+	conn.logger.Info("new SSH connection", "client_version", string(sshConn.ClientVersion()))
+	s.connections.Store(conn.id, conn)
+	s.stats.activeConnections.Add(1)
+	s.stats.connections.Add(1)
+	s.publish(conn, Event{Type: EventConnectionOpened})
+	defer func() {
+		s.connections.Delete(conn.id)
+		s.stats.activeConnections.Add(-1)
+		s.publish(conn, Event{Type: EventConnectionClosed})
+	}()
+	defer s.closeLifecycle(conn)
+	if disconnectAt := connDisconnectAt(sshConn); !disconnectAt.IsZero() {
+		timer := time.AfterFunc(time.Until(disconnectAt), func() {
+			conn.logger.Info("closing connection at its end", "disconnect_at", disconnectAt)
+			sshConn.Close()
+		})
+		defer timer.Stop()
+	}
+	if s.Upstream != nil {
+		s.proxyConn(conn, chans, reqs)
+		return
+	}
+	conn.lifecycle.Go(func() { s.handleGlobalRequests(conn, reqs) })
+	s.handleChannels(conn, shell, chans)
+	conn.logger.Info("SSH connection closed")
+}
+
+// HandleChannels serves chans. Use HandleConn instead to let Session know its connection.
 func (s *Server) HandleChannels(shell string, chans <-chan ssh.NewChannel) {
+	conn := s.newConnection(nil)
+	defer s.closeLifecycle(conn)
+	s.handleChannels(conn, shell, chans)
+}
+
+// closeLifecycle kills the processes of conn and waits for its goroutines after it is closed
+func (s *Server) closeLifecycle(conn *connection) {
+	if !conn.lifecycle.close(lifecycleCloseTimeout) {
+		conn.logger.Warn("goroutines still running after connection closed", "goroutines", conn.lifecycle.goroutines.Load(), "processes", conn.lifecycle.processes.Load())
+	}
+}
+
+func (s *Server) handleChannels(conn *connection, shell string, chans <-chan ssh.NewChannel) {
 	// Service the incoming Channel channel in go routine
 	for newChannel := range chans {
-		go s.handleChannel(shell, newChannel)
+		newChannel := newChannel
+		s.goChannel(conn, newChannel, func() { s.handleChannel(conn, shell, newChannel) })
 	}
 }
 
-func (s *Server) handleChannel(shell string, newChannel ssh.NewChannel) {
+// goChannel serves newChannel by handle in a goroutine of conn after a slot of ChannelPool is free,
+// or rejects it if rate limited, over MaxChannels or shed. It is called by the loop of the channels of conn, so the channels are counted in order.
+func (s *Server) goChannel(conn *connection, newChannel ssh.NewChannel, handle func()) {
+	if !conn.allowChannel(newChannel.ChannelType()) {
+		s.rateLimitChannel(conn, newChannel)
+		return
+	}
+	if s.MaxChannels != 0 && conn.activeChannels.Load() >= int64(s.MaxChannels) {
+		conn.logger.Info("too many channels", "channel_type", newChannel.ChannelType(), "max_channels", s.MaxChannels)
+		newChannel.Reject(ssh.ResourceShortage, "too many channels")
+		return
+	}
+	ticket := s.ChannelPool.Enter()
+	if ticket == nil {
+		s.shedChannel(conn, newChannel)
+		return
+	}
+	conn.activeChannels.Add(1)
+	conn.lifecycle.Go(func() {
+		defer conn.activeChannels.Add(-1)
+		if !ticket.Wait(conn.lifecycle.ctx) {
+			// Nothing to reject if the connection is closed while queued
+			if conn.lifecycle.ctx.Err() == nil {
+				s.shedChannel(conn, newChannel)
+			}
+			return
+		}
+		defer ticket.Leave()
+		handle()
+	})
+}
+
+func (s *Server) shedChannel(conn *connection, newChannel ssh.NewChannel) {
+	conn.logger.Warn("shed channel by the channel pool", "channel_type", newChannel.ChannelType())
+	s.stats.shedChannels.Add(1)
+	newChannel.Reject(ssh.ResourceShortage, "server busy")
+}
+
+// rateLimitChannel rejects newChannel over the rate limits of conn. Only the first one of the connection is logged above the debug level not to flood logs by scans.
+func (s *Server) rateLimitChannel(conn *connection, newChannel ssh.NewChannel) {
+	level := slog.LevelDebug
+	if conn.rateLimited.Add(1) == 1 {
+		level = slog.LevelWarn
+	}
+	conn.logger.Log(context.Background(), level, "channel rate exceeded", "channel_type", newChannel.ChannelType())
+	s.stats.rateLimitedChannels.Add(1)
+	newChannel.Reject(ssh.ResourceShortage, "channel rate exceeded")
+}
+
+func (s *Server) handleChannel(conn *connection, shell string, newChannel ssh.NewChannel) {
+	logger := conn.channelLogger(newChannel.ChannelType())
+	newChannel = &countingNewChannel{NewChannel: newChannel, stats: &s.stats, user: conn.traffic, limit: conn.transferLimit, forward: isForwardChannelType(newChannel.ChannelType())}
+	if s.GenericOpenFailures {
+		newChannel = &genericRejectNewChannel{NewChannel: newChannel}
+	}
+	if debugEnabled(logger) {
+		logger.Debug("channel opened", "extra_data", debugPayload(newChannel.ExtraData()))
+		newChannel = &debugNewChannel{NewChannel: newChannel, logger: logger}
+	}
 	switch newChannel.ChannelType() {
 	case "session":
-		s.handleSession(shell, newChannel)
+		if conn.maxSessions != 0 {
+			userSessions, _ := s.userSessions.LoadOrStore(conn.sshConn.User(), new(atomic.Int64))
+			defer userSessions.Add(-1)
+			if userSessions.Add(1) > int64(conn.maxSessions) {
+				logger.Info("too many sessions", "max_sessions", conn.maxSessions)
+				newChannel.Reject(ssh.ResourceShortage, "too many sessions")
+				break
+			}
+		}
+		if conn.maxConnSessions != 0 {
+			defer conn.openSessions.Add(-1)
+			if conn.openSessions.Add(1) > int64(conn.maxConnSessions) {
+				logger.Info("too many sessions of the connection", "max_conn_sessions", conn.maxConnSessions)
+				newChannel.Reject(ssh.ResourceShortage, "too many sessions")
+				break
+			}
+		}
+		s.stats.activeSessions.Add(1)
+		defer s.stats.activeSessions.Add(-1)
+		s.stats.sessions.Add(1)
+		if s.Handler != nil {
+			s.handleSessionWithHandler(logger, conn, newChannel)
+			break
+		}
+		if conn.shell != "" {
+			shell = conn.shell
+		}
+		s.handleSession(logger, conn, shell, newChannel)
 	case "direct-tcpip":
-		if !s.AllowDirectTcpip {
+		if !conn.permissions.directTcpip {
 			newChannel.Reject(ssh.Prohibited, "direct-tcpip not allowed")
 			break
 		}
-		s.handleDirectTcpip(newChannel)
+		s.forwarder.HandleDirectTcpip(logger, s.forwardHooks(logger, conn), newChannel)
 	case "direct-streamlocal@openssh.com":
-		if !s.AllowDirectStreamlocal {
+		if !conn.permissions.directStreamlocal {
 			newChannel.Reject(ssh.Prohibited, "direct-streamlocal (Unix domain socket) not allowed")
 			break
 		}
-		s.handleDirectStreamlocal(newChannel)
+		s.forwarder.HandleDirectStreamlocal(logger, s.forwardHooks(logger, conn), newChannel)
 	default:
+		if handler, ok := s.channelHandlers.Load(newChannel.ChannelType()); ok {
+			handler(conn.metadata, newChannel)
+			break
+		}
 		newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", newChannel.ChannelType()))
 	}
 }
 
-func (s *Server) handleSession(shell string, newChannel ssh.NewChannel) {
+func (s *Server) handleSession(logger *slog.Logger, conn *connection, shell string, newChannel ssh.NewChannel) {
 	// At this point, we have the opportunity to reject the client's
 	// request for another logical connection
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
-		s.Logger.Info("Could not accept channel", "err", err)
+		logger.Info("Could not accept channel", "err", err)
 		return
 	}
 
-	var shf *os.File = nil
+	active, connection, removeSession := conn.addSession(connection)
+	defer removeSession()
+	spec := &ProcessSpec{User: conn.metadata.User(), Dir: conn.homeDir, Conn: conn.metadata, ResourceLimits: conn.resourceLimits, CgroupLimits: conn.cgroupLimits, Namespaces: conn.namespaces, ChrootDirectory: conn.chrootDirectory}
+	var process Process
+	// attached is true if the session is attached to another one by AttachCommand
+	var attached bool
+	// env is set by "env" requests
+	var env []string
+	var forwards sessionForwards
+	defer forwards.close()
 
 	for req := range requests {
 		switch req.Type {
-		case "exec":
-			if !s.AllowExecute {
-				s.Logger.Info("execution not allowed (exec)")
+		case "env":
+			name, value, err := session.ParseEnv(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if process != nil {
 				req.Reply(false, nil)
 				break
 			}
-			s.handleExecRequest(req, connection)
-		case "shell":
-			// We only accept the default shell
-			// (i.e. no command in the Payload)
-			if len(req.Payload) == 0 {
-				req.Reply(true, nil)
+			env = append(env, name+"="+value)
+			req.Reply(true, nil)
+		case "shell", "exec":
+			if args, ok := attachArgs(req.Payload); ok && req.Type == "exec" && conn.forceCommand == "" {
+				if process != nil || attached {
+					req.Reply(false, nil)
+					break
+				}
+				attached = s.handleAttach(logger, conn, req, connection, active, args)
+				break
+			}
+			// A forced command is not scp
+			if !conn.permissions.execute && !(req.Type == "exec" && conn.permissions.scp && conn.forceCommand == "") {
+				logger.Info(fmt.Sprintf("execution not allowed (%s)", req.Type))
+				req.Reply(false, nil)
+				break
 			}
+			if process != nil || attached {
+				req.Reply(false, nil)
+				break
+			}
+			// rawCommand is the command line of "exec" even if it is replaced by the forced command
+			var rawCommand string
+			if req.Type == "exec" {
+				rawCommand, err = session.ParseExec(req.Payload)
+				if err != nil {
+					s.malformedRequest(logger, conn, req, err)
+					break
+				}
+			}
+			if conn.forceCommand != "" {
+				cmdSlice, err := conn.forcedCommand()
+				if err != nil {
+					logger.Info("failed to force command", "err", err)
+					req.Reply(false, nil)
+					break
+				}
+				spec.RawCommand = conn.forceCommand
+				spec.Command = cmdSlice
+			} else if req.Type == "exec" {
+				cmdSlice, err := shellwords.Parse(rawCommand)
+				if err != nil || len(cmdSlice) == 0 {
+					req.Reply(false, nil)
+					break
+				}
+				// The shell on Windows runs the command line as is, so the checked command must be all of it
+				restricted := !conn.permissions.execute || s.Authorizer != nil
+				if rawExecCommandLines && restricted && strings.ContainsAny(rawCommand, windowsExecMetacharacters) {
+					logger.Info("execution not allowed (exec)", "reason", "shell metacharacters")
+					req.Reply(false, nil)
+					break
+				}
+				if !conn.permissions.executes(cmdSlice) {
+					logger.Info("execution not allowed (exec)")
+					req.Reply(false, nil)
+					break
+				}
+				spec.RawCommand = rawCommand
+				spec.Command = cmdSlice
+			} else {
+				spec.Command = []string{defaultShell(shell)}
+			}
+			if !s.authorize(logger, conn, &Action{Type: req.Type, Command: spec.Command, RawCommand: spec.RawCommand}) {
+				req.Reply(false, nil)
+				break
+			}
+			spec.Env = forwards.env
+			// Variables such as LD_PRELOAD could run other programs than scp
+			if conn.permissions.execute {
+				spec.Env = append(env, forwards.env...)
+			}
+			// Clients can't override them
+			spec.Env = append(spec.Env, conn.sshEnv()...)
+			spec.Env = append(spec.Env, conn.forcedEnv(rawCommand)...)
+			process, err = s.executor().Start(spec)
+			if err != nil {
+				logger.Info("failed to start process", "err", err)
+				req.Reply(false, nil)
+				break
+			}
+			req.Reply(true, nil)
+			command := spec.Command
+			active.start(req.Type, command, spec.Pty != nil)
+			active.setPID(processPID(process))
+			if spec.Pty != nil {
+				active.setInput(process.Stdin())
+			}
+			s.startRecording(conn, active, spec.Pty)
+			s.publish(conn, Event{Type: EventSessionStarted, Command: command})
+			if req.Type == "exec" {
+				s.recordHistory(conn, active.snapshot().ID, HistoryExec, rawCommand)
+			}
+			var channel ssh.Channel = connection
+			if spec.Pty != nil && s.ObscureKeystrokeTiming > 0 {
+				channel = newObscuredChannel(connection, s.ObscureKeystrokeTiming)
+			}
+			if spec.Pty != nil && req.Type == "shell" {
+				channel = s.historyInput(conn, active.snapshot().ID, channel)
+			}
+			runProcess(logger, conn.lifecycle, channel, process, func(exitCode int) {
+				s.publish(conn, Event{Type: EventSessionEnded, Command: command, ExitCode: exitCode})
+			})
 		case "pty-req":
-			if !s.AllowExecute {
-				s.Logger.Info("execution not allowed (pty-req)")
+			// Terminals of attached users are in raw mode with a pty
+			if !conn.permissions.execute && !conn.permissions.attach {
+				logger.Info("execution not allowed (pty-req)")
+				req.Reply(false, nil)
+				break
+			}
+			if conn.denyPty {
+				logger.Info("pty not allowed")
 				req.Reply(false, nil)
 				break
 			}
-			termLen := req.Payload[3]
-			w, h := parseDims(req.Payload[termLen+4:])
-			shf, err = s.createPty(shell, connection)
+			pty, err := session.ParsePtyRequest(req.Payload)
 			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if process != nil {
 				req.Reply(false, nil)
-				return
+				break
 			}
-			setWinsize(shf, w, h)
+			spec.Pty = pty
 			// Responding true (OK) here will let the client
 			// know we have a pty ready for input
 			req.Reply(true, nil)
 		case "window-change":
-			w, h := parseDims(req.Payload)
-			if shf != nil {
-				setWinsize(shf, w, h)
+			window, err := session.ParseWindowChange(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if process != nil && spec.Pty != nil {
+				process.Resize(uint32(window.Width), uint32(window.Height))
+				active.tap.resize(window)
+			}
+		case "signal":
+			signal, err := session.ParseSignal(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if process == nil {
+				break
 			}
+			if err := process.Signal(signal); err != nil {
+				logger.Info("failed to signal", "signal", signal, "err", err)
+			}
+		case "auth-agent-req@openssh.com":
+			s.handleAgentRequest(logger, conn, req, &forwards, process != nil)
+		case "x11-req":
+			s.handleX11Request(logger, conn, req, &forwards, process != nil)
 		case "subsystem":
-			s.handleSessionSubSystem(req, connection)
+			s.handleSessionSubSystem(logger, conn, req, connection, active)
 		default:
-			s.Logger.Info("unsupported request", "req_type", req.Type)
+			logger.Info("unsupported request", "req_type", req.Type)
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
 		}
 	}
 }
 
-func (s *Server) handleExecRequest(req *ssh.Request, connection ssh.Channel) {
-	var msg struct {
-		Command string
-	}
-	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
-		s.Logger.Info("failed to parse message in exec", "err", err)
-		return
-	}
-	cmdSlice, err := shellwords.Parse(msg.Command)
-	if err != nil {
-		return
-	}
-	cmd := exec.Command(cmdSlice[0], cmdSlice[1:]...)
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return
-	}
-	stdout, err := cmd.StdoutPipe()
+func (s *Server) handleSessionSubSystem(logger *slog.Logger, conn *connection, req *ssh.Request, connection ssh.Channel, active *activeSession) {
+	name, err := session.ParseSubsystem(req.Payload)
 	if err != nil {
+		s.malformedRequest(logger, conn, req, err)
 		return
 	}
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
+	if name != "sftp" {
+		req.Reply(false, nil)
 		return
 	}
-	go io.Copy(stdin, connection)
-	go io.Copy(connection, stdout)
-	go io.Copy(connection, stderr)
-	req.Reply(true, nil)
-	var exitCode int
-	if err := cmd.Run(); err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			exitCode = exitErr.ExitCode()
-		}
-	}
-	connection.SendRequest("exit-status", false, ssh.Marshal(exitStatusMsg{
-		Status: uint32(exitCode),
-	}))
-	connection.Close()
-}
-
-func (s *Server) handleSessionSubSystem(req *ssh.Request, connection ssh.Channel) {
-	// https://github.com/pkg/sftp/blob/42e9800606febe03f9cdf1d1283719af4a5e6456/examples/go-sftp-server/main.go#L111
-	if string(req.Payload[4:]) != "sftp" {
+	if conn.forceCommand != "" && !conn.forcesSftp() {
+		logger.Info("subsystem not allowed by forced command")
 		req.Reply(false, nil)
 		return
 	}
-	if !s.AllowSftp {
-		s.Logger.Info("sftp not allowed")
+	if !conn.permissions.sftp {
+		logger.Info("sftp not allowed")
 		req.Reply(false, nil)
 		return
 	}
 
 	req.Reply(true, nil)
-	serverOptions := []sftp.ServerOption{
-		sftp.WithDebug(os.Stderr),
-	}
-	sftpServer, err := sftp.NewServer(connection, serverOptions...)
-	if err != nil {
-		s.Logger.Info("failed to create sftp server", "err", err)
-		return
-	}
-	if err := sftpServer.Serve(); err == io.EOF {
-		sftpServer.Close()
-	} else if err != nil {
-		s.Logger.Info("failed to serve sftp server", "err", err)
-		return
-	}
-}
-
-// (base: https://github.com/peertechde/zodiac/blob/110fdd2dfd27359546c1cd75a9fec5de2882bf42/pkg/server/server.go#L228)
-func (s *Server) handleDirectTcpip(newChannel ssh.NewChannel) {
-	var msg struct {
-		RemoteAddr string
-		RemotePort uint32
-		SourceAddr string
-		SourcePort uint32
-	}
-	if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
-		s.Logger.Info("failed to parse direct-tcpip message", "err", err)
-		return
+	active.start("subsystem", []string{name}, false)
+	// transferStats counts bytes of this SFTP session only
+	var transferStats serverStats
+	connection = &countingChannel{Channel: connection, stats: &transferStats}
+	connection = &sftpChannel{Channel: connection, reader: sftpd.NewOperationReader(connection, func(operation sftpd.Operation) {
+		increment(&s.stats.sftpOperations, operation.Name)
+		if operation.Path != "" {
+			s.publish(conn, Event{Type: EventSftp, Operation: operation.Name, Path: operation.Path, TargetPath: operation.TargetPath})
+		}
+	})}
+	defer func() {
+		s.publish(conn, Event{Type: EventTransfer, BytesReceived: transferStats.bytesReceived.Load(), BytesSent: transferStats.bytesSent.Load()})
+	}()
+	options := sftpd.Options{WorkingDirectory: conn.homeDir}
+	if s.Authorizer != nil {
+		options.Authorize = func(req *sftpd.Request) bool {
+			return s.authorize(logger, conn, &Action{Type: ActionSftp, Operation: req.Operation, Path: req.Path, TargetPath: req.TargetPath})
+		}
 	}
-	channel, reqs, err := newChannel.Accept()
-	if err != nil {
-		s.Logger.Info("failed to accept", "err", err)
-		return
+	if s.SandboxUser != nil {
+		err = serveSftpAs(connection, options, s.SandboxUser, s.Privileged)
+	} else {
+		err = sftpd.Serve(connection, options)
 	}
-	go ssh.DiscardRequests(reqs)
-	raddr := net.JoinHostPort(msg.RemoteAddr, strconv.Itoa(int(msg.RemotePort)))
-	conn, err := net.Dial("tcp", raddr)
 	if err != nil {
-		s.Logger.Info("failed to dial", "err", err)
-		channel.Close()
-		return
-	}
-	var closeOnce sync.Once
-	closer := func() {
-		channel.Close()
-		conn.Close()
+		logger.Info("failed to serve sftp server", "err", err)
 	}
-	go func() {
-		io.Copy(channel, conn)
-		closeOnce.Do(closer)
-	}()
-	io.Copy(conn, channel)
-	closeOnce.Do(closer)
-	return
 }
 
-// client side: https://github.com/golang/crypto/blob/b4ddeeda5bc71549846db71ba23e83ecb26f36ed/ssh/streamlocal.go#L52
-func (s *Server) handleDirectStreamlocal(newChannel ssh.NewChannel) {
-	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L237
-	var msg struct {
-		SocketPath string
-		Reserved0  string
-		Reserved1  uint32
-	}
-	if err := ssh.Unmarshal(newChannel.ExtraData(), &msg); err != nil {
-		s.Logger.Info("failed to parse direct-streamlocal message", "err", err)
-		return
+// serveSftpAs serves SFTP on channel by the helper running as sandboxUser, started by run if not nil
+func serveSftpAs(channel ssh.Channel, options sftpd.Options, sandboxUser *SandboxUser, run func(f func() error) error) error {
+	if options.WorkingDirectory == "" {
+		options.WorkingDirectory = sandboxUser.workingDir()
 	}
-	channel, reqs, err := newChannel.Accept()
+	cmd, err := sftpd.Command(options)
 	if err != nil {
-		s.Logger.Info("failed to accept", "err", err)
-		return
+		return err
 	}
-	go ssh.DiscardRequests(reqs)
-	conn, err := net.Dial("unix", msg.SocketPath)
-	if err != nil {
-		s.Logger.Info("failed to dial", "err", err)
-		channel.Close()
-		return
+	if err := setCredential(cmd, sandboxUser); err != nil {
+		return err
 	}
-	var closeOnce sync.Once
-	closer := func() {
-		channel.Close()
-		conn.Close()
+	// Closing the channel after the helper exits ends the session like sftpd.Serve
+	defer channel.Close()
+	cmd.Stdin = channel
+	cmd.Stdout = channel
+	// The helper exits when the client closes the channel
+	cmd.WaitDelay = time.Second
+	if err := privileged(run, cmd.Start); err != nil {
+		return err
 	}
-	go func() {
-		io.Copy(channel, conn)
-		closeOnce.Do(closer)
-	}()
-	io.Copy(conn, channel)
-	closeOnce.Do(closer)
-	return
+	return cmd.Wait()
 }
 
-// =======================
+// sftpChannel is a channel read by reader
+type sftpChannel struct {
+	ssh.Channel
+	reader io.Reader
+}
 
-// parseDims extracts terminal dimensions (width x height) from the provided buffer.
-func parseDims(b []byte) (uint32, uint32) {
-	w := binary.BigEndian.Uint32(b)
-	h := binary.BigEndian.Uint32(b[4:])
-	return w, h
+func (c *sftpChannel) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
 }
 
+// =======================
+
 // ======================
 
 func GenerateKey() ([]byte, error) {
@@ -309,211 +671,63 @@ func GenerateKey() ([]byte, error) {
 
 // ======================================================================
 
+// HandleGlobalRequestType registers handler for global requests of type name.
+// A registered handler takes precedence over the built-in handling of the same type (e.g. "tcpip-forward").
+func (s *Server) HandleGlobalRequestType(name string, handler GlobalRequestHandler) {
+	s.globalRequestHandlers.Store(name, handler)
+}
+
+// HandleGlobalRequests serves global requests of sshConn. Use HandleConn instead to serve its channels too.
 func (s *Server) HandleGlobalRequests(sshConn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	conn := s.newConnection(sshConn)
+	defer s.closeLifecycle(conn)
+	s.handleGlobalRequests(conn, reqs)
+}
+
+func (s *Server) handleGlobalRequests(conn *connection, reqs <-chan *ssh.Request) {
+	logger := conn.logger
 	for req := range reqs {
+		req := req
+		if debugEnabled(logger) {
+			logger.Debug("global request", "req_type", req.Type, "want_reply", req.WantReply, "payload", debugPayload(req.Payload))
+		}
+		if handler, ok := s.globalRequestHandlers.Load(req.Type); ok {
+			conn.lifecycle.Go(func() { handler(conn.metadata, req) })
+			continue
+		}
 		switch req.Type {
 		case "tcpip-forward":
-			if !s.AllowTcpipForward {
-				s.Logger.Info("tcpip-forward not allowed")
+			if !conn.permissions.tcpipForward {
+				logger.Info("tcpip-forward not allowed")
 				req.Reply(false, nil)
 				break
 			}
-			go func() {
-				s.handleTcpipForward(sshConn, req)
-			}()
+			conn.lifecycle.Go(func() {
+				s.forwarder.HandleTcpipForward(logger, s.forwardHooks(logger, conn), conn.sshConn, req)
+			})
 		case "cancel-tcpip-forward":
-			go func() {
-				s.cancelTcpipForward(req)
-			}()
+			conn.lifecycle.Go(func() {
+				s.forwarder.CancelTcpipForward(logger, req)
+			})
 		case "streamlocal-forward@openssh.com":
-			if !s.AllowStreamlocalForward {
-				s.Logger.Info("streamlocal-forward not allowed")
+			if !conn.permissions.streamlocalForward {
+				logger.Info("streamlocal-forward not allowed")
 				req.Reply(false, nil)
 				break
 			}
-			go func() {
-				s.handleStreamlocalForward(sshConn, req)
-			}()
+			conn.lifecycle.Go(func() {
+				s.forwarder.HandleStreamlocalForward(logger, s.forwardHooks(logger, conn), conn.sshConn, req)
+			})
 		case "cancel-streamlocal-forward@openssh.com":
-			go func() {
-				s.cancelStreamlocalForward(req)
-			}()
+			conn.lifecycle.Go(func() {
+				s.forwarder.CancelStreamlocalForward(logger, req)
+			})
 		default:
 			// discard
 			if req.WantReply {
 				req.Reply(false, nil)
 			}
-			s.Logger.Info("request discarded", "request_type", req.Type)
-		}
-	}
-}
-
-// https://datatracker.ietf.org/doc/html/rfc4254#section-7.1
-func (s *Server) handleTcpipForward(sshConn *ssh.ServerConn, req *ssh.Request) {
-	var msg struct {
-		Addr string
-		Port uint32
-	}
-	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	address := net.JoinHostPort(msg.Addr, strconv.Itoa(int(msg.Port)))
-	ln, err := net.Listen("tcp", address)
-	if err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	s.bindAddressToListener.Store(address, ln)
-	req.Reply(true, nil)
-	go func() {
-		sshConn.Wait()
-		ln.Close()
-		s.Logger.Info("connection closed", "address", ln.Addr().String())
-	}()
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			s.Logger.Info("failed to accept", "err", err)
-			return
-		}
-		var replyMsg struct {
-			Addr           string
-			Port           uint32
-			OriginatorAddr string
-			OriginatorPort uint32
-		}
-		replyMsg.Addr = msg.Addr
-		replyMsg.Port = msg.Port
-		originatorAddr, originatorPortStr, err := net.SplitHostPort(conn.RemoteAddr().String())
-		if err == nil {
-			originatorPort, _ := strconv.Atoi(originatorPortStr)
-			replyMsg.OriginatorAddr = originatorAddr
-			replyMsg.OriginatorPort = uint32(originatorPort)
-		} else {
-			s.Logger.Error("failed to split remote address", "remote_address", conn.RemoteAddr())
-		}
-
-		go func() {
-			channel, reqs, err := sshConn.OpenChannel("forwarded-tcpip", ssh.Marshal(&replyMsg))
-			if err != nil {
-				req.Reply(false, nil)
-				conn.Close()
-				return
-			}
-			go ssh.DiscardRequests(reqs)
-			go func() {
-				io.Copy(channel, conn)
-				conn.Close()
-				channel.Close()
-			}()
-			go func() {
-				io.Copy(conn, channel)
-				conn.Close()
-				channel.Close()
-			}()
-		}()
-	}
-}
-
-// https://datatracker.ietf.org/doc/html/rfc4254#section-7.1
-func (s *Server) cancelTcpipForward(req *ssh.Request) {
-	var msg struct {
-		Addr string
-		Port uint32
-	}
-	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	address := net.JoinHostPort(msg.Addr, strconv.Itoa(int(msg.Port)))
-	ln, loaded := s.bindAddressToListener.LoadAndDelete(address)
-	if !loaded {
-		req.Reply(false, nil)
-		s.Logger.Info("failed to find listener", "address", address)
-	}
-	if err := ln.Close(); err != nil {
-		req.Reply(false, nil)
-		s.Logger.Info("failed to close", "err", err)
-	}
-	req.Reply(true, nil)
-}
-
-// client side: https://github.com/golang/crypto/blob/b4ddeeda5bc71549846db71ba23e83ecb26f36ed/ssh/streamlocal.go#L34
-func (s *Server) handleStreamlocalForward(sshConn *ssh.ServerConn, req *ssh.Request) {
-	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L272
-	var msg struct {
-		SocketPath string
-	}
-	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	ln, err := net.Listen("unix", msg.SocketPath)
-	if err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	s.bindAddressToListener.Store(msg.SocketPath, ln)
-	req.Reply(true, nil)
-	go func() {
-		sshConn.Wait()
-		ln.Close()
-		s.Logger.Info("connection closed", "address", ln.Addr().String())
-	}()
-	for {
-		conn, err := ln.Accept()
-		if err != nil {
-			s.Logger.Info("failed to accept", "err", err)
-			return
-		}
-		// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L255
-		var replyMsg struct {
-			SocketPath string
-			Reserved   string
+			logger.Info("request discarded", "request_type", req.Type)
 		}
-		replyMsg.SocketPath = msg.SocketPath
-
-		go func() {
-			channel, reqs, err := sshConn.OpenChannel("forwarded-streamlocal@openssh.com", ssh.Marshal(&replyMsg))
-			if err != nil {
-				req.Reply(false, nil)
-				conn.Close()
-				return
-			}
-			go ssh.DiscardRequests(reqs)
-			go func() {
-				io.Copy(channel, conn)
-				conn.Close()
-				channel.Close()
-			}()
-			go func() {
-				io.Copy(conn, channel)
-				conn.Close()
-				channel.Close()
-			}()
-		}()
 	}
 }
-
-func (s *Server) cancelStreamlocalForward(req *ssh.Request) {
-	// https://github.com/openssh/openssh-portable/blob/f9f18006678d2eac8b0c5a5dddf17ab7c50d1e9f/PROTOCOL#L280
-	var msg struct {
-		SocketPath string
-	}
-	if err := ssh.Unmarshal(req.Payload, &msg); err != nil {
-		req.Reply(false, nil)
-		return
-	}
-	ln, loaded := s.bindAddressToListener.LoadAndDelete(msg.SocketPath)
-	if !loaded {
-		s.Logger.Info("failed to find listener", "address", msg.SocketPath)
-		req.Reply(false, nil)
-		return
-	}
-	if err := ln.Close(); err != nil {
-		req.Reply(false, nil)
-		s.Logger.Info("failed to close", "err", err)
-	}
-	req.Reply(true, nil)
-}
@@ -0,0 +1,2162 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/geoip"
+	"github.com/John-Ao/go-sshd/rlimit"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/exp/slog"
+)
+
+func TestMain(m *testing.M) {
+	rlimit.RunHelper()
+	os.Exit(m.Run())
+}
+
+// serveTest serves SSH connections with s and returns the address to connect.
+func serveTest(t testing.TB, s *Server) string {
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	if s.Config == nil {
+		s.Config = &ssh.ServerConfig{NoClientAuth: true}
+	}
+	s.Config.AddHostKey(signer)
+	go s.Serve(ln)
+	return ln.Addr().String()
+}
+
+// newTestClient serves SSH connections with s and returns a client connected to it.
+func newTestClient(t testing.TB, s *Server) *ssh.Client {
+	client, err := ssh.Dial("tcp", serveTest(t, s), &ssh.ClientConfig{
+		User:            "john",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestHandleChannelType(t *testing.T) {
+	s := &Server{}
+	s.HandleChannelType("echo@example.com", func(conn *ConnMetadata, newChannel ssh.NewChannel) {
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		io.WriteString(channel, conn.User()+":")
+		io.Copy(channel, channel)
+		channel.Close()
+	})
+	client := newTestClient(t, s)
+
+	channel, reqs, err := client.OpenChannel("echo@example.com", nil)
+	require.NoError(t, err)
+	go ssh.DiscardRequests(reqs)
+	_, err = channel.Write([]byte("hello"))
+	assert.NoError(t, err)
+	var buf [10]byte
+	_, err = io.ReadFull(channel, buf[:])
+	assert.NoError(t, err)
+	assert.Equal(t, "john:hello", string(buf[:]))
+	channel.Close()
+
+	_, _, err = client.OpenChannel("unknown@example.com", nil)
+	assert.Error(t, err)
+	assert.Equal(t, "ssh: rejected: unknown channel type (unknown channel type: unknown@example.com)", err.Error())
+}
+
+func TestExecOutput(t *testing.T) {
+	client := newTestClient(t, &Server{AllowExecute: true})
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// More than the channel window, so the output is still being copied when the command exits
+	output, err := session.Output("sh -c 'seq 100000'")
+	assert.NoError(t, err)
+	assert.Equal(t, 588895, len(output))
+	assert.True(t, strings.HasSuffix(string(output), "\n100000\n"))
+}
+
+func TestGlobalRequestsWithoutReply(t *testing.T) {
+	client := newTestClient(t, &Server{AllowTcpipForward: true})
+	var ports []int
+	for i := 0; i < 10; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+		ln.Close()
+	}
+	// Requests without replies are sent back to back, before the previous ones are handled
+	for _, port := range ports {
+		_, _, err := client.SendRequest("tcpip-forward", false, ssh.Marshal(struct {
+			Addr string
+			Port uint32
+		}{Addr: "127.0.0.1", Port: uint32(port)}))
+		require.NoError(t, err)
+	}
+	for _, port := range ports {
+		assert.Eventually(t, func() bool {
+			conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+			if err != nil {
+				return false
+			}
+			conn.Close()
+			return true
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestHandleGlobalRequestType(t *testing.T) {
+	s := &Server{AllowTcpipForward: true}
+	s.HandleGlobalRequestType("ping@example.com", func(conn *ConnMetadata, req *ssh.Request) {
+		assert.NotEmpty(t, conn.ID)
+		assert.NotEmpty(t, conn.SessionID())
+		req.Reply(true, []byte("pong "+conn.User()))
+	})
+	// Override built-in one
+	s.HandleGlobalRequestType("tcpip-forward", func(conn *ConnMetadata, req *ssh.Request) {
+		req.Reply(false, nil)
+	})
+	client := newTestClient(t, s)
+
+	ok, payload, err := client.SendRequest("ping@example.com", true, nil)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "pong john", string(payload))
+
+	_, err = client.Listen("tcp", "127.0.0.1:0")
+	assert.Error(t, err)
+	assert.Equal(t, "ssh: tcpip-forward request denied by peer", err.Error())
+}
+
+func TestHandler(t *testing.T) {
+	s := &Server{AllowExecute: true}
+	s.Handler = func(sess Session) {
+		io.WriteString(sess, fmt.Sprintf("user=%s command=%q env=%q", sess.User(), sess.Command(), sess.Environ()))
+		_, _, isPty := sess.Pty()
+		io.WriteString(sess.Stderr(), fmt.Sprintf("pty=%t", isPty))
+		sess.Exit(3)
+	}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	assert.NoError(t, session.Setenv("LANG", "C"))
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run(`echo "hello world"`)
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitStatus())
+	assert.Regexp(t, `^user=john command=\["echo" "hello world"\] env=\["LANG=C" `+sshEnvPattern+`\]$`, stdout.String())
+	assert.Equal(t, "pty=false", stderr.String())
+}
+
+func TestWindowsExecCommandLine(t *testing.T) {
+	cmdLine, err := windowsExecCommandLine(WindowsExecCmd, `C:\Windows\system32\cmd.exe`, `findstr "a  b" "C:\My Files\x.txt"`)
+	require.NoError(t, err)
+	assert.Equal(t, `"C:\Windows\system32\cmd.exe" /d /s /c "findstr "a  b" "C:\My Files\x.txt""`, cmdLine)
+	cmdLine, err = windowsExecCommandLine(WindowsExecPowerShell, `C:\Program Files\PowerShell\7\pwsh.exe`, `Write-Output "a  b"`)
+	require.NoError(t, err)
+	assert.Equal(t, `"C:\Program Files\PowerShell\7\pwsh.exe" -NoLogo -EncodedCommand VwByAGkAdABlAC0ATwB1AHQAcAB1AHQAIAAiAGEAIAAgAGIAIgA=`, cmdLine)
+	_, err = windowsExecCommandLine("bash", "bash.exe", "true")
+	assert.EqualError(t, err, "unknown Windows exec shell: bash")
+}
+
+func TestLoginShells(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("login shells are not supported on Windows")
+	}
+	dir := t.TempDir()
+	shell := filepath.Join(dir, "login-shell")
+	require.NoError(t, os.WriteFile(shell, []byte("#!/bin/sh\necho login shell\n"), 0755))
+	defer func(file string) { passwdFile = file }(passwdFile)
+	passwdFile = filepath.Join(dir, "passwd")
+	require.NoError(t, os.WriteFile(passwdFile, []byte("root:x:0:0:root:/root:/bin/bash\njohn:x:1000:1000:John:/home/john:"+shell+"\n"), 0644))
+	found, err := loginShell("john")
+	require.NoError(t, err)
+	assert.Equal(t, shell, found)
+	found, err = loginShell("alice")
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	s := &Server{AllowExecute: true, LoginShells: true, Shell: "/bin/false"}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	require.NoError(t, session.Shell())
+	require.NoError(t, session.Wait())
+	assert.Equal(t, "login shell\n", stdout.String())
+}
+
+// sshEnvPattern matches the environment variables of the connection of newTestClient quoted by %q
+const sshEnvPattern = `"SSH_CONNECTION=127.0.0.1 \d+ 127.0.0.1 \d+" "SSH_CLIENT=127.0.0.1 \d+ \d+" "USER=john" "LOGNAME=john"`
+
+func TestSSHEnv(t *testing.T) {
+	s := &Server{AllowExecute: true}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// Clients can't override them
+	assert.NoError(t, session.Setenv("SSH_CONNECTION", "203.0.113.1 1 203.0.113.2 22"))
+	output, err := session.Output(`sh -c 'echo "$SSH_CONNECTION|$SSH_CLIENT|$USER|$LOGNAME|$SSH_TTY"'`)
+	require.NoError(t, err)
+	local := client.LocalAddr().(*net.TCPAddr)
+	remote := client.RemoteAddr().(*net.TCPAddr)
+	assert.Equal(t, fmt.Sprintf("127.0.0.1 %d 127.0.0.1 %d|127.0.0.1 %d %d|john|john|\n", local.Port, remote.Port, local.Port, remote.Port), string(output))
+
+	if runtime.GOOS == "windows" {
+		t.Skip("pty is not supported on Windows")
+	}
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.RequestPty("xterm", 40, 80, ssh.TerminalModes{}))
+	// stdin is kept open, or EOF closes the pty before the command runs
+	_, err = session.StdinPipe()
+	require.NoError(t, err)
+	output, err = session.Output(`sh -c 'echo "$SSH_TTY"; tty'`)
+	require.NoError(t, err)
+	lines := strings.Fields(string(output))
+	require.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "/dev/"), lines[0])
+	assert.Equal(t, lines[1], lines[0])
+}
+
+func TestHandlerPty(t *testing.T) {
+	s := &Server{AllowExecute: true}
+	s.Handler = func(sess Session) {
+		pty, winCh, isPty := sess.Pty()
+		if !isPty {
+			return
+		}
+		io.WriteString(sess, fmt.Sprintf("%s %dx%d\n", pty.Term, pty.Window.Width, pty.Window.Height))
+		for win := range winCh {
+			io.WriteString(sess, fmt.Sprintf("%dx%d\n", win.Width, win.Height))
+			if win.Width == 120 {
+				return
+			}
+		}
+	}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.RequestPty("xterm", 40, 80, ssh.TerminalModes{}))
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	reader := bufio.NewReader(stdout)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "xterm 80x40\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "80x40\n", line)
+	require.NoError(t, session.WindowChange(50, 120))
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "120x50\n", line)
+	assert.NoError(t, session.Wait())
+}
+
+func TestUpstream(t *testing.T) {
+	backend := &Server{AllowExecute: true, AllowDirectTcpip: true}
+	backend.Handler = func(sess Session) {
+		io.WriteString(sess, "hello from backend to "+sess.User())
+		sess.Exit(2)
+	}
+	address := serveTest(t, backend)
+
+	gateway := &Server{
+		Upstream: func(conn ssh.ConnMetadata) (*Upstream, error) {
+			return &Upstream{
+				Address: address,
+				ClientConfig: &ssh.ClientConfig{
+					User:            conn.User(),
+					HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+				},
+			}, nil
+		},
+	}
+	client := newTestClient(t, gateway)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	output, err := session.Output("whoami")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 2, exitErr.ExitStatus())
+	assert.Equal(t, "hello from backend to john", string(output))
+}
+
+func TestStats(t *testing.T) {
+	s := &Server{AllowExecute: true}
+	handlerStarted := make(chan struct{})
+	s.Handler = func(sess Session) {
+		io.WriteString(sess, "hello")
+		close(handlerStarted)
+		io.Copy(io.Discard, sess)
+	}
+	client := newTestClient(t, s)
+	s.AuthLog(client, "password", fmt.Errorf("password rejected"))
+	s.AuthLog(client, "none", fmt.Errorf("no auth"))
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	<-handlerStarted
+	var buf [5]byte
+	_, err = io.ReadFull(stdout, buf[:])
+	require.NoError(t, err)
+	_, err = stdin.Write([]byte("abc"))
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return s.Stats().BytesReceived == 3
+	}, time.Second, 10*time.Millisecond)
+	stats := s.Stats()
+	assert.Equal(t, int64(1), stats.ActiveConnections)
+	assert.Equal(t, int64(1), stats.ActiveSessions)
+	assert.Equal(t, int64(0), stats.ActiveForwards)
+	assert.Equal(t, uint64(5), stats.BytesSent)
+	assert.Equal(t, uint64(1), stats.AuthFailures)
+	assert.Equal(t, map[AuthResult]uint64{{Method: "password", Success: false}: 1}, stats.AuthAttempts)
+	assert.Equal(t, uint64(1), stats.Connections)
+	assert.Equal(t, uint64(1), stats.Sessions)
+	assert.Equal(t, uint64(0), stats.ForwardBytesReceived)
+	assert.Equal(t, uint64(1), stats.HandshakeDurations.Count)
+	assert.Equal(t, uint64(1), stats.HandshakeDurations.Counts[len(stats.HandshakeDurations.Counts)-1])
+	assert.Equal(t, map[string]Traffic{"john": {BytesReceived: 3, BytesSent: 5}}, stats.UserTraffic)
+	total := stats.Add(stats)
+	assert.Equal(t, uint64(2), total.Connections)
+	assert.Equal(t, Traffic{BytesReceived: 6, BytesSent: 10}, total.UserTraffic["john"])
+	assert.Equal(t, uint64(2), total.AuthAttempts[AuthResult{Method: "password"}])
+	assert.Equal(t, uint64(2), total.HandshakeDurations.Count)
+	conns := s.Connections()
+	require.Len(t, conns, 1)
+	assert.Equal(t, "john", conns[0].User)
+	assert.Equal(t, int64(1), conns[0].Channels)
+
+	session.Close()
+	client.Close()
+	assert.Eventually(t, func() bool {
+		stats := s.Stats()
+		return stats.ActiveConnections == 0 && stats.ActiveSessions == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Empty(t, s.Connections())
+}
+
+func BenchmarkHandshake(b *testing.B) {
+	address := serveTest(b, &Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))})
+	config := &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		client, err := ssh.Dial("tcp", address, config)
+		if err != nil {
+			b.Fatal(err)
+		}
+		client.Close()
+	}
+}
+
+func BenchmarkDirectTcpip(b *testing.B) {
+	client := newTestClient(b, &Server{Logger: slog.New(slog.NewTextHandler(io.Discard, nil)), AllowDirectTcpip: true})
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(b, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(b, err)
+	defer conn.Close()
+	data := make([]byte, 32*1024)
+	received := make([]byte, len(data))
+	b.SetBytes(int64(2 * len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(data); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := io.ReadFull(conn, received); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestStatsForwardAndSftp(t *testing.T) {
+	s := &Server{AllowDirectTcpip: true, AllowSftp: true}
+	var mu sync.Mutex
+	var sftpEvents []Event
+	s.OnEvent = func(event Event) {
+		if event.Type == EventSftp {
+			mu.Lock()
+			defer mu.Unlock()
+			sftpEvents = append(sftpEvents, event)
+		}
+	}
+	client := newTestClient(t, s)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(conn, make([]byte, 5))
+	require.NoError(t, err)
+	conn.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	_, err = sftpClient.Stat(".")
+	require.NoError(t, err)
+	sftpClient.Close()
+
+	assert.Eventually(t, func() bool {
+		return s.Stats().ForwardBytesSent == 5
+	}, time.Second, 10*time.Millisecond)
+	stats := s.Stats()
+	assert.Equal(t, uint64(5), stats.ForwardBytesReceived)
+	assert.Equal(t, uint64(1), stats.SftpOperations["init"])
+	assert.Equal(t, uint64(1), stats.SftpOperations["stat"])
+	john := stats.UserTraffic["john"]
+	assert.Equal(t, Traffic{BytesReceived: stats.BytesReceived, BytesSent: stats.BytesSent, ForwardBytesReceived: 5, ForwardBytesSent: 5}, john)
+	assert.Greater(t, john.BytesReceived, john.ForwardBytesReceived)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, sftpEvents, 1)
+	assert.Equal(t, "stat", sftpEvents[0].Operation)
+	assert.Equal(t, ".", sftpEvents[0].Path)
+	assert.Equal(t, "john", sftpEvents[0].User)
+}
+
+func TestMonitor(t *testing.T) {
+	s := &Server{AllowExecute: true}
+	s.Handler = func(sess Session) {
+		// Echo stdin to stdout and stderr until it is closed
+		buf := make([]byte, 1024)
+		for {
+			n, err := sess.Read(buf)
+			if err != nil {
+				return
+			}
+			sess.Write(buf[:n])
+			sess.Stderr().Write(bytes.ToUpper(buf[:n]))
+		}
+	}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	stderr := &lockedBuffer{}
+	session.Stderr = stderr
+	require.NoError(t, session.Shell())
+	var sessionID string
+	require.Eventually(t, func() bool {
+		conns := s.Connections()
+		if len(conns) == 1 && len(conns[0].Sessions) == 1 && conns[0].Sessions[0].Type == "shell" {
+			sessionID = conns[0].Sessions[0].ID
+		}
+		return sessionID != ""
+	}, time.Second, 10*time.Millisecond)
+
+	_, _, ok := s.Monitor(sessionID+"0", false)
+	assert.False(t, ok)
+	output, stop, ok := s.Monitor(sessionID, true)
+	require.True(t, ok)
+	defer stop()
+	_, err = stdin.Write([]byte("hello"))
+	require.NoError(t, err)
+	_, err = io.ReadFull(stdout, make([]byte, 5))
+	require.NoError(t, err)
+	var monitored []byte
+	for len(monitored) < 10 {
+		monitored = append(monitored, <-output...)
+	}
+	assert.Equal(t, "helloHELLO", string(monitored))
+	assert.Eventually(t, func() bool {
+		return strings.Contains(stderr.String(), "being monitored by an administrator")
+	}, time.Second, 10*time.Millisecond)
+	assert.NotContains(t, string(monitored), "monitored")
+
+	// The output is closed when the session ends
+	stdin.Close()
+	for range output {
+	}
+	_, _, ok = s.Monitor(sessionID, false)
+	assert.False(t, ok)
+}
+
+func TestClose(t *testing.T) {
+	s := &Server{AllowExecute: true, AllowTcpipForward: true}
+	s.Handler = func(sess Session) {
+		io.Copy(io.Discard, sess)
+	}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// stdin is kept open not to end the session
+	_, err = session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Start("sleep 60"))
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conns := s.Connections()
+	require.Len(t, conns, 1)
+	conn := conns[0]
+	require.Len(t, conn.Sessions, 1)
+	assert.Equal(t, conn.ID+"/1", conn.Sessions[0].ID)
+	assert.Equal(t, "exec", conn.Sessions[0].Type)
+	assert.Equal(t, []string{"sleep", "60"}, conn.Sessions[0].Command)
+	require.Len(t, conn.Forwards, 1)
+	assert.Equal(t, "tcpip-forward", conn.Forwards[0].Type)
+	assert.Equal(t, ln.Addr().(*net.TCPAddr).Port, conn.Forwards[0].Port)
+
+	assert.False(t, s.Close(conn.ID+"/100"))
+	// Closing the session ends it on the client
+	assert.True(t, s.Close(conn.Sessions[0].ID))
+	assert.Error(t, session.Wait())
+	assert.True(t, s.Close(conn.Forwards[0].ID))
+	assert.Eventually(t, func() bool {
+		conns := s.Connections()
+		return len(conns[0].Sessions) == 0 && len(conns[0].Forwards) == 0
+	}, time.Second, 10*time.Millisecond)
+	_, err = net.Dial("tcp", ln.Addr().String())
+	assert.Error(t, err)
+
+	assert.True(t, s.Close(conn.ID))
+	assert.Error(t, client.Wait())
+	assert.Eventually(t, func() bool {
+		return len(s.Connections()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+// echoPtyFactory starts a fake shell echoing input until "exit\r"
+type echoPtyFactory struct {
+	resized chan Window
+}
+
+func (f *echoPtyFactory) Start(spec *ProcessSpec) (PtyProcess, error) {
+	shell := spec.Command[0]
+	inReader, inWriter := io.Pipe()
+	outReader, outWriter := io.Pipe()
+	p := &echoPtyProcess{Reader: outReader, Writer: inWriter, resized: f.resized, done: make(chan struct{})}
+	go func() {
+		defer close(p.done)
+		defer outWriter.Close()
+		scanner := bufio.NewScanner(inReader)
+		scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+			if i := bytes.IndexByte(data, '\r'); i >= 0 {
+				return i + 1, data[:i], nil
+			}
+			return 0, nil, nil
+		})
+		for scanner.Scan() {
+			if scanner.Text() == "exit" {
+				return
+			}
+			fmt.Fprintf(outWriter, "%s: %s\r\n", shell, scanner.Text())
+		}
+	}()
+	return p, nil
+}
+
+type echoPtyProcess struct {
+	io.Reader
+	io.Writer
+	resized chan Window
+	done    chan struct{}
+}
+
+func (p *echoPtyProcess) Close() error {
+	return p.Writer.(io.Closer).Close()
+}
+
+func (p *echoPtyProcess) Resize(width, height uint32) error {
+	p.resized <- Window{Width: int(width), Height: int(height)}
+	return nil
+}
+
+func (p *echoPtyProcess) Wait() (int, error) {
+	<-p.done
+	return 7, nil
+}
+
+func TestLifecycle(t *testing.T) {
+	s := &Server{AllowExecute: true, AllowTcpipForward: true}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	// stdin is kept open, so only closing the connection ends the copier of stdin
+	_, err = session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Start("sleep 60"))
+	ln, err := client.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return s.Stats().ActiveProcesses == 1
+	}, time.Second, 10*time.Millisecond)
+	conns := s.Connections()
+	require.Len(t, conns, 1)
+	assert.Equal(t, int64(1), conns[0].Processes)
+	assert.Positive(t, conns[0].Goroutines)
+	assert.Equal(t, conns[0].Goroutines, s.Stats().ActiveGoroutines)
+
+	// The process is killed, and the goroutines of the session and the forwarding exit
+	client.Close()
+	assert.Eventually(t, func() bool {
+		stats := s.Stats()
+		return stats.ActiveConnections == 0 && stats.ActiveProcesses == 0 && stats.ActiveGoroutines == 0
+	}, 3*time.Second, 10*time.Millisecond)
+	_, err = net.Dial("tcp", ln.Addr().String())
+	assert.Error(t, err)
+}
+
+func TestPool(t *testing.T) {
+	pool := &Pool{Size: 1, QueueSize: 1, QueueTimeout: 50 * time.Millisecond}
+	first := pool.Enter()
+	require.NotNil(t, first)
+	assert.True(t, first.Wait(context.Background()))
+	queued := pool.Enter()
+	require.NotNil(t, queued)
+	// The queue is full
+	assert.Nil(t, pool.Enter())
+	// The queued one times out
+	assert.False(t, queued.Wait(context.Background()))
+
+	queued = pool.Enter()
+	require.NotNil(t, queued)
+	time.AfterFunc(10*time.Millisecond, first.Leave)
+	assert.True(t, queued.Wait(context.Background()))
+	queued.Leave()
+	queued.Leave()
+	assert.NotNil(t, pool.Enter())
+
+	// Waiting forever without QueueTimeout unless ctx is done
+	forever := &Pool{Size: 1, QueueSize: 1}
+	require.True(t, forever.Enter().Wait(context.Background()))
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	assert.False(t, forever.Enter().Wait(ctx))
+	assert.NotNil(t, forever.Enter())
+
+	var unbounded *Pool
+	ticket := unbounded.Enter()
+	assert.True(t, ticket.Wait(context.Background()))
+	ticket.Leave()
+}
+
+func TestChannelPool(t *testing.T) {
+	s := &Server{ChannelPool: &Pool{Size: 1}}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// The queue is empty, so the second channel is shed at once
+	_, err = client.NewSession()
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ssh.ResourceShortage, openErr.Reason)
+	assert.Equal(t, "server busy", openErr.Message)
+	assert.Equal(t, uint64(1), s.Stats().ShedChannels)
+
+	session.Close()
+	assert.Eventually(t, func() bool {
+		session, err := client.NewSession()
+		if err != nil {
+			return false
+		}
+		session.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestChannelPoolClientDisconnected(t *testing.T) {
+	pool := &Pool{Size: 1, QueueSize: 1}
+	s := &Server{ChannelPool: pool}
+	address := serveTest(t, s)
+	dial := func() *ssh.Client {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	client1, client2 := dial(), dial()
+	session, err := client1.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// Queued until the client disconnects
+	go client2.NewSession()
+	assert.Eventually(t, func() bool { return pool.queued.Load() == 1 }, time.Second, 10*time.Millisecond)
+	client2.Close()
+	assert.Eventually(t, func() bool { return pool.queued.Load() == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint64(0), s.Stats().ShedChannels)
+
+	// The slot is not taken by the disconnected one
+	session.Close()
+	assert.Eventually(t, func() bool {
+		session, err := client1.NewSession()
+		if err != nil {
+			return false
+		}
+		session.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestRateLimit(t *testing.T) {
+	limit, err := ParseRateLimit("0.5")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 0.5, Burst: 1}, limit)
+	limit, err = ParseRateLimit("10:2")
+	require.NoError(t, err)
+	assert.Equal(t, RateLimit{Rate: 10, Burst: 2}, limit)
+	for _, invalid := range []string{"0", "-1", "x", "10:0", "10:", "inf"} {
+		_, err := ParseRateLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	limiter := newRateLimiter(limit)
+	now := time.Now()
+	assert.True(t, limiter.allow(now))
+	assert.True(t, limiter.allow(now))
+	assert.False(t, limiter.allow(now))
+	// A token per 100ms
+	assert.False(t, limiter.allow(now.Add(50*time.Millisecond)))
+	assert.True(t, limiter.allow(now.Add(100*time.Millisecond)))
+	// Up to the burst
+	assert.True(t, limiter.allow(now.Add(time.Hour)))
+	assert.True(t, limiter.allow(now.Add(time.Hour)))
+	assert.False(t, limiter.allow(now.Add(time.Hour)))
+	assert.Nil(t, newRateLimiter(RateLimit{}))
+	assert.True(t, (*rateLimiter)(nil).allow(now))
+}
+
+func TestChannelRate(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	s := &Server{AllowDirectTcpip: true, ChannelRate: RateLimit{Rate: 0.001, Burst: 3}, ForwardChannelRate: RateLimit{Rate: 0.001, Burst: 1}}
+	client := newTestClient(t, s)
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	_, err = client.Dial("tcp", ln.Addr().String())
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ssh.ResourceShortage, openErr.Reason)
+	assert.Equal(t, "channel rate exceeded", openErr.Message)
+
+	// Sessions are limited only by ChannelRate, from which the allowed forwarding channel took a token
+	for i := 0; i < 2; i++ {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		session.Close()
+	}
+	_, err = client.NewSession()
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, "channel rate exceeded", openErr.Message)
+	assert.Equal(t, uint64(2), s.Stats().RateLimitedChannels)
+}
+
+func TestMaxChannels(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	s := &Server{AllowDirectTcpip: true, MaxChannels: 2}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, err = client.NewSession()
+	var openErr *ssh.OpenChannelError
+	require.ErrorAs(t, err, &openErr)
+	assert.Equal(t, ssh.ResourceShortage, openErr.Reason)
+	assert.Equal(t, "too many channels", openErr.Message)
+	assert.Equal(t, int64(2), s.Connections()[0].Channels)
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		session, err := client.NewSession()
+		if err != nil {
+			return false
+		}
+		session.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMaxTransfer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("cat is not available on Windows")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	s := &Server{AllowExecute: true, AllowDirectTcpip: true, MaxTransfer: 64 << 10}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	// The session stays until the connection is closed
+	_, err = session.StdinPipe()
+	require.NoError(t, err)
+	stderr := &lockedBuffer{}
+	session.Stderr = stderr
+	require.NoError(t, session.Start("cat"))
+
+	// Echoed bytes are counted in both directions
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	go io.Copy(io.Discard, conn)
+	_, err = io.Copy(conn, io.LimitReader(zeroReader{}, 64<<20))
+	assert.Error(t, err)
+	// After the output is copied
+	session.Wait()
+	assert.Contains(t, stderr.String(), "[go-sshd: transfer limit of 65536 bytes exceeded, closing connection]")
+}
+
+func TestStallTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	// The target writes endlessly and never reads
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, zeroReader{})
+			}()
+		}
+	}()
+	s := &Server{AllowDirectTcpip: true, StallTimeout: 200 * time.Millisecond}
+	client := newTestClient(t, s)
+
+	// The target stalls the relay by not reading, which is closed
+	conn, err := client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	go io.Copy(io.Discard, conn)
+	_, err = io.Copy(conn, zeroReader{})
+	assert.Error(t, err)
+	assert.Eventually(t, func() bool {
+		return s.Stats().StalledRelays == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The client stalls the relay by not reading, which is disconnected
+	_, err = client.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("client not disconnected")
+	}
+	assert.Equal(t, uint64(2), s.Stats().StalledRelays)
+}
+
+// zeroReader reads zeros endlessly
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+func TestHandshakePool(t *testing.T) {
+	s := &Server{HandshakePool: &Pool{Size: 1}}
+	address := serveTest(t, s)
+	// The slot is taken by a connection not sending its version
+	conn, err := net.Dial("tcp", address)
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return false
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		// Closed without the version of the server
+		_, err = conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}, 3*time.Second, 10*time.Millisecond)
+	assert.Positive(t, s.Stats().ShedConnections)
+
+	conn.Close()
+	assert.Eventually(t, func() bool {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		if err != nil {
+			return false
+		}
+		client.Close()
+		return true
+	}, 3*time.Second, 10*time.Millisecond)
+}
+
+func TestPtyFactory(t *testing.T) {
+	t.Setenv("SHELL", "")
+	factory := &echoPtyFactory{resized: make(chan Window, 2)}
+	s := &Server{AllowExecute: true, PtyFactory: factory}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.RequestPty("xterm", 40, 80, ssh.TerminalModes{}))
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := session.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.Shell())
+	assert.Equal(t, Window{Width: 80, Height: 40}, <-factory.resized)
+	require.NoError(t, session.WindowChange(50, 120))
+	assert.Equal(t, Window{Width: 120, Height: 50}, <-factory.resized)
+	_, err = io.WriteString(stdin, "hello\rexit\r")
+	require.NoError(t, err)
+	output, err := io.ReadAll(stdout)
+	assert.NoError(t, err)
+	assert.Equal(t, "sh: hello\r\n", string(output))
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, session.Wait(), &exitErr)
+	assert.Equal(t, 7, exitErr.ExitStatus())
+}
+
+// chaffChannel is a channel recording data and requests
+type chaffChannel struct {
+	ssh.Channel
+	mu       sync.Mutex
+	data     bytes.Buffer
+	requests []string
+}
+
+func (c *chaffChannel) Read(p []byte) (int, error) {
+	return copy(p, "a"), nil
+}
+
+func (c *chaffChannel) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.data.Write(p)
+}
+
+func (c *chaffChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, name)
+	return false, nil
+}
+
+func (c *chaffChannel) Close() error {
+	return nil
+}
+
+func (c *chaffChannel) state() (string, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.data.String(), append([]string(nil), c.requests...)
+}
+
+func TestObscureKeystrokeTiming(t *testing.T) {
+	fake := &chaffChannel{}
+	channel := newObscuredChannel(fake, 20*time.Millisecond)
+	// Output is written directly without input
+	channel.Write([]byte("prompt$ "))
+	data, _ := fake.state()
+	assert.Equal(t, "prompt$ ", data)
+	// Output is written at the next interval after input, and chaff without output
+	channel.Read(make([]byte, 1))
+	n, err := channel.Write([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	data, _ = fake.state()
+	assert.Equal(t, "prompt$ ", data)
+	assert.Eventually(t, func() bool {
+		data, requests := fake.state()
+		return data == "prompt$ a" && len(requests) > 0
+	}, time.Second, 10*time.Millisecond)
+	_, requests := fake.state()
+	assert.Equal(t, chaffRequest, requests[0])
+	channel.Write([]byte("b"))
+	channel.SendRequest("exit-status", false, nil)
+	data, requests = fake.state()
+	assert.Equal(t, "prompt$ ab", data)
+	assert.Equal(t, "exit-status", requests[len(requests)-1])
+	assert.NoError(t, channel.Close())
+
+	// Sessions work as without it
+	t.Setenv("SHELL", "")
+	s := &Server{AllowExecute: true, PtyFactory: &echoPtyFactory{resized: make(chan Window, 2)}, ObscureKeystrokeTiming: 20 * time.Millisecond}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, session.RequestPty("xterm", 40, 80, ssh.TerminalModes{}))
+	session.Stdin = strings.NewReader("hello\rexit\r")
+	var output bytes.Buffer
+	session.Stdout = &output
+	require.NoError(t, session.Shell())
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, session.Wait(), &exitErr)
+	assert.Equal(t, 7, exitErr.ExitStatus())
+	assert.Equal(t, "sh: hello\r\n", output.String())
+}
+
+// fakeExecutor starts fake processes writing the spec to stdout
+type fakeExecutor struct{}
+
+func (fakeExecutor) Start(spec *ProcessSpec) (Process, error) {
+	stdinReader, stdinWriter := io.Pipe()
+	p := &fakeProcess{
+		stdin:  stdinWriter,
+		stdout: strings.NewReader(fmt.Sprintf("user=%s command=%q env=%q", spec.User, spec.Command, spec.Env)),
+	}
+	p.stderr, p.stderrWriter = io.Pipe()
+	go func() {
+		// Echo stdin to stderr
+		io.Copy(p.stderrWriter, stdinReader)
+		p.stderrWriter.Close()
+	}()
+	return p, nil
+}
+
+type fakeProcess struct {
+	stdin        io.WriteCloser
+	stdout       io.Reader
+	stderr       io.Reader
+	stderrWriter *io.PipeWriter
+}
+
+func (p *fakeProcess) Stdin() io.WriteCloser { return p.stdin }
+func (p *fakeProcess) Stdout() io.Reader     { return p.stdout }
+func (p *fakeProcess) Stderr() io.Reader     { return p.stderr }
+func (p *fakeProcess) Signal(sig ssh.Signal) error {
+	io.WriteString(p.stderrWriter, "signal="+string(sig))
+	return nil
+}
+func (p *fakeProcess) Resize(width, height uint32) error { return nil }
+func (p *fakeProcess) Wait() (int, error)                { return 5, nil }
+func (p *fakeProcess) Close() error                      { return nil }
+
+func TestExecutor(t *testing.T) {
+	s := &Server{AllowExecute: true, Executor: fakeExecutor{}}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	assert.NoError(t, session.Setenv("LANG", "C"))
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	session.Stdin = strings.NewReader("input;")
+	err = session.Run(`ls -l "my dir"`)
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 5, exitErr.ExitStatus())
+	assert.Regexp(t, `^user=john command=\["ls" "-l" "my dir"\] env=\["LANG=C" `+sshEnvPattern+`\]$`, stdout.String())
+	assert.Equal(t, "input;", stderr.String())
+}
+
+// pathAuthorizer allows actions except SFTP requests outside dir
+type pathAuthorizer struct {
+	dir string
+}
+
+func (a *pathAuthorizer) Authorize(action *Action) (bool, error) {
+	switch action.Type {
+	case ActionExec:
+		return action.Command[0] == "echo", nil
+	case ActionSftp:
+		return strings.HasPrefix(action.Path, a.dir), nil
+	case ActionDirectTcpip:
+		return false, nil
+	}
+	return true, nil
+}
+
+func TestAuthorizer(t *testing.T) {
+	dir := t.TempDir()
+	s := &Server{AllowExecute: true, AllowSftp: true, AllowDirectTcpip: true, Authorizer: &pathAuthorizer{dir: dir}}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("echo hello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(output))
+	session.Close()
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	_, err = session.Output("whoami")
+	assert.Error(t, err)
+	session.Close()
+
+	sftpClient, err := sftp.NewClient(client)
+	require.NoError(t, err)
+	defer sftpClient.Close()
+	f, err := sftpClient.Create(path.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	content, err := os.ReadFile(path.Join(dir, "hello.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(content))
+	infos, err := sftpClient.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, infos, 1)
+	assert.Equal(t, "hello.txt", infos[0].Name())
+	_, err = sftpClient.Open("/etc/hostname")
+	assert.ErrorIs(t, err, os.ErrPermission)
+
+	_, err = client.Dial("tcp", "127.0.0.1:22")
+	assert.Error(t, err)
+}
+
+func TestSubscribe(t *testing.T) {
+	s := &Server{AllowExecute: true, Executor: fakeExecutor{}}
+	events, unsubscribe := s.Subscribe(16)
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	session.Run("ls")
+	session.Close()
+	client.Close()
+
+	var types []string
+	for event := range events {
+		assert.Equal(t, "john", event.User)
+		types = append(types, event.Type)
+		if event.Type == EventSessionEnded {
+			assert.Equal(t, []string{"ls"}, event.Command)
+			assert.Equal(t, 5, event.ExitCode)
+		}
+		if event.Type == EventConnectionClosed {
+			break
+		}
+	}
+	unsubscribe()
+	assert.Equal(t, []string{EventConnectionOpened, EventSessionStarted, EventSessionEnded, EventConnectionClosed}, types)
+	_, ok := <-events
+	assert.False(t, ok)
+}
+
+func TestConfig(t *testing.T) {
+	s := &Server{Config: &ssh.ServerConfig{
+		ServerVersion: "SSH-2.0-example",
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "mypass" {
+				return nil, fmt.Errorf("password rejected")
+			}
+			return nil, nil
+		},
+	}}
+	address := serveTest(t, s)
+
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("mypass")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+	defer client.Close()
+	assert.Equal(t, "SSH-2.0-example", string(client.ServerVersion()))
+
+	_, err = ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "john",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	assert.Error(t, err)
+}
+
+func TestMalformedRequests(t *testing.T) {
+	for _, policy := range []MalformedRequestPolicy{MalformedRequestIgnore, MalformedRequestDisconnect} {
+		s := &Server{AllowExecute: true, Executor: fakeExecutor{}, MalformedRequests: policy}
+		client := newTestClient(t, s)
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		// Shorter than 4 uint32s
+		ok, err := session.SendRequest("window-change", true, []byte{0, 0, 0})
+		if policy == MalformedRequestIgnore {
+			require.NoError(t, err)
+			assert.False(t, ok)
+			_, err = client.NewSession()
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, client.Wait())
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	s := &Server{}
+	address := serveTest(t, s)
+	client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+		User:            "john",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Drain(ctx), context.DeadlineExceeded)
+	client.Close()
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, s.Drain(ctx))
+}
+
+func TestDenyPty(t *testing.T) {
+	s := &Server{AllowExecute: true, DenyPty: true, Executor: fakeExecutor{}, Config: &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			if conn.User() == "alex" {
+				return &ssh.Permissions{Extensions: map[string]string{ExtensionDenyPty: "false"}}, nil
+			}
+			return nil, nil
+		},
+	}}
+	address := serveTest(t, s)
+	for _, c := range []struct {
+		user    string
+		allowed bool
+	}{{user: "john", allowed: false}, {user: "alex", allowed: true}} {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+			User:            c.user,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		require.NoError(t, err)
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		err = session.RequestPty("xterm", 40, 80, ssh.TerminalModes{})
+		if c.allowed {
+			assert.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+			// Commands without pty are allowed
+			var exitErr *ssh.ExitError
+			require.ErrorAs(t, session.Run("true"), &exitErr)
+			assert.Equal(t, 5, exitErr.ExitStatus())
+		}
+		client.Close()
+	}
+}
+
+func TestMaxConnSessions(t *testing.T) {
+	s := &Server{AllowExecute: true, Executor: fakeExecutor{}, Config: &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{ExtensionMaxConnSessions: "1"}}, nil
+		},
+	}}
+	address := serveTest(t, s)
+	dial := func() *ssh.Client {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{
+			User:            "john",
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		})
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	client1, client2 := dial(), dial()
+	session1, err := client1.NewSession()
+	require.NoError(t, err)
+	// Counted per connection, not per user
+	session2, err := client2.NewSession()
+	require.NoError(t, err)
+	_, err = client1.NewSession()
+	assert.ErrorContains(t, err, "too many sessions")
+	session1.Close()
+	session2.Close()
+	assert.Eventually(t, func() bool {
+		session, err := client1.NewSession()
+		if err != nil {
+			return false
+		}
+		session.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestForceCommand(t *testing.T) {
+	s := &Server{AllowExecute: true, AllowSftp: true, ForceCommand: "echo forced", Executor: fakeExecutor{}, Config: &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			forceCommand := map[string]string{"alex": ForceCommandInternalSftp, "bob": "none"}[conn.User()]
+			return &ssh.Permissions{Extensions: map[string]string{ExtensionForceCommand: forceCommand}}, nil
+		},
+	}}
+	address := serveTest(t, s)
+	dial := func(user string) *ssh.Client {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	run := func(client *ssh.Client, command string) (string, error) {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		var stdout bytes.Buffer
+		session.Stdout = &stdout
+		err = session.Run(command)
+		return stdout.String(), err
+	}
+	subsystem := func(client *ssh.Client) error {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		defer session.Close()
+		return session.RequestSubsystem("sftp")
+	}
+
+	john := dial("john")
+	stdout, _ := run(john, "ls -l")
+	assert.Regexp(t, `^user=john command=\["echo" "forced"\] env=\[`+sshEnvPattern+` "SSH_ORIGINAL_COMMAND=ls -l"\]$`, stdout)
+	stdout, _ = run(john, "")
+	assert.Regexp(t, `command=\["echo" "forced"\] env=\[`+sshEnvPattern+`\]$`, stdout)
+	assert.Error(t, subsystem(john))
+
+	alex := dial("alex")
+	_, err := run(alex, "ls")
+	var exitErr *ssh.ExitError
+	assert.False(t, errors.As(err, &exitErr))
+	assert.NoError(t, subsystem(alex))
+
+	bob := dial("bob")
+	stdout, _ = run(bob, "ls -l")
+	assert.Contains(t, stdout, `command=["ls" "-l"]`)
+}
+
+func TestCheckLogin(t *testing.T) {
+	s := &Server{CheckLogin: func(sshConn *ssh.ServerConn) error {
+		if sshConn.User() == "john" {
+			return errors.New("denied")
+		}
+		return nil
+	}}
+	address := serveTest(t, s)
+	for user, allowed := range map[string]bool{"john": false, "alex": true} {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		require.NoError(t, err)
+		_, err = client.NewSession()
+		assert.Equal(t, allowed, err == nil, user)
+		client.Close()
+	}
+}
+
+func TestDisconnectAt(t *testing.T) {
+	disconnectAt := time.Now().Add(time.Second)
+	s := &Server{Config: &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{ExtensionDisconnectAt: disconnectAt.Format(time.RFC3339)}}, nil
+		},
+	}}
+	client := newTestClient(t, s)
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		assert.False(t, time.Now().Before(disconnectAt.Truncate(time.Second)))
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+// lockedBuffer is a bytes.Buffer written by goroutines
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDebugLog(t *testing.T) {
+	var logs lockedBuffer
+	s := &Server{
+		AllowExecute: true,
+		Logger:       slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+	}
+	s.Handler = func(sess Session) {
+		sess.Exit(0)
+	}
+	client := newTestClient(t, s)
+
+	_, _, err := client.SendRequest("keepalive@openssh.com", true, []byte("ping"))
+	require.NoError(t, err)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	require.NoError(t, session.Run("echo hello"))
+	_, _, err = client.OpenChannel("unknown@example.com", nil)
+	require.Error(t, err)
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(logs.String(), `msg="channel closed"`)
+	}, 5*time.Second, 10*time.Millisecond)
+	output := logs.String()
+	assert.Contains(t, output, `level=DEBUG msg="global request"`)
+	assert.Contains(t, output, `req_type=keepalive@openssh.com want_reply=true payload="\"ping\""`)
+	assert.Contains(t, output, `msg="channel opened" conn_id=`)
+	assert.Contains(t, output, `channel_type=session extra_data="\"\""`)
+	assert.Contains(t, output, `msg="channel accepted"`)
+	assert.Contains(t, output, `req_type=exec want_reply=true payload="\"\\x00\\x00\\x00\\necho hello\""`)
+	assert.Contains(t, output, `channel_type=unknown@example.com reason="unknown channel type" message="unknown channel type: unknown@example.com"`)
+}
+
+// closeRecorder is a lockedBuffer recording whether it is closed
+type closeRecorder struct {
+	lockedBuffer
+	closed atomic.Bool
+}
+
+func (w *closeRecorder) Close() error {
+	w.closed.Store(true)
+	return nil
+}
+
+func TestConnectionLog(t *testing.T) {
+	var logs lockedBuffer
+	var connLog closeRecorder
+	var connMetadata *ConnMetadata
+	s := &Server{
+		AllowExecute: true,
+		Logger:       slog.New(slog.NewTextHandler(&logs, nil)),
+		ConnectionLog: func(conn *ConnMetadata, startTime time.Time) (io.WriteCloser, error) {
+			connMetadata = conn
+			return &connLog, nil
+		},
+	}
+	s.Handler = func(sess Session) {
+		sess.Exit(0)
+	}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	require.NoError(t, session.Run("echo hello"))
+	client.Close()
+
+	assert.Eventually(t, connLog.closed.Load, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "john", connMetadata.User())
+	output := connLog.String()
+	assert.Contains(t, output, `level=INFO msg="new SSH connection" conn_id=`+connMetadata.ID+" user=john")
+	// Debug logs are written only to the connection log
+	assert.Contains(t, output, `level=DEBUG msg="channel opened"`)
+	assert.Contains(t, output, `msg="SSH connection closed"`)
+	assert.Contains(t, logs.String(), `msg="new SSH connection" conn_id=`+connMetadata.ID)
+	assert.NotContains(t, logs.String(), "DEBUG")
+}
+
+func TestAllowScp(t *testing.T) {
+	s := &Server{AllowScp: true, Executor: fakeExecutor{}}
+	client := newTestClient(t, s)
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	assert.NoError(t, session.Setenv("LD_PRELOAD", "evil.so"))
+	output, err := session.Output("scp -t /tmp")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Regexp(t, `^user=john command=\["scp" "-t" "/tmp"\] env=\[`+sshEnvPattern+`\]$`, string(output))
+
+	for _, command := range []string{"ls", ""} {
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		if command == "" {
+			assert.Error(t, session.Shell())
+		} else {
+			assert.Error(t, session.Run(command))
+		}
+		session.Close()
+	}
+}
+
+func TestWindowsExecMetacharacters(t *testing.T) {
+	defer func(raw bool) { rawExecCommandLines = raw }(rawExecCommandLines)
+	rawExecCommandLines = true
+	s := &Server{AllowScp: true, Executor: fakeExecutor{}}
+	client := newTestClient(t, s)
+
+	// The shell on Windows would run calc.exe after the allowed scp
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	_, err = session.Output("scp -t /tmp & calc.exe")
+	assert.EqualError(t, err, "ssh: command scp -t /tmp & calc.exe failed")
+	session.Close()
+
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("scp -t /tmp")
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Regexp(t, `^user=john command=\["scp" "-t" "/tmp"\]`, string(output))
+}
+
+func TestAgentForward(t *testing.T) {
+	s := &Server{AllowExecute: true, AllowAgentForward: true}
+	s.Handler = func(sess Session) {
+		for _, env := range sess.Environ() {
+			path, ok := strings.CutPrefix(env, "SSH_AUTH_SOCK=")
+			if !ok {
+				continue
+			}
+			conn, err := net.Dial("unix", path)
+			if err != nil {
+				io.WriteString(sess, err.Error())
+				return
+			}
+			defer conn.Close()
+			keys, err := agent.NewClient(conn).List()
+			if err != nil {
+				io.WriteString(sess, err.Error())
+				return
+			}
+			for _, key := range keys {
+				io.WriteString(sess, key.Comment)
+			}
+		}
+	}
+	client := newTestClient(t, s)
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	keyring := agent.NewKeyring()
+	require.NoError(t, keyring.Add(agent.AddedKey{PrivateKey: privateKey, Comment: "john@example.com"}))
+	require.NoError(t, agent.ForwardToAgent(client, keyring))
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	require.NoError(t, agent.RequestAgentForwarding(session))
+	output, err := session.Output("")
+	assert.NoError(t, err)
+	assert.Equal(t, "john@example.com", string(output))
+
+	// Denied without the permission
+	s.AllowAgentForward = false
+	client = newTestClient(t, s)
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	assert.Error(t, agent.RequestAgentForwarding(session))
+}
+
+func TestGenericOpenFailures(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ln.Close()
+	for _, generic := range []bool{false, true} {
+		client := newTestClient(t, &Server{AllowDirectTcpip: true, GenericOpenFailures: generic})
+		_, err := client.Dial("tcp", ln.Addr().String())
+		var openErr *ssh.OpenChannelError
+		require.ErrorAs(t, err, &openErr)
+		assert.Equal(t, ssh.ConnectionFailed, openErr.Reason)
+		if generic {
+			assert.Equal(t, "connect failed", openErr.Message)
+		} else {
+			assert.Equal(t, "connection refused by target "+ln.Addr().String(), openErr.Message)
+		}
+	}
+}
+
+func TestClientVersionPolicy(t *testing.T) {
+	policy, err := (&ClientVersionPolicy{
+		Deny:        []string{"*libssh*"},
+		MinVersions: []string{"OpenSSH_8.0", "PuTTY_Release_0.75"},
+	}).compile()
+	require.NoError(t, err)
+	assert.NoError(t, policy.check("SSH-2.0-OpenSSH_9.6p1 Ubuntu-3ubuntu13"))
+	assert.NoError(t, policy.check("SSH-2.0-OpenSSH_8.0"))
+	assert.EqualError(t, policy.check("SSH-2.0-OpenSSH_7.9p1 Debian-10"), "client version older than OpenSSH_8.0")
+	assert.EqualError(t, policy.check("SSH-2.0-PuTTY_Release_0.74"), "client version older than PuTTY_Release_0.75")
+	assert.NoError(t, policy.check("SSH-2.0-PuTTY_Release_0.80"))
+	assert.EqualError(t, policy.check("SSH-2.0-libssh_0.9.6"), `client version denied by pattern "*libssh*"`)
+	assert.NoError(t, policy.check("SSH-2.0-Go"))
+
+	policy, err = (&ClientVersionPolicy{Allow: []string{"SSH-2.0-OpenSSH_*", "SSH-2.0-Go"}}).compile()
+	require.NoError(t, err)
+	assert.NoError(t, policy.check("SSH-2.0-OpenSSH_9.6"))
+	assert.NoError(t, policy.check("SSH-2.0-Go"))
+	assert.EqualError(t, policy.check("SSH-2.0-Gopher"), "client version not allowed")
+
+	policy, err = (&ClientVersionPolicy{}).compile()
+	require.NoError(t, err)
+	assert.Nil(t, policy)
+	assert.EqualError(t, (&ClientVersionPolicy{MinVersions: []string{"OpenSSH"}}).Validate(), "OpenSSH is not in the form of <software>_<version>")
+}
+
+func TestClientVersionDenied(t *testing.T) {
+	address := serveTest(t, &Server{ClientVersions: ClientVersionPolicy{Deny: []string{"SSH-2.0-Go"}}})
+	config := &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+	_, err := ssh.Dial("tcp", address, config)
+	assert.Error(t, err)
+	config.ClientVersion = "SSH-2.0-Test_1.0"
+	client, err := ssh.Dial("tcp", address, config)
+	require.NoError(t, err)
+	client.Close()
+}
+
+func TestGeoIP(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, geoip.Write(&b, map[netip.Prefix]geoip.Location{netip.MustParsePrefix("127.0.0.0/8"): {Country: "JP", ASN: 64500}}))
+	dbFile := path.Join(t.TempDir(), "geoip.mmdb")
+	require.NoError(t, os.WriteFile(dbFile, b.Bytes(), 0600))
+	db, err := geoip.Open(dbFile)
+	require.NoError(t, err)
+	defer db.Close()
+	config := &ssh.ClientConfig{User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()}
+
+	_, err = ssh.Dial("tcp", serveTest(t, &Server{GeoIP: db, GeoIPRules: geoip.Rules{DenyCountries: []string{"JP"}}}), config)
+	assert.Error(t, err)
+
+	var logs lockedBuffer
+	s := &Server{Logger: slog.New(slog.NewTextHandler(&logs, nil)), GeoIP: db, GeoIPRules: geoip.Rules{AllowASNs: []uint{64500}}}
+	events, unsubscribe := s.Subscribe(10)
+	defer unsubscribe()
+	client, err := ssh.Dial("tcp", serveTest(t, s), config)
+	require.NoError(t, err)
+	client.Close()
+	event := <-events
+	assert.Equal(t, "JP", event.Country)
+	assert.Equal(t, uint(64500), event.ASN)
+	assert.Contains(t, logs.String(), "country=JP asn=64500")
+}
+
+func TestAlgorithms(t *testing.T) {
+	var config ssh.Config
+	require.NoError(t, Algorithms{}.Apply(&config))
+	assert.Nil(t, config.Ciphers)
+
+	require.NoError(t, Algorithms{
+		Ciphers:      "aes256-ctr,aes128-ctr",
+		MACs:         "-hmac-sha1*,hmac-sha2-512",
+		KeyExchanges: "+diffie-hellman-group16-sha512",
+	}.Apply(&config))
+	assert.Equal(t, []string{"aes256-ctr", "aes128-ctr"}, config.Ciphers)
+	assert.Equal(t, []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-512-etm@openssh.com", "hmac-sha2-256"}, config.MACs)
+	assert.Equal(t, append(append([]string(nil), DefaultKeyExchanges...), "diffie-hellman-group16-sha512"), config.KeyExchanges)
+
+	require.NoError(t, Algorithms{KeyExchanges: "^ecdh-sha2-nistp521"}.Apply(&config))
+	assert.Equal(t, "ecdh-sha2-nistp521", config.KeyExchanges[0])
+	assert.Len(t, config.KeyExchanges, len(DefaultKeyExchanges))
+
+	assert.EqualError(t, Algorithms{Ciphers: "+aes128-cbc,blowfish-cbc"}.Apply(&config), `ciphers: unsupported algorithm: "blowfish-cbc"`)
+	assert.EqualError(t, Algorithms{KeyExchanges: "diffie-hellman-group-exchange-sha256"}.Apply(&config), `key exchanges: unsupported algorithm: "diffie-hellman-group-exchange-sha256"`)
+	assert.EqualError(t, Algorithms{MACs: "-*"}.Apply(&config), "MACs: all algorithms removed")
+}
+
+func TestAlgorithmsHandshake(t *testing.T) {
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	require.NoError(t, Algorithms{MACs: "-hmac-sha1*", KeyExchanges: "-diffie-hellman-group14-sha1"}.Apply(&serverConfig.Config))
+	address := serveTest(t, &Server{Config: serverConfig})
+	dial := func(config ssh.Config) error {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{Config: config, User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial(ssh.Config{}))
+	assert.Error(t, dial(ssh.Config{Ciphers: []string{"aes128-ctr"}, MACs: []string{"hmac-sha1"}}))
+	assert.Error(t, dial(ssh.Config{KeyExchanges: []string{"diffie-hellman-group14-sha1"}}))
+	assert.NoError(t, dial(ssh.Config{Ciphers: []string{"aes128-ctr"}, MACs: []string{"hmac-sha2-256"}}))
+
+	// All supported ones work
+	serverConfig = &ssh.ServerConfig{NoClientAuth: true, Config: ssh.Config{Ciphers: SupportedCiphers, MACs: SupportedMACs, KeyExchanges: SupportedKeyExchanges}}
+	address = serveTest(t, &Server{Config: serverConfig})
+	for _, cipher := range SupportedCiphers {
+		assert.NoError(t, dial(ssh.Config{Ciphers: []string{cipher}}), cipher)
+	}
+	for _, mac := range SupportedMACs {
+		assert.NoError(t, dial(ssh.Config{Ciphers: []string{"aes128-ctr"}, MACs: []string{mac}}), mac)
+	}
+	for _, kex := range SupportedKeyExchanges {
+		assert.NoError(t, dial(ssh.Config{KeyExchanges: []string{kex}}), kex)
+	}
+}
+
+func TestPostQuantumKeyExchanges(t *testing.T) {
+	var config ssh.Config
+	if len(PostQuantumKeyExchanges) == 0 {
+		assert.EqualError(t, Algorithms{PostQuantum: PostQuantumPrefer}.Apply(&config), "key exchanges: post-quantum key exchanges require Go 1.24 or later")
+		return
+	}
+	require.NoError(t, Algorithms{KeyExchanges: "curve25519-sha256", PostQuantum: PostQuantumPrefer}.Apply(&config))
+	assert.Equal(t, append(append([]string(nil), PostQuantumKeyExchanges...), "curve25519-sha256"), config.KeyExchanges)
+	require.NoError(t, Algorithms{PostQuantum: PostQuantumRequire}.Apply(&config))
+	assert.Equal(t, PostQuantumKeyExchanges, config.KeyExchanges)
+	require.NoError(t, Algorithms{PostQuantum: PostQuantumDisable}.Apply(&config))
+	assert.Equal(t, DefaultKeyExchanges[len(PostQuantumKeyExchanges):], config.KeyExchanges)
+	assert.EqualError(t, Algorithms{KeyExchanges: "sntrup761x25519-sha512@openssh.com"}.Apply(&config), `key exchanges: unsupported algorithm: "sntrup761x25519-sha512@openssh.com"`)
+	assert.EqualError(t, Algorithms{FIPS: true, PostQuantum: PostQuantumPrefer}.Apply(&config), "key exchanges: post-quantum key exchanges are not approved by FIPS")
+	assert.EqualError(t, Algorithms{PostQuantum: "always"}.Apply(&config), `key exchanges: unknown post-quantum mode: "always"`)
+
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	require.NoError(t, Algorithms{PostQuantum: PostQuantumRequire}.Apply(&serverConfig.Config))
+	address := serveTest(t, &Server{Config: serverConfig})
+	dial := func(keyExchanges []string) error {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{Config: ssh.Config{KeyExchanges: keyExchanges}, User: "john", HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+	assert.NoError(t, dial(PostQuantumKeyExchanges))
+	assert.Error(t, dial([]string{"curve25519-sha256"}))
+}
+
+func TestFIPS(t *testing.T) {
+	var config ssh.Config
+	require.NoError(t, Algorithms{FIPS: true}.Apply(&config))
+	assert.Equal(t, FIPSCiphers, config.Ciphers)
+	assert.Equal(t, FIPSMACs, config.MACs)
+	assert.Equal(t, FIPSKeyExchanges, config.KeyExchanges)
+	require.NoError(t, Algorithms{FIPS: true, Ciphers: "-aes*-ctr"}.Apply(&config))
+	assert.Equal(t, []string{"aes128-gcm@openssh.com", "aes256-gcm@openssh.com"}, config.Ciphers)
+	assert.EqualError(t, Algorithms{FIPS: true, Ciphers: "+chacha20-poly1305@openssh.com"}.Apply(&config), `ciphers: unsupported algorithm: "chacha20-poly1305@openssh.com"`)
+
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(ed25519Key)
+	require.NoError(t, err)
+	_, err = FIPSSigner(signer)
+	assert.EqualError(t, err, "ssh-ed25519 keys are not approved by FIPS")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	signer, err = ssh.NewSignerFromKey(rsaKey)
+	require.NoError(t, err)
+	signer, err = FIPSSigner(signer)
+	require.NoError(t, err)
+	assert.Equal(t, []string{ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256}, signer.(ssh.MultiAlgorithmSigner).Algorithms())
+}
+
+func TestChrootDirectory(t *testing.T) {
+	dir, err := expandChrootDirectory("/srv/jail/%u/%%", "john")
+	require.NoError(t, err)
+	assert.Equal(t, "/srv/jail/john/%", dir)
+	_, err = expandChrootDirectory("/srv/jail/%u", "..")
+	assert.Error(t, err)
+	_, err = expandChrootDirectory("jail/%u", "john")
+	assert.EqualError(t, err, "chroot directory is not absolute: jail/john")
+
+	// The directory of the server fails unless the extension disables it
+	for chrootDirectory, ok := range map[string]bool{"": false, "none": true} {
+		client := newTestClient(t, &Server{AllowExecute: true, ChrootDirectory: "jail/%u", Config: &ssh.ServerConfig{
+			NoClientAuth: true,
+			NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+				return &ssh.Permissions{Extensions: map[string]string{ExtensionChrootDirectory: chrootDirectory}}, nil
+			},
+		}})
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		_, err = session.Output("true")
+		assert.Equal(t, ok, err == nil, chrootDirectory)
+	}
+
+	sandboxUser, err := LookupSandboxUser("nobody")
+	if err != nil || runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("chroot requires root")
+	}
+	// The temporary directory is writable by others
+	client := newTestClient(t, &Server{AllowExecute: true, ChrootDirectory: t.TempDir(), SandboxUser: sandboxUser})
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	_, err = session.Output("pwd")
+	assert.Error(t, err)
+
+	client = newTestClient(t, &Server{AllowExecute: true, ChrootDirectory: "/", SandboxUser: sandboxUser})
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("sh -c 'pwd; id -u'")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("/\n%d\n", sandboxUser.UID), string(output))
+
+	// Neither as root nor as users without OS accounts
+	for _, user := range []string{"root", "john"} {
+		s := &Server{AllowExecute: true, ChrootDirectory: "/"}
+		client, err := ssh.Dial("tcp", serveTest(t, s), &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		require.NoError(t, err)
+		defer client.Close()
+		session, err := client.NewSession()
+		require.NoError(t, err)
+		_, err = session.Output("pwd")
+		assert.Error(t, err, user)
+	}
+}
+
+func TestResourceLimits(t *testing.T) {
+	limits, err := ParseResourceLimits("cpu=60,as=1G,nofile=1024,nproc=64")
+	require.NoError(t, err)
+	assert.Equal(t, ResourceLimits{CPU: 60, AddressSpace: 1 << 30, Files: 1024, Processes: 64}, limits)
+	assert.Equal(t, "cpu=60,as=1073741824,nofile=1024,nproc=64", limits.String())
+	for _, invalid := range []string{"rss=1G", "cpu", "cpu=", "cpu=1m", "as=1T"} {
+		_, err := ParseResourceLimits(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	if runtime.GOOS != "linux" {
+		t.Skip("resource limits are supported only on Linux")
+	}
+	s := &Server{AllowExecute: true, ResourceLimits: ResourceLimits{CPU: 60, Files: 64}, Config: &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(conn ssh.ConnMetadata) (*ssh.Permissions, error) {
+			return &ssh.Permissions{Extensions: map[string]string{ExtensionResourceLimits: "nofile=32"}}, nil
+		},
+	}}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	// limits are set before the command runs
+	output, err := session.Output("sh -c 'ulimit -n; ulimit -t'")
+	assert.NoError(t, err)
+	assert.Equal(t, "32\n60\n", string(output))
+}
+
+func TestSandboxUser(t *testing.T) {
+	sandboxUser, err := LookupSandboxUser("nobody")
+	if err != nil || runtime.GOOS == "windows" || os.Geteuid() != 0 {
+		t.Skip("running as nobody needs root")
+	}
+	s := &Server{AllowExecute: true, SandboxUser: sandboxUser, Config: &ssh.ServerConfig{NoClientAuth: true}}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("sh -c 'id -u; echo $USER'")
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%d\nnobody\n", sandboxUser.UID), string(output))
+}
+
+func TestCgroupLimits(t *testing.T) {
+	limits, err := ParseCgroupLimits("cpu.weight=50,memory.max=512M,pids.max=128")
+	require.NoError(t, err)
+	assert.Equal(t, CgroupLimits{CPUWeight: 50, MemoryMax: 512 << 20, PidsMax: 128}, limits)
+	assert.Equal(t, "cpu.weight=50,memory.max=536870912,pids.max=128", limits.String())
+	for _, invalid := range []string{"cpu.max=100", "pids.max", "pids.max=", "cpu.weight=10001", "memory.max=1T"} {
+		_, err := ParseCgroupLimits(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	var parent string
+	for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		if _, err := os.Stat(path.Join(root, "cgroup.subtree_control")); err == nil {
+			parent, _ = os.MkdirTemp(root, "go-sshd-test-")
+			break
+		}
+	}
+	if parent == "" {
+		t.Skip("no writable cgroup v2 hierarchy")
+	}
+	defer os.Remove(parent)
+	s := &Server{AllowExecute: true, CgroupParent: parent, Config: &ssh.ServerConfig{NoClientAuth: true}}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	output, err := session.Output("cat /proc/self/cgroup")
+	assert.NoError(t, err)
+	assert.Contains(t, string(output), "/"+path.Base(parent)+"/session-")
+	entries, err := os.ReadDir(parent)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, entry.IsDir(), "cgroup %s is left", entry.Name())
+	}
+}
+
+func TestWallMessage(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	assert.Equal(t, "\r\n\r\nBroadcast message from go-sshd (Tue Jan  2 15:04:05 2024):\r\n\r\nrestart at 18:00\r\nsave\twork\r\n",
+		wallMessage("restart at 18:00\nsave\twork\n", now))
+	// Escape sequences can't control terminals
+	assert.Equal(t, "\r\n\r\nBroadcast message from go-sshd (Tue Jan  2 15:04:05 2024):\r\n\r\n]0;title\r\n",
+		wallMessage("\x1b]0;title\x07", now))
+}
+
+func TestAttach(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pty is not supported on Windows")
+	}
+	t.Setenv("SHELL", "/bin/sh")
+	s := &Server{AllowExecute: true, AllowAttachWrite: true}
+	address := serveTest(t, s)
+	dial := func(user string) *ssh.Client {
+		client, err := ssh.Dial("tcp", address, &ssh.ClientConfig{User: user, HostKeyCallback: ssh.InsecureIgnoreHostKey()})
+		require.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	owner, err := dial("john").NewSession()
+	require.NoError(t, err)
+	defer owner.Close()
+	// Pty sessions end on EOF of stdin
+	_, err = owner.StdinPipe()
+	require.NoError(t, err)
+	ownerOutput := &lockedBuffer{}
+	owner.Stdout = ownerOutput
+	owner.Stderr = ownerOutput
+	require.NoError(t, owner.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	require.NoError(t, owner.Shell())
+	var id string
+	require.Eventually(t, func() bool {
+		for _, conn := range s.Connections() {
+			for _, sess := range conn.Sessions {
+				if sess.Pty {
+					id = sess.ID
+				}
+			}
+		}
+		return id != ""
+	}, 3*time.Second, 10*time.Millisecond)
+
+	client := dial("john")
+	list, err := client.NewSession()
+	require.NoError(t, err)
+	output, err := list.Output(AttachCommand)
+	require.NoError(t, err)
+	assert.Regexp(t, `^ID +USER +AGE +COMMAND\n`+regexp.QuoteMeta(id)+` +john +\d+s +/bin/sh\n$`, string(output))
+
+	// Input of the attached user runs in the shell and both see the output
+	attached, err := client.NewSession()
+	require.NoError(t, err)
+	defer attached.Close()
+	attachedStdin, err := attached.StdinPipe()
+	require.NoError(t, err)
+	attachedOutput := &lockedBuffer{}
+	attached.Stdout = attachedOutput
+	require.NoError(t, attached.Start(AttachCommand+" -w "+id))
+	require.Eventually(t, func() bool {
+		return strings.Contains(ownerOutput.String(), "[go-sshd: john attached to this session (read-write)]")
+	}, 3*time.Second, 10*time.Millisecond)
+	_, err = io.WriteString(attachedStdin, "echo shared-$((20+22))\n")
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(attachedOutput.String(), "shared-42") && strings.Contains(ownerOutput.String(), "shared-42")
+	}, 3*time.Second, 10*time.Millisecond)
+	// The end of input detaches
+	require.NoError(t, attachedStdin.Close())
+	require.NoError(t, attached.Wait())
+	assert.Eventually(t, func() bool {
+		return strings.Contains(ownerOutput.String(), "[go-sshd: john detached from this session]")
+	}, 3*time.Second, 10*time.Millisecond)
+
+	// Read-write requires its permission
+	s.AllowAttachWrite = false
+	s.AllowAttach = true
+	readOnly, err := dial("john").NewSession()
+	require.NoError(t, err)
+	var stderr bytes.Buffer
+	readOnly.Stderr = &stderr
+	var exitErr *ssh.ExitError
+	require.ErrorAs(t, readOnly.Run(AttachCommand+" -w "+id), &exitErr)
+	assert.Equal(t, 1, exitErr.ExitStatus())
+	assert.Equal(t, "read-write attach not allowed\n", stderr.String())
+	unknown, err := dial("john").NewSession()
+	require.NoError(t, err)
+	stderr.Reset()
+	unknown.Stderr = &stderr
+	require.ErrorAs(t, unknown.Run(AttachCommand+" unknown"), &exitErr)
+	assert.Equal(t, "no session to attach to: unknown\n", stderr.String())
+
+	// Sessions of other users are neither listed nor attached to without AttachTargets
+	alex := dial("alex")
+	list, err = alex.NewSession()
+	require.NoError(t, err)
+	output, err = list.Output(AttachCommand)
+	require.NoError(t, err)
+	assert.Equal(t, "ID  USER  AGE  COMMAND\n", string(output))
+	other, err := alex.NewSession()
+	require.NoError(t, err)
+	stderr.Reset()
+	other.Stderr = &stderr
+	require.ErrorAs(t, other.Run(AttachCommand+" "+id), &exitErr)
+	assert.Equal(t, "no session to attach to: "+id+"\n", stderr.String())
+	s.AttachTargets = []string{"john"}
+	list, err = alex.NewSession()
+	require.NoError(t, err)
+	output, err = list.Output(AttachCommand)
+	require.NoError(t, err)
+	assert.Contains(t, string(output), id)
+	// Authorizer decides too
+	s.Authorizer = attachAuthorizer{user: "john"}
+	other, err = alex.NewSession()
+	require.NoError(t, err)
+	stderr.Reset()
+	other.Stderr = &stderr
+	require.ErrorAs(t, other.Run(AttachCommand+" "+id), &exitErr)
+	assert.Equal(t, "attach not allowed\n", stderr.String())
+
+	// Attaching is not allowed by default
+	s.AllowAttach = false
+	denied, err := dial("john").NewSession()
+	require.NoError(t, err)
+	assert.Error(t, denied.Run(AttachCommand))
+}
+
+// attachAuthorizer denies attaching to sessions of user
+type attachAuthorizer struct {
+	user string
+}
+
+func (a attachAuthorizer) Authorize(action *Action) (bool, error) {
+	return action.Type != ActionAttach || action.TargetUser != a.user, nil
+}
+
+func TestCommandHistory(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("pty is not supported on Windows")
+	}
+	t.Setenv("SHELL", "/bin/sh")
+	var mu sync.Mutex
+	var entries []HistoryEntry
+	s := &Server{AllowExecute: true, HistoryShellInput: true, CommandHistory: func(conn *ConnMetadata, entry HistoryEntry) {
+		assert.Equal(t, "john", conn.User())
+		mu.Lock()
+		defer mu.Unlock()
+		entries = append(entries, entry)
+	}}
+	client := newTestClient(t, s)
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	require.NoError(t, session.Run("echo 'hello world'"))
+	session.Close()
+
+	session, err = client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	stdin, err := session.StdinPipe()
+	require.NoError(t, err)
+	require.NoError(t, session.RequestPty("xterm", 24, 80, ssh.TerminalModes{}))
+	require.NoError(t, session.Shell())
+	// Backspaces, ^U and arrows are applied to lines
+	_, err = io.WriteString(stdin, "echo typo\x7f\x7f\x7f\x7fone\r\x1b[Arm -rf /\x15\r echo two \rexit\r")
+	require.NoError(t, err)
+	session.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, entries, 4)
+	assert.Equal(t, HistoryExec, entries[0].Type)
+	assert.Equal(t, "echo 'hello world'", entries[0].Command)
+	var lines []string
+	for _, entry := range entries[1:] {
+		assert.Equal(t, HistoryShell, entry.Type)
+		assert.Equal(t, entries[1].SessionID, entry.SessionID)
+		lines = append(lines, entry.Command)
+	}
+	assert.Equal(t, []string{"echo one", "echo two", "exit"}, lines)
+	assert.NotEqual(t, entries[0].SessionID, entries[1].SessionID)
+}
+
+func TestLineEditor(t *testing.T) {
+	var e lineEditor
+	var lines []string
+	for _, b := range []byte("ls -l foo\x17bar\r\x03\rsudo\tx\x1bOP\x7f\n日本\x7f\r") {
+		if line, ok := e.input(b); ok {
+			lines = append(lines, line)
+		}
+	}
+	assert.Equal(t, []string{"ls -l bar", "sudo", "日"}, lines)
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	"net"
+	"sync"
+
+	"github.com/John-Ao/go-sshd/server/session"
+
+	"github.com/mattn/go-shellwords"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// Session is a "session" channel served by Server.Handler.
+// Reading and writing a Session read stdin and write stdout of the client.
+type Session interface {
+	ssh.Channel
+
+	// User returns the user name of the connection. It is empty when the connection is unknown.
+	User() string
+	// RemoteAddr returns the address of the client. It is nil when the connection is unknown.
+	RemoteAddr() net.Addr
+	// RawCommand returns the command line of the "exec" request, or the forced command by Server.ForceCommand. It is empty for "shell" without one.
+	RawCommand() string
+	// Command returns RawCommand split into words.
+	Command() []string
+	// Environ returns environment variables set by "env" requests in "key=value" form,
+	// followed by the ones of agent and X11 forwarding such as SSH_AUTH_SOCK and DISPLAY,
+	// and SSH_CONNECTION, SSH_CLIENT, USER, LOGNAME and SSH_ORIGINAL_COMMAND of the connection.
+	Environ() []string
+	// Pty returns the pty requested by the client, a channel of window changes and whether a pty was requested.
+	Pty() (Pty, <-chan Window, bool)
+	// Exit sends the exit status to the client and closes the session.
+	Exit(code int) error
+	// Conn returns the connection of the session.
+	Conn() *ConnMetadata
+}
+
+// Pty is a pseudo terminal requested by "pty-req".
+type Pty = session.Pty
+
+// Window is a size of a terminal in characters.
+type Window = session.Window
+
+// handlerSession is a Session passed to Server.Handler
+type handlerSession struct {
+	ssh.Channel
+	conn       *ConnMetadata
+	rawCommand string
+	env        []string
+	pty        *Pty
+	winCh      chan Window
+	exitOnce   sync.Once
+	exitCode   int
+}
+
+var _ Session = (*handlerSession)(nil)
+
+func (sess *handlerSession) User() string {
+	return sess.conn.User()
+}
+
+func (sess *handlerSession) RemoteAddr() net.Addr {
+	return sess.conn.RemoteAddr()
+}
+
+func (sess *handlerSession) Conn() *ConnMetadata {
+	return sess.conn
+}
+
+func (sess *handlerSession) RawCommand() string {
+	return sess.rawCommand
+}
+
+func (sess *handlerSession) Command() []string {
+	words, err := shellwords.Parse(sess.rawCommand)
+	if err != nil {
+		return nil
+	}
+	return words
+}
+
+func (sess *handlerSession) Environ() []string {
+	return append([]string(nil), sess.env...)
+}
+
+func (sess *handlerSession) Pty() (Pty, <-chan Window, bool) {
+	if sess.pty == nil {
+		return Pty{}, sess.winCh, false
+	}
+	return *sess.pty, sess.winCh, true
+}
+
+func (sess *handlerSession) Exit(code int) error {
+	err := net.ErrClosed
+	sess.exitOnce.Do(func() {
+		sess.exitCode = code
+		_, err = sess.SendRequest("exit-status", false, session.ExitStatus(code))
+		sess.Close()
+	})
+	return err
+}
+
+// setWindow notifies the latest window size without blocking the request loop.
+func (sess *handlerSession) setWindow(w Window) {
+	for {
+		select {
+		case sess.winCh <- w:
+			return
+		default:
+		}
+		// Drop the stale size not received yet
+		select {
+		case <-sess.winCh:
+		default:
+		}
+	}
+}
+
+func (s *Server) handleSessionWithHandler(logger *slog.Logger, conn *connection, newChannel ssh.NewChannel) {
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		logger.Info("Could not accept channel", "err", err)
+		return
+	}
+	active, channel, removeSession := conn.addSession(channel)
+	defer removeSession()
+	sess := &handlerSession{Channel: channel, conn: conn.metadata, winCh: make(chan Window, 1)}
+	started := false
+	// env is set by "env" requests
+	var env []string
+	var forwards sessionForwards
+	defer forwards.close()
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			name, value, err := session.ParseEnv(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if started {
+				req.Reply(false, nil)
+				break
+			}
+			env = append(env, name+"="+value)
+			req.Reply(true, nil)
+		case "pty-req":
+			if !conn.permissions.execute {
+				logger.Info("execution not allowed (pty-req)")
+				req.Reply(false, nil)
+				break
+			}
+			if conn.denyPty {
+				logger.Info("pty not allowed")
+				req.Reply(false, nil)
+				break
+			}
+			pty, err := session.ParsePtyRequest(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			if started {
+				req.Reply(false, nil)
+				break
+			}
+			sess.pty = pty
+			sess.setWindow(sess.pty.Window)
+			req.Reply(true, nil)
+		case "window-change":
+			window, err := session.ParseWindowChange(req.Payload)
+			if err != nil {
+				s.malformedRequest(logger, conn, req, err)
+				break
+			}
+			sess.setWindow(window)
+			active.tap.resize(window)
+		case "shell", "exec":
+			// A forced command is not scp
+			if !conn.permissions.execute && !(req.Type == "exec" && conn.permissions.scp && conn.forceCommand == "") {
+				logger.Info("execution not allowed", "req_type", req.Type)
+				req.Reply(false, nil)
+				break
+			}
+			if started {
+				req.Reply(false, nil)
+				break
+			}
+			// rawCommand is the command line of "exec" even if it is replaced by the forced command
+			var rawCommand string
+			if req.Type == "exec" {
+				rawCommand, err = session.ParseExec(req.Payload)
+				if err != nil {
+					s.malformedRequest(logger, conn, req, err)
+					break
+				}
+				sess.rawCommand = rawCommand
+				if conn.forceCommand == "" && !conn.permissions.executes(sess.Command()) {
+					logger.Info("execution not allowed", "req_type", req.Type)
+					req.Reply(false, nil)
+					break
+				}
+			}
+			if conn.forceCommand != "" {
+				if _, err := conn.forcedCommand(); err != nil {
+					logger.Info("failed to force command", "err", err)
+					req.Reply(false, nil)
+					break
+				}
+				sess.rawCommand = conn.forceCommand
+			}
+			if !s.authorize(logger, conn, &Action{Type: req.Type, Command: sess.Command()}) {
+				req.Reply(false, nil)
+				break
+			}
+			sess.env = forwards.env
+			// Variables such as LD_PRELOAD could run other programs than scp
+			if conn.permissions.execute {
+				sess.env = append(env, forwards.env...)
+			}
+			// Clients can't override them
+			sess.env = append(sess.env, conn.sshEnv()...)
+			sess.env = append(sess.env, conn.forcedEnv(rawCommand)...)
+			started = true
+			req.Reply(true, nil)
+			active.start(req.Type, sess.Command(), sess.pty != nil)
+			s.publish(conn, Event{Type: EventSessionStarted, Command: sess.Command()})
+			if req.Type == "exec" {
+				s.recordHistory(conn, active.snapshot().ID, HistoryExec, rawCommand)
+			} else if sess.pty != nil {
+				sess.Channel = s.historyInput(conn, active.snapshot().ID, sess.Channel)
+			}
+			conn.lifecycle.Go(func() {
+				s.Handler(sess)
+				sess.Exit(0)
+				s.publish(conn, Event{Type: EventSessionEnded, Command: sess.Command(), ExitCode: sess.exitCode})
+			})
+		case "auth-agent-req@openssh.com":
+			s.handleAgentRequest(logger, conn, req, &forwards, started)
+		case "x11-req":
+			s.handleX11Request(logger, conn, req, &forwards, started)
+		case "subsystem":
+			s.handleSessionSubSystem(logger, conn, req, channel, active)
+		default:
+			logger.Info("unsupported request", "req_type", req.Type)
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+		}
+	}
+	close(sess.winCh)
+}
@@ -0,0 +1,197 @@
+// Package session decodes and encodes requests of "session" channels.
+// https://datatracker.ietf.org/doc/html/rfc4254#section-6
+package session
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrMalformed is wrapped by errors of the parsers for payloads violating the protocol or the limits below.
+var ErrMalformed = errors.New("malformed request")
+
+// Limits of decoded values
+const (
+	MaxTermLength    = 256
+	MaxWindowSize    = 10000
+	MaxEnvLength     = 64 * 1024
+	MaxCommandLength = 256 * 1024
+	MaxSignalLength  = 32
+	MaxX11AuthLength = 256
+)
+
+// Pty is a pseudo terminal requested by "pty-req".
+type Pty struct {
+	Term   string
+	Window Window
+}
+
+// Window is a size of a terminal in characters.
+type Window struct {
+	Width  int
+	Height int
+}
+
+func malformed(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrMalformed, fmt.Sprintf(format, args...))
+}
+
+// decode unmarshals payload strictly. ssh.Unmarshal rejects short payloads and trailing data.
+func decode(reqType string, payload []byte, out any) error {
+	if err := ssh.Unmarshal(payload, out); err != nil {
+		return malformed("%s: %v", reqType, err)
+	}
+	return nil
+}
+
+func validateWindow(reqType string, columns, rows uint32) error {
+	if columns > MaxWindowSize || rows > MaxWindowSize {
+		return malformed("%s: window too large: %dx%d", reqType, columns, rows)
+	}
+	return nil
+}
+
+// ParsePtyRequest parses the payload of "pty-req".
+func ParsePtyRequest(payload []byte) (*Pty, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.2
+	var msg struct {
+		Term     string
+		Columns  uint32
+		Rows     uint32
+		Width    uint32
+		Height   uint32
+		Modelist string
+	}
+	if err := decode("pty-req", payload, &msg); err != nil {
+		return nil, err
+	}
+	if len(msg.Term) > MaxTermLength || strings.ContainsRune(msg.Term, 0) {
+		return nil, malformed("pty-req: invalid terminal type")
+	}
+	if err := validateWindow("pty-req", msg.Columns, msg.Rows); err != nil {
+		return nil, err
+	}
+	return &Pty{Term: msg.Term, Window: Window{Width: int(msg.Columns), Height: int(msg.Rows)}}, nil
+}
+
+// ParseWindowChange parses the payload of "window-change".
+func ParseWindowChange(payload []byte) (Window, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.7
+	var msg struct {
+		Columns uint32
+		Rows    uint32
+		Width   uint32
+		Height  uint32
+	}
+	if err := decode("window-change", payload, &msg); err != nil {
+		return Window{}, err
+	}
+	if err := validateWindow("window-change", msg.Columns, msg.Rows); err != nil {
+		return Window{}, err
+	}
+	return Window{Width: int(msg.Columns), Height: int(msg.Rows)}, nil
+}
+
+// ParseEnv parses the payload of "env".
+func ParseEnv(payload []byte) (name string, value string, err error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.4
+	var msg struct {
+		Name  string
+		Value string
+	}
+	if err := decode("env", payload, &msg); err != nil {
+		return "", "", err
+	}
+	if msg.Name == "" || strings.ContainsAny(msg.Name, "=\x00") {
+		return "", "", malformed("env: invalid name %q", msg.Name)
+	}
+	if strings.ContainsRune(msg.Value, 0) || len(msg.Name)+len(msg.Value) > MaxEnvLength {
+		return "", "", malformed("env: invalid value of %s", msg.Name)
+	}
+	return msg.Name, msg.Value, nil
+}
+
+// ParseExec parses the payload of "exec" into the command line.
+func ParseExec(payload []byte) (string, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.5
+	var msg struct {
+		Command string
+	}
+	if err := decode("exec", payload, &msg); err != nil {
+		return "", err
+	}
+	if len(msg.Command) > MaxCommandLength || strings.ContainsRune(msg.Command, 0) {
+		return "", malformed("exec: invalid command")
+	}
+	return msg.Command, nil
+}
+
+// ParseSubsystem parses the payload of "subsystem" into the subsystem name.
+func ParseSubsystem(payload []byte) (string, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.5
+	var msg struct {
+		Name string
+	}
+	if err := decode("subsystem", payload, &msg); err != nil {
+		return "", err
+	}
+	return msg.Name, nil
+}
+
+// ParseSignal parses the payload of "signal". The name is without the "SIG" prefix (e.g. "INT").
+func ParseSignal(payload []byte) (ssh.Signal, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.9
+	var msg struct {
+		Signal string
+	}
+	if err := decode("signal", payload, &msg); err != nil {
+		return "", err
+	}
+	if msg.Signal == "" || len(msg.Signal) > MaxSignalLength {
+		return "", malformed("signal: invalid name %q", msg.Signal)
+	}
+	for _, r := range msg.Signal {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '@' || r == '.' || r == '-' || r == '_') {
+			return "", malformed("signal: invalid name %q", msg.Signal)
+		}
+	}
+	return ssh.Signal(msg.Signal), nil
+}
+
+// X11Request is a request of X11 forwarding by "x11-req".
+type X11Request struct {
+	// SingleConnection allows only one X11 connection to be forwarded
+	SingleConnection bool
+	// AuthProtocol is the X11 authentication protocol, e.g. "MIT-MAGIC-COOKIE-1"
+	AuthProtocol string
+	// AuthCookie is the hexadecimal authentication cookie
+	AuthCookie   string
+	ScreenNumber uint32
+}
+
+// ParseX11Request parses the payload of "x11-req".
+func ParseX11Request(payload []byte) (*X11Request, error) {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.3.1
+	var msg X11Request
+	if err := decode("x11-req", payload, &msg); err != nil {
+		return nil, err
+	}
+	if msg.AuthProtocol == "" || len(msg.AuthProtocol) > MaxX11AuthLength || strings.ContainsAny(msg.AuthProtocol, "\x00 ") {
+		return nil, malformed("x11-req: invalid authentication protocol")
+	}
+	if msg.AuthCookie == "" || len(msg.AuthCookie) > MaxX11AuthLength || strings.Trim(msg.AuthCookie, "0123456789abcdefABCDEF") != "" {
+		return nil, malformed("x11-req: invalid authentication cookie")
+	}
+	return &msg, nil
+}
+
+// ExitStatus returns the payload of "exit-status".
+func ExitStatus(code int) []byte {
+	// https://datatracker.ietf.org/doc/html/rfc4254#section-6.10
+	return ssh.Marshal(struct {
+		Status uint32
+	}{Status: uint32(code)})
+}
@@ -0,0 +1,126 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePtyRequest(t *testing.T) {
+	payload := ssh.Marshal(struct {
+		Term     string
+		Columns  uint32
+		Rows     uint32
+		Width    uint32
+		Height   uint32
+		Modelist string
+	}{Term: "xterm", Columns: 80, Rows: 24})
+	pty, err := ParsePtyRequest(payload)
+	require.NoError(t, err)
+	assert.Equal(t, &Pty{Term: "xterm", Window: Window{Width: 80, Height: 24}}, pty)
+
+	_, err = ParsePtyRequest(payload[:6])
+	assert.Error(t, err)
+}
+
+func TestParseWindowChange(t *testing.T) {
+	window, err := ParseWindowChange(ssh.Marshal(struct {
+		Columns uint32
+		Rows    uint32
+		Width   uint32
+		Height  uint32
+	}{Columns: 120, Rows: 40}))
+	require.NoError(t, err)
+	assert.Equal(t, Window{Width: 120, Height: 40}, window)
+
+	// Too short payloads must not panic
+	_, err = ParseWindowChange([]byte{0, 0, 0})
+	assert.Error(t, err)
+}
+
+func TestParseSubsystem(t *testing.T) {
+	name, err := ParseSubsystem(ssh.Marshal(struct{ Name string }{Name: "sftp"}))
+	require.NoError(t, err)
+	assert.Equal(t, "sftp", name)
+	_, err = ParseSubsystem(nil)
+	assert.Error(t, err)
+}
+
+func TestParseEnv(t *testing.T) {
+	name, value, err := ParseEnv(ssh.Marshal(struct{ Name, Value string }{Name: "LANG", Value: "C"}))
+	require.NoError(t, err)
+	assert.Equal(t, "LANG", name)
+	assert.Equal(t, "C", value)
+	for _, msg := range []struct{ Name, Value string }{{Name: ""}, {Name: "A=B"}, {Name: "A\x00"}, {Name: "A", Value: "\x00"}} {
+		_, _, err = ParseEnv(ssh.Marshal(msg))
+		assert.ErrorIs(t, err, ErrMalformed, "%q", msg)
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	signal, err := ParseSignal(ssh.Marshal(struct{ Signal string }{Signal: "INT"}))
+	require.NoError(t, err)
+	assert.Equal(t, ssh.SIGINT, signal)
+	_, err = ParseSignal(ssh.Marshal(struct{ Signal string }{Signal: "INT; rm -rf /"}))
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestParseX11Request(t *testing.T) {
+	req, err := ParseX11Request(ssh.Marshal(X11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "0123abcd", ScreenNumber: 1}))
+	require.NoError(t, err)
+	assert.Equal(t, &X11Request{AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "0123abcd", ScreenNumber: 1}, req)
+	for _, msg := range []X11Request{{AuthProtocol: "", AuthCookie: "00"}, {AuthProtocol: "MIT-MAGIC-COOKIE-1", AuthCookie: "00; rm -rf /"}} {
+		_, err = ParseX11Request(ssh.Marshal(msg))
+		assert.ErrorIs(t, err, ErrMalformed, "%q", msg)
+	}
+}
+
+func TestTrailingData(t *testing.T) {
+	payload := append(ssh.Marshal(struct{ Command string }{Command: "ls"}), 0)
+	_, err := ParseExec(payload)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func FuzzParsePtyRequest(f *testing.F) {
+	f.Add(ssh.Marshal(struct {
+		Term                         string
+		Columns, Rows, Width, Height uint32
+		Modelist                     string
+	}{Term: "xterm", Columns: 80, Rows: 24}))
+	f.Add([]byte{0, 0, 0, 5, 'x'})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		pty, err := ParsePtyRequest(payload)
+		if err != nil {
+			assert.ErrorIs(t, err, ErrMalformed)
+			return
+		}
+		assert.LessOrEqual(t, pty.Window.Width, MaxWindowSize)
+		assert.LessOrEqual(t, pty.Window.Height, MaxWindowSize)
+	})
+}
+
+func FuzzParseWindowChange(f *testing.F) {
+	f.Add(ssh.Marshal(struct{ Columns, Rows, Width, Height uint32 }{Columns: 80, Rows: 24}))
+	f.Add([]byte{0, 0, 0})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		if _, err := ParseWindowChange(payload); err != nil {
+			assert.ErrorIs(t, err, ErrMalformed)
+		}
+	})
+}
+
+func FuzzParseRequests(f *testing.F) {
+	f.Add(ssh.Marshal(struct{ Name, Value string }{Name: "LANG", Value: "C"}))
+	f.Add(ssh.Marshal(struct{ Command string }{Command: "ls -l"}))
+	f.Add([]byte{0xff, 0xff, 0xff, 0xff})
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		// None of them may panic
+		ParseEnv(payload)
+		ParseExec(payload)
+		ParseSubsystem(payload)
+		ParseSignal(payload)
+		ParseX11Request(payload)
+	})
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/John-Ao/go-sshd/server/session"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// x11DisplayOffset is the first X11 display number like X11DisplayOffset of sshd_config
+const x11DisplayOffset = 10
+
+// maxX11Displays is the number of display numbers tried
+const maxX11Displays = 1000
+
+// sessionForwards are agent and X11 forwarding requested by a session.
+type sessionForwards struct {
+	// env is environment variables for the forwarding, e.g. SSH_AUTH_SOCK
+	env   []string
+	agent bool
+	x11   bool
+	stops []func()
+}
+
+// close stops forwarding
+func (f *sessionForwards) close() {
+	for _, stop := range f.stops {
+		stop()
+	}
+}
+
+// handleAgentRequest serves "auth-agent-req@openssh.com" before the process starts
+func (s *Server) handleAgentRequest(logger *slog.Logger, conn *connection, req *ssh.Request, forwards *sessionForwards, started bool) {
+	if !conn.permissions.agentForward {
+		logger.Info("agent forwarding not allowed")
+		req.Reply(false, nil)
+		return
+	}
+	if started || forwards.agent || conn.sshConn == nil {
+		req.Reply(false, nil)
+		return
+	}
+	path, stop, err := s.forwardAgent(logger, conn)
+	if err != nil {
+		logger.Info("failed to forward agent", "err", err)
+		req.Reply(false, nil)
+		return
+	}
+	forwards.agent = true
+	forwards.stops = append(forwards.stops, stop)
+	forwards.env = append(forwards.env, "SSH_AUTH_SOCK="+path)
+	req.Reply(true, nil)
+}
+
+// handleX11Request serves "x11-req" before the process starts
+func (s *Server) handleX11Request(logger *slog.Logger, conn *connection, req *ssh.Request, forwards *sessionForwards, started bool) {
+	if !conn.permissions.x11Forward {
+		logger.Info("X11 forwarding not allowed")
+		req.Reply(false, nil)
+		return
+	}
+	x11Req, err := session.ParseX11Request(req.Payload)
+	if err != nil {
+		s.malformedRequest(logger, conn, req, err)
+		return
+	}
+	if started || forwards.x11 || conn.sshConn == nil {
+		req.Reply(false, nil)
+		return
+	}
+	display, stop, err := s.forwardX11(logger, conn, x11Req)
+	if err != nil {
+		logger.Info("failed to forward X11", "err", err)
+		req.Reply(false, nil)
+		return
+	}
+	forwards.x11 = true
+	forwards.stops = append(forwards.stops, stop)
+	forwards.env = append(forwards.env, "DISPLAY="+display)
+	req.Reply(true, nil)
+}
+
+// forwardAgent listens on a Unix domain socket and relays its connections to the agent of the client.
+// It returns the path of the socket and the function to stop forwarding.
+func (s *Server) forwardAgent(logger *slog.Logger, conn *connection) (string, func(), error) {
+	// The directory is only accessible by this user
+	dir, err := os.MkdirTemp("", "go-sshd-agent-")
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(dir, "agent.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, err
+	}
+	conn.lifecycle.Go(func() {
+		s.acceptForwarded(logger, conn, ln, "auth-agent@openssh.com", func(net.Conn) []byte { return nil }, false)
+	})
+	return path, func() {
+		ln.Close()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// forwardX11 listens on a free X11 display on localhost and relays its connections to the X server of the client.
+// It returns the display for DISPLAY and the function to stop forwarding.
+func (s *Server) forwardX11(logger *slog.Logger, conn *connection, req *session.X11Request) (string, func(), error) {
+	var ln net.Listener
+	var displayNumber int
+	for n := x11DisplayOffset; n < x11DisplayOffset+maxX11Displays; n++ {
+		var err error
+		ln, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(6000+n)))
+		if err == nil {
+			displayNumber = n
+			break
+		}
+	}
+	if ln == nil {
+		return "", nil, fmt.Errorf("no free X11 display")
+	}
+	// X clients authenticate with the cookie, which the SSH client replaces with the real one
+	xauthDisplay := fmt.Sprintf("unix:%d.%d", displayNumber, req.ScreenNumber)
+	if output, err := exec.Command("xauth", "add", xauthDisplay, req.AuthProtocol, req.AuthCookie).CombinedOutput(); err != nil {
+		logger.Warn("failed to add X11 cookie by xauth", "err", err, "output", strings.TrimSpace(string(output)))
+	}
+	originator := func(x11Conn net.Conn) []byte {
+		// https://datatracker.ietf.org/doc/html/rfc4254#section-6.3.2
+		msg := struct {
+			OriginatorAddress string
+			OriginatorPort    uint32
+		}{}
+		if addr, ok := x11Conn.RemoteAddr().(*net.TCPAddr); ok {
+			msg.OriginatorAddress = addr.IP.String()
+			msg.OriginatorPort = uint32(addr.Port)
+		}
+		return ssh.Marshal(msg)
+	}
+	conn.lifecycle.Go(func() {
+		s.acceptForwarded(logger, conn, ln, "x11", originator, req.SingleConnection)
+	})
+	return fmt.Sprintf("localhost:%d.%d", displayNumber, req.ScreenNumber), func() {
+		ln.Close()
+		exec.Command("xauth", "remove", xauthDisplay).Run()
+	}, nil
+}
+
+// acceptForwarded relays connections of ln to channels of channelType opened to the client of conn until ln is closed
+func (s *Server) acceptForwarded(logger *slog.Logger, conn *connection, ln net.Listener, channelType string, extraData func(net.Conn) []byte, single bool) {
+	for {
+		localConn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if single {
+			ln.Close()
+		}
+		conn.lifecycle.Go(func() {
+			defer localConn.Close()
+			channel, reqs, err := conn.sshConn.OpenChannel(channelType, extraData(localConn))
+			if err != nil {
+				logger.Info("failed to open channel to client", "channel_type", channelType, "err", err)
+				return
+			}
+			conn.lifecycle.Go(func() { ssh.DiscardRequests(reqs) })
+			defer channel.Close()
+			var closeOnce sync.Once
+			closeRelay := func() {
+				closeOnce.Do(func() {
+					channel.Close()
+					localConn.Close()
+				})
+			}
+			conn.lifecycle.Go(func() {
+				s.copyRelay(logger, conn, channel, localConn, true, closeRelay)
+				closeRelay()
+			})
+			s.copyRelay(logger, conn, localConn, channel, false, closeRelay)
+			closeRelay()
+		})
+	}
+}
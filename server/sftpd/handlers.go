@@ -0,0 +1,177 @@
+package sftpd
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// authorizedHandlers serves the local file system and asks authorize for each request.
+type authorizedHandlers struct {
+	authorize func(req *Request) bool
+}
+
+func newAuthorizedHandlers(authorize func(req *Request) bool) sftp.Handlers {
+	h := &authorizedHandlers{authorize: authorize}
+	return sftp.Handlers{FileGet: h, FilePut: h, FileCmd: h, FileList: h}
+}
+
+func (h *authorizedHandlers) check(r *sftp.Request) error {
+	req := &Request{Operation: r.Method, Path: r.Filepath}
+	switch r.Method {
+	case "Rename", "Link", "Symlink":
+		req.TargetPath = r.Target
+	}
+	if !h.authorize(req) {
+		return sftp.ErrSSHFxPermissionDenied
+	}
+	return nil
+}
+
+func (h *authorizedHandlers) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	if err := h.check(r); err != nil {
+		return nil, err
+	}
+	return os.Open(r.Filepath)
+}
+
+func (h *authorizedHandlers) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if err := h.check(r); err != nil {
+		return nil, err
+	}
+	// O_APPEND is not used because os.File does not allow WriteAt with it
+	flag := os.O_WRONLY
+	pflags := r.Pflags()
+	if pflags.Read {
+		flag = os.O_RDWR
+	}
+	if pflags.Creat {
+		flag |= os.O_CREATE
+	}
+	if pflags.Trunc {
+		flag |= os.O_TRUNC
+	}
+	if pflags.Excl {
+		flag |= os.O_EXCL
+	}
+	return os.OpenFile(r.Filepath, flag, 0644)
+}
+
+func (h *authorizedHandlers) Filecmd(r *sftp.Request) error {
+	if err := h.check(r); err != nil {
+		return err
+	}
+	switch r.Method {
+	case "Setstat":
+		return setstat(r)
+	case "Rename":
+		return os.Rename(r.Filepath, r.Target)
+	case "Rmdir", "Remove":
+		return os.Remove(r.Filepath)
+	case "Mkdir":
+		return os.Mkdir(r.Filepath, 0755)
+	case "Link":
+		return os.Link(r.Filepath, r.Target)
+	case "Symlink":
+		// Filepath is the target and Target is the link path
+		return os.Symlink(r.Filepath, r.Target)
+	}
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+func setstat(r *sftp.Request) error {
+	flags := r.AttrFlags()
+	attrs := r.Attributes()
+	if flags.Size {
+		if err := os.Truncate(r.Filepath, int64(attrs.Size)); err != nil {
+			return err
+		}
+	}
+	if flags.Permissions {
+		if err := os.Chmod(r.Filepath, attrs.FileMode()); err != nil {
+			return err
+		}
+	}
+	if flags.UidGid {
+		if err := os.Chown(r.Filepath, int(attrs.UID), int(attrs.GID)); err != nil {
+			return err
+		}
+	}
+	if flags.Acmodtime {
+		if err := os.Chtimes(r.Filepath, time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *authorizedHandlers) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	if err := h.check(r); err != nil {
+		return nil, err
+	}
+	switch r.Method {
+	case "List":
+		entries, err := os.ReadDir(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		var infos []os.FileInfo
+		for _, entry := range entries {
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+			infos = append(infos, info)
+		}
+		return fileInfoLister(infos), nil
+	case "Stat":
+		info, err := os.Stat(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfoLister{info}, nil
+	case "Readlink":
+		target, err := os.Readlink(r.Filepath)
+		if err != nil {
+			return nil, err
+		}
+		return fileInfoLister{linkInfo{name: target}}, nil
+	}
+	return nil, sftp.ErrSSHFxOpUnsupported
+}
+
+func (h *authorizedHandlers) Lstat(r *sftp.Request) (sftp.ListerAt, error) {
+	if err := h.check(r); err != nil {
+		return nil, err
+	}
+	info, err := os.Lstat(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return fileInfoLister{info}, nil
+}
+
+type fileInfoLister []os.FileInfo
+
+func (l fileInfoLister) ListAt(dst []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(dst, l[offset:])
+	if n < len(dst) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// linkInfo carries the target of "Readlink" as the name
+type linkInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (l linkInfo) Name() string {
+	return l.name
+}
@@ -0,0 +1,142 @@
+package sftpd
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Operation is an SFTP request observed by NewOperationReader.
+type Operation struct {
+	// Name is the request type (e.g. "open", "read", "remove")
+	Name string
+	// Path is the path of requests on paths. It is empty for requests on handles such as "read" and "write".
+	Path string
+	// TargetPath is the second path of "rename", "symlink", "posix-rename@openssh.com" and "hardlink@openssh.com"
+	TargetPath string
+}
+
+// operations are the names of SFTP request packet types
+// https://datatracker.ietf.org/doc/html/draft-ietf-secsh-filexfer-02#section-3
+var operations = map[byte]string{
+	1:   "init",
+	3:   "open",
+	4:   "close",
+	5:   "read",
+	6:   "write",
+	7:   "lstat",
+	8:   "fstat",
+	9:   "setstat",
+	10:  "fsetstat",
+	11:  "opendir",
+	12:  "readdir",
+	13:  "remove",
+	14:  "mkdir",
+	15:  "rmdir",
+	16:  "realpath",
+	17:  "stat",
+	18:  "rename",
+	19:  "readlink",
+	20:  "symlink",
+	200: "extended",
+}
+
+// pathOperations are the packet types followed by the request ID and paths
+var pathOperations = map[byte]bool{3: true, 7: true, 9: true, 11: true, 13: true, 14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 200: true}
+
+// maxPathPacket is the maximum length of packets parsed for paths
+const maxPathPacket = 64 * 1024
+
+// operationReader parses packets read from the client
+type operationReader struct {
+	r       io.Reader
+	observe func(operation Operation)
+	// header is the length and the type of the current packet
+	header    [5]byte
+	headerLen int
+	// remaining is the length of the rest of the current packet
+	remaining uint32
+	// body is the current packet being parsed for paths if not nil
+	body []byte
+}
+
+// NewOperationReader returns a reader of r, the stream from an SFTP client, calling observe with each request.
+// Unknown request types are observed as "unknown".
+func NewOperationReader(r io.Reader, observe func(operation Operation)) io.Reader {
+	return &operationReader{r: r, observe: observe}
+}
+
+func (o *operationReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	for b := p[:n]; len(b) != 0; {
+		if o.remaining != 0 {
+			chunk := uint32(len(b))
+			if o.remaining < chunk {
+				chunk = o.remaining
+			}
+			if o.body != nil {
+				o.body = append(o.body, b[:chunk]...)
+			}
+			o.remaining -= chunk
+			b = b[chunk:]
+			if o.remaining == 0 && o.body != nil {
+				o.observe(parseOperation(o.header[4], o.body))
+				o.body = nil
+			}
+			continue
+		}
+		o.header[o.headerLen] = b[0]
+		o.headerLen++
+		b = b[1:]
+		if o.headerLen != len(o.header) {
+			continue
+		}
+		o.headerLen = 0
+		// The length includes the type
+		if length := binary.BigEndian.Uint32(o.header[:4]); length != 0 {
+			o.remaining = length - 1
+		}
+		if pathOperations[o.header[4]] && o.remaining != 0 && o.remaining <= maxPathPacket {
+			o.body = make([]byte, 0, o.remaining)
+			continue
+		}
+		o.observe(parseOperation(o.header[4], nil))
+	}
+	return n, err
+}
+
+// parseOperation parses the body of a packet after the type. Paths are empty if body is nil or malformed.
+func parseOperation(packetType byte, body []byte) Operation {
+	operation := Operation{Name: operations[packetType]}
+	if operation.Name == "" {
+		operation.Name = "unknown"
+	}
+	if len(body) < 4 {
+		return operation
+	}
+	// Skip the request ID
+	body = body[4:]
+	if packetType == 200 {
+		var name string
+		name, body = parseString(body)
+		if name != "posix-rename@openssh.com" && name != "hardlink@openssh.com" {
+			return operation
+		}
+	}
+	operation.Path, body = parseString(body)
+	switch packetType {
+	case 18, 20, 200:
+		operation.TargetPath, _ = parseString(body)
+	}
+	return operation
+}
+
+func parseString(b []byte) (string, []byte) {
+	if len(b) < 4 {
+		return "", nil
+	}
+	length := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < length {
+		return "", nil
+	}
+	return string(b[4 : 4+length]), b[4+length:]
+}
@@ -0,0 +1,58 @@
+package sftpd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sftpPacket returns a packet of packetType with the fields of uint32 and string values
+func sftpPacket(packetType byte, fields ...any) []byte {
+	body := []byte{packetType}
+	for _, field := range fields {
+		switch v := field.(type) {
+		case uint32:
+			body = binary.BigEndian.AppendUint32(body, v)
+		case string:
+			body = binary.BigEndian.AppendUint32(body, uint32(len(v)))
+			body = append(body, v...)
+		}
+	}
+	return append(binary.BigEndian.AppendUint32(nil, uint32(len(body))), body...)
+}
+
+func TestOperationReader(t *testing.T) {
+	var stream bytes.Buffer
+	stream.Write(sftpPacket(1, uint32(3)))
+	stream.Write(sftpPacket(3, uint32(1), "/tmp/a.txt", uint32(0), uint32(0)))
+	stream.Write(sftpPacket(6, uint32(2), "handle", "data"))
+	stream.Write(sftpPacket(18, uint32(3), "a.txt", "b.txt"))
+	stream.Write(sftpPacket(200, uint32(4), "posix-rename@openssh.com", "b.txt", "c.txt"))
+	stream.Write(sftpPacket(200, uint32(5), "statvfs@openssh.com", "/"))
+	// Malformed
+	stream.Write(sftpPacket(13, uint32(6), uint32(100)))
+	stream.Write(sftpPacket(99))
+	var operations []Operation
+	// Packets are split across reads
+	r := NewOperationReader(iotest.OneByteReader(bytes.NewReader(stream.Bytes())), func(operation Operation) {
+		operations = append(operations, operation)
+	})
+	b, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, stream.Bytes(), b)
+	assert.Equal(t, []Operation{
+		{Name: "init"},
+		{Name: "open", Path: "/tmp/a.txt"},
+		{Name: "write"},
+		{Name: "rename", Path: "a.txt", TargetPath: "b.txt"},
+		{Name: "extended", Path: "b.txt", TargetPath: "c.txt"},
+		{Name: "extended"},
+		{Name: "remove"},
+		{Name: "unknown"},
+	}, operations)
+}
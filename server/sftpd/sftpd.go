@@ -0,0 +1,103 @@
+// Package sftpd serves the SFTP subsystem on the local file system.
+package sftpd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/sftp"
+)
+
+// Request is an SFTP request passed to Options.Authorize.
+type Request struct {
+	// Operation is the request method (e.g. "Get", "Put", "Remove", "List")
+	Operation string
+	Path      string
+	// TargetPath is the new path of "Rename", "Link" and "Symlink"
+	TargetPath string
+}
+
+type Options struct {
+	// WorkingDirectory is the directory relative paths are resolved from. The working directory of the process is used if empty.
+	WorkingDirectory string
+	// Authorize decides each request if not nil.
+	Authorize func(req *Request) bool
+}
+
+// Serve serves SFTP on channel until the client closes it.
+func Serve(channel io.ReadWriteCloser, options Options) error {
+	if options.Authorize != nil {
+		wd := options.WorkingDirectory
+		if wd == "" {
+			var err error
+			wd, err = os.Getwd()
+			if err != nil {
+				return err
+			}
+		}
+		requestServer := sftp.NewRequestServer(channel, newAuthorizedHandlers(options.Authorize), sftp.WithStartDirectory(wd))
+		defer requestServer.Close()
+		if err := requestServer.Serve(); err != nil && err != io.EOF {
+			return err
+		}
+		return nil
+	}
+	// https://github.com/pkg/sftp/blob/42e9800606febe03f9cdf1d1283719af4a5e6456/examples/go-sftp-server/main.go#L111
+	serverOptions := []sftp.ServerOption{
+		sftp.WithDebug(os.Stderr),
+	}
+	if options.WorkingDirectory != "" {
+		serverOptions = append(serverOptions, sftp.WithServerWorkingDirectory(options.WorkingDirectory))
+	}
+	sftpServer, err := sftp.NewServer(channel, serverOptions...)
+	if err != nil {
+		return err
+	}
+	defer sftpServer.Close()
+	if err := sftpServer.Serve(); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// envHelper is the working directory for the helper started by Command
+const envHelper = "GO_SSHD_SFTP"
+
+// Command returns a command serving SFTP on its standard input and output by starting the executable as a helper,
+// so that SFTP can run as another user by SysProcAttr. The helper must call RunHelper first in main.
+// Options.Authorize is not supported.
+func Command(options Options) (*exec.Cmd, error) {
+	if options.Authorize != nil {
+		return nil, fmt.Errorf("authorization is not supported by the helper")
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), envHelper+"="+options.WorkingDirectory)
+	return cmd, nil
+}
+
+// RunHelper serves SFTP on the standard input and output and exits if this process was started by Command, and does nothing otherwise.
+func RunHelper() {
+	wd, ok := os.LookupEnv(envHelper)
+	if !ok {
+		return
+	}
+	os.Unsetenv(envHelper)
+	if err := Serve(stdio{}, Options{WorkingDirectory: wd}); err != nil {
+		fmt.Fprintf(os.Stderr, "sftp: %v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// stdio is the standard input and output
+type stdio struct{}
+
+func (stdio) Read(p []byte) (int, error)  { return os.Stdin.Read(p) }
+func (stdio) Write(p []byte) (int, error) { return os.Stdout.Write(p) }
+func (stdio) Close() error                { return os.Stdout.Close() }
@@ -0,0 +1,46 @@
+package sftpd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+func TestCommand(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a.txt"), []byte("hello"), 0600))
+	cmd, err := Command(Options{WorkingDirectory: dir})
+	require.NoError(t, err)
+	stdin, err := cmd.StdinPipe()
+	require.NoError(t, err)
+	stdout, err := cmd.StdoutPipe()
+	require.NoError(t, err)
+	require.NoError(t, cmd.Start())
+	client, err := sftp.NewClientPipe(stdout, stdin)
+	require.NoError(t, err)
+	wd, err := client.Getwd()
+	assert.NoError(t, err)
+	assert.Equal(t, dir, wd)
+	f, err := client.Open("a.txt")
+	if assert.NoError(t, err) {
+		b := make([]byte, 5)
+		_, err = f.Read(b)
+		assert.NoError(t, err)
+		assert.Equal(t, "hello", string(b))
+		f.Close()
+	}
+	client.Close()
+	assert.NoError(t, cmd.Wait())
+
+	_, err = Command(Options{Authorize: func(req *Request) bool { return true }})
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"unicode/utf16"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Shells running the command lines of "exec" requests on Windows
+const (
+	WindowsExecCmd        = "cmd"
+	WindowsExecPowerShell = "powershell"
+)
+
+// passwdFile is the file of login shells on Unix
+var passwdFile = "/etc/passwd"
+
+// defaultShell returns shell if not empty, or $SHELL, or the default shell of the platform
+func defaultShell(shell string) string {
+	if shell == "" {
+		shell = os.Getenv("SHELL")
+	}
+	if shell == "" {
+		shell = platformShell()
+	}
+	return shell
+}
+
+// connShell returns the shell of the user by ExtensionShell or the login shell with LoginShells, or empty for the shell of the server.
+// Users not found in the passwd file get the shell of the server.
+func (s *Server) connShell(sshConn *ssh.ServerConn) string {
+	if shell := extension(sshConn, ExtensionShell); shell != "" || !s.LoginShells || sshConn == nil {
+		return shell
+	}
+	shell, _ := loginShell(sshConn.User())
+	return shell
+}
+
+// rawExecCommandLines reports whether the command lines of "exec" requests run as is by a shell, as on Windows
+var rawExecCommandLines = runtime.GOOS == "windows"
+
+// windowsExecMetacharacters are the characters with which cmd or PowerShell can run other commands than the one parsed by shellwords,
+// e.g. after "&" or in "$(...)", since the shells get the command line of "exec" requests as is
+const windowsExecMetacharacters = "&|;<>^%$`(){}@\r\n"
+
+// windowsExecCommandLine returns the Windows command line of program running rawCommand as is by shell.
+// cmd gets it after /s /c in quotes, which it strips, and PowerShell gets it encoded not to be split into arguments.
+func windowsExecCommandLine(shell, program, rawCommand string) (string, error) {
+	switch shell {
+	case "", WindowsExecCmd:
+		return fmt.Sprintf(`"%s" /d /s /c "%s"`, program, rawCommand), nil
+	case WindowsExecPowerShell:
+		encoded := utf16.Encode([]rune(rawCommand))
+		b := make([]byte, 2*len(encoded))
+		for i, c := range encoded {
+			binary.LittleEndian.PutUint16(b[2*i:], c)
+		}
+		return fmt.Sprintf(`"%s" -NoLogo -EncodedCommand %s`, program, base64.StdEncoding.EncodeToString(b)), nil
+	}
+	return "", fmt.Errorf("unknown Windows exec shell: %s", shell)
+}
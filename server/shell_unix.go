@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execCommand returns the command of spec. Command of "exec" requests is the one parsed from RawCommand.
+func execCommand(spec *ProcessSpec, windowsShell string) (*exec.Cmd, error) {
+	return exec.Command(spec.Command[0], spec.Command[1:]...), nil
+}
+
+// platformShell returns the shell used without Shell and $SHELL
+func platformShell() string {
+	return "sh"
+}
+
+// loginShell returns the shell of userName in passwdFile, or empty if not found
+func loginShell(userName string) (string, error) {
+	f, err := os.Open(passwdFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == userName {
+			return fields[6], nil
+		}
+	}
+	return "", scanner.Err()
+}
@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+package server
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// platformShell returns PowerShell if installed, or the command interpreter of %ComSpec%
+func platformShell() string {
+	if path := powerShell(); path != "" {
+		return path
+	}
+	return commandInterpreter()
+}
+
+// powerShell returns the path of PowerShell 7 or Windows PowerShell, or empty if not installed
+func powerShell() string {
+	for _, name := range []string{"pwsh.exe", "powershell.exe"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// commandInterpreter returns %ComSpec% or cmd.exe
+func commandInterpreter() string {
+	if comspec := os.Getenv("ComSpec"); comspec != "" {
+		return comspec
+	}
+	return "cmd.exe"
+}
+
+// execCommand returns the command of spec. RawCommand of "exec" requests is run by windowsShell with its command line preserved,
+// since the command lines built from the arguments parsed by shellwords are quoted differently.
+func execCommand(spec *ProcessSpec, windowsShell string) (*exec.Cmd, error) {
+	if spec.RawCommand == "" {
+		return exec.Command(spec.Command[0], spec.Command[1:]...), nil
+	}
+	program := commandInterpreter()
+	if windowsShell == WindowsExecPowerShell {
+		if program = powerShell(); program == "" {
+			return nil, fmt.Errorf("PowerShell not found")
+		}
+	}
+	cmdLine, err := windowsExecCommandLine(windowsShell, program, spec.RawCommand)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(program)
+	cmd.SysProcAttr = &syscall.SysProcAttr{CmdLine: cmdLine}
+	return cmd, nil
+}
+
+func loginShell(userName string) (string, error) {
+	return "", fmt.Errorf("login shells are not supported on Windows")
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"io"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/John-Ao/go-sshd/sync_generics"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Stats is a snapshot of runtime statistics of Server.
+type Stats struct {
+	ActiveConnections int64
+	ActiveSessions    int64
+	// ActiveForwards is the number of remote forwarding listeners and local forwarding channels
+	ActiveForwards int64
+	// ActiveGoroutines and ActiveProcesses are the numbers of goroutines and child processes of connections
+	ActiveGoroutines int64
+	ActiveProcesses  int64
+	// BytesReceived is the total number of bytes received from clients through channels
+	BytesReceived uint64
+	// BytesSent is the total number of bytes sent to clients through channels
+	BytesSent    uint64
+	AuthFailures uint64
+	// ShedConnections and ShedChannels are the numbers of connections and channels shed by HandshakePool and ChannelPool
+	ShedConnections uint64
+	ShedChannels    uint64
+	// RateLimitedChannels is the number of channels rejected by ChannelRate and ForwardChannelRate
+	RateLimitedChannels uint64
+	// StalledRelays is the number of relays closed by StallTimeout
+	StalledRelays uint64
+
+	// Connections is the total number of connections served by HandleConn
+	Connections uint64
+	// Sessions is the total number of "session" channels
+	Sessions uint64
+	// AuthAttempts are the numbers of authentication attempts by method and result, not including failures of "none"
+	AuthAttempts map[AuthResult]uint64
+	// ForwardBytesReceived and ForwardBytesSent are the parts of BytesReceived and BytesSent through forwarding channels
+	ForwardBytesReceived uint64
+	ForwardBytesSent     uint64
+	// SftpOperations are the numbers of SFTP requests by operation (e.g. "open", "read", "remove")
+	SftpOperations map[string]uint64
+	// HandshakeDurations are the durations of successful handshakes by ServeConn including authentication
+	HandshakeDurations Histogram
+	// UserTraffic is the traffic through channels by user of connections served by HandleConn
+	UserTraffic map[string]Traffic
+}
+
+// Traffic is the number of bytes through channels of sessions including SFTP and forwarding.
+type Traffic struct {
+	BytesReceived uint64
+	BytesSent     uint64
+	// ForwardBytesReceived and ForwardBytesSent are the parts of BytesReceived and BytesSent through forwarding channels
+	ForwardBytesReceived uint64
+	ForwardBytesSent     uint64
+}
+
+// Add returns the sum of t and other.
+func (t Traffic) Add(other Traffic) Traffic {
+	t.BytesReceived += other.BytesReceived
+	t.BytesSent += other.BytesSent
+	t.ForwardBytesReceived += other.ForwardBytesReceived
+	t.ForwardBytesSent += other.ForwardBytesSent
+	return t
+}
+
+// Sub returns t minus other, e.g. the traffic since other was taken.
+func (t Traffic) Sub(other Traffic) Traffic {
+	t.BytesReceived -= other.BytesReceived
+	t.BytesSent -= other.BytesSent
+	t.ForwardBytesReceived -= other.ForwardBytesReceived
+	t.ForwardBytesSent -= other.ForwardBytesSent
+	return t
+}
+
+// AuthResult is a key of Stats.AuthAttempts.
+type AuthResult struct {
+	Method  string
+	Success bool
+}
+
+// handshakeBuckets are the upper bounds of the buckets of Stats.HandshakeDurations
+var handshakeBuckets = [...]time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Histogram is a snapshot of observed durations.
+type Histogram struct {
+	// Buckets are the upper bounds of the buckets
+	Buckets []time.Duration
+	// Counts are the numbers of observations less than or equal to each bucket
+	Counts []uint64
+	Count  uint64
+	Sum    time.Duration
+}
+
+// Add returns the sum of s and other, e.g. to keep the totals of servers replaced by reloads.
+func (s Stats) Add(other Stats) Stats {
+	s.ActiveConnections += other.ActiveConnections
+	s.ActiveSessions += other.ActiveSessions
+	s.ActiveForwards += other.ActiveForwards
+	s.ActiveGoroutines += other.ActiveGoroutines
+	s.ActiveProcesses += other.ActiveProcesses
+	s.BytesReceived += other.BytesReceived
+	s.BytesSent += other.BytesSent
+	s.AuthFailures += other.AuthFailures
+	s.ShedConnections += other.ShedConnections
+	s.ShedChannels += other.ShedChannels
+	s.RateLimitedChannels += other.RateLimitedChannels
+	s.StalledRelays += other.StalledRelays
+	s.Connections += other.Connections
+	s.Sessions += other.Sessions
+	s.AuthAttempts = addCounts(s.AuthAttempts, other.AuthAttempts)
+	s.ForwardBytesReceived += other.ForwardBytesReceived
+	s.ForwardBytesSent += other.ForwardBytesSent
+	s.SftpOperations = addCounts(s.SftpOperations, other.SftpOperations)
+	s.HandshakeDurations = s.HandshakeDurations.add(other.HandshakeDurations)
+	s.UserTraffic = AddTraffic(s.UserTraffic, other.UserTraffic)
+	return s
+}
+
+// AddTraffic returns the sum of traffic by key of a and b.
+func AddTraffic[K comparable](a, b map[K]Traffic) map[K]Traffic {
+	sum := map[K]Traffic{}
+	for k, v := range a {
+		sum[k] = sum[k].Add(v)
+	}
+	for k, v := range b {
+		sum[k] = sum[k].Add(v)
+	}
+	return sum
+}
+
+func addCounts[K comparable](a, b map[K]uint64) map[K]uint64 {
+	sum := map[K]uint64{}
+	for k, v := range a {
+		sum[k] += v
+	}
+	for k, v := range b {
+		sum[k] += v
+	}
+	return sum
+}
+
+func (h Histogram) add(other Histogram) Histogram {
+	if len(h.Buckets) == 0 {
+		h.Buckets = other.Buckets
+		h.Counts = make([]uint64, len(other.Counts))
+	}
+	counts := make([]uint64, len(h.Counts))
+	for i := range counts {
+		counts[i] = h.Counts[i]
+		if i < len(other.Counts) {
+			counts[i] += other.Counts[i]
+		}
+	}
+	h.Counts = counts
+	h.Count += other.Count
+	h.Sum += other.Sum
+	return h
+}
+
+// ConnectionInfo describes an active connection.
+type ConnectionInfo struct {
+	ID         string
+	User       string
+	RemoteAddr net.Addr
+	// Channels is the number of active channels
+	Channels int64
+	// Goroutines and Processes are the numbers of goroutines and child processes serving the connection
+	Goroutines int64
+	Processes  int64
+	StartTime  time.Time
+	Sessions   []SessionInfo
+	Forwards   []ForwardInfo
+}
+
+type serverStats struct {
+	activeConnections    atomic.Int64
+	activeSessions       atomic.Int64
+	activeForwards       atomic.Int64
+	activeGoroutines     atomic.Int64
+	activeProcesses      atomic.Int64
+	bytesReceived        atomic.Uint64
+	bytesSent            atomic.Uint64
+	authFailures         atomic.Uint64
+	shedConnections      atomic.Uint64
+	shedChannels         atomic.Uint64
+	rateLimitedChannels  atomic.Uint64
+	stalledRelays        atomic.Uint64
+	connections          atomic.Uint64
+	sessions             atomic.Uint64
+	authAttempts         sync_generics.Map[AuthResult, *atomic.Uint64]
+	forwardBytesReceived atomic.Uint64
+	forwardBytesSent     atomic.Uint64
+	sftpOperations       sync_generics.Map[string, *atomic.Uint64]
+	handshakeDurations   handshakeHistogram
+	userTraffic          sync_generics.Map[string, *trafficCounters]
+}
+
+// trafficCounters count Traffic
+type trafficCounters struct {
+	bytesReceived        atomic.Uint64
+	bytesSent            atomic.Uint64
+	forwardBytesReceived atomic.Uint64
+	forwardBytesSent     atomic.Uint64
+}
+
+func (t *trafficCounters) snapshot() Traffic {
+	return Traffic{
+		BytesReceived:        t.bytesReceived.Load(),
+		BytesSent:            t.bytesSent.Load(),
+		ForwardBytesReceived: t.forwardBytesReceived.Load(),
+		ForwardBytesSent:     t.forwardBytesSent.Load(),
+	}
+}
+
+func loadTraffic(counters *sync_generics.Map[string, *trafficCounters]) map[string]Traffic {
+	traffic := map[string]Traffic{}
+	counters.Range(func(key string, counter *trafficCounters) bool {
+		traffic[key] = counter.snapshot()
+		return true
+	})
+	return traffic
+}
+
+// handshakeHistogram observes durations in handshakeBuckets
+type handshakeHistogram struct {
+	// counts are not cumulative unlike Histogram
+	counts [len(handshakeBuckets)]atomic.Uint64
+	count  atomic.Uint64
+	sum    atomic.Int64
+}
+
+func (h *handshakeHistogram) observe(d time.Duration) {
+	for i, bucket := range handshakeBuckets {
+		if d <= bucket {
+			h.counts[i].Add(1)
+			break
+		}
+	}
+	h.count.Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *handshakeHistogram) snapshot() Histogram {
+	snapshot := Histogram{
+		Buckets: handshakeBuckets[:],
+		Counts:  make([]uint64, len(handshakeBuckets)),
+		Count:   h.count.Load(),
+		Sum:     time.Duration(h.sum.Load()),
+	}
+	var count uint64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+		snapshot.Counts[i] = count
+	}
+	return snapshot
+}
+
+// increment adds 1 to the counter of key in counters
+func increment[K comparable](counters *sync_generics.Map[K, *atomic.Uint64], key K) {
+	counter, _ := counters.LoadOrStore(key, new(atomic.Uint64))
+	counter.Add(1)
+}
+
+func loadCounts[K comparable](counters *sync_generics.Map[K, *atomic.Uint64]) map[K]uint64 {
+	counts := map[K]uint64{}
+	counters.Range(func(key K, counter *atomic.Uint64) bool {
+		counts[key] = counter.Load()
+		return true
+	})
+	return counts
+}
+
+// Stats returns the current statistics.
+func (s *Server) Stats() Stats {
+	return Stats{
+		ActiveConnections:    s.stats.activeConnections.Load(),
+		ActiveSessions:       s.stats.activeSessions.Load(),
+		ActiveForwards:       s.stats.activeForwards.Load(),
+		ActiveGoroutines:     s.stats.activeGoroutines.Load(),
+		ActiveProcesses:      s.stats.activeProcesses.Load(),
+		BytesReceived:        s.stats.bytesReceived.Load(),
+		BytesSent:            s.stats.bytesSent.Load(),
+		AuthFailures:         s.stats.authFailures.Load(),
+		ShedConnections:      s.stats.shedConnections.Load(),
+		ShedChannels:         s.stats.shedChannels.Load(),
+		RateLimitedChannels:  s.stats.rateLimitedChannels.Load(),
+		StalledRelays:        s.stats.stalledRelays.Load(),
+		Connections:          s.stats.connections.Load(),
+		Sessions:             s.stats.sessions.Load(),
+		AuthAttempts:         loadCounts(&s.stats.authAttempts),
+		ForwardBytesReceived: s.stats.forwardBytesReceived.Load(),
+		ForwardBytesSent:     s.stats.forwardBytesSent.Load(),
+		SftpOperations:       loadCounts(&s.stats.sftpOperations),
+		HandshakeDurations:   s.stats.handshakeDurations.snapshot(),
+		UserTraffic:          loadTraffic(&s.stats.userTraffic),
+	}
+}
+
+// Connections returns the active connections served by HandleConn in order of start time.
+func (s *Server) Connections() []ConnectionInfo {
+	var infos []ConnectionInfo
+	s.connections.Range(func(_ string, conn *connection) bool {
+		sessions, forwards := conn.activities()
+		infos = append(infos, ConnectionInfo{
+			ID:         conn.id,
+			User:       conn.sshConn.User(),
+			RemoteAddr: conn.sshConn.RemoteAddr(),
+			Channels:   conn.activeChannels.Load(),
+			Goroutines: conn.lifecycle.goroutines.Load(),
+			Processes:  conn.lifecycle.processes.Load(),
+			StartTime:  conn.startTime,
+			Sessions:   sessions,
+			Forwards:   forwards,
+		})
+		return true
+	})
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].StartTime.Before(infos[j].StartTime)
+	})
+	return infos
+}
+
+// AuthLog counts authentication attempts and publishes EventAuth. Set it to ssh.ServerConfig.AuthLogCallback.
+func (s *Server) AuthLog(conn ssh.ConnMetadata, method string, err error) {
+	// "none" is tried first by most clients to get available methods
+	if method == "none" && err != nil {
+		return
+	}
+	location := s.location(conn.RemoteAddr())
+	event := Event{Type: EventAuth, User: conn.User(), RemoteAddr: conn.RemoteAddr().String(), Country: location.Country, ASN: location.ASN, Method: method}
+	increment(&s.stats.authAttempts, AuthResult{Method: method, Success: err == nil})
+	if err != nil {
+		s.stats.authFailures.Add(1)
+		event.Err = err.Error()
+	}
+	s.publish(nil, event)
+}
+
+// isForwardChannelType reports whether channels of channelType are opened by clients for local forwarding
+func isForwardChannelType(channelType string) bool {
+	return channelType == "direct-tcpip" || channelType == "direct-streamlocal@openssh.com"
+}
+
+// countingNewChannel counts bytes of the channel after accepted.
+type countingNewChannel struct {
+	ssh.NewChannel
+	stats *serverStats
+	// user counts the bytes also as the traffic of the user if not nil
+	user *trafficCounters
+	// limit counts the bytes also for the transfer limit of the connection if not nil
+	limit *transferLimit
+	// forward counts the bytes also as forwarded ones
+	forward bool
+}
+
+func (c *countingNewChannel) Accept() (ssh.Channel, <-chan *ssh.Request, error) {
+	channel, reqs, err := c.NewChannel.Accept()
+	if err != nil {
+		return nil, nil, err
+	}
+	return &countingChannel{Channel: channel, stats: c.stats, user: c.user, limit: c.limit, forward: c.forward}, reqs, nil
+}
+
+// countingChannel counts bytes read from and written to the channel including extended data.
+type countingChannel struct {
+	ssh.Channel
+	stats *serverStats
+	// user counts the bytes also as the traffic of the user if not nil
+	user *trafficCounters
+	// limit counts the bytes also for the transfer limit of the connection if not nil
+	limit *transferLimit
+	// forward counts the bytes also as forwarded ones
+	forward bool
+}
+
+func (c *countingChannel) Read(p []byte) (int, error) {
+	n, err := c.Channel.Read(p)
+	c.stats.received(n, c.forward, c.user)
+	c.limit.add(n)
+	return n, err
+}
+
+func (c *countingChannel) Write(p []byte) (int, error) {
+	n, err := c.Channel.Write(p)
+	c.stats.sent(n, c.forward, c.user)
+	c.limit.add(n)
+	return n, err
+}
+
+func (c *countingChannel) Stderr() io.ReadWriter {
+	return &countingReadWriter{ReadWriter: c.Channel.Stderr(), stats: c.stats, user: c.user, limit: c.limit, forward: c.forward}
+}
+
+type countingReadWriter struct {
+	io.ReadWriter
+	stats   *serverStats
+	user    *trafficCounters
+	limit   *transferLimit
+	forward bool
+}
+
+func (c *countingReadWriter) Read(p []byte) (int, error) {
+	n, err := c.ReadWriter.Read(p)
+	c.stats.received(n, c.forward, c.user)
+	c.limit.add(n)
+	return n, err
+}
+
+func (c *countingReadWriter) Write(p []byte) (int, error) {
+	n, err := c.ReadWriter.Write(p)
+	c.stats.sent(n, c.forward, c.user)
+	c.limit.add(n)
+	return n, err
+}
+
+func (s *serverStats) received(n int, forward bool, user *trafficCounters) {
+	s.bytesReceived.Add(uint64(n))
+	if forward {
+		s.forwardBytesReceived.Add(uint64(n))
+	}
+	if user != nil {
+		user.bytesReceived.Add(uint64(n))
+		if forward {
+			user.forwardBytesReceived.Add(uint64(n))
+		}
+	}
+}
+
+func (s *serverStats) sent(n int, forward bool, user *trafficCounters) {
+	s.bytesSent.Add(uint64(n))
+	if forward {
+		s.forwardBytesSent.Add(uint64(n))
+	}
+	if user != nil {
+		user.bytesSent.Add(uint64(n))
+		if forward {
+			user.forwardBytesSent.Add(uint64(n))
+		}
+	}
+}
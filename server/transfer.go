@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// transferNoticeTimeout is how long the notice of an exceeded transfer limit can take to be written to the sessions before closing the connection
+const transferNoticeTimeout = time.Second
+
+// transferLimit calls exceeded once when the bytes through the channels of a connection exceed max
+type transferLimit struct {
+	max      uint64
+	bytes    atomic.Uint64
+	once     sync.Once
+	exceeded func()
+}
+
+// add counts n bytes. A nil limit counts nothing.
+func (l *transferLimit) add(n int) {
+	if l == nil || n == 0 {
+		return
+	}
+	if l.bytes.Add(uint64(n)) > l.max {
+		l.once.Do(l.exceeded)
+	}
+}
+
+// connMaxTransfer returns the maximum number of bytes through the channels of the connection from its extension or MaxTransfer. An invalid extension is ignored.
+func (s *Server) connMaxTransfer(sshConn *ssh.ServerConn) uint64 {
+	if n, err := strconv.ParseUint(extension(sshConn, ExtensionMaxTransfer), 10, 64); err == nil {
+		return n
+	}
+	return s.MaxTransfer
+}
+
+// newTransferLimit returns the transfer limit of conn closing it when exceeded, or nil without limits
+func (s *Server) newTransferLimit(conn *connection) *transferLimit {
+	if conn.sshConn == nil {
+		return nil
+	}
+	max := s.connMaxTransfer(conn.sshConn)
+	if max == 0 {
+		return nil
+	}
+	return &transferLimit{max: max, exceeded: func() {
+		// Not to block the reader or the writer counting the bytes
+		conn.lifecycle.Go(func() { s.closeOverTransfer(conn, max) })
+	}}
+}
+
+// closeOverTransfer notifies the sessions of conn that it transferred over max bytes and closes it
+func (s *Server) closeOverTransfer(conn *connection, max uint64) {
+	conn.logger.Warn("closing connection over transfer limit", "max_transfer", max)
+	notice := fmt.Sprintf("\r\n[go-sshd: transfer limit of %d bytes exceeded, closing connection]\r\n", max)
+	var wg sync.WaitGroup
+	conn.sessions.Range(func(_ string, sess *activeSession) bool {
+		wg.Add(1)
+		conn.lifecycle.Go(func() {
+			defer wg.Done()
+			io.WriteString(sess.stderr, notice)
+		})
+		return true
+	})
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(transferNoticeTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+	conn.sshConn.Close()
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// wallMessage formats message for terminals like wall(1). Control characters other than tabs and line breaks are removed
+// not to be interpreted by terminals, and line breaks get carriage returns.
+func wallMessage(message string, now time.Time) string {
+	message = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, strings.TrimRight(message, "\r\n"))
+	message = strings.ReplaceAll(message, "\n", "\r\n")
+	return fmt.Sprintf("\r\n\r\nBroadcast message from go-sshd (%s):\r\n\r\n%s\r\n", now.Format(time.ANSIC), message)
+}
+
+// Wall writes message to the terminals of the active sessions with a pty like wall(1), e.g. to warn of maintenance,
+// only of users if not empty. It doesn't wait for clients to receive it, and returns the IDs of the sessions in order.
+func (s *Server) Wall(message string, users []string) []string {
+	text := wallMessage(message, time.Now())
+	ids := []string{}
+	s.connections.Range(func(_ string, conn *connection) bool {
+		if len(users) != 0 && !slices.Contains(users, conn.metadata.User()) {
+			return true
+		}
+		conn.sessions.Range(func(id string, sess *activeSession) bool {
+			if !sess.snapshot().Pty {
+				return true
+			}
+			conn.logger.Info("broadcasting message to session by request", "session_id", id)
+			ids = append(ids, id)
+			// Clients not reading don't block the others
+			conn.lifecycle.Go(func() {
+				io.WriteString(sess.stderr, text)
+			})
+			return true
+		})
+		return true
+	})
+	sort.Strings(ids)
+	return ids
+}
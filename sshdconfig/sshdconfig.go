@@ -0,0 +1,633 @@
+// Package sshdconfig parses a subset of OpenSSH sshd_config.
+//
+// Supported directives are Port, ListenAddress, HostKey, AuthorizedKeysFile, Subsystem, Ciphers, MACs, KexAlgorithms, RequiredRSASize, RekeyLimit, Compression,
+// AllowUsers, DenyUsers, AllowGroups, DenyGroups,
+// PermitTTY, AllowTcpForwarding, AllowStreamLocalForwarding, AllowAgentForwarding, X11Forwarding, ForceCommand, ChrootDirectory, MaxSessions
+// and Match with User, Group, Address and All criteria.
+// Include reads files and directories like Include of OpenSSH, so fragments such as sshd_config.d/*.conf can be managed separately.
+// PermitTTY, AllowTcpForwarding, AllowStreamLocalForwarding, AllowAgentForwarding, X11Forwarding, ForceCommand, ChrootDirectory and MaxSessions can be used in Match blocks.
+// Other directives are ignored and reported in Config.Unsupported.
+package sshdconfig
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Config is a parsed sshd_config.
+type Config struct {
+	Ports []uint16
+	// ListenAddresses are "host" or "host:port"
+	ListenAddresses     []string
+	HostKeys            []string
+	AuthorizedKeysFiles []string
+	// Subsystems are commands by subsystem names
+	Subsystems map[string]string
+	// Ciphers, MACs and KexAlgorithms are comma-separated lists, which can start with "+", "-" or "^"
+	Ciphers       string
+	MACs          string
+	KexAlgorithms string
+	// RequiredRSASize is the minimum size of RSA keys, 0 if not set
+	RequiredRSASize int
+	// RekeyLimit is the amount of data like "1G" or "default", and RekeyLimitTime is the time like "1h" or "none"
+	RekeyLimit     string
+	RekeyLimitTime string
+	// Compression is "yes", "delayed" or "no", empty if not set
+	Compression string
+	// AllowUsers, DenyUsers, AllowGroups and DenyGroups are the patterns of all lines
+	AllowUsers  []string
+	DenyUsers   []string
+	AllowGroups []string
+	DenyGroups  []string
+	// Global is the settings outside Match blocks
+	Global  Settings
+	Matches []Match
+	// Unsupported are the ignored directives with their line numbers
+	Unsupported []string
+
+	// Groups returns the group names of user for Group criteria. The groups of the OS user are used if nil.
+	Groups func(user string) ([]string, error)
+}
+
+// Settings are directives which Match blocks can override. Empty fields are not set.
+type Settings struct {
+	// PermitTTY is "yes" or "no"
+	PermitTTY string
+	// AllowTcpForwarding is "yes", "all", "no", "local" or "remote"
+	AllowTcpForwarding string
+	// AllowStreamLocalForwarding is "yes", "all", "no", "local" or "remote"
+	AllowStreamLocalForwarding string
+	// AllowAgentForwarding is "yes" or "no"
+	AllowAgentForwarding string
+	// X11Forwarding is "yes" or "no"
+	X11Forwarding string
+	// ForceCommand is the command line run instead of the requested ones, "internal-sftp" or "none"
+	ForceCommand string
+	// ChrootDirectory is the directory with %u and %h, or "none"
+	ChrootDirectory string
+	// MaxSessions is the maximum number of concurrent sessions of each connection in decimal
+	MaxSessions string
+}
+
+// Match is a Match block.
+type Match struct {
+	Criteria []Criterion
+	Settings Settings
+}
+
+// Criterion is a criterion of Match, e.g. "User alice,bob".
+type Criterion struct {
+	// Keyword is "user", "group", "address" or "all"
+	Keyword string
+	// Patterns is a comma-separated pattern list. "!" negates a pattern.
+	Patterns string
+}
+
+// maxIncludeDepth is the maximum depth of nested Include like OpenSSH
+const maxIncludeDepth = 16
+
+// ParseFile parses the sshd_config file. Relative paths of Include are relative to the directory of the file.
+func ParseFile(path string) (*Config, error) {
+	config := &Config{Subsystems: map[string]string{}}
+	match := -1
+	if err := config.parseFile(path, "", &match, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Parse parses sshd_config. Relative paths of Include are relative to the working directory.
+func Parse(r io.Reader) (*Config, error) {
+	config := &Config{Subsystems: map[string]string{}}
+	match := -1
+	if err := config.parse(r, ".", "", &match, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// parseFile parses the file at path included at depth. name prefixes Unsupported, which is empty for the top file.
+func (c *Config) parseFile(path, name string, match *int, depth int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := c.parse(f, filepath.Dir(path), name, match, depth); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// parse parses r with Include relative to dir. *match is the index of the current Match block, or -1 for Global.
+func (c *Config) parse(r io.Reader, dir, name string, match *int, depth int) error {
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		keyword, args, err := splitLine(scanner.Text())
+		if err != nil {
+			return fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if keyword == "" {
+			continue
+		}
+		if strings.EqualFold(keyword, "include") {
+			if err := c.include(dir, args, *match, depth); err != nil {
+				return fmt.Errorf("line %d: %s: %w", lineNumber, keyword, err)
+			}
+			continue
+		}
+		supported, err := c.parseDirective(match, keyword, args)
+		if err != nil {
+			return fmt.Errorf("line %d: %s: %w", lineNumber, keyword, err)
+		}
+		if !supported {
+			unsupported := fmt.Sprintf("line %d: %s", lineNumber, keyword)
+			if name != "" {
+				unsupported = name + ": " + unsupported
+			}
+			c.Unsupported = append(c.Unsupported, unsupported)
+		}
+	}
+	return scanner.Err()
+}
+
+// include parses the files of patterns relative to dir in the order of their names. A directory includes the files in it.
+// Patterns matching nothing are ignored like OpenSSH. Match blocks in the files end with them, and Include in a Match block applies the files to it.
+func (c *Config) include(dir string, patterns []string, match int, depth int) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("argument required")
+	}
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("too deeply nested")
+	}
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		// Sorted by Glob
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %s", pattern)
+		}
+		for _, path := range paths {
+			files, err := includedFiles(path)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				current := match
+				if err := c.parseFile(file, file, &current, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// includedFiles returns path, or the files in path in the order of their names if it is a directory. Hidden files are skipped.
+func includedFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return []string{path}, err
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// parseDirective applies the directive to c or the settings of the Match block at *match, or Global if it is -1, which is switched by Match.
+// It returns false for unsupported directives.
+func (c *Config) parseDirective(match *int, keyword string, args []string) (bool, error) {
+	current := &c.Global
+	if *match >= 0 {
+		current = &c.Matches[*match].Settings
+	}
+	switch strings.ToLower(keyword) {
+	case "match":
+		m, err := parseMatch(args)
+		if err != nil {
+			return true, err
+		}
+		c.Matches = append(c.Matches, m)
+		*match = len(c.Matches) - 1
+		return true, nil
+	case "permittty":
+		return true, setOnce(&current.PermitTTY, args, "yes", "no")
+	case "allowtcpforwarding":
+		return true, setOnce(&current.AllowTcpForwarding, args, "yes", "all", "no", "local", "remote")
+	case "allowstreamlocalforwarding":
+		return true, setOnce(&current.AllowStreamLocalForwarding, args, "yes", "all", "no", "local", "remote")
+	case "allowagentforwarding":
+		return true, setOnce(&current.AllowAgentForwarding, args, "yes", "no")
+	case "x11forwarding":
+		return true, setOnce(&current.X11Forwarding, args, "yes", "no")
+	case "forcecommand":
+		if len(args) == 0 {
+			return true, fmt.Errorf("argument required")
+		}
+		if current.ForceCommand == "" {
+			current.ForceCommand = strings.Join(args, " ")
+		}
+		return true, nil
+	case "chrootdirectory":
+		if len(args) != 1 {
+			return true, fmt.Errorf("one argument required")
+		}
+		if current.ChrootDirectory == "" {
+			current.ChrootDirectory = args[0]
+		}
+		return true, nil
+	case "maxsessions":
+		if len(args) != 1 {
+			return true, fmt.Errorf("one argument required")
+		}
+		// 0 disabling sessions in OpenSSH is not supported
+		if n, err := strconv.ParseUint(args[0], 10, 31); err != nil || n == 0 {
+			return true, fmt.Errorf("invalid number: %s", args[0])
+		}
+		if current.MaxSessions == "" {
+			current.MaxSessions = args[0]
+		}
+		return true, nil
+	}
+	switch strings.ToLower(keyword) {
+	case "port", "listenaddress", "hostkey", "authorizedkeysfile", "subsystem", "ciphers", "macs", "kexalgorithms", "requiredrsasize", "rekeylimit", "compression", "allowusers", "denyusers", "allowgroups", "denygroups":
+		if *match >= 0 {
+			return true, fmt.Errorf("not allowed in Match")
+		}
+	default:
+		return false, nil
+	}
+	switch strings.ToLower(keyword) {
+	case "port":
+		if len(args) != 1 {
+			return true, fmt.Errorf("one argument required")
+		}
+		port, err := strconv.ParseUint(args[0], 10, 16)
+		if err != nil {
+			return true, fmt.Errorf("invalid port: %s", args[0])
+		}
+		c.Ports = append(c.Ports, uint16(port))
+	case "listenaddress":
+		if len(args) == 0 {
+			return true, fmt.Errorf("argument required")
+		}
+		// "rdomain" is not supported
+		c.ListenAddresses = append(c.ListenAddresses, args[0])
+	case "hostkey":
+		if len(args) != 1 {
+			return true, fmt.Errorf("one argument required")
+		}
+		c.HostKeys = append(c.HostKeys, args[0])
+	case "authorizedkeysfile":
+		if len(args) == 0 {
+			return true, fmt.Errorf("argument required")
+		}
+		if c.AuthorizedKeysFiles == nil {
+			c.AuthorizedKeysFiles = args
+		}
+	case "subsystem":
+		if len(args) < 2 {
+			return true, fmt.Errorf("name and command required")
+		}
+		if _, ok := c.Subsystems[args[0]]; ok {
+			return true, fmt.Errorf("duplicate subsystem: %s", args[0])
+		}
+		c.Subsystems[args[0]] = strings.Join(args[1:], " ")
+	case "ciphers":
+		return true, setListOnce(&c.Ciphers, args)
+	case "macs":
+		return true, setListOnce(&c.MACs, args)
+	case "kexalgorithms":
+		return true, setListOnce(&c.KexAlgorithms, args)
+	case "requiredrsasize":
+		if len(args) != 1 {
+			return true, fmt.Errorf("one argument required")
+		}
+		size, err := strconv.ParseUint(args[0], 10, 16)
+		if err != nil {
+			return true, fmt.Errorf("invalid size: %s", args[0])
+		}
+		if c.RequiredRSASize == 0 {
+			c.RequiredRSASize = int(size)
+		}
+	case "rekeylimit":
+		if len(args) != 1 && len(args) != 2 {
+			return true, fmt.Errorf("one or two arguments required")
+		}
+		if c.RekeyLimit == "" {
+			c.RekeyLimit = args[0]
+			if len(args) == 2 {
+				c.RekeyLimitTime = args[1]
+			}
+		}
+	case "compression":
+		return true, setOnce(&c.Compression, args, "yes", "delayed", "no")
+	case "allowusers", "denyusers", "allowgroups", "denygroups":
+		if len(args) == 0 {
+			return true, fmt.Errorf("argument required")
+		}
+		lists := map[string]*[]string{"allowusers": &c.AllowUsers, "denyusers": &c.DenyUsers, "allowgroups": &c.AllowGroups, "denygroups": &c.DenyGroups}
+		list := lists[strings.ToLower(keyword)]
+		*list = append(*list, args...)
+	}
+	return true, nil
+}
+
+// setOnce sets the first value of the keyword like OpenSSH
+func setOnce(field *string, args []string, values ...string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("one argument required")
+	}
+	value := strings.ToLower(args[0])
+	for _, v := range values {
+		if value == v {
+			if *field == "" {
+				*field = value
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid value: %s", args[0])
+}
+
+// setListOnce sets the first algorithm list like OpenSSH
+func setListOnce(field *string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("one argument required")
+	}
+	if *field == "" {
+		*field = args[0]
+	}
+	return nil
+}
+
+func parseMatch(args []string) (Match, error) {
+	var match Match
+	for i := 0; i < len(args); i++ {
+		keyword := strings.ToLower(args[i])
+		switch keyword {
+		case "all":
+			if len(args) != 1 {
+				return Match{}, fmt.Errorf("All can not be combined")
+			}
+			match.Criteria = append(match.Criteria, Criterion{Keyword: keyword})
+		case "user", "group", "address":
+			if i+1 >= len(args) {
+				return Match{}, fmt.Errorf("patterns required for %s", args[i])
+			}
+			match.Criteria = append(match.Criteria, Criterion{Keyword: keyword, Patterns: args[i+1]})
+			i++
+		default:
+			return Match{}, fmt.Errorf("unsupported criterion: %s", args[i])
+		}
+	}
+	if len(match.Criteria) == 0 {
+		return Match{}, fmt.Errorf("criteria required")
+	}
+	return match, nil
+}
+
+// splitLine splits a line into the keyword and arguments. Arguments can be quoted with double quotes.
+func splitLine(line string) (string, []string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil, nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i < 0 {
+		return line, nil, nil
+	}
+	// "Keyword=value" is allowed
+	keyword := line[:i]
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (r == ' ' || r == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		case !quoted && r == '#' && !inArg:
+			// Trailing comment
+			return keyword, args, nil
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return "", nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return keyword, args, nil
+}
+
+// ConnSettings returns the settings for the connection of user from addr with Match blocks applied.
+// Unset settings are the defaults of OpenSSH.
+// The groups of user are looked up once for Group criteria, which don't match if the lookup fails.
+func (c *Config) ConnSettings(user string, addr net.Addr) Settings {
+	var groups []string
+	looked := false
+	lookupGroups := func() []string {
+		if !looked {
+			looked = true
+			groupsFunc := c.Groups
+			if groupsFunc == nil {
+				groupsFunc = osGroups
+			}
+			groups, _ = groupsFunc(user)
+		}
+		return groups
+	}
+	var settings Settings
+	for _, match := range c.Matches {
+		if match.matches(user, addr, lookupGroups) {
+			settings = settings.or(match.Settings)
+		}
+	}
+	return settings.or(c.Global).WithDefaults()
+}
+
+// WithDefaults returns s with unset fields filled with the defaults of OpenSSH, "yes" except X11Forwarding.
+// ForceCommand, ChrootDirectory and MaxSessions are left unset.
+func (s Settings) WithDefaults() Settings {
+	return s.or(Settings{
+		PermitTTY:                  "yes",
+		AllowTcpForwarding:         "yes",
+		AllowStreamLocalForwarding: "yes",
+		AllowAgentForwarding:       "yes",
+		X11Forwarding:              "no",
+	})
+}
+
+// or fills unset fields of s with the ones of other
+func (s Settings) or(other Settings) Settings {
+	if s.PermitTTY == "" {
+		s.PermitTTY = other.PermitTTY
+	}
+	if s.AllowTcpForwarding == "" {
+		s.AllowTcpForwarding = other.AllowTcpForwarding
+	}
+	if s.AllowStreamLocalForwarding == "" {
+		s.AllowStreamLocalForwarding = other.AllowStreamLocalForwarding
+	}
+	if s.AllowAgentForwarding == "" {
+		s.AllowAgentForwarding = other.AllowAgentForwarding
+	}
+	if s.X11Forwarding == "" {
+		s.X11Forwarding = other.X11Forwarding
+	}
+	if s.ForceCommand == "" {
+		s.ForceCommand = other.ForceCommand
+	}
+	if s.ChrootDirectory == "" {
+		s.ChrootDirectory = other.ChrootDirectory
+	}
+	if s.MaxSessions == "" {
+		s.MaxSessions = other.MaxSessions
+	}
+	return s
+}
+
+// matches reports whether the connection of user from addr matches all criteria. groups returns the groups of user.
+func (m *Match) matches(user string, addr net.Addr, groups func() []string) bool {
+	for _, criterion := range m.Criteria {
+		switch criterion.Keyword {
+		case "all":
+		case "user":
+			if !matchPatternList(criterion.Patterns, user, nil) {
+				return false
+			}
+		case "group":
+			if !matchGroups(criterion.Patterns, groups()) {
+				return false
+			}
+		case "address":
+			ip := addrIP(addr)
+			if ip == nil || !matchPatternList(criterion.Patterns, ip.String(), ip) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchGroups reports whether a group matches the pattern list and none matches a negated pattern like OpenSSH
+func matchGroups(list string, groups []string) bool {
+	matched := false
+	for _, group := range groups {
+		for _, pattern := range strings.Split(list, ",") {
+			if negated := strings.HasPrefix(pattern, "!"); negated && wildcard(pattern[1:], group) {
+				return false
+			}
+		}
+		matched = matched || matchPatternList(list, group, nil)
+	}
+	return matched
+}
+
+func osGroups(userName string) ([]string, error) {
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, id := range ids {
+		if g, err := user.LookupGroupId(id); err == nil {
+			groups = append(groups, g.Name)
+		}
+	}
+	return groups, nil
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+// matchPatternList reports whether s matches a pattern and no negated pattern. ip is non-nil to match CIDRs.
+func matchPatternList(list string, s string, ip net.IP) bool {
+	matched := false
+	for _, pattern := range strings.Split(list, ",") {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		var ok bool
+		if _, ipNet, err := net.ParseCIDR(pattern); ip != nil && err == nil {
+			ok = ipNet.Contains(ip)
+		} else {
+			ok = wildcard(pattern, s)
+		}
+		if ok && negated {
+			return false
+		}
+		matched = matched || ok
+	}
+	return matched
+}
+
+// wildcard matches s with pattern of "*" and "?"
+func wildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
@@ -0,0 +1,158 @@
+package sshdconfig
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `# comment
+Port 2222
+Port=2223
+ListenAddress 127.0.0.1
+HostKey /etc/ssh/ssh_host_ed25519_key
+AuthorizedKeysFile .ssh/authorized_keys "/etc/ssh/keys/%u"
+Subsystem sftp /usr/lib/openssh/sftp-server
+PermitTTY yes
+AllowTcpForwarding local # trailing comment
+AllowTcpForwarding remote
+UsePAM yes
+KexAlgorithms -diffie-hellman-group14-sha1
+KexAlgorithms curve25519-sha256
+RequiredRSASize 2048
+RekeyLimit 512M 1h
+Compression delayed
+AllowUsers john deploy@10.0.0.0/8
+AllowUsers ci-*
+DenyGroups guests
+ChrootDirectory /srv/jail/%u
+
+Match User deploy,ci-*
+	PermitTTY no
+	AllowTcpForwarding no
+	X11Forwarding yes
+Match Address 10.0.0.0/8,!10.0.0.1 User *
+	AllowStreamLocalForwarding no
+	AllowTcpForwarding all
+Match Group sftponly,!admin
+	ForceCommand internal-sftp -l INFO
+	ChrootDirectory /srv/sftp/%u
+	MaxSessions 2
+`
+
+func TestParse(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{2222, 2223}, config.Ports)
+	assert.Equal(t, []string{"127.0.0.1"}, config.ListenAddresses)
+	assert.Equal(t, []string{"/etc/ssh/ssh_host_ed25519_key"}, config.HostKeys)
+	assert.Equal(t, []string{".ssh/authorized_keys", "/etc/ssh/keys/%u"}, config.AuthorizedKeysFiles)
+	assert.Equal(t, map[string]string{"sftp": "/usr/lib/openssh/sftp-server"}, config.Subsystems)
+	assert.Equal(t, "-diffie-hellman-group14-sha1", config.KexAlgorithms)
+	assert.Equal(t, 2048, config.RequiredRSASize)
+	assert.Equal(t, "512M", config.RekeyLimit)
+	assert.Equal(t, "1h", config.RekeyLimitTime)
+	assert.Equal(t, "delayed", config.Compression)
+	assert.Equal(t, []string{"john", "deploy@10.0.0.0/8", "ci-*"}, config.AllowUsers)
+	assert.Equal(t, []string{"guests"}, config.DenyGroups)
+	// The first value is used
+	assert.Equal(t, Settings{PermitTTY: "yes", AllowTcpForwarding: "local", ChrootDirectory: "/srv/jail/%u"}, config.Global)
+	assert.Len(t, config.Matches, 3)
+	assert.Equal(t, []string{"line 11: UsePAM"}, config.Unsupported)
+	assert.Equal(t, Settings{PermitTTY: "no", AllowTcpForwarding: "no", X11Forwarding: "yes"}, config.Matches[0].Settings)
+	assert.Equal(t, []Criterion{{Keyword: "group", Patterns: "sftponly,!admin"}}, config.Matches[2].Criteria)
+	assert.Equal(t, Settings{ForceCommand: "internal-sftp -l INFO", ChrootDirectory: "/srv/sftp/%u", MaxSessions: "2"}, config.Matches[2].Settings)
+}
+
+func TestConnSettings(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	require.NoError(t, err)
+	config.Groups = func(user string) ([]string, error) {
+		return map[string][]string{"john": {"users"}, "ci-runner": {"users"}, "deploy": {"users"}}[user], nil
+	}
+	addr := func(ip string) net.Addr {
+		return &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	}
+	jail := "/srv/jail/%u"
+	assert.Equal(t, Settings{PermitTTY: "yes", AllowTcpForwarding: "local", AllowStreamLocalForwarding: "yes", AllowAgentForwarding: "yes", X11Forwarding: "no", ChrootDirectory: jail}, config.ConnSettings("john", addr("192.168.0.1")))
+	assert.Equal(t, Settings{PermitTTY: "no", AllowTcpForwarding: "no", AllowStreamLocalForwarding: "yes", AllowAgentForwarding: "yes", X11Forwarding: "yes", ChrootDirectory: jail}, config.ConnSettings("ci-runner", addr("192.168.0.1")))
+	// The first match wins
+	assert.Equal(t, Settings{PermitTTY: "no", AllowTcpForwarding: "no", AllowStreamLocalForwarding: "no", AllowAgentForwarding: "yes", X11Forwarding: "yes", ChrootDirectory: jail}, config.ConnSettings("deploy", addr("10.1.2.3")))
+	assert.Equal(t, Settings{PermitTTY: "yes", AllowTcpForwarding: "all", AllowStreamLocalForwarding: "no", AllowAgentForwarding: "yes", X11Forwarding: "no", ChrootDirectory: jail}, config.ConnSettings("john", addr("10.1.2.3")))
+	// Negated
+	assert.Equal(t, Settings{PermitTTY: "yes", AllowTcpForwarding: "local", AllowStreamLocalForwarding: "yes", AllowAgentForwarding: "yes", X11Forwarding: "no", ChrootDirectory: jail}, config.ConnSettings("john", addr("10.0.0.1")))
+}
+
+func TestConnSettingsGroup(t *testing.T) {
+	config, err := Parse(strings.NewReader(testConfig))
+	require.NoError(t, err)
+	config.Groups = func(user string) ([]string, error) {
+		return map[string][]string{"alice": {"users", "sftponly"}, "bob": {"sftponly", "admin"}}[user], nil
+	}
+	addr := &net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 50000}
+	settings := config.ConnSettings("alice", addr)
+	assert.Equal(t, "internal-sftp -l INFO", settings.ForceCommand)
+	assert.Equal(t, "/srv/sftp/%u", settings.ChrootDirectory)
+	assert.Equal(t, "2", settings.MaxSessions)
+	// A group matching a negated pattern
+	settings = config.ConnSettings("bob", addr)
+	assert.Equal(t, "", settings.ForceCommand)
+	assert.Equal(t, "/srv/jail/%u", settings.ChrootDirectory)
+	// Users without groups
+	settings = config.ConnSettings("carol", addr)
+	assert.Equal(t, "", settings.MaxSessions)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, c := range []struct {
+		config string
+		err    string
+	}{
+		{config: "Port ssh", err: "line 1: Port: invalid port: ssh"},
+		{config: "PermitTTY maybe", err: "line 1: PermitTTY: invalid value: maybe"},
+		{config: "Match User john\nPort 22", err: "line 2: Port: not allowed in Match"},
+		{config: "Match Host example.com", err: "line 1: Match: unsupported criterion: Host"},
+		{config: "Match Group admin\nMaxSessions 0", err: "line 2: MaxSessions: invalid number: 0"},
+		{config: `HostKey "/etc/key`, err: "line 1: unterminated quote"},
+	} {
+		_, err := Parse(strings.NewReader(c.config))
+		assert.EqualError(t, err, c.err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	confDir := filepath.Join(dir, "sshd_config.d")
+	require.NoError(t, os.Mkdir(confDir, 0700))
+	for name, content := range map[string]string{
+		"sshd_config": "Include sshd_config.d\nPort 2222\nMatch User deploy\n\tInclude deploy.conf\n\tX11Forwarding yes\nMatch User *\n\tInclude missing-*.conf\n",
+		"deploy.conf": "PermitTTY no\n",
+		// Applied in the order of the names
+		"sshd_config.d/20-team.conf": "Port 2200\nMatch User team-*\n\tPermitTTY no\n",
+		"sshd_config.d/10-host.conf": "Port 2201\nUsePAM yes\n",
+		"sshd_config.d/.hidden":      "Port 2202\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0600))
+	}
+	config, err := ParseFile(filepath.Join(dir, "sshd_config"))
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{2201, 2200, 2222}, config.Ports)
+	assert.Equal(t, []string{filepath.Join(confDir, "10-host.conf") + ": line 2: UsePAM"}, config.Unsupported)
+	require.Len(t, config.Matches, 3)
+	assert.Equal(t, Settings{PermitTTY: "no"}, config.Matches[0].Settings)
+	// The Match block of the included file ends with it
+	assert.Equal(t, Settings{PermitTTY: "no", X11Forwarding: "yes"}, config.Matches[1].Settings)
+	assert.Equal(t, Settings{}, config.Matches[2].Settings)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "loop.conf"), []byte("Include loop.conf\n"), 0600))
+	_, err = ParseFile(filepath.Join(dir, "loop.conf"))
+	assert.ErrorContains(t, err, "Include: too deeply nested")
+	require.NoError(t, os.WriteFile(filepath.Join(confDir, "30-invalid.conf"), []byte("Port ssh\n"), 0600))
+	_, err = ParseFile(filepath.Join(dir, "sshd_config"))
+	assert.EqualError(t, err, filepath.Join(dir, "sshd_config")+": line 1: Include: "+filepath.Join(confDir, "30-invalid.conf")+": line 1: Port: invalid port: ssh")
+}
@@ -0,0 +1,183 @@
+package sshdtest
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Listener is an in-memory net.Listener whose connections are made by Dial.
+type Listener struct {
+	// RemoteAddr is the remote address of the accepted connections, e.g. a TCP address for code checking IP addresses, instead of "pipe" if not nil.
+	RemoteAddr net.Addr
+
+	conns     chan net.Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen returns a new Listener.
+func Listen() *Listener {
+	return &Listener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// Dial connects to the listener. Unlike net.Pipe, writes are buffered so that both ends can send SSH versions at once.
+func (l *Listener) Dial() (net.Conn, error) {
+	serverConn, clientConn := pipe()
+	serverConn.remoteAddr = l.RemoteAddr
+	select {
+	case l.conns <- serverConn:
+		return clientConn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipeBuffer is data written by one end and read by the other
+type pipeBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  bytes.Buffer
+	// writerClosed makes reads return EOF after the buffer is drained
+	writerClosed bool
+	readerClosed bool
+	readDeadline time.Time
+	timer        *time.Timer
+}
+
+func newPipeBuffer() *pipeBuffer {
+	b := &pipeBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *pipeBuffer) read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for {
+		if b.readerClosed {
+			return 0, net.ErrClosed
+		}
+		if b.buf.Len() != 0 {
+			return b.buf.Read(p)
+		}
+		if b.writerClosed {
+			return 0, io.EOF
+		}
+		if !b.readDeadline.IsZero() && !time.Now().Before(b.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		b.cond.Wait()
+	}
+}
+
+func (b *pipeBuffer) write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.writerClosed {
+		return 0, net.ErrClosed
+	}
+	if b.readerClosed {
+		return 0, io.ErrClosedPipe
+	}
+	b.buf.Write(p)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+func (b *pipeBuffer) setReadDeadline(t time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.readDeadline = t
+	if b.timer != nil {
+		b.timer.Stop()
+	}
+	if !t.IsZero() {
+		b.timer = time.AfterFunc(time.Until(t), func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+	}
+	b.cond.Broadcast()
+}
+
+func (b *pipeBuffer) close(reader bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if reader {
+		b.readerClosed = true
+	} else {
+		b.writerClosed = true
+	}
+	b.cond.Broadcast()
+}
+
+type pipeConn struct {
+	r *pipeBuffer
+	w *pipeBuffer
+	// remoteAddr is returned by RemoteAddr if not nil
+	remoteAddr net.Addr
+}
+
+func pipe() (*pipeConn, *pipeConn) {
+	a, b := newPipeBuffer(), newPipeBuffer()
+	return &pipeConn{r: a, w: b}, &pipeConn{r: b, w: a}
+}
+
+func (c *pipeConn) Read(p []byte) (int, error)  { return c.r.read(p) }
+func (c *pipeConn) Write(p []byte) (int, error) { return c.w.write(p) }
+
+func (c *pipeConn) Close() error {
+	c.r.close(true)
+	c.w.close(false)
+	return nil
+}
+
+func (c *pipeConn) LocalAddr() net.Addr { return pipeAddr{} }
+func (c *pipeConn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return pipeAddr{}
+}
+
+func (c *pipeConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *pipeConn) SetReadDeadline(t time.Time) error {
+	c.r.setReadDeadline(t)
+	return nil
+}
+
+// SetWriteDeadline does nothing because writes never block.
+func (c *pipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,83 @@
+// Package sshdtest provides an in-memory SSH server for hermetic integration tests.
+package sshdtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/exp/slog"
+)
+
+// Server is a server.Server serving on an in-memory Listener.
+type Server struct {
+	*server.Server
+	Listener *Listener
+	// HostKey is the generated host key of the server.
+	HostKey ssh.Signer
+}
+
+// NewServer starts serving s until the test ends.
+// s.Config accepts all users without authentication if nil. Otherwise the generated host key is added to it.
+func NewServer(tb testing.TB, s *server.Server) *Server {
+	tb.Helper()
+	if s.Logger == nil {
+		s.Logger = slog.Default()
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if s.Config == nil {
+		s.Config = &ssh.ServerConfig{NoClientAuth: true}
+	}
+	s.Config.AddHostKey(hostKey)
+	ln := Listen()
+	tb.Cleanup(func() { ln.Close() })
+	go s.Serve(ln)
+	return &Server{Server: s, Listener: ln, HostKey: hostKey}
+}
+
+// Client returns a client connected with config until the test ends.
+// The host key is verified if config.HostKeyCallback is nil.
+func (s *Server) Client(tb testing.TB, config *ssh.ClientConfig) *ssh.Client {
+	tb.Helper()
+	client, err := s.Dial(config)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { client.Close() })
+	return client
+}
+
+// Dial returns a client connected with config. The host key is verified if config.HostKeyCallback is nil.
+func (s *Server) Dial(config *ssh.ClientConfig) (*ssh.Client, error) {
+	if config.HostKeyCallback == nil {
+		copied := *config
+		copied.HostKeyCallback = ssh.FixedHostKey(s.HostKey.PublicKey())
+		config = &copied
+	}
+	conn, err := s.Listener.Dial()
+	if err != nil {
+		return nil, err
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "pipe", config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// NewClient starts serving s and returns a client connected as user until the test ends.
+func NewClient(tb testing.TB, s *server.Server, user string) *ssh.Client {
+	tb.Helper()
+	return NewServer(tb, s).Client(tb, &ssh.ClientConfig{User: user})
+}
@@ -0,0 +1,45 @@
+package sshdtest
+
+import (
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNewClient(t *testing.T) {
+	client := NewClient(t, &server.Server{
+		AllowExecute: true,
+		Handler: func(sess server.Session) {
+			io.WriteString(sess, fmt.Sprintf("%s ran %q", sess.User(), sess.RawCommand()))
+		},
+	}, "john")
+
+	session, err := client.NewSession()
+	require.NoError(t, err)
+	defer session.Close()
+	output, err := session.Output("ls -l")
+	require.NoError(t, err)
+	assert.Equal(t, `john ran "ls -l"`, string(output))
+}
+
+func TestServerWithConfig(t *testing.T) {
+	s := NewServer(t, &server.Server{Config: &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) != "mypass" {
+				return nil, fmt.Errorf("password rejected")
+			}
+			return nil, nil
+		},
+	}})
+
+	s.Client(t, &ssh.ClientConfig{User: "john", Auth: []ssh.AuthMethod{ssh.Password("mypass")}})
+	_, err := s.Dial(&ssh.ClientConfig{User: "john", Auth: []ssh.AuthMethod{ssh.Password("wrong")}})
+	assert.Error(t, err)
+	assert.Equal(t, int64(1), s.Stats().ActiveConnections)
+}
@@ -0,0 +1,97 @@
+// Package tarpit holds connections open by trickling an endless SSH banner like endlessh, wasting the time and sockets of scanners.
+// Clients accept lines before the version of the server (RFC 4253 section 4.2), so they wait for the version, which never comes.
+package tarpit
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is the time between lines if Tarpit.Interval is 0
+const DefaultInterval = 10 * time.Second
+
+// Tarpit holds connections. The zero value holds any number of connections until clients close them.
+type Tarpit struct {
+	// MaxConnections is the number of connections held at once, or unlimited if 0
+	MaxConnections int
+	// Duration is the time to hold each connection, or unlimited if 0
+	Duration time.Duration
+	// Interval is the time between lines (default: DefaultInterval)
+	Interval time.Duration
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// Hold trickles lines to conn in the background and closes it after Duration or when the client closes it.
+// It returns false leaving conn to the caller if MaxConnections are held or t is closed.
+func (t *Tarpit) Hold(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed || (t.MaxConnections > 0 && len(t.conns) >= t.MaxConnections) {
+		return false
+	}
+	if t.conns == nil {
+		t.conns = map[net.Conn]struct{}{}
+	}
+	t.conns[conn] = struct{}{}
+	go t.hold(conn)
+	return true
+}
+
+func (t *Tarpit) hold(conn net.Conn) {
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+		conn.Close()
+	}()
+	interval := t.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if t.Duration > 0 {
+		// Closing also ends writes blocked while the client doesn't read
+		timer := time.AfterFunc(t.Duration, func() { conn.Close() })
+		defer timer.Stop()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if _, err := conn.Write(line()); err != nil {
+			return
+		}
+	}
+}
+
+// Active returns the number of connections held.
+func (t *Tarpit) Active() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
+
+// Close closes the connections held and makes Hold return false.
+func (t *Tarpit) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	for conn := range t.conns {
+		conn.Close()
+	}
+}
+
+// line returns a random line of up to 32 printable characters, which doesn't start with "SSH-" to be the version
+func line() []byte {
+	b := make([]byte, 1+rand.Intn(32), 34)
+	for i := range b {
+		b[i] = byte('!' + rand.Intn('~'-'!'+1))
+	}
+	if b[0] == 'S' {
+		b[0] = 's'
+	}
+	return append(b, '\r', '\n')
+}
@@ -0,0 +1,48 @@
+package tarpit
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHold(t *testing.T) {
+	tp := &Tarpit{MaxConnections: 1, Duration: 200 * time.Millisecond, Interval: 10 * time.Millisecond}
+	server, client := net.Pipe()
+	require.True(t, tp.Hold(server))
+	other, _ := net.Pipe()
+	assert.False(t, tp.Hold(other))
+	assert.Equal(t, 1, tp.Active())
+
+	reader := bufio.NewReader(client)
+	lines := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		assert.True(t, strings.HasSuffix(line, "\r\n"), line)
+		assert.False(t, strings.HasPrefix(line, "SSH-"), line)
+		lines++
+	}
+	assert.Greater(t, lines, 1)
+	assert.Eventually(t, func() bool { return tp.Active() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestClose(t *testing.T) {
+	tp := &Tarpit{}
+	server, client := net.Pipe()
+	require.True(t, tp.Hold(server))
+	tp.Close()
+	_, err := client.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	other, _ := net.Pipe()
+	assert.False(t, tp.Hold(other))
+}
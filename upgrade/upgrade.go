@@ -0,0 +1,238 @@
+// Package upgrade restarts the running binary without closing listening sockets.
+// The new process inherits the listeners, so that the old process can stop accepting and drain its connections.
+package upgrade
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// envListeners is the JSON array of the keys of the inherited listeners.
+// The listeners are passed from fd 3 in order and followed by the pipe to notify readiness.
+const envListeners = "GO_SSHD_UPGRADE_LISTENERS"
+
+// ReadyTimeout is the time for the new process to become ready.
+var ReadyTimeout = time.Minute
+
+// Upgrader creates listeners which can be passed to a new process by Upgrade.
+type Upgrader struct {
+	// Start starts the new process, or cmd.Start if nil.
+	Start func(cmd *exec.Cmd) error
+
+	mu        sync.Mutex
+	inherited map[string]*os.File
+	ready     *os.File
+	listeners map[*listener]struct{}
+	packets   map[*packetConn]struct{}
+}
+
+// listener is removed from Upgrader when closed
+type listener struct {
+	net.Listener
+	key      string
+	upgrader *Upgrader
+}
+
+func (l *listener) Close() error {
+	l.upgrader.mu.Lock()
+	delete(l.upgrader.listeners, l)
+	l.upgrader.mu.Unlock()
+	return l.Listener.Close()
+}
+
+// packetConn is removed from Upgrader when closed
+type packetConn struct {
+	net.PacketConn
+	key      string
+	upgrader *Upgrader
+}
+
+func (c *packetConn) Close() error {
+	c.upgrader.mu.Lock()
+	delete(c.upgrader.packets, c)
+	c.upgrader.mu.Unlock()
+	return c.PacketConn.Close()
+}
+
+// New returns an Upgrader with the listeners inherited from the old process if any.
+func New() (*Upgrader, error) {
+	u := &Upgrader{inherited: map[string]*os.File{}, listeners: map[*listener]struct{}{}, packets: map[*packetConn]struct{}{}}
+	value := os.Getenv(envListeners)
+	if value == "" {
+		return u, nil
+	}
+	// Not to be inherited by shells and the next upgrade
+	os.Unsetenv(envListeners)
+	var keys []string
+	if err := json.Unmarshal([]byte(value), &keys); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", envListeners, err)
+	}
+	for i, key := range keys {
+		u.inherited[key] = os.NewFile(uintptr(3+i), key)
+	}
+	u.ready = os.NewFile(uintptr(3+len(keys)), "ready")
+	return u, nil
+}
+
+// Upgraded reports whether this process was started by Upgrade.
+func (u *Upgrader) Upgraded() bool {
+	return u.ready != nil
+}
+
+// Listen returns the listener inherited for network and address, or listens on them.
+// Closed listeners are not passed by Upgrade. Only "tcp" and "unix" listeners can be passed, and see ListenPacket for "udp".
+func (u *Upgrader) Listen(network, address string) (net.Listener, error) {
+	return u.ListenConfig(&net.ListenConfig{}, network, address, "")
+}
+
+// ListenConfig is Listen by config, e.g. to set socket options.
+// Listeners on the same network and address, e.g. with SO_REUSEPORT, are told apart by name.
+// Inherited listeners keep the socket options set by the old process.
+func (u *Upgrader) ListenConfig(config *net.ListenConfig, network, address, name string) (net.Listener, error) {
+	key := network + ":" + address
+	if name != "" {
+		key += "#" + name
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var ln net.Listener
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		ln, err = net.FileListener(f)
+		f.Close()
+	} else {
+		ln, err = config.Listen(context.Background(), network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{Listener: ln, key: key, upgrader: u}
+	u.listeners[l] = struct{}{}
+	return l, nil
+}
+
+// ListenPacket returns the packet connection inherited for network and address, or listens on them like Listen.
+// Only "udp" connections can be passed.
+func (u *Upgrader) ListenPacket(network, address string) (net.PacketConn, error) {
+	key := network + ":" + address
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var pc net.PacketConn
+	var err error
+	if f, ok := u.inherited[key]; ok {
+		delete(u.inherited, key)
+		pc, err = net.FilePacketConn(f)
+		f.Close()
+	} else {
+		pc, err = net.ListenPacket(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &packetConn{PacketConn: pc, key: key, upgrader: u}
+	u.packets[c] = struct{}{}
+	return c, nil
+}
+
+// Ready notifies the old process that this process is serving.
+// Inherited listeners not used by Listen are closed.
+func (u *Upgrader) Ready() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for key, f := range u.inherited {
+		f.Close()
+		delete(u.inherited, key)
+	}
+	if u.ready == nil {
+		return nil
+	}
+	_, err := u.ready.Write([]byte{1})
+	u.ready.Close()
+	u.ready = nil
+	return err
+}
+
+// Upgrade starts the executable with the same arguments, passes the listeners and waits for it to call Ready.
+// The caller should close the listeners to stop accepting and drain its connections after Upgrade succeeded.
+func (u *Upgrader) Upgrade() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	var keys []string
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	pass := func(key string, socket any) error {
+		filer, ok := socket.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s can not be passed", key)
+		}
+		f, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("failed to get file of listener %s: %w", key, err)
+		}
+		keys = append(keys, key)
+		files = append(files, f)
+		return nil
+	}
+	for l := range u.listeners {
+		if err := pass(l.key, l.Listener); err != nil {
+			return err
+		}
+	}
+	for c := range u.packets {
+		if err := pass(c.key, c.PacketConn); err != nil {
+			return err
+		}
+	}
+	keysJSON, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer readyReader.Close()
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), envListeners+"="+string(keysJSON))
+	cmd.ExtraFiles = append(files, readyWriter)
+	if u.Start != nil {
+		err = u.Start(cmd)
+	} else {
+		err = cmd.Start()
+	}
+	readyWriter.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+	readyReader.SetReadDeadline(time.Now().Add(ReadyTimeout))
+	if _, err := readyReader.Read(make([]byte, 1)); err != nil {
+		cmd.Process.Kill()
+		return fmt.Errorf("new process did not become ready: %w", err)
+	}
+	// The socket files are used by the new process
+	for l := range u.listeners {
+		if unixListener, ok := l.Listener.(*net.UnixListener); ok {
+			unixListener.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
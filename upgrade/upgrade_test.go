@@ -0,0 +1,81 @@
+package upgrade
+
+import (
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// envTestAddress is the address listened by the test and the new process
+const envTestAddress = "UPGRADE_TEST_ADDRESS"
+
+func TestMain(m *testing.M) {
+	if address := os.Getenv(envTestAddress); address != "" {
+		os.Exit(runNewProcess(address))
+	}
+	os.Exit(m.Run())
+}
+
+// runNewProcess is the new process started by Upgrade
+func runNewProcess(address string) int {
+	u, err := New()
+	if err != nil || !u.Upgraded() {
+		return 1
+	}
+	ln, err := u.Listen("tcp", address)
+	if err != nil {
+		return 1
+	}
+	u.Ready()
+	conn, err := ln.Accept()
+	if err != nil {
+		return 1
+	}
+	conn.Write([]byte("new"))
+	conn.Close()
+	return 0
+}
+
+func TestUpgrade(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("listeners can not be passed on Windows")
+	}
+
+	port := func() string {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+		return ln.Addr().String()
+	}()
+	t.Setenv(envTestAddress, port)
+	u, err := New()
+	require.NoError(t, err)
+	assert.False(t, u.Upgraded())
+	ln, err := u.Listen("tcp", port)
+	require.NoError(t, err)
+	require.NoError(t, u.Upgrade())
+	// Connections are accepted by the new process after the listener is closed
+	ln.Close()
+	conn, err := net.Dial("tcp", port)
+	require.NoError(t, err)
+	defer conn.Close()
+	b, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(b))
+}
+
+func TestListenPacket(t *testing.T) {
+	u, err := New()
+	require.NoError(t, err)
+	pc, err := u.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.Len(t, u.packets, 1)
+	require.NoError(t, pc.Close())
+	// Closed connections are not passed by Upgrade
+	assert.Empty(t, u.packets)
+}
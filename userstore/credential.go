@@ -0,0 +1,144 @@
+package userstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Credential is a password or a public key of a user in addition to PasswordHash and AuthorizedKeys,
+// which can expire or be used only once, e.g. for contractors and break-glass access.
+type Credential struct {
+	// PasswordHash is a bcrypt or argon2id hash of the password. Either it or AuthorizedKey is required.
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+	// AuthorizedKey is a public key in the authorized_keys format.
+	AuthorizedKey string `json:"authorized_key,omitempty" yaml:"authorized_key,omitempty"`
+	// ExpiresAt is when the credential expires. It never expires if nil.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" yaml:"expires_at,omitempty"`
+	// OneTime makes the credential invalid after the first login with it.
+	OneTime bool `json:"one_time,omitempty" yaml:"one_time,omitempty"`
+}
+
+// extensionOneTimeCredential is the ID of the one-time credential of the login, which Authenticator.CheckLogin consumes
+const extensionOneTimeCredential = "go-sshd-one-time-credential"
+
+// validate returns an error if the credential is invalid
+func (c *Credential) validate() error {
+	switch {
+	case (c.PasswordHash == "") == (c.AuthorizedKey == ""):
+		return errors.New("either password_hash or authorized_key is required")
+	case c.PasswordHash != "":
+		return validatePasswordHash(c.PasswordHash)
+	}
+	_, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.AuthorizedKey))
+	return err
+}
+
+// id returns the ID of the credential of userName recorded when used, which doesn't reveal the credential
+func (c *Credential) id(userName string) string {
+	secret := c.PasswordHash
+	if key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.AuthorizedKey)); err == nil {
+		secret = string(key.Marshal())
+	}
+	sum := sha256.Sum256([]byte(userName + "\x00" + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// credentialPermissions returns the permissions of user logging in with c at now, which is rejected if c is expired or used
+func (a *Authenticator) credentialPermissions(user *User, c *Credential, now time.Time) (*ssh.Permissions, error) {
+	if c.ExpiresAt != nil && !now.Before(*c.ExpiresAt) {
+		return nil, fmt.Errorf("expired credential of %q", user.Name)
+	}
+	var id string
+	if c.OneTime {
+		id = c.id(user.Name)
+		if a.isUsed(id) {
+			return nil, fmt.Errorf("used one-time credential of %q", user.Name)
+		}
+	}
+	permissions, err := user.loginPermissions(now)
+	if err != nil {
+		return nil, err
+	}
+	if id != "" {
+		permissions.Extensions[extensionOneTimeCredential] = id
+	}
+	return permissions, nil
+}
+
+// LoadUsedCredentials loads UsedCredentialsFile. It does nothing if the file doesn't exist.
+func (a *Authenticator) LoadUsedCredentials() error {
+	if a.UsedCredentialsFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(a.UsedCredentialsFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	used := map[string]time.Time{}
+	if err := json.Unmarshal(b, &used); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", a.UsedCredentialsFile, err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.used = used
+	return nil
+}
+
+func (a *Authenticator) isUsed(id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, ok := a.used[id]
+	return ok
+}
+
+// CheckLogin consumes the one-time credential of the authenticated connection, for server.Server.CheckLogin.
+// It returns an error if the credential has been used by another connection or can't be recorded in UsedCredentialsFile.
+func (a *Authenticator) CheckLogin(sshConn *ssh.ServerConn) error {
+	if sshConn.Permissions == nil {
+		return nil
+	}
+	id := sshConn.Permissions.Extensions[extensionOneTimeCredential]
+	if id == "" {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.used[id]; ok {
+		return fmt.Errorf("used one-time credential of %q", sshConn.User())
+	}
+	if a.used == nil {
+		a.used = map[string]time.Time{}
+	}
+	a.used[id] = time.Now()
+	if a.UsedCredentialsFile == "" {
+		return nil
+	}
+	if err := a.saveUsedCredentials(); err != nil {
+		delete(a.used, id)
+		return fmt.Errorf("failed to record the one-time credential of %q: %w", sshConn.User(), err)
+	}
+	return nil
+}
+
+// saveUsedCredentials replaces UsedCredentialsFile at once not to be broken by crashes
+func (a *Authenticator) saveUsedCredentials() error {
+	b, err := json.MarshalIndent(a.used, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := a.UsedCredentialsFile + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.UsedCredentialsFile)
+}
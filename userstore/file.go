@@ -0,0 +1,68 @@
+package userstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileStore is a Store loaded from a JSON or YAML file.
+type FileStore struct {
+	users map[string]*User
+}
+
+type fileContent struct {
+	Users []User `json:"users" yaml:"users"`
+}
+
+// LoadFile loads and validates users from path. The format is YAML for ".yaml" and ".yml" extensions and JSON otherwise.
+func LoadFile(path string) (*FileStore, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var content fileContent
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &content)
+	default:
+		err = json.Unmarshal(b, &content)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	users := make(map[string]*User, len(content.Users))
+	for i := range content.Users {
+		user := &content.Users[i]
+		if err := user.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid user in %s: %w", path, err)
+		}
+		if _, ok := users[user.Name]; ok {
+			return nil, fmt.Errorf("duplicate user in %s: %s", path, user.Name)
+		}
+		users[user.Name] = user
+	}
+	return &FileStore{users: users}, nil
+}
+
+func (f *FileStore) Lookup(name string) (*User, error) {
+	user, ok := f.users[name]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+// Users returns the users sorted by name.
+func (f *FileStore) Users() []*User {
+	users := make([]*User, 0, len(f.users))
+	for _, user := range f.users {
+		users = append(users, user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
@@ -0,0 +1,111 @@
+package userstore
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hash algorithms
+const (
+	HashBcrypt   = "bcrypt"
+	HashArgon2id = "argon2id"
+)
+
+// Argon2idParams are the parameters of argon2id hashes.
+type Argon2idParams struct {
+	// Memory is the memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultArgon2idParams is the second recommended option of RFC 9106.
+var DefaultArgon2idParams = Argon2idParams{Memory: 64 * 1024, Iterations: 3, Parallelism: 4, SaltLength: 16, KeyLength: 32}
+
+var errMismatchedPassword = errors.New("password does not match")
+
+// HashBcryptPassword returns the bcrypt hash of password with cost.
+func HashBcryptPassword(password []byte, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// HashArgon2idPassword returns the argon2id hash of password in the PHC string format,
+// e.g. "$argon2id$v=19$m=65536,t=3,p=4$salt$key".
+func HashArgon2idPassword(password []byte, params Argon2idParams) (string, error) {
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// ComparePassword returns nil if password matches the bcrypt or argon2id hash.
+func ComparePassword(hash string, password []byte) error {
+	if !strings.HasPrefix(hash, "$argon2id$") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), password)
+	}
+	params, salt, key, err := parseArgon2idHash(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey(password, salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return errMismatchedPassword
+	}
+	return nil
+}
+
+// validatePasswordHash returns an error if hash is neither bcrypt nor argon2id
+func validatePasswordHash(hash string) error {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		_, _, _, err := parseArgon2idHash(hash)
+		return err
+	}
+	_, err := bcrypt.Cost([]byte(hash))
+	return err
+}
+
+func parseArgon2idHash(hash string) (Argon2idParams, []byte, []byte, error) {
+	var params Argon2idParams
+	// "", "argon2id", "v=19", "m=65536,t=3,p=4", salt, key
+	fields := strings.Split(hash, "$")
+	if len(fields) != 6 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(fields[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version: %s", fields[2])
+	}
+	if _, err := fmt.Sscanf(fields[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %s", fields[3])
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters: %s", fields[3])
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(fields[4])
+	if err != nil {
+		return params, nil, nil, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(fields[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id key")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
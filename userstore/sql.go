@@ -0,0 +1,54 @@
+package userstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+)
+
+// DefaultSQLQuery selects a user by the name. Placeholders may need to be changed for the driver (e.g. "$1" for PostgreSQL).
+const DefaultSQLQuery = `SELECT name, password_hash, authorized_keys, shell, home_dir, permissions, max_sessions FROM users WHERE name = ?`
+
+// SQLStore is a Store in a SQL database.
+// Query selects columns of a user in the order of DefaultSQLQuery: authorized_keys is newline-separated
+// and permissions is comma-separated or NULL to use the permissions of the server.
+type SQLStore struct {
+	DB *sql.DB
+	// Query selects a user by the name. DefaultSQLQuery is used if empty.
+	Query string
+}
+
+func (s *SQLStore) Lookup(name string) (*User, error) {
+	query := s.Query
+	if query == "" {
+		query = DefaultSQLQuery
+	}
+	var user User
+	var passwordHash, authorizedKeys, shell, homeDir, permissions sql.NullString
+	var maxSessions sql.NullInt64
+	err := s.DB.QueryRow(query, name).Scan(&user.Name, &passwordHash, &authorizedKeys, &shell, &homeDir, &permissions, &maxSessions)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.PasswordHash = passwordHash.String
+	for _, line := range strings.Split(authorizedKeys.String, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			user.AuthorizedKeys = append(user.AuthorizedKeys, line)
+		}
+	}
+	user.Shell = shell.String
+	user.HomeDir = homeDir.String
+	if permissions.Valid {
+		user.Permissions = []string{}
+		for _, name := range strings.Split(permissions.String, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				user.Permissions = append(user.Permissions, name)
+			}
+		}
+	}
+	user.MaxSessions = int(maxSessions.Int64)
+	return &user, nil
+}
@@ -0,0 +1,108 @@
+package userstore
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDriver serves fixed rows of users keyed by the name
+type fakeDriver struct {
+	rows map[string][]driver.Value
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{driver: d}, nil
+}
+
+type fakeConn struct {
+	driver *fakeDriver
+}
+
+func (c *fakeConn) Prepare(string) (driver.Stmt, error) {
+	return &fakeStmt{driver: c.driver}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return nil, driver.ErrSkip
+}
+
+type fakeStmt struct {
+	driver *fakeDriver
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return 1
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	row, ok := s.driver.rows[args[0].(string)]
+	if !ok {
+		return &fakeRows{}, nil
+	}
+	return &fakeRows{rows: [][]driver.Value{row}}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"name", "password_hash", "authorized_keys", "shell", "home_dir", "permissions", "max_sessions"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestSQLStore(t *testing.T) {
+	sql.Register("userstore-fake", &fakeDriver{rows: map[string][]driver.Value{
+		"john": {"john", "hash", "ssh-ed25519 AAAA1\n\nssh-ed25519 AAAA2\n", "/bin/bash", nil, "execute, sftp", int64(3)},
+		"alex": {"alex", nil, nil, nil, nil, nil, nil},
+	}})
+	db, err := sql.Open("userstore-fake", "")
+	require.NoError(t, err)
+	defer db.Close()
+	store := &SQLStore{DB: db}
+
+	user, err := store.Lookup("john")
+	require.NoError(t, err)
+	assert.Equal(t, &User{
+		Name:           "john",
+		PasswordHash:   "hash",
+		AuthorizedKeys: []string{"ssh-ed25519 AAAA1", "ssh-ed25519 AAAA2"},
+		Shell:          "/bin/bash",
+		Permissions:    []string{"execute", "sftp"},
+		MaxSessions:    3,
+	}, user)
+	user, err = store.Lookup("alex")
+	require.NoError(t, err)
+	assert.Equal(t, &User{Name: "alex"}, user)
+	_, err = store.Lookup("bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
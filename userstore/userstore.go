@@ -0,0 +1,229 @@
+// Package userstore provides virtual users which do not need to exist in the operating system.
+package userstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/namespaces"
+	"github.com/John-Ao/go-sshd/notify"
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/server/auth"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrUserNotFound is returned by Store.Lookup when the user does not exist.
+var ErrUserNotFound = errors.New("user not found")
+
+// User is a virtual user and its settings.
+type User struct {
+	Name string `json:"name" yaml:"name"`
+	// PasswordHash is a bcrypt or argon2id hash of the password, e.g. by "go-sshd passwd". Password authentication is disabled if empty.
+	PasswordHash string `json:"password_hash,omitempty" yaml:"password_hash,omitempty"`
+	// AuthorizedKeys are public keys in the authorized_keys format.
+	AuthorizedKeys []string `json:"authorized_keys,omitempty" yaml:"authorized_keys,omitempty"`
+	// Shell is the shell of the user. The shell of the server is used if empty.
+	Shell string `json:"shell,omitempty" yaml:"shell,omitempty"`
+	// HomeDir is the working directory of processes of the user.
+	HomeDir string `json:"home_dir,omitempty" yaml:"home_dir,omitempty"`
+	// Permissions are permission names allowed for the user (e.g. "execute", "sftp"). The permissions of the server are used if nil.
+	Permissions []string `json:"permissions,omitempty" yaml:"permissions,omitempty"`
+	// MaxSessions is the maximum number of concurrent sessions. No limit if 0.
+	MaxSessions int `json:"max_sessions,omitempty" yaml:"max_sessions,omitempty"`
+	// MaxTransfer is the maximum number of bytes through the channels of each connection, overriding the one of the server. No limit of the user if 0.
+	MaxTransfer uint64 `json:"max_transfer,omitempty" yaml:"max_transfer,omitempty"`
+	// ResourceLimits are the limits of processes like "cpu=60,as=1G,nofile=1024,nproc=64", overriding the ones of the server.
+	ResourceLimits string `json:"resource_limits,omitempty" yaml:"resource_limits,omitempty"`
+	// CgroupLimits are the cgroup controls of processes like "cpu.weight=50,memory.max=512M,pids.max=128", overriding the ones of the server.
+	CgroupLimits string `json:"cgroup_limits,omitempty" yaml:"cgroup_limits,omitempty"`
+	// Namespaces are the Linux namespaces of processes like "mount,pid,net=none" instead of the ones of the server.
+	Namespaces string `json:"namespaces,omitempty" yaml:"namespaces,omitempty"`
+	// LoginHours are when the user can log in like "Mon-Fri 08:00-18:00 Europe/Berlin" in the form of notify.ParseHours. No limit if empty.
+	LoginHours string `json:"login_hours,omitempty" yaml:"login_hours,omitempty"`
+	// DisconnectAfterHours closes connections of the user when LoginHours end.
+	DisconnectAfterHours bool `json:"disconnect_after_hours,omitempty" yaml:"disconnect_after_hours,omitempty"`
+	// Credentials are passwords and keys which can expire or be used only once.
+	Credentials []Credential `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+// Validate returns an error if the password hash, authorized keys or permissions of u are invalid.
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if u.PasswordHash != "" {
+		if err := validatePasswordHash(u.PasswordHash); err != nil {
+			return fmt.Errorf("invalid password hash of %q: %w", u.Name, err)
+		}
+	}
+	for i, line := range u.AuthorizedKeys {
+		if _, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line)); err != nil {
+			return fmt.Errorf("invalid authorized_keys[%d] of %q: %w", i, u.Name, err)
+		}
+	}
+	for i := range u.Credentials {
+		if err := u.Credentials[i].validate(); err != nil {
+			return fmt.Errorf("invalid credentials[%d] of %q: %w", i, u.Name, err)
+		}
+	}
+	for _, name := range u.Permissions {
+		switch name {
+		case server.PermissionTcpipForward, server.PermissionDirectTcpip, server.PermissionExecute,
+			server.PermissionSftp, server.PermissionStreamlocalForward, server.PermissionDirectStreamlocal,
+			server.PermissionScp, server.PermissionAgentForward, server.PermissionX11Forward,
+			server.PermissionAttach, server.PermissionAttachWrite:
+		default:
+			return fmt.Errorf("unknown permission of %q: %s", u.Name, name)
+		}
+	}
+	if u.MaxSessions < 0 {
+		return fmt.Errorf("negative max_sessions of %q", u.Name)
+	}
+	if _, err := server.ParseResourceLimits(u.ResourceLimits); err != nil {
+		return fmt.Errorf("invalid resource_limits of %q: %w", u.Name, err)
+	}
+	if _, err := server.ParseCgroupLimits(u.CgroupLimits); err != nil {
+		return fmt.Errorf("invalid cgroup_limits of %q: %w", u.Name, err)
+	}
+	if _, err := namespaces.Parse(u.Namespaces); err != nil {
+		return fmt.Errorf("invalid namespaces of %q: %w", u.Name, err)
+	}
+	if u.LoginHours != "" {
+		if _, err := notify.ParseHours(u.LoginHours); err != nil {
+			return fmt.Errorf("invalid login_hours of %q: %w", u.Name, err)
+		}
+	} else if u.DisconnectAfterHours {
+		return fmt.Errorf("disconnect_after_hours of %q requires login_hours", u.Name)
+	}
+	return nil
+}
+
+// Store is a source of users.
+type Store interface {
+	// Lookup returns the user of name or ErrUserNotFound.
+	Lookup(name string) (*User, error)
+}
+
+// Authenticator authenticates users in Store. Set its methods to ssh.ServerConfig, and CheckLogin to server.Server for one-time credentials.
+type Authenticator struct {
+	Store Store
+	// UsedCredentialsFile keeps the one-time credentials used across restarts if not empty
+	UsedCredentialsFile string
+
+	mu sync.Mutex
+	// used are the times one-time credentials were used by their IDs
+	used map[string]time.Time
+}
+
+var (
+	_ auth.PasswordAuthenticator  = (*Authenticator)(nil)
+	_ auth.PublicKeyAuthenticator = (*Authenticator)(nil)
+)
+
+func (a *Authenticator) PasswordCallback(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	user, err := a.Store.Lookup(conn.User())
+	if err != nil {
+		return nil, err
+	}
+	enabled := user.PasswordHash != ""
+	if enabled && ComparePassword(user.PasswordHash, password) == nil {
+		return user.loginPermissions(time.Now())
+	}
+	for i := range user.Credentials {
+		credential := &user.Credentials[i]
+		if credential.PasswordHash == "" {
+			continue
+		}
+		enabled = true
+		if ComparePassword(credential.PasswordHash, password) == nil {
+			return a.credentialPermissions(user, credential, time.Now())
+		}
+	}
+	if !enabled {
+		return nil, fmt.Errorf("password authentication disabled for %q", conn.User())
+	}
+	return nil, fmt.Errorf("password rejected for %q", conn.User())
+}
+
+func (a *Authenticator) PublicKeyCallback(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+	user, err := a.Store.Lookup(conn.User())
+	if err != nil {
+		return nil, err
+	}
+	keyBytes := key.Marshal()
+	for _, line := range user.AuthorizedKeys {
+		authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(authorizedKey.Marshal(), keyBytes) {
+			return user.loginPermissions(time.Now())
+		}
+	}
+	for i := range user.Credentials {
+		credential := &user.Credentials[i]
+		if credential.AuthorizedKey == "" {
+			continue
+		}
+		authorizedKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(credential.AuthorizedKey))
+		if err == nil && bytes.Equal(authorizedKey.Marshal(), keyBytes) {
+			return a.credentialPermissions(user, credential, time.Now())
+		}
+	}
+	return nil, fmt.Errorf("public key rejected for %q", conn.User())
+}
+
+// loginPermissions returns SSHPermissions of the user logging in at now, which is rejected outside LoginHours
+func (u *User) loginPermissions(now time.Time) (*ssh.Permissions, error) {
+	permissions := u.SSHPermissions()
+	if u.LoginHours == "" {
+		return permissions, nil
+	}
+	hours, err := notify.ParseHours(u.LoginHours)
+	if err != nil {
+		return nil, fmt.Errorf("invalid login_hours of %q: %w", u.Name, err)
+	}
+	if !hours.Contains(now) {
+		return nil, fmt.Errorf("login outside login_hours of %q", u.Name)
+	}
+	if end := hours.EndAfter(now); u.DisconnectAfterHours && !end.IsZero() {
+		permissions.Extensions[server.ExtensionDisconnectAt] = end.Format(time.RFC3339)
+	}
+	return permissions, nil
+}
+
+// SSHPermissions returns ssh.Permissions carrying the settings of the user to server.Server.
+func (u *User) SSHPermissions() *ssh.Permissions {
+	extensions := map[string]string{}
+	if u.Shell != "" {
+		extensions[server.ExtensionShell] = u.Shell
+	}
+	if u.HomeDir != "" {
+		extensions[server.ExtensionHomeDir] = u.HomeDir
+	}
+	if u.Permissions != nil {
+		extensions[server.ExtensionPermissions] = strings.Join(u.Permissions, ",")
+	}
+	if u.MaxSessions != 0 {
+		extensions[server.ExtensionMaxSessions] = strconv.Itoa(u.MaxSessions)
+	}
+	if u.MaxTransfer != 0 {
+		extensions[server.ExtensionMaxTransfer] = strconv.FormatUint(u.MaxTransfer, 10)
+	}
+	if u.ResourceLimits != "" {
+		extensions[server.ExtensionResourceLimits] = u.ResourceLimits
+	}
+	if u.CgroupLimits != "" {
+		extensions[server.ExtensionCgroupLimits] = u.CgroupLimits
+	}
+	if u.Namespaces != "" {
+		extensions[server.ExtensionNamespaces] = u.Namespaces
+	}
+	return &ssh.Permissions{Extensions: extensions}
+}
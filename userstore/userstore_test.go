@@ -0,0 +1,237 @@
+package userstore
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+type connMetadata struct {
+	ssh.ConnMetadata
+	user string
+}
+
+func (c connMetadata) User() string {
+	return c.user
+}
+
+func TestLoadFile(t *testing.T) {
+	dir := t.TempDir()
+	yamlPath := filepath.Join(dir, "users.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(`users:
+  - name: john
+    shell: /bin/bash
+    home_dir: /home/john
+    permissions: [execute, sftp]
+    max_sessions: 2
+    max_transfer: 1073741824
+    resource_limits: cpu=60,nproc=64
+    cgroup_limits: memory.max=512M
+    namespaces: pid,net=none
+  - name: alex
+`), 0600))
+	store, err := LoadFile(yamlPath)
+	require.NoError(t, err)
+	user, err := store.Lookup("john")
+	require.NoError(t, err)
+	assert.Equal(t, &User{Name: "john", Shell: "/bin/bash", HomeDir: "/home/john", Permissions: []string{"execute", "sftp"}, MaxSessions: 2, MaxTransfer: 1 << 30, ResourceLimits: "cpu=60,nproc=64", CgroupLimits: "memory.max=512M", Namespaces: "pid,net=none"}, user)
+	_, err = store.Lookup("bob")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+	users := store.Users()
+	if assert.Len(t, users, 2) {
+		assert.Equal(t, "alex", users[0].Name)
+		assert.Equal(t, "john", users[1].Name)
+	}
+
+	jsonPath := filepath.Join(dir, "users.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"users": [{"name": "john", "shell": "/bin/zsh"}]}`), 0600))
+	store, err = LoadFile(jsonPath)
+	require.NoError(t, err)
+	user, err = store.Lookup("john")
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/zsh", user.Shell)
+
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"users": [{"name": "john"}, {"name": "john"}]}`), 0600))
+	_, err = LoadFile(jsonPath)
+	assert.Error(t, err)
+
+	for _, invalid := range []string{
+		`{"users": [{"name": ""}]}`,
+		`{"users": [{"name": "john", "password_hash": "mypass"}]}`,
+		`{"users": [{"name": "john", "authorized_keys": ["ssh-ed25519 invalid"]}]}`,
+		`{"users": [{"name": "john", "permissions": ["exec"]}]}`,
+		`{"users": [{"name": "john", "resource_limits": "rss=1G"}]}`,
+		`{"users": [{"name": "john", "cgroup_limits": "cpu.weight=0.5"}]}`,
+		`{"users": [{"name": "john", "namespaces": "user"}]}`,
+		`{"users": [{"name": "john", "login_hours": "Mon-Fri 09:00-18:00 Mars/Olympus"}]}`,
+		`{"users": [{"name": "john", "disconnect_after_hours": true}]}`,
+		`{"users": [{"name": "john", "credentials": [{"one_time": true}]}]}`,
+		`{"users": [{"name": "john", "credentials": [{"authorized_key": "ssh-ed25519 invalid"}]}]}`,
+	} {
+		require.NoError(t, os.WriteFile(jsonPath, []byte(invalid), 0600))
+		_, err = LoadFile(jsonPath)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestAuthenticator(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherSSHPub, err := ssh.NewPublicKey(otherPub)
+	require.NoError(t, err)
+
+	store := &FileStore{users: map[string]*User{
+		"john": {
+			Name:           "john",
+			PasswordHash:   string(hash),
+			AuthorizedKeys: []string{string(ssh.MarshalAuthorizedKey(sshPub))},
+			Shell:          "/bin/bash",
+			Permissions:    []string{server.PermissionExecute},
+			MaxTransfer:    1 << 30,
+		},
+		"alex": {Name: "alex"},
+	}}
+	authenticator := &Authenticator{Store: store}
+
+	perms, err := authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		server.ExtensionShell:       "/bin/bash",
+		server.ExtensionPermissions: server.PermissionExecute,
+		server.ExtensionMaxTransfer: "1073741824",
+	}, perms.Extensions)
+	_, err = authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("wrong"))
+	assert.Error(t, err)
+	// Password authentication is disabled without a hash
+	_, err = authenticator.PasswordCallback(connMetadata{user: "alex"}, []byte(""))
+	assert.Error(t, err)
+	_, err = authenticator.PasswordCallback(connMetadata{user: "bob"}, []byte("mypass"))
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	perms, err = authenticator.PublicKeyCallback(connMetadata{user: "john"}, sshPub)
+	require.NoError(t, err)
+	assert.Equal(t, "/bin/bash", perms.Extensions[server.ExtensionShell])
+	_, err = authenticator.PublicKeyCallback(connMetadata{user: "john"}, otherSSHPub)
+	assert.Error(t, err)
+}
+
+func TestLoginHours(t *testing.T) {
+	user := &User{Name: "john", LoginHours: "Mon-Fri 09:00-18:00 Asia/Tokyo"}
+	// 2024-01-01 is Monday, and 00:00 in UTC is 09:00 in Tokyo
+	monday := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	perms, err := user.loginPermissions(monday)
+	require.NoError(t, err)
+	assert.NotContains(t, perms.Extensions, server.ExtensionDisconnectAt)
+	_, err = user.loginPermissions(monday.Add(9 * time.Hour))
+	assert.EqualError(t, err, `login outside login_hours of "john"`)
+	_, err = user.loginPermissions(monday.AddDate(0, 0, 5))
+	assert.Error(t, err)
+
+	user.DisconnectAfterHours = true
+	perms, err = user.loginPermissions(monday)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01T18:00:00+09:00", perms.Extensions[server.ExtensionDisconnectAt])
+}
+
+// serverConn is ssh.ServerConn of the user with permissions
+func serverConn(user string, permissions *ssh.Permissions) *ssh.ServerConn {
+	return &ssh.ServerConn{Conn: sshConn{user: user}, Permissions: permissions}
+}
+
+type sshConn struct {
+	ssh.Conn
+	user string
+}
+
+func (c sshConn) User() string {
+	return c.user
+}
+
+func TestCredentials(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("mypass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	oneTimeHash, err := bcrypt.GenerateFromPassword([]byte("breakglass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	expired := time.Now().Add(-time.Hour)
+	store := &FileStore{users: map[string]*User{
+		"john": {
+			Name:         "john",
+			PasswordHash: string(hash),
+			Credentials: []Credential{
+				{PasswordHash: string(oneTimeHash), OneTime: true},
+				{AuthorizedKey: string(ssh.MarshalAuthorizedKey(sshPub)), ExpiresAt: &expired},
+			},
+		},
+	}}
+	usedPath := filepath.Join(t.TempDir(), "users.yaml.used.json")
+	authenticator := &Authenticator{Store: store, UsedCredentialsFile: usedPath}
+	require.NoError(t, authenticator.LoadUsedCredentials())
+
+	perms, err := authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	require.NoError(t, err)
+	assert.NoError(t, authenticator.CheckLogin(serverConn("john", perms)))
+	_, err = authenticator.PublicKeyCallback(connMetadata{user: "john"}, sshPub)
+	assert.EqualError(t, err, `expired credential of "john"`)
+
+	// The one-time password can be tried by concurrent connections until one of them logs in
+	perms, err = authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("breakglass"))
+	require.NoError(t, err)
+	otherPerms, err := authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("breakglass"))
+	require.NoError(t, err)
+	assert.NoError(t, authenticator.CheckLogin(serverConn("john", perms)))
+	assert.EqualError(t, authenticator.CheckLogin(serverConn("john", otherPerms)), `used one-time credential of "john"`)
+	_, err = authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("breakglass"))
+	assert.EqualError(t, err, `used one-time credential of "john"`)
+
+	// Used credentials are kept across restarts
+	authenticator = &Authenticator{Store: store, UsedCredentialsFile: usedPath}
+	require.NoError(t, authenticator.LoadUsedCredentials())
+	_, err = authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("breakglass"))
+	assert.Error(t, err)
+	_, err = authenticator.PasswordCallback(connMetadata{user: "john"}, []byte("mypass"))
+	assert.NoError(t, err)
+}
+
+func TestComparePassword(t *testing.T) {
+	params := DefaultArgon2idParams
+	params.Memory = 1024
+	argon2idHash, err := HashArgon2idPassword([]byte("mypass"), params)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(argon2idHash, "$argon2id$v=19$m=1024,t=3,p=4$"))
+	bcryptHash, err := HashBcryptPassword([]byte("mypass"), bcrypt.MinCost)
+	require.NoError(t, err)
+	for _, hash := range []string{argon2idHash, bcryptHash} {
+		assert.NoError(t, validatePasswordHash(hash))
+		assert.NoError(t, ComparePassword(hash, []byte("mypass")))
+		assert.Error(t, ComparePassword(hash, []byte("wrong")))
+	}
+	for _, invalid := range []string{
+		"$argon2id$v=19$m=1024,t=3,p=4$c2FsdA",
+		"$argon2id$v=16$m=1024,t=3,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=4$c2FsdA$a2V5",
+		"$argon2id$v=19$m=1024,t=3,p=4$c2FsdA$",
+	} {
+		assert.Error(t, validatePasswordHash(invalid), invalid)
+	}
+}
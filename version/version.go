@@ -1,3 +1,71 @@
+// Package version provides the version of go-sshd and its build metadata.
 package version
 
-const Version = "0.4.3"
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version, Commit and BuildDate can be set at build time, e.g.
+//
+//	go build -ldflags "-X github.com/John-Ao/go-sshd/version.Commit=$(git rev-parse HEAD) -X github.com/John-Ao/go-sshd/version.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	Version = "0.4.3"
+	// Commit is the git commit. The revision recorded by go build is used if empty.
+	Commit = ""
+	// BuildDate is the time of the build in RFC 3339.
+	BuildDate = ""
+)
+
+// BuildInfo is the version and build metadata.
+type BuildInfo struct {
+	Version   string
+	Commit    string
+	BuildDate string
+	GoVersion string
+	// Platform is "GOOS/GOARCH"
+	Platform string
+}
+
+// Get returns the build metadata. Unknown fields are "unknown".
+func Get() BuildInfo {
+	info := BuildInfo{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if info.Commit == "" {
+		info.Commit = vcsRevision()
+	}
+	if info.Commit == "" {
+		info.Commit = "unknown"
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = "unknown"
+	}
+	return info
+}
+
+// vcsRevision returns the revision recorded by go build with "-dirty" for modified trees
+func vcsRevision() string {
+	buildInfo, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+	var revision string
+	var modified bool
+	for _, setting := range buildInfo.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	return revision
+}
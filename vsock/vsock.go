@@ -0,0 +1,53 @@
+// Package vsock listens on and dials AF_VSOCK sockets to serve SSH between virtual machines and their host.
+package vsock
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// CIDAny is the CID listening on all CIDs
+	CIDAny uint32 = 0xffffffff
+	// CIDHost is the CID of the host
+	CIDHost uint32 = 2
+	// CIDLocal is the CID of the local loopback
+	CIDLocal uint32 = 1
+	// PortAny is the port allocating a free port
+	PortAny uint32 = 0xffffffff
+)
+
+// Addr is a vsock address.
+type Addr struct {
+	CID  uint32
+	Port uint32
+}
+
+func (a *Addr) Network() string {
+	return "vsock"
+}
+
+func (a *Addr) String() string {
+	return fmt.Sprintf("vsock:%d:%d", a.CID, a.Port)
+}
+
+// ParseAddr parses "port" or "cid:port". The CID is CIDAny when omitted.
+func ParseAddr(s string) (*Addr, error) {
+	cid := CIDAny
+	cidStr, portStr, found := strings.Cut(s, ":")
+	if !found {
+		portStr = s
+	} else {
+		c, err := strconv.ParseUint(cidStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid vsock CID: %s", cidStr)
+		}
+		cid = uint32(c)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid vsock port: %s", portStr)
+	}
+	return &Addr{CID: cid, Port: uint32(port)}, nil
+}
@@ -0,0 +1,127 @@
+package vsock
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/sys/unix"
+)
+
+// Listen listens on addr.
+func Listen(addr *Addr) (net.Listener, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrVM{CID: addr.CID, Port: addr.Port}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	if err := unix.Listen(fd, unix.SOMAXCONN); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("listen", err)
+	}
+	localAddr, err := sockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &listener{file: os.NewFile(uintptr(fd), "vsock"), addr: localAddr}, nil
+}
+
+// Dial connects to addr.
+func Dial(addr *Addr) (net.Conn, error) {
+	fd, err := unix.Socket(unix.AF_VSOCK, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Connect(fd, &unix.SockaddrVM{CID: addr.CID, Port: addr.Port}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("connect", err)
+	}
+	if err := unix.SetNonblock(fd, true); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("setnonblock", err)
+	}
+	localAddr, err := sockname(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &conn{File: os.NewFile(uintptr(fd), "vsock"), localAddr: localAddr, remoteAddr: addr}, nil
+}
+
+func sockname(fd int) (*Addr, error) {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return nil, os.NewSyscallError("getsockname", err)
+	}
+	return toAddr(sa), nil
+}
+
+func toAddr(sa unix.Sockaddr) *Addr {
+	if vm, ok := sa.(*unix.SockaddrVM); ok {
+		return &Addr{CID: vm.CID, Port: vm.Port}
+	}
+	return &Addr{}
+}
+
+type listener struct {
+	file   *os.File
+	addr   *Addr
+	closed atomic.Bool
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	rawConn, err := l.file.SyscallConn()
+	if err != nil {
+		return nil, l.closedErr(err)
+	}
+	var nfd int
+	var sa unix.Sockaddr
+	var acceptErr error
+	err = rawConn.Read(func(fd uintptr) bool {
+		nfd, sa, acceptErr = unix.Accept4(int(fd), unix.SOCK_NONBLOCK|unix.SOCK_CLOEXEC)
+		return acceptErr != unix.EAGAIN
+	})
+	if err != nil {
+		return nil, l.closedErr(err)
+	}
+	if acceptErr != nil {
+		return nil, os.NewSyscallError("accept4", acceptErr)
+	}
+	return &conn{File: os.NewFile(uintptr(nfd), "vsock"), localAddr: l.addr, remoteAddr: toAddr(sa)}, nil
+}
+
+// closedErr returns net.ErrClosed after Close so that servers can stop serving
+func (l *listener) closedErr(err error) error {
+	if l.closed.Load() {
+		return net.ErrClosed
+	}
+	return err
+}
+
+func (l *listener) Close() error {
+	l.closed.Store(true)
+	return l.file.Close()
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.addr
+}
+
+// conn is a connected vsock socket. *os.File provides reads, writes and deadlines by the runtime poller.
+type conn struct {
+	*os.File
+	localAddr  *Addr
+	remoteAddr *Addr
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
@@ -0,0 +1,52 @@
+package vsock
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListenAndDial(t *testing.T) {
+	ln, err := Listen(&Addr{CID: CIDAny, Port: PortAny})
+	if err != nil {
+		t.Skipf("vsock unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := Dial(&Addr{CID: CIDLocal, Port: ln.Addr().(*Addr).Port})
+	if err != nil {
+		t.Skipf("vsock loopback unavailable: %v", err)
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestAcceptAfterClose(t *testing.T) {
+	ln, err := Listen(&Addr{CID: CIDAny, Port: PortAny})
+	if err != nil {
+		t.Skipf("vsock unavailable: %v", err)
+	}
+	errCh := make(chan error)
+	go func() {
+		_, err := ln.Accept()
+		errCh <- err
+	}()
+	ln.Close()
+	assert.True(t, errors.Is(<-errCh, net.ErrClosed))
+}
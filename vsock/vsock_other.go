@@ -0,0 +1,18 @@
+//go:build !linux
+
+package vsock
+
+import (
+	"fmt"
+	"net"
+)
+
+// Listen listens on addr.
+func Listen(addr *Addr) (net.Listener, error) {
+	return nil, fmt.Errorf("vsock is not supported on this platform")
+}
+
+// Dial connects to addr.
+func Dial(addr *Addr) (net.Conn, error) {
+	return nil, fmt.Errorf("vsock is not supported on this platform")
+}
@@ -0,0 +1,20 @@
+package vsock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAddr(t *testing.T) {
+	addr, err := ParseAddr("2222")
+	assert.NoError(t, err)
+	assert.Equal(t, &Addr{CID: CIDAny, Port: 2222}, addr)
+	addr, err = ParseAddr("3:22")
+	assert.NoError(t, err)
+	assert.Equal(t, &Addr{CID: 3, Port: 22}, addr)
+	_, err = ParseAddr("host:22")
+	assert.EqualError(t, err, "invalid vsock CID: host")
+	_, err = ParseAddr("")
+	assert.EqualError(t, err, "invalid vsock port: ")
+}
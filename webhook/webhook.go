@@ -0,0 +1,316 @@
+// Package webhook posts notifications of server events to HTTP endpoints with retries and HMAC signatures.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+	"github.com/John-Ao/go-sshd/server/forward"
+
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+// Notification events
+const (
+	// EventLogin is a successful authentication.
+	EventLogin = "login"
+	// EventAuthFailureBurst is Notifier.AuthFailureBurst failed authentications from a host within Notifier.AuthFailureWindow.
+	EventAuthFailureBurst = "auth-failure-burst"
+	// EventRemoteForward is a new remote forwarding listener (ssh -R).
+	EventRemoteForward = "remote-forward"
+	// EventLargeUpload is an SFTP session which received Notifier.LargeUploadBytes or more.
+	EventLargeUpload = "large-upload"
+)
+
+// AllEvents are all notification events.
+var AllEvents = []string{EventLogin, EventAuthFailureBurst, EventRemoteForward, EventLargeUpload}
+
+// Headers of requests
+const (
+	HeaderEvent    = "X-Go-Sshd-Event"
+	HeaderDelivery = "X-Go-Sshd-Delivery"
+	// HeaderSignature is "sha256=" followed by the hex HMAC-SHA256 of the body with Notifier.Secret
+	HeaderSignature = "X-Go-Sshd-Signature"
+)
+
+// Payload is the JSON body of a notification. Fields not related to the event are omitted.
+type Payload struct {
+	Event string    `json:"event"`
+	Time  time.Time `json:"time"`
+	// Server is the name of the server in the config file
+	Server     string `json:"server,omitempty"`
+	ConnID     string `json:"conn_id,omitempty"`
+	User       string `json:"user,omitempty"`
+	RemoteAddr string `json:"remote_address,omitempty"`
+	// Method is the authentication method of EventLogin
+	Method string `json:"method,omitempty"`
+	// Failures is the number of failed authentications of EventAuthFailureBurst
+	Failures int `json:"failures,omitempty"`
+	// ForwardType, Host, Port and Path are of EventRemoteForward
+	ForwardType string `json:"forward_type,omitempty"`
+	Host        string `json:"host,omitempty"`
+	Port        int    `json:"port,omitempty"`
+	Path        string `json:"path,omitempty"`
+	// BytesReceived is of EventLargeUpload
+	BytesReceived uint64 `json:"bytes_received,omitempty"`
+}
+
+// Notifier posts notifications of events passed to Notify. Zero fields have defaults.
+type Notifier struct {
+	URLs []string
+	// Secret signs requests in HeaderSignature if not empty
+	Secret []byte
+	// Events are the notification events to post. All events are posted if empty.
+	Events []string
+	// AuthFailureBurst is the number of failures for EventAuthFailureBurst (default: 5)
+	AuthFailureBurst int
+	// AuthFailureWindow is the time window for EventAuthFailureBurst (default: 1m)
+	AuthFailureWindow time.Duration
+	// LargeUploadBytes is the bytes for EventLargeUpload (default: 100 MiB)
+	LargeUploadBytes uint64
+	// MaxRetries is the number of retries on network errors, 429 and 5xx responses (default: 3)
+	MaxRetries int
+	// RetryInterval is the interval before the first retry, doubled for each retry (default: 1s)
+	RetryInterval time.Duration
+	// QueueSize is the number of notifications waiting to be posted, beyond which they are dropped (default: 1024)
+	QueueSize int
+	Client    *http.Client
+	Logger    *slog.Logger
+
+	startOnce sync.Once
+	queue     chan delivery
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	// failures are the failed authentications by host
+	failures map[string]*failureWindow
+}
+
+type delivery struct {
+	url     string
+	event   string
+	id      string
+	payload []byte
+}
+
+type failureWindow struct {
+	start time.Time
+	count int
+}
+
+func (n *Notifier) start() {
+	n.startOnce.Do(func() {
+		queueSize := n.QueueSize
+		if queueSize == 0 {
+			queueSize = 1024
+		}
+		n.queue = make(chan delivery, queueSize)
+		n.done = make(chan struct{})
+		n.failures = map[string]*failureWindow{}
+		go n.run()
+	})
+}
+
+// Notify posts the notification of event of the server named serverName if any. It doesn't block.
+func (n *Notifier) Notify(serverName string, event server.Event) {
+	n.start()
+	payload, ok := n.payload(event)
+	if !ok || !n.enabled(payload.Event) {
+		return
+	}
+	payload.Server = serverName
+	body, err := json.Marshal(payload)
+	if err != nil {
+		n.logger().Error("failed to marshal webhook payload", "err", err)
+		return
+	}
+	id := uuid.New().String()
+	for _, url := range n.URLs {
+		select {
+		case n.queue <- delivery{url: url, event: payload.Event, id: id, payload: body}:
+		default:
+			n.logger().Warn("webhook dropped because the queue is full", "event", payload.Event, "url", url)
+		}
+	}
+}
+
+// payload returns the notification of event. It returns false if event is not notified.
+func (n *Notifier) payload(event server.Event) (Payload, bool) {
+	payload := Payload{Time: event.Time, ConnID: event.ConnID, User: event.User, RemoteAddr: event.RemoteAddr}
+	switch event.Type {
+	case server.EventAuth:
+		if event.Err == "" {
+			payload.Event = EventLogin
+			payload.Method = event.Method
+			return payload, true
+		}
+		failures, ok := n.authFailed(event)
+		if !ok {
+			return Payload{}, false
+		}
+		payload.Event = EventAuthFailureBurst
+		payload.Failures = failures
+		return payload, true
+	case server.EventForwardStarted:
+		if event.ForwardType != forward.TypeTcpipForward && event.ForwardType != forward.TypeStreamlocalForward {
+			return Payload{}, false
+		}
+		payload.Event = EventRemoteForward
+		payload.ForwardType = event.ForwardType
+		payload.Host = event.Host
+		payload.Port = event.Port
+		payload.Path = event.Path
+		return payload, true
+	case server.EventTransfer:
+		largeUploadBytes := n.LargeUploadBytes
+		if largeUploadBytes == 0 {
+			largeUploadBytes = 100 * 1024 * 1024
+		}
+		if event.BytesReceived < largeUploadBytes {
+			return Payload{}, false
+		}
+		payload.Event = EventLargeUpload
+		payload.BytesReceived = event.BytesReceived
+		return payload, true
+	}
+	return Payload{}, false
+}
+
+// authFailed counts the failure of event by host and returns true when the failures reach AuthFailureBurst in the window
+func (n *Notifier) authFailed(event server.Event) (int, bool) {
+	burst := n.AuthFailureBurst
+	if burst == 0 {
+		burst = 5
+	}
+	window := n.AuthFailureWindow
+	if window == 0 {
+		window = time.Minute
+	}
+	host, _, err := net.SplitHostPort(event.RemoteAddr)
+	if err != nil {
+		host = event.RemoteAddr
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// Expired windows are removed not to grow by many hosts
+	if len(n.failures) >= 1024 {
+		for h, w := range n.failures {
+			if event.Time.Sub(w.start) > window {
+				delete(n.failures, h)
+			}
+		}
+	}
+	w, ok := n.failures[host]
+	if !ok || event.Time.Sub(w.start) > window {
+		w = &failureWindow{start: event.Time}
+		n.failures[host] = w
+	}
+	w.count++
+	// Notified once per window
+	return w.count, w.count == burst
+}
+
+func (n *Notifier) enabled(event string) bool {
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, e := range n.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Close stops accepting notifications and waits for queued ones to be posted.
+func (n *Notifier) Close() {
+	n.start()
+	n.closeOnce.Do(func() {
+		close(n.queue)
+		<-n.done
+	})
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for d := range n.queue {
+		n.deliver(d)
+	}
+}
+
+// deliver posts d with retries
+func (n *Notifier) deliver(d delivery) {
+	maxRetries := n.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 3
+	}
+	interval := n.RetryInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(d)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= maxRetries {
+			n.logger().Error("failed to post webhook", "event", d.event, "url", d.url, "attempts", attempt+1, "err", err)
+			return
+		}
+		time.Sleep(interval << attempt)
+	}
+}
+
+// post posts d once and returns whether to retry on errors
+func (n *Notifier) post(d delivery) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, d.url, bytes.NewReader(d.payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.event)
+	req.Header.Set(HeaderDelivery, d.id)
+	if len(n.Secret) != 0 {
+		req.Header.Set(HeaderSignature, Sign(n.Secret, d.payload))
+	}
+	client := n.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	if res.StatusCode/100 == 2 {
+		return false, nil
+	}
+	retry := res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500
+	return retry, fmt.Errorf("unexpected status: %s", res.Status)
+}
+
+func (n *Notifier) logger() *slog.Logger {
+	if n.Logger == nil {
+		return slog.Default()
+	}
+	return n.Logger
+}
+
+// Sign returns the value of HeaderSignature of body.
+func Sign(secret, body []byte) string {
+	h := hmac.New(sha256.New, secret)
+	h.Write(body)
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/John-Ao/go-sshd/server"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type request struct {
+	header  http.Header
+	payload Payload
+	body    []byte
+}
+
+// newReceiver returns a server recording requests, responding statuses in order and then 204
+func newReceiver(t *testing.T, statuses ...int) (*httptest.Server, func() []request) {
+	var mu sync.Mutex
+	var requests []request
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var payload Payload
+		assert.NoError(t, json.Unmarshal(body, &payload))
+		mu.Lock()
+		requests = append(requests, request{header: r.Header, payload: payload, body: body})
+		status := http.StatusNoContent
+		if len(statuses) != 0 {
+			status = statuses[0]
+			statuses = statuses[1:]
+		}
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]request(nil), requests...)
+	}
+}
+
+func TestNotifier(t *testing.T) {
+	receiver, requests := newReceiver(t)
+	n := &Notifier{URLs: []string{receiver.URL}, Secret: []byte("secret"), LargeUploadBytes: 1000}
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	for _, event := range []server.Event{
+		{Type: server.EventAuth, Time: now, ConnID: "c1", User: "john", RemoteAddr: "192.0.2.1:50000", Method: "publickey"},
+		{Type: server.EventSessionStarted, Time: now, ConnID: "c1", User: "john"},
+		{Type: server.EventForwardStarted, Time: now, ConnID: "c1", User: "john", ForwardType: "direct-tcpip", Host: "example.com", Port: 80},
+		{Type: server.EventForwardStarted, Time: now, ConnID: "c1", User: "john", ForwardType: "tcpip-forward", Host: "127.0.0.1", Port: 8080},
+		{Type: server.EventTransfer, Time: now, ConnID: "c1", User: "john", BytesReceived: 999},
+		{Type: server.EventTransfer, Time: now, ConnID: "c1", User: "john", BytesReceived: 1000, BytesSent: 10},
+	} {
+		n.Notify("main", event)
+	}
+	n.Close()
+
+	got := requests()
+	require.Len(t, got, 3)
+	assert.Equal(t, Payload{Event: EventLogin, Time: now, Server: "main", ConnID: "c1", User: "john", RemoteAddr: "192.0.2.1:50000", Method: "publickey"}, got[0].payload)
+	assert.Equal(t, Payload{Event: EventRemoteForward, Time: now, Server: "main", ConnID: "c1", User: "john", ForwardType: "tcpip-forward", Host: "127.0.0.1", Port: 8080}, got[1].payload)
+	assert.Equal(t, Payload{Event: EventLargeUpload, Time: now, Server: "main", ConnID: "c1", User: "john", BytesReceived: 1000}, got[2].payload)
+	for _, r := range got {
+		assert.Equal(t, "application/json", r.header.Get("Content-Type"))
+		assert.Equal(t, r.payload.Event, r.header.Get(HeaderEvent))
+		assert.NotEmpty(t, r.header.Get(HeaderDelivery))
+		assert.Equal(t, Sign([]byte("secret"), r.body), r.header.Get(HeaderSignature))
+	}
+}
+
+func TestNotifierAuthFailureBurst(t *testing.T) {
+	receiver, requests := newReceiver(t)
+	n := &Notifier{URLs: []string{receiver.URL}, Events: []string{EventAuthFailureBurst}, AuthFailureBurst: 3, AuthFailureWindow: time.Minute}
+	start := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	fail := func(remoteAddr string, after time.Duration) {
+		n.Notify("", server.Event{Type: server.EventAuth, Time: start.Add(after), User: "root", RemoteAddr: remoteAddr, Method: "password", Err: "denied"})
+	}
+	// Once per window
+	for i := 0; i < 5; i++ {
+		fail("192.0.2.1:50000", time.Duration(i)*time.Second)
+	}
+	// Counted by host
+	fail("192.0.2.2:50000", 0)
+	fail("192.0.2.2:50001", time.Second)
+	// A new window
+	for i := 0; i < 3; i++ {
+		fail("192.0.2.1:50000", 2*time.Minute+time.Duration(i)*time.Second)
+	}
+	// Not enabled
+	n.Notify("", server.Event{Type: server.EventAuth, Time: start, User: "root", RemoteAddr: "192.0.2.1:50000", Method: "password"})
+	n.Close()
+
+	got := requests()
+	require.Len(t, got, 2)
+	assert.Equal(t, Payload{Event: EventAuthFailureBurst, Time: start.Add(2 * time.Second), User: "root", RemoteAddr: "192.0.2.1:50000", Failures: 3}, got[0].payload)
+	assert.Equal(t, start.Add(2*time.Minute+2*time.Second), got[1].payload.Time)
+	assert.Empty(t, got[0].header.Get(HeaderSignature))
+}
+
+func TestNotifierRetry(t *testing.T) {
+	receiver, requests := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	n := &Notifier{URLs: []string{receiver.URL}, RetryInterval: time.Millisecond}
+	n.Notify("", server.Event{Type: server.EventAuth, User: "john"})
+	n.Close()
+	got := requests()
+	require.Len(t, got, 3)
+	assert.Equal(t, got[0].header.Get(HeaderDelivery), got[2].header.Get(HeaderDelivery))
+
+	// Client errors are not retried
+	receiver, requests = newReceiver(t, http.StatusBadRequest)
+	n = &Notifier{URLs: []string{receiver.URL}, RetryInterval: time.Millisecond}
+	n.Notify("", server.Event{Type: server.EventAuth, User: "john"})
+	n.Close()
+	assert.Len(t, requests(), 1)
+
+	// Gives up after MaxRetries
+	receiver, requests = newReceiver(t, 500, 500, 500, 500)
+	n = &Notifier{URLs: []string{receiver.URL}, MaxRetries: 2, RetryInterval: time.Millisecond}
+	n.Notify("", server.Event{Type: server.EventAuth, User: "john"})
+	n.Close()
+	assert.Len(t, requests(), 3)
+}